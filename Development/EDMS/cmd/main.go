@@ -1,51 +1,683 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/app"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/logging"
+
+	_ "time/tzdata" // Site time zones do not depend on the zoneinfo files of the host
 )
 
+// startupTimeout is how long the server waits for the database before giving up
+const startupTimeout = 2 * time.Minute
+
+// drainDelay is how long /readyz reports the server as down before it stops accepting connections
+const drainDelay = 5 * time.Second
+
+const usage = `Usage: edms <command> [arguments]
+
+Commands:
+  serve [-config FILE] [-port P] ...     Migrate, seed and start the web server (the default),
+                                         run "edms serve -h" for the settings that can be flags
+  migrate up                             Apply the pending schema migrations
+  migrate down [-steps N]                Roll back the last N migrations (default 1)
+  migrate status                         List the migrations and whether they are applied
+  seed [-profile demo|minimal]           Load seed data into an unseeded database (default demo)
+  user create -username U -email E [-role Admin|User] [-password P]
+  user reset-password -username U [-password P]
+  user set-role -username U -role Admin|User
+  org list                               List the organisations
+  org create -name N                     Add an organisation
+  org add-user -username U -org ID       Make a user a member of an organisation
+  org remove-user -username U -org ID    End a user's membership of an organisation
+  import devices FILE                    Add the devices in a CSV file, - reads standard input
+  export report [-site ID] [-building CODE] [-o FILE]
+                                         Write the in-service devices as CSV
+  recompute statuses                     Update device statuses from their expiry and inspection dates
+  rounds generate [-month YYYY-MM] [-username U]
+                                         Plan the inspection rounds of every building for a month
+                                         (default next month), optionally assigned to an inspector
+  webhooks receive -secret S [-port P]    Print the webhook deliveries sent to this machine, checking
+                                         their signatures, to test webhooks without a real receiver,
+                                         with the server started with WEBHOOKS_ALLOW_LOCAL=true
+  healthcheck [-path /readyz|/healthz]   Exit with an error unless the local server is ready
+
+Passwords that are not given with -password are read from the first line of standard input.
+Every command reads the configuration from config.yaml, or the file given with -config, then from the
+environment and the .env file, the environment overriding the file.
+`
+
 func main() {
+	command := "serve"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "serve":
+		err = serve(args)
+	case "migrate":
+		err = runMigrate(args)
+	case "seed":
+		err = runSeed(args)
+	case "user":
+		err = runUser(args)
+	case "org":
+		err = runOrganisation(args)
+	case "import":
+		err = runImport(args)
+	case "export":
+		err = runExport(args)
+	case "recompute":
+		err = runRecompute(args)
+	case "rounds":
+		err = runRounds(args)
+	case "webhooks":
+		err = runWebhooks(args)
+	case "healthcheck":
+		err = runHealthcheck(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s: %v", command, err)
+	}
+}
+
+// loadConfig loads the configuration with the command's flags and sends all logging, including the
+// standard log package, through a structured logger on standard error at the configured level
+func loadConfig(flags *flag.FlagSet) (config.Config, error) {
+	cfg, err := config.Load(flags)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid configuration:\n%v", err)
+	}
+
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return cfg, err
+	}
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, level)
+	if err != nil {
+		return cfg, err
+	}
+	slog.SetDefault(logger)
+
+	return cfg, nil
+}
+
+// serve runs the web server until it is interrupted
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	config.AddFlags(flags)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
 	// Load the configuration
-	cfg := config.LoadConfig()
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		return err
+	}
 
-	// Initialize the app
-	application := app.NewApp(cfg)
+	// Stop waiting for the database if the server is interrupted while starting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	startupCtx, cancelStartup := context.WithTimeout(ctx, startupTimeout)
+	defer cancelStartup()
 
-	// Get PORT from environment, default to 8080 if not set
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Initialize the app
+	application, err := app.NewApp(startupCtx, cfg)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Starting HTTP service on port %s", port)
+	slog.Info("Starting HTTP service", "port", cfg.Port)
 
-	// HTTP listener is in a goroutine as it's blocking
+	// HTTP listener is in a goroutine as it's blocking, an error stops the server like an interrupt
+	listenErr := make(chan error, 1)
 	go func() {
-		if err := application.Router.Start(":" + port); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting the server: %v", err)
+		if err := application.Router.Start(":" + cfg.Port); err != nil && err != http.ErrServerClosed {
+			listenErr <- err
 		}
 	}()
 
-	// Setup a ctrl-c trap to ensure a graceful shutdown
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Send the webhook outbox until the server is stopped
+	go application.Webhooks.Run(ctx, app.WebhookPollInterval)
+
+	// Bring device statuses up to date now and every hour, queueing their webhook events
+	go application.Statuses.Run(ctx, app.StatusSweepInterval)
+
+	// Wait for ctrl-c, the container being stopped or the listener failing to shut down gracefully
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-listenErr:
+		slog.Error("HTTP service failed", "error", serveErr)
+		stop()
+	}
+
+	// Report not ready first so load balancers stop sending requests before the listener closes.
+	// There is nothing to drain when the listener failed.
+	if serveErr == nil {
+		slog.Info("Draining, readiness reported as down", "delay", drainDelay)
+		application.StartDraining()
+		time.Sleep(drainDelay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Log the shutdown process
-	log.Println("Shutting HTTP service down")
-	if err := application.Router.Shutdown(ctx); err != nil {
-		log.Fatalf("Server Shutdown Failed: %v", err)
+	slog.Info("Shutting HTTP service down")
+	if err := application.Router.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("server shutdown failed: %v", err)
+	}
+
+	if serveErr != nil {
+		return fmt.Errorf("error starting the server: %v", serveErr)
+	}
+
+	slog.Info("Shutdown complete")
+	return nil
+}
+
+// runHealthcheck asks the local server whether it is ready, for container health checks where there is no curl
+func runHealthcheck(args []string) error {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	path := flags.String("path", "/readyz", "probe to request, /readyz or /healthz")
+	config.AddFileFlag(flags)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	// The port comes from the same configuration as the server's
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://localhost:" + cfg.Port + *path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s returned %s: %s", *path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// openDB loads the configuration with the command's flags and connects to the database
+func openDB(flags *flag.FlagSet) (*database.DB, config.Config, error) {
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		return nil, cfg, err
+	}
+
+	db, err := database.NewDB(cfg)
+	return db, cfg, err
+}
+
+// subcommand splits the action off the arguments of a command that has actions, like "migrate up"
+func subcommand(args []string, actions ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("expected one of %s", strings.Join(actions, ", "))
+	}
+	for _, action := range actions {
+		if args[0] == action {
+			return action, args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown action %q, expected one of %s", args[0], strings.Join(actions, ", "))
+}
+
+// parseFlags parses the flags of a command, rejecting leftover arguments unless positional is set
+func parseFlags(flags *flag.FlagSet, args []string, positional int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != positional {
+		return nil, fmt.Errorf("expected %d arguments, got %d", positional, flags.NArg())
+	}
+	return flags.Args(), nil
+}
+
+func runMigrate(args []string) error {
+	action, args, err := subcommand(args, "up", "down", "status")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	steps := flags.Int("steps", 1, "number of migrations to roll back")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	db, _, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch action {
+	case "up":
+		return database.MigrateUp(db.DB)
+	case "down":
+		return database.MigrateDown(db.DB, *steps)
+	default:
+		statuses, err := database.GetMigrationStatus(db.DB)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT\tNOTE")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt.Valid {
+				appliedAt = status.AppliedAt.Time.Local().Format("2006-01-02 15:04:05")
+			}
+			note := ""
+			if status.ChecksumMismatch {
+				note = "changed since it was applied"
+			}
+			if status.Unknown {
+				note = "not part of this build"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, status.Name, appliedAt, note)
+		}
+		return w.Flush()
+	}
+}
+
+func runSeed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	profile := flags.String("profile", database.SeedProfileDemo, "seed profile, demo or minimal")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	db, cfg, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := database.MigrateUp(db.DB); err != nil {
+		return err
+	}
+
+	return database.SeedDatabase(db.DB, *profile, cfg.AdminPassword)
+}
+
+// readPassword returns the flag value, or the first line of standard input when the flag was not given
+func readPassword(value string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	fmt.Fprintln(os.Stderr)
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runUser(args []string) error {
+	action, args, err := subcommand(args, "create", "reset-password", "set-role")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	username := flags.String("username", "", "username of the account")
+	email := flags.String("email", "", "email address of a new account")
+	role := flags.String("role", "", "Admin or User")
+	password := flags.String("password", "", "password, read from standard input if not given")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *username == "" {
+		return fmt.Errorf("-username is required")
+	}
+
+	db, _, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch action {
+	case "create":
+		if *role == "" {
+			*role = "User"
+		}
+		newPassword, err := readPassword(*password)
+		if err != nil {
+			return err
+		}
+		user, err := app.CreateUser(db, *username, *email, newPassword, *role)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created %s user %s with ID %d", user.Role, user.Username, user.UserID)
+	case "reset-password":
+		newPassword, err := readPassword(*password)
+		if err != nil {
+			return err
+		}
+		if err := app.ResetPassword(db, *username, newPassword); err != nil {
+			return err
+		}
+		log.Printf("Password of %s has been reset", *username)
+	default:
+		if err := app.SetUserRole(db, *username, *role); err != nil {
+			return err
+		}
+		log.Printf("%s now has the %s role", *username, *role)
+	}
+
+	return nil
+}
+
+func runOrganisation(args []string) error {
+	action, args, err := subcommand(args, "list", "create", "add-user", "remove-user")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("org "+action, flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	name := flags.String("name", "", "name of a new organisation")
+	username := flags.String("username", "", "username of the member")
+	organisationID := flags.Int("org", 0, "ID of the organisation")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	db, _, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch action {
+	case "list":
+		organisations, err := db.GetAllOrganisations()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCREATED AT")
+		for _, organisation := range organisations {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", organisation.OrganisationID, organisation.Name,
+				organisation.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	case "create":
+		if *name == "" {
+			return fmt.Errorf("-name is required")
+		}
+		id, err := db.AddOrganisation(*name)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created organisation %s with ID %d", *name, id)
+	default:
+		if *username == "" || *organisationID == 0 {
+			return fmt.Errorf("-username and -org are required")
+		}
+		user, err := db.GetUserByUsername(*username)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", *username, err)
+		}
+		if _, err := db.GetOrganisationByID(*organisationID); err != nil {
+			return fmt.Errorf("organisation %d: %w", *organisationID, err)
+		}
+
+		if action == "add-user" {
+			if err := db.AddUserToOrganisation(user.UserID, *organisationID); err != nil {
+				return err
+			}
+			log.Printf("%s is now a member of organisation %d", *username, *organisationID)
+		} else {
+			if err := db.RemoveUserFromOrganisation(user.UserID, *organisationID); err != nil {
+				return fmt.Errorf("%s is not a member of organisation %d: %w", *username, *organisationID, err)
+			}
+			log.Printf("%s is no longer a member of organisation %d", *username, *organisationID)
+		}
+	}
+
+	return nil
+}
+
+func runImport(args []string) error {
+	_, args, err := subcommand(args, "devices")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("import devices", flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	files, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if files[0] != "-" {
+		file, err := os.Open(files[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	db, _, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := app.ImportDevicesCSV(db, input)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d devices", count)
+	return nil
+}
+
+func runExport(args []string) error {
+	_, args, err := subcommand(args, "report")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("export report", flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	siteID := flags.String("site", "", "only devices at the site with this ID")
+	buildingCode := flags.String("building", "", "only devices in buildings with this code")
+	output := flags.String("o", "", "file to write, standard output if not given")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
 	}
 
-	log.Println("Shutdown complete")
+	db, _, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := app.WriteDeviceReport(db, w, *siteID, *buildingCode)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d devices", count)
+	return nil
+}
+
+func runRecompute(args []string) error {
+	_, args, err := subcommand(args, "statuses")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("recompute statuses", flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	db, _, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	changed, err := app.RecomputeDeviceStatuses(db, time.Now())
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Updated the status of %d devices", changed)
+	return nil
+}
+
+func runRounds(args []string) error {
+	_, args, err := subcommand(args, "generate")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("rounds generate", flag.ContinueOnError)
+	config.AddFileFlag(flags)
+	month := flags.String("month", "", "month to plan as YYYY-MM, next month if not given")
+	username := flags.String("username", "", "inspector to assign the rounds to, unassigned if not given")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	db, cfg, err := openDB(flags)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now().In(cfg.Location())
+	start := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	if *month != "" {
+		if start, err = time.Parse("2006-01", *month); err != nil {
+			return fmt.Errorf("-month must be YYYY-MM: %v", err)
+		}
+	}
+
+	userID := 0
+	if *username != "" {
+		user, err := db.GetUserByUsername(*username)
+		if err != nil {
+			return fmt.Errorf("user %s: %v", *username, err)
+		}
+		userID = user.UserID
+	}
+
+	added, err := app.GenerateInspectionRounds(db, start, userID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Planned %d inspection rounds for %s", added, start.Format("January 2006"))
+	return nil
+}
+
+// runWebhooks runs a local receiver that prints the webhook deliveries it is sent, for testing webhooks
+func runWebhooks(args []string) error {
+	_, args, err := subcommand(args, "receive")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("webhooks receive", flag.ContinueOnError)
+	port := flags.String("port", "9000", "port to listen on, add the webhook as http://localhost:PORT/")
+	secret := flags.String("secret", "", "secret returned when the webhook was added")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if *secret == "" {
+		return errors.New("-secret is required")
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := app.VerifyWebhookSignature(*secret, r.Header.Get("X-EDMS-Signature"), body, time.Now()); err != nil {
+			log.Printf("Rejected %s delivery: %v", r.Header.Get("X-EDMS-Event"), err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, body, "", "  "); err != nil {
+			pretty.Write(body)
+		}
+		fmt.Printf("%s %s\n%s\n\n", r.Header.Get("X-EDMS-Event"), r.Header.Get("X-EDMS-Event-ID"), pretty.String())
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("Receiving webhooks on http://localhost:%s/", *port)
+	server := &http.Server{Addr: ":" + *port, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	return server.ListenAndServe()
 }
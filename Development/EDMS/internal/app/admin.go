@@ -0,0 +1,473 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The functions in this file back the administration subcommands of the edms binary.
+// They work on a database.Store and apply the same rules as the web handlers.
+
+// ErrUserNotFound is returned when an administration command names a user that does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// DeviceImportColumns are the columns of a device import CSV file, in the order they are exported.
+// The header row is required, columns may be in any order and only site, building, room and device_type must be filled in.
+var DeviceImportColumns = []string{"site", "building", "room", "device_type", "extinguisher_type", "serial_number", "manufacture_date", "description", "size", "status"}
+
+// validatePassword checks the password policy used by registration and the admin user form
+func validatePassword(password string) error {
+	passwordLengthRegex := regexp.MustCompile(`.{8,}`)
+	passwordDigitRegex := regexp.MustCompile(`[0-9]`)
+	passwordSpecialCharRegex := regexp.MustCompile(`[!@#$%^&*]`)
+	passwordCapitalLetterRegex := regexp.MustCompile(`[A-Z]`)
+
+	if !passwordLengthRegex.MatchString(password) || !passwordDigitRegex.MatchString(password) || !passwordSpecialCharRegex.MatchString(password) || !passwordCapitalLetterRegex.MatchString(password) {
+		return errors.New("password must contain at least one number, one special character, one capital letter, and be at least 8 characters long")
+	}
+
+	return nil
+}
+
+// validateRole checks the role is one the application knows
+func validateRole(role string) error {
+	if role != "Admin" && role != "User" {
+		return fmt.Errorf("role must be Admin or User, not %q", role)
+	}
+	return nil
+}
+
+// CreateUser adds a user with the given role, validating it like the registration form
+func CreateUser(store database.Store, username, email, password, role string) (*models.User, error) {
+	if !regexp.MustCompile(`^[a-zA-Z0-9_]{6,}$`).MatchString(username) {
+		return nil, errors.New("username must be at least 6 characters and contain only letters, numbers, and underscores")
+	}
+
+	if !regexp.MustCompile(`[^@\s]+@[^@\s]+\.[^@\s]+`).MatchString(email) {
+		return nil, errors.New("invalid email address")
+	}
+
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	if err := validateRole(role); err != nil {
+		return nil, err
+	}
+
+	if _, err := store.GetUserByUsername(username); err == nil {
+		return nil, errors.New("username already exists")
+	}
+
+	if _, err := store.GetUserByEmail(email); err == nil {
+		return nil, errors.New("email already exists")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash password: %v", err)
+	}
+
+	if err := store.CreateUser(&models.User{Username: username, Email: email, Password: string(hashedPassword)}); err != nil {
+		return nil, err
+	}
+
+	user, err := store.GetUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+
+	// New users are created with the User role
+	if role != user.Role {
+		user.Role = role
+		if err := store.UpdateUser(user); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// getUserForAdmin looks a user up by username for an administration command
+func getUserForAdmin(store database.Store, username string) (*models.User, error) {
+	user, err := store.GetUserByUsername(username)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	return user, err
+}
+
+// ResetPassword sets a new password for a user, this is how a locked out default admin gets back in
+func ResetPassword(store database.Store, username, password string) error {
+	user, err := getUserForAdmin(store, username)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("could not hash password: %v", err)
+	}
+
+	return store.UpdatePassword(user.UserID, string(hashedPassword))
+}
+
+// SetUserRole changes the role of a user, the default admin always keeps the Admin role
+func SetUserRole(store database.Store, username, role string) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+
+	user, err := getUserForAdmin(store, username)
+	if err != nil {
+		return err
+	}
+
+	if user.DefaultAdmin && role != "Admin" {
+		return errors.New("cannot change role of the default admin account")
+	}
+
+	user.Role = role
+	return store.UpdateUser(user)
+}
+
+// ImportDevicesCSV adds the devices in a CSV file with a DeviceImportColumns header.
+// Every row is checked before anything is added, so a file with an error imports nothing.
+func ImportDevicesCSV(store database.Store, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, errors.New("the file is empty")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"site", "building", "room", "device_type"} {
+		if _, ok := columns[required]; !ok {
+			return 0, fmt.Errorf("the header is missing the %s column", required)
+		}
+	}
+
+	extinguisherTypes, err := store.GetAllExtinguisherTypes()
+	if err != nil {
+		return 0, err
+	}
+
+	var devices []*models.EmergencyDevice
+	var rowErrors []error
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			return 0, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		device, err := importDevice(store, extinguisherTypes, field)
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Errorf("line %d: %v", line, err))
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	if len(rowErrors) > 0 {
+		return 0, errors.Join(rowErrors...)
+	}
+
+	// The devices are added with their webhook events, all of them or none
+	err = store.WithTx(func(tx database.Store) error {
+		for i, device := range devices {
+			if err := tx.AddEmergencyDevice(device); err != nil {
+				return fmt.Errorf("adding device %d of %d: %v", i+1, len(devices), err)
+			}
+			if err := publishDeviceCreated(tx, device.EmergencyDeviceID); err != nil {
+				return fmt.Errorf("queueing webhook events of device %d of %d: %v", i+1, len(devices), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(devices), nil
+}
+
+// importDevice resolves the names in an import row to IDs and validates it like the device form
+func importDevice(store database.Store, extinguisherTypes []models.ExtinguisherType, field func(name string) string) (*models.EmergencyDevice, error) {
+	if field("site") == "" || field("building") == "" || field("room") == "" || field("device_type") == "" {
+		return nil, errors.New("site, building, room and device_type are required")
+	}
+
+	site, err := store.GetSiteByName(field("site"))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("site %q does not exist", field("site"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	building, err := store.GetBuildingByCodeandSite(field("building"), site.SiteID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("building %q does not exist at %s", field("building"), site.SiteName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	room, err := store.GetRoomByCodeAndBuilding(field("room"), building.BuildingID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("room %q does not exist in building %s", field("room"), building.BuildingCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deviceType, err := store.GetDeviceTypeByName(field("device_type"))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("device type %q does not exist", field("device_type"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	extinguisherTypeID := ""
+	if name := field("extinguisher_type"); name != "" {
+		for _, extinguisherType := range extinguisherTypes {
+			if strings.EqualFold(extinguisherType.ExtinguisherTypeName, name) {
+				extinguisherTypeID = strconv.Itoa(extinguisherType.ExtinguisherTypeID)
+			}
+		}
+		if extinguisherTypeID == "" {
+			return nil, fmt.Errorf("extinguisher type %q does not exist", name)
+		}
+	}
+
+	return validateDevice(strconv.Itoa(room.RoomID), strconv.Itoa(deviceType.EmergencyDeviceTypeID), extinguisherTypeID,
+		field("serial_number"), field("manufacture_date"), field("size"), field("description"), field("status"))
+}
+
+// WriteDeviceReport writes the in-service devices as CSV, filtered like the dashboard device list.
+// The first columns match DeviceImportColumns so a report can be edited and imported into another install.
+func WriteDeviceReport(store database.Store, w io.Writer, siteID string, buildingCode string) (int, error) {
+	devices, err := store.GetAllDevices(siteID, buildingCode, "")
+	if err != nil {
+		return 0, err
+	}
+
+	writer := csv.NewWriter(w)
+
+	header := append(append([]string(nil), DeviceImportColumns...), "emergency_device_id", "expire_date", "last_inspection_date", "next_inspection_date", "last_service_date", "next_service_date")
+	if err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	nullString := func(value sql.NullString) string {
+		if !value.Valid {
+			return ""
+		}
+		return value.String
+	}
+	nullDate := func(value sql.NullTime) string {
+		if !value.Valid {
+			return ""
+		}
+		return value.Time.Format("2006-01-02")
+	}
+
+	for _, device := range devices {
+		err := writer.Write([]string{
+			device.SiteName,
+			device.BuildingCode,
+			device.RoomCode,
+			device.EmergencyDeviceTypeName,
+			nullString(device.ExtinguisherTypeName),
+			nullString(device.SerialNumber),
+			nullDate(device.ManufactureDate),
+			nullString(device.Description),
+			nullString(device.Size),
+			nullString(device.Status),
+			strconv.Itoa(device.EmergencyDeviceID),
+			nullDate(device.ExpireDate),
+			nullDate(device.LastInspectionDateTime),
+			nullDate(device.NextInspectionDate),
+			nullDate(device.LastServiceDate),
+			nullDate(device.NextServiceDate),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	writer.Flush()
+	return len(devices), writer.Error()
+}
+
+// recomputedStatus is the status a device should have on the given day. Statuses only move forward:
+// an expired fire extinguisher becomes Expired and an Active device that is overdue becomes Inspection Due,
+// anything else waits for the next inspection.
+func recomputedStatus(device models.EmergencyDevice, now time.Time) sql.NullString {
+	switch {
+	case device.ExpireDate.Valid && !device.ExpireDate.Time.After(now):
+		return sql.NullString{String: "Expired", Valid: true}
+	case device.Status.Valid && device.Status.String == "Active" && device.NextInspectionDate.Valid && !device.NextInspectionDate.Time.After(now):
+		return sql.NullString{String: "Inspection Due", Valid: true}
+	default:
+		return device.Status
+	}
+}
+
+// RecomputeDeviceStatuses brings the status of every in-service device up to date with its
+// expiry and inspection dates and returns how many devices changed
+func RecomputeDeviceStatuses(store database.Store, now time.Time) (int, error) {
+	devices, err := store.GetAllDevices("", "", "")
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, device := range devices {
+		status := recomputedStatus(device, now)
+		if status == device.Status {
+			continue
+		}
+		// Each status change is saved with its webhook event
+		err := store.WithTx(func(tx database.Store) error {
+			if err := tx.UpdateDeviceStatus(device.EmergencyDeviceID, status.String); err != nil {
+				return fmt.Errorf("updating device %d: %v", device.EmergencyDeviceID, err)
+			}
+			if err := publishStatusChange(tx, device.EmergencyDeviceID, device.Status); err != nil {
+				return fmt.Errorf("queueing webhook events of device %d: %v", device.EmergencyDeviceID, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
+// ErrNothingDue is returned when an inspection round would have no devices
+var ErrNothingDue = errors.New("no devices are due for inspection in this period")
+
+// dueBy reports whether a listed device needs inspecting by the last day of a period,
+// devices that have never been inspected are always due
+func dueBy(device models.EmergencyDevice, periodEnd time.Time) bool {
+	if !device.NextInspectionDate.Valid {
+		return true
+	}
+	year, month, day := device.NextInspectionDate.Time.Date()
+	return !time.Date(year, month, day, 0, 0, 0, 0, time.UTC).After(periodEnd)
+}
+
+// PlanInspectionRound adds a round of the in-service devices of a site, or one of its buildings,
+// whose next inspection falls before the end of the round's period, overdue devices included
+func PlanInspectionRound(store database.Store, round *models.InspectionRound) (int, error) {
+	buildingCode := ""
+	if round.BuildingID.Valid {
+		building, err := store.GetBuildingById(int(round.BuildingID.Int64))
+		if err != nil || building.SiteID != round.SiteID {
+			return 0, errors.New("building does not exist at this site")
+		}
+		buildingCode = building.BuildingCode
+	}
+
+	devices, err := store.GetAllDevices(strconv.Itoa(round.SiteID), buildingCode, "")
+	if err != nil {
+		return 0, err
+	}
+
+	round.DeviceIDs = nil
+	for _, device := range devices {
+		if dueBy(device, round.PeriodEnd) {
+			round.DeviceIDs = append(round.DeviceIDs, device.EmergencyDeviceID)
+		}
+	}
+	if len(round.DeviceIDs) == 0 {
+		return 0, ErrNothingDue
+	}
+
+	return store.AddInspectionRound(round)
+}
+
+// GenerateInspectionRounds plans a round for every building with devices due in the month that starts on
+// the given day, optionally assigned to one inspector. Buildings that already have a round for the month
+// are left alone, so it can be run again, and the number of rounds added is returned.
+func GenerateInspectionRounds(store database.Store, month time.Time, userID int) (int, error) {
+	periodStart := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	periodEnd := periodStart.AddDate(0, 1, -1)
+
+	existing, err := store.GetInspectionRounds(0)
+	if err != nil {
+		return 0, err
+	}
+	planned := map[int64]bool{}
+	for _, round := range existing {
+		if round.PeriodStart.Equal(periodStart) && round.BuildingID.Valid {
+			planned[round.BuildingID.Int64] = true
+		}
+	}
+
+	buildings, err := store.GetAllBuildings("")
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, building := range buildings {
+		if planned[int64(building.BuildingID)] {
+			continue
+		}
+		_, err := PlanInspectionRound(store, &models.InspectionRound{
+			SiteID:      building.SiteID,
+			BuildingID:  sql.NullInt64{Int64: int64(building.BuildingID), Valid: true},
+			UserID:      sql.NullInt64{Int64: int64(userID), Valid: userID != 0},
+			PeriodStart: periodStart,
+			PeriodEnd:   periodEnd,
+		})
+		if errors.Is(err, ErrNothingDue) {
+			continue
+		}
+		if err != nil {
+			return added, fmt.Errorf("planning the round of building %s: %v", building.BuildingCode, err)
+		}
+		added++
+	}
+
+	return added, nil
+}
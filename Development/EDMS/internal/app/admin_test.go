@@ -0,0 +1,163 @@
+package app_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/app"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCreateUserAndSetRole(t *testing.T) {
+	a := newTestApp(t)
+
+	_, err := app.CreateUser(a.Store, "inspector1", "inspector@example.com", "weak", "User")
+	assert.Error(t, err, "the password policy applies")
+
+	_, err = app.CreateUser(a.Store, "inspector1", "inspector@example.com", "Password1!", "Owner")
+	assert.Error(t, err, "only Admin and User are roles")
+
+	user, err := app.CreateUser(a.Store, "inspector1", "inspector@example.com", "Password1!", "Admin")
+	require.NoError(t, err)
+	assert.Equal(t, "Admin", user.Role)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("Password1!")))
+
+	_, err = app.CreateUser(a.Store, "inspector1", "other@example.com", "Password1!", "User")
+	assert.Error(t, err, "usernames are unique")
+
+	require.NoError(t, app.SetUserRole(a.Store, "inspector1", "User"))
+	user, err = a.Store.GetUserByUsername("inspector1")
+	require.NoError(t, err)
+	assert.Equal(t, "User", user.Role)
+
+	err = app.SetUserRole(a.Store, "nobody", "User")
+	assert.True(t, errors.Is(err, app.ErrUserNotFound))
+}
+
+func TestResetPassword(t *testing.T) {
+	a := newTestApp(t)
+
+	assert.Error(t, app.ResetPassword(a.Store, "admin", "short"))
+	require.NoError(t, app.ResetPassword(a.Store, "admin", "NewPassword1!"))
+
+	user, err := a.Store.GetUserByUsername("admin")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("NewPassword1!")))
+
+	assert.True(t, errors.Is(app.ResetPassword(a.Store, "nobody", "NewPassword1!"), app.ErrUserNotFound))
+}
+
+func TestImportDevicesCSV(t *testing.T) {
+	a := newTestApp(t)
+
+	invalid := "site,building,room,device_type,serial_number,manufacture_date\n" +
+		"Taradale,A,A101,Fire Extinguisher,SN2,2024-01-01\n" +
+		"Taradale,A,A999,Fire Extinguisher,SN3,2024-01-01\n" +
+		"Taradale,A,A101,Fire Extinguisher,SN4,01/01/2024\n"
+
+	count, err := app.ImportDevicesCSV(a.Store, strings.NewReader(invalid))
+	require.Error(t, err)
+	assert.Equal(t, 0, count)
+	assert.Contains(t, err.Error(), "line 3")
+	assert.Contains(t, err.Error(), "line 4")
+
+	devices, err := a.Store.GetDevicesByRoomID(a.RoomID)
+	require.NoError(t, err)
+	assert.Len(t, devices, 1, "a file with an error imports nothing")
+
+	valid := "device_type,site,building,room,serial_number,manufacture_date,status\n" +
+		"Fire Extinguisher,Taradale,A,A101,SN2,2024-01-01,Active\n" +
+		"Fire Extinguisher,Taradale,A,A101,SN3,,\n"
+
+	count, err = app.ImportDevicesCSV(a.Store, strings.NewReader(valid))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	devices, err = a.Store.GetDevicesByRoomID(a.RoomID)
+	require.NoError(t, err)
+	assert.Len(t, devices, 3)
+
+	_, err = app.ImportDevicesCSV(a.Store, strings.NewReader("site,building,room\n"))
+	assert.Error(t, err, "the device_type column is required")
+}
+
+func TestWriteDeviceReport(t *testing.T) {
+	a := newTestApp(t)
+
+	var buf bytes.Buffer
+	count, err := app.WriteDeviceReport(a.Store, &buf, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, app.DeviceImportColumns, records[0][:len(app.DeviceImportColumns)])
+	assert.Equal(t, []string{"Taradale", "A", "A101", "Fire Extinguisher", "", "SN1", "2024-08-01"}, records[1][:7])
+
+	// A report can be imported again
+	buf.Reset()
+	_, err = app.WriteDeviceReport(a.Store, &buf, "", "")
+	require.NoError(t, err)
+	count, err = app.ImportDevicesCSV(a.Store, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestRecomputeDeviceStatuses(t *testing.T) {
+	a := newTestApp(t)
+
+	changed, err := app.RecomputeDeviceStatuses(a.Store, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 0, changed, "a device that has never been inspected has no due date")
+
+	changed, err = app.RecomputeDeviceStatuses(a.Store, time.Date(2029, time.August, 2, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Expired", device.Status.String)
+
+	changed, err = app.RecomputeDeviceStatuses(a.Store, time.Date(2029, time.August, 2, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 0, changed, "recomputing twice changes nothing")
+}
+
+func TestGenerateInspectionRounds(t *testing.T) {
+	a := newTestApp(t)
+
+	// The device is inspected in July 2025, its next inspection is three months later
+	require.NoError(t, a.Store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  a.DeviceID,
+		UserID:             a.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Date(2025, time.July, 15, 0, 0, 0, 0, time.UTC), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+
+	added, err := app.GenerateInspectionRounds(a.Store, time.Date(2025, time.September, 1, 0, 0, 0, 0, time.UTC), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, added, "nothing is due in September")
+
+	added, err = app.GenerateInspectionRounds(a.Store, time.Date(2025, time.October, 1, 0, 0, 0, 0, time.UTC), a.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	rounds, err := a.Store.GetInspectionRounds(a.UserID)
+	require.NoError(t, err)
+	require.Len(t, rounds, 1, "the rounds are assigned to the inspector")
+	assert.True(t, rounds[0].PeriodEnd.Equal(time.Date(2025, time.October, 31, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 1, rounds[0].Remaining)
+
+	added, err = app.GenerateInspectionRounds(a.Store, time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added, "overdue devices are in later rounds too")
+}
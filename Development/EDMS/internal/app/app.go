@@ -1,74 +1,207 @@
 package app
 
 import (
-	"log"
-	"os"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/logging"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 // App holds the application state including database and router
 type App struct {
-	DB     *database.DB
-	Router *echo.Echo
-	Logger *log.Logger
+	DB      database.Store
+	Router  *echo.Echo
+	Logger  *slog.Logger
+	Metrics *prometheus.Registry
+	Config  config.Config
+
+	// Webhooks sends the webhook outbox, it only runs once started with Run
+	Webhooks *WebhookDispatcher
+	// Statuses keeps device statuses up to date, it only runs once started with Run
+	Statuses *StatusSweeper
+
+	readiness readiness
 }
 
-// handleError is a method of App for handling errors
+// handleError logs an error with the request ID and returns it to the client as JSON
 func (a *App) handleError(c echo.Context, statusCode int, message string, err error) error {
-	a.Logger.Printf("\033[31mError: %v\033[0m", err) // Use the logger in the App struct
-	return c.JSON(statusCode, map[string]string{"error": message})
+	ctx := c.Request().Context()
+
+	level := slog.LevelWarn
+	if statusCode >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	a.Logger.Log(ctx, level, message, "status", statusCode, "error", err)
+
+	return c.JSON(statusCode, map[string]string{"error": message, "request_id": logging.RequestID(ctx)})
 }
 
-func (a *App) handleLogger(message string) {
-	a.Logger.Printf("\033[34m%s\033[0m", message)
+// handleLogger logs an informational message with the request ID
+func (a *App) handleLogger(c echo.Context, message string) {
+	a.Logger.InfoContext(c.Request().Context(), message)
 }
 
-// NewApp creates a new instance of App
-func NewApp(cfg config.Config) *App {
-	// Initialize Echo
-	router := echo.New()
+// rejected is a request refused inside a unit of work, rolling it back, with the message shown to the user
+type rejected string
 
-	// Set up renderer
-	renderer, err := utils.NewTemplateRenderer()
-	if err != nil {
-		// Handle the error, e.g.:
-		panic(err)
+func (r rejected) Error() string {
+	return string(r)
+}
+
+// handleHTTPError replaces Echo's default error handler so unhandled errors are logged
+// and their responses carry the request ID like handleError's
+func (a *App) handleHTTPError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
 	}
 
-	router.Renderer = renderer
+	statusCode := http.StatusInternalServerError
+	message := http.StatusText(statusCode)
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		statusCode = httpError.Code
+		message = fmt.Sprint(httpError.Message)
+	}
 
-	// Serve static files
-	router.Static("/static", "static")
+	ctx := c.Request().Context()
+	if statusCode >= http.StatusInternalServerError {
+		a.Logger.ErrorContext(ctx, "Unhandled error", "status", statusCode, "error", err)
+	}
 
-	router.Use(middleware.Logger())  // Log requests
-	router.Use(middleware.Recover()) // Recover from panics
-	router.Use(middleware.CORS())    // Enable CORS
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(statusCode)
+	} else {
+		err = c.JSON(statusCode, map[string]string{"error": message, "request_id": logging.RequestID(ctx)})
+	}
+	if err != nil {
+		a.Logger.ErrorContext(ctx, "Error writing error response", "error", err)
+	}
+}
+
+// requestLogger logs one line per request at a level matching its status, except passing health probes.
+// Only the path is logged, query strings can carry personal details.
+func (a *App) requestLogger() echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		HandleError:  true,
+		LogMethod:    true,
+		LogURIPath:   true,
+		LogRoutePath: true,
+		LogStatus:    true,
+		LogLatency:   true,
+		LogRemoteIP:  true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			// Probes run every few seconds, only their failures are worth a line
+			if (v.RoutePath == "/healthz" || v.RoutePath == "/readyz") && v.Status < http.StatusBadRequest {
+				return nil
+			}
+
+			level := slog.LevelInfo
+			if v.Status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			} else if v.Status >= http.StatusBadRequest {
+				level = slog.LevelWarn
+			}
+
+			a.Logger.LogAttrs(c.Request().Context(), level, "Request",
+				slog.String("method", v.Method),
+				slog.String("path", v.URIPath),
+				slog.String("route", v.RoutePath),
+				slog.Int("status", v.Status),
+				slog.Duration("latency", v.Latency),
+				slog.String("remote_ip", v.RemoteIP),
+			)
+			return nil
+		},
+	})
+}
 
+// NewApp creates a new instance of App. The database connection is retried until the context is done,
+// so the server can start before the database is accepting connections.
+func NewApp(ctx context.Context, cfg config.Config) (*App, error) {
 	// Initialize Database
-	db, err := database.NewDB(cfg)
+	db, err := database.ConnectWithRetry(ctx, cfg)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	// Seed database
-	// Initialize database and seed data if needed
-	if err := database.SeedDatabase(db.DB); err != nil {
-		panic(err)
+	// Bring the schema up to date before anything queries it
+	if err := database.MigrateUp(db.DB); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	// Initialize Logger
-	logger := log.New(os.Stdout, "\033[34mAPP: \033[0m", log.LstdFlags)
+	// Seed data if the database records that it has not been seeded yet
+	if err := database.SeedDatabase(db.DB, database.SeedProfileDemo, cfg.AdminPassword); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	app := NewAppWithStore(db, cfg)
+
+	// Report the connection pool alongside the other metrics
+	app.Metrics.MustRegister(collectors.NewDBStatsCollector(db.DB, "edms"))
+
+	// Set up renderer
+	renderer, err := utils.NewTemplateRenderer()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("loading templates: %v", err)
+	}
+
+	app.Router.Renderer = renderer
+
+	// Serve static files
+	app.Router.Static("/static", "static")
+
+	if err := app.addDependencyChecks(db.DB); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// NewAppWithStore creates an App with its routes on the given store, without connecting to PostgreSQL or
+// loading the page templates. Handler tests use it with a database.MemoryStore.
+func NewAppWithStore(store database.Store, cfg config.Config) *App {
+	// Initialize Echo
+	router := echo.New()
 
+	// Log through the default logger, set up by the command that starts the app
 	app := &App{
-		DB:     db,
-		Router: router,
-		Logger: logger,
+		DB:      store,
+		Router:  router,
+		Logger:  slog.Default(),
+		Metrics: newMetricsRegistry(),
+		Config:  cfg,
 	}
+	app.Metrics.MustRegister(newDomainCollector(store, app.Logger))
+	app.Webhooks = NewWebhookDispatcher(store, app.Logger, app.Metrics)
+	app.Webhooks.AllowLocal = cfg.WebhooksAllowLocal
+	app.Statuses = NewStatusSweeper(store, app.Logger)
+
+	router.HTTPErrorHandler = app.handleHTTPError
+
+	// Tag every request with an ID, taken from the X-Request-ID header when a proxy has set one
+	router.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		RequestIDHandler: func(c echo.Context, id string) {
+			c.SetRequest(c.Request().WithContext(logging.WithRequestID(c.Request().Context(), id)))
+		},
+	}))
+	router.Use(httpMetrics(app.Metrics)) // Count requests per route
+	router.Use(app.requestLogger())      // Log requests
+	router.Use(middleware.Recover())     // Recover from panics
+	router.Use(middleware.CORS())        // Enable CORS
 
 	// Initialize routes
 	app.initRoutes()
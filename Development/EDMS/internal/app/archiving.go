@@ -0,0 +1,116 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Sites, buildings and rooms are only archived once nothing is left under them. GET .../dependents is a dry run
+// listing what is in the way, and DELETE refuses with 409 and the same list. With ?reassign_to= the buildings of a
+// site, the rooms of a building or the devices of a room are first moved to another one, in the same transaction
+// as the archive so either both happen or neither does. Only the version in If-Match is archived, like PUT.
+
+// location is a site, building or room being archived
+type location struct {
+	name       string // Site, Building or Room
+	children   string // What reassign_to moves: buildings, rooms or devices
+	dependents func(tx database.Store) (*models.Dependents, error)
+	move       func(tx database.Store, to int) error
+	archive    func(tx database.Store) error
+	current    func(store database.Store) (interface{}, int, error) // The location as it is now and its version
+}
+
+// archiveLocation archives a location in one transaction with moving its children to the one in reassign_to,
+// if given, refusing when something still depends on it
+func (a *App) archiveLocation(c echo.Context, id int, loc location) error {
+	lower := strings.ToLower(loc.name)
+
+	reassignTo := 0
+	if param := c.QueryParam("reassign_to"); param != "" {
+		to, err := strconv.Atoi(param)
+		if err != nil || to == id {
+			message := "Invalid " + lower + " to move the " + loc.children + " to"
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error":       message,
+				"redirectURL": "/admin?error=" + message,
+			})
+		}
+		reassignTo = to
+	}
+
+	status := http.StatusConflict
+	var blocking *models.Dependents
+	err := a.store(c).WithTx(func(tx database.Store) error {
+		if reassignTo != 0 {
+			err := loc.move(tx, reassignTo)
+			if errors.Is(err, sql.ErrNoRows) {
+				status = http.StatusBadRequest
+				return rejected(loc.name + " to move the " + loc.children + " to does not exist")
+			}
+			if errors.Is(err, database.ErrCodeTaken) {
+				return rejected(loc.name + " to move the " + loc.children + " to already has " + loc.children + " with the same codes")
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		// Checked in the same transaction as the archive, so nothing is added in between
+		dependents, err := loc.dependents(tx)
+		if err != nil {
+			return err
+		}
+		if !dependents.Empty() {
+			blocking = dependents
+			return rejected("Cannot archive " + lower + " with associated buildings, rooms or devices, move or archive them first")
+		}
+
+		return loc.archive(tx)
+	})
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, version, err := loc.current(a.store(c)); err == nil {
+			return versionConflict(c, http.StatusConflict, loc.name, "/admin", current, version)
+		}
+	}
+	var rejection rejected
+	if errors.As(err, &rejection) {
+		return c.JSON(status, map[string]interface{}{
+			"error":       string(rejection),
+			"redirectURL": "/admin?error=" + string(rejection),
+			"dependents":  blocking,
+		})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error archiving "+lower, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     loc.name + " archived successfully",
+		"redirectURL": "/admin?message=" + loc.name + " archived successfully",
+	})
+}
+
+// getDependents answers the dry run of archiving a location, 404 when it does not exist or is archived
+func (a *App) getDependents(c echo.Context, name string, dependents func(store database.Store, id int) (*models.Dependents, error)) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return a.handleError(c, http.StatusBadRequest, "Invalid "+strings.ToLower(name)+" ID", err)
+	}
+
+	result, err := dependents(a.store(c), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a.handleError(c, http.StatusNotFound, name+" not found", err)
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching dependents", err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
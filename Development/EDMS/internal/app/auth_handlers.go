@@ -1,13 +1,13 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
 	"time"
 
-	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
@@ -18,11 +18,12 @@ import (
 
 // CustomClaims represents JWT custom claims
 type CustomClaims struct {
-	UserID       string `json:"user_id"`
-	Username     string `json:"username"`
-	Email        string `json:"email"`
-	Role         string `json:"role"`
-	DefaultAdmin bool   `json:"default_admin"`
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	Email          string `json:"email"`
+	Role           string `json:"role"`
+	DefaultAdmin   bool   `json:"default_admin"`
+	OrganisationID int    `json:"organisation_id"`
 	jwt.RegisteredClaims
 }
 
@@ -62,10 +63,10 @@ func (a *App) HandlePostForgotPassword(c echo.Context) error {
 
 	emailmessage := fmt.Sprintf("Could not send email but Your new password is: %s", newPassword)
 
-	a.handleLogger(emailmessage)
-
 	// Send the new password to the user's email
-	if err := sendPasswordResetEmail(email, user.Username, newPassword); err != nil {
+	a.handleLogger(c, "Sending password reset email to user "+strconv.Itoa(user.UserID))
+	if err := a.sendPasswordResetEmail(email, user.Username, newPassword); err != nil {
+		a.Logger.ErrorContext(c.Request().Context(), "Error sending password reset email", "user_id", user.UserID, "error", err)
 		return c.Redirect(http.StatusSeeOther, "/?message="+emailmessage)
 	}
 	message := fmt.Sprintf("Password reset successful. Check your %s for the new password.", email)
@@ -159,7 +160,8 @@ func (a *App) HandlePostRegister(c echo.Context) error {
 		Password: string(hashedPassword),
 	}
 
-	if err := a.DB.CreateUser(&user); err != nil {
+	// Self-registered accounts belong to no organisation, so they see no one's data until an admin adds them
+	if err := a.DB.RegisterUser(&user); err != nil {
 		return c.Render(http.StatusOK, "register.html", map[string]interface{}{
 			"error": "Could not create user",
 		})
@@ -167,7 +169,7 @@ func (a *App) HandlePostRegister(c echo.Context) error {
 
 	// Generate a success message
 	// Maybe send a welcome email here
-	message := fmt.Sprintf("Registration successful. Please login with your username: %s", username)
+	message := fmt.Sprintf("Registration successful. You can log in with your username %s once an admin adds you to an organisation", username)
 	return c.Redirect(http.StatusSeeOther, "/?message="+message)
 }
 
@@ -184,7 +186,7 @@ func (a *App) HandleGetLogin(c echo.Context) error {
 	cookie, err := c.Cookie("token")
 	if err == nil && cookie.Value != "" {
 		// Parse the JWT token
-		token, err := parseToken(cookie.Value)
+		token, err := a.parseToken(cookie.Value)
 		if err == nil && token.Valid {
 			if claims, ok := token.Claims.(*CustomClaims); ok {
 				// Put the claims data in the context
@@ -225,14 +227,22 @@ func (a *App) HandlePostLogin(c echo.Context) error {
 		})
 	}
 
+	// Users start in the organisation they joined first, they can switch to their others later
+	organisations, err := a.DB.GetUserOrganisations(user.UserID)
+	if err != nil || len(organisations) == 0 {
+		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
+			"error": "Your account does not belong to an organisation yet, ask an admin to add you",
+		})
+	}
+
 	// Determine expiration time based on "remember" checkbox
-	expiresAt := time.Now().Add(72 * time.Hour) // Default expiration time is 3 days
+	expiresAt := time.Now().Add(a.Config.SessionLifetime)
 	if remember == "on" {
-		expiresAt = time.Now().Add(30 * 24 * time.Hour)
+		expiresAt = time.Now().Add(a.Config.RememberMeLifetime)
 	}
 
 	// Generate token
-	token, err := GenerateToken(user, expiresAt)
+	token, err := a.GenerateToken(user, organisations[0].OrganisationID, expiresAt)
 	if err != nil {
 		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
 			"error": "Could not generate token",
@@ -240,16 +250,7 @@ func (a *App) HandlePostLogin(c echo.Context) error {
 	}
 
 	// Set the token as a cookie
-	cookie := &http.Cookie{
-		Name:     "token",
-		Value:    token,
-		Expires:  expiresAt,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false, // Set to true if using HTTPS
-		SameSite: http.SameSiteStrictMode,
-	}
-	c.SetCookie(cookie)
+	a.setTokenCookie(c, token, expiresAt)
 
 	c.Set("user", token)
 
@@ -289,40 +290,56 @@ func (a *App) HandleGetLogout(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, "/?message="+message)
 }
 
-// GenerateToken generates a JWT token
-func GenerateToken(user *models.User, expiresAt time.Time) (string, error) {
+// setTokenCookie sets the login token cookie
+func (a *App) setTokenCookie(c echo.Context, token string, expiresAt time.Time) {
+	c.SetCookie(&http.Cookie{
+		Name:     "token",
+		Value:    token,
+		Expires:  expiresAt,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false, // Set to true if using HTTPS
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// GenerateToken generates a JWT token signed with the configured secret for a user working in an organisation
+func (a *App) GenerateToken(user *models.User, organisationID int, expiresAt time.Time) (string, error) {
 	claims := &CustomClaims{
-		UserID:       strconv.Itoa(user.UserID),
-		Email:        user.Email,
-		Username:     user.Username,
-		Role:         user.Role,
-		DefaultAdmin: user.DefaultAdmin,
+		UserID:         strconv.Itoa(user.UserID),
+		Email:          user.Email,
+		Username:       user.Username,
+		Role:           user.Role,
+		DefaultAdmin:   user.DefaultAdmin,
+		OrganisationID: organisationID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := config.LoadConfig().JWTSecret
-	return token.SignedString([]byte(secret))
+	return token.SignedString([]byte(a.Config.JWTSecret))
 }
 
 // parseToken parses and validates the JWT token
-func parseToken(tokenString string) (*jwt.Token, error) {
-	secret := config.LoadConfig().JWTSecret
+func (a *App) parseToken(tokenString string) (*jwt.Token, error) {
 	return jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(secret), nil
+		return []byte(a.Config.JWTSecret), nil
 	})
 }
 
-// sendPasswordResetEmail sends a password reset email
-func sendPasswordResetEmail(email, username, newPassword string) error {
+// sendPasswordResetEmail sends a password reset email through the configured mail server
+func (a *App) sendPasswordResetEmail(email, username, newPassword string) error {
+	if a.Config.SMTPHost == "" {
+		return errors.New("SMTP_HOST is not configured")
+	}
+
 	m := gomail.NewMessage()
 	m.Reset()
-	m.SetHeader("From", "MS_kAX6BH@trial-jpzkmgqev0vg059v.mlsender.net")
+	m.SetHeader("From", a.Config.SMTPFrom)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", "EDMS PASSWORD RESET")
 	m.SetBody("text/plain", "Your Username is "+username+", Your new password is: "+newPassword)
@@ -332,10 +349,7 @@ func sendPasswordResetEmail(email, username, newPassword string) error {
 		<p>Your new password is: <strong>`+newPassword+`</strong></p>
 	</body></html>`)
 
-	d := gomail.NewDialer("smtp.gmail.com", 587, "edms4758@gmail.com", "dksz kwoc zjiv cqub")
+	d := gomail.NewDialer(a.Config.SMTPHost, a.Config.SMTPPort, a.Config.SMTPUsername, a.Config.SMTPPassword)
 
-	fmt.Println("Sending email to", email)
-	fmt.Println("Username:", username)
-	fmt.Println("New Password:", newPassword)
 	return d.DialAndSend(m)
 }
@@ -1,10 +1,14 @@
 package app
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	"github.com/labstack/echo/v4"
 )
@@ -16,7 +20,7 @@ func (a *App) HandleGetAllBuildings(c echo.Context) error {
 	}
 
 	siteId := c.QueryParam("siteId")
-	buildings, err := a.DB.GetAllBuildings(siteId)
+	buildings, err := a.store(c).GetAllBuildings(siteId)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -39,12 +43,13 @@ func (a *App) HandleGetBuildingByID(c echo.Context) error {
 		return a.handleError(c, http.StatusBadRequest, "Invalid building ID", err)
 	}
 
-	building, err := a.DB.GetBuildingById(buildingIdInt)
+	building, err := a.store(c).GetBuildingById(buildingIdInt)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
-	// Return the results as JSON
+	// Return the results as JSON, with the version edits must be made from
+	setETag(c, building.Version)
 	return c.JSON(http.StatusOK, building)
 }
 
@@ -64,7 +69,7 @@ func (a *App) HandlePostBuilding(c echo.Context) error {
 	}
 
 	// Validate site ID
-	_, err := a.DB.GetSiteByID(siteId)
+	_, err := a.store(c).GetSiteByID(siteId)
 	if err != nil {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Invalid site ID")
 	}
@@ -80,17 +85,26 @@ func (a *App) HandlePostBuilding(c echo.Context) error {
 	}
 
 	// Check if the building already exists
-	_, err = a.DB.GetBuildingByCodeandSite(buildingCode, siteIdNum)
+	_, err = a.store(c).GetBuildingByCodeandSite(buildingCode, siteIdNum)
 	if err == nil {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Building already exists at the site")
 	}
 
+	// Validate the position on the site map
+	mapX, mapY, mapPolygon, err := parseBuildingMapPosition(c.FormValue("addBuildingMapX"), c.FormValue("addBuildingMapY"), c.FormValue("addBuildingMapPolygon"))
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/admin?error="+err.Error())
+	}
+
 	building := &models.Building{
 		SiteID:       siteIdNum,
 		BuildingCode: buildingCode,
+		MapX:         mapX,
+		MapY:         mapY,
+		MapPolygon:   mapPolygon,
 	}
 
-	err = a.DB.AddBuilding(building)
+	err = a.store(c).AddBuilding(building)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error saving building", err)
 	}
@@ -111,7 +125,7 @@ func (a *App) HandleEditBuilding(c echo.Context) error {
 	// Parse the form data
 	var building models.BuildingDto
 	if err := c.Bind(&building); err != nil {
-		a.handleLogger("Error parsing form data")
+		a.handleLogger(c, "Error parsing form data")
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Error parsing form data",
 			"redirectURL": "/admin?error=Error parsing form data",
@@ -129,7 +143,7 @@ func (a *App) HandleEditBuilding(c echo.Context) error {
 	}
 
 	// Validate site ID
-	_, err := a.DB.GetSiteByID(building.SiteID)
+	_, err := a.store(c).GetSiteByID(building.SiteID)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid site ID",
@@ -161,22 +175,54 @@ func (a *App) HandleEditBuilding(c echo.Context) error {
 		})
 	}
 
-	// Check if the building already exists
-	_, err = a.DB.GetBuildingByCodeandSite(building.BuildingCode, siteIdNum)
-	if err == nil {
+	// The edit must be made from the current version of the building
+	current, err := a.store(c).GetBuildingById(buildingIDNum)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Building does not exist",
+			"redirectURL": "/admin?error=Building does not exist",
+		})
+	}
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Building", "/admin", current, current.Version)
+	}
+
+	// Check if the building already exists, the building being edited may keep its own code
+	existingBuilding, err := a.store(c).GetBuildingByCodeandSite(building.BuildingCode, siteIdNum)
+	if err == nil && existingBuilding.BuildingID != buildingIDNum {
 		return c.JSON(http.StatusOK, map[string]string{
 			"error":       "Building already exists at the site",
 			"redirectURL": "/admin?error=Building already exists at the site",
 		})
 	}
 
+	// Validate the position on the site map
+	mapX, mapY, mapPolygon, err := parseBuildingMapPosition(building.MapX, building.MapY, building.MapPolygon)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error(),
+		})
+	}
+
 	buildingModel := &models.Building{
 		BuildingID:   buildingIDNum,
 		SiteID:       siteIdNum,
 		BuildingCode: building.BuildingCode,
+		MapX:         mapX,
+		MapY:         mapY,
+		MapPolygon:   mapPolygon,
+		Version:      version,
 	}
 
-	err = a.DB.UpdateBuilding(buildingModel)
+	// Update the building, unless someone else changed it first
+	err = a.store(c).UpdateBuilding(buildingModel)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetBuildingById(buildingIDNum); err == nil {
+			return versionConflict(c, http.StatusConflict, "Building", "/admin", current, current.Version)
+		}
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error updating building",
@@ -213,7 +259,7 @@ func (a *App) HandleDeleteBuilding(c echo.Context) error {
 	}
 
 	// Get the building by ID
-	building, err := a.DB.GetBuildingById(buildingIDInt)
+	building, err := a.store(c).GetBuildingById(buildingIDInt)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error fetching building",
@@ -221,52 +267,76 @@ func (a *App) HandleDeleteBuilding(c echo.Context) error {
 		})
 	}
 
-	// Check if the site has any emergency devices
-	emergencyDevices, err := a.DB.GetAllDevices(strconv.Itoa(building.SiteID), building.BuildingCode)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error fetching emergency devices",
-			"redirectURL": "/admin?error=Error fetching emergency devices",
-		})
+	// Only the version the admin saw can be archived
+	version, ok := ifMatch(c, building.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Building", "/admin", building, building.Version)
 	}
 
-	// Check if the site has any emergency devices
-	if len(emergencyDevices) > 0 {
-		return c.JSON(http.StatusOK, map[string]string{
-			"error":       "Cannot delete building with associated emergency devices",
-			"redirectURL": "/admin?error=Cannot delete building with associated emergency devices",
-		})
-	}
+	// Archive the building once nothing is under it, the row is kept for history
+	return a.archiveLocation(c, building.BuildingID, location{
+		name:     "Building",
+		children: "rooms",
+		dependents: func(tx database.Store) (*models.Dependents, error) {
+			return tx.GetBuildingDependents(building.BuildingID)
+		},
+		move: func(tx database.Store, to int) error {
+			return tx.MoveBuildingRooms(building.BuildingID, to)
+		},
+		archive: func(tx database.Store) error {
+			return tx.ArchiveBuilding(buildingID, strings.TrimSpace(c.QueryParam("reason")), version)
+		},
+		current: func(store database.Store) (interface{}, int, error) {
+			current, err := store.GetBuildingById(buildingIDInt)
+			if err != nil {
+				return nil, 0, err
+			}
+			return current, current.Version, nil
+		},
+	})
+}
 
-	// Check if the building has any rooms
-	rooms, err := a.DB.GetRoomsByBuildingID(buildingID)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error fetching rooms",
-			"redirectURL": "/admin?error=Error fetching rooms",
-		})
+// HandleGetBuildingDependents lists what stops a building from being archived
+func (a *App) HandleGetBuildingDependents(c echo.Context) error {
+	return a.getDependents(c, "Building", database.Store.GetBuildingDependents)
+}
+
+// parseBuildingMapPosition validates the position of a building on its site map.
+// Positions are fractions of the map image measured from the top left corner, all fields are optional
+// but an X coordinate needs a Y coordinate and a polygon needs at least three points.
+func parseBuildingMapPosition(mapXStr, mapYStr, mapPolygonStr string) (sql.NullFloat64, sql.NullFloat64, sql.NullString, error) {
+	var mapX, mapY sql.NullFloat64
+	var mapPolygon sql.NullString
+
+	mapXStr = strings.TrimSpace(mapXStr)
+	mapYStr = strings.TrimSpace(mapYStr)
+	if (mapXStr == "") != (mapYStr == "") {
+		return mapX, mapY, mapPolygon, errors.New("Map X and Map Y must both be set")
 	}
 
-	// Check if the site has any rooms
-	if len(rooms) > 0 {
-		return c.JSON(http.StatusOK, map[string]string{
-			"error":       "Cannot building site with associated rooms",
-			"redirectURL": "/admin?error=Cannot delete building with associated rooms",
-		})
+	if mapXStr != "" {
+		x, errX := strconv.ParseFloat(mapXStr, 64)
+		y, errY := strconv.ParseFloat(mapYStr, 64)
+		if errX != nil || errY != nil || x < 0 || x > 1 || y < 0 || y > 1 {
+			return mapX, mapY, mapPolygon, errors.New("Map X and Map Y must be between 0 and 1")
+		}
+		mapX = sql.NullFloat64{Float64: x, Valid: true}
+		mapY = sql.NullFloat64{Float64: y, Valid: true}
 	}
 
-	// Delete the building from the database
-	err = a.DB.DeleteBuilding(buildingID)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error deleting site",
-			"redirectURL": "/admin?error=Error deleting site",
-		})
+	mapPolygonStr = strings.TrimSpace(mapPolygonStr)
+	if mapPolygonStr != "" {
+		var points [][]float64
+		if err := json.Unmarshal([]byte(mapPolygonStr), &points); err != nil || len(points) < 3 {
+			return mapX, mapY, mapPolygon, errors.New("Map polygon must be a JSON array of at least three [x, y] points")
+		}
+		for _, point := range points {
+			if len(point) != 2 || point[0] < 0 || point[0] > 1 || point[1] < 0 || point[1] > 1 {
+				return mapX, mapY, mapPolygon, errors.New("Map polygon points must be [x, y] pairs between 0 and 1")
+			}
+		}
+		mapPolygon = sql.NullString{String: mapPolygonStr, Valid: true}
 	}
 
-	// Respond to the client
-	return c.JSON(http.StatusOK, map[string]string{
-		"message":     "Building deleted successfully",
-		"redirectURL": "/admin?message=Building deleted successfully",
-	})
+	return mapX, mapY, mapPolygon, nil
 }
@@ -0,0 +1,234 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// calendarHorizonMonths is how far ahead the calendar feed lists due dates
+	calendarHorizonMonths = 12
+	// calendarDateFormat is the iCalendar DATE value format
+	calendarDateFormat = "20060102"
+)
+
+// calendarEvent is one all day event, the devices in a room that fall due on the same day
+type calendarEvent struct {
+	date     string
+	site     string
+	building string
+	room     string
+	lines    []string
+}
+
+// HandlePostCalendarFeed gives the logged in user a new calendar link for their organisation.
+// Only the hash of the token is kept, so the link is shown once and any earlier link stops working.
+func (a *App) HandlePostCalendarFeed(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error creating calendar link", err)
+	}
+	token := hex.EncodeToString(secret)
+
+	if err := a.DB.SetCalendarFeed(userID, organisationID(c), hashCalendarToken(token)); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error creating calendar link", err)
+	}
+
+	url := fmt.Sprintf("%s://%s/calendar/%s.ics", c.Scheme(), c.Request().Host, token)
+	return c.JSON(http.StatusCreated, map[string]string{"url": url})
+}
+
+// HandleDeleteCalendarFeed stops the logged in user's calendar link for their organisation from working
+func (a *App) HandleDeleteCalendarFeed(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	if err := a.DB.DeleteCalendarFeed(userID, organisationID(c)); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error revoking calendar link", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleGetCalendarFeed serves the upcoming inspection and expiry dates as an iCalendar feed.
+// Calendar apps cannot log in, so the token in the link is the only credential.
+// The devices can be filtered with site_id and building_code like the device list.
+func (a *App) HandleGetCalendarFeed(c echo.Context) error {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	feed, err := a.DB.GetCalendarFeed(hashCalendarToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return a.handleError(c, http.StatusNotFound, "Calendar not found", err)
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching calendar", err)
+	}
+
+	// The link stops working once the user leaves the organisation
+	organisations, err := a.DB.GetUserOrganisations(feed.UserID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching calendar", err)
+	}
+	if !memberOf(organisations, feed.OrganisationID) {
+		return a.handleError(c, http.StatusNotFound, "Calendar not found", nil)
+	}
+
+	store := a.DB.ForOrganisation(feed.OrganisationID)
+	devices, err := store.GetAllDevices(c.QueryParam("site_id"), c.QueryParam("building_code"), "")
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching devices", err)
+	}
+
+	calendarName := "EDMS due dates"
+	if organisation, err := store.GetOrganisationByID(feed.OrganisationID); err == nil {
+		calendarName = organisation.Name + " due dates"
+	}
+
+	body := buildCalendar(calendarName, a.calendarEvents(devices, time.Now()), time.Now())
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(body))
+}
+
+// calendarEvents groups the due dates of the devices from today until the horizon into one event per room per day.
+// Each day is the site's day, as the due dates are midnight at the site.
+func (a *App) calendarEvents(devices []models.EmergencyDevice, now time.Time) []*calendarEvent {
+	events := map[string]*calendarEvent{}
+	add := func(device models.EmergencyDevice, due sql.NullTime, what string) {
+		if !due.Valid {
+			return
+		}
+		location := a.siteLocation(device.SiteTimeZone)
+		today := now.In(location).Format(calendarDateFormat)
+		horizon := now.In(location).AddDate(0, calendarHorizonMonths, 0).Format(calendarDateFormat)
+		date := due.Time.In(location).Format(calendarDateFormat)
+		if date < today || date > horizon {
+			return
+		}
+
+		key := fmt.Sprintf("%d/%d/%d/%s", device.SiteID, device.BuildingID, device.RoomID, date)
+		event, ok := events[key]
+		if !ok {
+			event = &calendarEvent{date: date, site: device.SiteName, building: device.BuildingCode, room: device.RoomCode}
+			events[key] = event
+		}
+
+		line := fmt.Sprintf("%s %s", device.EmergencyDeviceTypeName, what)
+		if device.SerialNumber.Valid && device.SerialNumber.String != "" {
+			line = fmt.Sprintf("%s (%s) %s", device.EmergencyDeviceTypeName, device.SerialNumber.String, what)
+		}
+		event.lines = append(event.lines, line)
+	}
+
+	for _, device := range devices {
+		add(device, device.NextInspectionDate, "inspection due")
+		add(device, device.ExpireDate, "expires")
+	}
+
+	list := make([]*calendarEvent, 0, len(events))
+	for _, event := range events {
+		list = append(list, event)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].date != list[j].date {
+			return list[i].date < list[j].date
+		}
+		return list[i].uid() < list[j].uid()
+	})
+
+	return list
+}
+
+// uid identifies the event across downloads so calendar apps update it rather than adding a copy
+func (e *calendarEvent) uid() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{e.site, e.building, e.room, e.date}, "\x00")))
+	return hex.EncodeToString(sum[:16]) + "@edms"
+}
+
+// buildCalendar writes the events as an iCalendar (RFC 5545) document
+func buildCalendar(name string, events []*calendarEvent, now time.Time) string {
+	var b strings.Builder
+	write := func(line string) {
+		b.WriteString(foldCalendarLine(line))
+		b.WriteString("\r\n")
+	}
+
+	write("BEGIN:VCALENDAR")
+	write("VERSION:2.0")
+	write("PRODID:-//EDMS//Due Dates//EN")
+	write("CALSCALE:GREGORIAN")
+	write("METHOD:PUBLISH")
+	write("X-WR-CALNAME:" + escapeCalendarText(name))
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, event := range events {
+		start, _ := time.Parse(calendarDateFormat, event.date)
+		summary := fmt.Sprintf("%d due in %s %s", len(event.lines), event.building, event.room)
+		if len(event.lines) == 1 {
+			summary = fmt.Sprintf("%s in %s %s", event.lines[0], event.building, event.room)
+		}
+
+		write("BEGIN:VEVENT")
+		write("UID:" + event.uid())
+		write("DTSTAMP:" + stamp)
+		write("DTSTART;VALUE=DATE:" + event.date)
+		write("DTEND;VALUE=DATE:" + start.AddDate(0, 0, 1).Format(calendarDateFormat))
+		write("SUMMARY:" + escapeCalendarText(summary))
+		write("LOCATION:" + escapeCalendarText(fmt.Sprintf("%s, %s %s", event.site, event.building, event.room)))
+		write("DESCRIPTION:" + escapeCalendarText(strings.Join(event.lines, "\n")))
+		write("TRANSP:TRANSPARENT")
+		write("END:VEVENT")
+	}
+
+	write("END:VCALENDAR")
+	return b.String()
+}
+
+// escapeCalendarText escapes a TEXT value
+func escapeCalendarText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// foldCalendarLine splits a content line into lines of at most 75 octets, without splitting a character
+func foldCalendarLine(line string) string {
+	const limit = 75
+	if len(line) <= limit {
+		return line
+	}
+
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > limit {
+			// Continuation lines start with a space, which counts towards their length
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
+
+// hashCalendarToken is what is kept in place of a calendar token
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
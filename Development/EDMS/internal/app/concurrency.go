@@ -0,0 +1,57 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Admins editing the same row do not silently overwrite each other. GET by ID sends the row's version as its ETag,
+// and PUT and DELETE only apply to the version in If-Match. A stale If-Match is answered with 412 and an edit that
+// loses the race with another with 409, both with the current row so the UI can show the conflict.
+// Requests without If-Match are refused with 428 by RequireIfMatch, the site edit form names its version in a field.
+
+// ifMatchRequired is the answer to an edit or delete that does not name the version it was made from
+const ifMatchRequired = "If-Match is required, reload the page and try again"
+
+// etag is the entity tag of a row's API representation, its version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag sends the version of the row a response represents
+func setETag(c echo.Context, version int) {
+	c.Response().Header().Set("ETag", etag(version))
+}
+
+// ifMatch returns the version a request applies to, and false when its If-Match header names another version
+// of the row than the current one. Weak tags compare like strong ones, the version is the whole representation.
+func ifMatch(c echo.Context, current int) (int, bool) {
+	header := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return current, true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag(current) {
+			return current, true
+		}
+	}
+
+	return 0, false
+}
+
+// versionConflict answers a request made from a stale copy of a row with the current row and its ETag.
+// The status is 412 when If-Match named an old version and 409 when the row changed while the request was handled.
+func versionConflict(c echo.Context, status int, name string, page string, current interface{}, version int) error {
+	message := name + " has been changed by someone else, review the changes and try again"
+
+	setETag(c, version)
+	return c.JSON(status, map[string]interface{}{
+		"error":       message,
+		"redirectURL": page + "?error=" + message,
+		"current":     current,
+	})
+}
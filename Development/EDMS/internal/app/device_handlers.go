@@ -2,17 +2,23 @@ package app
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	"github.com/labstack/echo/v4"
 )
 
-// HandleGetAllDevices fetches all emergency devices from the database with optional filtering by building code
-// and returns the results as JSON
+// HandleGetAllDevices fetches all emergency devices from the database with optional filtering by site,
+// building code and floor, or by the filters of a saved view ?view_id=, and returns the results as JSON
 func (a *App) HandleGetAllDevices(c echo.Context) error {
 	// Check if request if a POST request
 	if c.Request().Method != http.MethodGet {
@@ -20,11 +26,36 @@ func (a *App) HandleGetAllDevices(c echo.Context) error {
 	}
 	siteId := c.QueryParam("site_id")
 	buildingCode := c.QueryParam("building_code")
+	floorId := c.QueryParam("floor_id")
 
-	emergencyDevices, err := a.DB.GetAllDevices(siteId, buildingCode)
+	// A saved view replaces the filters with its own and sorts the devices
+	var view *models.SavedView
+	if viewIDStr := c.QueryParam("view_id"); viewIDStr != "" {
+		viewID, err := strconv.Atoi(viewIDStr)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid saved view ID"})
+		}
+		userID, err := loggedInUserID(c)
+		if err != nil {
+			return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+		}
+		view, err = a.store(c).GetSavedViewByID(viewID, userID)
+		if err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Saved view not found"})
+		}
+		if err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error fetching saved view", err)
+		}
+		siteId, buildingCode, floorId = view.Filters.SiteID, view.Filters.BuildingCode, view.Filters.FloorID
+	}
+
+	emergencyDevices, err := a.store(c).GetAllDevices(siteId, buildingCode, floorId)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
+	if view != nil {
+		emergencyDevices = applySavedView(emergencyDevices, view)
+	}
 
 	// Return the results as JSON
 	return c.JSON(http.StatusOK, emergencyDevices)
@@ -45,13 +76,14 @@ func (a *App) HandleGetDeviceByID(c echo.Context) error {
 	}
 
 	// Fetch the device from the database
-	device, err := a.DB.GetDeviceByID(deviceID)
+	device, err := a.store(c).GetDeviceByID(deviceID)
 
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
-	// Return the result as JSON
+	// Return the result as JSON, with the version edits must be made from
+	setETag(c, device.Version)
 	return c.JSON(http.StatusOK, device)
 }
 
@@ -71,28 +103,37 @@ func (a *App) HandlePostDevice(c echo.Context) error {
 	size := c.FormValue("size")
 	description := c.FormValue("description")
 	status := c.FormValue("status")
-	a.handleLogger("Room: " + roomIDStr)
-	a.handleLogger("Emergency Device Type: " + emergencyDeviceTypeIDStr)
-	a.handleLogger("extinguisher_type_id: " + extinguisherTypeIDStr)
-	a.handleLogger("serial_number: " + serialNumber)
-	a.handleLogger("manufacture_date: " + manufactureDateStr)
-	a.handleLogger("size: " + size)
-	a.handleLogger("description: " + description)
-	a.handleLogger("status: " + status)
+	a.Logger.DebugContext(c.Request().Context(), "Adding device",
+		"room_id", roomIDStr,
+		"emergency_device_type_id", emergencyDeviceTypeIDStr,
+		"extinguisher_type_id", extinguisherTypeIDStr,
+		"serial_number", serialNumber,
+		"manufacture_date", manufactureDateStr,
+		"status", status,
+	)
 
 	// Validate input
 	emergencyDevice, err := validateDevice(roomIDStr, emergencyDeviceTypeIDStr, extinguisherTypeIDStr, serialNumber, manufactureDateStr, size, description, status)
 	if err != nil {
-		a.handleLogger("Error validating device: " + err.Error())
+		a.handleLogger(c, "Error validating device: "+err.Error())
 		// Redirect to dashboard with error message
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating device: "+err.Error())
 	}
 
-	// Insert new emergency device
-	err = a.DB.AddEmergencyDevice(emergencyDevice)
+	// Insert new emergency device, it is only saved with its webhook event
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		if err := tx.AddEmergencyDevice(emergencyDevice); err != nil {
+			a.handleLogger(c, "Error adding device: "+err.Error())
+			return rejected(err.Error())
+		}
+		return publishDeviceCreated(tx, emergencyDevice.EmergencyDeviceID)
+	})
+	var rejection rejected
+	if errors.As(err, &rejection) {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+string(rejection))
+	}
 	if err != nil {
-		a.handleLogger("Error adding device: " + err.Error())
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+err.Error())
+		return a.handleError(c, http.StatusInternalServerError, "Error adding device", err)
 	}
 
 	// Redirect to dashboard with success message
@@ -113,7 +154,7 @@ func (a *App) HandlePutDevice(c echo.Context) error {
 	// Parse form data from the request body
 	var device models.EmergencyDeviceDto
 	if err := c.Bind(&device); err != nil {
-		a.handleLogger("Error binding request body: " + err.Error())
+		a.handleLogger(c, "Error binding request body: "+err.Error())
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid request body",
 			"redirectURL": "/dashboard?error=Invalid request body",
@@ -123,26 +164,26 @@ func (a *App) HandlePutDevice(c echo.Context) error {
 	// Convert the device ID to an integer
 	deviceID, err := strconv.Atoi(deviceIDStr)
 	if err != nil {
-		a.handleLogger("Error converting device ID to integer: " + err.Error())
+		a.handleLogger(c, "Error converting device ID to integer: "+err.Error())
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid device ID",
 			"redirectURL": "/dashboard?error=Invalid device ID"})
 	}
 
 	// Log the incoming data
-	a.handleLogger("Device ID: " + deviceIDStr)
-	a.handleLogger("Room: " + device.RoomID)
-	a.handleLogger("Emergency Device Type: " + device.EmergencyDeviceTypeID)
-	a.handleLogger("Extinguisher Type: " + device.ExtinguisherTypeID)
-	a.handleLogger("Serial Number: " + device.SerialNumber)
-	a.handleLogger("Manufacture Date: " + device.ManufactureDate)
-	a.handleLogger("Size: " + device.Size)
-	a.handleLogger("Description: " + device.Description)
-	a.handleLogger("Status: " + device.Status)
+	a.Logger.DebugContext(c.Request().Context(), "Updating device",
+		"emergency_device_id", deviceIDStr,
+		"room_id", device.RoomID,
+		"emergency_device_type_id", device.EmergencyDeviceTypeID,
+		"extinguisher_type_id", device.ExtinguisherTypeID,
+		"serial_number", device.SerialNumber,
+		"manufacture_date", device.ManufactureDate,
+		"status", device.Status,
+	)
 
 	// Validate input
 	emergencyDevice, err := validateDevice(device.RoomID, device.EmergencyDeviceTypeID, device.ExtinguisherTypeID, device.SerialNumber, device.ManufactureDate, device.Size, device.Description, device.Status)
 	if err != nil {
-		a.handleLogger("Error validating device: " + err.Error())
+		a.handleLogger(c, "Error validating device: "+err.Error())
 		// Redirect to dashboard with error message
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Error validating device: " + err.Error(),
 			"redirectURL": "/dashboard?error=" + err.Error()})
@@ -151,10 +192,34 @@ func (a *App) HandlePutDevice(c echo.Context) error {
 	// Add the device ID to the emergency device model
 	emergencyDevice.EmergencyDeviceID = deviceID
 
-	// Update the device in the database
-	err = a.DB.UpdateEmergencyDevice(emergencyDevice)
+	// The status before the update, webhooks hear about it changing
+	existing, err := a.store(c).GetDeviceByID(deviceID)
 	if err != nil {
-		a.handleLogger("Error updating device: " + err.Error())
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Device not found",
+			"redirectURL": "/dashboard?error=Device not found"})
+	}
+
+	// The edit must be made from the current version of the device
+	version, ok := ifMatch(c, existing.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Device", "/dashboard", existing, existing.Version)
+	}
+	emergencyDevice.Version = version
+
+	// Update the device in the database with its webhook event, unless someone else changed it first
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		if err := tx.UpdateEmergencyDevice(emergencyDevice); err != nil {
+			return err
+		}
+		return publishStatusChange(tx, deviceID, existing.Status)
+	})
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetDeviceByID(deviceID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Device", "/dashboard", current, current.Version)
+		}
+	}
+	if err != nil {
+		a.handleLogger(c, "Error updating device: "+err.Error())
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error updating device: " + err.Error(),
 			"redirectURL": "/dashboard?error=" + err.Error()})
 	}
@@ -264,6 +329,7 @@ func parseDate(dateStr string) (sql.NullTime, error) {
 	return sql.NullTime{Time: parsedDate, Valid: true}, nil
 }
 
+// HandleDeleteDevice decommissions a device, the device and its inspection history are kept
 func (a *App) HandleDeleteDevice(c echo.Context) error {
 	// Check if request is not a DELETE request
 	if c.Request().Method != http.MethodDelete {
@@ -282,21 +348,171 @@ func (a *App) HandleDeleteDevice(c echo.Context) error {
 		})
 	}
 
-	// Delete the device from the database
-	err = a.DB.DeleteEmergencyDevice(deviceID)
+	// Validate the decommission reason
+	reason := strings.TrimSpace(c.QueryParam("reason"))
+	if reason == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Decommission reason is required",
+			"redirectURL": "/dashboard?error=Decommission reason is required",
+		})
+	}
+
+	if len(reason) > 255 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Decommission reason is too long, maximum 255 characters",
+			"redirectURL": "/dashboard?error=Decommission reason is too long, maximum 255 characters",
+		})
+	}
+
+	// The status before decommissioning, webhooks hear about it changing.
+	// Only the version the admin saw can be decommissioned.
+	var previousStatus sql.NullString
+	version := 0
+	if existing, err := a.store(c).GetDeviceByID(deviceID); err == nil {
+		var ok bool
+		if version, ok = ifMatch(c, existing.Version); !ok {
+			return versionConflict(c, http.StatusPreconditionFailed, "Device", "/dashboard", existing, existing.Version)
+		}
+		previousStatus = existing.Status
+	}
+
+	// Decommission the device in the database with its webhook event, unless someone else changed it first
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		if err := tx.DecommissionEmergencyDevice(deviceID, reason, version); err != nil {
+			return err
+		}
+		return publishStatusChange(tx, deviceID, previousStatus)
+	})
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetDeviceByID(deviceID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Device", "/dashboard", current, current.Version)
+		}
+	}
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Device not found or already decommissioned",
+			"redirectURL": "/dashboard?error=Device not found or already decommissioned",
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error deleting device",
-			"redirectURL": "/dashboard?error=Error deleting device: " + err.Error(),
+			"error":       "Error decommissioning device",
+			"redirectURL": "/dashboard?error=Error decommissioning device: " + err.Error(),
 		})
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
-		"message":     "Device deleted successfully",
-		"redirectURL": "/dashboard?message=Device deleted successfully",
+		"message":     "Device decommissioned successfully",
+		"redirectURL": "/dashboard?message=Device decommissioned successfully",
+	})
+}
+
+// HandleGetDecommissionedDevices fetches the decommissioned devices with the same filters as HandleGetAllDevices
+func (a *App) HandleGetDecommissionedDevices(c echo.Context) error {
+	siteId := c.QueryParam("site_id")
+	buildingCode := c.QueryParam("building_code")
+	floorId := c.QueryParam("floor_id")
+
+	emergencyDevices, err := a.store(c).GetDecommissionedDevices(siteId, buildingCode, floorId)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, emergencyDevices)
+}
+
+// DeviceHistoryExport is the record written to disk before a device is purged
+type DeviceHistoryExport struct {
+	ExportedAt         time.Time                  `json:"exported_at"`
+	Device             *models.EmergencyDevice    `json:"device"`
+	Inspections        []models.Inspection        `json:"inspections"`
+	MaintenanceRecords []models.MaintenanceRecord `json:"maintenance_records"`
+}
+
+// HandlePurgeDevice permanently deletes a decommissioned device after exporting its history
+func (a *App) HandlePurgeDevice(c echo.Context) error {
+	deviceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid device ID",
+			"redirectURL": "/dashboard?error=Invalid device ID",
+		})
+	}
+
+	device, err := a.store(c).GetDeviceByID(deviceID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Device not found",
+			"redirectURL": "/dashboard?error=Device not found",
+		})
+	}
+
+	// Only devices that have already been taken out of service can be purged
+	if !device.DecommissionedAt.Valid {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Device must be decommissioned before it can be purged",
+			"redirectURL": "/dashboard?error=Device must be decommissioned before it can be purged",
+		})
+	}
+
+	inspections, err := a.store(c).GetAllInspectionsByDeviceID(deviceID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching inspections", err)
+	}
+
+	maintenanceRecords, err := a.store(c).GetMaintenanceRecordsByDeviceID(deviceID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching maintenance records", err)
+	}
+
+	// Export the history before anything is removed
+	exportPath, err := exportDeviceHistory(device, inspections, maintenanceRecords)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error exporting device history", err)
+	}
+
+	a.handleLogger(c, "Device history exported to "+exportPath)
+
+	err = a.store(c).PurgeEmergencyDevice(deviceID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error purging device", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Device purged successfully",
+		"export_path": exportPath,
+		"redirectURL": "/dashboard?message=Device purged successfully",
 	})
 }
 
+// exportDeviceHistory writes the device, its inspections and maintenance records to a JSON file and returns its path
+func exportDeviceHistory(device *models.EmergencyDevice, inspections []models.Inspection, maintenanceRecords []models.MaintenanceRecord) (string, error) {
+	exportDir := "./exports/purged_devices"
+	if err := os.MkdirAll(exportDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	export := DeviceHistoryExport{
+		ExportedAt:         time.Now().UTC(),
+		Device:             device,
+		Inspections:        inspections,
+		MaintenanceRecords: maintenanceRecords,
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	fileName := filepath.Join(exportDir, fmt.Sprintf("device_%d_%s.json", device.EmergencyDeviceID, export.ExportedAt.Format("20060102T150405Z")))
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		return "", err
+	}
+
+	return fileName, nil
+}
+
 // HandlePutDeviceStatus
 
 func (a *App) HandlePutDeviceStatus(c echo.Context) error {
@@ -332,7 +548,7 @@ func (a *App) HandlePutDeviceStatus(c echo.Context) error {
 	}
 
 	// Validate device exists
-	device, err := a.DB.GetDeviceByID(deviceID)
+	device, err := a.store(c).GetDeviceByID(deviceID)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error":       "Device not found",
@@ -361,11 +577,15 @@ func (a *App) HandlePutDeviceStatus(c echo.Context) error {
 	}
 
 	// Log the incoming data
-	a.handleLogger("Device ID: " + deviceIDStr)
-	a.handleLogger("Status: " + req.Status)
+	a.Logger.DebugContext(c.Request().Context(), "Updating device status", "emergency_device_id", deviceIDStr, "status", req.Status)
 
-	// Update the device status in the database
-	err = a.DB.UpdateDeviceStatus(deviceID, req.Status)
+	// Update the device status in the database with its webhook event
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		if err := tx.UpdateDeviceStatus(deviceID, req.Status); err != nil {
+			return err
+		}
+		return publishStatusChange(tx, deviceID, device.Status)
+	})
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Failed to update device status",
@@ -375,3 +595,149 @@ func (a *App) HandlePutDeviceStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{
 		"message": "Device status updated successfully"})
 }
+
+// HandlePostReplaceDevice decommissions a device and creates its replacement in the same room
+func (a *App) HandlePostReplaceDevice(c echo.Context) error {
+	// Parse the device ID from the URL parameter
+	deviceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid device ID",
+			"redirectURL": "/dashboard?error=Invalid device ID",
+		})
+	}
+
+	// Parse the replacement details from the request body
+	var dto models.EmergencyDeviceReplacementDto
+	if err := c.Bind(&dto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/dashboard?error=Invalid request body",
+		})
+	}
+
+	replacement, reason, err := validateReplacement(dto)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Error validating replacement: " + err.Error(),
+			"redirectURL": "/dashboard?error=" + err.Error(),
+		})
+	}
+
+	// The status of the old device before it is decommissioned, webhooks hear about it changing.
+	// Only the version the admin saw can be replaced.
+	var previousStatus sql.NullString
+	version := 0
+	if existing, err := a.store(c).GetDeviceByID(deviceID); err == nil {
+		var ok bool
+		if version, ok = ifMatch(c, existing.Version); !ok {
+			return versionConflict(c, http.StatusPreconditionFailed, "Device", "/dashboard", existing, existing.Version)
+		}
+		previousStatus = existing.Status
+	}
+
+	// The replacement is saved with the webhook events of both devices, unless someone else changed the device first
+	var newDeviceID int
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		var err error
+		if newDeviceID, err = tx.ReplaceEmergencyDevice(deviceID, replacement, reason, version); err != nil {
+			return err
+		}
+		if err := publishStatusChange(tx, deviceID, previousStatus); err != nil {
+			return err
+		}
+		return publishDeviceCreated(tx, newDeviceID)
+	})
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetDeviceByID(deviceID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Device", "/dashboard", current, current.Version)
+		}
+	}
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Device not found",
+			"redirectURL": "/dashboard?error=Device not found",
+		})
+	}
+	if errors.Is(err, database.ErrDeviceDecommissioned) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Device has already been decommissioned",
+			"redirectURL": "/dashboard?error=Device has already been decommissioned",
+		})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error replacing device", err)
+	}
+
+	a.handleLogger(c, fmt.Sprintf("Device %d replaced by device %d", deviceID, newDeviceID))
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":               "Device replaced successfully",
+		"emergency_device_id":   newDeviceID,
+		"predecessor_device_id": deviceID,
+		"redirectURL":           "/dashboard?message=Device replaced successfully",
+	})
+}
+
+// validateReplacement checks the replacement details, empty optional fields are carried over from the old device
+func validateReplacement(dto models.EmergencyDeviceReplacementDto) (*models.EmergencyDevice, string, error) {
+	var device models.EmergencyDevice
+
+	reason := strings.TrimSpace(dto.Reason)
+	if reason == "" {
+		return nil, "", errors.New("replacement reason is required")
+	}
+
+	if len(reason) > 200 {
+		return nil, "", errors.New("replacement reason is too long, maximum 200 characters")
+	}
+
+	if len(dto.SerialNumber) > 50 {
+		return nil, "", errors.New("serial number is too long, maximum 50 characters")
+	}
+
+	if len(dto.Size) > 50 {
+		return nil, "", errors.New("size is too long, maximum 50 characters")
+	}
+
+	if dto.ExtinguisherTypeID != "" {
+		extinguisherTypeID, err := strconv.ParseInt(dto.ExtinguisherTypeID, 10, 32)
+		if err != nil {
+			return nil, "", errors.New("invalid extinguisher type ID")
+		}
+		device.ExtinguisherTypeID = sql.NullInt64{Int64: extinguisherTypeID, Valid: true}
+	}
+
+	manufactureDate, err := parseDate(dto.ManufactureDate)
+	if err != nil {
+		return nil, "", errors.New("invalid manufacture date format")
+	}
+
+	if manufactureDate.Valid && manufactureDate.Time.After(time.Now()) {
+		return nil, "", errors.New("manufacture date cannot be in the future")
+	}
+
+	device.SerialNumber = sql.NullString{String: dto.SerialNumber, Valid: dto.SerialNumber != ""}
+	device.Size = sql.NullString{String: dto.Size, Valid: dto.Size != ""}
+	device.ManufactureDate = manufactureDate
+
+	return &device, reason, nil
+}
+
+// HandleGetDeviceReplacementChain fetches every device in the replacement chain of a device, oldest first
+func (a *App) HandleGetDeviceReplacementChain(c echo.Context) error {
+	deviceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return a.handleError(c, http.StatusBadRequest, "Invalid device ID", err)
+	}
+
+	chain, err := a.store(c).GetDeviceReplacementChain(deviceID)
+	if err == sql.ErrNoRows {
+		return a.handleError(c, http.StatusNotFound, "Device not found", err)
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	return c.JSON(http.StatusOK, chain)
+}
@@ -1,11 +1,16 @@
 package app
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
 
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
@@ -15,7 +20,7 @@ func (a *App) HandleGetAllDeviceTypes(c echo.Context) error {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	emergencyDeviceTypes, err := a.DB.GetAllDeviceTypes()
+	emergencyDeviceTypes, err := a.store(c).GetAllDeviceTypes()
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -32,7 +37,7 @@ func (a *App) HandlePostDeviceType(c echo.Context) error {
 
 	//Pass device type name from form
 	deviceTypeName := c.FormValue("device_type_name")
-	a.handleLogger("Device Type Name: " + deviceTypeName)
+	a.Logger.DebugContext(c.Request().Context(), "Adding device type", "device_type_name", deviceTypeName)
 
 	//Validate device type name
 	deviceNameRegex := regexp.MustCompile("^[a-zA-Z0-9_ ]{1,50}$")
@@ -41,13 +46,25 @@ func (a *App) HandlePostDeviceType(c echo.Context) error {
 	}
 
 	//Check device type name is unique
-	if _, err := a.DB.GetDeviceTypeByName(deviceTypeName); err == nil {
+	if _, err := a.store(c).GetDeviceTypeByName(deviceTypeName); err == nil {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Device Type Name already exists")
 	}
 
-	err := a.DB.AddEmergencyDeviceType(deviceTypeName)
+	//Validate inspection and service intervals
+	inspectionInterval, serviceInterval, err := parseDeviceTypeIntervals(c.FormValue("inspection_interval_months"), c.FormValue("service_interval_months"))
 	if err != nil {
-		a.handleLogger("Error adding Device Type: " + err.Error())
+		return c.Redirect(http.StatusSeeOther, "/admin?error="+err.Error())
+	}
+
+	deviceType := &models.EmergencyDeviceType{
+		EmergencyDeviceTypeName:  deviceTypeName,
+		InspectionIntervalMonths: inspectionInterval,
+		ServiceIntervalMonths:    serviceInterval,
+	}
+
+	err = a.store(c).AddEmergencyDeviceType(deviceType)
+	if err != nil {
+		a.handleLogger(c, "Error adding Device Type: "+err.Error())
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Error adding device type")
 	}
 
@@ -80,10 +97,30 @@ func (a *App) HandlePutDeviceType(c echo.Context) error {
 		})
 	}
 
+	// Global device types are shared by every organisation
+	store, httpErr := a.deviceTypeStore(c, emergencyDeviceTypeID)
+	if httpErr != nil {
+		message := fmt.Sprint(httpErr.Message)
+		return c.JSON(httpErr.Code, map[string]string{
+			"error":       message,
+			"redirectURL": "/admin?error=" + message,
+		})
+	}
+
+	// The edit must be made from the current version of the device type
+	current, err := store.GetEmergencyDeviceTypeByID(emergencyDeviceTypeID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching device type", err)
+	}
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Device type", "/admin", current, current.Version)
+	}
+
 	var deviceTypeDto models.EmergencyDeviceTypeDto
 	// Parse the device type name from the from the request body
 	if err := c.Bind(&deviceTypeDto); err != nil {
-		a.handleLogger("Error parsing device type")
+		a.handleLogger(c, "Error parsing device type")
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid request payload",
 			"redirectURL": "/admin?error=Invalid request payload",
@@ -99,22 +136,40 @@ func (a *App) HandlePutDeviceType(c echo.Context) error {
 		})
 	}
 
-	//Check device type name is unique
-	if _, err := a.DB.GetDeviceTypeByName(deviceTypeDto.EmergencyDeviceTypeName); err == nil {
+	//Check device type name is unique, the type being edited may keep its own name
+	if existing, err := store.GetDeviceTypeByName(deviceTypeDto.EmergencyDeviceTypeName); err == nil && existing.EmergencyDeviceTypeID != emergencyDeviceTypeID {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Device Type Name already exists",
 			"redirectURL": "/admin?error=Device Type Name already exists",
 		})
 	}
 
+	//Validate inspection and service intervals
+	inspectionInterval, serviceInterval, err := parseDeviceTypeIntervals(deviceTypeDto.InspectionIntervalMonths, deviceTypeDto.ServiceIntervalMonths)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error(),
+		})
+	}
+
 	deviceType := &models.EmergencyDeviceType{
-		EmergencyDeviceTypeID:   emergencyDeviceTypeID,
-		EmergencyDeviceTypeName: deviceTypeDto.EmergencyDeviceTypeName,
+		EmergencyDeviceTypeID:    emergencyDeviceTypeID,
+		EmergencyDeviceTypeName:  deviceTypeDto.EmergencyDeviceTypeName,
+		InspectionIntervalMonths: inspectionInterval,
+		ServiceIntervalMonths:    serviceInterval,
+		Version:                  version,
 	}
 
-	err = a.DB.UpdateEmergencyDeviceType(deviceType)
+	// Update the device type, unless someone else changed it first
+	err = store.UpdateEmergencyDeviceType(deviceType)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := store.GetEmergencyDeviceTypeByID(emergencyDeviceTypeID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Device type", "/admin", current, current.Version)
+		}
+	}
 	if err != nil {
-		a.handleLogger("Error updating Device Type: " + err.Error())
+		a.handleLogger(c, "Error updating Device Type: "+err.Error())
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error updating device type",
 			"redirectURL": "/admin?error=Error updating device type",
@@ -136,18 +191,19 @@ func (a *App) HandleGetAllDeviceTypeByID(c echo.Context) error {
 	deviceTypeIDStr := c.Param("id")
 	deviceTypeID, err := strconv.Atoi(deviceTypeIDStr)
 	if err != nil {
-		a.handleLogger("Invalid Device Type ID")
+		a.handleLogger(c, "Invalid Device Type ID")
 		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Invalid Device Type ID"})
 	}
 
 	//Fetch the device type from the database
-	deviceType, err := a.DB.GetEmergencyDeviceTypeByID(deviceTypeID)
+	deviceType, err := a.store(c).GetEmergencyDeviceTypeByID(deviceTypeID)
 	if err != nil {
-		a.handleLogger("Error fetching Device Type")
+		a.handleLogger(c, "Error fetching Device Type")
 		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Error fetching Device Type"})
 	}
 
-	//return the result as JSON
+	//return the result as JSON, with the version edits must be made from
+	setETag(c, deviceType.Version)
 	return c.JSON(http.StatusOK, deviceType)
 }
 
@@ -169,8 +225,28 @@ func (a *App) HandleDeleteDeviceType(c echo.Context) error {
 		})
 	}
 
+	// Global device types are shared by every organisation
+	store, httpErr := a.deviceTypeStore(c, emergencyDeviceTypeID)
+	if httpErr != nil {
+		message := fmt.Sprint(httpErr.Message)
+		return c.JSON(httpErr.Code, map[string]string{
+			"error":       message,
+			"redirectURL": "/admin?error=" + message,
+		})
+	}
+
+	// Only the version the admin saw can be deleted
+	current, err := store.GetEmergencyDeviceTypeByID(emergencyDeviceTypeID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching device type", err)
+	}
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Device type", "/admin", current, current.Version)
+	}
+
 	//get devices by device type
-	emergencyDevices, err := a.DB.GetDevicesByTypeID(emergencyDeviceTypeID)
+	emergencyDevices, err := store.GetDevicesByTypeID(emergencyDeviceTypeID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error fetching emergency devices",
@@ -186,8 +262,13 @@ func (a *App) HandleDeleteDeviceType(c echo.Context) error {
 		})
 	}
 
-	// Delete the device type from the database
-	err = a.DB.DeleteEmergencyDeviceType(emergencyDeviceTypeID)
+	// Delete the device type from the database, unless someone else changed it first
+	err = store.DeleteEmergencyDeviceType(emergencyDeviceTypeID, version)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := store.GetEmergencyDeviceTypeByID(emergencyDeviceTypeID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Device type", "/admin", current, current.Version)
+		}
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error deleting device type",
@@ -200,3 +281,45 @@ func (a *App) HandleDeleteDeviceType(c echo.Context) error {
 		"redirectURL": "/admin?message=Device type deleted successfully",
 	})
 }
+
+// deviceTypeStore returns the store to change a device type with. Organisations change their own types,
+// global types are shared by every organisation so only the default admin can change them.
+func (a *App) deviceTypeStore(c echo.Context, emergencyDeviceTypeID int) (database.Store, *echo.HTTPError) {
+	deviceType, err := a.store(c).GetEmergencyDeviceTypeByID(emergencyDeviceTypeID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Device type not found")
+	}
+	if deviceType.OrganisationID.Valid {
+		return a.store(c), nil
+	}
+
+	claims := c.Get("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	if claims["default_admin"] != true {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "Only the default admin can change device types shared by every organisation")
+	}
+	return a.DB, nil
+}
+
+// parseDeviceTypeIntervals validates the inspection and service intervals of a device type.
+// The inspection interval defaults to 3 months, a blank service interval means the type is not serviced.
+func parseDeviceTypeIntervals(inspectionIntervalStr, serviceIntervalStr string) (int, sql.NullInt64, error) {
+	inspectionInterval := database.DefaultInspectionIntervalMonths
+	if inspectionIntervalStr != "" {
+		interval, err := strconv.Atoi(inspectionIntervalStr)
+		if err != nil || interval < 1 || interval > 120 {
+			return 0, sql.NullInt64{}, errors.New("Inspection interval must be between 1 and 120 months")
+		}
+		inspectionInterval = interval
+	}
+
+	var serviceInterval sql.NullInt64
+	if serviceIntervalStr != "" {
+		interval, err := strconv.Atoi(serviceIntervalStr)
+		if err != nil || interval < 1 || interval > 120 {
+			return 0, sql.NullInt64{}, errors.New("Service interval must be between 1 and 120 months")
+		}
+		serviceInterval = sql.NullInt64{Int64: int64(interval), Valid: true}
+	}
+
+	return inspectionInterval, serviceInterval, nil
+}
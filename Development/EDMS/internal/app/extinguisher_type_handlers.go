@@ -12,7 +12,7 @@ func (a *App) HandleGetAllExtinguisherTypes(c echo.Context) error {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	extinguisherTypes, err := a.DB.GetAllExtinguisherTypes()
+	extinguisherTypes, err := a.store(c).GetAllExtinguisherTypes()
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
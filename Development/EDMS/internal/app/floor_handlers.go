@@ -0,0 +1,211 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// HandleGetFloors fetches the floors of a building, lowest floor first
+func (a *App) HandleGetFloors(c echo.Context) error {
+	buildingID, err := strconv.Atoi(c.QueryParam("buildingId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid building ID"})
+	}
+
+	floors, err := a.store(c).GetFloorsByBuildingID(buildingID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching floors", err)
+	}
+
+	return c.JSON(http.StatusOK, floors)
+}
+
+// HandlePostFloor adds a floor to a building
+func (a *App) HandlePostFloor(c echo.Context) error {
+	var floorDto models.FloorDto
+	if err := c.Bind(&floorDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request data",
+			"redirectURL": "/admin?error=Invalid request data",
+		})
+	}
+
+	buildingID, err := strconv.Atoi(floorDto.BuildingID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid building ID",
+			"redirectURL": "/admin?error=Invalid building ID",
+		})
+	}
+
+	if _, err := a.store(c).GetBuildingById(buildingID); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Building does not exist",
+			"redirectURL": "/admin?error=Building does not exist",
+		})
+	}
+
+	floor, errorMessage := validateFloor(floorDto)
+	if errorMessage != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       errorMessage,
+			"redirectURL": "/admin?error=" + errorMessage,
+		})
+	}
+	floor.BuildingID = buildingID
+
+	floorID, err := a.store(c).AddFloor(&floor)
+	if err != nil {
+		a.handleLogger(c, "Error adding floor: "+err.Error())
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Error adding floor, the building may already have a floor on this level",
+			"redirectURL": "/admin?error=Error adding floor, the building may already have a floor on this level",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":     "Floor added successfully",
+		"floor_id":    floorID,
+		"redirectURL": "/admin?message=Floor added successfully",
+	})
+}
+
+// HandlePutFloor renames or renumbers a floor
+func (a *App) HandlePutFloor(c echo.Context) error {
+	floorID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid floor ID",
+			"redirectURL": "/admin?error=Invalid floor ID",
+		})
+	}
+
+	current, err := a.store(c).GetFloorByID(floorID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Floor does not exist",
+			"redirectURL": "/admin?error=Floor does not exist",
+		})
+	}
+
+	// The edit must be made from the current version of the floor
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Floor", "/admin", current, current.Version)
+	}
+
+	var floorDto models.FloorDto
+	if err := c.Bind(&floorDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request data",
+			"redirectURL": "/admin?error=Invalid request data",
+		})
+	}
+
+	floor, errorMessage := validateFloor(floorDto)
+	if errorMessage != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       errorMessage,
+			"redirectURL": "/admin?error=" + errorMessage,
+		})
+	}
+	floor.FloorID = floorID
+	floor.Version = version
+
+	err = a.store(c).UpdateFloor(&floor)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetFloorByID(floorID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Floor", "/admin", current, current.Version)
+		}
+	}
+	if err != nil {
+		a.handleLogger(c, "Error updating floor: "+err.Error())
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Error updating floor, the building may already have a floor on this level",
+			"redirectURL": "/admin?error=Error updating floor, the building may already have a floor on this level",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Floor updated successfully",
+		"redirectURL": "/admin?message=Floor updated successfully",
+	})
+}
+
+// HandleDeleteFloor deletes an empty floor together with its floor plan
+func (a *App) HandleDeleteFloor(c echo.Context) error {
+	floorID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid floor ID",
+			"redirectURL": "/admin?error=Invalid floor ID",
+		})
+	}
+
+	current, err := a.store(c).GetFloorByID(floorID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Floor does not exist",
+			"redirectURL": "/admin?error=Floor does not exist",
+		})
+	}
+
+	// Only the version the admin saw can be deleted
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Floor", "/admin", current, current.Version)
+	}
+
+	// Handle foreign key constraints, check if the floor has any rooms
+	rooms, err := a.store(c).GetAllRooms("", strconv.Itoa(floorID))
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching rooms", err)
+	}
+	if len(rooms) > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Can't delete floor with associated rooms",
+			"redirectURL": "/admin?error=Can't delete floor with associated rooms",
+		})
+	}
+
+	// Archived rooms keep their floor, so a floor that had rooms cannot be deleted
+	err = a.store(c).DeleteFloor(floorID, version)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetFloorByID(floorID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Floor", "/admin", current, current.Version)
+		}
+	}
+	if err != nil {
+		a.handleLogger(c, "Error deleting floor: "+err.Error())
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Can't delete floor with archived rooms",
+			"redirectURL": "/admin?error=Can't delete floor with archived rooms",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Floor deleted successfully",
+		"redirectURL": "/admin?message=Floor deleted successfully",
+	})
+}
+
+// validateFloor checks the name and level of a floor, returning the error to show
+func validateFloor(floorDto models.FloorDto) (models.Floor, string) {
+	floorName := strings.TrimSpace(floorDto.FloorName)
+	if floorName == "" || len(floorName) > 50 {
+		return models.Floor{}, "Floor name must be between 1 and 50 characters long"
+	}
+
+	floorLevel, err := strconv.Atoi(strings.TrimSpace(floorDto.FloorLevel))
+	if err != nil {
+		return models.Floor{}, "Floor level must be a whole number"
+	}
+
+	return models.Floor{FloorName: floorName, FloorLevel: floorLevel}, ""
+}
@@ -0,0 +1,331 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// floorPlanDir is where uploaded floor plan images are stored
+const floorPlanDir = "./static/floor_plans"
+
+// Pin colours match the status badges on the dashboard
+const (
+	pinColourActive   = "#198754"
+	pinColourWarning  = "#ffc107"
+	pinColourFailed   = "#dc3545"
+	pinColourInactive = "#6c757d"
+)
+
+// HandleGetFloorPlans fetches the floor plans of a building
+func (a *App) HandleGetFloorPlans(c echo.Context) error {
+	buildingID, err := strconv.Atoi(c.QueryParam("buildingId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid building ID"})
+	}
+
+	floorPlans, err := a.store(c).GetFloorPlansByBuildingID(buildingID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching floor plans", err)
+	}
+
+	return c.JSON(http.StatusOK, floorPlans)
+}
+
+// HandlePostFloorPlan uploads a floor plan image for a floor of a building.
+// The floor is chosen by floor_id, or by building_id, floor_label and floor_level, adding the floor if the building does not have it yet.
+func (a *App) HandlePostFloorPlan(c echo.Context) error {
+	floor, errorMessage := a.floorPlanFloor(c)
+	if errorMessage != "" {
+		return c.Redirect(http.StatusSeeOther, "/admin?error="+errorMessage)
+	}
+
+	file, header, err := c.Request().FormFile("floorPlanImgInput")
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=A floor plan image is required")
+	}
+	defer file.Close()
+
+	fileExt := strings.ToLower(filepath.Ext(header.Filename))
+	allowedExtensions := map[string]bool{".svg": true, ".png": true}
+	if !allowedExtensions[fileExt] {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Invalid file type. Allowed types: svg, png")
+	}
+
+	if err := os.MkdirAll(floorPlanDir, os.ModePerm); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error creating floor plan directory", err)
+	}
+
+	// Name the file after the building and floor, with a timestamp so a replaced plan is not served from cache
+	fileName := fmt.Sprintf("building_%d_floor_%d_%d%s", floor.BuildingID, floor.FloorLevel, time.Now().Unix(), fileExt)
+	if err := saveUploadedFile(header, filepath.Join(floorPlanDir, fileName)); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error saving floor plan image", err)
+	}
+
+	floorPlan := &models.FloorPlan{
+		FloorID:   floor.FloorID,
+		ImagePath: "/static/floor_plans/" + fileName,
+	}
+
+	_, err = a.store(c).AddFloorPlan(floorPlan)
+	if err != nil {
+		os.Remove(filepath.Join(floorPlanDir, fileName))
+		a.handleLogger(c, "Error adding floor plan: "+err.Error())
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Error adding floor plan, the floor may already have a plan")
+	}
+
+	return c.Redirect(http.StatusFound, "/admin?message=Floor plan added successfully")
+}
+
+// floorPlanFloor returns the floor a floor plan is uploaded for, or the error to show
+func (a *App) floorPlanFloor(c echo.Context) (*models.Floor, string) {
+	if floorID := c.FormValue("floor_id"); floorID != "" {
+		floorIDInt, err := strconv.Atoi(floorID)
+		if err != nil {
+			return nil, "Invalid floor ID"
+		}
+		floor, err := a.store(c).GetFloorByID(floorIDInt)
+		if err != nil {
+			return nil, "Invalid floor ID"
+		}
+		return floor, ""
+	}
+
+	buildingID, err := strconv.Atoi(c.FormValue("building_id"))
+	if err != nil {
+		return nil, "Invalid building ID"
+	}
+
+	building, err := a.store(c).GetBuildingById(buildingID)
+	if err != nil {
+		return nil, "Invalid building ID"
+	}
+
+	floorLabel := strings.TrimSpace(c.FormValue("floor_label"))
+	if floorLabel == "" || len(floorLabel) > 50 {
+		return nil, "Floor label must be between 1 and 50 characters long"
+	}
+
+	floorLevel, err := strconv.Atoi(c.FormValue("floor_level"))
+	if err != nil {
+		return nil, "Floor level must be a whole number"
+	}
+
+	floors, err := a.store(c).GetFloorsByBuildingID(building.BuildingID)
+	if err != nil {
+		a.handleLogger(c, "Error fetching floors: "+err.Error())
+		return nil, "Error fetching floors"
+	}
+	for _, floor := range floors {
+		if floor.FloorLevel == floorLevel {
+			return &floor, ""
+		}
+	}
+
+	floor := models.Floor{BuildingID: building.BuildingID, FloorName: floorLabel, FloorLevel: floorLevel}
+	floor.FloorID, err = a.store(c).AddFloor(&floor)
+	if err != nil {
+		a.handleLogger(c, "Error adding floor: "+err.Error())
+		return nil, "Error adding floor"
+	}
+
+	return &floor, ""
+}
+
+// HandleDeleteFloorPlan deletes a floor plan, its pins and its image
+func (a *App) HandleDeleteFloorPlan(c echo.Context) error {
+	floorPlanID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid floor plan ID",
+			"redirectURL": "/admin?error=Invalid floor plan ID",
+		})
+	}
+
+	floorPlan, err := a.store(c).GetFloorPlanByID(floorPlanID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Floor plan not found",
+			"redirectURL": "/admin?error=Floor plan not found",
+		})
+	}
+
+	if err := a.store(c).DeleteFloorPlan(floorPlanID); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error deleting floor plan", err)
+	}
+
+	if err := os.Remove("." + floorPlan.ImagePath); err != nil {
+		a.handleLogger(c, "Error deleting floor plan image: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Floor plan deleted successfully",
+		"redirectURL": "/admin?message=Floor plan deleted successfully",
+	})
+}
+
+// HandleGetFloorPlanPins fetches the pins of a floor plan with their live status colours
+func (a *App) HandleGetFloorPlanPins(c echo.Context) error {
+	floorPlanID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid floor plan ID"})
+	}
+
+	pins, err := a.store(c).GetFloorPlanPins(floorPlanID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching floor plan pins", err)
+	}
+
+	for i := range pins {
+		pins[i].StatusColour = pinStatusColour(pins[i].Status)
+	}
+
+	return c.JSON(http.StatusOK, pins)
+}
+
+// HandlePostFloorPlanPin places a room or a device on a floor plan
+func (a *App) HandlePostFloorPlanPin(c echo.Context) error {
+	floorPlanID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid floor plan ID"})
+	}
+
+	floorPlan, err := a.store(c).GetFloorPlanByID(floorPlanID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Floor plan not found"})
+	}
+
+	var pinDto models.FloorPlanPinDto
+	if err := c.Bind(&pinDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+
+	if !validPinPosition(pinDto.X, pinDto.Y) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Pin position must be between 0 and 1"})
+	}
+
+	pin := &models.FloorPlanPin{
+		FloorPlanID: floorPlanID,
+		X:           pinDto.X,
+		Y:           pinDto.Y,
+	}
+
+	// A pin marks either a room or a device, which must be in the floor plan's building
+	switch {
+	case pinDto.RoomID != "" && pinDto.EmergencyDeviceID != "":
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A pin marks either a room or a device, not both"})
+	case pinDto.RoomID != "":
+		roomID, err := strconv.Atoi(pinDto.RoomID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid room ID"})
+		}
+		room, err := a.store(c).GetRoomByID(roomID)
+		if err != nil || room.BuildingID != floorPlan.BuildingID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Room is not in the floor plan's building"})
+		}
+		pin.RoomID = sql.NullInt64{Int64: int64(roomID), Valid: true}
+	case pinDto.EmergencyDeviceID != "":
+		deviceID, err := strconv.Atoi(pinDto.EmergencyDeviceID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid device ID"})
+		}
+		device, err := a.store(c).GetDeviceByID(deviceID)
+		if err != nil || device.BuildingID != floorPlan.BuildingID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Device is not in the floor plan's building"})
+		}
+		if device.DecommissionedAt.Valid {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Cannot place a decommissioned device"})
+		}
+		pin.EmergencyDeviceID = sql.NullInt64{Int64: int64(deviceID), Valid: true}
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A room or device is required"})
+	}
+
+	floorPlanPinID, err := a.store(c).AddFloorPlanPin(pin)
+	if err != nil {
+		a.handleLogger(c, "Error adding floor plan pin: "+err.Error())
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Error adding pin, it may already be on this floor plan"})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{
+		"message":           "Pin added successfully",
+		"floor_plan_pin_id": strconv.Itoa(floorPlanPinID),
+	})
+}
+
+// HandlePutFloorPlanPin moves a pin on its floor plan
+func (a *App) HandlePutFloorPlanPin(c echo.Context) error {
+	floorPlanPinID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid pin ID"})
+	}
+
+	var pinDto models.FloorPlanPinDto
+	if err := c.Bind(&pinDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+
+	if !validPinPosition(pinDto.X, pinDto.Y) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Pin position must be between 0 and 1"})
+	}
+
+	err = a.store(c).MoveFloorPlanPin(floorPlanPinID, pinDto.X, pinDto.Y)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pin not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error moving pin", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Pin moved successfully"})
+}
+
+// HandleDeleteFloorPlanPin removes a pin from its floor plan
+func (a *App) HandleDeleteFloorPlanPin(c echo.Context) error {
+	floorPlanPinID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid pin ID"})
+	}
+
+	err = a.store(c).DeleteFloorPlanPin(floorPlanPinID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Pin not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error deleting pin", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Pin deleted successfully"})
+}
+
+// validPinPosition checks the position is inside the floor plan image
+func validPinPosition(x float64, y float64) bool {
+	return x >= 0 && x <= 1 && y >= 0 && y <= 1
+}
+
+// pinStatusColour returns the marker colour for a device or room status
+func pinStatusColour(status sql.NullString) string {
+	if !status.Valid {
+		return pinColourInactive
+	}
+
+	switch status.String {
+	case "Active":
+		return pinColourActive
+	case "Inspection Failed":
+		return pinColourFailed
+	case "Inactive", "Decommissioned":
+		return pinColourInactive
+	default:
+		// Expired, Inspection Due and any other status needs attention
+		return pinColourWarning
+	}
+}
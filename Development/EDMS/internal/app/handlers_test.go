@@ -0,0 +1,1696 @@
+package app_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/app"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/logging"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJWTSecret = "test-secret"
+
+const testMetricsToken = "test-metrics-token"
+
+// testApp is an App on a memory store holding a site with one building, room and fire extinguisher
+type testApp struct {
+	*app.App
+	Store    *database.MemoryStore
+	UserID   int
+	SiteID   int
+	RoomID   int
+	DeviceID int
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	store := database.NewMemoryStore()
+
+	require.NoError(t, store.AddSite(&models.Site{SiteName: "Taradale", SiteAddress: "501 Gloucester Street"}))
+	site, err := store.GetSiteByName("Taradale")
+	require.NoError(t, err)
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: site.SiteID, BuildingCode: "A"}))
+	building, err := store.GetBuildingByCodeandSite("A", site.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: building.BuildingID, RoomCode: "A101"}))
+	room, err := store.GetRoomByCodeAndBuilding("A101", building.BuildingID)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddEmergencyDeviceType(&models.EmergencyDeviceType{
+		EmergencyDeviceTypeName:  "Fire Extinguisher",
+		InspectionIntervalMonths: 3,
+		ServiceIntervalMonths:    sql.NullInt64{Int64: 12, Valid: true},
+	}))
+	deviceType, err := store.GetDeviceTypeByName("Fire Extinguisher")
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddEmergencyDevice(&models.EmergencyDevice{
+		EmergencyDeviceTypeID: deviceType.EmergencyDeviceTypeID,
+		RoomID:                room.RoomID,
+		SerialNumber:          sql.NullString{String: "SN1", Valid: true},
+		ManufactureDate:       sql.NullTime{Time: time.Date(2024, time.August, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		Status:                sql.NullString{String: "Active", Valid: true},
+	}))
+	devices, err := store.GetDevicesByRoomID(room.RoomID)
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+
+	require.NoError(t, store.CreateUser(&models.User{Username: "admin", Password: "hash", Email: "admin@example.com"}))
+	user, err := store.GetUserByUsername("admin")
+	require.NoError(t, err)
+	user.Role = "Admin"
+	require.NoError(t, store.UpdateUser(user))
+
+	return &testApp{
+		App:      app.NewAppWithStore(store, testConfig()),
+		Store:    store,
+		UserID:   user.UserID,
+		SiteID:   site.SiteID,
+		RoomID:   room.RoomID,
+		DeviceID: devices[0].EmergencyDeviceID,
+	}
+}
+
+// testConfig is the default configuration with the test signing key
+func testConfig() config.Config {
+	cfg := config.Default()
+	cfg.JWTSecret = testJWTSecret
+	cfg.MetricsToken = testMetricsToken
+	return cfg
+}
+
+// token signs a login token for the default organisation like GenerateToken does
+func token(t *testing.T, userID int, role string, defaultAdmin bool) string {
+	t.Helper()
+	return organisationToken(t, userID, role, defaultAdmin, models.DefaultOrganisationID)
+}
+
+// organisationToken signs a login token for an organisation
+func organisationToken(t *testing.T, userID int, role string, defaultAdmin bool, organisationID int) string {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":         strconv.Itoa(userID),
+		"username":        "admin",
+		"email":           "admin@example.com",
+		"role":            role,
+		"default_admin":   defaultAdmin,
+		"organisation_id": organisationID,
+		"exp":             time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testJWTSecret))
+	require.NoError(t, err)
+
+	return signed
+}
+
+// serve sends a request through the router, logged in with the given token if it is not empty
+func (a *testApp) serve(method string, target string, contentType string, body string, loginToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if loginToken != "" {
+		req.AddCookie(&http.Cookie{Name: "token", Value: loginToken})
+	}
+
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestProtectedRoutesRequireLogin(t *testing.T) {
+	a := newTestApp(t)
+
+	rec := a.serve(http.MethodGet, "/api/emergency-device", "", "", "")
+
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/", rec.Header().Get("Location"))
+}
+
+func TestAdminRoutesRequireAdmin(t *testing.T) {
+	a := newTestApp(t)
+
+	rec := a.serve(http.MethodPut, "/api/emergency-device/"+strconv.Itoa(a.DeviceID)+"/status",
+		"application/json", `{"status": "Expired"}`, token(t, a.UserID, "User", false))
+
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "/dashboard?error=")
+
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Active", device.Status.String)
+}
+
+func TestHandleGetAllDevices(t *testing.T) {
+	a := newTestApp(t)
+
+	rec := a.serve(http.MethodGet, "/api/emergency-device?building_code=A", "", "", token(t, a.UserID, "User", false))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var devices []models.EmergencyDevice
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &devices))
+	require.Len(t, devices, 1)
+	assert.Equal(t, "SN1", devices[0].SerialNumber.String)
+	assert.Equal(t, "A101", devices[0].RoomCode)
+	assert.Equal(t, config.DefaultTimeZone, devices[0].SiteTimeZone)
+	// Devices expire at midnight at their site, Auckland is UTC+12 in August
+	assert.True(t, devices[0].ExpireDate.Time.Equal(time.Date(2029, time.July, 31, 12, 0, 0, 0, time.UTC)))
+
+	rec = a.serve(http.MethodGet, "/api/emergency-device?building_code=B", "", "", token(t, a.UserID, "User", false))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "null", strings.TrimSpace(rec.Body.String()), "an unknown building has no devices")
+}
+
+func TestHandleFloors(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+	userToken := token(t, a.UserID, "User", false)
+
+	room, err := a.Store.GetRoomByID(a.RoomID)
+	require.NoError(t, err)
+	buildingID := strconv.Itoa(room.BuildingID)
+
+	rec := a.serve(http.MethodPost, "/api/floor", "application/json", `{"building_id": "`+buildingID+`", "floor_name": "Level 1", "floor_level": "1"}`, userToken)
+	assert.Equal(t, http.StatusSeeOther, rec.Code, "only admins manage floors")
+
+	rec = a.serve(http.MethodPost, "/api/floor", "application/json", `{"building_id": "`+buildingID+`", "floor_name": "Level 1", "floor_level": "one"}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = a.serve(http.MethodPost, "/api/floor", "application/json", `{"building_id": "`+buildingID+`", "floor_name": "Level 1", "floor_level": "1"}`, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = a.serve(http.MethodGet, "/api/floor?buildingId="+buildingID, "", "", userToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var floors []models.Floor
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &floors))
+	require.Len(t, floors, 2)
+	assert.Equal(t, "Ground", floors[0].FloorName)
+	upperID := strconv.Itoa(floors[1].FloorID)
+
+	// Move the room upstairs, the devices follow it
+	rec = a.serveIfMatch(http.MethodPut, "/api/room/"+strconv.Itoa(a.RoomID), `{"room_code": "A101", "building_id": "`+buildingID+`", "floor_id": "`+upperID+`"}`, etag(room.Version), adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = a.serve(http.MethodGet, "/api/emergency-device?floor_id="+upperID, "", "", userToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var devices []models.EmergencyDevice
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &devices))
+	require.Len(t, devices, 1)
+	assert.Equal(t, "Level 1", devices[0].FloorName)
+
+	rec = a.serve(http.MethodGet, "/api/emergency-device?floor_id="+strconv.Itoa(floors[0].FloorID), "", "", userToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[]", strings.TrimSpace(rec.Body.String()), "the ground floor has no devices left")
+
+	rec = a.serveIfMatch(http.MethodDelete, "/api/floor/"+upperID, "", etag(floors[1].Version), adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code, "floors with rooms cannot be deleted")
+
+	rec = a.serveIfMatch(http.MethodDelete, "/api/floor/"+strconv.Itoa(floors[0].FloorID), "", etag(floors[0].Version), adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestHandleInspectionRounds(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+	userToken := token(t, a.UserID, "User", false)
+
+	room, err := a.Store.GetRoomByID(a.RoomID)
+	require.NoError(t, err)
+	round := `{"site_id": "` + strconv.Itoa(room.SiteID) + `", "building_id": "` + strconv.Itoa(room.BuildingID) + `", "period_start": "2024-08-01", "period_end": "2024-08-31"}`
+
+	rec := a.serve(http.MethodPost, "/api/inspection-round", "application/json", round, userToken)
+	assert.Equal(t, http.StatusSeeOther, rec.Code, "only admins plan rounds")
+
+	rec = a.serve(http.MethodPost, "/api/inspection-round", "application/json", round, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created struct {
+		InspectionRoundID int `json:"inspection_round_id"`
+		Devices           int `json:"devices"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, 1, created.Devices, "a device that has never been inspected is due")
+	roundTarget := "/api/inspection-round/" + strconv.Itoa(created.InspectionRoundID)
+
+	rec = a.serve(http.MethodPost, "/api/inspection-round", "application/json", round, adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code, "the building already has a round for the period")
+
+	rec = a.serve(http.MethodPut, roundTarget+"/inspector", "application/json", `{"user_id": "`+strconv.Itoa(a.UserID)+`"}`, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = a.serve(http.MethodGet, "/api/inspection-round?mine=true", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var rounds []models.InspectionRound
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rounds))
+	require.Len(t, rounds, 1)
+	assert.Equal(t, 1, rounds[0].Remaining)
+
+	// Posting an inspection in the period completes the device
+	form := url.Values{
+		"inspection_datetime": {"2024-08-15T10:00"},
+		"device_id":           {strconv.Itoa(a.DeviceID)},
+		"user_id":             {strconv.Itoa(a.UserID)},
+		"inspection_status":   {"Passed"},
+	}
+	rec = a.serve(http.MethodPost, "/api/inspection", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	require.NotContains(t, rec.Header().Get("Location"), "error", rec.Header().Get("Location"))
+
+	rec = a.serve(http.MethodGet, roundTarget, "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var progress models.InspectionRound
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &progress))
+	assert.Equal(t, 1, progress.Inspected)
+	require.Len(t, progress.Devices, 1)
+	assert.Equal(t, models.RoundDeviceInspected, progress.Devices[0].Status)
+
+	rec = a.serve(http.MethodPut, roundTarget+"/device/"+strconv.Itoa(a.DeviceID)+"/skip", "application/json", `{"reason": ""}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "skipping needs a reason")
+	rec = a.serve(http.MethodPut, roundTarget+"/device/"+strconv.Itoa(a.DeviceID)+"/skip", "application/json", `{"reason": "Room locked"}`, adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code, "inspected devices cannot be skipped")
+
+	// Generating a month's rounds twice plans each building once
+	var generated struct {
+		Added int `json:"added"`
+	}
+	rec = a.serve(http.MethodPost, "/api/inspection-round/generate", "application/json", `{"month": "2024-11"}`, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &generated))
+	assert.Equal(t, 1, generated.Added)
+	rec = a.serve(http.MethodPost, "/api/inspection-round/generate", "application/json", `{"month": "2024-11"}`, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &generated))
+	assert.Equal(t, 0, generated.Added)
+}
+
+func TestHandleCalendarFeed(t *testing.T) {
+	a := newTestApp(t)
+	userToken := token(t, a.UserID, "User", false)
+
+	require.NoError(t, a.Store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  a.DeviceID,
+		UserID:             a.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Now().AddDate(0, 0, -1), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+
+	rec := a.serve(http.MethodPost, "/api/calendar/feed", "", "", userToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created struct {
+		URL string `json:"url"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	link, err := url.Parse(created.URL)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(link.Path, ".ics"))
+
+	// Calendar apps subscribe without logging in
+	rec = a.serve(http.MethodGet, link.Path, "", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "text/calendar; charset=utf-8", rec.Header().Get("Content-Type"))
+	calendar := rec.Body.String()
+	assert.True(t, strings.HasPrefix(calendar, "BEGIN:VCALENDAR\r\n"))
+	assert.Equal(t, 1, strings.Count(calendar, "BEGIN:VEVENT"), "the inspection is due in three months")
+	assert.Contains(t, calendar, "SUMMARY:Fire Extinguisher (SN1) inspection due in A A101")
+	assert.Contains(t, calendar, "LOCATION:Taradale\\, A A101")
+
+	rec = a.serve(http.MethodGet, link.Path+"?site_id=999", "", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "BEGIN:VEVENT", "other sites have no devices")
+
+	// A new link replaces the old one
+	rec = a.serve(http.MethodPost, "/api/calendar/feed", "", "", userToken)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	replaced, err := url.Parse(created.URL)
+	require.NoError(t, err)
+	rec = a.serve(http.MethodGet, link.Path, "", "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = a.serve(http.MethodDelete, "/api/calendar/feed", "", "", userToken)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	rec = a.serve(http.MethodGet, replaced.Path, "", "", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "revoked links stop working")
+}
+
+func TestHandleSync(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+	userToken := token(t, a.UserID, "User", false)
+
+	rec := a.serve(http.MethodGet, "/api/sync", "", "", userToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var changes struct {
+		Cursor    string                   `json:"cursor"`
+		Devices   []models.EmergencyDevice `json:"devices"`
+		Rooms     []models.Room            `json:"rooms"`
+		Checklist []models.ChecklistItem   `json:"checklist"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &changes))
+	require.Len(t, changes.Devices, 1)
+	assert.Equal(t, a.RoomID, changes.Devices[0].RoomID)
+	require.Len(t, changes.Rooms, 1)
+	assert.Len(t, changes.Checklist, len(models.InspectionChecklist))
+	cursor := changes.Cursor
+
+	for _, invalid := range []string{"yesterday", "2026-10-19T09:00:00Z", "-1"} {
+		rec = a.serve(http.MethodGet, "/api/sync?cursor="+url.QueryEscape(invalid), "", "", userToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "cursors are what an earlier sync returned, not times")
+	}
+
+	deviceID := strconv.Itoa(a.DeviceID)
+	capturedAt := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	batch := `{"inspections": [
+		{"client_id": "0B7F2A8E-3C1D-4E5F-9A6B-7C8D9E0F1A2B", "emergency_device_id": ` + deviceID + `, "captured_at": "` + capturedAt + `",
+		 "inspection_status": "Failed", "notes": "Pin missing", "checklist": {"is_accessible": true}},
+		{"client_id": "not-a-uuid", "emergency_device_id": ` + deviceID + `, "captured_at": "` + capturedAt + `", "inspection_status": "Passed"},
+		{"client_id": "1b7f2a8e-3c1d-4e5f-9a6b-7c8d9e0f1a2b", "emergency_device_id": ` + deviceID + `, "captured_at": "` + capturedAt + `",
+		 "inspection_status": "Passed", "checklist": {"is_shiny": true}}
+	]}`
+
+	rec = a.serve(http.MethodPost, "/api/sync/inspections", "application/json", batch, userToken)
+	assert.Equal(t, http.StatusSeeOther, rec.Code, "only admins record inspections")
+
+	var response struct {
+		Results []models.SyncResult `json:"results"`
+	}
+	rec = a.serve(http.MethodPost, "/api/sync/inspections", "application/json", batch, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	require.Len(t, response.Results, 3)
+	assert.Equal(t, models.SyncCreated, response.Results[0].Status)
+	assert.Equal(t, models.SyncRejected, response.Results[1].Status)
+	assert.Equal(t, models.SyncRejected, response.Results[2].Status)
+	assert.Contains(t, response.Results[2].Error, "is_shiny")
+	inspectionID := response.Results[0].InspectionID
+
+	inspection, err := a.Store.GetInspectionByID(inspectionID)
+	require.NoError(t, err)
+	assert.Equal(t, a.UserID, inspection.UserID, "the inspection is recorded for the logged in inspector")
+	assert.True(t, inspection.IsAccessible.Bool)
+	assert.False(t, inspection.IsConspicuous.Bool)
+
+	// Sending the batch again records nothing twice
+	rec = a.serve(http.MethodPost, "/api/sync/inspections", "application/json", batch, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, models.SyncDuplicate, response.Results[0].Status)
+	assert.Equal(t, inspectionID, response.Results[0].InspectionID)
+	inspections, err := a.Store.GetAllInspectionsByDeviceID(a.DeviceID)
+	require.NoError(t, err)
+	assert.Len(t, inspections, 1)
+
+	rec = a.serve(http.MethodGet, "/api/sync?cursor="+url.QueryEscape(cursor), "", "", userToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &changes))
+	require.Len(t, changes.Devices, 1, "the inspection changed the device status")
+	assert.Equal(t, "Inspection Failed", changes.Devices[0].Status.String)
+	assert.Empty(t, changes.Rooms)
+}
+
+// allowLocalWebhooks lets the app's webhooks be sent to test receivers on the loopback address,
+// as WEBHOOKS_ALLOW_LOCAL does
+func allowLocalWebhooks(a *testApp) {
+	a.Config.WebhooksAllowLocal = true
+	a.Webhooks.AllowLocal = true
+}
+
+func TestHandleWebhooks(t *testing.T) {
+	a := newTestApp(t)
+	allowLocalWebhooks(a)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	// A local receiver that fails while failing is set
+	var received []string
+	failing := false
+	var secret string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, app.VerifyWebhookSignature(secret, r.Header.Get("X-EDMS-Signature"), body, time.Now()))
+		if failing {
+			http.Error(w, "ticketing is down", http.StatusServiceUnavailable)
+			return
+		}
+
+		var payload struct {
+			EventID   string `json:"event_id"`
+			EventType string `json:"event_type"`
+		}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, r.Header.Get("X-EDMS-Event"), payload.EventType)
+		assert.Equal(t, r.Header.Get("X-EDMS-Event-ID"), payload.EventID)
+		received = append(received, payload.EventType)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	rec := a.serve(http.MethodPost, "/api/webhook", "application/json", `{"url": "ftp://example.com", "event_types": ["device.created"]}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = a.serve(http.MethodPost, "/api/webhook", "application/json", `{"url": "`+receiver.URL+`", "event_types": ["device.deleted"]}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = a.serve(http.MethodPost, "/api/webhook", "application/json", `{"url": "`+receiver.URL+`", "event_types": `+
+		`["device.created", "device.status_changed", "inspection.created", "inspection.failed", "workorder.created"]}`, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created struct {
+		WebhookID int    `json:"webhook_id"`
+		Secret    string `json:"secret"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	require.Len(t, created.Secret, 64)
+	secret = created.Secret
+
+	rec = a.serve(http.MethodGet, "/api/webhook", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), secret, "the secret is only shown when the webhook is added")
+
+	// A failed inspection that needs a work order
+	form := url.Values{
+		"inspection_datetime": {time.Now().Add(-time.Hour).In(a.Config.Location()).Format("2006-01-02T15:04")},
+		"device_id":           {strconv.Itoa(a.DeviceID)},
+		"user_id":             {strconv.Itoa(a.UserID)},
+		"inspection_status":   {"Failed"},
+		"workOrderRequired":   {"on"},
+	}
+	rec = a.serve(http.MethodPost, "/api/inspection", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	require.NotContains(t, rec.Header().Get("Location"), "error", rec.Header().Get("Location"))
+
+	assert.Empty(t, received, "events are sent from the outbox, not by the request")
+	claimed, err := a.Webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 4, claimed)
+	assert.Equal(t, []string{"inspection.created", "inspection.failed", "workorder.created", "device.status_changed"}, received)
+
+	// A failed delivery is retried with backoff
+	failing = true
+	form = url.Values{
+		"room_id":               {strconv.Itoa(a.RoomID)},
+		"emergency_device_type": {"1"},
+		"serial_number":         {"SN2"},
+		"status":                {"Active"},
+	}
+	rec = a.serve(http.MethodPost, "/api/emergency-device", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	require.Equal(t, http.StatusFound, rec.Code, rec.Header().Get("Location"))
+	_, err = a.Webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+
+	failing = false
+	claimed, err = a.Webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, claimed, "the retry waits")
+	a.Webhooks.Now = func() time.Time { return time.Now().Add(time.Minute) }
+	claimed, err = a.Webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, claimed)
+	assert.Equal(t, "device.created", received[len(received)-1])
+
+	rec = a.serve(http.MethodGet, "/api/webhook/"+strconv.Itoa(created.WebhookID)+"/delivery?limit=1", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var deliveries []models.WebhookDelivery
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.EventDeviceCreated, deliveries[0].EventType)
+	assert.Equal(t, models.DeliveryDelivered, deliveries[0].Status)
+	require.Len(t, deliveries[0].Log, 2)
+	assert.Equal(t, int64(http.StatusServiceUnavailable), deliveries[0].Log[0].ResponseStatus.Int64)
+	assert.Contains(t, deliveries[0].Log[0].Error.String, "ticketing is down")
+	assert.Equal(t, int64(http.StatusNoContent), deliveries[0].Log[1].ResponseStatus.Int64)
+
+	rec = a.serve(http.MethodPost, "/api/webhook-delivery/"+strconv.Itoa(deliveries[0].WebhookDeliveryID)+"/redeliver", "", "", adminToken)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	claimed, err = a.Webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, claimed)
+
+	webhook, err := a.Store.GetWebhookByID(created.WebhookID)
+	require.NoError(t, err)
+	rec = a.serveIfMatch(http.MethodDelete, "/api/webhook/"+strconv.Itoa(created.WebhookID), "", etag(webhook.Version), adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestWebhooksRefuseLocalAddresses(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	received := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		_, _ = w.Write([]byte("internal details"))
+	}))
+	defer receiver.Close()
+
+	for _, target := range []string{
+		receiver.URL,
+		"http://localhost:9000/",
+		"http://api.localhost/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/",
+		"http://192.168.1.10:8080/",
+		"http://[::1]/",
+		"http://[::ffff:127.0.0.1]/",
+		"http://0.0.0.0/",
+	} {
+		rec := a.serve(http.MethodPost, "/api/webhook", "application/json", `{"url": "`+target+`", "event_types": ["device.created"]}`, adminToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+		assert.Contains(t, rec.Body.String(), "public address", target)
+	}
+
+	// A name that resolves to a loopback address gets past the URL check, the connection is refused
+	_, err := a.Store.AddWebhook(&models.Webhook{
+		URL:        strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1),
+		Secret:     "secret",
+		EventTypes: []string{models.EventDeviceCreated},
+		IsActive:   true,
+	})
+	require.NoError(t, err)
+	form := url.Values{
+		"room_id":               {strconv.Itoa(a.RoomID)},
+		"emergency_device_type": {"1"},
+		"serial_number":         {"SN2"},
+		"status":                {"Active"},
+	}
+	rec := a.serve(http.MethodPost, "/api/emergency-device", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	require.Equal(t, http.StatusFound, rec.Code, rec.Header().Get("Location"))
+
+	claimed, err := a.Webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, claimed)
+	assert.Zero(t, received, "nothing reaches the local receiver")
+
+	webhooks, err := a.Store.GetAllWebhooks()
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	deliveries, err := a.Store.GetWebhookDeliveries(webhooks[0].WebhookID, 1)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Len(t, deliveries[0].Log, 1)
+	assert.False(t, deliveries[0].Log[0].ResponseStatus.Valid)
+	assert.Contains(t, deliveries[0].Log[0].Error.String, "local or private address")
+	assert.NotContains(t, deliveries[0].Log[0].Error.String, "internal details")
+
+	// Allowed for testing with webhooks receive
+	allowLocalWebhooks(a)
+	rec = a.serve(http.MethodPost, "/api/webhook", "application/json", `{"url": "http://localhost:9000/", "event_types": ["device.created"]}`, adminToken)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+}
+
+func TestStatusSweeper(t *testing.T) {
+	a := newTestApp(t)
+	allowLocalWebhooks(a)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	received := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-EDMS-Event")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	rec := a.serve(http.MethodPost, "/api/webhook", "application/json", `{"url": "`+receiver.URL+`", "event_types": ["device.status_changed"]}`, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	// The fixture's fire extinguisher has expired by then, the running sweeper notices without anyone asking
+	a.Statuses.Now = func() time.Time { return time.Date(2029, time.August, 2, 0, 0, 0, 0, time.UTC) }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Statuses.Run(ctx, time.Hour)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		device, err := a.Store.GetDeviceByID(a.DeviceID)
+		return err == nil && device.Status.String == "Expired"
+	}, 5*time.Second, 10*time.Millisecond, "the sweeper runs when it starts")
+	cancel()
+	<-done
+
+	// The status change was queued with its event, which the dispatcher sends
+	assert.Empty(t, received, "the sweeper does not send webhooks itself")
+	claimed, err := a.Webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, claimed)
+	assert.Equal(t, models.EventDeviceStatusChanged, <-received)
+
+	// A sweep with nothing new to report changes nothing
+	changed, err := a.Statuses.Sweep()
+	require.NoError(t, err)
+	assert.Equal(t, 0, changed)
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	payload := []byte(`{"event_type":"device.created"}`)
+	sentAt := time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC)
+	signature := app.SignWebhookPayload("secret", sentAt, payload)
+
+	assert.NoError(t, app.VerifyWebhookSignature("secret", signature, payload, sentAt.Add(time.Minute)))
+	assert.Error(t, app.VerifyWebhookSignature("other", signature, payload, sentAt), "wrong secret")
+	assert.Error(t, app.VerifyWebhookSignature("secret", signature, []byte(`{}`), sentAt), "changed payload")
+	assert.Error(t, app.VerifyWebhookSignature("secret", signature, payload, sentAt.Add(time.Hour)), "replayed later")
+	assert.Error(t, app.VerifyWebhookSignature("secret", "v1=abc", payload, sentAt), "no timestamp")
+}
+
+func TestHandlePutDeviceStatus(t *testing.T) {
+	a := newTestApp(t)
+	target := "/api/emergency-device/" + strconv.Itoa(a.DeviceID) + "/status"
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	rec := a.serve(http.MethodPut, target, "application/json", `{"status": "Active"}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "only Inspection Due and Expired can be set by hand")
+
+	rec = a.serve(http.MethodPut, target, "application/json", `{"status": "Expired"}`, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Expired", device.Status.String)
+
+	rec = a.serve(http.MethodPut, "/api/emergency-device/999/status", "application/json", `{"status": "Expired"}`, adminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandlePostReplaceDevice(t *testing.T) {
+	a := newTestApp(t)
+	target := "/api/emergency-device/" + strconv.Itoa(a.DeviceID) + "/replace"
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	rec := a.serveIfMatch(http.MethodPost, target, `{"reason": "Expired"}`, etag(1), token(t, a.UserID, "User", false))
+	assert.Equal(t, http.StatusSeeOther, rec.Code, "only admins replace devices")
+
+	for _, invalid := range []string{
+		`{"reason": " "}`,
+		`{"reason": "Expired", "manufacture_date": "2999-01-01"}`,
+		`{"reason": "Expired", "manufacture_date": "last year"}`,
+		`{"reason": "Expired", "extinguisher_type": "CO2"}`,
+		`{"reason": "Expired", "serial_number": "` + strings.Repeat("9", 51) + `"}`,
+	} {
+		rec = a.serveIfMatch(http.MethodPost, target, invalid, etag(1), adminToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, invalid)
+	}
+
+	rec = a.serveIfMatch(http.MethodPost, "/api/emergency-device/999/replace", `{"reason": "Expired"}`, etag(1), adminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.False(t, device.DecommissionedAt.Valid, "refused replacements leave the device in service")
+
+	rec = a.serveIfMatch(http.MethodPost, target, `{"reason": "Expired", "serial_number": "SN2", "manufacture_date": "2025-01-20"}`, etag(device.Version), adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var replaced struct {
+		EmergencyDeviceID   int `json:"emergency_device_id"`
+		PredecessorDeviceID int `json:"predecessor_device_id"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &replaced))
+	assert.Equal(t, a.DeviceID, replaced.PredecessorDeviceID)
+
+	replacement, err := a.Store.GetDeviceByID(replaced.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "SN2", replacement.SerialNumber.String)
+	assert.Equal(t, a.RoomID, replacement.RoomID, "the replacement goes in the same room")
+	device, err = a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.True(t, device.DecommissionedAt.Valid)
+	assert.Contains(t, device.DecommissionReason.String, "Expired")
+
+	rec = a.serveIfMatch(http.MethodPost, target, `{"reason": "Again"}`, etag(device.Version), adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code, "a decommissioned device cannot be replaced again")
+}
+
+func TestHandleGetDeviceReplacementChain(t *testing.T) {
+	a := newTestApp(t)
+	userToken := token(t, a.UserID, "User", false)
+
+	replacementID, err := a.Store.ReplaceEmergencyDevice(a.DeviceID, &models.EmergencyDevice{}, "Expired", 0)
+	require.NoError(t, err)
+
+	// Every user can see the history, from either end of the chain
+	for _, deviceID := range []int{a.DeviceID, replacementID} {
+		rec := a.serve(http.MethodGet, "/api/emergency-device/"+strconv.Itoa(deviceID)+"/chain", "", "", userToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var chain []models.EmergencyDevice
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &chain))
+		require.Len(t, chain, 2)
+		assert.Equal(t, a.DeviceID, chain[0].EmergencyDeviceID, "the chain is ordered oldest first")
+		assert.Equal(t, replacementID, chain[1].EmergencyDeviceID)
+	}
+
+	rec := a.serve(http.MethodGet, "/api/emergency-device/SN1/chain", "", "", userToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = a.serve(http.MethodGet, "/api/emergency-device/999/chain", "", "", userToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandlePostMaintenanceRecord(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	form := url.Values{
+		"emergency_device_id": {strconv.Itoa(a.DeviceID)},
+		"service_type":        {models.ServiceTypeRecharge},
+		"service_date":        {"2025-01-20"},
+		"provider":            {"Fire Co"},
+		"next_service_date":   {"2026-01-20"},
+	}
+
+	rec := a.serve(http.MethodPost, "/api/maintenance", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	records, err := a.Store.GetMaintenanceRecordsByDeviceID(a.DeviceID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, a.UserID, records[0].UserID, "the record is made by the logged in user")
+	assert.Equal(t, "admin", records[0].RecordedBy)
+
+	form.Set("service_type", "Polish")
+	rec = a.serve(http.MethodPost, "/api/maintenance", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	require.NoError(t, a.Store.DecommissionEmergencyDevice(a.DeviceID, "Removed", 0))
+	form.Set("service_type", models.ServiceTypeService)
+	rec = a.serve(http.MethodPost, "/api/maintenance", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestHandlePurgeDeviceRequiresDefaultAdmin(t *testing.T) {
+	a := newTestApp(t)
+	require.NoError(t, a.Store.DecommissionEmergencyDevice(a.DeviceID, "Removed", 0))
+
+	rec := a.serve(http.MethodDelete, "/api/emergency-device/"+strconv.Itoa(a.DeviceID)+"/purge", "", "", token(t, a.UserID, "Admin", false))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	_, err := a.Store.GetDeviceByID(a.DeviceID)
+	assert.NoError(t, err, "the device is kept")
+}
+
+func TestOrganisationIsolation(t *testing.T) {
+	a := newTestApp(t)
+	otherID, err := a.Store.AddOrganisation("Other")
+	require.NoError(t, err)
+	require.NoError(t, a.Store.AddUserToOrganisation(a.UserID, otherID))
+	otherToken := organisationToken(t, a.UserID, "Admin", false, otherID)
+	deviceURL := "/api/emergency-device/" + strconv.Itoa(a.DeviceID)
+
+	rec := a.serve(http.MethodGet, "/api/emergency-device", "", "", otherToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "null", strings.TrimSpace(rec.Body.String()), "other organisations' devices are not listed")
+
+	rec = a.serve(http.MethodGet, deviceURL, "", "", otherToken)
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+
+	rec = a.serve(http.MethodPut, deviceURL+"/status", "application/json", `{"status": "Expired"}`, otherToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Active", device.Status.String)
+
+	// The fixture's device type was added unscoped, so it is shared by every organisation
+	deviceType, err := a.Store.GetDeviceTypeByName("Fire Extinguisher")
+	require.NoError(t, err)
+	rec = a.serveIfMatch(http.MethodDelete, "/api/emergency-device-type/"+strconv.Itoa(deviceType.EmergencyDeviceTypeID), "", etag(deviceType.Version), otherToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "only the default admin changes global device types")
+}
+
+func TestRegisteredUsersJoinNoOrganisation(t *testing.T) {
+	a := newTestApp(t)
+
+	form := url.Values{
+		"username":         {"newcomer"},
+		"email":            {"newcomer@example.com"},
+		"password":         {"Passw0rd!"},
+		"confirm-password": {"Passw0rd!"},
+	}
+	rec := a.serve(http.MethodPost, "/register", "application/x-www-form-urlencoded", form.Encode(), "")
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+
+	user, err := a.Store.GetUserByUsername("newcomer")
+	require.NoError(t, err)
+	organisations, err := a.Store.GetUserOrganisations(user.UserID)
+	require.NoError(t, err)
+	assert.Empty(t, organisations, "self-registered users see no organisation's data")
+	_, err = a.Store.ForOrganisation(models.DefaultOrganisationID).GetUserByID(user.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestTokensWithoutOrganisation(t *testing.T) {
+	a := newTestApp(t)
+
+	for name, organisationID := range map[string]interface{}{"missing": nil, "malformed": "1", "zero": 0, "fraction": 1.5} {
+		claims := jwt.MapClaims{
+			"user_id": strconv.Itoa(a.UserID),
+			"role":    "Admin",
+			"exp":     time.Now().Add(time.Hour).Unix(),
+		}
+		if organisationID != nil {
+			claims["organisation_id"] = organisationID
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+		require.NoError(t, err)
+
+		rec := a.serve(http.MethodGet, "/api/site", "", "", signed)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, name)
+		assert.NotContains(t, rec.Body.String(), "Taradale", name)
+	}
+}
+
+func TestSharedUserIsolation(t *testing.T) {
+	a := newTestApp(t)
+	otherID, err := a.Store.AddOrganisation("Other")
+	require.NoError(t, err)
+
+	// An admin of only the other organisation, and a user of both
+	other := a.Store.ForOrganisation(otherID)
+	require.NoError(t, other.CreateUser(&models.User{Username: "other_admin", Password: "hash", Email: "other@example.com", Role: "Admin"}))
+	otherAdmin, err := other.GetUserByUsername("other_admin")
+	require.NoError(t, err)
+	require.NoError(t, a.Store.CreateUser(&models.User{Username: "shared_user", Password: "hash", Email: "shared@example.com"}))
+	shared, err := a.Store.GetUserByUsername("shared_user")
+	require.NoError(t, err)
+	require.NoError(t, a.Store.AddUserToOrganisation(shared.UserID, otherID))
+
+	userURL := "/api/user/" + strconv.Itoa(shared.UserID)
+	body := `{"username": "shared_user", "email": "attacker@example.com", "role": "Admin", "password": "Taken0ver!", "confirm_password": "Taken0ver!"}`
+	rec := a.serveIfMatch(http.MethodPut, userURL, body, etag(shared.Version), organisationToken(t, otherAdmin.UserID, "Admin", false, otherID))
+	assert.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	user, err := a.Store.GetUserByID(shared.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "shared@example.com", user.Email)
+	assert.Equal(t, "hash", user.Password)
+
+	// An admin of both organisations can change them
+	require.NoError(t, a.Store.AddUserToOrganisation(a.UserID, otherID))
+	rec = a.serveIfMatch(http.MethodPut, userURL, body, etag(shared.Version), organisationToken(t, a.UserID, "Admin", false, otherID))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	user, err = a.Store.GetUserByID(shared.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "attacker@example.com", user.Email)
+}
+
+func TestRemovedMembersLoseAccess(t *testing.T) {
+	a := newTestApp(t)
+	otherID, err := a.Store.AddOrganisation("Other")
+	require.NoError(t, err)
+	require.NoError(t, a.Store.AddUserToOrganisation(a.UserID, otherID))
+	otherToken := organisationToken(t, a.UserID, "Admin", false, otherID)
+
+	rec := a.serve(http.MethodGet, "/api/site", "", "", otherToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// The token is still valid, the membership it was issued for is not
+	require.NoError(t, a.Store.RemoveUserFromOrganisation(a.UserID, otherID))
+	rec = a.serve(http.MethodGet, "/api/site", "", "", otherToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = a.serve(http.MethodGet, "/api/site", "", "", token(t, a.UserID, "Admin", false))
+	assert.Equal(t, http.StatusOK, rec.Code, "their other organisations are not affected")
+}
+
+func TestSwitchOrganisation(t *testing.T) {
+	a := newTestApp(t)
+	otherID, err := a.Store.AddOrganisation("Other")
+	require.NoError(t, err)
+	strangerID, err := a.Store.AddOrganisation("Stranger")
+	require.NoError(t, err)
+	require.NoError(t, a.Store.AddUserToOrganisation(a.UserID, otherID))
+	loginToken := token(t, a.UserID, "Admin", false)
+
+	rec := a.serve(http.MethodGet, "/api/organisation", "", "", loginToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Current       int                   `json:"current"`
+		Organisations []models.Organisation `json:"organisations"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, models.DefaultOrganisationID, body.Current)
+	require.Len(t, body.Organisations, 2)
+
+	rec = a.serve(http.MethodPost, "/api/organisation/"+strconv.Itoa(strangerID)+"/switch", "", "", loginToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "users can only switch to their own organisations")
+
+	rec = a.serve(http.MethodPost, "/api/organisation/"+strconv.Itoa(otherID)+"/switch", "", "", loginToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	rec = a.serve(http.MethodGet, "/api/site", "", "", cookies[0].Value)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "Taradale", "the new token works in the other organisation")
+}
+
+func TestHandleFloorPlanPins(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	room, err := a.Store.GetRoomByID(a.RoomID)
+	require.NoError(t, err)
+	floorPlanID, err := a.Store.AddFloorPlan(&models.FloorPlan{FloorID: room.FloorID, ImagePath: "/static/floor_plans/a0.svg"})
+	require.NoError(t, err)
+	pinsURL := "/api/floor-plan/" + strconv.Itoa(floorPlanID) + "/pins"
+
+	rec := a.serve(http.MethodPost, pinsURL, "application/json", `{"room_id": "`+strconv.Itoa(a.RoomID)+`", "x": 0.25, "y": 0.5}`, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var created map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	pinURL := "/api/floor-plan-pin/" + created["floor_plan_pin_id"]
+
+	rec = a.serve(http.MethodPost, pinsURL, "application/json", `{"room_id": "`+strconv.Itoa(a.RoomID)+`", "x": 0.3, "y": 0.3}`, adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code, "a room is pinned once per floor plan")
+
+	rec = a.serve(http.MethodPut, pinURL, "application/json", `{"x": 1.5, "y": 0.5}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = a.serve(http.MethodPut, pinURL, "application/json", `{"x": 0.75, "y": 0.5}`, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.NoError(t, a.Store.UpdateDeviceStatus(a.DeviceID, "Expired"))
+
+	rec = a.serve(http.MethodGet, pinsURL, "", "", token(t, a.UserID, "User", false))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var pins []models.FloorPlanPin
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pins))
+	require.Len(t, pins, 1)
+	assert.InDelta(t, 0.75, pins[0].X, 1e-9)
+	assert.Equal(t, "Expired", pins[0].Status.String)
+	assert.Equal(t, "#ffc107", pins[0].StatusColour)
+
+	rec = a.serve(http.MethodDelete, pinURL, "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = a.serve(http.MethodDelete, pinURL, "", "", adminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRequestIDInLogsAndErrors(t *testing.T) {
+	a := newTestApp(t)
+
+	var logs bytes.Buffer
+	logger, err := logging.New(&logs, "json", slog.LevelInfo)
+	require.NoError(t, err)
+	a.Logger = logger
+
+	req := httptest.NewRequest(http.MethodGet, "/api/emergency-device/abc?token=secret", nil)
+	req.Header.Set(echo.HeaderXRequestID, "req-42")
+	req.AddCookie(&http.Cookie{Name: "token", Value: token(t, a.UserID, "User", false)})
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "req-42", rec.Header().Get(echo.HeaderXRequestID))
+
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "Invalid device ID", body["error"])
+	assert.Equal(t, "req-42", body["request_id"])
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2, "the handler error and the request are logged")
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, "req-42", entry["request_id"])
+		assert.Equal(t, "WARN", entry["level"])
+	}
+	assert.NotContains(t, logs.String(), "secret", "query strings are not logged")
+
+	rec = a.serve(http.MethodGet, "/no-such-page", "", "", token(t, a.UserID, "Admin", true))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	body = nil
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.NotEmpty(t, body["request_id"], "an ID is generated when the client sends none")
+	assert.Equal(t, rec.Header().Get(echo.HeaderXRequestID), body["request_id"])
+}
+
+// scrape gets /metrics the way Prometheus does, with the bearer token
+func (a *testApp) scrape(bearer string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if bearer != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMetricsRequireToken(t *testing.T) {
+	a := newTestApp(t)
+
+	rec := a.scrape("")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer realm="metrics"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+	assert.NotContains(t, rec.Body.String(), "edms_devices")
+
+	assert.Equal(t, http.StatusUnauthorized, a.scrape("wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, a.serve(http.MethodGet, "/metrics", "", "", token(t, a.UserID, "Admin", true)).Code,
+		"a login is not a metrics token")
+	assert.Equal(t, http.StatusOK, a.scrape(testMetricsToken).Code)
+
+	a.Config.MetricsToken = ""
+	assert.Equal(t, http.StatusNotFound, a.scrape("").Code, "metrics are not served without a token set")
+}
+
+func TestMetrics(t *testing.T) {
+	a := newTestApp(t)
+
+	require.NoError(t, a.Store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  a.DeviceID,
+		UserID:             a.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Date(2024, time.September, 1, 10, 0, 0, 0, time.UTC), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+
+	loginToken := token(t, a.UserID, "Admin", false)
+	require.Equal(t, http.StatusOK, a.serve(http.MethodGet, "/api/emergency-device/"+strconv.Itoa(a.DeviceID), "", "", loginToken).Code)
+	require.Equal(t, http.StatusBadRequest, a.serve(http.MethodGet, "/api/emergency-device/abc", "", "", loginToken).Code)
+
+	rec := a.scrape(testMetricsToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	metrics := rec.Body.String()
+
+	assert.Contains(t, metrics, `edms_http_requests_total{code="200",method="GET",route="/api/emergency-device/:id"} 1`)
+	assert.Contains(t, metrics, `edms_http_requests_total{code="400",method="GET",route="/api/emergency-device/:id"} 1`,
+		"errors returned by handlers are counted with their status")
+	assert.Contains(t, metrics, `edms_http_request_duration_seconds_count{method="GET",route="/api/emergency-device/:id"} 2`)
+	labels := `organisation_id="` + strconv.Itoa(models.DefaultOrganisationID) + `",site_id="` + strconv.Itoa(a.SiteID) + `"`
+	assert.Contains(t, metrics, `edms_devices{device_type="Fire Extinguisher",`+labels+`,status="Active"} 1`)
+	assert.Contains(t, metrics, `edms_devices_inspection_overdue{`+labels+`} 1`)
+	assert.Contains(t, metrics, `edms_devices_expiring_soon{`+labels+`} 0`)
+	assert.Contains(t, metrics, `edms_inspections_recorded_last_day 1`)
+}
+
+func TestMetricsPerOrganisation(t *testing.T) {
+	a := newTestApp(t)
+
+	// Another organisation with a site of the same name and two devices
+	otherID, err := a.Store.AddOrganisation("Other")
+	require.NoError(t, err)
+	other := a.Store.ForOrganisation(otherID)
+	require.NoError(t, other.AddSite(&models.Site{SiteName: "Taradale"}))
+	site, err := other.GetSiteByName("Taradale")
+	require.NoError(t, err)
+	require.NoError(t, other.AddBuilding(&models.Building{SiteID: site.SiteID, BuildingCode: "A"}))
+	building, err := other.GetBuildingByCodeandSite("A", site.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, other.AddRoom(&models.Room{BuildingID: building.BuildingID, RoomCode: "A101"}))
+	room, err := other.GetRoomByCodeAndBuilding("A101", building.BuildingID)
+	require.NoError(t, err)
+	deviceType, err := a.Store.GetDeviceTypeByName("Fire Extinguisher")
+	require.NoError(t, err)
+	for _, serialNumber := range []string{"SN2", "SN3"} {
+		require.NoError(t, other.AddEmergencyDevice(&models.EmergencyDevice{
+			EmergencyDeviceTypeID: deviceType.EmergencyDeviceTypeID,
+			RoomID:                room.RoomID,
+			SerialNumber:          sql.NullString{String: serialNumber, Valid: true},
+			Status:                sql.NullString{String: "Active", Valid: true},
+		}))
+	}
+
+	rec := a.scrape(testMetricsToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	metrics := rec.Body.String()
+
+	defaultLabels := `organisation_id="` + strconv.Itoa(models.DefaultOrganisationID) + `",site_id="` + strconv.Itoa(a.SiteID) + `"`
+	otherLabels := `organisation_id="` + strconv.Itoa(otherID) + `",site_id="` + strconv.Itoa(site.SiteID) + `"`
+	assert.Contains(t, metrics, `edms_devices{device_type="Fire Extinguisher",`+defaultLabels+`,status="Active"} 1`)
+	assert.Contains(t, metrics, `edms_devices{device_type="Fire Extinguisher",`+otherLabels+`,status="Active"} 2`,
+		"sites of the same name in two organisations are not added up")
+	assert.Contains(t, metrics, `edms_devices_expiring_soon{`+otherLabels+`} 0`)
+	assert.NotContains(t, metrics, "Taradale", "organisation and site names are not exposed")
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	a := newTestApp(t)
+
+	rec := a.serve(http.MethodGet, "/healthz", "", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code, "probes do not need a login")
+
+	rec = a.serve(http.MethodGet, "/readyz", "", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	a.AddReadinessCheck("database", func(ctx context.Context) error { return nil })
+	a.AddReadinessCheck("uploads", func(ctx context.Context) error { return errors.New("read-only file system") })
+
+	rec = a.serve(http.MethodGet, "/readyz", "", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "not ready", body.Status)
+	assert.Equal(t, map[string]string{"database": "ok", "uploads": "read-only file system"}, body.Checks)
+
+	a.AddReadinessCheck("uploads", func(ctx context.Context) error { return nil })
+	assert.Equal(t, http.StatusOK, a.serve(http.MethodGet, "/readyz", "", "", "").Code)
+
+	a.StartDraining()
+	rec = a.serve(http.MethodGet, "/readyz", "", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "draining")
+	assert.Equal(t, http.StatusOK, a.serve(http.MethodGet, "/healthz", "", "", "").Code, "a draining server is still alive")
+}
+
+func TestSiteTimeZones(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	addSite := func(name string, timeZone string) *httptest.ResponseRecorder {
+		t.Helper()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		require.NoError(t, form.WriteField("addSiteName", name))
+		require.NoError(t, form.WriteField("addSiteAddress", "1 Park Row"))
+		require.NoError(t, form.WriteField("addSiteTimeZone", timeZone))
+		require.NoError(t, form.Close())
+		return a.serve(http.MethodPost, "/api/site", form.FormDataContentType(), body.String(), adminToken)
+	}
+
+	rec := addSite("Leeds", "Europe/London")
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	leeds, err := a.Store.GetSiteByName("Leeds")
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/London", leeds.TimeZone)
+
+	rec = addSite("Napier", "")
+	require.Equal(t, http.StatusFound, rec.Code)
+	napier, err := a.Store.GetSiteByName("Napier")
+	require.NoError(t, err)
+	assert.Equal(t, config.DefaultTimeZone, napier.TimeZone, "new sites get the configured time zone")
+
+	rec = addSite("Olympus", "Mars/Olympus")
+	assert.Contains(t, rec.Header().Get("Location"), "Unknown time zone")
+	_, err = a.Store.GetSiteByName("Olympus")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Inspection times are entered in the time zone of the device's site
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	site, err := a.Store.GetSiteByID(strconv.Itoa(device.SiteID))
+	require.NoError(t, err)
+	site.TimeZone = "Europe/London"
+	require.NoError(t, a.Store.UpdateSite(site))
+
+	form := url.Values{
+		"inspection_datetime": {"2024-08-15T23:30"},
+		"device_id":           {strconv.Itoa(a.DeviceID)},
+		"user_id":             {strconv.Itoa(a.UserID)},
+		"inspection_status":   {"Passed"},
+	}
+	rec = a.serve(http.MethodPost, "/api/inspection", "application/x-www-form-urlencoded", form.Encode(), adminToken)
+	require.Equal(t, http.StatusSeeOther, rec.Code)
+	require.NotContains(t, rec.Header().Get("Location"), "error", rec.Header().Get("Location"))
+
+	inspected, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.True(t, inspected.LastInspectionDateTime.Time.Equal(time.Date(2024, time.August, 15, 22, 30, 0, 0, time.UTC)),
+		"23:30 in London in summer is 22:30 UTC, got %s", inspected.LastInspectionDateTime.Time)
+}
+
+func TestSiteMapFiles(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	// Site maps are saved under the working directory
+	workDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { os.Chdir(workDir) })
+
+	postSite := func(target string, fields map[string]string, image string) *httptest.ResponseRecorder {
+		t.Helper()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, value := range fields {
+			require.NoError(t, form.WriteField(name, value))
+		}
+		if image != "" {
+			part, err := form.CreateFormFile("siteMapImgInput", "map.png")
+			require.NoError(t, err)
+			_, err = part.Write([]byte(image))
+			require.NoError(t, err)
+		}
+		require.NoError(t, form.Close())
+		return a.serve(http.MethodPost, target, form.FormDataContentType(), body.String(), adminToken)
+	}
+
+	rec := postSite("/api/site", map[string]string{"addSiteName": "Leeds", "addSiteAddress": "1 Park Row"}, "leeds map")
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	rec = postSite("/api/site", map[string]string{"addSiteName": "York", "addSiteAddress": "1 Station Road"}, "")
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	leeds, err := a.Store.GetSiteByName("Leeds")
+	require.NoError(t, err)
+	york, err := a.Store.GetSiteByName("York")
+	require.NoError(t, err)
+
+	// An edit that is not saved leaves the files alone, even when its image has the name of another site's
+	rec = postSite("/api/site/"+strconv.Itoa(york.SiteID), map[string]string{
+		"editSiteID": strconv.Itoa(york.SiteID), "editSiteName": "Leeds", "editSiteAddress": "1 Station Road",
+		"editSiteVersion": strconv.Itoa(york.Version),
+	}, "york map")
+	assert.Contains(t, rec.Header().Get("Location"), "Site name already exists")
+	image, err := os.ReadFile("./static/site_maps/Leeds.png")
+	require.NoError(t, err)
+	assert.Equal(t, "leeds map", string(image))
+
+	// The edit form names the version of the site it was made from
+	rec = postSite("/api/site/"+strconv.Itoa(leeds.SiteID), map[string]string{
+		"editSiteID": strconv.Itoa(leeds.SiteID), "editSiteName": "Leeds North", "editSiteAddress": "1 Park Row",
+	}, "")
+	assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+
+	// Renaming a site renames its map once the site is saved
+	rec = postSite("/api/site/"+strconv.Itoa(leeds.SiteID), map[string]string{
+		"editSiteID": strconv.Itoa(leeds.SiteID), "editSiteName": "Leeds North", "editSiteAddress": "1 Park Row",
+		"editSiteVersion": strconv.Itoa(leeds.Version),
+	}, "")
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	renamed, err := a.Store.GetSiteByName("Leeds North")
+	require.NoError(t, err)
+	assert.Equal(t, "/static/site_maps/Leeds_North.png", renamed.SiteMapImagePath.String)
+	_, err = os.Stat("./static/site_maps/Leeds.png")
+	assert.True(t, os.IsNotExist(err))
+	image, err = os.ReadFile("./static/site_maps/Leeds_North.png")
+	require.NoError(t, err)
+	assert.Equal(t, "leeds map", string(image))
+}
+
+func TestHandleStats(t *testing.T) {
+	a := newTestApp(t)
+	userToken := token(t, a.UserID, "User", false)
+	now := time.Now()
+
+	co2, err := a.Store.AddExtinguisherType("CO2")
+	require.NoError(t, err)
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	addDevice := func(serialNumber string, manufactureDate time.Time) int {
+		t.Helper()
+		added := &models.EmergencyDevice{
+			EmergencyDeviceTypeID: device.EmergencyDeviceTypeID,
+			ExtinguisherTypeID:    sql.NullInt64{Int64: int64(co2), Valid: true},
+			RoomID:                a.RoomID,
+			SerialNumber:          sql.NullString{String: serialNumber, Valid: true},
+			ManufactureDate:       sql.NullTime{Time: manufactureDate, Valid: true},
+			Status:                sql.NullString{String: "Active", Valid: true},
+		}
+		require.NoError(t, a.Store.AddEmergencyDevice(added))
+		return added.EmergencyDeviceID
+	}
+	inspect := func(deviceID int, inspectedAt time.Time, status string) {
+		t.Helper()
+		require.NoError(t, a.Store.AddInspection(&models.Inspection{
+			EmergencyDeviceID:  deviceID,
+			UserID:             a.UserID,
+			InspectionDateTime: sql.NullTime{Time: inspectedAt, Valid: true},
+			InspectionStatus:   status,
+		}))
+	}
+
+	// SN1 is well overdue, SN2 failed then passed, was serviced and expires next quarter, SN3 has never been inspected
+	inspect(a.DeviceID, now.AddDate(0, -3, -100), "Passed")
+	expiring := now.AddDate(0, 3, 0)
+	compliantID := addDevice("SN2", expiring.AddDate(-5, 0, 0))
+	inspect(compliantID, now.AddDate(0, 0, -10), "Failed")
+	inspect(compliantID, now.AddDate(0, 0, -1), "Passed")
+	_, err = a.Store.AddMaintenanceRecord(&models.MaintenanceRecord{
+		EmergencyDeviceID: compliantID,
+		UserID:            a.UserID,
+		ServiceType:       models.ServiceTypeService,
+		ServiceDate:       sql.NullTime{Time: now.AddDate(0, 0, -1), Valid: true},
+		Provider:          "Chubb",
+	})
+	require.NoError(t, err)
+	addDevice("SN3", now.AddDate(-1, 0, 0))
+
+	rec := a.serve(http.MethodGet, "/api/stats", "", "", userToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var stats models.Stats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+
+	assert.Equal(t, 3, stats.Compliance.Devices)
+	assert.Equal(t, 1, stats.Compliance.Compliant)
+	assert.Equal(t, 33.3, stats.Compliance.Percentage)
+	require.Len(t, stats.Compliance.Sites, 1)
+	assert.Equal(t, "Taradale", stats.Compliance.Sites[0].SiteName)
+	require.Len(t, stats.Compliance.Sites[0].Buildings, 1)
+	assert.Equal(t, models.BuildingCompliance{BuildingCode: "A", Devices: 3, Compliant: 1, Percentage: 33.3}, stats.Compliance.Sites[0].Buildings[0])
+
+	assert.Equal(t, 1, stats.Overdue.Devices)
+	assert.Equal(t, 1, stats.Overdue.NeverInspected)
+	require.Len(t, stats.Overdue.Buckets, 4)
+	assert.Equal(t, "Over 90 days", stats.Overdue.Buckets[3].Label)
+	assert.Equal(t, 1, stats.Overdue.Buckets[3].Devices)
+
+	assert.Equal(t, []models.FailureRate{{Name: "Fire Extinguisher", Inspections: 3, Failed: 1, Percentage: 33.3}}, stats.FailureByDeviceType)
+	assert.Equal(t, []models.FailureRate{{Name: "CO2", Inspections: 2, Failed: 1, Percentage: 50}}, stats.FailureByExtinguisher)
+	require.Len(t, stats.InspectionsByInspector, 2, "the inspections of the last 12 months fall in two months")
+
+	require.Len(t, stats.ExpiringByQuarter, 4)
+	assert.Equal(t, 1, stats.ExpiringByQuarter[1].Devices, "SN2 expires next quarter, SN1 and SN3 after the fourth")
+	assert.Equal(t, 0, stats.ExpiringByQuarter[0].Devices+stats.ExpiringByQuarter[2].Devices+stats.ExpiringByQuarter[3].Devices)
+
+	// The date filters only limit the inspections
+	rec = a.serve(http.MethodGet, "/api/stats?from=2000-01-01&to=2000-12-31", "", "", userToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, "2000-01-01", stats.From)
+	assert.Empty(t, stats.FailureByDeviceType)
+	assert.Empty(t, stats.InspectionsByInspector)
+	assert.Equal(t, 3, stats.Compliance.Devices)
+
+	for target, status := range map[string]int{
+		"/api/stats?site_id=" + strconv.Itoa(device.SiteID): http.StatusOK,
+		"/api/stats?site_id=999":                            http.StatusNotFound,
+		"/api/stats?site_id=abc":                            http.StatusBadRequest,
+		"/api/stats?from=2024-13-01":                        http.StatusBadRequest,
+		"/api/stats?from=2024-02-01&to=2024-01-01":          http.StatusBadRequest,
+	} {
+		rec = a.serve(http.MethodGet, target, "", "", userToken)
+		assert.Equal(t, status, rec.Code, target)
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	a := newTestApp(t)
+	userToken := token(t, a.UserID, "User", false)
+
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	device.Description = sql.NullString{String: "<b>Kitchen</b> cupboard", Valid: true}
+	require.NoError(t, a.Store.UpdateEmergencyDevice(device))
+
+	search := func(query string) models.SearchResults {
+		t.Helper()
+		rec := a.serve(http.MethodGet, "/api/search?q="+url.QueryEscape(query), "", "", userToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var results models.SearchResults
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+		return results
+	}
+
+	results := search("sn1")
+	require.Len(t, results.Results, 1)
+	assert.Equal(t, "<mark>SN1</mark>", results.Results[0].Highlight)
+	assert.Equal(t, 20, results.Limit)
+
+	results = search("kitchen")
+	require.Len(t, results.Results, 1)
+	assert.Equal(t, "description", results.Results[0].Field)
+	assert.Equal(t, "&lt;b&gt;<mark>Kitchen</mark>&lt;/b&gt; cupboard", results.Results[0].Highlight, "values are escaped as HTML")
+
+	results = search("  kitchin ")
+	require.Len(t, results.Results, 1)
+	assert.Equal(t, "kitchin", results.Query)
+	assert.Equal(t, "&lt;b&gt;<mark>Kitchen</mark>&lt;/b&gt; cupboard", results.Results[0].Highlight, "similar words are marked")
+
+	for _, target := range []string{"/api/search?q=a", "/api/search?q=kitchen&limit=0", "/api/search?q=kitchen&offset=-1"} {
+		rec := a.serve(http.MethodGet, target, "", "", userToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
+
+// dataRenderer keeps the data pages are rendered with instead of rendering their templates
+type dataRenderer struct {
+	data map[string]interface{}
+}
+
+func (r *dataRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	r.data, _ = data.(map[string]interface{})
+	return nil
+}
+
+func TestHandleSavedViews(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	require.NoError(t, a.Store.CreateUser(&models.User{Username: "manager", Password: "hash", Email: "manager@example.com"}))
+	manager, err := a.Store.GetUserByUsername("manager")
+	require.NoError(t, err)
+	managerToken := token(t, manager.UserID, "User", false)
+
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	for _, serialNumber := range []string{"SN2", "SN3"} {
+		require.NoError(t, a.Store.AddEmergencyDevice(&models.EmergencyDevice{
+			EmergencyDeviceTypeID: device.EmergencyDeviceTypeID,
+			RoomID:                a.RoomID,
+			SerialNumber:          sql.NullString{String: serialNumber, Valid: true},
+			ManufactureDate:       device.ManufactureDate,
+			Status:                sql.NullString{String: "Inactive", Valid: true},
+		}))
+	}
+
+	body := `{"name": " Inactive ", "filters": {"status": "Inactive"}, "sort_column": "serial_number", "sort_descending": true,
+		"columns": ["serial_number", "status", "serial_number"], "shared_with": [` + strconv.Itoa(manager.UserID) + `], "is_default": true}`
+	rec := a.serve(http.MethodPost, "/api/saved-view", "application/json", body, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created struct {
+		SavedViewID int `json:"saved_view_id"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	viewID := strconv.Itoa(created.SavedViewID)
+
+	view, err := a.Store.GetSavedViewByID(created.SavedViewID, a.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "Inactive", view.Name)
+	assert.Equal(t, []string{"serial_number", "status"}, view.Columns)
+	assert.True(t, view.IsDefault)
+
+	rec = a.serve(http.MethodPost, "/api/saved-view", "application/json", `{"name": "Inactive"}`, adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	for _, invalid := range []string{
+		`{"name": ""}`,
+		`{"name": "Sorted", "sort_column": "password"}`,
+		`{"name": "Columns", "columns": ["password"]}`,
+		`{"name": "Site", "filters": {"site_id": "Taradale"}}`,
+		`{"name": "Shared", "shared_with": [999]}`,
+	} {
+		rec = a.serve(http.MethodPost, "/api/saved-view", "application/json", invalid, adminToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, invalid)
+	}
+
+	// The device list applies the view's filters and sort
+	rec = a.serve(http.MethodGet, "/api/emergency-device?view_id="+viewID, "", "", managerToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var devices []models.EmergencyDevice
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &devices))
+	require.Len(t, devices, 2)
+	assert.Equal(t, "SN3", devices[0].SerialNumber.String)
+	assert.Equal(t, "SN2", devices[1].SerialNumber.String)
+
+	// Users the view is shared with can use it but not change it
+	rec = a.serve(http.MethodGet, "/api/saved-view", "", "", managerToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var views []models.SavedView
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &views))
+	require.Len(t, views, 1)
+	assert.Equal(t, "admin", views[0].OwnerName)
+	rec = a.serveIfMatch(http.MethodPut, "/api/saved-view/"+viewID, `{"name": "Mine"}`, etag(view.Version), managerToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = a.serveIfMatch(http.MethodDelete, "/api/saved-view/"+viewID, "", etag(view.Version), managerToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = a.serve(http.MethodPut, "/api/saved-view/"+viewID+"/default", "", "", managerToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The dashboard opens on the default view
+	renderer := &dataRenderer{}
+	a.Router.Renderer = renderer
+	rec = a.serve(http.MethodGet, "/dashboard", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	defaultView, ok := renderer.data["default_view"].(*models.SavedView)
+	require.True(t, ok)
+	require.NotNil(t, defaultView)
+	assert.Equal(t, created.SavedViewID, defaultView.SavedViewID)
+
+	rec = a.serveIfMatch(http.MethodPut, "/api/saved-view/"+viewID, `{"name": "Inactive", "is_default": false}`, etag(view.Version), adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	_, err = a.Store.GetDefaultSavedView(a.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = a.Store.GetDefaultSavedView(manager.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "the view is no longer shared with the manager")
+
+	rec = a.serve(http.MethodGet, "/dashboard", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, renderer.data["default_view"])
+
+	rec = a.serve(http.MethodPut, "/api/saved-view/"+viewID+"/default", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = a.serve(http.MethodDelete, "/api/saved-view/default", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, err = a.Store.GetDefaultSavedView(a.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	view, err = a.Store.GetSavedViewByID(created.SavedViewID, a.UserID)
+	require.NoError(t, err)
+	rec = a.serveIfMatch(http.MethodDelete, "/api/saved-view/"+viewID, "", etag(view.Version), adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = a.serve(http.MethodGet, "/api/saved-view/"+viewID, "", "", adminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// serveIfMatch sends a JSON request through the router that only applies to the version of the row in ifMatch
+// etag is the If-Match value naming a version of a row
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+func (a *testApp) serveIfMatch(method string, target string, body string, ifMatch string, loginToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", ifMatch)
+	req.AddCookie(&http.Cookie{Name: "token", Value: loginToken})
+
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	room, err := a.Store.GetRoomByID(a.RoomID)
+	require.NoError(t, err)
+	roomURL := "/api/room/" + strconv.Itoa(a.RoomID)
+	body := func(roomCode string) string {
+		return `{"room_code": "` + roomCode + `", "building_id": "` + strconv.Itoa(room.BuildingID) + `"}`
+	}
+
+	rec := a.serve(http.MethodGet, roomURL, "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+
+	rec = a.serveIfMatch(http.MethodPut, roomURL, body("A102"), `"1"`, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// The other admin edited the first version too
+	rec = a.serveIfMatch(http.MethodPut, roomURL, body("A103"), `"1"`, adminToken)
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code, rec.Body.String())
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	var conflict struct {
+		Error   string      `json:"error"`
+		Current models.Room `json:"current"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &conflict))
+	assert.Equal(t, "A102", conflict.Current.RoomCode)
+	assert.Equal(t, 2, conflict.Current.Version)
+
+	rec = a.serveIfMatch(http.MethodDelete, roomURL, "", `"1"`, adminToken)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	// Weak tags and lists of tags name the current version too
+	rec = a.serveIfMatch(http.MethodPut, roomURL, body("A103"), `"1", W/"2"`, adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Edits and deletes must name the version they were made from
+	rec = a.serve(http.MethodPut, roomURL, "application/json", body("A104"), adminToken)
+	require.Equal(t, http.StatusPreconditionRequired, rec.Code, rec.Body.String())
+	rec = a.serve(http.MethodDelete, roomURL, "", "", adminToken)
+	assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+	room, err = a.Store.GetRoomByID(a.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, "A103", room.RoomCode)
+	assert.Equal(t, 3, room.Version)
+
+	deviceURL := "/api/emergency-device/" + strconv.Itoa(a.DeviceID)
+	rec = a.serve(http.MethodGet, deviceURL, "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+	rec = a.serve(http.MethodDelete, deviceURL+"?reason=Expired", "", "", adminToken)
+	assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+	rec = a.serve(http.MethodPost, deviceURL+"/replace", "application/json", `{"reason": "Expired"}`, adminToken)
+	assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+	rec = a.serveIfMatch(http.MethodPost, deviceURL+"/replace", `{"reason": "Expired"}`, `"2"`, adminToken)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = a.serveIfMatch(http.MethodDelete, deviceURL+"?reason=Expired", "", `"2"`, adminToken)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = a.serveIfMatch(http.MethodDelete, deviceURL+"?reason=Expired", "", `"1"`, adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestArchiveWithDependents(t *testing.T) {
+	a := newTestApp(t)
+	adminToken := token(t, a.UserID, "Admin", false)
+
+	room, err := a.Store.GetRoomByID(a.RoomID)
+	require.NoError(t, err)
+	roomURL := "/api/room/" + strconv.Itoa(a.RoomID)
+	buildingURL := "/api/building/" + strconv.Itoa(room.BuildingID)
+	building, err := a.Store.GetBuildingById(room.BuildingID)
+	require.NoError(t, err)
+
+	// The dry run lists what is in the way
+	rec := a.serve(http.MethodGet, buildingURL+"/dependents", "", "", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var dependents models.Dependents
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &dependents))
+	assert.Equal(t, []models.Dependent{{ID: a.RoomID, Name: "A101"}}, dependents.Rooms)
+	assert.Equal(t, []models.Dependent{{ID: a.DeviceID, Name: "SN1"}}, dependents.Devices)
+	rec = a.serve(http.MethodGet, "/api/room/"+strconv.Itoa(a.RoomID+100)+"/dependents", "", "", adminToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Archiving is refused with the same list
+	rec = a.serveIfMatch(http.MethodDelete, buildingURL, "", etag(building.Version), adminToken)
+	require.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	var refused struct {
+		Error      string            `json:"error"`
+		Dependents models.Dependents `json:"dependents"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &refused))
+	assert.Equal(t, dependents, refused.Dependents)
+
+	// The devices move to another room and the room is archived, or neither happens
+	require.NoError(t, a.Store.AddRoom(&models.Room{BuildingID: room.BuildingID, RoomCode: "A102"}))
+	other, err := a.Store.GetRoomByCodeAndBuilding("A102", room.BuildingID)
+	require.NoError(t, err)
+	rec = a.serveIfMatch(http.MethodDelete, roomURL+"?reassign_to="+strconv.Itoa(a.RoomID), "", etag(room.Version), adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = a.serveIfMatch(http.MethodDelete, roomURL+"?reassign_to="+strconv.Itoa(a.RoomID+100), "", etag(room.Version), adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = a.serveIfMatch(http.MethodDelete, roomURL+"?reassign_to="+strconv.Itoa(other.RoomID), "", etag(room.Version), adminToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	device, err := a.Store.GetDeviceByID(a.DeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, other.RoomID, device.RoomID)
+	rooms, err := a.Store.GetAllRooms(strconv.Itoa(room.BuildingID), "")
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	assert.Equal(t, "A102", rooms[0].RoomCode)
+
+	// A failed move leaves the building in place with its rooms
+	require.NoError(t, a.Store.AddBuilding(&models.Building{SiteID: room.SiteID, BuildingCode: "B"}))
+	b, err := a.Store.GetBuildingByCodeandSite("B", room.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, a.Store.AddRoom(&models.Room{BuildingID: b.BuildingID, RoomCode: "A102"}))
+	rec = a.serveIfMatch(http.MethodDelete, buildingURL+"?reassign_to="+strconv.Itoa(b.BuildingID), "", etag(building.Version), adminToken)
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	buildings, err := a.Store.GetAllBuildings(strconv.Itoa(room.SiteID))
+	require.NoError(t, err)
+	assert.Len(t, buildings, 2, "the building is not archived")
+	rooms, err = a.Store.GetAllRooms(strconv.Itoa(room.BuildingID), "")
+	require.NoError(t, err)
+	assert.Len(t, rooms, 1)
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// readinessCheckTimeout bounds each readiness check so a hung database cannot hang the probe
+const readinessCheckTimeout = 2 * time.Second
+
+// uploadDirs are the directories the handlers save uploaded files into
+var uploadDirs = []string{siteMapDir, floorPlanDir, maintenanceAttachmentDir}
+
+// readiness holds the checks /readyz runs and whether the server is draining for shutdown
+type readiness struct {
+	mu       sync.Mutex
+	checks   map[string]func(ctx context.Context) error
+	draining bool
+}
+
+// AddReadinessCheck adds a dependency check to /readyz, the app is only ready while every check passes
+func (a *App) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	a.readiness.mu.Lock()
+	defer a.readiness.mu.Unlock()
+
+	if a.readiness.checks == nil {
+		a.readiness.checks = make(map[string]func(ctx context.Context) error)
+	}
+	a.readiness.checks[name] = check
+}
+
+// StartDraining makes /readyz report the app as not ready, so load balancers stop sending it new
+// requests while the server shuts down. /healthz keeps reporting the process as alive.
+func (a *App) StartDraining() {
+	a.readiness.mu.Lock()
+	defer a.readiness.mu.Unlock()
+
+	a.readiness.draining = true
+}
+
+// HandleGetHealthz is the liveness probe, it only reports that the process is serving requests
+func (a *App) HandleGetHealthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleGetReadyz is the readiness probe, it runs every readiness check and reports each result
+func (a *App) HandleGetReadyz(c echo.Context) error {
+	a.readiness.mu.Lock()
+	draining := a.readiness.draining
+	names := make([]string, 0, len(a.readiness.checks))
+	for name := range a.readiness.checks {
+		names = append(names, name)
+	}
+	checks := a.readiness.checks
+	a.readiness.mu.Unlock()
+
+	if draining {
+		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{"status": "draining"})
+	}
+
+	sort.Strings(names)
+	results := make(map[string]string, len(names))
+	status, statusCode := "ready", http.StatusOK
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), readinessCheckTimeout)
+		err := checks[name](ctx)
+		cancel()
+
+		results[name] = "ok"
+		if err != nil {
+			results[name] = err.Error()
+			status, statusCode = "not ready", http.StatusServiceUnavailable
+			a.Logger.WarnContext(c.Request().Context(), "Readiness check failed", "check", name, "error", err)
+		}
+	}
+
+	return c.JSON(statusCode, map[string]interface{}{"status": status, "checks": results})
+}
+
+// addDependencyChecks adds the readiness checks of a server connected to PostgreSQL
+func (a *App) addDependencyChecks(db *sql.DB) error {
+	latestVersion, err := database.LatestMigrationVersion()
+	if err != nil {
+		return err
+	}
+
+	a.AddReadinessCheck("database", db.PingContext)
+
+	a.AddReadinessCheck("migrations", func(ctx context.Context) error {
+		version, err := database.SchemaVersion(ctx, db)
+		if err != nil {
+			return err
+		}
+		if version != latestVersion {
+			return fmt.Errorf("schema is at version %d, expected %d", version, latestVersion)
+		}
+		return nil
+	})
+
+	a.AddReadinessCheck("templates", func(ctx context.Context) error {
+		renderer, ok := a.Router.Renderer.(*utils.TemplateRenderer)
+		if !ok || renderer.Count() == 0 {
+			return errors.New("no page templates are loaded")
+		}
+		return nil
+	})
+
+	a.AddReadinessCheck("uploads", func(ctx context.Context) error {
+		for _, dir := range uploadDirs {
+			if err := checkWritable(dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return nil
+}
+
+// checkWritable creates the directory if needed and writes a file to it
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
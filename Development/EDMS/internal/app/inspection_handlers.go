@@ -2,11 +2,12 @@ package app
 
 import (
 	"database/sql"
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	"github.com/labstack/echo/v4"
 )
@@ -23,7 +24,7 @@ func (a *App) HandleGetAllInspectionsByDeviceID(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
 
-	inspections, err := a.DB.GetAllInspectionsByDeviceID(deviceID)
+	inspections, err := a.store(c).GetAllInspectionsByDeviceID(deviceID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -43,7 +44,7 @@ func (a *App) HandleGetInspectionByID(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
 
-	inspection, err := a.DB.GetInspectionByID(inspectionID)
+	inspection, err := a.store(c).GetInspectionByID(inspectionID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -93,91 +94,97 @@ func (a *App) HandlePostInspection(c echo.Context) error {
 	inspection_status := c.FormValue("inspection_status")
 
 	// Log the inspection details
-	fmt.Println("Inspection Date:", inspectionDateTime)
-	fmt.Println("Notes:", notes)
-	fmt.Println("Device ID:", deviceID)
-	fmt.Println("User ID:", userId)
-	fmt.Println("IsConspicuous:", inspection.IsConspicuous.Bool)
-	fmt.Println("IsAccessible:", inspection.IsAccessible.Bool)
-	fmt.Println("IsAssignedLocation:", inspection.IsAssignedLocation.Bool)
-	fmt.Println("IsSignVisible:", inspection.IsSignVisible.Bool)
-	fmt.Println("IsAntiTamperDeviceIntact:", inspection.IsAntiTamperDeviceIntact.Bool)
-	fmt.Println("IsSupportBracketSecure:", inspection.IsSupportBracketSecure.Bool)
-	fmt.Println("WorkOrderRequired:", inspection.WorkOrderRequired.Bool)
-	fmt.Println("AreOperatingInstructionsClear:", inspection.AreOperatingInstructionsClear.Bool)
-	fmt.Println("IsMaintenanceTagAttached:", inspection.IsMaintenanceTagAttached.Bool)
-	fmt.Println("IsNoExternalDamage:", inspection.IsNoExternalDamage.Bool)
-	fmt.Println("IsChargeGaugeNormal:", inspection.IsChargeGaugeNormal.Bool)
-	fmt.Println("IsReplaced:", inspection.IsReplaced.Bool)
-	fmt.Println("AreMaintenanceRecordsComplete:", inspection.AreMaintenanceRecordsComplete.Bool)
-	fmt.Println("InspectionStatus:", inspection_status)
+	a.Logger.DebugContext(c.Request().Context(), "Adding inspection",
+		"inspection_datetime", inspectionDateTime,
+		"emergency_device_id", deviceID,
+		"user_id", userId,
+		"inspection_status", inspection_status,
+	)
 
 	// Validate required fields
 	if inspectionDateTime == "" || deviceID == 0 || userId == 0 || inspection_status == "" {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Invalid request payload")
 	}
 
-	// Check if the device ID exists
-	_, err = a.DB.GetDeviceByID(deviceID)
-	if err != nil {
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Invalid Device ID")
-	}
-
-	// Check if the user ID exists
-	_, err = a.DB.GetUserByID(userId)
-	if err != nil {
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Invalid User ID")
+	// The checks, the inspection, the device status the trigger sets and the webhook events are saved together
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		// Check if the device ID exists
+		device, err := tx.GetDeviceByID(deviceID)
+		if err != nil {
+			return rejected("Invalid Device ID")
+		}
+
+		// Decommissioned devices are no longer inspected
+		if device.DecommissionedAt.Valid {
+			return rejected("Cannot inspect a decommissioned device")
+		}
+
+		// Check if the user ID exists
+		_, err = tx.GetUserByID(userId)
+		if err != nil {
+			return rejected("Invalid User ID")
+		}
+
+		// Parse the input date and time, assuming it's in the local time of the device's site
+		localLocation := a.siteLocation(device.SiteTimeZone)
+		formattedInspectionDateTime, err := time.ParseInLocation("2006-01-02T15:04", inspectionDateTime, localLocation)
+		if err != nil {
+			return rejected("Invalid Inspection Date and Time")
+		}
+
+		// Create the sql.NullTime struct
+		nullTimeDate := sql.NullTime{
+			Time:  formattedInspectionDateTime,
+			Valid: true,
+		}
+
+		currentTime := time.Now().In(localLocation) // Get current local time
+
+		// Check if the formatted inspection date time is valid and in the future
+		if nullTimeDate.Valid && nullTimeDate.Time.After(currentTime) {
+			return rejected("Inspection Date and Time cannot be in the future")
+		}
+
+		// Validate notes length is less than 255 characters
+		if len(notes) > 255 {
+			return rejected("Notes must be less than 255 characters")
+		}
+
+		// Set the remaining inspection fields
+		inspection.InspectionDateTime = nullTimeDate
+		inspection.Notes.String = notes
+		inspection.EmergencyDeviceID = deviceID
+		inspection.UserID = userId
+		inspection.InspectionStatus = inspection_status
+
+		a.Logger.InfoContext(c.Request().Context(), "New inspection submission",
+			"emergency_device_id", deviceID, "user_id", userId, "inspection_datetime", inspectionDateTime)
+
+		// Add the inspection to the database
+		if err := tx.AddInspection(inspection); err != nil {
+			return err
+		}
+		return publishInspection(tx, inspection.EmergencyDeviceInspectionID, device.Status)
+	})
+	var rejection rejected
+	if errors.As(err, &rejection) {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+string(rejection))
 	}
-
-	// Parse the input date and time, assuming it's in local time
-	localLocation, err := time.LoadLocation("Pacific/Auckland") // Load NZDT timezone
-	if err != nil {
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Invalid Timezone")
-	}
-	formattedInspectionDateTime, err := time.ParseInLocation("2006-01-02T15:04", inspectionDateTime, localLocation)
 	if err != nil {
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Invalid Inspection Date and Time")
-	}
-
-	// Log the formatted inspection date time
-	fmt.Println("Formatted Inspection Date Time:", formattedInspectionDateTime)
-
-	// Create the sql.NullTime struct
-	nullTimeDate := sql.NullTime{
-		Time:  formattedInspectionDateTime,
-		Valid: true,
+		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
-	// Log the current time in local timezone
-	currentTime := time.Now().In(localLocation) // Get current local time
-	fmt.Println("Current Local Time:", currentTime)
-
-	// Check if the formatted inspection date time is valid and in the future
-	if nullTimeDate.Valid && nullTimeDate.Time.After(currentTime) {
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Inspection Date and Time cannot be in the future")
-	}
+	return c.Redirect(http.StatusSeeOther, "/dashboard?message=Inspection added successfully")
+}
 
-	// Validate notes length is less than 255 characters
-	if len(notes) > 255 {
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Notes must be less than 255 characters")
+// siteLocation loads the time zone of a site, the configured time zone when it has none
+func (a *App) siteLocation(timeZone string) *time.Location {
+	if timeZone == "" {
+		return a.Config.Location()
 	}
-
-	// Set the remaining inspection fields
-	inspection.InspectionDateTime = nullTimeDate
-	inspection.Notes.String = notes
-	inspection.EmergencyDeviceID = deviceID
-	inspection.UserID = userId
-	inspection.InspectionStatus = inspection_status
-
-	// Log the inspection details (consider using structured logging)
-	a.handleLogger(fmt.Sprintf("New inspection submission: deviceID=%d, userID=%d, date=%s",
-		deviceID, userId, inspectionDateTime))
-
-	// Add the inspection to the database
-	err = a.DB.AddInspection(inspection)
+	location, err := time.LoadLocation(timeZone)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err.Error())
+		return a.Config.Location()
 	}
-
-	return c.Redirect(http.StatusSeeOther, "/dashboard?message=Inspection added successfully")
+	return location
 }
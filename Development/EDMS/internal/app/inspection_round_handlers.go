@@ -0,0 +1,255 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// HandleGetInspectionRounds lists the inspection rounds with their progress.
+// ?mine=true lists the rounds of the logged in user and ?user_id= those of another inspector.
+func (a *App) HandleGetInspectionRounds(c echo.Context) error {
+	userID := 0
+	if c.QueryParam("mine") == "true" {
+		id, err := loggedInUserID(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+		}
+		userID = id
+	} else if userId := c.QueryParam("user_id"); userId != "" {
+		id, err := strconv.Atoi(userId)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+		}
+		userID = id
+	}
+
+	rounds, err := a.store(c).GetInspectionRounds(userID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching inspection rounds", err)
+	}
+
+	return c.JSON(http.StatusOK, rounds)
+}
+
+// HandleGetInspectionRoundByID returns a round with its devices in the order they are inspected
+func (a *App) HandleGetInspectionRoundByID(c echo.Context) error {
+	roundID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid inspection round ID"})
+	}
+
+	round, err := a.store(c).GetInspectionRoundByID(roundID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Inspection round not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching inspection round", err)
+	}
+
+	return c.JSON(http.StatusOK, round)
+}
+
+// HandlePostInspectionRound plans a round of the devices due in a site or building for a period
+func (a *App) HandlePostInspectionRound(c echo.Context) error {
+	var roundDto models.InspectionRoundDto
+	if err := c.Bind(&roundDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request data",
+			"redirectURL": "/admin?error=Invalid request data",
+		})
+	}
+
+	round, errorMessage := a.validateInspectionRound(c, roundDto)
+	if errorMessage != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       errorMessage,
+			"redirectURL": "/admin?error=" + errorMessage,
+		})
+	}
+
+	roundID, err := PlanInspectionRound(a.store(c), &round)
+	if errors.Is(err, ErrNothingDue) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{
+			"error":       "No devices are due in this period",
+			"redirectURL": "/admin?error=No devices are due in this period",
+		})
+	}
+	if err != nil {
+		a.handleLogger(c, "Error adding inspection round: "+err.Error())
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Error adding inspection round, there may already be a round for this period",
+			"redirectURL": "/admin?error=Error adding inspection round, there may already be a round for this period",
+		})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":             "Inspection round added successfully",
+		"inspection_round_id": roundID,
+		"devices":             len(round.DeviceIDs),
+	})
+}
+
+// validateInspectionRound checks the site, building, inspector and period of a round to plan
+func (a *App) validateInspectionRound(c echo.Context, roundDto models.InspectionRoundDto) (models.InspectionRound, string) {
+	var round models.InspectionRound
+
+	site, err := a.store(c).GetSiteByID(roundDto.SiteID)
+	if err != nil {
+		return round, "Site does not exist"
+	}
+	round.SiteID = site.SiteID
+
+	if roundDto.BuildingID != "" {
+		buildingID, err := strconv.Atoi(roundDto.BuildingID)
+		if err != nil {
+			return round, "Invalid building ID"
+		}
+		round.BuildingID = sql.NullInt64{Int64: int64(buildingID), Valid: true}
+	}
+
+	if roundDto.UserID != "" {
+		userID, err := strconv.Atoi(roundDto.UserID)
+		if err != nil {
+			return round, "Invalid user ID"
+		}
+		if _, err := a.store(c).GetUserByID(userID); err != nil {
+			return round, "User does not exist"
+		}
+		round.UserID = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+
+	if round.PeriodStart, err = time.Parse("2006-01-02", roundDto.PeriodStart); err != nil {
+		return round, "Invalid period start date"
+	}
+	if round.PeriodEnd, err = time.Parse("2006-01-02", roundDto.PeriodEnd); err != nil {
+		return round, "Invalid period end date"
+	}
+	if round.PeriodEnd.Before(round.PeriodStart) {
+		return round, "The period cannot end before it starts"
+	}
+
+	return round, ""
+}
+
+// HandlePostGenerateInspectionRounds plans the rounds of every building for a month, next month by default
+func (a *App) HandlePostGenerateInspectionRounds(c echo.Context) error {
+	var request struct {
+		Month  string `json:"month"` // YYYY-MM
+		UserID string `json:"user_id"`
+	}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	month := nextMonth(time.Now().In(a.Config.Location()))
+	if request.Month != "" {
+		parsed, err := time.Parse("2006-01", request.Month)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Month must be YYYY-MM"})
+		}
+		month = parsed
+	}
+
+	userID := 0
+	if request.UserID != "" {
+		id, err := strconv.Atoi(request.UserID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+		}
+		if _, err := a.store(c).GetUserByID(id); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "User does not exist"})
+		}
+		userID = id
+	}
+
+	added, err := GenerateInspectionRounds(a.store(c), month, userID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error generating inspection rounds", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Inspection rounds generated successfully",
+		"month":   month.Format("2006-01"),
+		"added":   added,
+	})
+}
+
+// nextMonth is the first day of the month after the given day
+func nextMonth(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// HandlePutInspectionRoundInspector assigns a round to an inspector, an empty user ID unassigns it
+func (a *App) HandlePutInspectionRoundInspector(c echo.Context) error {
+	roundID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid inspection round ID"})
+	}
+
+	var request struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	userID := 0
+	if request.UserID != "" {
+		if userID, err = strconv.Atoi(request.UserID); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid user ID"})
+		}
+		if _, err := a.store(c).GetUserByID(userID); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "User does not exist"})
+		}
+	}
+
+	err = a.store(c).AssignInspectionRound(roundID, userID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Inspection round not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error assigning inspection round", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Inspection round assigned successfully"})
+}
+
+// HandlePutSkipInspectionRoundDevice records why a device of a round was not inspected
+func (a *App) HandlePutSkipInspectionRoundDevice(c echo.Context) error {
+	roundID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid inspection round ID"})
+	}
+	deviceID, err := strconv.Atoi(c.Param("deviceId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid Device ID"})
+	}
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+	reason := strings.TrimSpace(request.Reason)
+	if reason == "" || len(reason) > 255 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A reason of at most 255 characters is required"})
+	}
+
+	err = a.store(c).SkipInspectionRoundDevice(roundID, deviceID, reason)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The device is not waiting for inspection in this round"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error skipping device", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Device skipped"})
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// maintenanceAttachmentDir is where certificates and reports uploaded with maintenance records are stored
+const maintenanceAttachmentDir = "./static/maintenance_attachments"
+
+// HandleGetMaintenanceRecordsByDeviceID fetches the maintenance records of a device
+func (a *App) HandleGetMaintenanceRecordsByDeviceID(c echo.Context) error {
+	deviceID, err := strconv.Atoi(c.QueryParam("device_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid device ID"})
+	}
+
+	records, err := a.store(c).GetMaintenanceRecordsByDeviceID(deviceID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching maintenance records", err)
+	}
+
+	return c.JSON(http.StatusOK, records)
+}
+
+// HandleGetMaintenanceRecordByID fetches a maintenance record and its attachments
+func (a *App) HandleGetMaintenanceRecordByID(c echo.Context) error {
+	maintenanceRecordID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid maintenance record ID"})
+	}
+
+	record, err := a.store(c).GetMaintenanceRecordByID(maintenanceRecordID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Maintenance record not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching maintenance record", err)
+	}
+
+	return c.JSON(http.StatusOK, record)
+}
+
+// HandlePostMaintenanceRecord records contractor servicing of a device, with optional certificate attachments
+func (a *App) HandlePostMaintenanceRecord(c echo.Context) error {
+	deviceID, err := strconv.Atoi(c.FormValue("emergency_device_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid device ID",
+			"redirectURL": "/dashboard?error=Invalid device ID",
+		})
+	}
+
+	device, err := a.store(c).GetDeviceByID(deviceID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Device not found",
+			"redirectURL": "/dashboard?error=Device not found",
+		})
+	}
+
+	// Decommissioned devices are no longer serviced
+	if device.DecommissionedAt.Valid {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Cannot record maintenance for a decommissioned device",
+			"redirectURL": "/dashboard?error=Cannot record maintenance for a decommissioned device",
+		})
+	}
+
+	// The maintenance is recorded against the logged in user
+	claims := c.Get("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error":       "Invalid user",
+			"redirectURL": "/?error=Invalid user",
+		})
+	}
+
+	record, err := parseMaintenanceRecord(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/dashboard?error=" + err.Error(),
+		})
+	}
+	record.EmergencyDeviceID = deviceID
+	record.UserID = userID
+
+	attachments, err := saveMaintenanceAttachments(c, deviceID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/dashboard?error=" + err.Error(),
+		})
+	}
+	record.Attachments = attachments
+
+	maintenanceRecordID, err := a.store(c).AddMaintenanceRecord(record)
+	if err != nil {
+		// Remove the uploaded files so they are not left without a record
+		for _, attachment := range attachments {
+			os.Remove("." + attachment.FilePath)
+		}
+		return a.handleError(c, http.StatusInternalServerError, "Error adding maintenance record", err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{
+		"message":               "Maintenance record added successfully",
+		"maintenance_record_id": strconv.Itoa(maintenanceRecordID),
+		"redirectURL":           "/dashboard?message=Maintenance record added successfully",
+	})
+}
+
+// parseMaintenanceRecord validates the maintenance record fields of the form
+func parseMaintenanceRecord(c echo.Context) (*models.MaintenanceRecord, error) {
+	record := &models.MaintenanceRecord{}
+
+	serviceType := c.FormValue("service_type")
+	switch serviceType {
+	case models.ServiceTypeService, models.ServiceTypeRecharge, models.ServiceTypePressureTest, models.ServiceTypeRefurbishment:
+		record.ServiceType = serviceType
+	default:
+		return nil, fmt.Errorf("Service type must be one of: %s, %s, %s, %s",
+			models.ServiceTypeService, models.ServiceTypeRecharge, models.ServiceTypePressureTest, models.ServiceTypeRefurbishment)
+	}
+
+	serviceDate, err := time.Parse("2006-01-02", c.FormValue("service_date"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid service date")
+	}
+	if serviceDate.After(time.Now()) {
+		return nil, fmt.Errorf("Service date cannot be in the future")
+	}
+	record.ServiceDate = sql.NullTime{Time: serviceDate, Valid: true}
+
+	record.Provider = strings.TrimSpace(c.FormValue("provider"))
+	if record.Provider == "" || len(record.Provider) > 100 {
+		return nil, fmt.Errorf("Provider must be between 1 and 100 characters long")
+	}
+
+	if certificateNumber := strings.TrimSpace(c.FormValue("certificate_number")); certificateNumber != "" {
+		if len(certificateNumber) > 100 {
+			return nil, fmt.Errorf("Certificate number must be less than 100 characters")
+		}
+		record.CertificateNumber = sql.NullString{String: certificateNumber, Valid: true}
+	}
+
+	if costStr := c.FormValue("cost"); costStr != "" {
+		cost, err := strconv.ParseFloat(costStr, 64)
+		if err != nil || cost < 0 {
+			return nil, fmt.Errorf("Cost must be a positive number")
+		}
+		record.Cost = sql.NullFloat64{Float64: cost, Valid: true}
+	}
+
+	if nextServiceDateStr := c.FormValue("next_service_date"); nextServiceDateStr != "" {
+		nextServiceDate, err := time.Parse("2006-01-02", nextServiceDateStr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid next service date")
+		}
+		if !nextServiceDate.After(serviceDate) {
+			return nil, fmt.Errorf("Next service date must be after the service date")
+		}
+		record.NextServiceDate = sql.NullTime{Time: nextServiceDate, Valid: true}
+	}
+
+	if notes := c.FormValue("notes"); notes != "" {
+		if len(notes) > 255 {
+			return nil, fmt.Errorf("Notes must be less than 255 characters")
+		}
+		record.Notes = sql.NullString{String: notes, Valid: true}
+	}
+
+	return record, nil
+}
+
+// saveMaintenanceAttachments stores the uploaded attachments and returns them ready to be recorded
+func saveMaintenanceAttachments(c echo.Context, deviceID int) ([]models.MaintenanceAttachment, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		// Attachments are optional, a url encoded form has none
+		return nil, nil
+	}
+
+	files := form.File["attachments"]
+	allowedExtensions := map[string]bool{".pdf": true, ".jpg": true, ".jpeg": true, ".png": true}
+	for _, header := range files {
+		if !allowedExtensions[strings.ToLower(filepath.Ext(header.Filename))] {
+			return nil, fmt.Errorf("Invalid file type. Allowed types: pdf, jpg, jpeg, png")
+		}
+	}
+
+	if err := os.MkdirAll(maintenanceAttachmentDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	attachments := []models.MaintenanceAttachment{}
+	for i, header := range files {
+		// Prefix with the device and upload time so files from different records never collide
+		fileName := fmt.Sprintf("device_%d_%d_%d%s", deviceID, time.Now().UnixNano(), i, strings.ToLower(filepath.Ext(header.Filename)))
+		if err := saveUploadedFile(header, filepath.Join(maintenanceAttachmentDir, fileName)); err != nil {
+			for _, attachment := range attachments {
+				os.Remove("." + attachment.FilePath)
+			}
+			return nil, fmt.Errorf("Error saving attachment")
+		}
+
+		attachments = append(attachments, models.MaintenanceAttachment{
+			FileName: filepath.Base(header.Filename),
+			FilePath: "/static/maintenance_attachments/" + fileName,
+		})
+	}
+
+	return attachments, nil
+}
+
+// saveUploadedFile copies an uploaded file to the given path
+func saveUploadedFile(header *multipart.FileHeader, path string) error {
+	file, err := header.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, file)
+	return err
+}
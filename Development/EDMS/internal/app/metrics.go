@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ExpiringSoonDays is how far ahead a device expiry counts towards edms_devices_expiring_soon
+const ExpiringSoonDays = 30
+
+// RecentInspectionsWindow is the period edms_inspections_recorded_last_day counts inspections over
+const RecentInspectionsWindow = 24 * time.Hour
+
+// newMetricsRegistry creates the registry served on /metrics with the Go runtime and process collectors.
+// Each App has its own registry so handler tests can create as many apps as they need.
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// httpMetrics counts requests and measures their latency per route. The route is the registered
+// path, like /api/emergency-device/:id, so the number of series does not grow with the IDs requested.
+func httpMetrics(registry prometheus.Registerer) echo.MiddlewareFunc {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edms_http_requests_total",
+		Help: "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "edms_http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	registry.MustRegister(requests, duration)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+
+			// Let the error handler write the response now so its status code is counted
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			method := c.Request().Method
+
+			requests.WithLabelValues(method, route, strconv.Itoa(c.Response().Status)).Inc()
+			duration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+// domainCollector reports the compliance state of the devices, read from the store on every scrape. The device
+// metrics are labelled with organisation and site IDs rather than names, which belong to the organisations.
+type domainCollector struct {
+	store  database.Store
+	logger *slog.Logger
+
+	devices            *prometheus.Desc
+	inspectionsOverdue *prometheus.Desc
+	expiringSoon       *prometheus.Desc
+	recentInspections  *prometheus.Desc
+}
+
+func newDomainCollector(store database.Store, logger *slog.Logger) *domainCollector {
+	return &domainCollector{
+		store:  store,
+		logger: logger,
+		devices: prometheus.NewDesc("edms_devices",
+			"In-service emergency devices, by status, device type, organisation and site.",
+			[]string{"status", "device_type", "organisation_id", "site_id"}, nil),
+		inspectionsOverdue: prometheus.NewDesc("edms_devices_inspection_overdue",
+			"In-service devices whose next inspection day has passed at their site, by organisation and site.",
+			[]string{"organisation_id", "site_id"}, nil),
+		expiringSoon: prometheus.NewDesc("edms_devices_expiring_soon",
+			"In-service devices that expire within the next "+strconv.Itoa(ExpiringSoonDays)+" days, by organisation and site.",
+			[]string{"organisation_id", "site_id"}, nil),
+		recentInspections: prometheus.NewDesc("edms_inspections_recorded_last_day",
+			"Inspections recorded in the last 24 hours.",
+			nil, nil),
+	}
+}
+
+func (d *domainCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.devices
+	ch <- d.inspectionsOverdue
+	ch <- d.expiringSoon
+	ch <- d.recentInspections
+}
+
+func (d *domainCollector) Collect(ch chan<- prometheus.Metric) {
+	organisations, err := d.store.GetAllOrganisations()
+	if err != nil {
+		d.logger.ErrorContext(context.Background(), "Error collecting device metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(d.devices, err)
+		return
+	}
+
+	type siteKey struct{ organisation, site int }
+	type deviceKey struct {
+		status, deviceType string
+		siteKey
+	}
+	counts := make(map[deviceKey]int)
+	overdue := make(map[siteKey]int)
+	expiring := make(map[siteKey]int)
+
+	now := time.Now()
+	expiringBy := now.AddDate(0, 0, ExpiringSoonDays)
+	for _, organisation := range organisations {
+		devices, err := d.store.ForOrganisation(organisation.OrganisationID).GetAllDevices("", "", "")
+		if err != nil {
+			d.logger.ErrorContext(context.Background(), "Error collecting device metrics", "error", err)
+			ch <- prometheus.NewInvalidMetric(d.devices, err)
+			return
+		}
+
+		for _, device := range devices {
+			status := device.Status.String
+			if !device.Status.Valid {
+				status = "Unknown"
+			}
+			site := siteKey{organisation.OrganisationID, device.SiteID}
+			counts[deviceKey{status, device.EmergencyDeviceTypeName, site}]++
+
+			// Every site with devices reports both counts, even when they are zero
+			overdue[site] += 0
+			expiring[site] += 0
+
+			// The next inspection date is midnight at the site, the device is overdue once that day is over
+			if device.NextInspectionDate.Valid && !device.NextInspectionDate.Time.AddDate(0, 0, 1).After(now) {
+				overdue[site]++
+			}
+			if device.ExpireDate.Valid && device.ExpireDate.Time.After(now) && !device.ExpireDate.Time.After(expiringBy) {
+				expiring[site]++
+			}
+		}
+	}
+
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(d.devices, prometheus.GaugeValue, float64(count), key.status, key.deviceType, strconv.Itoa(key.organisation), strconv.Itoa(key.site))
+	}
+	for site, count := range overdue {
+		ch <- prometheus.MustNewConstMetric(d.inspectionsOverdue, prometheus.GaugeValue, float64(count), strconv.Itoa(site.organisation), strconv.Itoa(site.site))
+	}
+	for site, count := range expiring {
+		ch <- prometheus.MustNewConstMetric(d.expiringSoon, prometheus.GaugeValue, float64(count), strconv.Itoa(site.organisation), strconv.Itoa(site.site))
+	}
+
+	recent, err := d.store.CountRecentInspections(RecentInspectionsWindow)
+	if err != nil {
+		d.logger.ErrorContext(context.Background(), "Error collecting inspection metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(d.recentInspections, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.recentInspections, prometheus.GaugeValue, float64(recent))
+}
+
+// HandleGetMetrics serves the metrics in the Prometheus text format to scrapes with the METRICS_TOKEN bearer
+// token, and not at all when no token is set. A metric that fails to collect is left out and logged, the rest
+// are still served.
+func (a *App) HandleGetMetrics() echo.HandlerFunc {
+	metrics := echo.WrapHandler(promhttp.HandlerFor(a.Metrics, promhttp.HandlerOpts{
+		ErrorLog:      slog.NewLogLogger(a.Logger.Handler(), slog.LevelError),
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+	return func(c echo.Context) error {
+		if a.Config.MetricsToken == "" {
+			return echo.ErrNotFound
+		}
+		given, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(a.Config.MetricsToken)) != 1 {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="metrics"`)
+			return echo.ErrUnauthorized
+		}
+		return metrics(c)
+	}
+}
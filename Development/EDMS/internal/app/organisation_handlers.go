@@ -0,0 +1,162 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// organisationKey is where RequireOrganisation keeps the organisation a request works in
+const organisationKey = "organisation_id"
+
+// errNoOrganisation is a login token that does not name an organisation, such as one issued before there were any
+var errNoOrganisation = errors.New("login token has no organisation")
+
+// errNotMember is a login token for an organisation the user has since been removed from
+var errNotMember = errors.New("user is not a member of the token's organisation")
+
+// tokenOrganisationID returns the organisation the login token was issued for
+func tokenOrganisationID(c echo.Context) (int, error) {
+	user, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0, errNoOrganisation
+	}
+
+	claims, ok := user.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, errNoOrganisation
+	}
+	id, ok := claims["organisation_id"].(float64)
+	if !ok || id < 1 || id != float64(int(id)) {
+		return 0, errNoOrganisation
+	}
+	return int(id), nil
+}
+
+// RequireOrganisation middleware, every logged in request works in the organisation of its token.
+// Tokens without one are refused rather than given an organisation the user may not belong to.
+// Membership is checked on every request, so users removed from an organisation lose access at once
+// and not when their token expires.
+func (a *App) RequireOrganisation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := tokenOrganisationID(c)
+		if err != nil {
+			return a.handleError(c, http.StatusUnauthorized, "Log in again to choose an organisation", err)
+		}
+
+		userID, err := loggedInUserID(c)
+		if err != nil {
+			return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+		}
+		organisations, err := a.DB.GetUserOrganisations(userID)
+		if err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+		}
+		if !memberOf(organisations, id) {
+			return a.handleError(c, http.StatusUnauthorized, "You are not a member of this organisation, log in again", errNotMember)
+		}
+
+		c.Set(organisationKey, id)
+		return next(c)
+	}
+}
+
+// organisationID returns the organisation the logged in user is working in, checked by RequireOrganisation
+func organisationID(c echo.Context) int {
+	return c.Get(organisationKey).(int)
+}
+
+// store returns the database limited to the logged in user's organisation, so one organisation
+// cannot read or change another's data
+func (a *App) store(c echo.Context) database.Store {
+	return a.DB.ForOrganisation(organisationID(c))
+}
+
+// HandleGetOrganisations returns the organisations the logged in user belongs to
+func (a *App) HandleGetOrganisations(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	organisations, err := a.DB.GetUserOrganisations(userID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"current":       organisationID(c),
+		"organisations": organisations,
+	})
+}
+
+// HandlePostSwitchOrganisation reissues the login token for another organisation of the logged in user
+func (a *App) HandlePostSwitchOrganisation(c echo.Context) error {
+	switchTo, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid organisation ID",
+			"redirectURL": "/dashboard?error=Invalid organisation ID",
+		})
+	}
+
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	organisations, err := a.DB.GetUserOrganisations(userID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	if !memberOf(organisations, switchTo) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error":       "You are not a member of this organisation",
+			"redirectURL": "/dashboard?error=You are not a member of this organisation",
+		})
+	}
+
+	user, err := a.DB.GetUserByID(userID)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	// The new token expires with the current one, switching does not extend the session
+	expiresAt := time.Now().Add(a.Config.SessionLifetime)
+	if exp, err := c.Get("user").(*jwt.Token).Claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresAt = exp.Time
+	}
+
+	token, err := a.GenerateToken(user, switchTo, expiresAt)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Could not generate token", err)
+	}
+	a.setTokenCookie(c, token, expiresAt)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Organisation switched successfully",
+		"redirectURL": "/dashboard?message=Organisation switched successfully",
+	})
+}
+
+// loggedInUserID returns the ID of the logged in user from their token
+func loggedInUserID(c echo.Context) (int, error) {
+	claims := c.Get("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userIDStr, _ := claims["user_id"].(string)
+	return strconv.Atoi(userIDStr)
+}
+
+func memberOf(organisations []models.Organisation, organisationID int) bool {
+	for _, organisation := range organisations {
+		if organisation.OrganisationID == organisationID {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,12 @@
 package app
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	"github.com/labstack/echo/v4"
 )
@@ -15,8 +18,9 @@ func (a *App) HandleGetAllRooms(c echo.Context) error {
 	}
 
 	buildingId := c.QueryParam("buildingId")
+	floorId := c.QueryParam("floorId")
 
-	rooms, err := a.DB.GetAllRooms(buildingId)
+	rooms, err := a.store(c).GetAllRooms(buildingId, floorId)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -50,7 +54,7 @@ func (a *App) HandleGetRoomByID(c echo.Context) error {
 		})
 	}
 
-	room, err := a.DB.GetRoomByID(roomIdInt)
+	room, err := a.store(c).GetRoomByID(roomIdInt)
 	if err != nil {
 		a.handleError(c, http.StatusNotFound, "Room not found", err)
 		return c.JSON(http.StatusNotFound, map[string]string{
@@ -59,7 +63,8 @@ func (a *App) HandleGetRoomByID(c echo.Context) error {
 		})
 	}
 
-	// Return the results as JSON
+	// Return the results as JSON, with the version edits must be made from
+	setETag(c, room.Version)
 	return c.JSON(http.StatusOK, room)
 }
 
@@ -71,7 +76,7 @@ func (a *App) HandlePostRoom(c echo.Context) error {
 
 	//Pass room name from form
 	roomCode := c.FormValue("addRoomCode")
-	a.handleLogger("Room Code: " + roomCode)
+	a.Logger.DebugContext(c.Request().Context(), "Adding room", "room_code", roomCode)
 
 	// Parse the building ID
 	buildingId := c.FormValue("addRoomBuildingCode")
@@ -91,31 +96,38 @@ func (a *App) HandlePostRoom(c echo.Context) error {
 	}
 
 	// Check if the building exists
-	building, err := a.DB.GetBuildingById(buildingIdInt)
+	building, err := a.store(c).GetBuildingById(buildingIdInt)
 	if err != nil || building == nil {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Building does not exist")
 	}
 
-	_, err = a.DB.GetRoomByCodeAndSite(roomCode, building.SiteID)
+	_, err = a.store(c).GetRoomByCodeAndSite(roomCode, building.SiteID)
 	if err == nil {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Room already exists at this site")
 	}
 
 	// Check if the room already exists at the building
-	_, err = a.DB.GetRoomByCodeAndBuilding(roomCode, buildingIdInt)
+	_, err = a.store(c).GetRoomByCodeAndBuilding(roomCode, buildingIdInt)
 	if err == nil {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Room already exists at this building")
 	}
 
+	// The floor is optional, without one the room goes on the building's default floor
+	floorIdInt, err := a.roomFloorID(c, c.FormValue("addRoomFloor"), buildingIdInt)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/admin?error="+err.Error())
+	}
+
 	var room = models.Room{
 		RoomCode:   roomCode,
 		BuildingID: buildingIdInt,
+		FloorID:    floorIdInt,
 	}
 
 	// Add the room to the database
-	err = a.DB.AddRoom(&room)
+	err = a.store(c).AddRoom(&room)
 	if err != nil {
-		a.handleLogger("Error adding Room: " + err.Error())
+		a.handleLogger(c, "Error adding Room: "+err.Error())
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Error adding room")
 	}
 
@@ -151,7 +163,7 @@ func (a *App) HandlePutRoom(c echo.Context) error {
 	}
 
 	// Check if the room exists
-	_, err = a.DB.GetRoomByID(roomIdInt)
+	current, err := a.store(c).GetRoomByID(roomIdInt)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error":       "Room does not exist",
@@ -159,6 +171,12 @@ func (a *App) HandlePutRoom(c echo.Context) error {
 		})
 	}
 
+	// The edit must be made from the current version of the room
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Room", "/admin", current, current.Version)
+	}
+
 	// Parse the form data from the request body
 	var roomDto models.RoomDto
 	if err := c.Bind(&roomDto); err != nil {
@@ -186,7 +204,7 @@ func (a *App) HandlePutRoom(c echo.Context) error {
 	}
 
 	// Check if the building exists and get the SiteID
-	building, err := a.DB.GetBuildingById(buildingIdInt)
+	building, err := a.store(c).GetBuildingById(buildingIdInt)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error":       "Building does not exist",
@@ -194,33 +212,49 @@ func (a *App) HandlePutRoom(c echo.Context) error {
 		})
 	}
 
-	// Check if the room already exists at the site
-	existingRoomAtSite, err := a.DB.GetRoomByCodeAndSite(roomDto.RoomCode, building.SiteID)
-	if err == nil || existingRoomAtSite != nil {
+	// Check if another room already exists at the site, the room itself can keep its code
+	existingRoomAtSite, err := a.store(c).GetRoomByCodeAndSite(roomDto.RoomCode, building.SiteID)
+	if err == nil && existingRoomAtSite.RoomID != roomIdInt {
 		return c.JSON(http.StatusConflict, map[string]string{
 			"error":       "Room already exists at this site",
 			"redirectURL": "/admin?error=Room already exists at this site",
 		})
 	}
 
-	// Check if the room already exists at the building
-	existingRoom, err := a.DB.GetRoomByCodeAndBuilding(roomDto.RoomCode, buildingIdInt)
-	if err == nil || existingRoom != nil {
+	// Check if another room already exists at the building
+	existingRoom, err := a.store(c).GetRoomByCodeAndBuilding(roomDto.RoomCode, buildingIdInt)
+	if err == nil && existingRoom.RoomID != roomIdInt {
 		return c.JSON(http.StatusConflict, map[string]string{
 			"error":       "Room already exists at this building",
 			"redirectURL": "/admin?error=Room already exists at this building",
 		})
 	}
 
+	// The floor is optional, without one the room keeps its floor or moves to the new building's default floor
+	floorIdInt, err := a.roomFloorID(c, roomDto.FloorID, buildingIdInt)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error(),
+		})
+	}
+
 	// Create a new room object
 	room := models.Room{
 		RoomID:     roomIdInt,
 		RoomCode:   roomDto.RoomCode,
 		BuildingID: buildingIdInt,
+		FloorID:    floorIdInt,
+		Version:    version,
 	}
 
-	// Update the room in the database
-	err = a.DB.UpdateRoom(&room)
+	// Update the room in the database, unless someone else changed it first
+	err = a.store(c).UpdateRoom(&room)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetRoomByID(roomIdInt); err == nil {
+			return versionConflict(c, http.StatusConflict, "Room", "/admin", current, current.Version)
+		}
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error updating room",
@@ -263,7 +297,7 @@ func (a *App) HandleDeleteRoom(c echo.Context) error {
 	}
 
 	// Check if the room exists
-	_, err = a.DB.GetRoomByID(roomIdInt)
+	current, err := a.store(c).GetRoomByID(roomIdInt)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error":       "Room does not exist",
@@ -271,34 +305,55 @@ func (a *App) HandleDeleteRoom(c echo.Context) error {
 		})
 	}
 
-	// Handle foreign key constraints, check if room has any devices
-	devices, err := a.DB.GetDevicesByRoomID(roomIdInt)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error fetching devices",
-			"redirectURL": "/admin?error=Error fetching devices",
-		})
-	}
+	// Only the version the admin saw can be archived
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Room", "/admin", current, current.Version)
+	}
+
+	// Archive the room once no devices are in it, the row is kept for history
+	return a.archiveLocation(c, roomIdInt, location{
+		name:     "Room",
+		children: "devices",
+		dependents: func(tx database.Store) (*models.Dependents, error) {
+			return tx.GetRoomDependents(roomIdInt)
+		},
+		move: func(tx database.Store, to int) error {
+			return tx.MoveRoomDevices(roomIdInt, to)
+		},
+		archive: func(tx database.Store) error {
+			return tx.ArchiveRoom(roomIdInt, strings.TrimSpace(c.QueryParam("reason")), version)
+		},
+		current: func(store database.Store) (interface{}, int, error) {
+			current, err := store.GetRoomByID(roomIdInt)
+			if err != nil {
+				return nil, 0, err
+			}
+			return current, current.Version, nil
+		},
+	})
+}
 
-	if len(devices) > 0 {
-		return c.JSON(http.StatusConflict, map[string]string{
-			"error":       "Can't delete room with associated devices",
-			"redirectURL": "/admin?error=Can't delete room with associated devices",
-		})
+// HandleGetRoomDependents lists what stops a room from being archived
+func (a *App) HandleGetRoomDependents(c echo.Context) error {
+	return a.getDependents(c, "Room", database.Store.GetRoomDependents)
+}
+
+// roomFloorID parses the floor chosen for a room and checks it is in the room's building, 0 means none was chosen
+func (a *App) roomFloorID(c echo.Context, floorId string, buildingID int) (int, error) {
+	if floorId == "" {
+		return 0, nil
 	}
 
-	// Delete the room from the database
-	err = a.DB.DeleteRoom(roomIdInt)
+	floorIdInt, err := strconv.Atoi(floorId)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error deleting room",
-			"redirectURL": "/admin?error=Error deleting room",
-		})
+		return 0, errors.New("Invalid floor ID")
 	}
 
-	// Redirect to admin settings with success message
-	return c.JSON(http.StatusOK, map[string]string{
-		"message":     "Room deleted successfully",
-		"redirectURL": "/admin?message=Room deleted successfully",
-	})
+	floor, err := a.store(c).GetFloorByID(floorIdInt)
+	if err != nil || floor.BuildingID != buildingID {
+		return 0, errors.New("Floor does not exist in this building")
+	}
+
+	return floorIdInt, nil
 }
@@ -2,8 +2,8 @@ package app
 
 import (
 	"net/http"
+	"strings"
 
-	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
@@ -23,8 +23,37 @@ func (a *App) AdminOnly(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// DefaultAdminOnly middleware, used for irreversible operations such as purging records
+func (a *App) DefaultAdminOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := c.Get("user").(*jwt.Token)
+		claims := user.Claims.(jwt.MapClaims)
+
+		if claims["default_admin"] != true {
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error":       "Only the default admin can perform this action",
+				"redirectURL": "/dashboard?error=Only the default admin can perform this action",
+			})
+		}
+		return next(c)
+	}
+}
+
+// RequireIfMatch middleware, used for edits and deletes of versioned rows so they are made from the version the
+// client saw. The handler compares the version, this only refuses requests that do not name one.
+func (a *App) RequireIfMatch(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if strings.TrimSpace(c.Request().Header.Get("If-Match")) == "" {
+			return c.JSON(http.StatusPreconditionRequired, map[string]string{
+				"error":       ifMatchRequired,
+				"redirectURL": "/dashboard?error=" + ifMatchRequired,
+			})
+		}
+		return next(c)
+	}
+}
+
 func (a *App) initRoutes() {
-	secret := config.LoadConfig().JWTSecret
 	// Public routes
 	a.Router.GET("/", a.HandleGetLogin)
 	a.Router.GET("/login", a.HandleGetLogin)
@@ -35,9 +64,19 @@ func (a *App) initRoutes() {
 	a.Router.POST("/login", a.HandlePostLogin)
 	a.Router.GET("/logout", a.HandleGetLogout)
 
+	// Scraped by Prometheus with the METRICS_TOKEN bearer token
+	a.Router.GET("/metrics", a.HandleGetMetrics())
+
+	// Probes for docker-compose, Cloud Run and load balancers
+	a.Router.GET("/healthz", a.HandleGetHealthz)
+	a.Router.GET("/readyz", a.HandleGetReadyz)
+
+	// Subscribed to by calendar apps, the token in the link is the credential
+	a.Router.GET("/calendar/:token", a.HandleGetCalendarFeed)
+
 	// JWT middleware
 	jwtMiddleware := echojwt.WithConfig(echojwt.Config{
-		SigningKey:  []byte(secret),
+		SigningKey:  []byte(a.Config.JWTSecret),
 		TokenLookup: "cookie:token",
 		ErrorHandler: func(c echo.Context, err error) error {
 			return c.Redirect(http.StatusSeeOther, "/")
@@ -46,10 +85,16 @@ func (a *App) initRoutes() {
 
 	// Protected routes
 	protected := a.Router.Group("")
-	protected.Use(jwtMiddleware)
+	protected.Use(jwtMiddleware, a.RequireOrganisation)
 
 	protected.GET("/dashboard", a.HandleGetDashboard)
 
+	// Organisation routes, users work in one of their organisations at a time
+	protected.GET("/api/organisation", a.HandleGetOrganisations)
+	protected.POST("/api/organisation/:id/switch", a.HandlePostSwitchOrganisation)
+	protected.POST("/api/calendar/feed", a.HandlePostCalendarFeed)
+	protected.DELETE("/api/calendar/feed", a.HandleDeleteCalendarFeed)
+
 	// Admin-only routes
 	admin := protected.Group("")
 	admin.Use(a.AdminOnly)
@@ -60,46 +105,103 @@ func (a *App) initRoutes() {
 	admin.GET("/api/inspection", a.HandleGetAllInspectionsByDeviceID)
 	admin.GET("/api/inspection/:id", a.HandleGetInspectionByID)
 	admin.POST("/api/inspection", a.HandlePostInspection)
+	// Inspection round routes
+	admin.GET("/api/inspection-round", a.HandleGetInspectionRounds)
+	admin.GET("/api/inspection-round/:id", a.HandleGetInspectionRoundByID)
+	admin.POST("/api/inspection-round", a.HandlePostInspectionRound)
+	admin.POST("/api/inspection-round/generate", a.HandlePostGenerateInspectionRounds)
+	admin.PUT("/api/inspection-round/:id/inspector", a.HandlePutInspectionRoundInspector)
+	admin.PUT("/api/inspection-round/:id/device/:deviceId/skip", a.HandlePutSkipInspectionRoundDevice)
+	// Offline inspections uploaded by the mobile app
+	admin.POST("/api/sync/inspections", a.HandlePostSyncInspections)
+
+	// Maintenance record routes
+	admin.GET("/api/maintenance", a.HandleGetMaintenanceRecordsByDeviceID)
+	admin.GET("/api/maintenance/:id", a.HandleGetMaintenanceRecordByID)
+	admin.POST("/api/maintenance", a.HandlePostMaintenanceRecord)
 
 	// User management routes - Alex
 	admin.GET("/api/user", a.HandleGetAllUsers)
 	admin.GET("/api/user/:username", a.HandleGetUserByUsername)
-	admin.PUT("/api/user/:id", a.HandlePutUser)
-	admin.DELETE("/api/user/:id", a.HandleDeleteUser)
+	admin.PUT("/api/user/:id", a.HandlePutUser, a.RequireIfMatch)
+	admin.DELETE("/api/user/:id", a.HandleDeleteUser, a.RequireIfMatch)
 	// Site management routes - Alex
 	admin.POST("/api/site", a.HandlePostSite)
 	admin.POST("/api/site/:id", a.HandleEditSite)
-	admin.DELETE("/api/site/:id", a.HandleDeleteSite)
+	admin.DELETE("/api/site/:id", a.HandleDeleteSite, a.RequireIfMatch)
+	admin.GET("/api/site/:id/dependents", a.HandleGetSiteDependents)
 	// Building management routes - Joe
 	admin.POST("/api/building", a.HandlePostBuilding)
-	admin.PUT("/api/building/:id", a.HandleEditBuilding)
-	admin.DELETE("/api/building/:id", a.HandleDeleteBuilding)
+	admin.PUT("/api/building/:id", a.HandleEditBuilding, a.RequireIfMatch)
+	admin.DELETE("/api/building/:id", a.HandleDeleteBuilding, a.RequireIfMatch)
+	admin.GET("/api/building/:id/dependents", a.HandleGetBuildingDependents)
+	// Floor management routes
+	admin.POST("/api/floor", a.HandlePostFloor)
+	admin.PUT("/api/floor/:id", a.HandlePutFloor, a.RequireIfMatch)
+	admin.DELETE("/api/floor/:id", a.HandleDeleteFloor, a.RequireIfMatch)
 	// Room management routes
 	admin.POST("/api/room", a.HandlePostRoom)
-	admin.PUT("/api/room/:id", a.HandlePutRoom)
-	admin.DELETE("/api/room/:id", a.HandleDeleteRoom)
+	admin.PUT("/api/room/:id", a.HandlePutRoom, a.RequireIfMatch)
+	admin.DELETE("/api/room/:id", a.HandleDeleteRoom, a.RequireIfMatch)
+	admin.GET("/api/room/:id/dependents", a.HandleGetRoomDependents)
 	// Device type management routes - James
 	admin.POST("/api/emergency-device-type", a.HandlePostDeviceType)
 	admin.GET("/api/emergency-device-type/:id", a.HandleGetAllDeviceTypeByID)
-	admin.PUT("/api/emergency-device-type/:id", a.HandlePutDeviceType)
-	admin.DELETE("/api/emergency-device-type/:id", a.HandleDeleteDeviceType)
+	admin.PUT("/api/emergency-device-type/:id", a.HandlePutDeviceType, a.RequireIfMatch)
+	admin.DELETE("/api/emergency-device-type/:id", a.HandleDeleteDeviceType, a.RequireIfMatch)
 	// Device management routes - Liam
 	admin.POST("/api/emergency-device", a.HandlePostDevice)
-	admin.PUT("/api/emergency-device/:id", a.HandlePutDevice)
-	admin.DELETE("/api/emergency-device/:id", a.HandleDeleteDevice)
+	admin.PUT("/api/emergency-device/:id", a.HandlePutDevice, a.RequireIfMatch)
+	admin.DELETE("/api/emergency-device/:id", a.HandleDeleteDevice, a.RequireIfMatch)
+	admin.GET("/api/emergency-device/decommissioned", a.HandleGetDecommissionedDevices)
+	admin.POST("/api/emergency-device/:id/replace", a.HandlePostReplaceDevice, a.RequireIfMatch)
+	// Floor plan management routes
+	admin.POST("/api/floor-plan", a.HandlePostFloorPlan)
+	admin.DELETE("/api/floor-plan/:id", a.HandleDeleteFloorPlan)
+	admin.POST("/api/floor-plan/:id/pins", a.HandlePostFloorPlanPin)
+	admin.PUT("/api/floor-plan-pin/:id", a.HandlePutFloorPlanPin)
+	admin.DELETE("/api/floor-plan-pin/:id", a.HandleDeleteFloorPlanPin)
+
+	// Webhook routes, events are sent to the organisation's ticketing and other systems
+	admin.GET("/api/webhook", a.HandleGetWebhooks)
+	admin.POST("/api/webhook", a.HandlePostWebhook)
+	admin.PUT("/api/webhook/:id", a.HandlePutWebhook, a.RequireIfMatch)
+	admin.DELETE("/api/webhook/:id", a.HandleDeleteWebhook, a.RequireIfMatch)
+	admin.GET("/api/webhook/:id/delivery", a.HandleGetWebhookDeliveries)
+	admin.POST("/api/webhook-delivery/:id/redeliver", a.HandlePostRedeliverWebhookDelivery)
+
+	// Purge routes, restricted to the default admin
+	purge := admin.Group("")
+	purge.Use(a.DefaultAdminOnly)
+	purge.DELETE("/api/emergency-device/:id/purge", a.HandlePurgeDevice)
 
 	// Other protected API routes
 	api := protected.Group("/api")
 	api.GET("/emergency-device", a.HandleGetAllDevices)
 	api.GET("/emergency-device/:id", a.HandleGetDeviceByID)
+	api.GET("/emergency-device/:id/chain", a.HandleGetDeviceReplacementChain)
 	api.GET("/emergency-device-type", a.HandleGetAllDeviceTypes)
 	api.GET("/extinguisher-type", a.HandleGetAllExtinguisherTypes)
+	api.GET("/floor", a.HandleGetFloors)
 	api.GET("/room", a.HandleGetAllRooms)
 	api.GET("/room/:id", a.HandleGetRoomByID)
 	api.GET("/building", a.HandleGetAllBuildings)
 	api.GET("/building/:id", a.HandleGetBuildingByID)
 	api.GET("/site", a.HandleGetAllSites)
 	api.GET("/site/:id", a.HamdleGetSiteByID)
+	api.GET("/floor-plan", a.HandleGetFloorPlans)
+	api.GET("/floor-plan/:id/pins", a.HandleGetFloorPlanPins)
+	api.GET("/sync", a.HandleGetSync)
+	api.GET("/stats", a.HandleGetStats)
+	api.GET("/search", a.HandleGetSearch)
+	// Saved device list views, every user keeps their own and shares them with others
+	api.GET("/saved-view", a.HandleGetSavedViews)
+	api.GET("/saved-view/:id", a.HandleGetSavedView)
+	api.POST("/saved-view", a.HandlePostSavedView)
+	api.PUT("/saved-view/:id", a.HandlePutSavedView, a.RequireIfMatch)
+	api.DELETE("/saved-view/:id", a.HandleDeleteSavedView, a.RequireIfMatch)
+	api.PUT("/saved-view/:id/default", a.HandlePutDefaultSavedView)
+	api.DELETE("/saved-view/default", a.HandleDeleteDefaultSavedView)
 
 	// Add any other routes as needed
 }
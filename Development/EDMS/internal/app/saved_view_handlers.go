@@ -0,0 +1,397 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// maxSavedViewName is the longest name of a saved view
+const maxSavedViewName = 100
+
+// HandleGetSavedViews lists the saved views of the logged in user, theirs and those shared with them
+func (a *App) HandleGetSavedViews(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	views, err := a.store(c).GetSavedViews(userID)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching saved views", err)
+	}
+
+	return c.JSON(http.StatusOK, views)
+}
+
+// HandleGetSavedView returns a saved view the logged in user owns or that is shared with them
+func (a *App) HandleGetSavedView(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+	savedViewID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid saved view ID"})
+	}
+
+	view, err := a.store(c).GetSavedViewByID(savedViewID, userID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Saved view not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching saved view", err)
+	}
+
+	setETag(c, view.Version)
+	return c.JSON(http.StatusOK, view)
+}
+
+// HandlePostSavedView saves a view for the logged in user, optionally as their default
+func (a *App) HandlePostSavedView(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	var viewDto models.SavedViewDto
+	if err := c.Bind(&viewDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	view, errorMessage := a.validateSavedView(c, viewDto, userID)
+	if errorMessage != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorMessage})
+	}
+
+	if taken, err := a.savedViewNameTaken(c, view); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error adding saved view", err)
+	} else if taken {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "You already have a saved view named " + view.Name})
+	}
+
+	savedViewID, err := a.store(c).AddSavedView(&view)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error adding saved view", err)
+	}
+
+	if viewDto.IsDefault != nil && *viewDto.IsDefault {
+		if err := a.store(c).SetDefaultSavedView(userID, savedViewID); err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error setting default view", err)
+		}
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":       "Saved view added successfully",
+		"saved_view_id": savedViewID,
+	})
+}
+
+// HandlePutSavedView changes a saved view of the logged in user, views shared with them cannot be changed
+func (a *App) HandlePutSavedView(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+	savedViewID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid saved view ID"})
+	}
+
+	// Views shared with the user are read here too, the update below refuses to change them
+	version := 0
+	if current, err := a.store(c).GetSavedViewByID(savedViewID, userID); err == nil {
+		var ok bool
+		if version, ok = ifMatch(c, current.Version); !ok {
+			return versionConflict(c, http.StatusPreconditionFailed, "Saved view", "/dashboard", current, current.Version)
+		}
+	}
+
+	var viewDto models.SavedViewDto
+	if err := c.Bind(&viewDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	view, errorMessage := a.validateSavedView(c, viewDto, userID)
+	if errorMessage != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorMessage})
+	}
+	view.SavedViewID = savedViewID
+	view.Version = version
+
+	if taken, err := a.savedViewNameTaken(c, view); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error updating saved view", err)
+	} else if taken {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "You already have a saved view named " + view.Name})
+	}
+
+	err = a.store(c).UpdateSavedView(&view)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetSavedViewByID(savedViewID, userID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Saved view", "/dashboard", current, current.Version)
+		}
+	}
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Saved view not found or not yours to change"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error updating saved view", err)
+	}
+
+	if viewDto.IsDefault != nil {
+		if *viewDto.IsDefault {
+			err = a.store(c).SetDefaultSavedView(userID, savedViewID)
+		} else if current, getErr := a.store(c).GetDefaultSavedView(userID); getErr == nil && current.SavedViewID == savedViewID {
+			err = a.store(c).ClearDefaultSavedView(userID)
+		}
+		if err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error setting default view", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Saved view updated successfully"})
+}
+
+// HandleDeleteSavedView deletes a saved view of the logged in user
+func (a *App) HandleDeleteSavedView(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+	savedViewID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid saved view ID"})
+	}
+
+	version := 0
+	if current, err := a.store(c).GetSavedViewByID(savedViewID, userID); err == nil {
+		var ok bool
+		if version, ok = ifMatch(c, current.Version); !ok {
+			return versionConflict(c, http.StatusPreconditionFailed, "Saved view", "/dashboard", current, current.Version)
+		}
+	}
+
+	err = a.store(c).DeleteSavedView(savedViewID, userID, version)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetSavedViewByID(savedViewID, userID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Saved view", "/dashboard", current, current.Version)
+		}
+	}
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Saved view not found or not yours to delete"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error deleting saved view", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Saved view deleted successfully"})
+}
+
+// HandlePutDefaultSavedView makes a saved view the one the logged in user's dashboard opens with
+func (a *App) HandlePutDefaultSavedView(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+	savedViewID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid saved view ID"})
+	}
+
+	err = a.store(c).SetDefaultSavedView(userID, savedViewID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Saved view not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error setting default view", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Default view set successfully"})
+}
+
+// HandleDeleteDefaultSavedView opens the logged in user's dashboard without a saved view again
+func (a *App) HandleDeleteDefaultSavedView(c echo.Context) error {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return a.handleError(c, http.StatusUnauthorized, "Invalid user", err)
+	}
+
+	if err := a.store(c).ClearDefaultSavedView(userID); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error clearing default view", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Default view cleared successfully"})
+}
+
+// validateSavedView checks the name, filters, sort and columns of a view owned by the user, and that the users
+// it is shared with belong to the organisation. A view without columns shows every column.
+func (a *App) validateSavedView(c echo.Context, viewDto models.SavedViewDto, userID int) (models.SavedView, string) {
+	view := models.SavedView{
+		UserID:         userID,
+		Name:           strings.TrimSpace(viewDto.Name),
+		Filters:        viewDto.Filters,
+		SortColumn:     viewDto.SortColumn,
+		SortDescending: viewDto.SortDescending,
+		SharedWith:     []int{},
+	}
+
+	if view.Name == "" {
+		return view, "Name is required"
+	}
+	if utf8.RuneCountInString(view.Name) > maxSavedViewName {
+		return view, "Name is too long, maximum " + strconv.Itoa(maxSavedViewName) + " characters"
+	}
+
+	if view.Filters.SiteID != "" {
+		if _, err := strconv.Atoi(view.Filters.SiteID); err != nil {
+			return view, "Invalid site ID filter"
+		}
+	}
+	if view.Filters.FloorID != "" {
+		if _, err := strconv.Atoi(view.Filters.FloorID); err != nil {
+			return view, "Invalid floor ID filter"
+		}
+	}
+
+	if view.SortColumn != "" && !slices.Contains(models.DeviceListColumns, view.SortColumn) {
+		return view, "Unknown sort column " + view.SortColumn + ", must be one of " + strings.Join(models.DeviceListColumns, ", ")
+	}
+
+	if len(viewDto.Columns) == 0 {
+		view.Columns = append([]string{}, models.DeviceListColumns...)
+	}
+	for _, column := range viewDto.Columns {
+		if !slices.Contains(models.DeviceListColumns, column) {
+			return view, "Unknown column " + column + ", must be one of " + strings.Join(models.DeviceListColumns, ", ")
+		}
+		if !slices.Contains(view.Columns, column) {
+			view.Columns = append(view.Columns, column)
+		}
+	}
+
+	for _, sharedUserID := range viewDto.SharedWith {
+		if sharedUserID == userID || slices.Contains(view.SharedWith, sharedUserID) {
+			continue
+		}
+		if _, err := a.store(c).GetUserByID(sharedUserID); err != nil {
+			return view, "User " + strconv.Itoa(sharedUserID) + " is not in the organisation"
+		}
+		view.SharedWith = append(view.SharedWith, sharedUserID)
+	}
+
+	return view, ""
+}
+
+// savedViewNameTaken reports whether the owner of a view already has another view with its name
+func (a *App) savedViewNameTaken(c echo.Context, view models.SavedView) (bool, error) {
+	views, err := a.store(c).GetSavedViews(view.UserID)
+	if err != nil {
+		return false, err
+	}
+	for _, existing := range views {
+		if existing.UserID == view.UserID && existing.SavedViewID != view.SavedViewID && existing.Name == view.Name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applySavedView narrows listed devices to the room, device type and status filters of a view and sorts them
+// by its sort column. The site, building and floor filters are applied when the devices are listed.
+func applySavedView(devices []models.EmergencyDevice, view *models.SavedView) []models.EmergencyDevice {
+	filters := view.Filters
+	filtered := []models.EmergencyDevice{}
+	for _, device := range devices {
+		if (filters.RoomCode != "" && device.RoomCode != filters.RoomCode) ||
+			(filters.EmergencyDeviceTypeName != "" && device.EmergencyDeviceTypeName != filters.EmergencyDeviceTypeName) ||
+			(filters.Status != "" && device.Status.String != filters.Status) {
+			continue
+		}
+		filtered = append(filtered, device)
+	}
+
+	if view.SortColumn != "" {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			a, aOK := deviceListKey(filtered[i], view.SortColumn)
+			b, bOK := deviceListKey(filtered[j], view.SortColumn)
+			if aOK != bOK {
+				// Empty values go last either way
+				return aOK
+			}
+			if view.SortDescending {
+				return a > b
+			}
+			return a < b
+		})
+	}
+
+	return filtered
+}
+
+// deviceListKey is the value of a device list column that devices sort by, text ignoring case or a time in
+// UTC that sorts as text, false when the device has no value
+func deviceListKey(device models.EmergencyDevice, column string) (string, bool) {
+	text := func(value sql.NullString) (string, bool) {
+		return strings.ToLower(value.String), value.Valid && value.String != ""
+	}
+	instant := func(value sql.NullTime) (string, bool) {
+		return value.Time.UTC().Format("2006-01-02T15:04:05.000000"), value.Valid
+	}
+
+	switch column {
+	case "emergency_device_type_name":
+		return text(sql.NullString{String: device.EmergencyDeviceTypeName, Valid: true})
+	case "extinguisher_type_name":
+		return text(device.ExtinguisherTypeName)
+	case "site_name":
+		return text(sql.NullString{String: device.SiteName, Valid: true})
+	case "building_code":
+		return text(sql.NullString{String: device.BuildingCode, Valid: true})
+	case "room_code":
+		return text(sql.NullString{String: device.RoomCode, Valid: true})
+	case "serial_number":
+		return text(device.SerialNumber)
+	case "size":
+		return text(device.Size)
+	case "status":
+		return text(device.Status)
+	case "manufacture_date":
+		return instant(device.ManufactureDate)
+	case "expire_date":
+		return instant(device.ExpireDate)
+	case "last_inspection_datetime":
+		return instant(device.LastInspectionDateTime)
+	case "next_inspection_date":
+		return instant(device.NextInspectionDate)
+	case "next_due_date":
+		return instant(device.NextDueDate)
+	}
+	return "", false
+}
+
+// defaultSavedView returns the logged in user's default view, nil when they have none or it cannot be read
+// so the dashboard still opens
+func (a *App) defaultSavedView(c echo.Context) *models.SavedView {
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return nil
+	}
+
+	view, err := a.store(c).GetDefaultSavedView(userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			a.handleLogger(c, "Error fetching default view: "+err.Error())
+		}
+		return nil
+	}
+	return view
+}
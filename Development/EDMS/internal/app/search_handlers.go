@@ -0,0 +1,92 @@
+package app
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultSearchLimit is how many results a page of search results has when no limit is given
+	defaultSearchLimit = 20
+	// maxSearchLimit is the most results a page of search results can have
+	maxSearchLimit = 100
+	// minSearchLength is the shortest search text, shorter text has too few trigrams to match on
+	minSearchLength = 2
+	// maxSearchLength is the longest search text
+	maxSearchLength = 100
+)
+
+// searchWord is a run of letters and digits, a word as pg_trgm sees it
+var searchWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// HandleGetSearch searches the devices, rooms and inspection notes of the organisation for ?q=, a page of
+// ?limit= results from ?offset= at a time, with the matching part of each result highlighted
+func (a *App) HandleGetSearch(c echo.Context) error {
+	query := strings.TrimSpace(c.QueryParam("q"))
+	if length := utf8.RuneCountInString(query); length < minSearchLength || length > maxSearchLength {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "q must be between " + strconv.Itoa(minSearchLength) + " and " + strconv.Itoa(maxSearchLength) + " characters",
+		})
+	}
+
+	limit := defaultSearchLimit
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxSearchLimit)})
+		}
+	}
+	offset := 0
+	if offsetParam := c.QueryParam("offset"); offsetParam != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "offset must be 0 or more"})
+		}
+	}
+
+	results, err := a.store(c).Search(query, limit, offset)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error searching", err)
+	}
+
+	for i := range results.Results {
+		results.Results[i].Highlight = highlightMatch(results.Results[i].Value, query)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// highlightMatch escapes a matched value as HTML and marks where it contains the search text, ignoring case.
+// A value that only matched by having similar words has those words marked instead.
+func highlightMatch(value string, query string) string {
+	matches := regexp.MustCompile(`(?i)`+regexp.QuoteMeta(query)).FindAllStringIndex(value, -1)
+	if len(matches) == 0 {
+		for _, word := range searchWord.FindAllStringIndex(value, -1) {
+			if database.WordSimilarity(query, value[word[0]:word[1]]) >= database.WordSimilarityThreshold {
+				matches = append(matches, word)
+			}
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		b.WriteString(html.EscapeString(value[last:match[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(value[match[0]:match[1]]))
+		b.WriteString("</mark>")
+		last = match[1]
+	}
+	b.WriteString(html.EscapeString(value[last:]))
+
+	return b.String()
+}
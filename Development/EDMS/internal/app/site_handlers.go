@@ -2,17 +2,39 @@ package app
 
 import (
 	"database/sql"
+	"errors"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	"github.com/labstack/echo/v4"
 )
 
+// siteMapDir is where uploaded site map images are stored
+const siteMapDir = "./static/site_maps"
+
+// siteTimeZone validates the IANA time zone entered for a site, an empty value keeps the fallback
+func siteTimeZone(value string, fallback string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback, true
+	}
+	if value == "Local" {
+		return "", false
+	}
+	if _, err := time.LoadLocation(value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
 func (a *App) HandlePostSite(c echo.Context) error {
 	// Check if request if a GET request
 	if c.Request().Method != http.MethodPost {
@@ -34,6 +56,12 @@ func (a *App) HandlePostSite(c echo.Context) error {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=All fields are required")
 	}
 
+	// New sites are in the configured time zone unless another one is chosen
+	timeZone, ok := siteTimeZone(c.FormValue("addSiteTimeZone"), a.Config.TimeZone)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Unknown time zone")
+	}
+
 	// Validate site name & address length (site name should be less than 100 characters) (address should be less than 255 characters)
 	if len(siteName) > 100 || len(siteAddress) > 255 {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Site name should be less than 100 characters and address should be less than 255 characters")
@@ -45,7 +73,7 @@ func (a *App) HandlePostSite(c echo.Context) error {
 	}
 
 	// Check if site name is unique
-	_, err = a.DB.GetSiteByName(siteName)
+	_, err = a.store(c).GetSiteByName(siteName)
 	if err == nil {
 		return c.Redirect(http.StatusSeeOther, "/admin?error=Site name already exists")
 	} else if err != sql.ErrNoRows { // If the error is not sql.ErrNoRows, it's a database error
@@ -66,27 +94,9 @@ func (a *App) HandlePostSite(c echo.Context) error {
 		if !allowedExtensions[fileExt] {
 			return c.Redirect(http.StatusSeeOther, "/admin?error=Invalid file type. Allowed types: jpg, jpeg, png, gif, svg")
 		}
-		// Define static directory for site maps
-		staticDir := "./static/site_maps"
-		if _, err := os.Stat(staticDir); os.IsNotExist(err) {
-			os.MkdirAll(staticDir, os.ModePerm) // Create directory if it doesn't exist
-		}
 
 		sanitizedSiteName := strings.ReplaceAll(siteName, " ", "_")
 		sanitizedSiteName = regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(sanitizedSiteName, "")
-		fileName := filepath.Join(staticDir, sanitizedSiteName+fileExt)
-
-		out, err := os.Create(fileName)
-		if err != nil {
-			return c.Redirect(http.StatusInternalServerError, "/admin?error=Error creating file")
-		}
-		defer out.Close()
-
-		// Copy the uploaded file data to the new file
-		_, err = io.Copy(out, file)
-		if err != nil {
-			return c.Redirect(http.StatusInternalServerError, "/admin?error=Error copying file")
-		}
 
 		// Save the relative path as a sql.NullString
 		filePath = sql.NullString{String: "/static/site_maps/" + sanitizedSiteName + fileExt, Valid: true}
@@ -97,13 +107,21 @@ func (a *App) HandlePostSite(c echo.Context) error {
 		SiteName:         siteName,
 		SiteAddress:      siteAddress,
 		SiteMapImagePath: filePath,
+		TimeZone:         timeZone,
 	}
 
-	err = a.DB.AddSite(site)
+	err = a.store(c).AddSite(site)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error saving site", err)
 	}
 
+	// The image is saved once the site is, so a site that could not be added leaves no file behind
+	if file != nil {
+		if err := saveSiteMap(file, "."+filePath.String); err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error saving site map", err)
+		}
+	}
+
 	// Redirect to the admin page with a success message
 	return c.Redirect(http.StatusFound, "/admin?message=Site added successfully")
 }
@@ -141,119 +159,149 @@ func (a *App) HandleEditSite(c echo.Context) error {
 	}
 
 	// Get the existing site by ID
-	existingSite, err := a.DB.GetSiteByID(siteID)
+	existingSite, err := a.store(c).GetSiteByID(siteID)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching site", err)
 	}
 
-	// Check if updated site name is unique
-	siteWithSameName, err := a.DB.GetSiteByName(siteName)
-	if err != nil {
-		if err != sql.ErrNoRows { // If the error is not sql.ErrNoRows, it's a database error
-			return a.handleError(c, http.StatusInternalServerError, "Database error", err)
-		}
-	} else if siteWithSameName.SiteID != existingSite.SiteID {
-		return c.Redirect(http.StatusSeeOther, "/admin?error=Site name already exists")
+	// The edit must be made from the version of the site the admin saw, named by If-Match or by the edit form
+	formVersion := c.FormValue("editSiteVersion")
+	if formVersion == "" && strings.TrimSpace(c.Request().Header.Get("If-Match")) == "" {
+		return c.JSON(http.StatusPreconditionRequired, map[string]string{
+			"error":       ifMatchRequired,
+			"redirectURL": "/admin?error=" + ifMatchRequired,
+		})
+	}
+	version, ok := ifMatch(c, existingSite.Version)
+	if formVersion != "" && formVersion != strconv.Itoa(existingSite.Version) {
+		ok = false
+	}
+	if !ok {
+		return siteConflict(c, http.StatusPreconditionFailed, existingSite)
 	}
 
-	// Initialize filePath as an empty sql.NullString
-	filePath := sql.NullString{String: "", Valid: false}
-
-	// Define static directory for site maps
-	staticDir := "./static/site_maps"
+	// The time zone is kept when none is given
+	timeZone, ok := siteTimeZone(c.FormValue("editSiteTimeZone"), existingSite.TimeZone)
+	if !ok {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Unknown time zone")
+	}
 
 	// Create sanitized site name
 	sanitizedSiteName := strings.ReplaceAll(siteName, " ", "_")
 	sanitizedSiteName = regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(sanitizedSiteName, "")
 
-	// Initialize file extension
-	var fileExt string
+	// The site keeps its map unless a new one is uploaded, or the site is renamed and the map with it.
+	// The files are only changed once the site is saved.
+	siteMapImagePath := existingSite.SiteMapImagePath
+	renameMap := false
 
 	// Retrieve the file from the form
 	file, header, err := c.Request().FormFile("siteMapImgInput")
 	if err == nil {
 		defer file.Close()
 
-		// If a new image is being uploaded and the existing site has an image, delete the old image
-		if existingSite != nil && existingSite.SiteMapImagePath.Valid {
-			oldImagePath := "." + existingSite.SiteMapImagePath.String
-			if err := os.Remove(oldImagePath); err != nil {
-				return c.Redirect(http.StatusInternalServerError, "/admin?error=Error deleting old image")
-			}
-		}
-
 		// Validate file extension
 		// Create unique file name based on the site name
-		fileExt = filepath.Ext(header.Filename)
+		fileExt := filepath.Ext(header.Filename)
 		allowedExtensions := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".svg": true}
 		if !allowedExtensions[fileExt] {
 			return c.Redirect(http.StatusSeeOther, "/admin?error=Invalid file type. Allowed types: jpg, jpeg, png, gif, svg")
 		}
 
-		// Define static directory for site maps
-		if _, err := os.Stat(staticDir); os.IsNotExist(err) {
-			os.MkdirAll(staticDir, os.ModePerm) // Create directory if it doesn't exist
-		}
+		siteMapImagePath = sql.NullString{String: "/static/site_maps/" + sanitizedSiteName + fileExt, Valid: true}
+	} else if siteName != existingSite.SiteName && existingSite.SiteMapImagePath.Valid {
+		// Only rename the file if no new file is uploaded
+		fileExt := filepath.Ext(existingSite.SiteMapImagePath.String)
+		siteMapImagePath = sql.NullString{String: "/static/site_maps/" + sanitizedSiteName + fileExt, Valid: true}
+		renameMap = true
+	}
 
-		sanitizedSiteName = strings.ReplaceAll(siteName, " ", "_")
-		sanitizedSiteName = regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(sanitizedSiteName, "")
-		fileName := filepath.Join(staticDir, sanitizedSiteName+fileExt)
+	site := &models.Site{
+		SiteID:           existingSite.SiteID,
+		SiteName:         siteName,
+		SiteAddress:      siteAddress,
+		SiteMapImagePath: siteMapImagePath,
+		TimeZone:         timeZone,
+		Version:          version,
+	}
 
-		out, err := os.Create(fileName)
-		if err != nil {
-			return c.Redirect(http.StatusInternalServerError, "/admin?error=Error creating file")
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		// Check if updated site name is unique
+		siteWithSameName, err := tx.GetSiteByName(siteName)
+		if err == nil && siteWithSameName.SiteID != existingSite.SiteID {
+			return rejected("Site name already exists")
 		}
-		defer out.Close()
-
-		// Copy the uploaded file data to the new file
-		_, err = io.Copy(out, file)
-		if err != nil {
-			return c.Redirect(http.StatusInternalServerError, "/admin?error=Error copying file")
+		if err != nil && err != sql.ErrNoRows { // If the error is not sql.ErrNoRows, it's a database error
+			return err
 		}
 
-		// Save the relative path as a sql.NullString
-		filePath = sql.NullString{String: "/static/site_maps/" + sanitizedSiteName + fileExt, Valid: true}
-	} else {
-		fileExt = filepath.Ext(existingSite.SiteMapImagePath.String)
+		// Update the site, unless someone else changed it first
+		return tx.UpdateSite(site)
+	})
+	var rejection rejected
+	if errors.As(err, &rejection) {
+		return c.Redirect(http.StatusSeeOther, "/admin?error="+string(rejection))
+	}
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetSiteByID(siteID); err == nil {
+			return siteConflict(c, http.StatusConflict, current)
+		}
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error saving site", err)
 	}
 
-	// check if the site name has changed and a map exists
-	if siteName != existingSite.SiteName && existingSite.SiteMapImagePath.Valid {
-		// Only rename the file if no new file is uploaded
-		if err != nil {
-			oldImagePath := "." + existingSite.SiteMapImagePath.String
-			newImagePath := filepath.Join(staticDir, sanitizedSiteName+fileExt)
-			if err := os.Rename(oldImagePath, newImagePath); err != nil {
-				return c.Redirect(http.StatusInternalServerError, "/admin?error=Error renaming image")
-			}
+	// Now the site is saved, put its map where the site says it is
+	oldImagePath := "." + existingSite.SiteMapImagePath.String
+	newImagePath := "." + siteMapImagePath.String
+	if file != nil {
+		if err := saveSiteMap(file, newImagePath); err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error saving site map", err)
+		}
 
-			// Update the file path
-			filePath = sql.NullString{String: "/static/site_maps/" + sanitizedSiteName + fileExt, Valid: true}
+		// The old image is deleted unless the new one replaced it
+		if existingSite.SiteMapImagePath.Valid && oldImagePath != newImagePath {
+			if err := os.Remove(oldImagePath); err != nil && !os.IsNotExist(err) {
+				return a.handleError(c, http.StatusInternalServerError, "Error deleting old image", err)
+			}
+		}
+	} else if renameMap {
+		if err := os.Rename(oldImagePath, newImagePath); err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error renaming image", err)
 		}
 	}
 
-	// Save site information and file path in the database
-	var siteMapImagePath sql.NullString
-	if filePath.Valid {
-		siteMapImagePath = filePath
-	} else {
-		siteMapImagePath = existingSite.SiteMapImagePath
-	}
+	// Respond to the client
+	return c.Redirect(http.StatusFound, "/admin?message=Site updated successfully")
+}
 
-	site := &models.Site{
-		SiteID:           existingSite.SiteID,
-		SiteName:         siteName,
-		SiteAddress:      siteAddress,
-		SiteMapImagePath: siteMapImagePath, // Use the existing path if no new file was uploaded
+// saveSiteMap writes an uploaded site map image to a path in the site map folder
+func saveSiteMap(file io.Reader, path string) error {
+	if err := os.MkdirAll(siteMapDir, os.ModePerm); err != nil { // Create directory if it doesn't exist
+		return err
 	}
 
-	err = a.DB.UpdateSite(site)
+	out, err := os.Create(path)
 	if err != nil {
-		return a.handleError(c, http.StatusInternalServerError, "Error saving site", err)
+		return err
 	}
+	defer out.Close()
 
-	// Respond to the client
-	return c.Redirect(http.StatusFound, "/admin?message=Site updated successfully")
+	// Copy the uploaded file data to the new file
+	if _, err := io.Copy(out, file); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// siteConflict answers an edit of a site someone else changed. API clients get the current site,
+// the edit form, which cannot send If-Match, goes back to the admin page with the error.
+func siteConflict(c echo.Context, status int, current *models.Site) error {
+	if c.Request().Header.Get("If-Match") == "" {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Site has been changed by someone else, review the changes and try again")
+	}
+
+	return versionConflict(c, status, "Site", "/admin", current, current.Version)
 }
 
 func (a *App) HandleDeleteSite(c echo.Context) error {
@@ -269,7 +317,7 @@ func (a *App) HandleDeleteSite(c echo.Context) error {
 	siteID := c.Param("id")
 
 	// Get the site by ID
-	site, err := a.DB.GetSiteByID(siteID)
+	site, err := a.store(c).GetSiteByID(siteID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error fetching site",
@@ -277,94 +325,47 @@ func (a *App) HandleDeleteSite(c echo.Context) error {
 		})
 	}
 
-	// Check if the site has any emergency devices
-	emergencyDevices, err := a.DB.GetAllDevices(siteID, "")
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error fetching emergency devices",
-			"redirectURL": "/admin?error=Error fetching emergency devices",
-		})
-	}
-
-	// Check if the site has any emergency devices
-	if len(emergencyDevices) > 0 {
-		return c.JSON(http.StatusOK, map[string]string{
-			"error":       "Cannot delete site with associated emergency devices",
-			"redirectURL": "/admin?error=Cannot delete site with associated emergency devices",
-		})
-	}
-
-	// Check if the site has any rooms
-	rooms, err := a.DB.GetRoomsBySiteID(siteID)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error fetching rooms",
-			"redirectURL": "/admin?error=Error fetching rooms",
-		})
-	}
-
-	// Check if the site has any rooms
-	if len(rooms) > 0 {
-		return c.JSON(http.StatusOK, map[string]string{
-			"error":       "Cannot delete site with associated rooms",
-			"redirectURL": "/admin?error=Cannot delete site with associated rooms",
-		})
-	}
-
-	// Handle foreign key constraints
-	// Check if the site has any buildings
-	buildings, err := a.DB.GetAllBuildings(siteID)
-
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error fetching buildings",
-			"redirectURL": "/admin?error=Error fetching buildings",
-		})
-	}
-
-	if len(buildings) > 0 {
-		return c.JSON(http.StatusOK, map[string]string{
-			"error":       "Cannot delete site with associated buildings",
-			"redirectURL": "/admin?error=Cannot delete site with associated buildings",
-		})
-	}
-
-	// Delete the site from the database
-	err = a.DB.DeleteSite(siteID)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error":       "Error deleting site",
-			"redirectURL": "/admin?error=Error deleting site",
-		})
-	}
-
-	// Check if the site has a map image
-	if site.SiteMapImagePath.Valid {
-		// Delete the map image file
-		imagePath := "." + site.SiteMapImagePath.String
-		if err := os.Remove(imagePath); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error":       "Error deleting site map image",
-				"redirectURL": "/admin?error=Error deleting site map image",
-			})
-
-		}
-	}
-
-	// Respond to the client
-	return c.JSON(http.StatusOK, map[string]string{
-		"message":     "Site deleted successfully",
-		"redirectURL": "/admin?message=Site deleted successfully",
+	// Only the version the admin saw can be archived
+	version, ok := ifMatch(c, site.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Site", "/admin", site, site.Version)
+	}
+
+	// Archive the site once nothing is under it, the map image is kept with the archived record
+	return a.archiveLocation(c, site.SiteID, location{
+		name:     "Site",
+		children: "buildings",
+		dependents: func(tx database.Store) (*models.Dependents, error) {
+			return tx.GetSiteDependents(site.SiteID)
+		},
+		move: func(tx database.Store, to int) error {
+			return tx.MoveSiteBuildings(site.SiteID, to)
+		},
+		archive: func(tx database.Store) error {
+			return tx.ArchiveSite(strconv.Itoa(site.SiteID), strings.TrimSpace(c.QueryParam("reason")), version)
+		},
+		current: func(store database.Store) (interface{}, int, error) {
+			current, err := store.GetSiteByID(siteID)
+			if err != nil {
+				return nil, 0, err
+			}
+			return current, current.Version, nil
+		},
 	})
 }
 
+// HandleGetSiteDependents lists what stops a site from being archived
+func (a *App) HandleGetSiteDependents(c echo.Context) error {
+	return a.getDependents(c, "Site", database.Store.GetSiteDependents)
+}
+
 func (a *App) HandleGetAllSites(c echo.Context) error {
 	// Check if request if a POST request
 	if c.Request().Method != http.MethodGet {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	sites, err := a.DB.GetAllSites()
+	sites, err := a.store(c).GetAllSites()
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -380,11 +381,12 @@ func (a *App) HamdleGetSiteByID(c echo.Context) error {
 	}
 
 	id := c.Param("id")
-	site, err := a.DB.GetSiteByID(id)
+	site, err := a.store(c).GetSiteByID(id)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
-	// Return the results as JSON
+	// Return the results as JSON, with the version edits must be made from
+	setETag(c, site.Version)
 	return c.JSON(http.StatusOK, site)
 }
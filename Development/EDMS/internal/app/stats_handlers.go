@@ -0,0 +1,267 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// statsPeriodMonths is how far back the inspection statistics go when no from date is given
+	statsPeriodMonths = 12
+	// statsExpiryQuarters is how many quarters of expiry dates are counted, starting with the current one
+	statsExpiryQuarters = 4
+)
+
+// overdueBuckets are the ranges of days overdue devices are counted in, the last is open ended
+var overdueBuckets = []models.OverdueBucket{
+	{Label: "0-30 days", MinDays: 0, MaxDays: 30},
+	{Label: "31-60 days", MinDays: 31, MaxDays: 60},
+	{Label: "61-90 days", MinDays: 61, MaxDays: 90},
+	{Label: "Over 90 days", MinDays: 91, MaxDays: -1},
+}
+
+// HandleGetStats returns the compliance KPIs of the dashboard, optionally for one site with ?site_id=.
+// Inspections are counted from ?from= to ?to= (YYYY-MM-DD), by default the last 12 months; compliance,
+// overdue devices and expiry dates are as of now.
+func (a *App) HandleGetStats(c echo.Context) error {
+	siteId := c.QueryParam("site_id")
+	if siteId != "" {
+		if _, err := strconv.Atoi(siteId); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid site ID"})
+		}
+		if _, err := a.store(c).GetSiteByID(siteId); err == sql.ErrNoRows {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Site not found"})
+		} else if err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error fetching site", err)
+		}
+	}
+
+	now := time.Now()
+	today := now.In(a.Config.Location())
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if toParam := c.QueryParam("to"); toParam != "" {
+		parsed, err := time.Parse("2006-01-02", toParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid to date, must be YYYY-MM-DD"})
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, -statsPeriodMonths, 1)
+	if fromParam := c.QueryParam("from"); fromParam != "" {
+		parsed, err := time.Parse("2006-01-02", fromParam)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid from date, must be YYYY-MM-DD"})
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must not be after to"})
+	}
+
+	stats, err := a.dashboardStats(a.store(c), siteId, from, to, now)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error calculating statistics", err)
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
+// dashboardStats works out the KPIs of the in-service devices from their due dates, the way the device list
+// shows them, and the failure rates and inspector workload from the inspections of the period
+func (a *App) dashboardStats(store database.Store, siteId string, from time.Time, to time.Time, now time.Time) (*models.Stats, error) {
+	devices, err := store.GetAllDevices(siteId, "", "")
+	if err != nil {
+		return nil, err
+	}
+	counts, err := store.GetInspectionCounts(siteId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.Stats{
+		GeneratedAt:            now,
+		From:                   from.Format("2006-01-02"),
+		To:                     to.Format("2006-01-02"),
+		InspectionsByInspector: counts.ByInspector,
+	}
+	stats.Compliance = complianceStats(devices, now)
+	stats.Overdue = a.overdueStats(devices, now)
+	stats.ExpiringByQuarter = a.expiringByQuarter(devices, now)
+	stats.FailureByDeviceType, stats.FailureByExtinguisher = failureRates(counts.ByType)
+
+	return stats, nil
+}
+
+// compliant reports whether a device has been inspected, is not due for inspection or service, has not expired
+// and did not fail its last inspection
+func compliant(device models.EmergencyDevice, now time.Time) bool {
+	switch {
+	case !device.LastInspectionDateTime.Valid:
+		return false
+	case !device.NextDueDate.Valid || !device.NextDueDate.Time.After(now):
+		return false
+	case device.ExpireDate.Valid && !device.ExpireDate.Time.After(now):
+		return false
+	case device.Status.Valid && (device.Status.String == "Inspection Failed" || device.Status.String == "Expired"):
+		return false
+	default:
+		return true
+	}
+}
+
+// percentage is part of total as a percentage to one decimal place, 100 when there is nothing to count
+func percentage(part int, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return math.Round(float64(part)*1000/float64(total)) / 10
+}
+
+// complianceStats counts the compliant devices in total, per site and per building
+func complianceStats(devices []models.EmergencyDevice, now time.Time) models.ComplianceStats {
+	stats := models.ComplianceStats{Sites: []models.SiteCompliance{}}
+	sites := map[string]*models.SiteCompliance{}
+	buildings := map[string]map[string]*models.BuildingCompliance{}
+
+	for _, device := range devices {
+		site, ok := sites[device.SiteName]
+		if !ok {
+			site = &models.SiteCompliance{SiteName: device.SiteName}
+			sites[device.SiteName] = site
+			buildings[device.SiteName] = map[string]*models.BuildingCompliance{}
+		}
+		building, ok := buildings[device.SiteName][device.BuildingCode]
+		if !ok {
+			building = &models.BuildingCompliance{BuildingCode: device.BuildingCode}
+			buildings[device.SiteName][device.BuildingCode] = building
+		}
+
+		stats.Devices++
+		site.Devices++
+		building.Devices++
+		if compliant(device, now) {
+			stats.Compliant++
+			site.Compliant++
+			building.Compliant++
+		}
+	}
+
+	stats.Percentage = percentage(stats.Compliant, stats.Devices)
+	for name, site := range sites {
+		site.Percentage = percentage(site.Compliant, site.Devices)
+		site.Buildings = []models.BuildingCompliance{}
+		for _, building := range buildings[name] {
+			building.Percentage = percentage(building.Compliant, building.Devices)
+			site.Buildings = append(site.Buildings, *building)
+		}
+		sort.Slice(site.Buildings, func(i, j int) bool { return site.Buildings[i].BuildingCode < site.Buildings[j].BuildingCode })
+		stats.Sites = append(stats.Sites, *site)
+	}
+	sort.Slice(stats.Sites, func(i, j int) bool { return stats.Sites[i].SiteName < stats.Sites[j].SiteName })
+
+	return stats
+}
+
+// overdueStats counts the devices past their next due date by how many days, at the local date of their site
+func (a *App) overdueStats(devices []models.EmergencyDevice, now time.Time) models.OverdueStats {
+	stats := models.OverdueStats{Buckets: append([]models.OverdueBucket(nil), overdueBuckets...)}
+
+	for _, device := range devices {
+		if !device.LastInspectionDateTime.Valid {
+			stats.NeverInspected++
+			continue
+		}
+		if !device.NextDueDate.Valid || device.NextDueDate.Time.After(now) {
+			continue
+		}
+
+		location := a.siteLocation(device.SiteTimeZone)
+		today := now.In(location)
+		due := device.NextDueDate.Time.In(location)
+		// Counted in calendar days so a daylight saving change does not move a device between buckets
+		days := int(math.Round(time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC).
+			Sub(time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24))
+
+		stats.Devices++
+		for i := range stats.Buckets {
+			bucket := &stats.Buckets[i]
+			if days >= bucket.MinDays && (bucket.MaxDays < 0 || days <= bucket.MaxDays) {
+				bucket.Devices++
+				break
+			}
+		}
+	}
+
+	return stats
+}
+
+// quarterOf is the YYYY-Qn label of the quarter a date is in
+func quarterOf(date time.Time) string {
+	return fmt.Sprintf("%d-Q%d", date.Year(), (int(date.Month())-1)/3+1)
+}
+
+// expiringByQuarter counts the devices that expire in the current quarter and the ones after it,
+// by the date they expire at their site. Devices that have already expired are counted as not compliant.
+func (a *App) expiringByQuarter(devices []models.EmergencyDevice, now time.Time) []models.QuarterCount {
+	today := now.In(a.Config.Location())
+	start := time.Date(today.Year(), today.Month()-(today.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+
+	quarters := make([]models.QuarterCount, statsExpiryQuarters)
+	byQuarter := map[string]*models.QuarterCount{}
+	for i := range quarters {
+		quarters[i].Quarter = quarterOf(start.AddDate(0, 3*i, 0))
+		byQuarter[quarters[i].Quarter] = &quarters[i]
+	}
+
+	for _, device := range devices {
+		if !device.ExpireDate.Valid || !device.ExpireDate.Time.After(now) {
+			continue
+		}
+		if quarter, ok := byQuarter[quarterOf(device.ExpireDate.Time.In(a.siteLocation(device.SiteTimeZone)))]; ok {
+			quarter.Devices++
+		}
+	}
+
+	return quarters
+}
+
+// failureRates adds up the inspection counts by device type, and by extinguisher type for extinguishers
+func failureRates(counts []models.InspectionTypeCount) ([]models.FailureRate, []models.FailureRate) {
+	add := func(rates []models.FailureRate, name string, count models.InspectionTypeCount) []models.FailureRate {
+		for i := range rates {
+			if rates[i].Name == name {
+				rates[i].Inspections += count.Inspections
+				rates[i].Failed += count.Failed
+				return rates
+			}
+		}
+		return append(rates, models.FailureRate{Name: name, Inspections: count.Inspections, Failed: count.Failed})
+	}
+
+	byDeviceType := []models.FailureRate{}
+	byExtinguisherType := []models.FailureRate{}
+	for _, count := range counts {
+		byDeviceType = add(byDeviceType, count.EmergencyDeviceTypeName, count)
+		if count.ExtinguisherTypeName.Valid {
+			byExtinguisherType = add(byExtinguisherType, count.ExtinguisherTypeName.String, count)
+		}
+	}
+
+	for _, rates := range [][]models.FailureRate{byDeviceType, byExtinguisherType} {
+		for i := range rates {
+			rates[i].Percentage = percentage(rates[i].Failed, rates[i].Inspections)
+		}
+		sort.Slice(rates, func(i, j int) bool { return rates[i].Name < rates[j].Name })
+	}
+
+	return byDeviceType, byExtinguisherType
+}
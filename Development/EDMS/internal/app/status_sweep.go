@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+)
+
+// StatusSweepInterval is how often device statuses are brought up to date, so expired devices are reported
+// without anyone running recompute statuses
+const StatusSweepInterval = time.Hour
+
+// StatusSweeper brings the device statuses of every organisation up to date on a schedule. The status changes
+// queue device.status_changed in the webhook outbox, which the WebhookDispatcher sends.
+type StatusSweeper struct {
+	Store  database.Store
+	Logger *slog.Logger
+	Now    func() time.Time // The clock, replaced in tests
+}
+
+// NewStatusSweeper creates a sweeper for the devices of a store
+func NewStatusSweeper(store database.Store, logger *slog.Logger) *StatusSweeper {
+	return &StatusSweeper{Store: store, Logger: logger, Now: time.Now}
+}
+
+// Run sweeps the device statuses when it starts and every interval, until the context is done
+func (s *StatusSweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		changed, err := s.Sweep()
+		if err != nil {
+			s.Logger.ErrorContext(ctx, "Error sweeping device statuses", "error", err)
+		}
+		if changed > 0 {
+			s.Logger.InfoContext(ctx, "Updated device statuses", "changed", changed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep brings the status of every organisation's devices up to date, queueing device.status_changed
+// for each device that has expired or fallen due since, and returns how many changed
+func (s *StatusSweeper) Sweep() (int, error) {
+	organisations, err := s.Store.GetAllOrganisations()
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, organisation := range organisations {
+		count, err := RecomputeDeviceStatuses(s.Store.ForOrganisation(organisation.OrganisationID), s.Now())
+		changed += count
+		if err != nil {
+			return changed, fmt.Errorf("organisation %d: %w", organisation.OrganisationID, err)
+		}
+	}
+
+	return changed, nil
+}
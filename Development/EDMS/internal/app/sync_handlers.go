@@ -0,0 +1,184 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// maxSyncBatch is the most inspections one sync request may upload
+	maxSyncBatch = 500
+	// syncClockSkew is how far ahead of the server a mobile client's clock may be
+	syncClockSkew = 5 * time.Minute
+)
+
+var clientIDRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// HandlePostSyncInspections records a batch of inspections captured offline by the logged in inspector.
+// Every inspection is recorded or rejected on its own and one sent again is reported as a duplicate,
+// so a client can retry a batch until it has a result for every inspection.
+func (a *App) HandlePostSyncInspections(c echo.Context) error {
+	var batch struct {
+		Inspections []models.SyncInspection `json:"inspections"`
+	}
+	if err := c.Bind(&batch); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+	if len(batch.Inspections) > maxSyncBatch {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("A sync can upload at most %d inspections", maxSyncBatch),
+		})
+	}
+
+	userID, err := loggedInUserID(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid user"})
+	}
+
+	results := make([]models.SyncResult, 0, len(batch.Inspections))
+	for _, item := range batch.Inspections {
+		results = append(results, a.syncInspection(c, userID, item))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// syncInspection records one uploaded inspection and reports what happened to it
+func (a *App) syncInspection(c echo.Context, userID int, item models.SyncInspection) models.SyncResult {
+	clientID := strings.ToLower(strings.TrimSpace(item.ClientID))
+	result := models.SyncResult{ClientID: item.ClientID}
+	reject := func(message string) models.SyncResult {
+		result.Status = models.SyncRejected
+		result.Error = message
+		return result
+	}
+	duplicate := func() (models.SyncResult, bool) {
+		existing, err := a.store(c).GetInspectionByClientID(clientID)
+		if err != nil {
+			return result, false
+		}
+		result.Status = models.SyncDuplicate
+		result.InspectionID = existing.EmergencyDeviceInspectionID
+		return result, true
+	}
+
+	if !clientIDRegex.MatchString(clientID) {
+		return reject("client_id must be a UUID")
+	}
+	if recorded, ok := duplicate(); ok {
+		return recorded
+	}
+
+	inspection, err := a.validateSyncInspection(c, item)
+	if err != nil {
+		return reject(err.Error())
+	}
+	inspection.UserID = userID
+	inspection.ClientID = sql.NullString{String: clientID, Valid: true}
+
+	// The status before the inspection, webhooks hear about it changing
+	var previousStatus sql.NullString
+	if device, err := a.store(c).GetDeviceByID(inspection.EmergencyDeviceID); err == nil {
+		previousStatus = device.Status
+	}
+
+	// The inspection is only recorded with its webhook events
+	err = a.store(c).WithTx(func(tx database.Store) error {
+		if err := tx.AddInspection(inspection); err != nil {
+			return err
+		}
+		return publishInspection(tx, inspection.EmergencyDeviceInspectionID, previousStatus)
+	})
+	if err != nil {
+		// Another upload of the same batch may have recorded it first
+		if recorded, ok := duplicate(); ok {
+			return recorded
+		}
+		a.handleLogger(c, "Error adding synced inspection: "+err.Error())
+		return reject("Error adding inspection")
+	}
+
+	a.Logger.InfoContext(c.Request().Context(), "Synced inspection",
+		"client_id", clientID, "emergency_device_id", inspection.EmergencyDeviceID, "user_id", userID)
+
+	recorded, ok := duplicate()
+	if !ok {
+		return reject("Error adding inspection")
+	}
+	recorded.Status = models.SyncCreated
+	return recorded
+}
+
+// validateSyncInspection checks an uploaded inspection like the inspection form does
+func (a *App) validateSyncInspection(c echo.Context, item models.SyncInspection) (*models.Inspection, error) {
+	device, err := a.store(c).GetDeviceByID(item.EmergencyDeviceID)
+	if err != nil {
+		return nil, errors.New("Invalid Device ID")
+	}
+	if device.DecommissionedAt.Valid {
+		return nil, errors.New("Cannot inspect a decommissioned device")
+	}
+
+	if item.CapturedAt.IsZero() {
+		return nil, errors.New("captured_at is required")
+	}
+	if item.CapturedAt.After(time.Now().Add(syncClockSkew)) {
+		return nil, errors.New("Inspection Date and Time cannot be in the future")
+	}
+
+	if item.InspectionStatus != "Passed" && item.InspectionStatus != "Failed" {
+		return nil, errors.New("inspection_status must be Passed or Failed")
+	}
+	if len(item.Notes) > 255 {
+		return nil, errors.New("Notes must be less than 255 characters")
+	}
+
+	inspection := &models.Inspection{
+		EmergencyDeviceID:  item.EmergencyDeviceID,
+		InspectionDateTime: sql.NullTime{Time: item.CapturedAt, Valid: true},
+		InspectionStatus:   item.InspectionStatus,
+		Notes:              sql.NullString{String: item.Notes, Valid: true},
+	}
+	for key, checked := range item.Checklist {
+		answer := inspection.ChecklistAnswer(key)
+		if answer == nil {
+			return nil, fmt.Errorf("unknown checklist item %q", key)
+		}
+		*answer = sql.NullBool{Bool: checked, Valid: true}
+	}
+
+	return inspection, nil
+}
+
+// HandleGetSync returns the devices, rooms and inspection checklist a mobile client needs.
+// Without a cursor it returns everything, with the cursor of an earlier sync only what changed since.
+func (a *App) HandleGetSync(c echo.Context) error {
+	var since int64
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		var err error
+		since, err = strconv.ParseInt(cursor, 10, 64)
+		if err != nil || since <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid cursor, sync again without one"})
+		}
+	}
+
+	changes, err := a.store(c).GetSyncChanges(since)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching changes", err)
+	}
+
+	return c.JSON(http.StatusOK, struct {
+		*models.SyncChanges
+		Checklist []models.ChecklistItem `json:"checklist"`
+	}{changes, models.InspectionChecklist})
+}
@@ -2,10 +2,13 @@ package app
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
 
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
@@ -18,7 +21,7 @@ func (a *App) HandleGetAllUsers(c echo.Context) error {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	users, err := a.DB.GetAllUsers()
+	users, err := a.store(c).GetAllUsers()
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -36,12 +39,13 @@ func (a *App) HandleGetUserByUsername(c echo.Context) error {
 	}
 
 	username := c.Param("username")
-	user, err := a.DB.GetUserByUsername(username)
+	user, err := a.store(c).GetUserByUsername(username)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
-	// Return the results as JSON
+	// Return the results as JSON, with the version edits must be made from
+	setETag(c, user.Version)
 	return c.JSON(http.StatusOK, user)
 }
 
@@ -58,7 +62,7 @@ func (a *App) HandlePutUser(c echo.Context) error {
 	// Parse form data from the request body
 	var user models.UserDto
 	if err := c.Bind(&user); err != nil {
-		a.handleLogger("Invalid request payload")
+		a.handleLogger(c, "Invalid request payload")
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid request payload",
 			"redirectURL": "/admin?error=Invalid request payload",
@@ -66,9 +70,12 @@ func (a *App) HandlePutUser(c echo.Context) error {
 	}
 
 	// log the user model
-	a.handleLogger(user.CurrentUserID)
-	a.handleLogger(user.DefaultAdmin)
-	a.handleLogger(user.Role)
+	a.Logger.DebugContext(c.Request().Context(), "Updating user",
+		"user_id", userID,
+		"current_user_id", user.CurrentUserID,
+		"default_admin", user.DefaultAdmin,
+		"role", user.Role,
+	)
 
 	user.UserID = userID
 
@@ -108,7 +115,7 @@ func (a *App) HandlePutUser(c echo.Context) error {
 	// Convert the user ID to an integer
 	userIDInt, err := strconv.Atoi(userID)
 	if err != nil {
-		a.handleLogger("Invalid user ID")
+		a.handleLogger(c, "Invalid user ID")
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid user ID",
 			"redirectURL": "/admin?error=Invalid user ID",
@@ -116,8 +123,31 @@ func (a *App) HandlePutUser(c echo.Context) error {
 
 	}
 
+	// The edit must be made from the current version of the user
+	currentUser, err := a.store(c).GetUserByID(userIDInt)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "User does not exist",
+			"redirectURL": "/admin?error=User does not exist",
+		})
+	}
+	version, ok := ifMatch(c, currentUser.Version)
+	if !ok {
+		return userConflict(c, http.StatusPreconditionFailed, currentUser)
+	}
+
+	// Users of several organisations can only be changed by an admin of all of them
+	store, httpErr := a.userStore(c, userIDInt)
+	if httpErr != nil {
+		message := fmt.Sprint(httpErr.Message)
+		return c.JSON(httpErr.Code, map[string]string{
+			"error":       message,
+			"redirectURL": "/admin?error=" + message,
+		})
+	}
+
 	// check if updated user.Username is unique
-	existingUser, err := a.DB.GetUserByUsername(user.Username)
+	existingUser, err := a.store(c).GetUserByUsername(user.Username)
 	if err == nil {
 		if existingUser.UserID != userIDInt {
 			return c.JSON(http.StatusOK, map[string]string{
@@ -133,7 +163,7 @@ func (a *App) HandlePutUser(c echo.Context) error {
 	}
 
 	// check if updated email is unique
-	existingUser, err = a.DB.GetUserByEmail(user.Email)
+	existingUser, err = a.store(c).GetUserByEmail(user.Email)
 	if err == nil {
 		if existingUser.UserID != userIDInt {
 			return c.JSON(http.StatusOK, map[string]string{
@@ -177,10 +207,14 @@ func (a *App) HandlePutUser(c echo.Context) error {
 				Username: user.Username,
 				Email:    user.Email,
 				Role:     user.Role,
+				Version:  version,
 			}
 
 			// Update the user in the database
-			err = a.DB.UpdateUser(user)
+			err = store.UpdateUser(user)
+			if errors.Is(err, database.ErrVersionConflict) {
+				return a.userChanged(c, userIDInt)
+			}
 			// Check for errors
 			// iF there is an error, return an error message
 			if err != nil {
@@ -241,10 +275,14 @@ func (a *App) HandlePutUser(c echo.Context) error {
 				Email:    user.Email,
 				Password: string(hashedPassword),
 				Role:     user.Role,
+				Version:  version,
 			}
 
 			// Update the user in the database
-			err = a.DB.UpdateUserWithPassword(user)
+			err = store.UpdateUserWithPassword(user)
+			if errors.Is(err, database.ErrVersionConflict) {
+				return a.userChanged(c, userIDInt)
+			}
 			if err != nil {
 				return c.JSON(http.StatusInternalServerError, map[string]string{
 					"error":       "Error updating user",
@@ -281,10 +319,14 @@ func (a *App) HandlePutUser(c echo.Context) error {
 			Username: user.Username,
 			Email:    user.Email,
 			Role:     user.Role,
+			Version:  version,
 		}
 
 		// Update the user in the database
-		err = a.DB.UpdateUser(user)
+		err = store.UpdateUser(user)
+		if errors.Is(err, database.ErrVersionConflict) {
+			return a.userChanged(c, userIDInt)
+		}
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
 				"error":       "Error updating user",
@@ -333,7 +375,7 @@ func (a *App) HandleDeleteUser(c echo.Context) error {
 	}
 
 	// Get the user by ID
-	user, err := a.DB.GetUserByID(userIDInt)
+	user, err := a.store(c).GetUserByID(userIDInt)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error fetching user",
@@ -341,6 +383,12 @@ func (a *App) HandleDeleteUser(c echo.Context) error {
 		})
 	}
 
+	// Only the version the admin saw can be deleted
+	version, ok := ifMatch(c, user.Version)
+	if !ok {
+		return userConflict(c, http.StatusPreconditionFailed, user)
+	}
+
 	// Check if the user is trying to delete the default admin
 	if user.DefaultAdmin {
 		return c.JSON(http.StatusOK, map[string]string{
@@ -349,8 +397,11 @@ func (a *App) HandleDeleteUser(c echo.Context) error {
 		})
 	}
 
-	// Delete the user from the database
-	err = a.DB.DeleteUser(userIDInt)
+	// Delete the user from the database, unless someone else changed it first
+	err = a.store(c).DeleteUser(userIDInt, version)
+	if errors.Is(err, database.ErrVersionConflict) {
+		return a.userChanged(c, userIDInt)
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error deleting user",
@@ -373,3 +424,51 @@ func (a *App) HandleDeleteUser(c echo.Context) error {
 		"redirectURL": "/admin?message=User deleted successfully",
 	})
 }
+
+// userStore returns the store to change a user with. A user's name, email, role and password are the same in
+// every organisation they belong to, so the logged in admin must be a member of all of them, and users of
+// several organisations are changed unscoped.
+func (a *App) userStore(c echo.Context, userID int) (database.Store, *echo.HTTPError) {
+	adminID, err := loggedInUserID(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid user")
+	}
+
+	administered, err := a.DB.GetUserOrganisations(adminID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Error fetching user")
+	}
+	organisations, err := a.DB.GetUserOrganisations(userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Error fetching user")
+	}
+	for _, organisation := range organisations {
+		if !memberOf(administered, organisation.OrganisationID) {
+			return nil, echo.NewHTTPError(http.StatusForbidden, "User also belongs to organisations you are not an admin of")
+		}
+	}
+
+	if len(organisations) > 1 {
+		return a.DB, nil
+	}
+	return a.store(c), nil
+}
+
+// userChanged answers an edit of a user someone else changed while it was being made, with the user as it is now
+func (a *App) userChanged(c echo.Context, userID int) error {
+	current, err := a.store(c).GetUserByID(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error updating user",
+			"redirectURL": "/admin?error=Error updating user",
+		})
+	}
+
+	return userConflict(c, http.StatusConflict, current)
+}
+
+// userConflict answers an edit of a stale user with the current one, leaving out the password hash
+func userConflict(c echo.Context, status int, current *models.User) error {
+	current.Password = ""
+	return versionConflict(c, status, "User", "/admin", current, current.Version)
+}
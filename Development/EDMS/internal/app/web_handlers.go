@@ -1,14 +1,13 @@
 package app
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
-// HandleGetDashboard serves the dashboard page
+// HandleGetDashboard serves the dashboard page, opening the device list on the user's default saved view
 func (a *App) HandleGetDashboard(c echo.Context) error {
 	// Check if request if a POST request
 	if c.Request().Method != http.MethodGet {
@@ -19,7 +18,6 @@ func (a *App) HandleGetDashboard(c echo.Context) error {
 
 	user := c.Get("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
-	fmt.Println("User Name: ", claims["username"], "User ID: ", claims["user_id"], "User Role: ", claims["role"], "User Email: ", claims["email"])
 
 	return c.Render(http.StatusOK, "dashboard.html", map[string]interface{}{
 		"username":      claims["username"],
@@ -27,6 +25,7 @@ func (a *App) HandleGetDashboard(c echo.Context) error {
 		"email":         claims["email"],
 		"user_id":       claims["user_id"],
 		"default_admin": claims["default_admin"],
+		"default_view":  a.defaultSavedView(c),
 	})
 }
 
@@ -34,7 +33,6 @@ func (a *App) HandleGetDashboard(c echo.Context) error {
 func (a *App) HandleGetAdmin(c echo.Context) error {
 	user := c.Get("user").(*jwt.Token)
 	claims := user.Claims.(jwt.MapClaims)
-	fmt.Println("User Name: ", claims["username"], "User ID: ", claims["user_id"], "User Role: ", claims["role"], "Default Admin: ", claims["default_admin"])
 	return c.Render(http.StatusOK, "admin.html", map[string]interface{}{
 		"username":      claims["username"],
 		"role":          claims["role"],
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultDeliveryLogLimit is how many deliveries the delivery log returns when no limit is given
+	defaultDeliveryLogLimit = 50
+	// maxDeliveryLogLimit is the most deliveries the delivery log returns at once
+	maxDeliveryLogLimit = 200
+)
+
+// HandleGetWebhooks lists the webhooks of the organisation, without their secrets
+func (a *App) HandleGetWebhooks(c echo.Context) error {
+	webhooks, err := a.store(c).GetAllWebhooks()
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching webhooks", err)
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// HandlePostWebhook adds a webhook. The secret its payloads are signed with is only returned here.
+func (a *App) HandlePostWebhook(c echo.Context) error {
+	var webhookDto models.WebhookDto
+	if err := c.Bind(&webhookDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	webhook, errorMessage := a.validateWebhook(webhookDto)
+	if errorMessage != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorMessage})
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error adding webhook", err)
+	}
+	webhook.Secret = hex.EncodeToString(secret)
+
+	webhookID, err := a.store(c).AddWebhook(&webhook)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error adding webhook", err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message":    "Webhook added successfully",
+		"webhook_id": webhookID,
+		"secret":     webhook.Secret,
+	})
+}
+
+// HandlePutWebhook changes the URL, event types or whether a webhook is active
+func (a *App) HandlePutWebhook(c echo.Context) error {
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid webhook ID"})
+	}
+
+	// The edit must be made from the current version of the webhook
+	current, err := a.store(c).GetWebhookByID(webhookID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching webhook", err)
+	}
+	version, ok := ifMatch(c, current.Version)
+	if !ok {
+		return versionConflict(c, http.StatusPreconditionFailed, "Webhook", "/admin", current, current.Version)
+	}
+
+	var webhookDto models.WebhookDto
+	if err := c.Bind(&webhookDto); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request data"})
+	}
+
+	webhook, errorMessage := a.validateWebhook(webhookDto)
+	if errorMessage != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errorMessage})
+	}
+	webhook.WebhookID = webhookID
+	webhook.Version = version
+
+	err = a.store(c).UpdateWebhook(&webhook)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetWebhookByID(webhookID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Webhook", "/admin", current, current.Version)
+		}
+	}
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error updating webhook", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Webhook updated successfully"})
+}
+
+// HandleDeleteWebhook deletes a webhook, its deliveries that have not been sent are dropped
+func (a *App) HandleDeleteWebhook(c echo.Context) error {
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid webhook ID"})
+	}
+
+	// Only the version the admin saw can be deleted
+	version := 0
+	if current, err := a.store(c).GetWebhookByID(webhookID); err == nil {
+		var ok bool
+		if version, ok = ifMatch(c, current.Version); !ok {
+			return versionConflict(c, http.StatusPreconditionFailed, "Webhook", "/admin", current, current.Version)
+		}
+	}
+
+	err = a.store(c).DeleteWebhook(webhookID, version)
+	if errors.Is(err, database.ErrVersionConflict) {
+		if current, err := a.store(c).GetWebhookByID(webhookID); err == nil {
+			return versionConflict(c, http.StatusConflict, "Webhook", "/admin", current, current.Version)
+		}
+	}
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error deleting webhook", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+}
+
+// HandleGetWebhookDeliveries returns the delivery log of a webhook, the latest ?limit= deliveries with every attempt
+func (a *App) HandleGetWebhookDeliveries(c echo.Context) error {
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid webhook ID"})
+	}
+
+	limit := defaultDeliveryLogLimit
+	if limitParam := c.QueryParam("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > maxDeliveryLogLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxDeliveryLogLimit)})
+		}
+	}
+
+	if _, err := a.store(c).GetWebhookByID(webhookID); err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Webhook not found"})
+	} else if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching webhook", err)
+	}
+
+	deliveries, err := a.store(c).GetWebhookDeliveries(webhookID, limit)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching webhook deliveries", err)
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// HandlePostRedeliverWebhookDelivery sends a delivered or failed delivery again, with a fresh set of retries
+func (a *App) HandlePostRedeliverWebhookDelivery(c echo.Context) error {
+	deliveryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid delivery ID"})
+	}
+
+	err = a.store(c).RedeliverWebhookDelivery(deliveryID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Delivery not found or still pending"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error queueing delivery", err)
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "Delivery queued to be sent again"})
+}
+
+// validateWebhook checks the URL and event types of a webhook. URLs naming localhost or an address that is not
+// public are refused unless WEBHOOKS_ALLOW_LOCAL is set, names resolving to one are refused when sending.
+// Plain http is allowed so webhooks can be tested against a receiver on the local machine.
+func (a *App) validateWebhook(webhookDto models.WebhookDto) (models.Webhook, string) {
+	webhook := models.Webhook{URL: strings.TrimSpace(webhookDto.URL), IsActive: true}
+	if webhookDto.IsActive != nil {
+		webhook.IsActive = *webhookDto.IsActive
+	}
+
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return webhook, "URL must be an http or https URL"
+	}
+	if len(webhook.URL) > 2048 {
+		return webhook, "URL is too long, maximum 2048 characters"
+	}
+	if !a.Config.WebhooksAllowLocal {
+		host := strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+		ip, err := netip.ParseAddr(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || (err == nil && !publicAddress(ip)) {
+			return webhook, "URL must be a public address, webhooks cannot be sent to local or private addresses"
+		}
+	}
+
+	if len(webhookDto.EventTypes) == 0 {
+		return webhook, "At least one event type is required"
+	}
+	for _, eventType := range webhookDto.EventTypes {
+		if !slices.Contains(models.WebhookEventTypes, eventType) {
+			return webhook, "Unknown event type " + eventType + ", must be one of " + strings.Join(models.WebhookEventTypes, ", ")
+		}
+		if !slices.Contains(webhook.EventTypes, eventType) {
+			webhook.EventTypes = append(webhook.EventTypes, eventType)
+		}
+	}
+
+	return webhook, ""
+}
@@ -0,0 +1,386 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// WebhookPollInterval is how often the outbox is checked for deliveries that are due
+	WebhookPollInterval = 10 * time.Second
+	// WebhookMaxAttempts is how many times a delivery is sent before it is given up as failed
+	WebhookMaxAttempts = 10
+	// WebhookSignatureTolerance is how old a signature receivers should accept, to stop old payloads being replayed
+	WebhookSignatureTolerance = 5 * time.Minute
+
+	// webhookTimeout is how long a receiver has to answer
+	webhookTimeout = 10 * time.Second
+	// webhookBatchSize is how many deliveries are claimed from the outbox at a time
+	webhookBatchSize = 20
+	// webhookLease holds claimed deliveries back from other servers while a batch is sent, one at a time
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+	// webhookFirstRetry is the wait after the first failure, it doubles with every failure after that
+	webhookFirstRetry = 30 * time.Second
+	// webhookMaxRetry caps the wait between attempts
+	webhookMaxRetry = 6 * time.Hour
+)
+
+// webhookPayload is the JSON body of every webhook request
+type webhookPayload struct {
+	EventID   string      `json:"event_id"` // The same on every retry, receivers use it to ignore repeats
+	EventType string      `json:"event_type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// newEventID returns a random (version 4) UUID
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// publishEvent queues an event about a device in the outbox of the webhooks subscribed to it
+func publishEvent(store database.Store, eventType string, deviceID int, data interface{}) error {
+	eventID, err := newEventID()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(webhookPayload{EventID: eventID, EventType: eventType, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	_, err = store.AddWebhookEvent(&models.WebhookEvent{
+		EventID:           eventID,
+		EventType:         eventType,
+		EmergencyDeviceID: deviceID,
+		Payload:           payload,
+	})
+	return err
+}
+
+// publishDeviceCreated queues device.created for a device that has just been added
+func publishDeviceCreated(store database.Store, deviceID int) error {
+	device, err := store.GetDeviceByID(deviceID)
+	if err != nil {
+		return err
+	}
+
+	return publishEvent(store, models.EventDeviceCreated, deviceID, map[string]interface{}{"device": device})
+}
+
+// publishStatusChange queues device.status_changed when a device's status is no longer the previous one
+func publishStatusChange(store database.Store, deviceID int, previous sql.NullString) error {
+	device, err := store.GetDeviceByID(deviceID)
+	if err != nil {
+		return err
+	}
+	if device.Status == previous {
+		return nil
+	}
+
+	return publishEvent(store, models.EventDeviceStatusChanged, deviceID, map[string]interface{}{
+		"device":          device,
+		"previous_status": previous,
+	})
+}
+
+// publishInspection queues the events of an inspection that has just been added: inspection.created,
+// inspection.failed when it failed, workorder.created when it requires a work order and
+// device.status_changed when it changed the status of the device
+func publishInspection(store database.Store, inspectionID int, previousStatus sql.NullString) error {
+	inspection, err := store.GetInspectionByID(inspectionID)
+	if err != nil {
+		return err
+	}
+	device, err := store.GetDeviceByID(inspection.EmergencyDeviceID)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{"inspection": inspection, "device": device}
+	eventTypes := []string{models.EventInspectionCreated}
+	if inspection.InspectionStatus == "Failed" {
+		eventTypes = append(eventTypes, models.EventInspectionFailed)
+	}
+	if inspection.WorkOrderRequired.Bool {
+		eventTypes = append(eventTypes, models.EventWorkOrderCreated)
+	}
+	for _, eventType := range eventTypes {
+		if err := publishEvent(store, eventType, device.EmergencyDeviceID, data); err != nil {
+			return err
+		}
+	}
+
+	return publishStatusChange(store, device.EmergencyDeviceID, previousStatus)
+}
+
+// SignWebhookPayload returns the X-EDMS-Signature header of a payload sent at a time: the Unix time and
+// the hex HMAC-SHA256 of the time, a dot and the payload, keyed with the webhook's secret
+func SignWebhookPayload(secret string, timestamp time.Time, payload []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(payload)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks an X-EDMS-Signature header, as a receiver should, rejecting signatures
+// that do not match and ones older than WebhookSignatureTolerance
+func VerifyWebhookSignature(secret string, header string, payload []byte, now time.Time) error {
+	var unix, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			unix = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || signature == "" {
+		return errors.New("malformed signature header")
+	}
+	timestamp := time.Unix(seconds, 0)
+	if now.Sub(timestamp) > WebhookSignatureTolerance || timestamp.Sub(now) > WebhookSignatureTolerance {
+		return errors.New("signature timestamp is outside the tolerance")
+	}
+
+	expected := SignWebhookPayload(secret, timestamp, payload)
+	if !hmac.Equal([]byte(expected), []byte("t="+unix+",v1="+signature)) {
+		return errors.New("signature does not match")
+	}
+
+	return nil
+}
+
+// webhookRetryDelay is the wait before the next attempt after a number of failed attempts
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookFirstRetry
+	for i := 1; i < attempts && delay < webhookMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxRetry {
+		delay = webhookMaxRetry
+	}
+	return delay
+}
+
+// WebhookDispatcher sends the deliveries in the outbox and records every attempt in the delivery log.
+// Failed deliveries are retried with exponential backoff until WebhookMaxAttempts.
+type WebhookDispatcher struct {
+	Store  database.Store
+	Client *http.Client
+	Logger *slog.Logger
+	Now    func() time.Time // The clock, replaced in tests
+
+	// AllowLocal lets deliveries connect to addresses that are not public, for testing with webhooks receive
+	AllowLocal bool
+
+	attempts *prometheus.CounterVec
+}
+
+// NewWebhookDispatcher creates a dispatcher for the outbox of a store, counting its attempts in the registry
+func NewWebhookDispatcher(store database.Store, logger *slog.Logger, registry prometheus.Registerer) *WebhookDispatcher {
+	attempts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "edms_webhook_attempts_total",
+		Help: "Attempts to send webhook deliveries, by result: delivered, retrying or failed.",
+	}, []string{"result"})
+	registry.MustRegister(attempts)
+
+	d := &WebhookDispatcher{
+		Store:    store,
+		Logger:   logger,
+		Now:      time.Now,
+		attempts: attempts,
+	}
+
+	// Every connection is checked once its address is resolved, so a webhook cannot reach the server's own
+	// network by naming a host that resolves, or is later changed to resolve, to an internal address
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if d.AllowLocal {
+				return nil
+			}
+			return checkWebhookAddress(address)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // A proxy would connect to the receiver instead, out of reach of the check
+	transport.DialContext = dialer.DialContext
+	d.Client = &http.Client{Timeout: webhookTimeout, Transport: transport}
+
+	return d
+}
+
+// errWebhookAddress is returned for a webhook connection to an address that is not public
+var errWebhookAddress = errors.New("webhooks cannot be sent to a local or private address")
+
+// sharedAddresses is the carrier-grade NAT range, private to the provider's network like the RFC 1918 ones
+var sharedAddresses = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether an address can receive webhooks: not loopback, link-local, private,
+// multicast or unspecified, like 127.0.0.1, 169.254.169.254, 10.0.0.1 or ::
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsMulticast() && !sharedAddresses.Contains(ip)
+}
+
+// checkWebhookAddress refuses a connection to a host:port address that is not public
+func checkWebhookAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(ip) {
+		return fmt.Errorf("%w: %s", errWebhookAddress, ip)
+	}
+	return nil
+}
+
+// Run sends the due deliveries every interval until the context is done
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while whole batches are due, so a backlog clears without waiting for the ticker
+		for {
+			claimed, err := d.DeliverDue(ctx)
+			if err != nil {
+				d.Logger.ErrorContext(ctx, "Error sending webhook deliveries", "error", err)
+			}
+			if err != nil || claimed < webhookBatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends one batch of the deliveries that are due and returns how many it claimed
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) (int, error) {
+	deliveries, err := d.Store.ClaimWebhookDeliveries(d.Now(), webhookLease, webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		if err := d.deliver(ctx, delivery); err != nil {
+			return len(deliveries), err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// deliver sends a delivery once and records the attempt
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) error {
+	attempt := &models.WebhookAttempt{WebhookDeliveryID: delivery.WebhookDeliveryID, AttemptedAt: d.Now()}
+	responseStatus, sendErr := d.send(ctx, delivery)
+	attempt.DurationMs = int(d.Now().Sub(attempt.AttemptedAt).Milliseconds())
+	if responseStatus != 0 {
+		attempt.ResponseStatus = sql.NullInt64{Int64: int64(responseStatus), Valid: true}
+	}
+
+	status := models.DeliveryDelivered
+	var nextAttemptAt sql.NullTime
+	if sendErr != nil {
+		// The log keeps the first 255 characters, the size of its column
+		message := []rune(strings.ToValidUTF8(sendErr.Error(), "?"))
+		if len(message) > 255 {
+			message = message[:255]
+		}
+		attempt.Error = sql.NullString{String: string(message), Valid: true}
+
+		status = models.DeliveryFailed
+		if attempts := delivery.Attempts + 1; attempts < WebhookMaxAttempts {
+			status = models.DeliveryPending
+			nextAttemptAt = sql.NullTime{Time: attempt.AttemptedAt.Add(webhookRetryDelay(attempts)), Valid: true}
+		}
+
+		d.Logger.WarnContext(ctx, "Webhook delivery failed",
+			"webhook_delivery_id", delivery.WebhookDeliveryID,
+			"webhook_id", delivery.WebhookID,
+			"event_type", delivery.EventType,
+			"attempt", delivery.Attempts+1,
+			"status", status,
+			"error", sendErr,
+		)
+	}
+
+	result := strings.ToLower(status)
+	if status == models.DeliveryPending {
+		result = "retrying"
+	}
+	d.attempts.WithLabelValues(result).Inc()
+
+	return d.Store.RecordWebhookAttempt(attempt, status, nextAttemptAt)
+}
+
+// send posts a delivery's payload and returns the response status, an error unless it is 2xx
+func (d *WebhookDispatcher) send(ctx context.Context, delivery models.WebhookDelivery) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "EDMS-Webhooks/1.0")
+	request.Header.Set("X-EDMS-Event", delivery.EventType)
+	request.Header.Set("X-EDMS-Event-ID", delivery.EventID)
+	request.Header.Set("X-EDMS-Signature", SignWebhookPayload(delivery.Secret, d.Now(), delivery.Payload))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	// Part of the body helps explain a failure in the delivery log
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 200))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("HTTP %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return response.StatusCode, nil
+}
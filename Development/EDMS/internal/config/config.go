@@ -1,68 +1,335 @@
+// Package config loads the EDMS settings once at startup. Each setting can come from a YAML file,
+// an environment variable or a command line flag, later sources overriding earlier ones:
+//
+//	config.yaml        db_host: db.internal
+//	environment        DB_HOST=db.internal
+//	flag               -db-host db.internal
+//
+// Secrets, like the database password, cannot be given as flags so they do not show up in the process list.
 package config
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// DefaultFile is read when no file is named with -config or EDMS_CONFIG, if it exists
+const DefaultFile = "config.yaml"
+
+// DefaultTimeZone is the time zone of sites that were added before sites had their own
+const DefaultTimeZone = "Pacific/Auckland"
+
+// Config is every setting of the application. The env tag names the environment variable, the YAML key
+// is the same name in lower case and the flag the same name in lower case with dashes.
 type Config struct {
-	DBUser        string
-	DBPassword    string
-	DBName        string
-	DBHost        string
-	DBPort        int
-	AdminPassword string
-	JWTSecret     string
+	Port string `env:"PORT" default:"8080" usage:"port the web server listens on"`
+
+	DBUser     string `env:"DB_USER" required:"true" usage:"PostgreSQL user"`
+	DBPassword string `env:"DB_PASSWORD" required:"true" secret:"true" usage:"PostgreSQL password"`
+	DBName     string `env:"DB_NAME" required:"true" usage:"PostgreSQL database name"`
+	DBHost     string `env:"DB_HOST" default:"localhost" usage:"PostgreSQL host"`
+	DBPort     int    `env:"DB_PORT" default:"5432" usage:"PostgreSQL port"`
+	DBSSLMode  string `env:"DB_SSLMODE" default:"disable" usage:"PostgreSQL sslmode: disable, require, verify-ca or verify-full"`
+
+	AdminPassword string `env:"ADMIN_PASSWORD" required:"true" secret:"true" usage:"password of the default admin account created when seeding"`
+	JWTSecret     string `env:"JWT_SECRET" required:"true" secret:"true" usage:"key login tokens are signed with"`
+
+	SessionLifetime    time.Duration `env:"SESSION_LIFETIME" default:"72h" usage:"how long a login lasts"`
+	RememberMeLifetime time.Duration `env:"REMEMBER_ME_LIFETIME" default:"720h" usage:"how long a login lasts with remember me ticked"`
+
+	TimeZone string `env:"TIME_ZONE" default:"Pacific/Auckland" usage:"IANA time zone new sites are given unless another is chosen"`
+
+	SMTPHost     string `env:"SMTP_HOST" usage:"mail server for password reset emails, emails are not sent when empty"`
+	SMTPPort     int    `env:"SMTP_PORT" default:"587" usage:"mail server port"`
+	SMTPUsername string `env:"SMTP_USERNAME" usage:"mail server user"`
+	SMTPPassword string `env:"SMTP_PASSWORD" secret:"true" usage:"mail server password"`
+	SMTPFrom     string `env:"SMTP_FROM" usage:"sender address of password reset emails"`
+
+	LogLevel  string `env:"LOG_LEVEL" default:"info" usage:"debug, info, warn or error"`
+	LogFormat string `env:"LOG_FORMAT" default:"text" usage:"text or json"`
+
+	WebhooksAllowLocal bool `env:"WEBHOOKS_ALLOW_LOCAL" default:"false" usage:"let webhooks be sent to loopback, link-local and private addresses, for testing with webhooks receive"`
+
+	MetricsToken string `env:"METRICS_TOKEN" secret:"true" usage:"bearer token Prometheus scrapes /metrics with, /metrics is not served when empty"`
+}
+
+// Default returns the configuration with every setting at its default and the required ones empty
+func Default() Config {
+	var cfg Config
+	for _, s := range settings() {
+		if s.def != "" {
+			// The defaults are checked by TestDefaultsAreValid
+			_ = s.set(&cfg, s.def)
+		}
+	}
+	return cfg
+}
+
+// AddFileFlag adds the -config flag naming the YAML file to read
+func AddFileFlag(flags *flag.FlagSet) {
+	flags.String("config", "", "YAML configuration file, "+DefaultFile+" or $EDMS_CONFIG if not given")
+}
+
+// AddFlags adds -config and a flag for every setting that is not a secret.
+// Only the flags given on the command line override the file and environment.
+func AddFlags(flags *flag.FlagSet) {
+	AddFileFlag(flags)
+	for _, s := range settings() {
+		if s.secret {
+			continue
+		}
+		usage := s.usage
+		if s.def != "" {
+			usage += " (default " + s.def + ")"
+		}
+		flags.String(s.flag, "", usage)
+	}
+}
+
+// Load reads the configuration file, the .env file and the environment, then the flags set on the
+// parsed flag set, which may be nil. Every invalid or missing setting is reported in the one error.
+func Load(flags *flag.FlagSet) (Config, error) {
+	// Try to load .env file, but don't fail if it doesn't exist. It never overrides the real environment.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("reading .env: %v", err)
+	}
+
+	values := make(map[string]string)
+
+	file, fileGiven := os.Getenv("EDMS_CONFIG"), os.Getenv("EDMS_CONFIG") != ""
+	if flags != nil {
+		if f := flags.Lookup("config"); f != nil && f.Value.String() != "" {
+			file, fileGiven = f.Value.String(), true
+		}
+	}
+	if file == "" {
+		file = DefaultFile
+	}
+	fileErrs, err := readFile(file, fileGiven, values)
+	if err != nil {
+		return Config{}, err
+	}
+
+	for _, s := range settings() {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			values[s.env] = value
+		}
+	}
+
+	if flags != nil {
+		flags.Visit(func(f *flag.Flag) {
+			for _, s := range settings() {
+				if f.Name == s.flag {
+					values[s.env] = f.Value.String()
+				}
+			}
+		})
+	}
+
+	cfg, err := parse(values)
+	return cfg, errors.Join(append(fileErrs, err)...)
+}
+
+// readFile adds the settings in a YAML file to values and returns an error for each key that is not a setting.
+// A missing file is only an error when it was asked for.
+func readFile(file string, required bool, values map[string]string) ([]error, error) {
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	keys := make(map[string]string)
+	for _, s := range settings() {
+		keys[s.key] = s.env
+	}
+
+	names := make([]string, 0, len(document))
+	for key := range document {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, key := range names {
+		value := document[key]
+		env, ok := keys[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", file, key))
+			continue
+		}
+		if value != nil {
+			values[env] = fmt.Sprint(value)
+		}
+	}
+
+	slog.Info("Loaded configuration", "file", file)
+	return errs, nil
+}
+
+// parse fills in a Config from the raw values, with defaults for those not given, and validates it
+func parse(values map[string]string) (Config, error) {
+	var cfg Config
+	var errs []error
+	for _, s := range settings() {
+		value, ok := values[s.env]
+		if !ok || value == "" {
+			if s.required {
+				errs = append(errs, fmt.Errorf("%s is required", s.env))
+				continue
+			}
+			value = s.def
+		}
+		if value == "" {
+			continue
+		}
+		if err := s.set(&cfg, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", s.env, err))
+		}
+	}
+
+	errs = append(errs, cfg.validate()...)
+	return cfg, errors.Join(errs...)
 }
 
-func LoadConfig() Config {
-	// Try to load .env file, but don't fail if it doesn't exist
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, will use environment variables")
+// validate checks the values that parsed but may still be unusable
+func (cfg Config) validate() []error {
+	var errs []error
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: %q is not a port number", cfg.Port))
+	}
+	if cfg.DBPort < 0 || cfg.DBPort > 65535 {
+		errs = append(errs, fmt.Errorf("DB_PORT: %d is not a port number", cfg.DBPort))
 	}
 
-	// List of required environment variables
-	requiredEnvVars := map[string]string{
-		"DB_USER":        "",
-		"DB_PASSWORD":    "",
-		"DB_NAME":        "",
-		"DB_HOST":        "",
-		"DB_PORT":        "",
-		"ADMIN_PASSWORD": "",
-		"JWT_SECRET":     "",
+	switch cfg.DBSSLMode {
+	case "", "disable", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("DB_SSLMODE: unknown mode %q, expected disable, require, verify-ca or verify-full", cfg.DBSSLMode))
+	}
+
+	if cfg.SessionLifetime <= 0 {
+		errs = append(errs, errors.New("SESSION_LIFETIME must be positive"))
+	}
+	if cfg.RememberMeLifetime < cfg.SessionLifetime {
+		errs = append(errs, errors.New("REMEMBER_ME_LIFETIME must be at least SESSION_LIFETIME"))
 	}
 
-	// Check for missing environment variables
-	var missingVars []string
-	for env := range requiredEnvVars {
-		if value := os.Getenv(env); value == "" {
-			missingVars = append(missingVars, env)
+	if cfg.TimeZone != "" {
+		if _, err := time.LoadLocation(cfg.TimeZone); err != nil {
+			errs = append(errs, fmt.Errorf("TIME_ZONE: %v", err))
 		}
 	}
 
-	// If any required variables are missing, log them and exit
-	if len(missingVars) > 0 {
-		log.Fatalf("Missing required environment variables: %v", missingVars)
+	if cfg.SMTPHost != "" && cfg.SMTPFrom == "" {
+		errs = append(errs, errors.New("SMTP_FROM is required when SMTP_HOST is set"))
 	}
 
-	// Get and validate DB_PORT
-	dbPortStr := os.Getenv("DB_PORT")
-	dbPort, err := strconv.Atoi(dbPortStr)
+	switch strings.ToLower(cfg.LogLevel) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: unknown level %q, expected debug, info, warn or error", cfg.LogLevel))
+	}
+	switch strings.ToLower(cfg.LogFormat) {
+	case "", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: unknown format %q, expected text or json", cfg.LogFormat))
+	}
+
+	return errs
+}
+
+// Location is the time zone new sites are in
+func (cfg Config) Location() *time.Location {
+	name := cfg.TimeZone
+	if name == "" {
+		name = DefaultTimeZone
+	}
+	location, err := time.LoadLocation(name)
 	if err != nil {
-		log.Fatalf("Invalid DB_PORT value: %v", err)
+		return time.UTC
+	}
+	return location
+}
+
+// setting is a field of Config with the names it is read from
+type setting struct {
+	index    int
+	env      string
+	key      string
+	flag     string
+	def      string
+	usage    string
+	required bool
+	secret   bool
+}
+
+// settings lists the fields of Config in declaration order
+func settings() []setting {
+	t := reflect.TypeOf(Config{})
+	list := make([]setting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		env := field.Tag.Get("env")
+		list = append(list, setting{
+			index:    i,
+			env:      env,
+			key:      strings.ToLower(env),
+			flag:     strings.ReplaceAll(strings.ToLower(env), "_", "-"),
+			def:      field.Tag.Get("default"),
+			usage:    field.Tag.Get("usage"),
+			required: field.Tag.Get("required") == "true",
+			secret:   field.Tag.Get("secret") == "true",
+		})
 	}
+	return list
+}
 
-	// Create and return the config
-	return Config{
-		DBUser:        os.Getenv("DB_USER"),
-		DBPassword:    os.Getenv("DB_PASSWORD"),
-		DBName:        os.Getenv("DB_NAME"),
-		DBHost:        os.Getenv("DB_HOST"),
-		DBPort:        dbPort,
-		AdminPassword: os.Getenv("ADMIN_PASSWORD"),
-		JWTSecret:     os.Getenv("JWT_SECRET"),
+// set parses a raw value into the setting's field
+func (s setting) set(cfg *Config, value string) error {
+	field := reflect.ValueOf(cfg).Elem().Field(s.index)
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		field.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 72h or 30m", value)
+		}
+		field.SetInt(int64(d))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
 	}
+	return nil
 }
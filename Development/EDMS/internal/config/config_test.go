@@ -0,0 +1,116 @@
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setRequired sets the settings without defaults in the environment
+func setRequired(t *testing.T) {
+	t.Setenv("DB_USER", "edms")
+	t.Setenv("DB_PASSWORD", "secret")
+	t.Setenv("DB_NAME", "edms")
+	t.Setenv("ADMIN_PASSWORD", "Password1!")
+	t.Setenv("JWT_SECRET", "jwt-secret")
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	return file
+}
+
+func TestDefaultsAreValid(t *testing.T) {
+	setRequired(t)
+
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+
+	defaults := config.Default()
+	defaults.DBUser, defaults.DBPassword, defaults.DBName = "edms", "secret", "edms"
+	defaults.AdminPassword, defaults.JWTSecret = "Password1!", "jwt-secret"
+	assert.Equal(t, defaults, cfg)
+
+	assert.Equal(t, "8080", cfg.Port)
+	assert.Equal(t, 5432, cfg.DBPort)
+	assert.Equal(t, "disable", cfg.DBSSLMode)
+	assert.Equal(t, 72*time.Hour, cfg.SessionLifetime)
+	assert.Equal(t, 30*24*time.Hour, cfg.RememberMeLifetime)
+	assert.Equal(t, config.DefaultTimeZone, cfg.TimeZone)
+	assert.Equal(t, "Pacific/Auckland", cfg.Location().String())
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	setRequired(t)
+	file := writeFile(t, "port: 9000\ndb_host: db.file\ndb_port: 6432\nlog_level: debug\nsession_lifetime: 8h\n")
+
+	t.Setenv("DB_HOST", "db.env")
+	t.Setenv("LOG_LEVEL", "warn")
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	config.AddFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-config", file, "-log-level", "error"}))
+
+	cfg, err := config.Load(flags)
+	require.NoError(t, err)
+	assert.Equal(t, "9000", cfg.Port, "the file overrides the default")
+	assert.Equal(t, 6432, cfg.DBPort)
+	assert.Equal(t, 8*time.Hour, cfg.SessionLifetime)
+	assert.Equal(t, "db.env", cfg.DBHost, "the environment overrides the file")
+	assert.Equal(t, "error", cfg.LogLevel, "flags override the environment")
+
+	assert.Nil(t, flags.Lookup("db-password"), "secrets are not flags")
+}
+
+func TestConfigFileFromEnvironment(t *testing.T) {
+	setRequired(t)
+	t.Setenv("EDMS_CONFIG", writeFile(t, "time_zone: Europe/London\n"))
+
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Europe/London", cfg.Location().String())
+
+	t.Setenv("EDMS_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	_, err = config.Load(nil)
+	assert.Error(t, err, "a file that was asked for must exist")
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	t.Setenv("DB_USER", "edms")
+	t.Setenv("DB_PORT", "postgres")
+	t.Setenv("TIME_ZONE", "Mars/Olympus")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("WEBHOOKS_ALLOW_LOCAL", "sometimes")
+	file := writeFile(t, "db_sslmode: sometimes\nsession_lifetime: 3 days\ndatabase: edms\n")
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	config.AddFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-config", file, "-port", "http"}))
+
+	_, err := config.Load(flags)
+	require.Error(t, err)
+	for _, expected := range []string{
+		`unknown setting "database"`,
+		"PORT",
+		"DB_PASSWORD is required",
+		"DB_NAME is required",
+		"DB_PORT",
+		"DB_SSLMODE",
+		"ADMIN_PASSWORD is required",
+		"JWT_SECRET is required",
+		"SESSION_LIFETIME",
+		"TIME_ZONE",
+		"SMTP_FROM is required",
+		"WEBHOOKS_ALLOW_LOCAL",
+	} {
+		assert.Contains(t, err.Error(), expected)
+	}
+}
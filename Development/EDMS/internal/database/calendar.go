@@ -0,0 +1,44 @@
+package database
+
+import (
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// SetCalendarFeed gives a user a new calendar link for an organisation, replacing any earlier one.
+// Calendar links are looked up by token like logins, so they are not limited to the store's organisation.
+func (db *DB) SetCalendarFeed(userID int, organisationID int, tokenHash string) error {
+	_, err := db.Exec(`
+	INSERT INTO CalendarFeedT (UserID, OrganisationID, TokenHash)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (UserID, OrganisationID) DO UPDATE
+	SET TokenHash = EXCLUDED.TokenHash, CreatedAt = NOW()
+	`, userID, organisationID, tokenHash)
+	return err
+}
+
+// GetCalendarFeed returns the calendar link with the given token hash
+func (db *DB) GetCalendarFeed(tokenHash string) (*models.CalendarFeed, error) {
+	var feed models.CalendarFeed
+	err := db.QueryRow(`
+	SELECT CalendarFeedID, UserID, OrganisationID, TokenHash, CreatedAt
+	FROM CalendarFeedT
+	WHERE TokenHash = $1
+	`, tokenHash).Scan(
+		&feed.CalendarFeedID,
+		&feed.UserID,
+		&feed.OrganisationID,
+		&feed.TokenHash,
+		&feed.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &feed, nil
+}
+
+// DeleteCalendarFeed stops a user's calendar link for an organisation from working
+func (db *DB) DeleteCalendarFeed(userID int, organisationID int) error {
+	_, err := db.Exec(`DELETE FROM CalendarFeedT WHERE UserID = $1 AND OrganisationID = $2`, userID, organisationID)
+	return err
+}
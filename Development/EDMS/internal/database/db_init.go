@@ -1,22 +1,36 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
+// The delay between connection attempts starts at connectRetryMin and doubles up to connectRetryMax
+const (
+	connectRetryMin = time.Second
+	connectRetryMax = 30 * time.Second
+)
+
 type DB struct {
 	*sql.DB
+
+	// organisationID limits the queries to one organisation, see ForOrganisation. 0 sees every organisation.
+	organisationID int
+
+	// tx is the unit of work the queries run in, see WithTx. Nil runs each query on its own.
+	tx *sql.Tx
 }
 
 func NewDB(cfg config.Config) (*DB, error) {
-	log.Println("Connecting to database...")
-	connStr := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%d sslmode=disable",
-		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
+	slog.Info("Connecting to database", "host", cfg.DBHost, "port", cfg.DBPort, "database", cfg.DBName)
+	connStr := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%d sslmode=%s",
+		cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort, cfg.DBSSLMode)
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, err
@@ -24,10 +38,32 @@ func NewDB(cfg config.Config) (*DB, error) {
 
 	// Ping the database to ensure connection is established
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	log.Println("Database connected successfully")
+	slog.Info("Database connected")
 
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
+}
+
+// ConnectWithRetry connects like NewDB, retrying with exponential backoff while the database is unavailable,
+// for example while its container is still starting. It gives up when the context is done.
+func ConnectWithRetry(ctx context.Context, cfg config.Config) (*DB, error) {
+	delay := connectRetryMin
+	for attempt := 1; ; attempt++ {
+		db, err := NewDB(cfg)
+		if err == nil {
+			return db, nil
+		}
+
+		slog.Warn("Database connection failed, retrying", "attempt", attempt, "retry_in", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not connect to the database after %d attempts: %w", attempt, err)
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, connectRetryMax)
+	}
 }
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// ErrCodeTaken is returned when rows cannot be moved because their new parent already has a row with the
+// code of one of them, archived rows included
+var ErrCodeTaken = errors.New("the new parent already has a row with the same code")
+
+// GetSiteDependents returns the buildings, rooms and in service devices of a site, sql.ErrNoRows if the
+// site does not exist or is archived
+func (db *DB) GetSiteDependents(siteID int) (*models.Dependents, error) {
+	args := []interface{}{siteID}
+	exists := `SELECT 1 FROM SiteT WHERE SiteID = $1 AND ArchivedAt IS NULL` + db.scope(&args, organisationScope, "OrganisationID")
+	if err := db.QueryRow(exists, args...).Scan(new(int)); err != nil {
+		return nil, err
+	}
+
+	return db.dependents(`b.SiteID = $1`, siteID, true, true)
+}
+
+// GetBuildingDependents returns the rooms and in service devices of a building, sql.ErrNoRows if the
+// building does not exist or is archived
+func (db *DB) GetBuildingDependents(buildingID int) (*models.Dependents, error) {
+	args := []interface{}{buildingID}
+	exists := `SELECT 1 FROM BuildingT WHERE BuildingID = $1 AND ArchivedAt IS NULL` + db.scope(&args, siteScope, "SiteID")
+	if err := db.QueryRow(exists, args...).Scan(new(int)); err != nil {
+		return nil, err
+	}
+
+	return db.dependents(`b.BuildingID = $1`, buildingID, false, true)
+}
+
+// GetRoomDependents returns the in service devices of a room, sql.ErrNoRows if the room does not exist
+// or is archived
+func (db *DB) GetRoomDependents(roomID int) (*models.Dependents, error) {
+	args := []interface{}{roomID}
+	exists := `SELECT 1 FROM RoomT WHERE RoomID = $1 AND ArchivedAt IS NULL` + db.scope(&args, buildingScope, "BuildingID")
+	if err := db.QueryRow(exists, args...).Scan(new(int)); err != nil {
+		return nil, err
+	}
+
+	return db.dependents(`r.RoomID = $1`, roomID, false, false)
+}
+
+// dependents lists what is under the rows matching condition, a condition on b (BuildingT) or r (RoomT)
+// with the ID as $1. The buildings and rooms are only listed when asked for.
+func (db *DB) dependents(condition string, id int, buildings bool, rooms bool) (*models.Dependents, error) {
+	dependents := &models.Dependents{
+		Buildings: []models.Dependent{},
+		Rooms:     []models.Dependent{},
+		Devices:   []models.Dependent{},
+	}
+
+	var err error
+	if buildings {
+		dependents.Buildings, err = db.listDependents(`
+		SELECT b.BuildingID, b.BuildingCode
+		FROM BuildingT b
+		WHERE b.ArchivedAt IS NULL AND `+condition+`
+		ORDER BY b.BuildingCode`, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rooms {
+		dependents.Rooms, err = db.listDependents(`
+		SELECT r.RoomID, r.RoomCode
+		FROM RoomT r
+		JOIN BuildingT b ON r.BuildingID = b.BuildingID
+		WHERE r.ArchivedAt IS NULL AND b.ArchivedAt IS NULL AND `+condition+`
+		ORDER BY r.RoomCode, r.RoomID`, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	devices := `
+		FROM Emergency_DeviceT ed
+		JOIN RoomT r ON ed.RoomID = r.RoomID
+		JOIN BuildingT b ON r.BuildingID = b.BuildingID
+		WHERE ed.DecommissionedAt IS NULL AND r.ArchivedAt IS NULL AND b.ArchivedAt IS NULL AND ` + condition
+	dependents.Devices, err = db.listDependents(`
+		SELECT ed.EmergencyDeviceID, COALESCE(ed.SerialNumber, '')`+devices+`
+		ORDER BY ed.EmergencyDeviceID`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.QueryRow(`
+		SELECT COUNT(*)
+		FROM Emergency_Device_InspectionT i
+		WHERE i.EmergencyDeviceID IN (SELECT ed.EmergencyDeviceID`+devices+`)`, id).Scan(&dependents.Inspections)
+	if err != nil {
+		return nil, err
+	}
+
+	return dependents, nil
+}
+
+// listDependents runs a query returning the ID and name of dependents
+func (db *DB) listDependents(query string, id int) ([]models.Dependent, error) {
+	rows, err := db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dependents := []models.Dependent{}
+	for rows.Next() {
+		var dependent models.Dependent
+		if err := rows.Scan(&dependent.ID, &dependent.Name); err != nil {
+			return nil, err
+		}
+		dependents = append(dependents, dependent)
+	}
+
+	return dependents, rows.Err()
+}
+
+// MoveSiteBuildings moves the buildings of a site, with their rooms and devices, to another site that is not
+// archived. The buildings lose their position on the old site's map. sql.ErrNoRows is returned if the new site
+// does not exist and ErrCodeTaken if it already has a building with the code of one of them.
+func (db *DB) MoveSiteBuildings(fromSiteID int, toSiteID int) error {
+	args := []interface{}{toSiteID}
+	exists := `SELECT 1 FROM SiteT WHERE SiteID = $1 AND ArchivedAt IS NULL` + db.scope(&args, organisationScope, "OrganisationID")
+	if err := db.QueryRow(exists, args...).Scan(new(int)); err != nil {
+		return err
+	}
+
+	var taken bool
+	err := db.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1
+		FROM BuildingT moving
+		JOIN BuildingT there ON there.SiteID = $2 AND there.BuildingCode = moving.BuildingCode
+		WHERE moving.SiteID = $1 AND moving.ArchivedAt IS NULL
+	)`, fromSiteID, toSiteID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrCodeTaken
+	}
+
+	args = []interface{}{fromSiteID, toSiteID}
+	query := `
+	UPDATE BuildingT
+	SET SiteID = $2, MapX = NULL, MapY = NULL, MapPolygon = NULL
+	WHERE SiteID = $1 AND ArchivedAt IS NULL` + db.scope(&args, siteScope, "SiteID")
+
+	_, err = db.Exec(query, args...)
+	return err
+}
+
+// MoveBuildingRooms moves the rooms of a building, with their devices, to the default floor of another building
+// that is not archived. sql.ErrNoRows is returned if the new building does not exist and ErrCodeTaken if it
+// already has a room with the code of one of them.
+func (db *DB) MoveBuildingRooms(fromBuildingID int, toBuildingID int) error {
+	args := []interface{}{toBuildingID}
+	exists := `SELECT 1 FROM BuildingT WHERE BuildingID = $1 AND ArchivedAt IS NULL` + db.scope(&args, siteScope, "SiteID")
+	if err := db.QueryRow(exists, args...).Scan(new(int)); err != nil {
+		return err
+	}
+
+	var taken bool
+	err := db.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1
+		FROM RoomT moving
+		JOIN RoomT there ON there.BuildingID = $2 AND there.RoomCode = moving.RoomCode
+		WHERE moving.BuildingID = $1 AND moving.ArchivedAt IS NULL
+	)`, fromBuildingID, toBuildingID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrCodeTaken
+	}
+
+	// set_room_floor puts the rooms on the default floor of their new building
+	args = []interface{}{fromBuildingID, toBuildingID}
+	query := `
+	UPDATE RoomT
+	SET BuildingID = $2
+	WHERE BuildingID = $1 AND ArchivedAt IS NULL` + db.scope(&args, buildingScope, "BuildingID")
+
+	_, err = db.Exec(query, args...)
+	return err
+}
+
+// MoveRoomDevices moves the in service devices of a room to another room that is not archived,
+// sql.ErrNoRows is returned if the new room does not exist
+func (db *DB) MoveRoomDevices(fromRoomID int, toRoomID int) error {
+	args := []interface{}{toRoomID}
+	exists := `
+	SELECT 1
+	FROM RoomT r
+	JOIN BuildingT b ON r.BuildingID = b.BuildingID
+	WHERE r.RoomID = $1 AND r.ArchivedAt IS NULL AND b.ArchivedAt IS NULL` + db.scope(&args, siteScope, "b.SiteID")
+	if err := db.QueryRow(exists, args...).Scan(new(int)); err != nil {
+		return err
+	}
+
+	args = []interface{}{fromRoomID, toRoomID}
+	query := `
+	UPDATE Emergency_DeviceT
+	SET RoomID = $2
+	WHERE RoomID = $1 AND DecommissionedAt IS NULL` + db.scope(&args, roomScope, "RoomID")
+
+	_, err := db.Exec(query, args...)
+	return err
+}
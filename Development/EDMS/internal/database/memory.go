@@ -0,0 +1,4073 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// MemoryStore is an in-memory Store for tests that need the data layer without PostgreSQL.
+// It follows the behaviour of the SQL queries, including the constraints, the soft delete filters,
+// the organisation scoping and the inspection trigger, and is kept honest by the contract tests in
+// internal/database/storetest.
+type MemoryStore struct {
+	*memoryData
+
+	// organisationID limits the store to one organisation like DB's, 0 sees every organisation
+	organisationID int
+
+	// inTx is set on the store WithTx gives its function, which joins the unit of work in progress
+	inTx bool
+}
+
+// memoryData holds the tables, shared by a MemoryStore and the stores its ForOrganisation returns
+type memoryData struct {
+	mu sync.Mutex
+
+	// work is held by the unit of work in progress, see WithTx
+	work sync.Mutex
+
+	memoryTables
+}
+
+// memoryTables are the rows of every table and the values of the sequences
+type memoryTables struct {
+	sequences map[string]int
+
+	organisations      []models.Organisation
+	memberships        []memoryMembership
+	users              []models.User
+	sites              []memoryLocation[models.Site]
+	buildings          []memoryLocation[models.Building]
+	floors             []models.Floor
+	rooms              []memoryLocation[models.Room]
+	deviceTypes        []models.EmergencyDeviceType
+	extinguisherTypes  []models.ExtinguisherType
+	devices            []models.EmergencyDevice
+	inspections        []models.Inspection
+	inspectionRounds   []models.InspectionRound
+	roundDevices       []memoryRoundDevice
+	maintenanceRecords []models.MaintenanceRecord
+	floorPlans         []models.FloorPlan
+	floorPlanPins      []models.FloorPlanPin
+	calendarFeeds      []models.CalendarFeed
+	webhooks           []models.Webhook
+	webhookDeliveries  []models.WebhookDelivery
+	webhookAttempts    []models.WebhookAttempt
+	savedViews         []models.SavedView
+	defaultSavedViews  []memoryDefaultSavedView
+}
+
+// memoryLocation is a site, building or room row with its archive columns
+type memoryLocation[T any] struct {
+	Row           T
+	ArchivedAt    sql.NullTime
+	ArchiveReason sql.NullString
+	UpdatedAt     sql.NullTime // When a site or building last changed, rooms keep theirs in the row
+}
+
+// memoryRoundDevice is an InspectionRoundDeviceT row
+type memoryRoundDevice struct {
+	InspectionRoundID int
+	models.InspectionRoundDevice
+}
+
+// memoryDefaultSavedView is a DefaultSavedViewT row
+type memoryDefaultSavedView struct {
+	UserID         int
+	OrganisationID int
+	SavedViewID    int
+}
+
+// memoryMembership is a UserOrganisationT row
+type memoryMembership struct {
+	UserID         int
+	OrganisationID int
+}
+
+// NewMemoryStore creates an empty in-memory store with the default organisation, like a migrated database
+func NewMemoryStore() *MemoryStore {
+	data := &memoryData{memoryTables: memoryTables{sequences: map[string]int{"organisation": models.DefaultOrganisationID}}}
+	data.organisations = []models.Organisation{{
+		OrganisationID: models.DefaultOrganisationID,
+		Name:           "Default",
+		CreatedAt:      now().Time,
+	}}
+	return &MemoryStore{memoryData: data}
+}
+
+// AddExtinguisherType adds an extinguisher type and returns its ID.
+// Extinguisher types are only ever seeded, so this is not part of Store. The type is global.
+func (m *MemoryStore) AddExtinguisherType(extinguisherTypeName string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, extinguisherType := range m.extinguisherTypes {
+		if !extinguisherType.OrganisationID.Valid && extinguisherType.ExtinguisherTypeName == extinguisherTypeName {
+			return 0, uniqueViolation("extinguisher_typet_organisation_name_key")
+		}
+	}
+
+	extinguisherType := models.ExtinguisherType{
+		ExtinguisherTypeID:   m.nextID("extinguisher_type"),
+		ExtinguisherTypeName: extinguisherTypeName,
+	}
+	m.extinguisherTypes = append(m.extinguisherTypes, extinguisherType)
+
+	return extinguisherType.ExtinguisherTypeID, nil
+}
+
+// nextID returns the next value of a table's serial column
+func (m *MemoryStore) nextID(table string) int {
+	m.sequences[table]++
+	return m.sequences[table]
+}
+
+func uniqueViolation(constraint string) error {
+	return fmt.Errorf("duplicate key value violates unique constraint %q", constraint)
+}
+
+func foreignKeyViolation(constraint string) error {
+	return fmt.Errorf("violates foreign key constraint %q", constraint)
+}
+
+func checkViolation(constraint string) error {
+	return fmt.Errorf("violates check constraint %q", constraint)
+}
+
+// matchVersion is what versionCheck does in SQL, an update made from a version only changes a row still at it
+func matchVersion(current int, version int) error {
+	if version != 0 && current != version {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// parseID converts an ID passed as text the way PostgreSQL casts it to an integer
+func parseID(id string) (int, error) {
+	value, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("invalid input syntax for type integer: %q", id)
+	}
+	return value, nil
+}
+
+// instant stores a time like a TIMESTAMPTZ column does, in UTC to the microsecond
+func instant(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// dateOnly drops the time of day like a DATE column does
+func dateOnly(t sql.NullTime) sql.NullTime {
+	if !t.Valid {
+		return t
+	}
+	return sql.NullTime{Time: time.Date(t.Time.Year(), t.Time.Month(), t.Time.Day(), 0, 0, 0, 0, time.UTC), Valid: true}
+}
+
+// now is the current time as stored by NOW() and CURRENT_TIMESTAMP
+func now() sql.NullTime {
+	return sql.NullTime{Time: instant(time.Now()), Valid: true}
+}
+
+// roundNumeric rounds a value to the scale of a NUMERIC column
+func roundNumeric(value float64, scale int) float64 {
+	factor := math.Pow(10, float64(scale))
+	return math.Round(value*factor) / factor
+}
+
+func (m *MemoryStore) ForOrganisation(organisationID int) Store {
+	return &MemoryStore{memoryData: m.memoryData, organisationID: organisationID, inTx: m.inTx}
+}
+
+// WithTx runs fn like DB.WithTx. Units of work run one at a time, and a failed one puts back the tables
+// as they were before it started. Unlike a transaction, its changes are seen by other stores before it ends.
+func (m *MemoryStore) WithTx(fn func(tx Store) error) error {
+	if m.inTx {
+		return fn(m)
+	}
+
+	m.work.Lock()
+	defer m.work.Unlock()
+
+	m.mu.Lock()
+	before := m.memoryTables.clone()
+	m.mu.Unlock()
+
+	committed := false
+	defer func() {
+		// Also rolls back when fn panics
+		if !committed {
+			m.mu.Lock()
+			m.memoryTables = before
+			m.mu.Unlock()
+		}
+	}()
+
+	if err := fn(&MemoryStore{memoryData: m.memoryData, organisationID: m.organisationID, inTx: true}); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+// clone copies the tables, so changes to the rows of one do not change the other
+func (t memoryTables) clone() memoryTables {
+	t.sequences = maps.Clone(t.sequences)
+	t.organisations = slices.Clone(t.organisations)
+	t.memberships = slices.Clone(t.memberships)
+	t.users = slices.Clone(t.users)
+	t.sites = slices.Clone(t.sites)
+	t.buildings = slices.Clone(t.buildings)
+	t.floors = slices.Clone(t.floors)
+	t.rooms = slices.Clone(t.rooms)
+	t.deviceTypes = slices.Clone(t.deviceTypes)
+	t.extinguisherTypes = slices.Clone(t.extinguisherTypes)
+	t.devices = slices.Clone(t.devices)
+	t.inspections = slices.Clone(t.inspections)
+	t.inspectionRounds = slices.Clone(t.inspectionRounds)
+	t.roundDevices = slices.Clone(t.roundDevices)
+	t.maintenanceRecords = slices.Clone(t.maintenanceRecords)
+	t.floorPlans = slices.Clone(t.floorPlans)
+	t.floorPlanPins = slices.Clone(t.floorPlanPins)
+	t.calendarFeeds = slices.Clone(t.calendarFeeds)
+	t.webhooks = slices.Clone(t.webhooks)
+	t.webhookDeliveries = slices.Clone(t.webhookDeliveries)
+	t.webhookAttempts = slices.Clone(t.webhookAttempts)
+	t.savedViews = slices.Clone(t.savedViews)
+	t.defaultSavedViews = slices.Clone(t.defaultSavedViews)
+	return t
+}
+
+// inOrganisation reports whether rows of an organisation are visible to the store
+func (m *MemoryStore) inOrganisation(organisationID int) bool {
+	return m.organisationID == 0 || organisationID == m.organisationID
+}
+
+// requireInOrganisation returns sql.ErrNoRows when a scoped store refers to a row of another organisation, like DB.inOrganisation
+func (m *MemoryStore) requireInOrganisation(ok bool) error {
+	if m.organisationID == 0 || ok {
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+// writeOrganisationID is the organisation new sites and users are added to
+func (m *MemoryStore) writeOrganisationID() int {
+	if m.organisationID == 0 {
+		return models.DefaultOrganisationID
+	}
+	return m.organisationID
+}
+
+// Rows below a site belong to the organisation of their site
+func (m *MemoryStore) siteInOrganisation(siteID int) bool {
+	site, ok := m.findSite(siteID)
+	return ok && m.inOrganisation(site.Row.OrganisationID)
+}
+
+func (m *MemoryStore) buildingInOrganisation(buildingID int) bool {
+	building, ok := m.findBuilding(buildingID)
+	return ok && m.siteInOrganisation(building.Row.SiteID)
+}
+
+func (m *MemoryStore) roomInOrganisation(roomID int) bool {
+	room, ok := m.findRoom(roomID)
+	return ok && m.buildingInOrganisation(room.Row.BuildingID)
+}
+
+// siteArchived, buildingArchived and roomArchived report whether a location, or one it is in, has been archived
+func (m *MemoryStore) siteArchived(siteID int) bool {
+	site, ok := m.findSite(siteID)
+	return ok && site.ArchivedAt.Valid
+}
+
+func (m *MemoryStore) buildingArchived(buildingID int) bool {
+	building, ok := m.findBuilding(buildingID)
+	return ok && (building.ArchivedAt.Valid || m.siteArchived(building.Row.SiteID))
+}
+
+func (m *MemoryStore) roomArchived(roomID int) bool {
+	room, ok := m.findRoom(roomID)
+	return ok && (room.ArchivedAt.Valid || m.buildingArchived(room.Row.BuildingID))
+}
+
+// roomLocationUpdatedAt is the last time a room, its building or its site changed
+func (m *MemoryStore) roomLocationUpdatedAt(roomID int) sql.NullTime {
+	latest := sql.NullTime{}
+	later := func(updatedAt sql.NullTime) {
+		if updatedAt.Valid && (!latest.Valid || updatedAt.Time.After(latest.Time)) {
+			latest = updatedAt
+		}
+	}
+
+	room, ok := m.findRoom(roomID)
+	if !ok {
+		return latest
+	}
+	later(room.Row.UpdatedAt)
+	if building, ok := m.findBuilding(room.Row.BuildingID); ok {
+		later(building.UpdatedAt)
+		if site, ok := m.findSite(building.Row.SiteID); ok {
+			later(site.UpdatedAt)
+		}
+	}
+	return latest
+}
+
+func (m *MemoryStore) deviceInOrganisation(deviceID int) bool {
+	i, ok := m.findDevice(deviceID)
+	return ok && m.roomInOrganisation(m.devices[i].RoomID)
+}
+
+func (m *MemoryStore) floorPlanInOrganisation(floorPlanID int) bool {
+	i, ok := m.findFloorPlan(floorPlanID)
+	return ok && m.buildingInOrganisation(m.floorPlans[i].BuildingID)
+}
+
+func (m *MemoryStore) userInOrganisation(userID int) bool {
+	return m.organisationID == 0 || m.memberOf(userID, m.organisationID)
+}
+
+// ownsUser is what DB.ownsUser does, a scoped store only changes users who belong to no other organisation
+func (m *MemoryStore) ownsUser(userID int) error {
+	if m.organisationID != 0 && m.memberOf(userID, m.organisationID) && len(m.userOrganisationIDs(userID)) > 1 {
+		return ErrUserInOtherOrganisations
+	}
+	return nil
+}
+
+// typeVisible reports whether a device or extinguisher type can be used, global types can be used by everyone
+func (m *MemoryStore) typeVisible(organisationID sql.NullInt64) bool {
+	return !organisationID.Valid || m.inOrganisation(int(organisationID.Int64))
+}
+
+// typeOwned reports whether a device type can be changed, only unscoped stores change global types
+func (m *MemoryStore) typeOwned(organisationID sql.NullInt64) bool {
+	return m.organisationID == 0 || (organisationID.Valid && int(organisationID.Int64) == m.organisationID)
+}
+
+func (m *MemoryStore) memberOf(userID int, organisationID int) bool {
+	for _, membership := range m.memberships {
+		if membership.UserID == userID && membership.OrganisationID == organisationID {
+			return true
+		}
+	}
+	return false
+}
+
+// userOrganisationIDs returns the organisations of a user in the order they joined
+func (m *MemoryStore) userOrganisationIDs(userID int) []int {
+	var organisationIDs []int
+	for _, membership := range m.memberships {
+		if membership.UserID == userID {
+			organisationIDs = append(organisationIDs, membership.OrganisationID)
+		}
+	}
+	sort.Ints(organisationIDs)
+	return organisationIDs
+}
+
+func (m *MemoryStore) removeMembership(userID int, organisationID int) {
+	for i, membership := range m.memberships {
+		if membership.UserID == userID && membership.OrganisationID == organisationID {
+			m.memberships = append(m.memberships[:i], m.memberships[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *MemoryStore) findOrganisation(organisationID int) (models.Organisation, bool) {
+	for _, organisation := range m.organisations {
+		if organisation.OrganisationID == organisationID {
+			return organisation, true
+		}
+	}
+	return models.Organisation{}, false
+}
+
+func (m *MemoryStore) GetAllOrganisations() ([]models.Organisation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	organisations := []models.Organisation{}
+	for _, organisation := range m.organisations {
+		if m.inOrganisation(organisation.OrganisationID) {
+			organisations = append(organisations, organisation)
+		}
+	}
+	sort.SliceStable(organisations, func(i, j int) bool { return organisations[i].Name < organisations[j].Name })
+
+	return organisations, nil
+}
+
+func (m *MemoryStore) GetOrganisationByID(organisationID int) (*models.Organisation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	organisation, ok := m.findOrganisation(organisationID)
+	if !ok || !m.inOrganisation(organisationID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return &organisation, nil
+}
+
+func (m *MemoryStore) AddOrganisation(name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.organisations {
+		if existing.Name == name {
+			return 0, uniqueViolation("organisationt_name_key")
+		}
+	}
+
+	organisation := models.Organisation{
+		OrganisationID: m.nextID("organisation"),
+		Name:           name,
+		CreatedAt:      now().Time,
+	}
+	m.organisations = append(m.organisations, organisation)
+
+	return organisation.OrganisationID, nil
+}
+
+func (m *MemoryStore) GetUserOrganisations(userID int) ([]models.Organisation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	organisations := []models.Organisation{}
+	for _, organisationID := range m.userOrganisationIDs(userID) {
+		organisation, _ := m.findOrganisation(organisationID)
+		organisations = append(organisations, organisation)
+	}
+
+	return organisations, nil
+}
+
+func (m *MemoryStore) AddUserToOrganisation(userID int, organisationID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.organisationID != 0 && organisationID != m.organisationID {
+		return sql.ErrNoRows
+	}
+	if _, ok := m.findUser(userID); !ok {
+		return foreignKeyViolation("userorganisationt_userid_fkey")
+	}
+	if _, ok := m.findOrganisation(organisationID); !ok {
+		return foreignKeyViolation("userorganisationt_organisationid_fkey")
+	}
+
+	if !m.memberOf(userID, organisationID) {
+		m.memberships = append(m.memberships, memoryMembership{UserID: userID, OrganisationID: organisationID})
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) RemoveUserFromOrganisation(userID int, organisationID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if (m.organisationID != 0 && organisationID != m.organisationID) || !m.memberOf(userID, organisationID) {
+		return sql.ErrNoRows
+	}
+	m.removeMembership(userID, organisationID)
+
+	return nil
+}
+
+func (m *MemoryStore) GetAllUsers() ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []models.User
+	for _, user := range m.users {
+		if !m.userInOrganisation(user.UserID) {
+			continue
+		}
+		users = append(users, models.User{
+			UserID:       user.UserID,
+			Username:     user.Username,
+			Email:        user.Email,
+			Role:         user.Role,
+			DefaultAdmin: user.DefaultAdmin,
+			Version:      user.Version,
+		})
+	}
+
+	return users, nil
+}
+
+func (m *MemoryStore) CreateUser(user *models.User) error {
+	return m.createUser(user, m.writeOrganisationID())
+}
+
+func (m *MemoryStore) RegisterUser(user *models.User) error {
+	return m.createUser(user, 0)
+}
+
+func (m *MemoryStore) createUser(user *models.User, organisationID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkUserUnique(0, user.Username, user.Email); err != nil {
+		return err
+	}
+
+	userID := m.nextID("user")
+	m.users = append(m.users, models.User{
+		UserID:   userID,
+		Username: user.Username,
+		Password: user.Password,
+		Email:    user.Email,
+		Role:     "User",
+		Version:  1,
+	})
+	if organisationID != 0 {
+		m.memberships = append(m.memberships, memoryMembership{UserID: userID, OrganisationID: organisationID})
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) checkUserUnique(userID int, username string, email string) error {
+	for _, existing := range m.users {
+		if existing.UserID == userID {
+			continue
+		}
+		if existing.Username == username {
+			return uniqueViolation("usert_username_key")
+		}
+		if existing.Email == email {
+			return uniqueViolation("usert_email_key")
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UpdateUserWithPassword(user *models.User) error {
+	return m.updateUser(user, true)
+}
+
+func (m *MemoryStore) UpdateUser(user *models.User) error {
+	return m.updateUser(user, false)
+}
+
+func (m *MemoryStore) updateUser(user *models.User, withPassword bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.ownsUser(user.UserID); err != nil {
+		return err
+	}
+
+	for i := range m.users {
+		if m.users[i].UserID != user.UserID || !m.userInOrganisation(user.UserID) {
+			continue
+		}
+		if err := matchVersion(m.users[i].Version, user.Version); err != nil {
+			return err
+		}
+		if err := m.checkUserUnique(user.UserID, user.Username, user.Email); err != nil {
+			return err
+		}
+		m.users[i].Username = user.Username
+		m.users[i].Email = user.Email
+		m.users[i].Role = user.Role
+		if withPassword {
+			m.users[i].Password = user.Password
+		}
+		m.users[i].Version++
+		return nil
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) GetUserByUsername(username string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Username == username && m.userInOrganisation(user.UserID) {
+			return &models.User{
+				UserID:       user.UserID,
+				Username:     user.Username,
+				Password:     user.Password,
+				Email:        user.Email,
+				Role:         user.Role,
+				DefaultAdmin: user.DefaultAdmin,
+				Version:      user.Version,
+			}, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetUserByID(userid int) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.findUser(userid)
+	if !ok || !m.userInOrganisation(userid) {
+		return nil, sql.ErrNoRows
+	}
+
+	return &models.User{
+		UserID:       user.UserID,
+		Username:     user.Username,
+		Password:     user.Password,
+		Email:        user.Email,
+		Role:         user.Role,
+		DefaultAdmin: user.DefaultAdmin,
+		Version:      user.Version,
+	}, nil
+}
+
+func (m *MemoryStore) findUser(userID int) (models.User, bool) {
+	for _, user := range m.users {
+		if user.UserID == userID {
+			return user, true
+		}
+	}
+	return models.User{}, false
+}
+
+func (m *MemoryStore) DeleteUser(userid int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A scoped store removes the user from its organisation, users of other organisations are left alone
+	if m.organisationID != 0 && !m.memberOf(userid, m.organisationID) {
+		return nil
+	}
+	if user, ok := m.findUser(userid); ok {
+		if err := matchVersion(user.Version, version); err != nil {
+			return err
+		}
+	}
+	if m.organisationID != 0 && len(m.userOrganisationIDs(userid)) > 1 {
+		m.removeMembership(userid, m.organisationID)
+		return nil
+	}
+
+	// Users who recorded inspections or maintenance are kept for the history
+	for _, inspection := range m.inspections {
+		if inspection.UserID == userid {
+			return foreignKeyViolation("emergency_device_inspectiont_userid_fkey")
+		}
+	}
+	for _, record := range m.maintenanceRecords {
+		if record.UserID == userid {
+			return foreignKeyViolation("maintenancerecordt_userid_fkey")
+		}
+	}
+
+	for i, user := range m.users {
+		if user.UserID == userid {
+			m.users = append(m.users[:i], m.users[i+1:]...)
+			break
+		}
+	}
+
+	// Rounds assigned to the user go back to unassigned
+	for i := range m.inspectionRounds {
+		if m.inspectionRounds[i].UserID.Valid && int(m.inspectionRounds[i].UserID.Int64) == userid {
+			m.inspectionRounds[i].UserID = sql.NullInt64{}
+		}
+	}
+
+	// Calendar links and memberships are deleted with the user
+	var feeds []models.CalendarFeed
+	for _, feed := range m.calendarFeeds {
+		if feed.UserID != userid {
+			feeds = append(feeds, feed)
+		}
+	}
+	m.calendarFeeds = feeds
+
+	// So are their saved views, their defaults and the views shared with them
+	for i := 0; i < len(m.savedViews); i++ {
+		if m.savedViews[i].UserID == userid {
+			m.deleteSavedView(i)
+			i--
+			continue
+		}
+		m.savedViews[i].SharedWith = slices.DeleteFunc(m.savedViews[i].SharedWith, func(userID int) bool { return userID == userid })
+	}
+	m.defaultSavedViews = slices.DeleteFunc(m.defaultSavedViews, func(row memoryDefaultSavedView) bool { return row.UserID == userid })
+
+	for _, organisationID := range m.userOrganisationIDs(userid) {
+		m.removeMembership(userid, organisationID)
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) UpdatePassword(userid int, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.ownsUser(userid); err != nil {
+		return err
+	}
+
+	for i := range m.users {
+		if m.users[i].UserID == userid && m.userInOrganisation(userid) {
+			m.users[i].Password = password
+			m.users[i].Version++
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) GetUserByEmail(email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Email == email && m.userInOrganisation(user.UserID) {
+			return &models.User{
+				UserID:   user.UserID,
+				Username: user.Username,
+				Password: user.Password,
+				Email:    user.Email,
+				Role:     user.Role,
+			}, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetAllDevices(siteId string, buildingCode string, floorId string) ([]models.EmergencyDevice, error) {
+	return m.getDevices(siteId, buildingCode, floorId, false)
+}
+
+func (m *MemoryStore) GetDecommissionedDevices(siteId string, buildingCode string, floorId string) ([]models.EmergencyDevice, error) {
+	return m.getDevices(siteId, buildingCode, floorId, true)
+}
+
+func (m *MemoryStore) getDevices(siteId string, buildingCode string, floorId string, decommissioned bool) ([]models.EmergencyDevice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buildingExists bool
+	var siteExists bool
+	var floorExists bool
+
+	if buildingCode != "" {
+		for _, building := range m.buildings {
+			if building.Row.BuildingCode == buildingCode && m.siteInOrganisation(building.Row.SiteID) {
+				buildingExists = true
+			}
+		}
+	}
+
+	var siteID int
+	if siteId != "" {
+		var err error
+		siteID, err = parseID(siteId)
+		if err != nil {
+			return nil, err
+		}
+		siteExists = m.siteInOrganisation(siteID)
+	}
+
+	var floorID int
+	if floorId != "" {
+		var err error
+		floorID, err = parseID(floorId)
+		if err != nil {
+			return nil, err
+		}
+		floor, ok := m.findFloor(floorID)
+		floorExists = ok && m.buildingInOrganisation(floor.BuildingID)
+	}
+
+	var emergencyDevices []models.EmergencyDevice
+	for _, stored := range m.devices {
+		if stored.DecommissionedAt.Valid != decommissioned || !m.roomInOrganisation(stored.RoomID) {
+			continue
+		}
+		// Devices still in service in an archived location are not listed as active
+		if !decommissioned && m.roomArchived(stored.RoomID) {
+			continue
+		}
+
+		joined := m.joinDevice(stored)
+		if siteId != "" && joined.SiteID != siteID {
+			continue
+		}
+		if buildingCode != "" && joined.BuildingCode != buildingCode {
+			continue
+		}
+		if floorId != "" && joined.FloorID != floorID {
+			continue
+		}
+
+		device := m.listedDevice(stored, joined)
+		emergencyDevices = append(emergencyDevices, device)
+	}
+
+	if (buildingExists && len(emergencyDevices) == 0) ||
+		(siteExists && len(emergencyDevices) == 0) ||
+		(floorExists && len(emergencyDevices) == 0) {
+		return []models.EmergencyDevice{}, nil
+	}
+
+	return emergencyDevices, nil
+}
+
+// listedDevice is a stored device as getDevices lists it, with the calculated dates
+func (m *MemoryStore) listedDevice(stored models.EmergencyDevice, joined models.EmergencyDevice) models.EmergencyDevice {
+	device := models.EmergencyDevice{
+		EmergencyDeviceID:       joined.EmergencyDeviceID,
+		EmergencyDeviceTypeName: joined.EmergencyDeviceTypeName,
+		ExtinguisherTypeName:    joined.ExtinguisherTypeName,
+		RoomCode:                joined.RoomCode,
+		FloorID:                 joined.FloorID,
+		FloorName:               joined.FloorName,
+		BuildingCode:            joined.BuildingCode,
+		SiteID:                  joined.SiteID,
+		SiteName:                joined.SiteName,
+		SiteTimeZone:            joined.SiteTimeZone,
+		SerialNumber:            joined.SerialNumber,
+		ManufactureDate:         joined.ManufactureDate,
+		LastInspectionDateTime:  joined.LastInspectionDateTime,
+		Description:             joined.Description,
+		Size:                    joined.Size,
+		Status:                  joined.Status,
+		DecommissionedAt:        joined.DecommissionedAt,
+		DecommissionReason:      joined.DecommissionReason,
+		Version:                 joined.Version,
+	}
+
+	var schedule deviceSchedule
+	if deviceType, ok := m.findDeviceType(stored.EmergencyDeviceTypeID); ok {
+		schedule.InspectionIntervalMonths = sql.NullInt64{Int64: int64(deviceType.InspectionIntervalMonths), Valid: true}
+		schedule.ServiceIntervalMonths = deviceType.ServiceIntervalMonths
+	}
+	if latest, ok := m.latestMaintenanceRecord(stored.EmergencyDeviceID); ok {
+		device.LastServiceDate = latest.ServiceDate
+		schedule.RecordedNextServiceDate = latest.NextServiceDate
+	}
+
+	setNotApplicable(&device)
+	applyExpireDate(&device)
+	applyDueDates(&device, schedule)
+
+	return device
+}
+
+// joinDevice fills in the type, extinguisher type and location of a stored device like GetDeviceByID
+func (m *MemoryStore) joinDevice(stored models.EmergencyDevice) models.EmergencyDevice {
+	device := stored
+
+	if deviceType, ok := m.findDeviceType(stored.EmergencyDeviceTypeID); ok {
+		device.EmergencyDeviceTypeName = deviceType.EmergencyDeviceTypeName
+	}
+	if stored.ExtinguisherTypeID.Valid {
+		if extinguisherType, ok := m.findExtinguisherType(int(stored.ExtinguisherTypeID.Int64)); ok {
+			device.ExtinguisherTypeName = sql.NullString{String: extinguisherType.ExtinguisherTypeName, Valid: true}
+		}
+	}
+	if room, ok := m.findRoom(stored.RoomID); ok {
+		device.RoomCode = room.Row.RoomCode
+		device.BuildingID = room.Row.BuildingID
+		device.FloorID = room.Row.FloorID
+		if floor, ok := m.findFloor(room.Row.FloorID); ok {
+			device.FloorName = floor.FloorName
+		}
+		if building, ok := m.findBuilding(room.Row.BuildingID); ok {
+			device.BuildingCode = building.Row.BuildingCode
+			device.SiteID = building.Row.SiteID
+			if site, ok := m.findSite(building.Row.SiteID); ok {
+				device.SiteName = site.Row.SiteName
+				device.SiteTimeZone = site.Row.TimeZone
+			}
+		}
+	}
+
+	device.UpdatedAt = sql.NullTime{}
+	device.SuccessorDeviceID = sql.NullInt64{}
+	for _, other := range m.devices {
+		if other.PredecessorDeviceID.Valid && int(other.PredecessorDeviceID.Int64) == stored.EmergencyDeviceID {
+			device.SuccessorDeviceID = sql.NullInt64{Int64: int64(other.EmergencyDeviceID), Valid: true}
+		}
+	}
+
+	localiseDevice(&device)
+
+	return device
+}
+
+// deviceSummary is a joined device without the lifecycle columns, as listed by room and by type
+func deviceSummary(device models.EmergencyDevice) models.EmergencyDevice {
+	device.DecommissionedAt = sql.NullTime{}
+	device.DecommissionReason = sql.NullString{}
+	device.PredecessorDeviceID = sql.NullInt64{}
+	device.SuccessorDeviceID = sql.NullInt64{}
+	return device
+}
+
+func (m *MemoryStore) findDevice(deviceID int) (int, bool) {
+	for i, device := range m.devices {
+		if device.EmergencyDeviceID == deviceID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (m *MemoryStore) latestMaintenanceRecord(deviceID int) (models.MaintenanceRecord, bool) {
+	var latest models.MaintenanceRecord
+	found := false
+	for _, record := range m.maintenanceRecords {
+		if record.EmergencyDeviceID != deviceID {
+			continue
+		}
+		if !found || record.ServiceDate.Time.After(latest.ServiceDate.Time) ||
+			(record.ServiceDate.Time.Equal(latest.ServiceDate.Time) && record.MaintenanceRecordID > latest.MaintenanceRecordID) {
+			latest = record
+			found = true
+		}
+	}
+	return latest, found
+}
+
+func (m *MemoryStore) GetDeviceByID(deviceID int) (*models.EmergencyDevice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findDevice(deviceID)
+	if !ok || !m.roomInOrganisation(m.devices[i].RoomID) {
+		return nil, sql.ErrNoRows
+	}
+
+	device := m.joinDevice(m.devices[i])
+	return &device, nil
+}
+
+func (m *MemoryStore) GetDevicesByRoomID(roomID int) ([]models.EmergencyDevice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var emergencyDevices []models.EmergencyDevice
+	for _, device := range m.devices {
+		if device.RoomID == roomID && !device.DecommissionedAt.Valid && m.roomInOrganisation(roomID) {
+			emergencyDevices = append(emergencyDevices, deviceSummary(m.joinDevice(device)))
+		}
+	}
+
+	return emergencyDevices, nil
+}
+
+func (m *MemoryStore) GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyDevice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var devices []models.EmergencyDevice
+	for _, device := range m.devices {
+		if device.EmergencyDeviceTypeID == emergencyDeviceTypeID && m.roomInOrganisation(device.RoomID) {
+			devices = append(devices, deviceSummary(m.joinDevice(device)))
+		}
+	}
+
+	return devices, nil
+}
+
+// checkDeviceInOrganisation checks that the room and types of a device are ones the store's organisation can use
+func (m *MemoryStore) checkDeviceInOrganisation(device *models.EmergencyDevice) error {
+	if err := m.requireInOrganisation(m.roomInOrganisation(device.RoomID)); err != nil {
+		return err
+	}
+	deviceType, ok := m.findDeviceType(device.EmergencyDeviceTypeID)
+	if err := m.requireInOrganisation(ok && m.typeVisible(deviceType.OrganisationID)); err != nil {
+		return err
+	}
+	if device.ExtinguisherTypeID.Valid {
+		return m.checkExtinguisherTypeInOrganisation(device.ExtinguisherTypeID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) checkExtinguisherTypeInOrganisation(extinguisherTypeID sql.NullInt64) error {
+	extinguisherType, ok := m.findExtinguisherType(int(extinguisherTypeID.Int64))
+	return m.requireInOrganisation(ok && m.typeVisible(extinguisherType.OrganisationID))
+}
+
+// checkDeviceReferences checks the foreign keys of a device row
+func (m *MemoryStore) checkDeviceReferences(device *models.EmergencyDevice) error {
+	if _, ok := m.findDeviceType(device.EmergencyDeviceTypeID); !ok {
+		return foreignKeyViolation("emergency_devicet_emergencydevicetypeid_fkey")
+	}
+	if _, ok := m.findRoom(device.RoomID); !ok {
+		return foreignKeyViolation("emergency_devicet_roomid_fkey")
+	}
+	if device.ExtinguisherTypeID.Valid {
+		if _, ok := m.findExtinguisherType(int(device.ExtinguisherTypeID.Int64)); !ok {
+			return foreignKeyViolation("emergency_devicet_extinguishertypeid_fkey")
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddEmergencyDevice(device *models.EmergencyDevice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkDeviceInOrganisation(device); err != nil {
+		return err
+	}
+	if err := m.checkDeviceReferences(device); err != nil {
+		return err
+	}
+
+	device.EmergencyDeviceID = m.nextID("emergency_device")
+	m.devices = append(m.devices, models.EmergencyDevice{
+		EmergencyDeviceID:     device.EmergencyDeviceID,
+		EmergencyDeviceTypeID: device.EmergencyDeviceTypeID,
+		ExtinguisherTypeID:    device.ExtinguisherTypeID,
+		RoomID:                device.RoomID,
+		SerialNumber:          device.SerialNumber,
+		ManufactureDate:       dateOnly(device.ManufactureDate),
+		Description:           device.Description,
+		Size:                  device.Size,
+		Status:                device.Status,
+		UpdatedAt:             now(),
+		Version:               1,
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) UpdateEmergencyDevice(device *models.EmergencyDevice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkDeviceInOrganisation(device); err != nil {
+		return err
+	}
+
+	i, ok := m.findDevice(device.EmergencyDeviceID)
+	if !ok || !m.roomInOrganisation(m.devices[i].RoomID) {
+		return nil
+	}
+
+	if err := matchVersion(m.devices[i].Version, device.Version); err != nil {
+		return err
+	}
+	if err := m.checkDeviceReferences(device); err != nil {
+		return err
+	}
+
+	stored := &m.devices[i]
+	stored.EmergencyDeviceTypeID = device.EmergencyDeviceTypeID
+	stored.ExtinguisherTypeID = device.ExtinguisherTypeID
+	stored.RoomID = device.RoomID
+	stored.SerialNumber = device.SerialNumber
+	stored.ManufactureDate = dateOnly(device.ManufactureDate)
+	stored.Description = device.Description
+	stored.Size = device.Size
+	stored.Status = device.Status
+	stored.UpdatedAt = now()
+	stored.Version++
+
+	return nil
+}
+
+func (m *MemoryStore) UpdateDeviceStatus(deviceID int, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i, ok := m.findDevice(deviceID); ok && m.roomInOrganisation(m.devices[i].RoomID) {
+		m.devices[i].Status = sql.NullString{String: status, Valid: true}
+		m.devices[i].UpdatedAt = now()
+		m.devices[i].Version++
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) DecommissionEmergencyDevice(deviceID int, reason string, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findDevice(deviceID)
+	if !ok || m.devices[i].DecommissionedAt.Valid || !m.roomInOrganisation(m.devices[i].RoomID) {
+		return sql.ErrNoRows
+	}
+	if err := matchVersion(m.devices[i].Version, version); err != nil {
+		return err
+	}
+
+	m.decommission(i, reason)
+	return nil
+}
+
+func (m *MemoryStore) decommission(i int, reason string) {
+	m.devices[i].DecommissionedAt = now()
+	m.devices[i].DecommissionReason = sql.NullString{String: reason, Valid: true}
+	m.devices[i].Status = sql.NullString{String: "Decommissioned", Valid: true}
+	m.devices[i].UpdatedAt = now()
+	m.devices[i].Version++
+}
+
+func (m *MemoryStore) PurgeEmergencyDevice(deviceID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.deviceInOrganisation(deviceID)); err != nil {
+		return err
+	}
+
+	var inspections []models.Inspection
+	for _, inspection := range m.inspections {
+		if inspection.EmergencyDeviceID != deviceID {
+			inspections = append(inspections, inspection)
+		}
+	}
+	m.inspections = inspections
+
+	var records []models.MaintenanceRecord
+	for _, record := range m.maintenanceRecords {
+		if record.EmergencyDeviceID != deviceID {
+			records = append(records, record)
+		}
+	}
+	m.maintenanceRecords = records
+
+	// Pins of the device are removed and a replacement loses its link, as the foreign keys do
+	var pins []models.FloorPlanPin
+	for _, pin := range m.floorPlanPins {
+		if !pin.EmergencyDeviceID.Valid || int(pin.EmergencyDeviceID.Int64) != deviceID {
+			pins = append(pins, pin)
+		}
+	}
+	m.floorPlanPins = pins
+
+	var roundDevices []memoryRoundDevice
+	for _, roundDevice := range m.roundDevices {
+		if roundDevice.EmergencyDeviceID != deviceID {
+			roundDevices = append(roundDevices, roundDevice)
+		}
+	}
+	m.roundDevices = roundDevices
+
+	for i := range m.devices {
+		if m.devices[i].PredecessorDeviceID.Valid && int(m.devices[i].PredecessorDeviceID.Int64) == deviceID {
+			m.devices[i].PredecessorDeviceID = sql.NullInt64{}
+			m.devices[i].UpdatedAt = now()
+			m.devices[i].Version++
+		}
+	}
+
+	if i, ok := m.findDevice(deviceID); ok {
+		m.devices = append(m.devices[:i], m.devices[i+1:]...)
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) ReplaceEmergencyDevice(oldDeviceID int, replacement *models.EmergencyDevice, reason string, version int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if replacement.ExtinguisherTypeID.Valid {
+		if err := m.checkExtinguisherTypeInOrganisation(replacement.ExtinguisherTypeID); err != nil {
+			return 0, err
+		}
+	}
+
+	i, ok := m.findDevice(oldDeviceID)
+	if !ok || !m.roomInOrganisation(m.devices[i].RoomID) {
+		return 0, sql.ErrNoRows
+	}
+
+	old := m.devices[i]
+	if old.DecommissionedAt.Valid {
+		return 0, ErrDeviceDecommissioned
+	}
+	if err := matchVersion(old.Version, version); err != nil {
+		return 0, err
+	}
+
+	replacement.RoomID = old.RoomID
+	replacement.EmergencyDeviceTypeID = old.EmergencyDeviceTypeID
+	replacement.Description = old.Description
+	if !replacement.ExtinguisherTypeID.Valid {
+		replacement.ExtinguisherTypeID = old.ExtinguisherTypeID
+	}
+	if !replacement.Size.Valid {
+		replacement.Size = old.Size
+	}
+	if !replacement.Status.Valid {
+		replacement.Status = sql.NullString{String: "Active", Valid: true}
+	}
+
+	if err := m.checkDeviceReferences(replacement); err != nil {
+		return 0, err
+	}
+
+	newDeviceID := m.nextID("emergency_device")
+	m.devices = append(m.devices, models.EmergencyDevice{
+		EmergencyDeviceID:     newDeviceID,
+		EmergencyDeviceTypeID: replacement.EmergencyDeviceTypeID,
+		ExtinguisherTypeID:    replacement.ExtinguisherTypeID,
+		RoomID:                replacement.RoomID,
+		SerialNumber:          replacement.SerialNumber,
+		ManufactureDate:       dateOnly(replacement.ManufactureDate),
+		Description:           replacement.Description,
+		Size:                  replacement.Size,
+		Status:                replacement.Status,
+		PredecessorDeviceID:   sql.NullInt64{Int64: int64(oldDeviceID), Valid: true},
+		UpdatedAt:             now(),
+		Version:               1,
+	})
+	m.decommission(i, fmt.Sprintf("Replaced by device %d: %s", newDeviceID, reason))
+
+	replacement.EmergencyDeviceID = newDeviceID
+	replacement.PredecessorDeviceID = sql.NullInt64{Int64: int64(oldDeviceID), Valid: true}
+
+	return newDeviceID, nil
+}
+
+func (m *MemoryStore) GetDeviceReplacementChain(deviceID int) ([]models.EmergencyDevice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findDevice(deviceID)
+	if !ok || !m.roomInOrganisation(m.devices[i].RoomID) {
+		return nil, sql.ErrNoRows
+	}
+
+	// Walk back through the predecessors, then forward through the successors
+	deviceIDs := []int{deviceID}
+	for current := m.devices[i]; current.PredecessorDeviceID.Valid; {
+		j, ok := m.findDevice(int(current.PredecessorDeviceID.Int64))
+		if !ok {
+			break
+		}
+		current = m.devices[j]
+		deviceIDs = append(deviceIDs, current.EmergencyDeviceID)
+	}
+	for current := m.joinDevice(m.devices[i]); current.SuccessorDeviceID.Valid; {
+		j, _ := m.findDevice(int(current.SuccessorDeviceID.Int64))
+		current = m.joinDevice(m.devices[j])
+		deviceIDs = append(deviceIDs, current.EmergencyDeviceID)
+	}
+	sort.Ints(deviceIDs)
+
+	var chain []models.EmergencyDevice
+	for _, id := range deviceIDs {
+		j, _ := m.findDevice(id)
+		chain = append(chain, m.joinDevice(m.devices[j]))
+	}
+
+	return chain, nil
+}
+
+func (m *MemoryStore) GetAllDeviceTypes() ([]models.EmergencyDeviceType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deviceTypes []models.EmergencyDeviceType
+	for _, deviceType := range m.deviceTypes {
+		if m.typeVisible(deviceType.OrganisationID) {
+			deviceTypes = append(deviceTypes, deviceType)
+		}
+	}
+	sort.SliceStable(deviceTypes, func(i, j int) bool {
+		return deviceTypes[i].EmergencyDeviceTypeName < deviceTypes[j].EmergencyDeviceTypeName
+	})
+
+	return deviceTypes, nil
+}
+
+func (m *MemoryStore) findDeviceType(emergencyDeviceTypeID int) (models.EmergencyDeviceType, bool) {
+	for _, deviceType := range m.deviceTypes {
+		if deviceType.EmergencyDeviceTypeID == emergencyDeviceTypeID {
+			return deviceType, true
+		}
+	}
+	return models.EmergencyDeviceType{}, false
+}
+
+func (m *MemoryStore) GetEmergencyDeviceTypeByID(emergencyDeviceTypeID int) (*models.EmergencyDeviceType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deviceType, ok := m.findDeviceType(emergencyDeviceTypeID)
+	if !ok || !m.typeVisible(deviceType.OrganisationID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return &deviceType, nil
+}
+
+func (m *MemoryStore) GetDeviceTypeByName(emergencyDeviceTypeName string) (*models.EmergencyDeviceType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, deviceType := range m.deviceTypes {
+		if deviceType.EmergencyDeviceTypeName == emergencyDeviceTypeName && m.typeVisible(deviceType.OrganisationID) {
+			return &deviceType, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// checkDeviceType checks the constraints of a device type row
+func (m *MemoryStore) checkDeviceType(emergencyDeviceType *models.EmergencyDeviceType) error {
+	for _, existing := range m.deviceTypes {
+		if existing.EmergencyDeviceTypeID != emergencyDeviceType.EmergencyDeviceTypeID &&
+			existing.OrganisationID == emergencyDeviceType.OrganisationID &&
+			existing.EmergencyDeviceTypeName == emergencyDeviceType.EmergencyDeviceTypeName {
+			return uniqueViolation("emergency_device_typet_organisation_name_key")
+		}
+	}
+	if emergencyDeviceType.InspectionIntervalMonths <= 0 {
+		return checkViolation("emergency_device_typet_inspectionintervalmonths_check")
+	}
+	if emergencyDeviceType.ServiceIntervalMonths.Valid && emergencyDeviceType.ServiceIntervalMonths.Int64 <= 0 {
+		return checkViolation("emergency_device_typet_serviceintervalmonths_check")
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddEmergencyDeviceType(emergencyDeviceType *models.EmergencyDeviceType) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deviceType := *emergencyDeviceType
+	deviceType.EmergencyDeviceTypeID = 0
+	deviceType.OrganisationID = sql.NullInt64{Int64: int64(m.organisationID), Valid: m.organisationID != 0}
+	if err := m.checkDeviceType(&deviceType); err != nil {
+		return err
+	}
+
+	deviceType.EmergencyDeviceTypeID = m.nextID("emergency_device_type")
+	deviceType.Version = 1
+	m.deviceTypes = append(m.deviceTypes, deviceType)
+
+	return nil
+}
+
+func (m *MemoryStore) UpdateEmergencyDeviceType(emergencyDeviceType *models.EmergencyDeviceType) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.deviceTypes {
+		if m.deviceTypes[i].EmergencyDeviceTypeID != emergencyDeviceType.EmergencyDeviceTypeID || !m.typeOwned(m.deviceTypes[i].OrganisationID) {
+			continue
+		}
+		if err := matchVersion(m.deviceTypes[i].Version, emergencyDeviceType.Version); err != nil {
+			return err
+		}
+		deviceType := *emergencyDeviceType
+		deviceType.OrganisationID = m.deviceTypes[i].OrganisationID
+		if err := m.checkDeviceType(&deviceType); err != nil {
+			return err
+		}
+		deviceType.Version = m.deviceTypes[i].Version + 1
+		m.deviceTypes[i] = deviceType
+		return nil
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) DeleteEmergencyDeviceType(emergencyDeviceTypeID int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deviceType, ok := m.findDeviceType(emergencyDeviceTypeID)
+	if !ok || !m.typeOwned(deviceType.OrganisationID) {
+		return nil
+	}
+	if err := matchVersion(deviceType.Version, version); err != nil {
+		return err
+	}
+
+	for _, device := range m.devices {
+		if device.EmergencyDeviceTypeID == emergencyDeviceTypeID {
+			return foreignKeyViolation("emergency_devicet_emergencydevicetypeid_fkey")
+		}
+	}
+
+	for i, deviceType := range m.deviceTypes {
+		if deviceType.EmergencyDeviceTypeID == emergencyDeviceTypeID {
+			m.deviceTypes = append(m.deviceTypes[:i], m.deviceTypes[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) GetAllExtinguisherTypes() ([]models.ExtinguisherType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var extinguisherTypes []models.ExtinguisherType
+	for _, extinguisherType := range m.extinguisherTypes {
+		if m.typeVisible(extinguisherType.OrganisationID) {
+			extinguisherTypes = append(extinguisherTypes, extinguisherType)
+		}
+	}
+	sort.SliceStable(extinguisherTypes, func(i, j int) bool {
+		return extinguisherTypes[i].ExtinguisherTypeName < extinguisherTypes[j].ExtinguisherTypeName
+	})
+
+	return extinguisherTypes, nil
+}
+
+func (m *MemoryStore) findExtinguisherType(extinguisherTypeID int) (models.ExtinguisherType, bool) {
+	for _, extinguisherType := range m.extinguisherTypes {
+		if extinguisherType.ExtinguisherTypeID == extinguisherTypeID {
+			return extinguisherType, true
+		}
+	}
+	return models.ExtinguisherType{}, false
+}
+
+func (m *MemoryStore) GetExtinguisherTypeByID(extinguisherTypeID int) (*models.ExtinguisherType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	extinguisherType, ok := m.findExtinguisherType(extinguisherTypeID)
+	if !ok || !m.typeVisible(extinguisherType.OrganisationID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return &extinguisherType, nil
+}
+
+func (m *MemoryStore) findSite(siteID int) (memoryLocation[models.Site], bool) {
+	for _, site := range m.sites {
+		if site.Row.SiteID == siteID {
+			return site, true
+		}
+	}
+	return memoryLocation[models.Site]{}, false
+}
+
+func (m *MemoryStore) GetAllSites() ([]models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sites []models.Site
+	for _, site := range m.sites {
+		if !site.ArchivedAt.Valid && m.inOrganisation(site.Row.OrganisationID) {
+			sites = append(sites, models.Site{
+				SiteID:         site.Row.SiteID,
+				SiteName:       site.Row.SiteName,
+				SiteAddress:    site.Row.SiteAddress,
+				TimeZone:       site.Row.TimeZone,
+				OrganisationID: site.Row.OrganisationID,
+			})
+		}
+	}
+	sort.SliceStable(sites, func(i, j int) bool { return sites[i].SiteName < sites[j].SiteName })
+
+	return sites, nil
+}
+
+func (m *MemoryStore) GetSiteByID(siteID string) (*models.Site, error) {
+	id, err := parseID(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	site, ok := m.findSite(id)
+	if !ok || !m.inOrganisation(site.Row.OrganisationID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return &site.Row, nil
+}
+
+func (m *MemoryStore) GetSiteByName(siteName string) (*models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, site := range m.sites {
+		if site.Row.SiteName == siteName && m.inOrganisation(site.Row.OrganisationID) {
+			return &site.Row, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) checkSiteUnique(siteID int, organisationID int, siteName string) error {
+	for _, existing := range m.sites {
+		if existing.Row.SiteID != siteID && existing.Row.OrganisationID == organisationID && existing.Row.SiteName == siteName {
+			return uniqueViolation("sitet_organisationid_sitename_key")
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddSite(site *models.Site) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkSiteUnique(0, m.writeOrganisationID(), site.SiteName); err != nil {
+		return err
+	}
+
+	row := *site
+	row.SiteID = m.nextID("site")
+	row.TimeZone = siteTimeZone(site)
+	row.OrganisationID = m.writeOrganisationID()
+	row.Version = 1
+	m.sites = append(m.sites, memoryLocation[models.Site]{Row: row, UpdatedAt: now()})
+
+	return nil
+}
+
+func (m *MemoryStore) UpdateSite(site *models.Site) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.sites {
+		organisationID := m.sites[i].Row.OrganisationID
+		if m.sites[i].Row.SiteID != site.SiteID || !m.inOrganisation(organisationID) {
+			continue
+		}
+		version := m.sites[i].Row.Version
+		if err := matchVersion(version, site.Version); err != nil {
+			return err
+		}
+		if err := m.checkSiteUnique(site.SiteID, organisationID, site.SiteName); err != nil {
+			return err
+		}
+		m.sites[i].Row = *site
+		m.sites[i].Row.TimeZone = siteTimeZone(site)
+		m.sites[i].Row.OrganisationID = organisationID
+		m.sites[i].Row.Version = version + 1
+		m.sites[i].UpdatedAt = now()
+		return nil
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) ArchiveSite(siteID string, reason string, version int) error {
+	id, err := parseID(siteID)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.sites {
+		if m.sites[i].Row.SiteID == id && !m.sites[i].ArchivedAt.Valid && m.inOrganisation(m.sites[i].Row.OrganisationID) {
+			if err := matchVersion(m.sites[i].Row.Version, version); err != nil {
+				return err
+			}
+			m.sites[i].ArchivedAt = now()
+			m.sites[i].ArchiveReason = sql.NullString{String: reason, Valid: reason != ""}
+			m.sites[i].Row.Version++
+			m.sites[i].UpdatedAt = now()
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) findBuilding(buildingID int) (memoryLocation[models.Building], bool) {
+	for _, building := range m.buildings {
+		if building.Row.BuildingID == buildingID {
+			return building, true
+		}
+	}
+	return memoryLocation[models.Building]{}, false
+}
+
+func (m *MemoryStore) GetAllBuildings(siteId string) ([]models.Building, error) {
+	var siteID int
+	if siteId != "" {
+		var err error
+		if siteID, err = parseID(siteId); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buildings []models.Building
+	for _, building := range m.buildings {
+		if m.buildingArchived(building.Row.BuildingID) || (siteId != "" && building.Row.SiteID != siteID) || !m.siteInOrganisation(building.Row.SiteID) {
+			continue
+		}
+		row := building.Row
+		if site, ok := m.findSite(row.SiteID); ok {
+			row.SiteName = site.Row.SiteName
+		}
+		buildings = append(buildings, row)
+	}
+	sort.SliceStable(buildings, func(i, j int) bool { return buildings[i].BuildingCode < buildings[j].BuildingCode })
+
+	return buildings, nil
+}
+
+func (m *MemoryStore) GetBuildingById(buildingID int) (*models.Building, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	building, ok := m.findBuilding(buildingID)
+	if !ok || !m.siteInOrganisation(building.Row.SiteID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return &building.Row, nil
+}
+
+func (m *MemoryStore) GetBuildingByCodeandSite(buildingCode string, siteId int) (*models.Building, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, building := range m.buildings {
+		if building.Row.BuildingCode == buildingCode && building.Row.SiteID == siteId && m.siteInOrganisation(siteId) {
+			return &models.Building{
+				BuildingID:   building.Row.BuildingID,
+				SiteID:       building.Row.SiteID,
+				BuildingCode: building.Row.BuildingCode,
+			}, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// buildingRow checks the constraints of a building row and returns it as stored
+func (m *MemoryStore) buildingRow(building *models.Building) (models.Building, error) {
+	if _, ok := m.findSite(building.SiteID); !ok {
+		return models.Building{}, foreignKeyViolation("buildingt_siteid_fkey")
+	}
+	for _, existing := range m.buildings {
+		if existing.Row.BuildingID != building.BuildingID && existing.Row.SiteID == building.SiteID &&
+			existing.Row.BuildingCode == building.BuildingCode {
+			return models.Building{}, uniqueViolation("buildingt_siteid_buildingcode_key")
+		}
+	}
+	if building.MapX.Valid != building.MapY.Valid {
+		return models.Building{}, checkViolation("buildingt_map_position_check")
+	}
+
+	row := models.Building{
+		BuildingID:   building.BuildingID,
+		SiteID:       building.SiteID,
+		BuildingCode: building.BuildingCode,
+		MapX:         building.MapX,
+		MapY:         building.MapY,
+		MapPolygon:   building.MapPolygon,
+	}
+	for _, coordinate := range []*sql.NullFloat64{&row.MapX, &row.MapY} {
+		if coordinate.Valid {
+			if coordinate.Float64 < 0 || coordinate.Float64 > 1 {
+				return models.Building{}, checkViolation("buildingt_map_check")
+			}
+			coordinate.Float64 = roundNumeric(coordinate.Float64, 6)
+		}
+	}
+
+	return row, nil
+}
+
+func (m *MemoryStore) AddBuilding(building *models.Building) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.siteInOrganisation(building.SiteID)); err != nil {
+		return err
+	}
+
+	candidate := *building
+	candidate.BuildingID = 0
+	row, err := m.buildingRow(&candidate)
+	if err != nil {
+		return err
+	}
+
+	row.BuildingID = m.nextID("building")
+	row.Version = 1
+	m.buildings = append(m.buildings, memoryLocation[models.Building]{Row: row, UpdatedAt: now()})
+
+	// Every building starts with a ground floor, see add_ground_floor
+	m.floors = append(m.floors, models.Floor{
+		FloorID:    m.nextID("floor"),
+		BuildingID: row.BuildingID,
+		FloorName:  "Ground",
+		FloorLevel: 0,
+		Version:    1,
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) UpdateBuilding(building *models.Building) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.siteInOrganisation(building.SiteID)); err != nil {
+		return err
+	}
+
+	for i := range m.buildings {
+		if m.buildings[i].Row.BuildingID != building.BuildingID || !m.siteInOrganisation(m.buildings[i].Row.SiteID) {
+			continue
+		}
+		if err := matchVersion(m.buildings[i].Row.Version, building.Version); err != nil {
+			return err
+		}
+		row, err := m.buildingRow(building)
+		if err != nil {
+			return err
+		}
+		row.Version = m.buildings[i].Row.Version + 1
+		m.buildings[i].Row = row
+		m.buildings[i].UpdatedAt = now()
+		return nil
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) ArchiveBuilding(buildingID string, reason string, version int) error {
+	id, err := parseID(buildingID)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.buildings {
+		if m.buildings[i].Row.BuildingID == id && !m.buildings[i].ArchivedAt.Valid && m.siteInOrganisation(m.buildings[i].Row.SiteID) {
+			if err := matchVersion(m.buildings[i].Row.Version, version); err != nil {
+				return err
+			}
+			m.buildings[i].ArchivedAt = now()
+			m.buildings[i].ArchiveReason = sql.NullString{String: reason, Valid: reason != ""}
+			m.buildings[i].Row.Version++
+			m.buildings[i].UpdatedAt = now()
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) findFloor(floorID int) (models.Floor, bool) {
+	for _, floor := range m.floors {
+		if floor.FloorID == floorID {
+			return floor, true
+		}
+	}
+	return models.Floor{}, false
+}
+
+// defaultFloor is the floor of a building nearest the ground, above ground first, see default_floor
+func (m *MemoryStore) defaultFloor(buildingID int) int {
+	var best models.Floor
+	for _, floor := range m.floors {
+		if floor.BuildingID != buildingID {
+			continue
+		}
+		distance, bestDistance := abs(floor.FloorLevel), abs(best.FloorLevel)
+		if best.FloorID == 0 || distance < bestDistance || (distance == bestDistance && floor.FloorLevel > best.FloorLevel) {
+			best = floor
+		}
+	}
+	return best.FloorID
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// joinFloor fills in the building, site and plan of a stored floor
+func (m *MemoryStore) joinFloor(floor models.Floor) models.Floor {
+	if building, ok := m.findBuilding(floor.BuildingID); ok {
+		floor.BuildingCode = building.Row.BuildingCode
+		floor.SiteID = building.Row.SiteID
+	}
+	for _, floorPlan := range m.floorPlans {
+		if floorPlan.FloorID == floor.FloorID {
+			floor.FloorPlanID = sql.NullInt64{Int64: int64(floorPlan.FloorPlanID), Valid: true}
+			floor.ImagePath = sql.NullString{String: floorPlan.ImagePath, Valid: true}
+		}
+	}
+	return floor
+}
+
+func (m *MemoryStore) GetFloorsByBuildingID(buildingID int) ([]models.Floor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	floors := []models.Floor{}
+	for _, floor := range m.floors {
+		if floor.BuildingID == buildingID && m.buildingInOrganisation(buildingID) {
+			floors = append(floors, m.joinFloor(floor))
+		}
+	}
+	sort.SliceStable(floors, func(i, j int) bool { return floors[i].FloorLevel < floors[j].FloorLevel })
+
+	return floors, nil
+}
+
+func (m *MemoryStore) GetFloorByID(floorID int) (*models.Floor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	floor, ok := m.findFloor(floorID)
+	if !ok || !m.buildingInOrganisation(floor.BuildingID) {
+		return nil, sql.ErrNoRows
+	}
+
+	joined := m.joinFloor(floor)
+	return &joined, nil
+}
+
+// checkFloorUnique checks that no other floor of the building is on the same level
+func (m *MemoryStore) checkFloorUnique(floorID int, buildingID int, floorLevel int) error {
+	for _, existing := range m.floors {
+		if existing.FloorID != floorID && existing.BuildingID == buildingID && existing.FloorLevel == floorLevel {
+			return uniqueViolation("floort_buildingid_floorlevel_key")
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddFloor(floor *models.Floor) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.buildingInOrganisation(floor.BuildingID)); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.findBuilding(floor.BuildingID); !ok {
+		return 0, foreignKeyViolation("floort_buildingid_fkey")
+	}
+	if err := m.checkFloorUnique(0, floor.BuildingID, floor.FloorLevel); err != nil {
+		return 0, err
+	}
+
+	stored := models.Floor{
+		FloorID:    m.nextID("floor"),
+		BuildingID: floor.BuildingID,
+		FloorName:  floor.FloorName,
+		FloorLevel: floor.FloorLevel,
+		Version:    1,
+	}
+	m.floors = append(m.floors, stored)
+
+	return stored.FloorID, nil
+}
+
+func (m *MemoryStore) UpdateFloor(floor *models.Floor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.floors {
+		if m.floors[i].FloorID != floor.FloorID || !m.buildingInOrganisation(m.floors[i].BuildingID) {
+			continue
+		}
+		if err := matchVersion(m.floors[i].Version, floor.Version); err != nil {
+			return err
+		}
+		if err := m.checkFloorUnique(floor.FloorID, m.floors[i].BuildingID, floor.FloorLevel); err != nil {
+			return err
+		}
+		m.floors[i].FloorName = floor.FloorName
+		m.floors[i].FloorLevel = floor.FloorLevel
+		m.floors[i].Version++
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteFloor(floorID int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.floors {
+		if m.floors[i].FloorID != floorID || !m.buildingInOrganisation(m.floors[i].BuildingID) {
+			continue
+		}
+		if err := matchVersion(m.floors[i].Version, version); err != nil {
+			return err
+		}
+		for _, room := range m.rooms {
+			if room.Row.FloorID == floorID {
+				return foreignKeyViolation("roomt_floorid_buildingid_fkey")
+			}
+		}
+
+		// The plan goes with its floor
+		for j := range m.floorPlans {
+			if m.floorPlans[j].FloorID == floorID {
+				m.deleteFloorPlan(j)
+				break
+			}
+		}
+		m.floors = append(m.floors[:i], m.floors[i+1:]...)
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) findRoom(roomID int) (memoryLocation[models.Room], bool) {
+	for _, room := range m.rooms {
+		if room.Row.RoomID == roomID {
+			return room, true
+		}
+	}
+	return memoryLocation[models.Room]{}, false
+}
+
+// joinRoom fills in the floor, building and site of a stored room
+func (m *MemoryStore) joinRoom(room models.Room) models.Room {
+	room.UpdatedAt = sql.NullTime{}
+	if floor, ok := m.findFloor(room.FloorID); ok {
+		room.FloorName = floor.FloorName
+		room.FloorLevel = floor.FloorLevel
+	}
+	if building, ok := m.findBuilding(room.BuildingID); ok {
+		room.BuildingCode = building.Row.BuildingCode
+		room.SiteID = building.Row.SiteID
+		if site, ok := m.findSite(building.Row.SiteID); ok {
+			room.SiteName = site.Row.SiteName
+		}
+	}
+	return room
+}
+
+func sortRoomsByCode(rooms []models.Room) {
+	sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].RoomCode < rooms[j].RoomCode })
+}
+
+func (m *MemoryStore) GetAllRooms(buildingId string, floorId string) ([]models.Room, error) {
+	var buildingID int
+	if buildingId != "" {
+		var err error
+		if buildingID, err = parseID(buildingId); err != nil {
+			return nil, err
+		}
+	}
+	var floorID int
+	if floorId != "" {
+		var err error
+		if floorID, err = parseID(floorId); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if m.roomArchived(room.Row.RoomID) || (buildingId != "" && room.Row.BuildingID != buildingID) ||
+			(floorId != "" && room.Row.FloorID != floorID) || !m.buildingInOrganisation(room.Row.BuildingID) {
+			continue
+		}
+		joined := m.joinRoom(room.Row)
+		rooms = append(rooms, models.Room{
+			RoomID:       joined.RoomID,
+			BuildingID:   joined.BuildingID,
+			RoomCode:     joined.RoomCode,
+			BuildingCode: joined.BuildingCode,
+			SiteName:     joined.SiteName,
+			FloorID:      joined.FloorID,
+			FloorName:    joined.FloorName,
+			FloorLevel:   joined.FloorLevel,
+		})
+	}
+	sort.SliceStable(rooms, func(i, j int) bool {
+		if rooms[i].BuildingCode != rooms[j].BuildingCode {
+			return rooms[i].BuildingCode < rooms[j].BuildingCode
+		}
+		if rooms[i].FloorLevel != rooms[j].FloorLevel {
+			return rooms[i].FloorLevel < rooms[j].FloorLevel
+		}
+		return rooms[i].RoomCode < rooms[j].RoomCode
+	})
+
+	return rooms, nil
+}
+
+func (m *MemoryStore) GetRoomsByBuildingID(buildingID string) ([]models.Room, error) {
+	id, err := parseID(buildingID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if !m.roomArchived(room.Row.RoomID) && room.Row.BuildingID == id && m.buildingInOrganisation(id) {
+			rooms = append(rooms, m.joinRoom(room.Row))
+		}
+	}
+	sortRoomsByCode(rooms)
+	sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].FloorLevel < rooms[j].FloorLevel })
+
+	// Only the IDs and floors are selected
+	for i := range rooms {
+		rooms[i] = models.Room{
+			RoomID:     rooms[i].RoomID,
+			FloorID:    rooms[i].FloorID,
+			FloorName:  rooms[i].FloorName,
+			FloorLevel: rooms[i].FloorLevel,
+		}
+	}
+
+	return rooms, nil
+}
+
+func (m *MemoryStore) GetRoomsBySiteID(siteID string) ([]models.Room, error) {
+	id, err := parseID(siteID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		joined := m.joinRoom(room.Row)
+		if m.roomArchived(room.Row.RoomID) || joined.SiteID != id || !m.siteInOrganisation(id) {
+			continue
+		}
+		rooms = append(rooms, models.Room{
+			RoomID:       joined.RoomID,
+			RoomCode:     joined.RoomCode,
+			BuildingCode: joined.BuildingCode,
+			SiteName:     joined.SiteName,
+		})
+	}
+	sortRoomsByCode(rooms)
+
+	return rooms, nil
+}
+
+func (m *MemoryStore) GetRoomByID(roomID int) (*models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.findRoom(roomID)
+	if !ok || !m.buildingInOrganisation(room.Row.BuildingID) {
+		return nil, sql.ErrNoRows
+	}
+
+	joined := m.joinRoom(room.Row)
+	return &joined, nil
+}
+
+func (m *MemoryStore) GetRoomByCodeAndSite(roomCode string, siteId int) (*models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, room := range m.rooms {
+		if room.Row.RoomCode == roomCode && m.joinRoom(room.Row).SiteID == siteId && m.siteInOrganisation(siteId) {
+			return &models.Room{RoomID: room.Row.RoomID, BuildingID: room.Row.BuildingID, RoomCode: room.Row.RoomCode}, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetRoomByCodeAndBuilding(roomCode string, buildingId int) (*models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, room := range m.rooms {
+		if room.Row.RoomCode == roomCode && room.Row.BuildingID == buildingId && m.buildingInOrganisation(buildingId) {
+			return &models.Room{RoomID: room.Row.RoomID, BuildingID: room.Row.BuildingID, RoomCode: room.Row.RoomCode}, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// checkRoom checks the constraints of a room row
+func (m *MemoryStore) checkRoom(roomID int, buildingID int, floorID int, roomCode string) error {
+	if _, ok := m.findBuilding(buildingID); !ok {
+		return foreignKeyViolation("roomt_buildingid_fkey")
+	}
+	if floor, ok := m.findFloor(floorID); !ok || floor.BuildingID != buildingID {
+		return foreignKeyViolation("roomt_floorid_buildingid_fkey")
+	}
+	for _, existing := range m.rooms {
+		if existing.Row.RoomID != roomID && existing.Row.BuildingID == buildingID && existing.Row.RoomCode == roomCode {
+			return uniqueViolation("roomt_buildingid_roomcode_key")
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddRoom(room *models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.buildingInOrganisation(room.BuildingID)); err != nil {
+		return err
+	}
+
+	// Without a floor the room goes on the building's default floor, see set_room_floor
+	floorID := room.FloorID
+	if floorID == 0 {
+		floorID = m.defaultFloor(room.BuildingID)
+	}
+
+	if err := m.checkRoom(0, room.BuildingID, floorID, room.RoomCode); err != nil {
+		return err
+	}
+
+	m.rooms = append(m.rooms, memoryLocation[models.Room]{Row: models.Room{
+		RoomID:     m.nextID("room"),
+		BuildingID: room.BuildingID,
+		FloorID:    floorID,
+		RoomCode:   room.RoomCode,
+		UpdatedAt:  now(),
+		Version:    1,
+	}})
+
+	return nil
+}
+
+func (m *MemoryStore) UpdateRoom(room *models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.buildingInOrganisation(room.BuildingID)); err != nil {
+		return err
+	}
+
+	for i := range m.rooms {
+		if m.rooms[i].Row.RoomID != room.RoomID || !m.buildingInOrganisation(m.rooms[i].Row.BuildingID) {
+			continue
+		}
+		// Without a floor the room keeps its floor, or goes on the default floor of the building it moves to
+		floorID := room.FloorID
+		if floorID == 0 {
+			floorID = m.rooms[i].Row.FloorID
+			if room.BuildingID != m.rooms[i].Row.BuildingID {
+				floorID = m.defaultFloor(room.BuildingID)
+			}
+		}
+		if err := matchVersion(m.rooms[i].Row.Version, room.Version); err != nil {
+			return err
+		}
+		if err := m.checkRoom(room.RoomID, room.BuildingID, floorID, room.RoomCode); err != nil {
+			return err
+		}
+		m.rooms[i].Row.BuildingID = room.BuildingID
+		m.rooms[i].Row.FloorID = floorID
+		m.rooms[i].Row.RoomCode = room.RoomCode
+		m.rooms[i].Row.UpdatedAt = now()
+		m.rooms[i].Row.Version++
+		return nil
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) ArchiveRoom(roomID int, reason string, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.rooms {
+		if m.rooms[i].Row.RoomID == roomID && !m.rooms[i].ArchivedAt.Valid && m.buildingInOrganisation(m.rooms[i].Row.BuildingID) {
+			if err := matchVersion(m.rooms[i].Row.Version, version); err != nil {
+				return err
+			}
+			m.rooms[i].ArchivedAt = now()
+			m.rooms[i].ArchiveReason = sql.NullString{String: reason, Valid: reason != ""}
+			m.rooms[i].Row.UpdatedAt = now()
+			m.rooms[i].Row.Version++
+		}
+	}
+
+	return nil
+}
+
+// joinInspection fills in the device serial number and inspector of a stored inspection
+func (m *MemoryStore) joinInspection(inspection models.Inspection) models.Inspection {
+	if i, ok := m.findDevice(inspection.EmergencyDeviceID); ok {
+		device := m.joinDevice(m.devices[i])
+		inspection.SerialNumber = device.SerialNumber.String
+		inspection.SiteTimeZone = device.SiteTimeZone
+	}
+	if user, ok := m.findUser(inspection.UserID); ok {
+		inspection.InspectorName = user.Username
+	}
+	localiseInspection(&inspection)
+	return inspection
+}
+
+func (m *MemoryStore) GetAllInspectionsByDeviceID(deviceID int) ([]models.Inspection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var inspections []models.Inspection
+	for _, inspection := range m.inspections {
+		if inspection.EmergencyDeviceID == deviceID && m.deviceInOrganisation(deviceID) {
+			inspections = append(inspections, m.joinInspection(inspection))
+		}
+	}
+	sort.SliceStable(inspections, func(i, j int) bool {
+		return inspections[i].InspectionDateTime.Time.After(inspections[j].InspectionDateTime.Time)
+	})
+
+	return inspections, nil
+}
+
+func (m *MemoryStore) GetInspectionByID(inspectionID int) (*models.Inspection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, inspection := range m.inspections {
+		if inspection.EmergencyDeviceInspectionID == inspectionID && m.deviceInOrganisation(inspection.EmergencyDeviceID) {
+			joined := m.joinInspection(inspection)
+			return &joined, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetInspectionByClientID(clientID string) (*models.Inspection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, inspection := range m.inspections {
+		if inspection.ClientID.Valid && inspection.ClientID.String == clientID && m.deviceInOrganisation(inspection.EmergencyDeviceID) {
+			joined := m.joinInspection(inspection)
+			return &joined, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) CountRecentInspections(within time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := now().Time.Add(-within)
+	count := 0
+	for _, inspection := range m.inspections {
+		if !inspection.CreatedAt.Time.Before(since) && m.deviceInOrganisation(inspection.EmergencyDeviceID) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *MemoryStore) AddInspection(inspection *models.Inspection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.deviceInOrganisation(inspection.EmergencyDeviceID)); err != nil {
+		return err
+	}
+	if err := m.requireInOrganisation(m.userInOrganisation(inspection.UserID)); err != nil {
+		return err
+	}
+
+	i, ok := m.findDevice(inspection.EmergencyDeviceID)
+	if !ok {
+		return foreignKeyViolation("emergency_device_inspectiont_emergencydeviceid_fkey")
+	}
+	if _, ok := m.findUser(inspection.UserID); !ok {
+		return foreignKeyViolation("emergency_device_inspectiont_userid_fkey")
+	}
+	if !inspection.InspectionDateTime.Valid {
+		return fmt.Errorf("null value in column %q violates not-null constraint", "inspectiondatetime")
+	}
+	if inspection.ClientID.Valid {
+		for _, existing := range m.inspections {
+			if existing.ClientID == inspection.ClientID {
+				return uniqueViolation("emergency_device_inspectiont_clientid_key")
+			}
+		}
+	}
+
+	// The checklist is always stored as answered, unanswered items are recorded as false
+	answer := func(value sql.NullBool) sql.NullBool { return sql.NullBool{Bool: value.Bool, Valid: true} }
+	inspectionDateTime := instant(inspection.InspectionDateTime.Time)
+	inspectionID := m.nextID("emergency_device_inspection")
+	inspection.EmergencyDeviceInspectionID = inspectionID
+	m.inspections = append(m.inspections, models.Inspection{
+		EmergencyDeviceInspectionID:   inspectionID,
+		EmergencyDeviceID:             inspection.EmergencyDeviceID,
+		UserID:                        inspection.UserID,
+		InspectionDateTime:            sql.NullTime{Time: inspectionDateTime, Valid: true},
+		CreatedAt:                     now(),
+		IsConspicuous:                 answer(inspection.IsConspicuous),
+		IsAccessible:                  answer(inspection.IsAccessible),
+		IsAssignedLocation:            answer(inspection.IsAssignedLocation),
+		IsSignVisible:                 answer(inspection.IsSignVisible),
+		IsAntiTamperDeviceIntact:      answer(inspection.IsAntiTamperDeviceIntact),
+		IsSupportBracketSecure:        answer(inspection.IsSupportBracketSecure),
+		AreOperatingInstructionsClear: answer(inspection.AreOperatingInstructionsClear),
+		IsMaintenanceTagAttached:      answer(inspection.IsMaintenanceTagAttached),
+		IsNoExternalDamage:            answer(inspection.IsNoExternalDamage),
+		IsChargeGaugeNormal:           answer(inspection.IsChargeGaugeNormal),
+		IsReplaced:                    answer(inspection.IsReplaced),
+		AreMaintenanceRecordsComplete: answer(inspection.AreMaintenanceRecordsComplete),
+		WorkOrderRequired:             answer(inspection.WorkOrderRequired),
+		InspectionStatus:              inspection.InspectionStatus,
+		Notes:                         sql.NullString{String: inspection.Notes.String, Valid: true},
+		ClientID:                      inspection.ClientID,
+	})
+
+	// What the update_device_status_on_inspection trigger does in PostgreSQL
+	device := &m.devices[i]
+	if !device.LastInspectionDateTime.Valid || inspectionDateTime.After(device.LastInspectionDateTime.Time) {
+		if !device.DecommissionedAt.Valid {
+			device.LastInspectionDateTime = sql.NullTime{Time: inspectionDateTime, Valid: true}
+			location := siteLocation(m.joinDevice(*device).SiteTimeZone)
+			device.Status = statusAfterInspection(device.Status, device.ManufactureDate, inspection.InspectionStatus, time.Now(), location)
+			device.UpdatedAt = now()
+			device.Version++
+		}
+	}
+
+	// What the record_round_progress trigger does in PostgreSQL
+	m.recordRoundProgress(inspection.EmergencyDeviceID, inspectionID, inspectionDateTime)
+
+	return nil
+}
+
+// joinMaintenanceRecord fills in the device serial number and recorder of a stored maintenance record
+func (m *MemoryStore) joinMaintenanceRecord(record models.MaintenanceRecord) models.MaintenanceRecord {
+	if i, ok := m.findDevice(record.EmergencyDeviceID); ok {
+		record.SerialNumber = m.devices[i].SerialNumber
+	}
+	if user, ok := m.findUser(record.UserID); ok {
+		record.RecordedBy = user.Username
+	}
+	record.Attachments = nil
+	return record
+}
+
+func (m *MemoryStore) GetMaintenanceRecordsByDeviceID(deviceID int) ([]models.MaintenanceRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := []models.MaintenanceRecord{}
+	for _, record := range m.maintenanceRecords {
+		if record.EmergencyDeviceID == deviceID && m.deviceInOrganisation(deviceID) {
+			records = append(records, m.joinMaintenanceRecord(record))
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if !records[i].ServiceDate.Time.Equal(records[j].ServiceDate.Time) {
+			return records[i].ServiceDate.Time.After(records[j].ServiceDate.Time)
+		}
+		return records[i].MaintenanceRecordID > records[j].MaintenanceRecordID
+	})
+
+	return records, nil
+}
+
+func (m *MemoryStore) GetMaintenanceRecordByID(maintenanceRecordID int) (*models.MaintenanceRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.maintenanceRecords {
+		if stored.MaintenanceRecordID == maintenanceRecordID && m.deviceInOrganisation(stored.EmergencyDeviceID) {
+			record := m.joinMaintenanceRecord(stored)
+			record.Attachments = append([]models.MaintenanceAttachment{}, stored.Attachments...)
+			return &record, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) AddMaintenanceRecord(record *models.MaintenanceRecord) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.deviceInOrganisation(record.EmergencyDeviceID)); err != nil {
+		return 0, err
+	}
+	if err := m.requireInOrganisation(m.userInOrganisation(record.UserID)); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.findDevice(record.EmergencyDeviceID); !ok {
+		return 0, foreignKeyViolation("maintenancerecordt_emergencydeviceid_fkey")
+	}
+	if _, ok := m.findUser(record.UserID); !ok {
+		return 0, foreignKeyViolation("maintenancerecordt_userid_fkey")
+	}
+	switch record.ServiceType {
+	case models.ServiceTypeService, models.ServiceTypeRecharge, models.ServiceTypePressureTest, models.ServiceTypeRefurbishment:
+	default:
+		return 0, checkViolation("maintenancerecordt_servicetype_check")
+	}
+	if !record.ServiceDate.Valid {
+		return 0, fmt.Errorf("null value in column %q violates not-null constraint", "servicedate")
+	}
+	if record.Cost.Valid && record.Cost.Float64 < 0 {
+		return 0, checkViolation("maintenancerecordt_cost_check")
+	}
+
+	stored := models.MaintenanceRecord{
+		MaintenanceRecordID: m.nextID("maintenance_record"),
+		EmergencyDeviceID:   record.EmergencyDeviceID,
+		UserID:              record.UserID,
+		ServiceType:         record.ServiceType,
+		ServiceDate:         dateOnly(record.ServiceDate),
+		Provider:            record.Provider,
+		CertificateNumber:   record.CertificateNumber,
+		Cost:                record.Cost,
+		NextServiceDate:     dateOnly(record.NextServiceDate),
+		Notes:               record.Notes,
+		CreatedAt:           now(),
+		Attachments:         []models.MaintenanceAttachment{},
+	}
+	if stored.Cost.Valid {
+		stored.Cost.Float64 = roundNumeric(stored.Cost.Float64, 2)
+	}
+	for _, attachment := range record.Attachments {
+		stored.Attachments = append(stored.Attachments, models.MaintenanceAttachment{
+			MaintenanceAttachmentID: m.nextID("maintenance_attachment"),
+			MaintenanceRecordID:     stored.MaintenanceRecordID,
+			FileName:                attachment.FileName,
+			FilePath:                attachment.FilePath,
+			UploadedAt:              now(),
+		})
+	}
+	m.maintenanceRecords = append(m.maintenanceRecords, stored)
+
+	return stored.MaintenanceRecordID, nil
+}
+
+// joinFloorPlan fills in the floor, building code and site of a stored floor plan
+func (m *MemoryStore) joinFloorPlan(floorPlan models.FloorPlan) models.FloorPlan {
+	if floor, ok := m.findFloor(floorPlan.FloorID); ok {
+		floorPlan.FloorLabel = floor.FloorName
+		floorPlan.FloorLevel = floor.FloorLevel
+	}
+	if building, ok := m.findBuilding(floorPlan.BuildingID); ok {
+		floorPlan.BuildingCode = building.Row.BuildingCode
+		floorPlan.SiteID = building.Row.SiteID
+	}
+	return floorPlan
+}
+
+func (m *MemoryStore) GetFloorPlansByBuildingID(buildingID int) ([]models.FloorPlan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	floorPlans := []models.FloorPlan{}
+	for _, floorPlan := range m.floorPlans {
+		if floorPlan.BuildingID == buildingID && m.buildingInOrganisation(buildingID) {
+			floorPlans = append(floorPlans, m.joinFloorPlan(floorPlan))
+		}
+	}
+	sort.SliceStable(floorPlans, func(i, j int) bool { return floorPlans[i].FloorLevel < floorPlans[j].FloorLevel })
+
+	return floorPlans, nil
+}
+
+func (m *MemoryStore) findFloorPlan(floorPlanID int) (int, bool) {
+	for i, floorPlan := range m.floorPlans {
+		if floorPlan.FloorPlanID == floorPlanID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (m *MemoryStore) GetFloorPlanByID(floorPlanID int) (*models.FloorPlan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findFloorPlan(floorPlanID)
+	if !ok || !m.buildingInOrganisation(m.floorPlans[i].BuildingID) {
+		return nil, sql.ErrNoRows
+	}
+
+	floorPlan := m.joinFloorPlan(m.floorPlans[i])
+	return &floorPlan, nil
+}
+
+func (m *MemoryStore) AddFloorPlan(floorPlan *models.FloorPlan) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The building comes from the floor
+	floor, ok := m.findFloor(floorPlan.FloorID)
+	if err := m.requireInOrganisation(ok && m.buildingInOrganisation(floor.BuildingID)); err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	for _, existing := range m.floorPlans {
+		if existing.FloorID == floorPlan.FloorID {
+			return 0, uniqueViolation("floorplant_floorid_key")
+		}
+	}
+
+	stored := models.FloorPlan{
+		FloorPlanID: m.nextID("floor_plan"),
+		FloorID:     floor.FloorID,
+		BuildingID:  floor.BuildingID,
+		ImagePath:   floorPlan.ImagePath,
+		CreatedAt:   now(),
+	}
+	m.floorPlans = append(m.floorPlans, stored)
+
+	return stored.FloorPlanID, nil
+}
+
+func (m *MemoryStore) DeleteFloorPlan(floorPlanID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findFloorPlan(floorPlanID)
+	if !ok || !m.buildingInOrganisation(m.floorPlans[i].BuildingID) {
+		return sql.ErrNoRows
+	}
+	m.deleteFloorPlan(i)
+
+	return nil
+}
+
+// deleteFloorPlan removes the floor plan at index i with its pins
+func (m *MemoryStore) deleteFloorPlan(i int) {
+	floorPlanID := m.floorPlans[i].FloorPlanID
+	m.floorPlans = append(m.floorPlans[:i], m.floorPlans[i+1:]...)
+
+	var pins []models.FloorPlanPin
+	for _, pin := range m.floorPlanPins {
+		if pin.FloorPlanID != floorPlanID {
+			pins = append(pins, pin)
+		}
+	}
+	m.floorPlanPins = pins
+}
+
+// roomPinStatusRank orders device statuses from most to least urgent for room pins
+func roomPinStatusRank(status sql.NullString) int {
+	switch status.String {
+	case "Inspection Failed":
+		return 0
+	case "Expired":
+		return 1
+	case "Inspection Due":
+		return 2
+	case "Active":
+		return 4
+	default:
+		return 3
+	}
+}
+
+func (m *MemoryStore) GetFloorPlanPins(floorPlanID int) ([]models.FloorPlanPin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pins := []models.FloorPlanPin{}
+	for _, stored := range m.floorPlanPins {
+		if stored.FloorPlanID != floorPlanID || !m.floorPlanInOrganisation(floorPlanID) {
+			continue
+		}
+
+		pin := models.FloorPlanPin{
+			FloorPlanPinID:    stored.FloorPlanPinID,
+			FloorPlanID:       stored.FloorPlanID,
+			RoomID:            stored.RoomID,
+			EmergencyDeviceID: stored.EmergencyDeviceID,
+			X:                 stored.X,
+			Y:                 stored.Y,
+		}
+
+		if stored.EmergencyDeviceID.Valid {
+			i, _ := m.findDevice(int(stored.EmergencyDeviceID.Int64))
+			device := m.joinDevice(m.devices[i])
+			if device.DecommissionedAt.Valid {
+				continue
+			}
+			pin.RoomCode = sql.NullString{String: device.RoomCode, Valid: true}
+			pin.EmergencyDeviceTypeName = sql.NullString{String: device.EmergencyDeviceTypeName, Valid: true}
+			pin.SerialNumber = device.SerialNumber
+			pin.Status = device.Status
+		} else {
+			room, _ := m.findRoom(int(stored.RoomID.Int64))
+			if room.ArchivedAt.Valid {
+				continue
+			}
+			pin.RoomCode = sql.NullString{String: room.Row.RoomCode, Valid: true}
+
+			// The room takes the most urgent status of its active devices
+			found := false
+			for _, device := range m.devices {
+				if device.RoomID != room.Row.RoomID || device.DecommissionedAt.Valid {
+					continue
+				}
+				if !found || roomPinStatusRank(device.Status) < roomPinStatusRank(pin.Status) {
+					pin.Status = device.Status
+					found = true
+				}
+			}
+		}
+
+		pins = append(pins, pin)
+	}
+
+	return pins, nil
+}
+
+func validPinCoordinates(x float64, y float64) error {
+	if x < 0 || x > 1 {
+		return checkViolation("floorplanpint_x_check")
+	}
+	if y < 0 || y > 1 {
+		return checkViolation("floorplanpint_y_check")
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddFloorPlanPin(pin *models.FloorPlanPin) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.floorPlanInOrganisation(pin.FloorPlanID)); err != nil {
+		return 0, err
+	}
+	if pin.RoomID.Valid {
+		if err := m.requireInOrganisation(m.roomInOrganisation(int(pin.RoomID.Int64))); err != nil {
+			return 0, err
+		}
+	}
+	if pin.EmergencyDeviceID.Valid {
+		if err := m.requireInOrganisation(m.deviceInOrganisation(int(pin.EmergencyDeviceID.Int64))); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, ok := m.findFloorPlan(pin.FloorPlanID); !ok {
+		return 0, foreignKeyViolation("floorplanpint_floorplanid_fkey")
+	}
+	if pin.RoomID.Valid == pin.EmergencyDeviceID.Valid {
+		return 0, checkViolation("floorplanpint_check")
+	}
+	if pin.RoomID.Valid {
+		if _, ok := m.findRoom(int(pin.RoomID.Int64)); !ok {
+			return 0, foreignKeyViolation("floorplanpint_roomid_fkey")
+		}
+	}
+	if pin.EmergencyDeviceID.Valid {
+		if _, ok := m.findDevice(int(pin.EmergencyDeviceID.Int64)); !ok {
+			return 0, foreignKeyViolation("floorplanpint_emergencydeviceid_fkey")
+		}
+	}
+	if err := validPinCoordinates(pin.X, pin.Y); err != nil {
+		return 0, err
+	}
+	for _, existing := range m.floorPlanPins {
+		if existing.FloorPlanID != pin.FloorPlanID {
+			continue
+		}
+		if pin.RoomID.Valid && existing.RoomID == pin.RoomID {
+			return 0, uniqueViolation("floorplanpint_floorplanid_roomid_key")
+		}
+		if pin.EmergencyDeviceID.Valid && existing.EmergencyDeviceID == pin.EmergencyDeviceID {
+			return 0, uniqueViolation("floorplanpint_floorplanid_emergencydeviceid_key")
+		}
+	}
+
+	stored := models.FloorPlanPin{
+		FloorPlanPinID:    m.nextID("floor_plan_pin"),
+		FloorPlanID:       pin.FloorPlanID,
+		RoomID:            pin.RoomID,
+		EmergencyDeviceID: pin.EmergencyDeviceID,
+		X:                 roundNumeric(pin.X, 6),
+		Y:                 roundNumeric(pin.Y, 6),
+	}
+	m.floorPlanPins = append(m.floorPlanPins, stored)
+
+	return stored.FloorPlanPinID, nil
+}
+
+func (m *MemoryStore) MoveFloorPlanPin(floorPlanPinID int, x float64, y float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.floorPlanPins {
+		if m.floorPlanPins[i].FloorPlanPinID != floorPlanPinID || !m.floorPlanInOrganisation(m.floorPlanPins[i].FloorPlanID) {
+			continue
+		}
+		if err := validPinCoordinates(x, y); err != nil {
+			return err
+		}
+		m.floorPlanPins[i].X = roundNumeric(x, 6)
+		m.floorPlanPins[i].Y = roundNumeric(y, 6)
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteFloorPlanPin(floorPlanPinID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, pin := range m.floorPlanPins {
+		if pin.FloorPlanPinID == floorPlanPinID && m.floorPlanInOrganisation(pin.FloorPlanID) {
+			m.floorPlanPins = append(m.floorPlanPins[:i], m.floorPlanPins[i+1:]...)
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+// GetSyncChanges uses the time in microseconds as the cursor, changes to the memory store are made one at a
+// time so none can be saved with an earlier time after a sync has read it
+func (m *MemoryStore) GetSyncChanges(since int64) (*models.SyncChanges, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changes := &models.SyncChanges{
+		Cursor:           now().Time.UnixMicro(),
+		Devices:          []models.EmergencyDevice{},
+		Rooms:            []models.Room{},
+		RemovedDeviceIDs: []int{},
+		RemovedRoomIDs:   []int{},
+	}
+	changedSince := func(updatedAt sql.NullTime) bool { return updatedAt.Time.UnixMicro() >= since }
+
+	for _, stored := range m.devices {
+		if !m.roomInOrganisation(stored.RoomID) {
+			continue
+		}
+		// A device is sent again when its room, building or site changes, they are part of the listed device
+		if !changedSince(stored.UpdatedAt) && !changedSince(m.roomLocationUpdatedAt(stored.RoomID)) {
+			continue
+		}
+		if stored.DecommissionedAt.Valid || m.roomArchived(stored.RoomID) {
+			if since != 0 {
+				changes.RemovedDeviceIDs = append(changes.RemovedDeviceIDs, stored.EmergencyDeviceID)
+			}
+			continue
+		}
+		device := m.listedDevice(stored, m.joinDevice(stored))
+		device.RoomID = stored.RoomID
+		device.UpdatedAt = stored.UpdatedAt
+		changes.Devices = append(changes.Devices, device)
+	}
+
+	for _, room := range m.rooms {
+		if !m.buildingInOrganisation(room.Row.BuildingID) || !changedSince(m.roomLocationUpdatedAt(room.Row.RoomID)) {
+			continue
+		}
+		if m.roomArchived(room.Row.RoomID) {
+			if since != 0 {
+				changes.RemovedRoomIDs = append(changes.RemovedRoomIDs, room.Row.RoomID)
+			}
+			continue
+		}
+		joined := m.joinRoom(room.Row)
+		changes.Rooms = append(changes.Rooms, models.Room{
+			RoomID:       joined.RoomID,
+			BuildingID:   joined.BuildingID,
+			RoomCode:     joined.RoomCode,
+			BuildingCode: joined.BuildingCode,
+			SiteID:       joined.SiteID,
+			SiteName:     joined.SiteName,
+			FloorID:      joined.FloorID,
+			FloorName:    joined.FloorName,
+			FloorLevel:   joined.FloorLevel,
+			UpdatedAt:    room.Row.UpdatedAt,
+		})
+	}
+
+	return changes, nil
+}
+
+// recordRoundProgress completes a device in the rounds whose period includes the inspection's day at the device's site
+func (m *MemoryStore) recordRoundProgress(deviceID int, inspectionID int, inspectedAt time.Time) {
+	for i := range m.roundDevices {
+		roundDevice := &m.roundDevices[i]
+		if roundDevice.EmergencyDeviceID != deviceID || roundDevice.Status != models.RoundDeviceRemaining {
+			continue
+		}
+		round, ok := m.findInspectionRound(roundDevice.InspectionRoundID)
+		if !ok {
+			continue
+		}
+		site, _ := m.findSite(round.SiteID)
+		day := dateOnly(sql.NullTime{Time: inspectedAt.In(siteLocation(site.Row.TimeZone)), Valid: true}).Time
+		if day.Before(round.PeriodStart) || day.After(round.PeriodEnd) {
+			continue
+		}
+		roundDevice.Status = models.RoundDeviceInspected
+		roundDevice.EmergencyDeviceInspectionID = sql.NullInt64{Int64: int64(inspectionID), Valid: true}
+	}
+}
+
+func (m *MemoryStore) findInspectionRound(roundID int) (models.InspectionRound, bool) {
+	for _, round := range m.inspectionRounds {
+		if round.InspectionRoundID == roundID {
+			return round, true
+		}
+	}
+	return models.InspectionRound{}, false
+}
+
+// joinInspectionRound fills in the site, building, inspector and progress of a stored round
+func (m *MemoryStore) joinInspectionRound(round models.InspectionRound) models.InspectionRound {
+	if site, ok := m.findSite(round.SiteID); ok {
+		round.SiteName = site.Row.SiteName
+	}
+	if round.BuildingID.Valid {
+		if building, ok := m.findBuilding(int(round.BuildingID.Int64)); ok {
+			round.BuildingCode = sql.NullString{String: building.Row.BuildingCode, Valid: true}
+		}
+	}
+	if round.UserID.Valid {
+		if user, ok := m.findUser(int(round.UserID.Int64)); ok {
+			round.InspectorName = sql.NullString{String: user.Username, Valid: true}
+		}
+	}
+	for _, roundDevice := range m.roundDevices {
+		if roundDevice.InspectionRoundID != round.InspectionRoundID {
+			continue
+		}
+		switch roundDevice.Status {
+		case models.RoundDeviceInspected:
+			round.Inspected++
+		case models.RoundDeviceSkipped:
+			round.Skipped++
+		default:
+			round.Remaining++
+		}
+	}
+	return round
+}
+
+func (m *MemoryStore) GetInspectionRounds(userID int) ([]models.InspectionRound, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rounds := []models.InspectionRound{}
+	for _, round := range m.inspectionRounds {
+		if !m.siteInOrganisation(round.SiteID) || (userID != 0 && (!round.UserID.Valid || int(round.UserID.Int64) != userID)) {
+			continue
+		}
+		rounds = append(rounds, m.joinInspectionRound(round))
+	}
+	sort.SliceStable(rounds, func(i, j int) bool {
+		a, b := rounds[i], rounds[j]
+		if !a.PeriodStart.Equal(b.PeriodStart) {
+			return a.PeriodStart.After(b.PeriodStart)
+		}
+		if a.SiteName != b.SiteName {
+			return a.SiteName < b.SiteName
+		}
+		if a.BuildingCode != b.BuildingCode {
+			return !a.BuildingCode.Valid || (b.BuildingCode.Valid && a.BuildingCode.String < b.BuildingCode.String)
+		}
+		return a.InspectionRoundID < b.InspectionRoundID
+	})
+
+	return rounds, nil
+}
+
+func (m *MemoryStore) GetInspectionRoundByID(roundID int) (*models.InspectionRound, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.findInspectionRound(roundID)
+	if !ok || !m.siteInOrganisation(stored.SiteID) {
+		return nil, sql.ErrNoRows
+	}
+
+	round := m.joinInspectionRound(stored)
+	round.Devices = []models.InspectionRoundDevice{}
+	floorLevels := map[int]int{}
+	for _, roundDevice := range m.roundDevices {
+		if roundDevice.InspectionRoundID != roundID {
+			continue
+		}
+		i, ok := m.findDevice(roundDevice.EmergencyDeviceID)
+		if !ok {
+			continue
+		}
+		joined := m.joinDevice(m.devices[i])
+		device := roundDevice.InspectionRoundDevice
+		device.EmergencyDeviceTypeName = joined.EmergencyDeviceTypeName
+		device.SerialNumber = joined.SerialNumber
+		device.RoomID = joined.RoomID
+		device.RoomCode = joined.RoomCode
+		device.FloorName = joined.FloorName
+		device.BuildingCode = joined.BuildingCode
+		if floor, ok := m.findFloor(joined.FloorID); ok {
+			floorLevels[device.EmergencyDeviceID] = floor.FloorLevel
+		}
+		round.Devices = append(round.Devices, device)
+	}
+	sort.SliceStable(round.Devices, func(i, j int) bool {
+		a, b := round.Devices[i], round.Devices[j]
+		if a.BuildingCode != b.BuildingCode {
+			return a.BuildingCode < b.BuildingCode
+		}
+		if floorLevels[a.EmergencyDeviceID] != floorLevels[b.EmergencyDeviceID] {
+			return floorLevels[a.EmergencyDeviceID] < floorLevels[b.EmergencyDeviceID]
+		}
+		if a.RoomCode != b.RoomCode {
+			return a.RoomCode < b.RoomCode
+		}
+		return a.EmergencyDeviceID < b.EmergencyDeviceID
+	})
+
+	return &round, nil
+}
+
+func (m *MemoryStore) AddInspectionRound(round *models.InspectionRound) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.siteInOrganisation(round.SiteID)); err != nil {
+		return 0, err
+	}
+	if round.BuildingID.Valid {
+		if err := m.requireInOrganisation(m.buildingInOrganisation(int(round.BuildingID.Int64))); err != nil {
+			return 0, err
+		}
+	}
+	if round.UserID.Valid {
+		if err := m.requireInOrganisation(m.userInOrganisation(int(round.UserID.Int64))); err != nil {
+			return 0, err
+		}
+	}
+	for _, deviceID := range round.DeviceIDs {
+		if err := m.requireInOrganisation(m.deviceInOrganisation(deviceID)); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, ok := m.findSite(round.SiteID); !ok {
+		return 0, foreignKeyViolation("inspectionroundt_siteid_fkey")
+	}
+	if round.BuildingID.Valid {
+		if _, ok := m.findBuilding(int(round.BuildingID.Int64)); !ok {
+			return 0, foreignKeyViolation("inspectionroundt_buildingid_fkey")
+		}
+	}
+	if round.UserID.Valid {
+		if _, ok := m.findUser(int(round.UserID.Int64)); !ok {
+			return 0, foreignKeyViolation("inspectionroundt_userid_fkey")
+		}
+	}
+	periodStart := dateOnly(sql.NullTime{Time: round.PeriodStart, Valid: true}).Time
+	periodEnd := dateOnly(sql.NullTime{Time: round.PeriodEnd, Valid: true}).Time
+	if periodEnd.Before(periodStart) {
+		return 0, checkViolation("inspectionroundt_check")
+	}
+	for _, existing := range m.inspectionRounds {
+		if existing.SiteID == round.SiteID && existing.BuildingID == round.BuildingID && existing.PeriodStart.Equal(periodStart) {
+			return 0, uniqueViolation("inspectionroundt_building_period_key")
+		}
+	}
+	seen := map[int]bool{}
+	for _, deviceID := range round.DeviceIDs {
+		if _, ok := m.findDevice(deviceID); !ok {
+			return 0, foreignKeyViolation("inspectionrounddevicet_emergencydeviceid_fkey")
+		}
+		if seen[deviceID] {
+			return 0, uniqueViolation("inspectionrounddevicet_pkey")
+		}
+		seen[deviceID] = true
+	}
+
+	roundID := m.nextID("inspection_round")
+	m.inspectionRounds = append(m.inspectionRounds, models.InspectionRound{
+		InspectionRoundID: roundID,
+		SiteID:            round.SiteID,
+		BuildingID:        round.BuildingID,
+		UserID:            round.UserID,
+		PeriodStart:       periodStart,
+		PeriodEnd:         periodEnd,
+		CreatedAt:         now(),
+	})
+	for _, deviceID := range round.DeviceIDs {
+		m.roundDevices = append(m.roundDevices, memoryRoundDevice{
+			InspectionRoundID: roundID,
+			InspectionRoundDevice: models.InspectionRoundDevice{
+				EmergencyDeviceID: deviceID,
+				Status:            models.RoundDeviceRemaining,
+			},
+		})
+	}
+
+	return roundID, nil
+}
+
+func (m *MemoryStore) AssignInspectionRound(roundID int, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inspector := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+	if inspector.Valid {
+		if err := m.requireInOrganisation(m.userInOrganisation(userID)); err != nil {
+			return err
+		}
+		if _, ok := m.findUser(userID); !ok {
+			return foreignKeyViolation("inspectionroundt_userid_fkey")
+		}
+	}
+
+	for i := range m.inspectionRounds {
+		if m.inspectionRounds[i].InspectionRoundID == roundID && m.siteInOrganisation(m.inspectionRounds[i].SiteID) {
+			m.inspectionRounds[i].UserID = inspector
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) SkipInspectionRoundDevice(roundID int, deviceID int, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.roundDevices {
+		roundDevice := &m.roundDevices[i]
+		if roundDevice.InspectionRoundID == roundID && roundDevice.EmergencyDeviceID == deviceID &&
+			roundDevice.Status == models.RoundDeviceRemaining && m.deviceInOrganisation(deviceID) {
+			roundDevice.Status = models.RoundDeviceSkipped
+			roundDevice.SkipReason = sql.NullString{String: reason, Valid: true}
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) SetCalendarFeed(userID int, organisationID int, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.findUser(userID); !ok {
+		return foreignKeyViolation("calendarfeedt_userid_fkey")
+	}
+	if _, ok := m.findOrganisation(organisationID); !ok {
+		return foreignKeyViolation("calendarfeedt_organisationid_fkey")
+	}
+	for _, feed := range m.calendarFeeds {
+		if feed.TokenHash == tokenHash && (feed.UserID != userID || feed.OrganisationID != organisationID) {
+			return uniqueViolation("calendarfeedt_tokenhash_key")
+		}
+	}
+
+	for i := range m.calendarFeeds {
+		if m.calendarFeeds[i].UserID == userID && m.calendarFeeds[i].OrganisationID == organisationID {
+			m.calendarFeeds[i].TokenHash = tokenHash
+			m.calendarFeeds[i].CreatedAt = now().Time
+			return nil
+		}
+	}
+
+	m.calendarFeeds = append(m.calendarFeeds, models.CalendarFeed{
+		CalendarFeedID: m.nextID("calendar_feed"),
+		UserID:         userID,
+		OrganisationID: organisationID,
+		TokenHash:      tokenHash,
+		CreatedAt:      now().Time,
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) GetCalendarFeed(tokenHash string) (*models.CalendarFeed, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, feed := range m.calendarFeeds {
+		if feed.TokenHash == tokenHash {
+			return &feed, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteCalendarFeed(userID int, organisationID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, feed := range m.calendarFeeds {
+		if feed.UserID == userID && feed.OrganisationID == organisationID {
+			m.calendarFeeds = append(m.calendarFeeds[:i], m.calendarFeeds[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// deviceOrganisationID is the organisation of a device's site
+func (m *MemoryStore) deviceOrganisationID(deviceID int) (int, bool) {
+	i, ok := m.findDevice(deviceID)
+	if !ok {
+		return 0, false
+	}
+	room, ok := m.findRoom(m.devices[i].RoomID)
+	if !ok {
+		return 0, false
+	}
+	building, ok := m.findBuilding(room.Row.BuildingID)
+	if !ok {
+		return 0, false
+	}
+	site, ok := m.findSite(building.Row.SiteID)
+	return site.Row.OrganisationID, ok
+}
+
+func (m *MemoryStore) findWebhook(webhookID int) (int, bool) {
+	for i, webhook := range m.webhooks {
+		if webhook.WebhookID == webhookID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (m *MemoryStore) webhookInOrganisation(webhookID int) bool {
+	i, ok := m.findWebhook(webhookID)
+	return ok && m.inOrganisation(m.webhooks[i].OrganisationID)
+}
+
+// copyWebhook returns a webhook that does not share its event types with the stored one
+func copyWebhook(webhook models.Webhook) models.Webhook {
+	webhook.EventTypes = append([]string{}, webhook.EventTypes...)
+	return webhook
+}
+
+func (m *MemoryStore) GetAllWebhooks() ([]models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhooks := []models.Webhook{}
+	for _, webhook := range m.webhooks {
+		if m.inOrganisation(webhook.OrganisationID) {
+			webhooks = append(webhooks, copyWebhook(webhook))
+		}
+	}
+
+	return webhooks, nil
+}
+
+func (m *MemoryStore) GetWebhookByID(webhookID int) (*models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findWebhook(webhookID)
+	if !ok || !m.inOrganisation(m.webhooks[i].OrganisationID) {
+		return nil, sql.ErrNoRows
+	}
+
+	webhook := copyWebhook(m.webhooks[i])
+	return &webhook, nil
+}
+
+func (m *MemoryStore) AddWebhook(webhook *models.Webhook) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := copyWebhook(*webhook)
+	stored.WebhookID = m.nextID("webhook")
+	stored.OrganisationID = m.writeOrganisationID()
+	stored.CreatedAt = now().Time
+	stored.Version = 1
+	m.webhooks = append(m.webhooks, stored)
+
+	return stored.WebhookID, nil
+}
+
+func (m *MemoryStore) UpdateWebhook(webhook *models.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findWebhook(webhook.WebhookID)
+	if !ok || !m.inOrganisation(m.webhooks[i].OrganisationID) {
+		return sql.ErrNoRows
+	}
+	if err := matchVersion(m.webhooks[i].Version, webhook.Version); err != nil {
+		return err
+	}
+
+	m.webhooks[i].URL = webhook.URL
+	m.webhooks[i].EventTypes = append([]string{}, webhook.EventTypes...)
+	m.webhooks[i].IsActive = webhook.IsActive
+	m.webhooks[i].Version++
+
+	return nil
+}
+
+func (m *MemoryStore) DeleteWebhook(webhookID int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findWebhook(webhookID)
+	if !ok || !m.inOrganisation(m.webhooks[i].OrganisationID) {
+		return sql.ErrNoRows
+	}
+	if err := matchVersion(m.webhooks[i].Version, version); err != nil {
+		return err
+	}
+	m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+
+	// The deliveries and their log go with the webhook
+	deleted := map[int]bool{}
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range m.webhookDeliveries {
+		if delivery.WebhookID == webhookID {
+			deleted[delivery.WebhookDeliveryID] = true
+		} else {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	m.webhookDeliveries = deliveries
+
+	var attempts []models.WebhookAttempt
+	for _, attempt := range m.webhookAttempts {
+		if !deleted[attempt.WebhookDeliveryID] {
+			attempts = append(attempts, attempt)
+		}
+	}
+	m.webhookAttempts = attempts
+
+	return nil
+}
+
+func (m *MemoryStore) AddWebhookEvent(event *models.WebhookEvent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.requireInOrganisation(m.deviceInOrganisation(event.EmergencyDeviceID)); err != nil {
+		return 0, err
+	}
+	organisationID, ok := m.deviceOrganisationID(event.EmergencyDeviceID)
+	if !ok {
+		return 0, nil
+	}
+
+	queued := 0
+	for _, webhook := range m.webhooks {
+		if !webhook.IsActive || webhook.OrganisationID != organisationID || !slices.Contains(webhook.EventTypes, event.EventType) {
+			continue
+		}
+		for _, delivery := range m.webhookDeliveries {
+			if delivery.WebhookID == webhook.WebhookID && delivery.EventID == event.EventID {
+				return 0, uniqueViolation("webhookdeliveryt_webhook_event_key")
+			}
+		}
+
+		m.webhookDeliveries = append(m.webhookDeliveries, models.WebhookDelivery{
+			WebhookDeliveryID: m.nextID("webhook_delivery"),
+			WebhookID:         webhook.WebhookID,
+			EventID:           event.EventID,
+			EventType:         event.EventType,
+			Payload:           append([]byte{}, event.Payload...),
+			Status:            models.DeliveryPending,
+			NextAttemptAt:     now(),
+			CreatedAt:         now().Time,
+		})
+		queued++
+	}
+
+	return queued, nil
+}
+
+func (m *MemoryStore) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []int
+	for i, delivery := range m.webhookDeliveries {
+		webhook, ok := m.findWebhook(delivery.WebhookID)
+		if delivery.Status != models.DeliveryPending || delivery.NextAttemptAt.Time.After(now) ||
+			!ok || !m.webhooks[webhook].IsActive || !m.inOrganisation(m.webhooks[webhook].OrganisationID) {
+			continue
+		}
+		due = append(due, i)
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return m.webhookDeliveries[due[i]].NextAttemptAt.Time.Before(m.webhookDeliveries[due[j]].NextAttemptAt.Time)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	sort.Ints(due)
+
+	deliveries := []models.WebhookDelivery{}
+	for _, i := range due {
+		m.webhookDeliveries[i].NextAttemptAt = sql.NullTime{Time: instant(now.Add(lease)), Valid: true}
+
+		delivery := m.webhookDeliveries[i]
+		webhook, _ := m.findWebhook(delivery.WebhookID)
+		delivery.URL = m.webhooks[webhook].URL
+		delivery.Secret = m.webhooks[webhook].Secret
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func (m *MemoryStore) RecordWebhookAttempt(attempt *models.WebhookAttempt, status string, nextAttemptAt sql.NullTime) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.webhookDeliveries {
+		delivery := &m.webhookDeliveries[i]
+		if delivery.WebhookDeliveryID != attempt.WebhookDeliveryID || !m.webhookInOrganisation(delivery.WebhookID) {
+			continue
+		}
+		if (status == models.DeliveryPending) != nextAttemptAt.Valid {
+			return checkViolation("webhookdeliveryt_check")
+		}
+
+		delivery.Status = status
+		delivery.Attempts++
+		delivery.NextAttemptAt = sql.NullTime{}
+		if nextAttemptAt.Valid {
+			delivery.NextAttemptAt = sql.NullTime{Time: instant(nextAttemptAt.Time), Valid: true}
+		}
+		if status == models.DeliveryDelivered {
+			delivery.DeliveredAt = sql.NullTime{Time: instant(attempt.AttemptedAt), Valid: true}
+		}
+
+		attempt.WebhookAttemptID = m.nextID("webhook_attempt")
+		stored := *attempt
+		stored.AttemptedAt = instant(attempt.AttemptedAt)
+		m.webhookAttempts = append(m.webhookAttempts, stored)
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetWebhookDeliveries(webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := []models.WebhookDelivery{}
+	if !m.webhookInOrganisation(webhookID) {
+		return deliveries, nil
+	}
+
+	// Latest first
+	for i := len(m.webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := m.webhookDeliveries[i]
+		if delivery.WebhookID != webhookID {
+			continue
+		}
+		delivery.Log = []models.WebhookAttempt{}
+		for _, attempt := range m.webhookAttempts {
+			if attempt.WebhookDeliveryID == delivery.WebhookDeliveryID {
+				delivery.Log = append(delivery.Log, attempt)
+			}
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+func (m *MemoryStore) RedeliverWebhookDelivery(deliveryID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.webhookDeliveries {
+		delivery := &m.webhookDeliveries[i]
+		if delivery.WebhookDeliveryID != deliveryID || delivery.Status == models.DeliveryPending || !m.webhookInOrganisation(delivery.WebhookID) {
+			continue
+		}
+
+		delivery.Status = models.DeliveryPending
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = now()
+		return nil
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetInspectionCounts(siteId string, from time.Time, to time.Time) (*models.InspectionCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var siteID int
+	if siteId != "" {
+		var err error
+		siteID, err = parseID(siteId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type typeKey struct {
+		deviceType       string
+		extinguisherType sql.NullString
+	}
+	type inspectorKey struct {
+		userID int
+		month  string
+	}
+	byType := map[typeKey]*models.InspectionTypeCount{}
+	byInspector := map[inspectorKey]*models.InspectorMonthCount{}
+
+	first, last := from.Format("2006-01-02"), to.Format("2006-01-02")
+	for _, inspection := range m.inspections {
+		i, ok := m.findDevice(inspection.EmergencyDeviceID)
+		if !ok || !m.deviceInOrganisation(inspection.EmergencyDeviceID) {
+			continue
+		}
+		device := m.joinDevice(m.devices[i])
+		if siteId != "" && device.SiteID != siteID {
+			continue
+		}
+		inspectedAt := inspection.InspectionDateTime.Time.In(siteLocation(device.SiteTimeZone))
+		if day := inspectedAt.Format("2006-01-02"); day < first || day > last {
+			continue
+		}
+		failed := 0
+		if inspection.InspectionStatus == "Failed" {
+			failed = 1
+		}
+
+		tk := typeKey{device.EmergencyDeviceTypeName, device.ExtinguisherTypeName}
+		if byType[tk] == nil {
+			byType[tk] = &models.InspectionTypeCount{EmergencyDeviceTypeName: tk.deviceType, ExtinguisherTypeName: tk.extinguisherType}
+		}
+		byType[tk].Inspections++
+		byType[tk].Failed += failed
+
+		ik := inspectorKey{inspection.UserID, inspectedAt.Format("2006-01")}
+		if byInspector[ik] == nil {
+			user, _ := m.findUser(inspection.UserID)
+			byInspector[ik] = &models.InspectorMonthCount{UserID: ik.userID, InspectorName: user.Username, Month: ik.month}
+		}
+		byInspector[ik].Inspections++
+		byInspector[ik].Failed += failed
+	}
+
+	counts := &models.InspectionCounts{
+		ByType:      []models.InspectionTypeCount{},
+		ByInspector: []models.InspectorMonthCount{},
+	}
+	for _, count := range byType {
+		counts.ByType = append(counts.ByType, *count)
+	}
+	for _, count := range byInspector {
+		counts.ByInspector = append(counts.ByInspector, *count)
+	}
+
+	// Ordered like the GROUP BY queries, devices that are not extinguishers first
+	sort.Slice(counts.ByType, func(i, j int) bool {
+		a, b := counts.ByType[i], counts.ByType[j]
+		if a.EmergencyDeviceTypeName != b.EmergencyDeviceTypeName {
+			return a.EmergencyDeviceTypeName < b.EmergencyDeviceTypeName
+		}
+		if a.ExtinguisherTypeName.Valid != b.ExtinguisherTypeName.Valid {
+			return !a.ExtinguisherTypeName.Valid
+		}
+		return a.ExtinguisherTypeName.String < b.ExtinguisherTypeName.String
+	})
+	sort.Slice(counts.ByInspector, func(i, j int) bool {
+		a, b := counts.ByInspector[i], counts.ByInspector[j]
+		if a.Month != b.Month {
+			return a.Month < b.Month
+		}
+		if a.InspectorName != b.InspectorName {
+			return a.InspectorName < b.InspectorName
+		}
+		return a.UserID < b.UserID
+	})
+
+	return counts, nil
+}
+
+func (m *MemoryStore) Search(query string, limit int, offset int) (*models.SearchResults, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type field struct {
+		name  string
+		value sql.NullString
+	}
+	var matches []models.SearchResult
+	// match adds the result by its best matching field, like DISTINCT ON in the query
+	match := func(result models.SearchResult, fields ...field) {
+		found := false
+		for _, f := range fields {
+			if !f.value.Valid {
+				continue
+			}
+			rank, ok := searchRank(f.value.String, query)
+			if ok && (!found || rank > result.Rank || (rank == result.Rank && f.name < result.Field)) {
+				result.Field, result.Value, result.Rank = f.name, f.value.String, rank
+				found = true
+			}
+		}
+		if found {
+			matches = append(matches, result)
+		}
+	}
+	text := func(value string) sql.NullString {
+		return sql.NullString{String: value, Valid: true}
+	}
+
+	for _, stored := range m.devices {
+		if stored.DecommissionedAt.Valid || m.roomArchived(stored.RoomID) || !m.roomInOrganisation(stored.RoomID) {
+			continue
+		}
+		device := m.joinDevice(stored)
+		match(models.SearchResult{
+			Kind:              models.SearchDevice,
+			ID:                device.EmergencyDeviceID,
+			EmergencyDeviceID: sql.NullInt64{Int64: int64(device.EmergencyDeviceID), Valid: true},
+			RoomID:            device.RoomID,
+			Title:             strings.TrimSpace(device.EmergencyDeviceTypeName + " " + device.SerialNumber.String),
+			Location:          device.SiteName + ", " + device.BuildingCode + " " + device.RoomCode,
+		},
+			field{"serial_number", device.SerialNumber},
+			field{"description", device.Description},
+			field{"emergency_device_type", text(device.EmergencyDeviceTypeName)},
+			field{"extinguisher_type", device.ExtinguisherTypeName},
+			field{"room_code", text(device.RoomCode)},
+			field{"building_code", text(device.BuildingCode)},
+			field{"site_name", text(device.SiteName)},
+		)
+	}
+
+	for _, room := range m.rooms {
+		if m.roomArchived(room.Row.RoomID) || !m.buildingInOrganisation(room.Row.BuildingID) {
+			continue
+		}
+		joined := m.joinRoom(room.Row)
+		match(models.SearchResult{
+			Kind:     models.SearchRoom,
+			ID:       joined.RoomID,
+			RoomID:   joined.RoomID,
+			Title:    joined.BuildingCode + " " + joined.RoomCode,
+			Location: joined.SiteName + ", " + joined.BuildingCode + " " + joined.RoomCode,
+		},
+			field{"room_code", text(joined.RoomCode)},
+			field{"building_code", text(joined.BuildingCode)},
+			field{"site_name", text(joined.SiteName)},
+		)
+	}
+
+	for _, inspection := range m.inspections {
+		i, ok := m.findDevice(inspection.EmergencyDeviceID)
+		if !ok || m.devices[i].DecommissionedAt.Valid || m.roomArchived(m.devices[i].RoomID) ||
+			!m.deviceInOrganisation(inspection.EmergencyDeviceID) {
+			continue
+		}
+		device := m.joinDevice(m.devices[i])
+		match(models.SearchResult{
+			Kind:              models.SearchInspection,
+			ID:                inspection.EmergencyDeviceInspectionID,
+			EmergencyDeviceID: sql.NullInt64{Int64: int64(device.EmergencyDeviceID), Valid: true},
+			RoomID:            device.RoomID,
+			Title:             strings.TrimSpace("Inspection of " + device.EmergencyDeviceTypeName + " " + device.SerialNumber.String),
+			Location:          device.SiteName + ", " + device.BuildingCode + " " + device.RoomCode,
+		}, field{"notes", inspection.Notes})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.ID < b.ID
+	})
+
+	results := &models.SearchResults{Query: query, Total: len(matches), Limit: limit, Offset: offset, Results: []models.SearchResult{}}
+	if offset < len(matches) {
+		results.Results = append(results.Results, matches[offset:min(offset+limit, len(matches))]...)
+	}
+
+	return results, nil
+}
+
+func (m *MemoryStore) findSavedView(savedViewID int) (int, bool) {
+	for i, view := range m.savedViews {
+		if view.SavedViewID == savedViewID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// savedViewVisible reports whether a stored view is in the store's organisation and owned by or shared with the user
+func (m *MemoryStore) savedViewVisible(view models.SavedView, userID int) bool {
+	return m.inOrganisation(view.OrganisationID) && (view.UserID == userID || slices.Contains(view.SharedWith, userID))
+}
+
+// joinSavedView fills in the owner's name and whether the view is the user's default
+func (m *MemoryStore) joinSavedView(view models.SavedView, userID int) models.SavedView {
+	view.Columns = append([]string{}, view.Columns...)
+	view.SharedWith = append([]int{}, view.SharedWith...)
+	slices.Sort(view.SharedWith)
+	if owner, ok := m.findUser(view.UserID); ok {
+		view.OwnerName = owner.Username
+	}
+	view.IsDefault = slices.Contains(m.defaultSavedViews, memoryDefaultSavedView{
+		UserID:         userID,
+		OrganisationID: view.OrganisationID,
+		SavedViewID:    view.SavedViewID,
+	})
+	return view
+}
+
+// deleteSavedView deletes a stored view, it stops being anyone's default
+func (m *MemoryStore) deleteSavedView(i int) {
+	savedViewID := m.savedViews[i].SavedViewID
+	m.savedViews = append(m.savedViews[:i], m.savedViews[i+1:]...)
+	m.defaultSavedViews = slices.DeleteFunc(m.defaultSavedViews, func(row memoryDefaultSavedView) bool {
+		return row.SavedViewID == savedViewID
+	})
+}
+
+// sharedWith is the distinct users of a view's share list, like SavedViewShareT's primary key keeps them
+func (m *MemoryStore) sharedWith(userIDs []int) ([]int, error) {
+	shared := []int{}
+	for _, userID := range userIDs {
+		if _, ok := m.findUser(userID); !ok {
+			return nil, foreignKeyViolation("savedviewsharet_userid_fkey")
+		}
+		if !slices.Contains(shared, userID) {
+			shared = append(shared, userID)
+		}
+	}
+	return shared, nil
+}
+
+func (m *MemoryStore) GetSavedViews(userID int) ([]models.SavedView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	views := []models.SavedView{}
+	for _, view := range m.savedViews {
+		if m.savedViewVisible(view, userID) {
+			views = append(views, m.joinSavedView(view, userID))
+		}
+	}
+	sort.SliceStable(views, func(i, j int) bool {
+		if views[i].Name != views[j].Name {
+			return views[i].Name < views[j].Name
+		}
+		return views[i].SavedViewID < views[j].SavedViewID
+	})
+
+	return views, nil
+}
+
+func (m *MemoryStore) GetSavedViewByID(savedViewID int, userID int) (*models.SavedView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findSavedView(savedViewID)
+	if !ok || !m.savedViewVisible(m.savedViews[i], userID) {
+		return nil, sql.ErrNoRows
+	}
+
+	view := m.joinSavedView(m.savedViews[i], userID)
+	return &view, nil
+}
+
+func (m *MemoryStore) GetDefaultSavedView(userID int) (*models.SavedView, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found *models.SavedView
+	for _, row := range m.defaultSavedViews {
+		i, ok := m.findSavedView(row.SavedViewID)
+		if row.UserID != userID || !ok || !m.savedViewVisible(m.savedViews[i], userID) {
+			continue
+		}
+		if found == nil || row.OrganisationID < found.OrganisationID {
+			view := m.joinSavedView(m.savedViews[i], userID)
+			found = &view
+		}
+	}
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return found, nil
+}
+
+func (m *MemoryStore) AddSavedView(view *models.SavedView) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.findUser(view.UserID); !ok {
+		return 0, foreignKeyViolation("savedviewt_userid_fkey")
+	}
+	organisationID := m.writeOrganisationID()
+	for _, stored := range m.savedViews {
+		if stored.UserID == view.UserID && stored.OrganisationID == organisationID && stored.Name == view.Name {
+			return 0, uniqueViolation("savedviewt_user_organisation_name_key")
+		}
+	}
+	shared, err := m.sharedWith(view.SharedWith)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := models.SavedView{
+		SavedViewID:    m.nextID("saved_view"),
+		UserID:         view.UserID,
+		OrganisationID: organisationID,
+		Name:           view.Name,
+		Filters:        view.Filters,
+		SortColumn:     view.SortColumn,
+		SortDescending: view.SortDescending,
+		Columns:        append([]string{}, view.Columns...),
+		SharedWith:     shared,
+		CreatedAt:      now().Time,
+		UpdatedAt:      now().Time,
+		Version:        1,
+	}
+	m.savedViews = append(m.savedViews, stored)
+
+	return stored.SavedViewID, nil
+}
+
+func (m *MemoryStore) UpdateSavedView(view *models.SavedView) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findSavedView(view.SavedViewID)
+	if !ok || m.savedViews[i].UserID != view.UserID || !m.inOrganisation(m.savedViews[i].OrganisationID) {
+		return sql.ErrNoRows
+	}
+	if err := matchVersion(m.savedViews[i].Version, view.Version); err != nil {
+		return err
+	}
+	for _, stored := range m.savedViews {
+		if stored.SavedViewID != view.SavedViewID && stored.UserID == view.UserID &&
+			stored.OrganisationID == m.savedViews[i].OrganisationID && stored.Name == view.Name {
+			return uniqueViolation("savedviewt_user_organisation_name_key")
+		}
+	}
+	shared, err := m.sharedWith(view.SharedWith)
+	if err != nil {
+		return err
+	}
+
+	stored := &m.savedViews[i]
+	stored.Name = view.Name
+	stored.Filters = view.Filters
+	stored.SortColumn = view.SortColumn
+	stored.SortDescending = view.SortDescending
+	stored.Columns = append([]string{}, view.Columns...)
+	stored.SharedWith = shared
+	stored.UpdatedAt = now().Time
+	stored.Version++
+
+	m.defaultSavedViews = slices.DeleteFunc(m.defaultSavedViews, func(row memoryDefaultSavedView) bool {
+		return row.SavedViewID == view.SavedViewID && row.UserID != view.UserID && !slices.Contains(shared, row.UserID)
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) DeleteSavedView(savedViewID int, userID int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findSavedView(savedViewID)
+	if !ok || m.savedViews[i].UserID != userID || !m.inOrganisation(m.savedViews[i].OrganisationID) {
+		return sql.ErrNoRows
+	}
+	if err := matchVersion(m.savedViews[i].Version, version); err != nil {
+		return err
+	}
+	m.deleteSavedView(i)
+
+	return nil
+}
+
+func (m *MemoryStore) SetDefaultSavedView(userID int, savedViewID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.findSavedView(savedViewID)
+	if !ok || !m.savedViewVisible(m.savedViews[i], userID) {
+		return sql.ErrNoRows
+	}
+	organisationID := m.savedViews[i].OrganisationID
+
+	for j := range m.defaultSavedViews {
+		if m.defaultSavedViews[j].UserID == userID && m.defaultSavedViews[j].OrganisationID == organisationID {
+			m.defaultSavedViews[j].SavedViewID = savedViewID
+			return nil
+		}
+	}
+	m.defaultSavedViews = append(m.defaultSavedViews, memoryDefaultSavedView{
+		UserID:         userID,
+		OrganisationID: organisationID,
+		SavedViewID:    savedViewID,
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) ClearDefaultSavedView(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.defaultSavedViews = slices.DeleteFunc(m.defaultSavedViews, func(row memoryDefaultSavedView) bool {
+		return row.UserID == userID && m.inOrganisation(row.OrganisationID)
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) GetSiteDependents(siteID int) (*models.Dependents, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if site, ok := m.findSite(siteID); !ok || site.ArchivedAt.Valid || !m.inOrganisation(site.Row.OrganisationID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return m.dependents(func(building models.Building, room models.Room) bool {
+		return building.SiteID == siteID
+	}, true, true), nil
+}
+
+func (m *MemoryStore) GetBuildingDependents(buildingID int) (*models.Dependents, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if building, ok := m.findBuilding(buildingID); !ok || building.ArchivedAt.Valid || !m.siteInOrganisation(building.Row.SiteID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return m.dependents(func(building models.Building, room models.Room) bool {
+		return building.BuildingID == buildingID
+	}, false, true), nil
+}
+
+func (m *MemoryStore) GetRoomDependents(roomID int) (*models.Dependents, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if room, ok := m.findRoom(roomID); !ok || room.ArchivedAt.Valid || !m.buildingInOrganisation(room.Row.BuildingID) {
+		return nil, sql.ErrNoRows
+	}
+
+	return m.dependents(func(building models.Building, room models.Room) bool {
+		return room.RoomID == roomID
+	}, false, false), nil
+}
+
+// dependents lists what is under the buildings and rooms matching match like DB.dependents. Buildings are
+// matched with an empty room.
+func (m *MemoryStore) dependents(match func(building models.Building, room models.Room) bool, buildings bool, rooms bool) *models.Dependents {
+	dependents := &models.Dependents{
+		Buildings: []models.Dependent{},
+		Rooms:     []models.Dependent{},
+		Devices:   []models.Dependent{},
+	}
+
+	// The rooms in buildings that are not archived
+	inService := map[int]bool{}
+	for _, building := range m.buildings {
+		if building.ArchivedAt.Valid {
+			continue
+		}
+		if buildings && match(building.Row, models.Room{}) {
+			dependents.Buildings = append(dependents.Buildings, models.Dependent{ID: building.Row.BuildingID, Name: building.Row.BuildingCode})
+		}
+		for _, room := range m.rooms {
+			if room.Row.BuildingID != building.Row.BuildingID || room.ArchivedAt.Valid || !match(building.Row, room.Row) {
+				continue
+			}
+			inService[room.Row.RoomID] = true
+			if rooms {
+				dependents.Rooms = append(dependents.Rooms, models.Dependent{ID: room.Row.RoomID, Name: room.Row.RoomCode})
+			}
+		}
+	}
+
+	devices := map[int]bool{}
+	for _, device := range m.devices {
+		if inService[device.RoomID] && !device.DecommissionedAt.Valid {
+			devices[device.EmergencyDeviceID] = true
+			dependents.Devices = append(dependents.Devices, models.Dependent{ID: device.EmergencyDeviceID, Name: device.SerialNumber.String})
+		}
+	}
+	for _, inspection := range m.inspections {
+		if devices[inspection.EmergencyDeviceID] {
+			dependents.Inspections++
+		}
+	}
+
+	sort.Slice(dependents.Buildings, func(i, j int) bool {
+		return dependents.Buildings[i].Name < dependents.Buildings[j].Name
+	})
+	sort.SliceStable(dependents.Rooms, func(i, j int) bool {
+		if dependents.Rooms[i].Name != dependents.Rooms[j].Name {
+			return dependents.Rooms[i].Name < dependents.Rooms[j].Name
+		}
+		return dependents.Rooms[i].ID < dependents.Rooms[j].ID
+	})
+	sort.Slice(dependents.Devices, func(i, j int) bool {
+		return dependents.Devices[i].ID < dependents.Devices[j].ID
+	})
+
+	return dependents
+}
+
+func (m *MemoryStore) MoveSiteBuildings(fromSiteID int, toSiteID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if site, ok := m.findSite(toSiteID); !ok || site.ArchivedAt.Valid || !m.inOrganisation(site.Row.OrganisationID) {
+		return sql.ErrNoRows
+	}
+
+	for _, moving := range m.buildings {
+		if moving.Row.SiteID != fromSiteID || moving.ArchivedAt.Valid {
+			continue
+		}
+		for _, there := range m.buildings {
+			if there.Row.SiteID == toSiteID && there.Row.BuildingCode == moving.Row.BuildingCode {
+				return ErrCodeTaken
+			}
+		}
+	}
+
+	if !m.siteInOrganisation(fromSiteID) {
+		return nil
+	}
+	for i := range m.buildings {
+		if m.buildings[i].Row.SiteID == fromSiteID && !m.buildings[i].ArchivedAt.Valid {
+			m.buildings[i].Row.SiteID = toSiteID
+			m.buildings[i].Row.MapX = sql.NullFloat64{}
+			m.buildings[i].Row.MapY = sql.NullFloat64{}
+			m.buildings[i].Row.MapPolygon = sql.NullString{}
+			m.buildings[i].Row.Version++
+			m.buildings[i].UpdatedAt = now()
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) MoveBuildingRooms(fromBuildingID int, toBuildingID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if building, ok := m.findBuilding(toBuildingID); !ok || building.ArchivedAt.Valid || !m.siteInOrganisation(building.Row.SiteID) {
+		return sql.ErrNoRows
+	}
+
+	for _, moving := range m.rooms {
+		if moving.Row.BuildingID != fromBuildingID || moving.ArchivedAt.Valid {
+			continue
+		}
+		for _, there := range m.rooms {
+			if there.Row.BuildingID == toBuildingID && there.Row.RoomCode == moving.Row.RoomCode {
+				return ErrCodeTaken
+			}
+		}
+	}
+
+	if !m.buildingInOrganisation(fromBuildingID) {
+		return nil
+	}
+	// The rooms go on the default floor of their new building, see set_room_floor
+	for i := range m.rooms {
+		if m.rooms[i].Row.BuildingID == fromBuildingID && !m.rooms[i].ArchivedAt.Valid {
+			m.rooms[i].Row.BuildingID = toBuildingID
+			m.rooms[i].Row.FloorID = m.defaultFloor(toBuildingID)
+			m.rooms[i].Row.UpdatedAt = now()
+			m.rooms[i].Row.Version++
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStore) MoveRoomDevices(fromRoomID int, toRoomID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.findRoom(toRoomID)
+	if !ok || room.ArchivedAt.Valid || !m.buildingInOrganisation(room.Row.BuildingID) {
+		return sql.ErrNoRows
+	}
+	if building, _ := m.findBuilding(room.Row.BuildingID); building.ArchivedAt.Valid {
+		return sql.ErrNoRows
+	}
+
+	if !m.roomInOrganisation(fromRoomID) {
+		return nil
+	}
+	for i := range m.devices {
+		if m.devices[i].RoomID == fromRoomID && !m.devices[i].DecommissionedAt.Valid {
+			m.devices[i].RoomID = toRoomID
+			m.devices[i].UpdatedAt = now()
+			m.devices[i].Version++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,453 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The schema migrations are compiled into the binary so a container needs nothing but the executable
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the PostgreSQL advisory lock held while migrating, so two instances
+// starting at the same time do not apply the same migration twice
+const migrationLockID = 20240731032555
+
+// Migration is one versioned schema change read from internal/database/migrations.
+// Files keep the goose layout, <version>_<name>.sql with "-- +goose Up" and "-- +goose Down" sections.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is the state of a migration in the schema_migrations table
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt sql.NullTime
+	// ChecksumMismatch is set when the file was edited after it was applied
+	ChecksumMismatch bool
+	// Unknown is set when the database has a migration this build does not know about
+	Unknown bool
+}
+
+// appliedMigration is a row of the schema_migrations table
+type appliedMigration struct {
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// LoadMigrations reads the embedded migrations in version order
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int64]string)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, err := parseMigration(entry.Name(), content)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[migration.Version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		seen[migration.Version] = entry.Name()
+
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// parseMigration splits a migration file into its up and down SQL.
+// The StatementBegin and StatementEnd annotations are left in place as comments, each section is
+// sent to PostgreSQL as a single multi-statement query so function bodies need no special handling.
+func parseMigration(fileName string, content []byte) (Migration, error) {
+	versionText, name, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), "_")
+	if !ok {
+		return Migration{}, fmt.Errorf("migration %s is not named <version>_<name>.sql", fileName)
+	}
+	version, err := strconv.ParseInt(versionText, 10, 64)
+	if err != nil || version <= 0 {
+		return Migration{}, fmt.Errorf("migration %s does not start with a version number", fileName)
+	}
+
+	var up, down strings.Builder
+	var section *strings.Builder
+	for _, line := range strings.Split(string(content), "\n") {
+		switch strings.TrimSpace(line) {
+		case "-- +goose Up":
+			section = &up
+			continue
+		case "-- +goose Down":
+			section = &down
+			continue
+		}
+		if section != nil {
+			section.WriteString(line)
+			section.WriteString("\n")
+		}
+	}
+
+	if strings.TrimSpace(up.String()) == "" {
+		return Migration{}, fmt.Errorf("migration %s has no -- +goose Up section", fileName)
+	}
+	if strings.TrimSpace(down.String()) == "" {
+		return Migration{}, fmt.Errorf("migration %s has no -- +goose Down section", fileName)
+	}
+
+	sum := sha256.Sum256(content)
+
+	return Migration{
+		Version:  version,
+		Name:     name,
+		Up:       up.String(),
+		Down:     down.String(),
+		Checksum: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// MigrateUp applies every embedded migration that has not been applied yet, oldest first.
+// It refuses to run if an applied migration has been edited since, a changed file has to become a new migration.
+func MigrateUp(db *sql.DB) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			row, ok := applied[migration.Version]
+			if ok && row.Checksum != migration.Checksum {
+				return fmt.Errorf("migration %d_%s has been changed since it was applied", migration.Version, migration.Name)
+			}
+		}
+
+		count := 0
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			slog.InfoContext(ctx, "Applying migration", "version", migration.Version, "name", migration.Name)
+			if err := runMigration(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `
+					INSERT INTO schema_migrations (version, name, checksum)
+					VALUES ($1, $2, $3)`, migration.Version, migration.Name, migration.Checksum)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+
+		if count == 0 {
+			slog.InfoContext(ctx, "Database schema is up to date")
+		} else {
+			slog.InfoContext(ctx, "Applied migrations", "count", count)
+		}
+
+		return nil
+	})
+}
+
+// MigrateDown rolls back the given number of most recently applied migrations
+func MigrateDown(db *sql.DB, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("steps must be at least 1")
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, migration := range migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	return withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		if steps > len(versions) {
+			return fmt.Errorf("only %d migrations have been applied", len(versions))
+		}
+
+		for _, version := range versions[:steps] {
+			migration, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("migration %d_%s is not part of this build and cannot be rolled back", version, applied[version].Name)
+			}
+			if applied[version].Checksum != migration.Checksum {
+				return fmt.Errorf("migration %d_%s has been changed since it was applied", migration.Version, migration.Name)
+			}
+
+			slog.InfoContext(ctx, "Rolling back migration", "version", migration.Version, "name", migration.Name)
+			if err := runMigration(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("rolling back migration %d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetMigrationStatus lists the embedded migrations and any unknown applied ones in version order
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := getAppliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if row, ok := applied[migration.Version]; ok {
+				status.AppliedAt = sql.NullTime{Time: row.AppliedAt, Valid: true}
+				status.ChecksumMismatch = row.Checksum != migration.Checksum
+				delete(applied, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+
+		for version, row := range applied {
+			statuses = append(statuses, MigrationStatus{
+				Version:   version,
+				Name:      row.Name,
+				AppliedAt: sql.NullTime{Time: row.AppliedAt, Valid: true},
+				Unknown:   true,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration advisory lock,
+// after making sure the schema_migrations table exists
+func withMigrationLock(db *sql.DB, fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+// ensureMigrationTable creates the schema_migrations table. A database that was migrated with
+// the goose command line tool has its applied versions copied over from goose_db_version.
+func ensureMigrationTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var empty, hasGoose bool
+	err = conn.QueryRowContext(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM schema_migrations), to_regclass('goose_db_version') IS NOT NULL`).Scan(&empty, &hasGoose)
+	if err != nil {
+		return err
+	}
+	if !empty || !hasGoose {
+		return nil
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		SELECT DISTINCT version_id
+		FROM goose_db_version
+		WHERE version_id > 0 AND is_applied`)
+	if err != nil {
+		return err
+	}
+	var gooseVersions []int64
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		gooseVersions = append(gooseVersions, version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(gooseVersions) == 0 {
+		return nil
+	}
+
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, migration := range migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	for _, version := range gooseVersions {
+		migration, ok := byVersion[version]
+		if !ok {
+			return fmt.Errorf("goose_db_version has migration %d which this build does not know about", version)
+		}
+		_, err := conn.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum)
+			VALUES ($1, $2, $3)`, migration.Version, migration.Name, migration.Checksum)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Adopted goose migration", "version", migration.Version, "name", migration.Name)
+	}
+
+	return nil
+}
+
+// LatestMigrationVersion is the version of the newest migration in this build
+func LatestMigrationVersion() (int64, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// SchemaVersion is the version of the newest migration applied to the database, zero before the first migration.
+// It does not take the migration lock, so it can be called while another instance is migrating.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int64
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// getAppliedMigrations reads the schema_migrations table keyed by version
+func getAppliedMigrations(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var version int64
+		var row appliedMigration
+		if err := rows.Scan(&version, &row.Name, &row.Checksum, &row.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = row
+	}
+
+	return applied, rows.Err()
+}
+
+// runMigration runs the SQL and its bookkeeping in one transaction, so a failed migration leaves no trace
+func runMigration(ctx context.Context, conn *sql.Conn, query string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,147 @@
+package database_test
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectMigrationLock mocks the advisory lock and the schema_migrations setup every migration command starts with,
+// returning the applied migrations from the table
+func expectMigrationLock(mock sqlmock.Sqlmock, applied []database.Migration) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT NOT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"empty", "goose"}).AddRow(len(applied) == 0, false))
+
+	rows := sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"})
+	for _, migration := range applied {
+		rows.AddRow(migration.Version, migration.Name, migration.Checksum, time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery("SELECT version, name, checksum, applied_at FROM schema_migrations").WillReturnRows(rows)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := database.LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	assert.Equal(t, int64(20240731032555), migrations[0].Version)
+	assert.Equal(t, "init_schema", migrations[0].Name)
+
+	for i, migration := range migrations {
+		if i > 0 {
+			assert.Greater(t, migration.Version, migrations[i-1].Version, "migrations are in version order")
+		}
+		assert.NotContains(t, migration.Up, "-- +goose Down", migration.Name)
+		assert.NotContains(t, migration.Down, "CREATE TABLE", migration.Name)
+		assert.Len(t, migration.Checksum, 64, migration.Name)
+	}
+
+	var trigger *database.Migration
+	for i := range migrations {
+		if migrations[i].Name == "inspection_status_trigger" {
+			trigger = &migrations[i]
+		}
+	}
+	require.NotNil(t, trigger, "the inspection trigger is created by a migration")
+	assert.Contains(t, trigger.Up, "$$ LANGUAGE plpgsql;", "function bodies are kept whole")
+}
+
+func TestMigrateUp(t *testing.T) {
+	migrations, err := database.LoadMigrations()
+	require.NoError(t, err)
+	pending := migrations[len(migrations)-2:]
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectMigrationLock(mock, migrations[:len(migrations)-2])
+	for _, migration := range pending {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migration.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").
+			WithArgs(migration.Version, migration.Name, migration.Checksum).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	require.NoError(t, database.MigrateUp(db))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateUpRejectsChangedMigration(t *testing.T) {
+	migrations, err := database.LoadMigrations()
+	require.NoError(t, err)
+
+	applied := append([]database.Migration(nil), migrations...)
+	applied[1].Checksum = strings.Repeat("0", 64)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectMigrationLock(mock, applied)
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = database.MigrateUp(db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), migrations[1].Name)
+	assert.NoError(t, mock.ExpectationsWereMet(), "nothing is applied")
+}
+
+func TestMigrateDown(t *testing.T) {
+	migrations, err := database.LoadMigrations()
+	require.NoError(t, err)
+	last := migrations[len(migrations)-1]
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectMigrationLock(mock, migrations)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(last.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(last.Version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	require.NoError(t, database.MigrateDown(db, 1))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Error(t, database.MigrateDown(db, 0))
+}
+
+func TestSchemaVersion(t *testing.T) {
+	migrations, err := database.LoadMigrations()
+	require.NoError(t, err)
+	latest, err := database.LatestMigrationVersion()
+	require.NoError(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].Version, latest)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(latest))
+
+	version, err := database.SchemaVersion(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), version, "a database that has never been migrated is at version zero")
+
+	version, err = database.SchemaVersion(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,52 @@
+-- +goose Up
+
+-- Devices are decommissioned rather than deleted so their inspection history is kept as compliance evidence
+ALTER TABLE Emergency_DeviceT
+    ADD COLUMN DecommissionedAt TIMESTAMP NULL,
+    ADD COLUMN DecommissionReason VARCHAR(255) NULL;
+
+-- Sites, buildings and rooms are archived rather than deleted
+ALTER TABLE SiteT
+    ADD COLUMN ArchivedAt TIMESTAMP NULL,
+    ADD COLUMN ArchiveReason VARCHAR(255) NULL;
+
+ALTER TABLE BuildingT
+    ADD COLUMN ArchivedAt TIMESTAMP NULL,
+    ADD COLUMN ArchiveReason VARCHAR(255) NULL;
+
+ALTER TABLE RoomT
+    ADD COLUMN ArchivedAt TIMESTAMP NULL,
+    ADD COLUMN ArchiveReason VARCHAR(255) NULL;
+
+-- Inspection history must never be removed as a side effect of deleting a device,
+-- a purge has to export and delete the inspections explicitly
+ALTER TABLE Emergency_Device_InspectionT
+    DROP CONSTRAINT emergency_device_inspectiont_emergencydeviceid_fkey,
+    ADD CONSTRAINT emergency_device_inspectiont_emergencydeviceid_fkey
+        FOREIGN KEY (EmergencyDeviceID) REFERENCES Emergency_DeviceT(EmergencyDeviceID)
+        ON UPDATE CASCADE
+        ON DELETE RESTRICT;
+
+-- +goose Down
+ALTER TABLE Emergency_Device_InspectionT
+    DROP CONSTRAINT emergency_device_inspectiont_emergencydeviceid_fkey,
+    ADD CONSTRAINT emergency_device_inspectiont_emergencydeviceid_fkey
+        FOREIGN KEY (EmergencyDeviceID) REFERENCES Emergency_DeviceT(EmergencyDeviceID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE;
+
+ALTER TABLE RoomT
+    DROP COLUMN IF EXISTS ArchivedAt,
+    DROP COLUMN IF EXISTS ArchiveReason;
+
+ALTER TABLE BuildingT
+    DROP COLUMN IF EXISTS ArchivedAt,
+    DROP COLUMN IF EXISTS ArchiveReason;
+
+ALTER TABLE SiteT
+    DROP COLUMN IF EXISTS ArchivedAt,
+    DROP COLUMN IF EXISTS ArchiveReason;
+
+ALTER TABLE Emergency_DeviceT
+    DROP COLUMN IF EXISTS DecommissionedAt,
+    DROP COLUMN IF EXISTS DecommissionReason;
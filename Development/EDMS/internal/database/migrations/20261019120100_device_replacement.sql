@@ -0,0 +1,14 @@
+-- +goose Up
+
+-- Link a replacement device to the device it replaced, the successor is found through the reverse link
+ALTER TABLE Emergency_DeviceT
+    ADD COLUMN ReplacesDeviceID INT NULL UNIQUE,
+    ADD CONSTRAINT emergency_devicet_replacesdeviceid_fkey
+        FOREIGN KEY (ReplacesDeviceID) REFERENCES Emergency_DeviceT(EmergencyDeviceID)
+        ON UPDATE CASCADE  -- If an EmergencyDeviceID changes, update the link
+        ON DELETE SET NULL; -- If the predecessor is purged, keep the replacement but drop the link
+
+-- +goose Down
+ALTER TABLE Emergency_DeviceT
+    DROP CONSTRAINT IF EXISTS emergency_devicet_replacesdeviceid_fkey,
+    DROP COLUMN IF EXISTS ReplacesDeviceID;
@@ -0,0 +1,54 @@
+-- +goose Up
+
+-- Inspection and service schedules are configured per device type
+ALTER TABLE Emergency_Device_TypeT
+    ADD COLUMN InspectionIntervalMonths INT NOT NULL DEFAULT 3 CHECK (InspectionIntervalMonths > 0),
+    ADD COLUMN ServiceIntervalMonths INT NULL CHECK (ServiceIntervalMonths > 0);
+
+-- Fire extinguishers are serviced by a contractor every year
+UPDATE Emergency_Device_TypeT
+SET ServiceIntervalMonths = 12
+WHERE EmergencyDeviceTypeName = 'Fire Extinguisher';
+
+-- Maintenance Record table to store contractor servicing, separate from the routine inspections
+CREATE TABLE MaintenanceRecordT (
+    MaintenanceRecordID SERIAL PRIMARY KEY,
+    EmergencyDeviceID INT NOT NULL,
+    UserID INT NOT NULL,
+    ServiceType VARCHAR(20) NOT NULL CHECK (ServiceType IN ('Service', 'Recharge', 'Pressure Test', 'Refurbishment')),
+    ServiceDate DATE NOT NULL,
+    Provider VARCHAR(100) NOT NULL,
+    CertificateNumber VARCHAR(100) NULL,
+    Cost NUMERIC(10, 2) NULL CHECK (Cost >= 0),
+    NextServiceDate DATE NULL,
+    Notes VARCHAR(255) NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (EmergencyDeviceID) REFERENCES Emergency_DeviceT(EmergencyDeviceID)
+        ON UPDATE CASCADE  -- If an EmergencyDeviceID changes, update it in MaintenanceRecordT
+        ON DELETE RESTRICT, -- Maintenance history is compliance evidence, a purge removes it explicitly
+    FOREIGN KEY (UserID) REFERENCES UserT(UserID)
+        ON UPDATE CASCADE  -- If a UserID changes, update it in MaintenanceRecordT
+        ON DELETE RESTRICT -- Prevent deletion of a User if they have recorded maintenance
+);
+
+CREATE INDEX idx_maintenancerecordt_device_servicedate ON MaintenanceRecordT (EmergencyDeviceID, ServiceDate DESC);
+
+-- Maintenance Attachment table to store certificates and reports uploaded with a maintenance record
+CREATE TABLE MaintenanceAttachmentT (
+    MaintenanceAttachmentID SERIAL PRIMARY KEY,
+    MaintenanceRecordID INT NOT NULL,
+    FileName VARCHAR(255) NOT NULL,
+    FilePath VARCHAR(255) NOT NULL,
+    UploadedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (MaintenanceRecordID) REFERENCES MaintenanceRecordT(MaintenanceRecordID)
+        ON UPDATE CASCADE  -- If a MaintenanceRecordID changes, update it in MaintenanceAttachmentT
+        ON DELETE CASCADE -- Attachments belong to their maintenance record
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS MaintenanceAttachmentT;
+DROP TABLE IF EXISTS MaintenanceRecordT;
+
+ALTER TABLE Emergency_Device_TypeT
+    DROP COLUMN IF EXISTS InspectionIntervalMonths,
+    DROP COLUMN IF EXISTS ServiceIntervalMonths;
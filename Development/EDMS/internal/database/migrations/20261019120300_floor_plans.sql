@@ -0,0 +1,43 @@
+-- +goose Up
+
+-- Floor Plan table to store a plan image for each floor of a building
+CREATE TABLE FloorPlanT (
+    FloorPlanID SERIAL PRIMARY KEY,
+    BuildingID INT NOT NULL,
+    FloorLabel VARCHAR(50) NOT NULL,
+    FloorLevel INT NOT NULL,
+    ImagePath VARCHAR(255) NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (BuildingID, FloorLevel),
+    FOREIGN KEY (BuildingID) REFERENCES BuildingT(BuildingID)
+        ON UPDATE CASCADE  -- If a BuildingID changes, update it in FloorPlanT
+        ON DELETE CASCADE -- Floor plans belong to their building
+);
+
+-- Floor Plan Pin table to position rooms and devices on a floor plan.
+-- X and Y are fractions of the image width and height measured from the top left corner,
+-- so pins stay in place when a plan image is replaced with one of a different resolution
+CREATE TABLE FloorPlanPinT (
+    FloorPlanPinID SERIAL PRIMARY KEY,
+    FloorPlanID INT NOT NULL,
+    RoomID INT NULL,
+    EmergencyDeviceID INT NULL,
+    X NUMERIC(7, 6) NOT NULL CHECK (X BETWEEN 0 AND 1),
+    Y NUMERIC(7, 6) NOT NULL CHECK (Y BETWEEN 0 AND 1),
+    CHECK ((RoomID IS NULL) <> (EmergencyDeviceID IS NULL)), -- A pin marks either a room or a device
+    UNIQUE (FloorPlanID, RoomID),
+    UNIQUE (FloorPlanID, EmergencyDeviceID),
+    FOREIGN KEY (FloorPlanID) REFERENCES FloorPlanT(FloorPlanID)
+        ON UPDATE CASCADE  -- If a FloorPlanID changes, update it in FloorPlanPinT
+        ON DELETE CASCADE, -- Pins belong to their floor plan
+    FOREIGN KEY (RoomID) REFERENCES RoomT(RoomID)
+        ON UPDATE CASCADE  -- If a RoomID changes, update it in FloorPlanPinT
+        ON DELETE CASCADE, -- Remove the pin if the room is deleted
+    FOREIGN KEY (EmergencyDeviceID) REFERENCES Emergency_DeviceT(EmergencyDeviceID)
+        ON UPDATE CASCADE  -- If an EmergencyDeviceID changes, update it in FloorPlanPinT
+        ON DELETE CASCADE -- Remove the pin if the device is purged
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS FloorPlanPinT;
+DROP TABLE IF EXISTS FloorPlanT;
@@ -0,0 +1,63 @@
+-- +goose Up
+
+-- Buildings are positioned on their site's map image. MapX and MapY are fractions of the image
+-- width and height measured from the top left corner, an optional MapPolygon outlines the building
+-- as a JSON array of [x, y] points in the same units
+ALTER TABLE BuildingT
+    ADD COLUMN MapX NUMERIC(7, 6) NULL CHECK (MapX BETWEEN 0 AND 1),
+    ADD COLUMN MapY NUMERIC(7, 6) NULL CHECK (MapY BETWEEN 0 AND 1),
+    ADD COLUMN MapPolygon JSONB NULL,
+    ADD CONSTRAINT buildingt_map_position_check CHECK ((MapX IS NULL) = (MapY IS NULL));
+
+-- The Taradale site map was hardcoded in the dashboard, store it with the site
+UPDATE SiteT
+SET SiteMapImagePath = '/static/site_maps/EIT_Taradale.svg'
+WHERE SiteName = 'EIT Taradale' AND SiteMapImagePath IS NULL;
+
+-- Move the Taradale building positions out of static/assets/buildings.json
+UPDATE BuildingT b
+SET MapX = coordinates.MapX, MapY = coordinates.MapY
+FROM (VALUES
+    ('O', 0.1359, 0.0293),
+    ('T', 0.2991, 0.0853),
+    ('R', 0.5073, 0.1318),
+    ('E2', 0.8075, 0.1195),
+    ('E', 0.8097, 0.1685),
+    ('E1', 0.8936, 0.2169),
+    ('D', 0.5636, 0.2514),
+    ('P1', 0.3227, 0.1821),
+    ('P', 0.2381, 0.1799),
+    ('N1', 0.2219, 0.2221),
+    ('N2', 0.3209, 0.2351),
+    ('N', 0.2500, 0.2840),
+    ('M', 0.4325, 0.3074),
+    ('F1', 0.7308, 0.2655),
+    ('F', 0.8140, 0.3087),
+    ('C', 0.6254, 0.3446),
+    ('B', 0.5185, 0.3604),
+    ('L', 0.3164, 0.3879),
+    ('L1', 0.2126, 0.3757),
+    ('K1', 0.2252, 0.4207),
+    ('K', 0.3592, 0.4693),
+    ('J', 0.5260, 0.5111),
+    ('G', 0.7208, 0.4792),
+    ('G1', 0.9183, 0.4709),
+    ('G2', 0.7484, 0.3930),
+    ('I', 0.7821, 0.6042),
+    ('I1', 0.6740, 0.6262),
+    ('H', 0.8905, 0.6743),
+    ('Q', 0.7084, 0.8876),
+    ('S', 0.4373, 0.8397),
+    ('A', 0.5793, 0.4143),
+    ('J1', 0.5388, 0.4697)
+) AS coordinates (BuildingCode, MapX, MapY), SiteT s
+WHERE b.SiteID = s.SiteID
+    AND s.SiteName = 'EIT Taradale'
+    AND b.BuildingCode = coordinates.BuildingCode;
+
+-- +goose Down
+ALTER TABLE BuildingT
+    DROP CONSTRAINT IF EXISTS buildingt_map_position_check,
+    DROP COLUMN IF EXISTS MapX,
+    DROP COLUMN IF EXISTS MapY,
+    DROP COLUMN IF EXISTS MapPolygon;
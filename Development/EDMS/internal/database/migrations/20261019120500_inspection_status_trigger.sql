@@ -1,4 +1,8 @@
-CREATE OR REPLACE FUNCTION update_device_status_on_inspection() 
+-- +goose Up
+
+-- The trigger used to be created from Go code after seeding, replace whatever copy an existing database has
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION update_device_status_on_inspection()
 RETURNS TRIGGER AS $$
 DECLARE
     current_last_inspection_timestamp TIMESTAMP;
@@ -8,7 +12,7 @@ BEGIN
     SELECT LastInspectionDateTime, ManufactureDate INTO current_last_inspection_timestamp, calculated_expire_date
     FROM Emergency_DeviceT
     WHERE EmergencyDeviceID = NEW.EmergencyDeviceID;
-    
+
     -- Calculate the expiration date as ManufactureDate + 5 years
     calculated_expire_date := calculated_expire_date + INTERVAL '5 years';
 
@@ -17,21 +21,28 @@ BEGIN
         -- Determine the status based on inspection and expiration conditions
         UPDATE Emergency_DeviceT
         SET LastInspectionDateTime = NEW.InspectionDateTime,
-            Status = CASE 
+            Status = CASE
                         WHEN NEW.InspectionStatus = 'Failed' THEN 'Inspection Failed'
                         WHEN calculated_expire_date <= NOW() THEN 'Expired'
                         WHEN NEW.InspectionStatus = 'Passed' THEN 'Active'
                         ELSE Status
                     END
-        WHERE EmergencyDeviceID = NEW.EmergencyDeviceID;
+        WHERE EmergencyDeviceID = NEW.EmergencyDeviceID
+            AND DecommissionedAt IS NULL;
     END IF;
 
     RETURN NEW;
 END;
 $$ LANGUAGE plpgsql;
+-- +goose StatementEnd
 
 -- Trigger to call the function after insert on Emergency_Device_InspectionT
+DROP TRIGGER IF EXISTS trg_update_device_status ON Emergency_Device_InspectionT;
 CREATE TRIGGER trg_update_device_status
 AFTER INSERT ON Emergency_Device_InspectionT
 FOR EACH ROW
 EXECUTE FUNCTION update_device_status_on_inspection();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS trg_update_device_status ON Emergency_Device_InspectionT;
+DROP FUNCTION IF EXISTS update_device_status_on_inspection;
@@ -0,0 +1,16 @@
+-- +goose Up
+
+-- Seed table to record which seed data has been loaded, so it is not loaded twice
+-- when the application restarts in a fresh container
+CREATE TABLE SeedT (
+    SeedName VARCHAR(50) PRIMARY KEY,
+    SeededAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Seeding used to be tracked with an internal/seed_complete file, a database that already has users was seeded
+INSERT INTO SeedT (SeedName)
+SELECT 'demo'
+WHERE EXISTS (SELECT 1 FROM UserT);
+
+-- +goose Down
+DROP TABLE IF EXISTS SeedT;
@@ -0,0 +1,152 @@
+-- +goose Up
+
+-- Each site has its own time zone, due dates fall on midnight at the site
+ALTER TABLE SiteT
+    ADD COLUMN TimeZone VARCHAR(64) NOT NULL DEFAULT 'Pacific/Auckland';
+
+-- Timestamps are stored as instants in UTC and converted to the site's time zone when read.
+-- Inspection times were entered in New Zealand time and stored without a zone.
+ALTER TABLE Emergency_DeviceT
+    ALTER COLUMN LastInspectionDateTime TYPE TIMESTAMPTZ USING LastInspectionDateTime AT TIME ZONE 'Pacific/Auckland';
+
+ALTER TABLE Emergency_Device_InspectionT
+    ALTER COLUMN InspectionDateTime TYPE TIMESTAMPTZ USING InspectionDateTime AT TIME ZONE 'Pacific/Auckland';
+
+-- The rest were set by NOW() or CURRENT_TIMESTAMP, in the time zone of the database session
+ALTER TABLE Emergency_DeviceT
+    ALTER COLUMN DecommissionedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE Emergency_Device_InspectionT
+    ALTER COLUMN CreatedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE SiteT
+    ALTER COLUMN ArchivedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE BuildingT
+    ALTER COLUMN ArchivedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE RoomT
+    ALTER COLUMN ArchivedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE MaintenanceRecordT
+    ALTER COLUMN CreatedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE MaintenanceAttachmentT
+    ALTER COLUMN UploadedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE FloorPlanT
+    ALTER COLUMN CreatedAt TYPE TIMESTAMPTZ;
+
+ALTER TABLE SeedT
+    ALTER COLUMN SeededAt TYPE TIMESTAMPTZ;
+
+-- Extinguishers expire at midnight in the time zone of their site
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION update_device_status_on_inspection()
+RETURNS TRIGGER AS $$
+DECLARE
+    current_last_inspection_timestamp TIMESTAMPTZ;
+    calculated_expire_date TIMESTAMPTZ;
+BEGIN
+    -- Retrieve the current last inspection timestamp and the expiry at the device's site
+    SELECT ed.LastInspectionDateTime,
+           (ed.ManufactureDate + INTERVAL '5 years') AT TIME ZONE s.TimeZone
+    INTO current_last_inspection_timestamp, calculated_expire_date
+    FROM Emergency_DeviceT ed
+    JOIN RoomT r ON ed.RoomID = r.RoomID
+    JOIN BuildingT b ON r.BuildingID = b.BuildingID
+    JOIN SiteT s ON b.SiteID = s.SiteID
+    WHERE ed.EmergencyDeviceID = NEW.EmergencyDeviceID;
+
+    -- Check if the new inspection timestamp is more recent than the current last inspection timestamp
+    IF current_last_inspection_timestamp IS NULL OR NEW.InspectionDateTime > current_last_inspection_timestamp THEN
+        -- Determine the status based on inspection and expiration conditions
+        UPDATE Emergency_DeviceT
+        SET LastInspectionDateTime = NEW.InspectionDateTime,
+            Status = CASE
+                        WHEN NEW.InspectionStatus = 'Failed' THEN 'Inspection Failed'
+                        WHEN calculated_expire_date <= NOW() THEN 'Expired'
+                        WHEN NEW.InspectionStatus = 'Passed' THEN 'Active'
+                        ELSE Status
+                    END
+        WHERE EmergencyDeviceID = NEW.EmergencyDeviceID
+            AND DecommissionedAt IS NULL;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION update_device_status_on_inspection()
+RETURNS TRIGGER AS $$
+DECLARE
+    current_last_inspection_timestamp TIMESTAMP;
+    calculated_expire_date TIMESTAMP;
+BEGIN
+    -- Retrieve the current last inspection timestamp and manufacture date for the device
+    SELECT LastInspectionDateTime, ManufactureDate INTO current_last_inspection_timestamp, calculated_expire_date
+    FROM Emergency_DeviceT
+    WHERE EmergencyDeviceID = NEW.EmergencyDeviceID;
+
+    -- Calculate the expiration date as ManufactureDate + 5 years
+    calculated_expire_date := calculated_expire_date + INTERVAL '5 years';
+
+    -- Check if the new inspection timestamp is more recent than the current last inspection timestamp
+    IF current_last_inspection_timestamp IS NULL OR NEW.InspectionDateTime > current_last_inspection_timestamp THEN
+        -- Determine the status based on inspection and expiration conditions
+        UPDATE Emergency_DeviceT
+        SET LastInspectionDateTime = NEW.InspectionDateTime,
+            Status = CASE
+                        WHEN NEW.InspectionStatus = 'Failed' THEN 'Inspection Failed'
+                        WHEN calculated_expire_date <= NOW() THEN 'Expired'
+                        WHEN NEW.InspectionStatus = 'Passed' THEN 'Active'
+                        ELSE Status
+                    END
+        WHERE EmergencyDeviceID = NEW.EmergencyDeviceID
+            AND DecommissionedAt IS NULL;
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+ALTER TABLE SeedT
+    ALTER COLUMN SeededAt TYPE TIMESTAMP;
+
+ALTER TABLE FloorPlanT
+    ALTER COLUMN CreatedAt TYPE TIMESTAMP;
+
+ALTER TABLE MaintenanceAttachmentT
+    ALTER COLUMN UploadedAt TYPE TIMESTAMP;
+
+ALTER TABLE MaintenanceRecordT
+    ALTER COLUMN CreatedAt TYPE TIMESTAMP;
+
+ALTER TABLE RoomT
+    ALTER COLUMN ArchivedAt TYPE TIMESTAMP;
+
+ALTER TABLE BuildingT
+    ALTER COLUMN ArchivedAt TYPE TIMESTAMP;
+
+ALTER TABLE SiteT
+    ALTER COLUMN ArchivedAt TYPE TIMESTAMP;
+
+ALTER TABLE Emergency_Device_InspectionT
+    ALTER COLUMN CreatedAt TYPE TIMESTAMP;
+
+ALTER TABLE Emergency_DeviceT
+    ALTER COLUMN DecommissionedAt TYPE TIMESTAMP;
+
+ALTER TABLE Emergency_Device_InspectionT
+    ALTER COLUMN InspectionDateTime TYPE TIMESTAMP USING InspectionDateTime AT TIME ZONE 'Pacific/Auckland';
+
+ALTER TABLE Emergency_DeviceT
+    ALTER COLUMN LastInspectionDateTime TYPE TIMESTAMP USING LastInspectionDateTime AT TIME ZONE 'Pacific/Auckland';
+
+ALTER TABLE SiteT
+    DROP COLUMN TimeZone;
@@ -0,0 +1,91 @@
+-- +goose Up
+
+-- Organisations own sites, and through them every building, room, device and inspection
+CREATE TABLE OrganisationT (
+    OrganisationID SERIAL PRIMARY KEY,
+    Name VARCHAR(100) NOT NULL UNIQUE,
+    CreatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Existing data belongs to the default organisation
+INSERT INTO OrganisationT (OrganisationID, Name) VALUES (1, 'Default');
+SELECT setval(pg_get_serial_sequence('organisationt', 'organisationid'), 1);
+
+-- Users can belong to more than one organisation
+CREATE TABLE UserOrganisationT (
+    UserID INT NOT NULL,
+    OrganisationID INT NOT NULL,
+    PRIMARY KEY (UserID, OrganisationID),
+    FOREIGN KEY (UserID) REFERENCES UserT(UserID)
+        ON DELETE CASCADE,
+    FOREIGN KEY (OrganisationID) REFERENCES OrganisationT(OrganisationID)
+        ON DELETE CASCADE
+);
+
+INSERT INTO UserOrganisationT (UserID, OrganisationID)
+SELECT UserID, 1 FROM UserT;
+
+ALTER TABLE SiteT
+    ADD COLUMN OrganisationID INT NOT NULL DEFAULT 1 REFERENCES OrganisationT(OrganisationID) ON DELETE RESTRICT;
+
+-- Site names only need to be unique within an organisation
+ALTER TABLE SiteT
+    DROP CONSTRAINT sitet_sitename_key;
+
+ALTER TABLE SiteT
+    ADD CONSTRAINT sitet_organisationid_sitename_key UNIQUE (OrganisationID, SiteName);
+
+-- Types without an organisation are global and shared by every organisation
+ALTER TABLE Emergency_Device_TypeT
+    ADD COLUMN OrganisationID INT NULL REFERENCES OrganisationT(OrganisationID) ON DELETE CASCADE;
+
+ALTER TABLE Extinguisher_TypeT
+    ADD COLUMN OrganisationID INT NULL REFERENCES OrganisationT(OrganisationID) ON DELETE CASCADE;
+
+ALTER TABLE Emergency_Device_TypeT
+    DROP CONSTRAINT emergency_device_typet_emergencydevicetypename_key;
+
+ALTER TABLE Extinguisher_TypeT
+    DROP CONSTRAINT extinguisher_typet_extinguishertypename_key;
+
+CREATE UNIQUE INDEX emergency_device_typet_organisation_name_key
+    ON Emergency_Device_TypeT (COALESCE(OrganisationID, 0), EmergencyDeviceTypeName);
+
+CREATE UNIQUE INDEX extinguisher_typet_organisation_name_key
+    ON Extinguisher_TypeT (COALESCE(OrganisationID, 0), ExtinguisherTypeName);
+
+-- +goose Down
+
+DROP INDEX extinguisher_typet_organisation_name_key;
+
+DROP INDEX emergency_device_typet_organisation_name_key;
+
+-- Organisation types are removed so the names can be made unique again
+DELETE FROM Extinguisher_TypeT WHERE OrganisationID IS NOT NULL;
+
+DELETE FROM Emergency_Device_TypeT WHERE OrganisationID IS NOT NULL;
+
+ALTER TABLE Extinguisher_TypeT
+    ADD CONSTRAINT extinguisher_typet_extinguishertypename_key UNIQUE (ExtinguisherTypeName);
+
+ALTER TABLE Emergency_Device_TypeT
+    ADD CONSTRAINT emergency_device_typet_emergencydevicetypename_key UNIQUE (EmergencyDeviceTypeName);
+
+ALTER TABLE Extinguisher_TypeT
+    DROP COLUMN OrganisationID;
+
+ALTER TABLE Emergency_Device_TypeT
+    DROP COLUMN OrganisationID;
+
+ALTER TABLE SiteT
+    DROP CONSTRAINT sitet_organisationid_sitename_key;
+
+ALTER TABLE SiteT
+    ADD CONSTRAINT sitet_sitename_key UNIQUE (SiteName);
+
+ALTER TABLE SiteT
+    DROP COLUMN OrganisationID;
+
+DROP TABLE UserOrganisationT;
+
+DROP TABLE OrganisationT;
@@ -0,0 +1,151 @@
+-- +goose Up
+
+-- Floors sit between buildings and rooms, FloorLevel orders them with 0 the ground floor and basements below it
+CREATE TABLE FloorT (
+    FloorID SERIAL PRIMARY KEY,
+    BuildingID INT NOT NULL,
+    FloorName VARCHAR(50) NOT NULL,
+    FloorLevel INT NOT NULL,
+    UNIQUE (BuildingID, FloorLevel),
+    UNIQUE (FloorID, BuildingID), -- Lets rooms and floor plans check their floor is in their building
+    FOREIGN KEY (BuildingID) REFERENCES BuildingT(BuildingID)
+        ON UPDATE CASCADE  -- If a BuildingID changes, update it in FloorT
+        ON DELETE CASCADE -- Floors belong to their building
+);
+
+-- Every floor that has a plan becomes a floor, and every building gets a ground floor
+INSERT INTO FloorT (BuildingID, FloorName, FloorLevel)
+SELECT BuildingID, FloorLabel, FloorLevel FROM FloorPlanT;
+
+INSERT INTO FloorT (BuildingID, FloorName, FloorLevel)
+SELECT BuildingID, 'Ground', 0 FROM BuildingT
+ON CONFLICT (BuildingID, FloorLevel) DO NOTHING;
+
+-- The default floor of a building is the one nearest the ground, rooms added without a floor go there
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION default_floor(building_id INT)
+RETURNS INT AS $$
+    SELECT FloorID
+    FROM FloorT
+    WHERE BuildingID = building_id
+    ORDER BY ABS(FloorLevel), FloorLevel DESC
+    LIMIT 1;
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION add_ground_floor()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO FloorT (BuildingID, FloorName, FloorLevel) VALUES (NEW.BuildingID, 'Ground', 0);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER trg_add_ground_floor
+AFTER INSERT ON BuildingT
+FOR EACH ROW
+EXECUTE FUNCTION add_ground_floor();
+
+-- Rooms without a floor, and rooms moved to another building without choosing a new floor,
+-- go on the default floor of their building
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_room_floor()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.FloorID IS NULL
+        OR (TG_OP = 'UPDATE' AND NEW.BuildingID <> OLD.BuildingID AND NEW.FloorID = OLD.FloorID) THEN
+        NEW.FloorID := default_floor(NEW.BuildingID);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+ALTER TABLE RoomT
+    ADD COLUMN FloorID INT NULL;
+
+UPDATE RoomT SET FloorID = default_floor(BuildingID);
+
+ALTER TABLE RoomT
+    ALTER COLUMN FloorID SET NOT NULL;
+
+ALTER TABLE RoomT
+    ADD CONSTRAINT roomt_floorid_buildingid_fkey FOREIGN KEY (FloorID, BuildingID) REFERENCES FloorT(FloorID, BuildingID);
+
+CREATE TRIGGER trg_set_room_floor
+BEFORE INSERT OR UPDATE ON RoomT
+FOR EACH ROW
+EXECUTE FUNCTION set_room_floor();
+
+-- Floor plans are the optional plan image of a floor, the label and level now come from the floor
+ALTER TABLE FloorPlanT
+    ADD COLUMN FloorID INT NULL;
+
+UPDATE FloorPlanT fp
+SET FloorID = f.FloorID
+FROM FloorT f
+WHERE f.BuildingID = fp.BuildingID AND f.FloorLevel = fp.FloorLevel;
+
+ALTER TABLE FloorPlanT
+    ALTER COLUMN FloorID SET NOT NULL;
+
+ALTER TABLE FloorPlanT
+    ADD CONSTRAINT floorplant_floorid_key UNIQUE (FloorID);
+
+ALTER TABLE FloorPlanT
+    ADD CONSTRAINT floorplant_floorid_buildingid_fkey FOREIGN KEY (FloorID, BuildingID) REFERENCES FloorT(FloorID, BuildingID)
+        ON DELETE CASCADE; -- The plan goes with its floor
+
+ALTER TABLE FloorPlanT
+    DROP CONSTRAINT floorplant_buildingid_floorlevel_key;
+
+ALTER TABLE FloorPlanT
+    DROP COLUMN FloorLabel,
+    DROP COLUMN FloorLevel;
+
+-- +goose Down
+
+ALTER TABLE FloorPlanT
+    ADD COLUMN FloorLabel VARCHAR(50) NULL,
+    ADD COLUMN FloorLevel INT NULL;
+
+UPDATE FloorPlanT fp
+SET FloorLabel = f.FloorName, FloorLevel = f.FloorLevel
+FROM FloorT f
+WHERE f.FloorID = fp.FloorID;
+
+ALTER TABLE FloorPlanT
+    ALTER COLUMN FloorLabel SET NOT NULL,
+    ALTER COLUMN FloorLevel SET NOT NULL;
+
+ALTER TABLE FloorPlanT
+    ADD CONSTRAINT floorplant_buildingid_floorlevel_key UNIQUE (BuildingID, FloorLevel);
+
+ALTER TABLE FloorPlanT
+    DROP CONSTRAINT floorplant_floorid_buildingid_fkey;
+
+ALTER TABLE FloorPlanT
+    DROP CONSTRAINT floorplant_floorid_key;
+
+ALTER TABLE FloorPlanT
+    DROP COLUMN FloorID;
+
+DROP TRIGGER IF EXISTS trg_set_room_floor ON RoomT;
+
+ALTER TABLE RoomT
+    DROP CONSTRAINT roomt_floorid_buildingid_fkey;
+
+ALTER TABLE RoomT
+    DROP COLUMN FloorID;
+
+DROP TRIGGER IF EXISTS trg_add_ground_floor ON BuildingT;
+
+DROP FUNCTION IF EXISTS set_room_floor;
+
+DROP FUNCTION IF EXISTS add_ground_floor;
+
+DROP FUNCTION IF EXISTS default_floor;
+
+DROP TABLE FloorT;
@@ -0,0 +1,64 @@
+-- +goose Up
+
+-- Inspections captured offline carry a UUID generated on the device, so a batch that is sent again is not recorded twice
+ALTER TABLE Emergency_Device_InspectionT
+    ADD COLUMN ClientID UUID NULL;
+
+ALTER TABLE Emergency_Device_InspectionT
+    ADD CONSTRAINT emergency_device_inspectiont_clientid_key UNIQUE (ClientID);
+
+-- Devices and rooms record when they last changed, so mobile clients only download what changed since their last sync
+ALTER TABLE Emergency_DeviceT
+    ADD COLUMN UpdatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+ALTER TABLE RoomT
+    ADD COLUMN UpdatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX emergency_devicet_updatedat_idx ON Emergency_DeviceT (UpdatedAt);
+
+CREATE INDEX roomt_updatedat_idx ON RoomT (UpdatedAt);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_updated_at()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.UpdatedAt := NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- Every update counts, including the status changes made by the inspection trigger
+CREATE TRIGGER trg_emergency_device_updated_at
+BEFORE UPDATE ON Emergency_DeviceT
+FOR EACH ROW
+EXECUTE FUNCTION set_updated_at();
+
+CREATE TRIGGER trg_room_updated_at
+BEFORE UPDATE ON RoomT
+FOR EACH ROW
+EXECUTE FUNCTION set_updated_at();
+
+-- +goose Down
+
+DROP TRIGGER IF EXISTS trg_room_updated_at ON RoomT;
+
+DROP TRIGGER IF EXISTS trg_emergency_device_updated_at ON Emergency_DeviceT;
+
+DROP FUNCTION IF EXISTS set_updated_at;
+
+DROP INDEX IF EXISTS roomt_updatedat_idx;
+
+DROP INDEX IF EXISTS emergency_devicet_updatedat_idx;
+
+ALTER TABLE RoomT
+    DROP COLUMN UpdatedAt;
+
+ALTER TABLE Emergency_DeviceT
+    DROP COLUMN UpdatedAt;
+
+ALTER TABLE Emergency_Device_InspectionT
+    DROP CONSTRAINT emergency_device_inspectiont_clientid_key;
+
+ALTER TABLE Emergency_Device_InspectionT
+    DROP COLUMN ClientID;
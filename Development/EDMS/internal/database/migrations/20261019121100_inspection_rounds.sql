@@ -0,0 +1,84 @@
+-- +goose Up
+
+-- An inspection round is the devices due in a building, or a whole site, for a period, assigned to an inspector
+CREATE TABLE InspectionRoundT (
+    InspectionRoundID SERIAL PRIMARY KEY,
+    SiteID INT NOT NULL,
+    BuildingID INT NULL, -- NULL for a round of the whole site
+    UserID INT NULL, -- NULL until the round is assigned
+    PeriodStart DATE NOT NULL,
+    PeriodEnd DATE NOT NULL,
+    CreatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (PeriodEnd >= PeriodStart),
+    FOREIGN KEY (SiteID) REFERENCES SiteT(SiteID)
+        ON UPDATE CASCADE
+        ON DELETE RESTRICT,
+    FOREIGN KEY (BuildingID) REFERENCES BuildingT(BuildingID)
+        ON UPDATE CASCADE
+        ON DELETE RESTRICT,
+    FOREIGN KEY (UserID) REFERENCES UserT(UserID)
+        ON UPDATE CASCADE
+        ON DELETE SET NULL -- The round goes back to unassigned
+);
+
+-- A building or site has one round per period, so generating the rounds again adds none twice
+CREATE UNIQUE INDEX inspectionroundt_building_period_key ON InspectionRoundT (SiteID, COALESCE(BuildingID, 0), PeriodStart);
+
+CREATE INDEX idx_inspectionroundt_userid ON InspectionRoundT (UserID);
+
+-- The devices of a round and how far the inspector has got with each
+CREATE TABLE InspectionRoundDeviceT (
+    InspectionRoundID INT NOT NULL,
+    EmergencyDeviceID INT NOT NULL,
+    Status VARCHAR(20) NOT NULL DEFAULT 'Remaining' CHECK (Status IN ('Remaining', 'Inspected', 'Skipped')),
+    SkipReason VARCHAR(255) NULL,
+    EmergencyDeviceInspectionID INT NULL, -- The inspection that completed the device
+    PRIMARY KEY (InspectionRoundID, EmergencyDeviceID),
+    CHECK ((Status = 'Skipped') = (SkipReason IS NOT NULL)),
+    FOREIGN KEY (InspectionRoundID) REFERENCES InspectionRoundT(InspectionRoundID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    FOREIGN KEY (EmergencyDeviceID) REFERENCES Emergency_DeviceT(EmergencyDeviceID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE, -- A purged device leaves its rounds
+    FOREIGN KEY (EmergencyDeviceInspectionID) REFERENCES Emergency_Device_InspectionT(EmergencyDeviceInspectionID)
+        ON UPDATE CASCADE
+        ON DELETE SET NULL
+);
+
+CREATE INDEX idx_inspectionrounddevicet_device ON InspectionRoundDeviceT (EmergencyDeviceID);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION record_round_progress()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- An inspection completes the device in the rounds whose period includes its day at the device's site
+    UPDATE InspectionRoundDeviceT rd
+    SET Status = 'Inspected',
+        EmergencyDeviceInspectionID = NEW.EmergencyDeviceInspectionID
+    FROM InspectionRoundT ir, SiteT s
+    WHERE rd.InspectionRoundID = ir.InspectionRoundID
+        AND ir.SiteID = s.SiteID
+        AND rd.EmergencyDeviceID = NEW.EmergencyDeviceID
+        AND rd.Status = 'Remaining'
+        AND (NEW.InspectionDateTime AT TIME ZONE s.TimeZone)::DATE BETWEEN ir.PeriodStart AND ir.PeriodEnd;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER trg_record_round_progress
+AFTER INSERT ON Emergency_Device_InspectionT
+FOR EACH ROW
+EXECUTE FUNCTION record_round_progress();
+
+-- +goose Down
+
+DROP TRIGGER IF EXISTS trg_record_round_progress ON Emergency_Device_InspectionT;
+
+DROP FUNCTION IF EXISTS record_round_progress;
+
+DROP TABLE IF EXISTS InspectionRoundDeviceT;
+
+DROP TABLE IF EXISTS InspectionRoundT;
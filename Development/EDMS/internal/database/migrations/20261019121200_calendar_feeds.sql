@@ -0,0 +1,23 @@
+-- +goose Up
+
+-- Calendar applications subscribe to a user's feed of due dates with a secret link instead of logging in.
+-- A user has one link per organisation, only the SHA-256 hash of its token is stored.
+CREATE TABLE CalendarFeedT (
+    CalendarFeedID SERIAL PRIMARY KEY,
+    UserID INT NOT NULL,
+    OrganisationID INT NOT NULL,
+    TokenHash CHAR(64) NOT NULL,
+    CreatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT calendarfeedt_tokenhash_key UNIQUE (TokenHash),
+    CONSTRAINT calendarfeedt_userid_organisationid_key UNIQUE (UserID, OrganisationID),
+    FOREIGN KEY (UserID) REFERENCES UserT(UserID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE, -- The link stops working with the account
+    FOREIGN KEY (OrganisationID) REFERENCES OrganisationT(OrganisationID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS CalendarFeedT;
@@ -0,0 +1,59 @@
+-- +goose Up
+
+-- Outbound webhooks, an organisation's admins subscribe a URL to some of the event types.
+-- The secret signs every payload so the receiver can check it came from EDMS.
+CREATE TABLE WebhookT (
+    WebhookID SERIAL PRIMARY KEY,
+    OrganisationID INT NOT NULL,
+    URL VARCHAR(2048) NOT NULL,
+    Secret VARCHAR(64) NOT NULL,
+    EventTypes TEXT[] NOT NULL,
+    IsActive BOOLEAN NOT NULL DEFAULT TRUE,
+    CreatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (OrganisationID) REFERENCES OrganisationT(OrganisationID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+
+-- The outbox, one row per event per subscribed webhook, written when the event happens and sent afterwards.
+-- Failed sends are retried at NextAttemptAt until the delivery is Delivered or given up as Failed.
+CREATE TABLE WebhookDeliveryT (
+    WebhookDeliveryID SERIAL PRIMARY KEY,
+    WebhookID INT NOT NULL,
+    EventID UUID NOT NULL, -- The same for every webhook the event is sent to
+    EventType VARCHAR(50) NOT NULL,
+    Payload TEXT NOT NULL,
+    Status VARCHAR(20) NOT NULL DEFAULT 'Pending' CHECK (Status IN ('Pending', 'Delivered', 'Failed')),
+    Attempts INT NOT NULL DEFAULT 0,
+    NextAttemptAt TIMESTAMPTZ NULL, -- NULL once the delivery is finished
+    DeliveredAt TIMESTAMPTZ NULL,
+    CreatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT webhookdeliveryt_webhook_event_key UNIQUE (WebhookID, EventID),
+    CHECK ((Status = 'Pending') = (NextAttemptAt IS NOT NULL)),
+    FOREIGN KEY (WebhookID) REFERENCES WebhookT(WebhookID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhookdeliveryt_due ON WebhookDeliveryT (NextAttemptAt) WHERE Status = 'Pending';
+
+-- The delivery log, every attempt to send a delivery and how the receiver answered
+CREATE TABLE WebhookAttemptT (
+    WebhookAttemptID SERIAL PRIMARY KEY,
+    WebhookDeliveryID INT NOT NULL,
+    AttemptedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ResponseStatus INT NULL, -- NULL when the receiver could not be reached
+    Error VARCHAR(255) NULL,
+    DurationMs INT NOT NULL,
+    FOREIGN KEY (WebhookDeliveryID) REFERENCES WebhookDeliveryT(WebhookDeliveryID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhookattemptt_delivery ON WebhookAttemptT (WebhookDeliveryID);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS WebhookAttemptT;
+DROP TABLE IF EXISTS WebhookDeliveryT;
+DROP TABLE IF EXISTS WebhookT;
@@ -0,0 +1,40 @@
+-- +goose Up
+
+-- Search matches parts of words and misspellings with trigrams, pg_trgm ships with PostgreSQL
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_emergency_devicet_serialnumber_trgm ON Emergency_DeviceT USING GIN (SerialNumber gin_trgm_ops);
+
+CREATE INDEX idx_emergency_devicet_description_trgm ON Emergency_DeviceT USING GIN (Description gin_trgm_ops);
+
+CREATE INDEX idx_emergency_device_typet_name_trgm ON Emergency_Device_TypeT USING GIN (EmergencyDeviceTypeName gin_trgm_ops);
+
+CREATE INDEX idx_extinguisher_typet_name_trgm ON Extinguisher_TypeT USING GIN (ExtinguisherTypeName gin_trgm_ops);
+
+CREATE INDEX idx_roomt_roomcode_trgm ON RoomT USING GIN (RoomCode gin_trgm_ops);
+
+CREATE INDEX idx_buildingt_buildingcode_trgm ON BuildingT USING GIN (BuildingCode gin_trgm_ops);
+
+CREATE INDEX idx_sitet_sitename_trgm ON SiteT USING GIN (SiteName gin_trgm_ops);
+
+CREATE INDEX idx_emergency_device_inspectiont_notes_trgm ON Emergency_Device_InspectionT USING GIN (Notes gin_trgm_ops);
+
+-- +goose Down
+
+DROP INDEX IF EXISTS idx_emergency_device_inspectiont_notes_trgm;
+
+DROP INDEX IF EXISTS idx_sitet_sitename_trgm;
+
+DROP INDEX IF EXISTS idx_buildingt_buildingcode_trgm;
+
+DROP INDEX IF EXISTS idx_roomt_roomcode_trgm;
+
+DROP INDEX IF EXISTS idx_extinguisher_typet_name_trgm;
+
+DROP INDEX IF EXISTS idx_emergency_device_typet_name_trgm;
+
+DROP INDEX IF EXISTS idx_emergency_devicet_description_trgm;
+
+DROP INDEX IF EXISTS idx_emergency_devicet_serialnumber_trgm;
+
+-- pg_trgm is left installed, dropping it would fail once anything else uses it
@@ -0,0 +1,61 @@
+-- +goose Up
+
+-- Named device list views users save so they do not pick the same filters every time they log in.
+-- Filters is a JSON object of the device list filters, Columns the visible columns in order.
+CREATE TABLE SavedViewT (
+    SavedViewID SERIAL PRIMARY KEY,
+    UserID INT NOT NULL, -- Owner, the only user that can change the view
+    OrganisationID INT NOT NULL,
+    Name VARCHAR(100) NOT NULL,
+    Filters JSONB NOT NULL DEFAULT '{}',
+    SortColumn VARCHAR(50) NOT NULL DEFAULT '',
+    SortDescending BOOLEAN NOT NULL DEFAULT FALSE,
+    Columns TEXT[] NOT NULL,
+    CreatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UpdatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT savedviewt_user_organisation_name_key UNIQUE (UserID, OrganisationID, Name),
+    FOREIGN KEY (UserID) REFERENCES UserT(UserID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    FOREIGN KEY (OrganisationID) REFERENCES OrganisationT(OrganisationID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+
+-- The users a view is shared with, they can use it and make it their default
+CREATE TABLE SavedViewShareT (
+    SavedViewID INT NOT NULL,
+    UserID INT NOT NULL,
+    PRIMARY KEY (SavedViewID, UserID),
+    FOREIGN KEY (SavedViewID) REFERENCES SavedViewT(SavedViewID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    FOREIGN KEY (UserID) REFERENCES UserT(UserID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+
+CREATE INDEX idx_savedviewsharet_userid ON SavedViewShareT (UserID);
+
+-- The view the dashboard opens with, one per user in each organisation
+CREATE TABLE DefaultSavedViewT (
+    UserID INT NOT NULL,
+    OrganisationID INT NOT NULL,
+    SavedViewID INT NOT NULL,
+    PRIMARY KEY (UserID, OrganisationID),
+    FOREIGN KEY (UserID) REFERENCES UserT(UserID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    FOREIGN KEY (OrganisationID) REFERENCES OrganisationT(OrganisationID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    FOREIGN KEY (SavedViewID) REFERENCES SavedViewT(SavedViewID)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE -- Back to no default when the view is deleted
+);
+
+-- +goose Down
+
+DROP TABLE IF EXISTS DefaultSavedViewT;
+DROP TABLE IF EXISTS SavedViewShareT;
+DROP TABLE IF EXISTS SavedViewT;
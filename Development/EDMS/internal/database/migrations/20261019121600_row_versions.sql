@@ -0,0 +1,136 @@
+-- +goose Up
+
+-- Rows that admins edit count their changes, so an edit made from a stale copy is refused instead of
+-- silently overwriting someone else's. The version is the ETag of the row's API representation.
+
+ALTER TABLE UserT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE SiteT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE BuildingT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE FloorT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE RoomT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE Emergency_Device_TypeT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE Emergency_DeviceT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE WebhookT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE SavedViewT
+    ADD COLUMN Version INT NOT NULL DEFAULT 1;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION bump_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.Version := OLD.Version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- Every update counts, including the status changes made by the inspection trigger and foreign keys set to NULL
+CREATE TRIGGER trg_user_version
+BEFORE UPDATE ON UserT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_site_version
+BEFORE UPDATE ON SiteT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_building_version
+BEFORE UPDATE ON BuildingT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_floor_version
+BEFORE UPDATE ON FloorT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_room_version
+BEFORE UPDATE ON RoomT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_emergency_device_type_version
+BEFORE UPDATE ON Emergency_Device_TypeT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_emergency_device_version
+BEFORE UPDATE ON Emergency_DeviceT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_webhook_version
+BEFORE UPDATE ON WebhookT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+CREATE TRIGGER trg_saved_view_version
+BEFORE UPDATE ON SavedViewT
+FOR EACH ROW
+EXECUTE FUNCTION bump_version();
+
+-- +goose Down
+
+DROP TRIGGER IF EXISTS trg_saved_view_version ON SavedViewT;
+
+DROP TRIGGER IF EXISTS trg_webhook_version ON WebhookT;
+
+DROP TRIGGER IF EXISTS trg_emergency_device_version ON Emergency_DeviceT;
+
+DROP TRIGGER IF EXISTS trg_emergency_device_type_version ON Emergency_Device_TypeT;
+
+DROP TRIGGER IF EXISTS trg_room_version ON RoomT;
+
+DROP TRIGGER IF EXISTS trg_floor_version ON FloorT;
+
+DROP TRIGGER IF EXISTS trg_building_version ON BuildingT;
+
+DROP TRIGGER IF EXISTS trg_site_version ON SiteT;
+
+DROP TRIGGER IF EXISTS trg_user_version ON UserT;
+
+DROP FUNCTION IF EXISTS bump_version;
+
+ALTER TABLE SavedViewT
+    DROP COLUMN Version;
+
+ALTER TABLE WebhookT
+    DROP COLUMN Version;
+
+ALTER TABLE Emergency_DeviceT
+    DROP COLUMN Version;
+
+ALTER TABLE Emergency_Device_TypeT
+    DROP COLUMN Version;
+
+ALTER TABLE RoomT
+    DROP COLUMN Version;
+
+ALTER TABLE FloorT
+    DROP COLUMN Version;
+
+ALTER TABLE BuildingT
+    DROP COLUMN Version;
+
+ALTER TABLE SiteT
+    DROP COLUMN Version;
+
+ALTER TABLE UserT
+    DROP COLUMN Version;
@@ -0,0 +1,78 @@
+-- +goose Up
+
+-- Devices, rooms, buildings and sites record the ID of the transaction that last changed them. A sync cursor is the
+-- oldest transaction that was still running when the sync read it, so every change not yet committed then has an
+-- ID at or above the cursor and reaches the next sync, however long its transaction was open.
+ALTER TABLE Emergency_DeviceT
+    ADD COLUMN ChangeXID XID8 NOT NULL DEFAULT pg_current_xact_id();
+
+ALTER TABLE RoomT
+    ADD COLUMN ChangeXID XID8 NOT NULL DEFAULT pg_current_xact_id();
+
+ALTER TABLE BuildingT
+    ADD COLUMN ChangeXID XID8 NOT NULL DEFAULT pg_current_xact_id();
+
+ALTER TABLE SiteT
+    ADD COLUMN ChangeXID XID8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX emergency_devicet_changexid_idx ON Emergency_DeviceT (ChangeXID);
+
+CREATE INDEX roomt_changexid_idx ON RoomT (ChangeXID);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_change_xid()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.ChangeXID := pg_current_xact_id();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER trg_emergency_device_change_xid
+BEFORE UPDATE ON Emergency_DeviceT
+FOR EACH ROW
+EXECUTE FUNCTION set_change_xid();
+
+CREATE TRIGGER trg_room_change_xid
+BEFORE UPDATE ON RoomT
+FOR EACH ROW
+EXECUTE FUNCTION set_change_xid();
+
+CREATE TRIGGER trg_building_change_xid
+BEFORE UPDATE ON BuildingT
+FOR EACH ROW
+EXECUTE FUNCTION set_change_xid();
+
+CREATE TRIGGER trg_site_change_xid
+BEFORE UPDATE ON SiteT
+FOR EACH ROW
+EXECUTE FUNCTION set_change_xid();
+
+-- +goose Down
+
+DROP TRIGGER IF EXISTS trg_site_change_xid ON SiteT;
+
+DROP TRIGGER IF EXISTS trg_building_change_xid ON BuildingT;
+
+DROP TRIGGER IF EXISTS trg_room_change_xid ON RoomT;
+
+DROP TRIGGER IF EXISTS trg_emergency_device_change_xid ON Emergency_DeviceT;
+
+DROP FUNCTION IF EXISTS set_change_xid;
+
+DROP INDEX IF EXISTS roomt_changexid_idx;
+
+DROP INDEX IF EXISTS emergency_devicet_changexid_idx;
+
+ALTER TABLE SiteT
+    DROP COLUMN ChangeXID;
+
+ALTER TABLE BuildingT
+    DROP COLUMN ChangeXID;
+
+ALTER TABLE RoomT
+    DROP COLUMN ChangeXID;
+
+ALTER TABLE Emergency_DeviceT
+    DROP COLUMN ChangeXID;
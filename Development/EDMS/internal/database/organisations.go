@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// Conditions that keep the queries of a scoped store to its organisation.
+// %[1]s is the column being checked and %[2]d the number of the organisation ID argument.
+const (
+	organisationScope = `%[1]s = $%[2]d`
+	sharedScope       = `(%[1]s IS NULL OR %[1]s = $%[2]d)`
+	siteScope         = `%[1]s IN (SELECT SiteID FROM SiteT WHERE OrganisationID = $%[2]d)`
+	buildingScope     = `%[1]s IN (
+		SELECT b.BuildingID FROM BuildingT b
+		JOIN SiteT s ON b.SiteID = s.SiteID
+		WHERE s.OrganisationID = $%[2]d)`
+	floorScope = `%[1]s IN (
+		SELECT f.FloorID FROM FloorT f
+		JOIN BuildingT b ON f.BuildingID = b.BuildingID
+		JOIN SiteT s ON b.SiteID = s.SiteID
+		WHERE s.OrganisationID = $%[2]d)`
+	roomScope = `%[1]s IN (
+		SELECT r.RoomID FROM RoomT r
+		JOIN BuildingT b ON r.BuildingID = b.BuildingID
+		JOIN SiteT s ON b.SiteID = s.SiteID
+		WHERE s.OrganisationID = $%[2]d)`
+	deviceScope = `%[1]s IN (
+		SELECT ed.EmergencyDeviceID FROM Emergency_DeviceT ed
+		JOIN RoomT r ON ed.RoomID = r.RoomID
+		JOIN BuildingT b ON r.BuildingID = b.BuildingID
+		JOIN SiteT s ON b.SiteID = s.SiteID
+		WHERE s.OrganisationID = $%[2]d)`
+	floorPlanScope = `%[1]s IN (
+		SELECT fp.FloorPlanID FROM FloorPlanT fp
+		JOIN BuildingT b ON fp.BuildingID = b.BuildingID
+		JOIN SiteT s ON b.SiteID = s.SiteID
+		WHERE s.OrganisationID = $%[2]d)`
+	webhookScope          = `%[1]s IN (SELECT WebhookID FROM WebhookT WHERE OrganisationID = $%[2]d)`
+	userScope             = `%[1]s IN (SELECT UserID FROM UserOrganisationT WHERE OrganisationID = $%[2]d)`
+	deviceTypeScope       = `%[1]s IN (SELECT EmergencyDeviceTypeID FROM Emergency_Device_TypeT WHERE OrganisationID IS NULL OR OrganisationID = $%[2]d)`
+	extinguisherTypeScope = `%[1]s IN (SELECT ExtinguisherTypeID FROM Extinguisher_TypeT WHERE OrganisationID IS NULL OR OrganisationID = $%[2]d)`
+)
+
+// ForOrganisation returns a store that only reads and writes the data of one organisation.
+// Global device and extinguisher types are shared by every organisation but can only be changed unscoped.
+func (db *DB) ForOrganisation(organisationID int) Store {
+	return &DB{DB: db.DB, organisationID: organisationID, tx: db.tx}
+}
+
+// scope returns the condition limiting column to the store's organisation, starting with AND, and adds its
+// argument to args. Unscoped stores see every organisation and get an empty condition.
+func (db *DB) scope(args *[]interface{}, condition string, column string) string {
+	if db.organisationID == 0 {
+		return ""
+	}
+	*args = append(*args, db.organisationID)
+	return " AND " + fmt.Sprintf(condition, column, len(*args))
+}
+
+// inOrganisation returns sql.ErrNoRows when a scoped store refers to a row that belongs to another organisation,
+// so rows cannot be added to or moved into another organisation's sites
+func (db *DB) inOrganisation(condition string, id interface{}) error {
+	if db.organisationID == 0 {
+		return nil
+	}
+
+	var ok bool
+	query := "SELECT " + fmt.Sprintf(condition, "$1::integer", 2)
+	if err := db.QueryRow(query, id, db.organisationID).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ErrUserInOtherOrganisations is returned when a scoped store changes a user who also belongs to other
+// organisations. Their name, email, role and password are the same in all of them.
+var ErrUserInOtherOrganisations = errors.New("user also belongs to other organisations")
+
+// ownsUser returns ErrUserInOtherOrganisations when a scoped store changes a member of its organisation
+// who belongs to other organisations too, users of no other organisation are the store's own
+func (db *DB) ownsUser(userID int) error {
+	if db.organisationID == 0 {
+		return nil
+	}
+
+	var shared bool
+	err := db.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM UserOrganisationT WHERE UserID = $1 AND OrganisationID = $2)
+		AND EXISTS (SELECT 1 FROM UserOrganisationT WHERE UserID = $1 AND OrganisationID <> $2)
+	`, userID, db.organisationID).Scan(&shared)
+	if err != nil {
+		return err
+	}
+	if shared {
+		return ErrUserInOtherOrganisations
+	}
+
+	return nil
+}
+
+// writeOrganisationID is the organisation new sites and users are added to
+func (db *DB) writeOrganisationID() int {
+	if db.organisationID == 0 {
+		return models.DefaultOrganisationID
+	}
+	return db.organisationID
+}
+
+// GetAllOrganisations returns the organisations by name, a scoped store only sees its own
+func (db *DB) GetAllOrganisations() ([]models.Organisation, error) {
+	var args []interface{}
+	query := `SELECT organisationid, name, createdat FROM OrganisationT WHERE true` +
+		db.scope(&args, organisationScope, "organisationid") +
+		` ORDER BY name`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrganisations(rows)
+}
+
+func (db *DB) GetOrganisationByID(organisationID int) (*models.Organisation, error) {
+	args := []interface{}{organisationID}
+	query := `SELECT organisationid, name, createdat FROM OrganisationT WHERE organisationid = $1` +
+		db.scope(&args, organisationScope, "organisationid")
+
+	var organisation models.Organisation
+	err := db.QueryRow(query, args...).Scan(
+		&organisation.OrganisationID,
+		&organisation.Name,
+		&organisation.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &organisation, nil
+}
+
+// AddOrganisation adds an organisation and returns its ID
+func (db *DB) AddOrganisation(name string) (int, error) {
+	var organisationID int
+	err := db.QueryRow(`INSERT INTO OrganisationT (Name) VALUES ($1) RETURNING OrganisationID`, name).Scan(&organisationID)
+	if err != nil {
+		return 0, err
+	}
+
+	return organisationID, nil
+}
+
+// GetUserOrganisations returns the organisations a user belongs to, the one they joined first first.
+// It is used to sign in, so it is not limited to the store's organisation.
+func (db *DB) GetUserOrganisations(userID int) ([]models.Organisation, error) {
+	query := `
+	SELECT o.organisationid, o.name, o.createdat
+	FROM OrganisationT o
+	JOIN UserOrganisationT uo ON o.organisationid = uo.organisationid
+	WHERE uo.userid = $1
+	ORDER BY o.organisationid
+	`
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrganisations(rows)
+}
+
+func scanOrganisations(rows *sql.Rows) ([]models.Organisation, error) {
+	organisations := []models.Organisation{}
+	for rows.Next() {
+		var organisation models.Organisation
+		err := rows.Scan(
+			&organisation.OrganisationID,
+			&organisation.Name,
+			&organisation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		organisations = append(organisations, organisation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return organisations, nil
+}
+
+// AddUserToOrganisation makes a user a member of an organisation, adding an existing member does nothing.
+// A scoped store can only add members to its own organisation.
+func (db *DB) AddUserToOrganisation(userID int, organisationID int) error {
+	if db.organisationID != 0 && organisationID != db.organisationID {
+		return sql.ErrNoRows
+	}
+
+	_, err := db.Exec(`
+	INSERT INTO UserOrganisationT (UserID, OrganisationID)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`, userID, organisationID)
+	return err
+}
+
+// RemoveUserFromOrganisation ends a user's membership of an organisation, sql.ErrNoRows is returned if they were not a member.
+// A scoped store can only remove members from its own organisation.
+func (db *DB) RemoveUserFromOrganisation(userID int, organisationID int) error {
+	if db.organisationID != 0 && organisationID != db.organisationID {
+		return sql.ErrNoRows
+	}
+
+	result, err := db.Exec(`DELETE FROM UserOrganisationT WHERE UserID = $1 AND OrganisationID = $2`, userID, organisationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -2,15 +2,64 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 )
 
+// ErrDeviceDecommissioned is returned when an operation needs a device that is still in service
+var ErrDeviceDecommissioned = errors.New("device is already decommissioned")
+
+// ErrVersionConflict is returned when a row has changed since the version an update, archive or delete was made from
+var ErrVersionConflict = errors.New("row has been changed since it was read")
+
+// versionCheck appends the condition that the row is still at the version an update was made from,
+// a version of 0 updates the row whatever its version
+func versionCheck(args *[]interface{}, column string, version int) string {
+	if version == 0 {
+		return ""
+	}
+
+	*args = append(*args, version)
+	return fmt.Sprintf(" AND %s = $%d", column, len(*args))
+}
+
+// checkVersion returns ErrVersionConflict when an update made from a version changed no row although
+// the row the exists query looks for is there, a row the store cannot see is left to the caller
+func checkVersion(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, result sql.Result, version int, exists string, args ...interface{}) error {
+	if version == 0 {
+		return nil
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	var found bool
+	if err := q.QueryRow(`SELECT EXISTS (`+exists+`)`, args...).Scan(&found); err != nil {
+		return err
+	}
+	if found {
+		return ErrVersionConflict
+	}
+
+	return nil
+}
+
 // GetAllUsers function
 func (db *DB) GetAllUsers() ([]models.User, error) {
-	query := `SELECT userid, username, email, role, defaultadmin FROM userT`
-	rows, err := db.Query(query)
+	var args []interface{}
+	query := `SELECT userid, username, email, role, defaultadmin, version FROM userT WHERE true` +
+		db.scope(&args, userScope, "userid")
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -26,6 +75,7 @@ func (db *DB) GetAllUsers() ([]models.User, error) {
 			&user.Email,
 			&user.Role,
 			&user.DefaultAdmin,
+			&user.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -37,36 +87,60 @@ func (db *DB) GetAllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-// Create user function
+// Create user function, the user joins the store's organisation
 func (db *DB) CreateUser(user *models.User) error {
-	query := `
-		INSERT INTO userT (username, password, email)
-		VALUES ($1, $2, $3)
-		`
-	insertStmt, err := db.Prepare(query)
+	return db.createUser(user, db.writeOrganisationID())
+}
+
+// RegisterUser adds a user who signed up themselves, they belong to no organisation until an admin adds them
+func (db *DB) RegisterUser(user *models.User) error {
+	return db.createUser(user, 0)
+}
+
+// createUser adds a user as a member of an organisation, or of none when organisationID is 0
+func (db *DB) createUser(user *models.User, organisationID int) error {
+	tx, err := db.begin()
 	if err != nil {
 		return err
 	}
 
-	defer insertStmt.Close()
-
-	_, err = insertStmt.Exec(user.Username, user.Password, user.Email)
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
 
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO userT (username, password, email)
+		VALUES ($1, $2, $3)
+		RETURNING userid
+		`, user.Username, user.Password, user.Email).Scan(&userID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if organisationID != 0 {
+		_, err = tx.Exec(`INSERT INTO UserOrganisationT (UserID, OrganisationID) VALUES ($1, $2)`, userID, organisationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // Update user function
 func (db *DB) UpdateUserWithPassword(user *models.User) error {
+	if err := db.ownsUser(user.UserID); err != nil {
+		return err
+	}
+
 	query := `
         UPDATE userT
         SET username = $1, email = $2, role = $3, password = $4
         WHERE userid = $5
         `
 	args := []interface{}{user.Username, user.Email, user.Role, user.Password, user.UserID}
+	query += db.scope(&args, userScope, "userid")
+	query += versionCheck(&args, "version", user.Version)
 
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
@@ -75,16 +149,22 @@ func (db *DB) UpdateUserWithPassword(user *models.User) error {
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(args...)
+	result, err := updateStmt.Exec(args...)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{user.UserID}
+	exists := `SELECT 1 FROM userT WHERE userid = $1` + db.scope(&existsArgs, userScope, "userid")
+	return checkVersion(db, result, user.Version, exists, existsArgs...)
 }
 
 // Update user function
 func (db *DB) UpdateUser(user *models.User) error {
+	if err := db.ownsUser(user.UserID); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE userT
 		SET username = $1, email = $2, role = $3
@@ -92,6 +172,8 @@ func (db *DB) UpdateUser(user *models.User) error {
 		`
 
 	args := []interface{}{user.Username, user.Email, user.Role, user.UserID}
+	query += db.scope(&args, userScope, "userid")
+	query += versionCheck(&args, "version", user.Version)
 
 	updateStmt, err := db.Prepare(query)
 
@@ -101,31 +183,37 @@ func (db *DB) UpdateUser(user *models.User) error {
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(args...)
+	result, err := updateStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{user.UserID}
+	exists := `SELECT 1 FROM userT WHERE userid = $1` + db.scope(&existsArgs, userScope, "userid")
+	return checkVersion(db, result, user.Version, exists, existsArgs...)
 
 }
 
 // Get user by username function
 func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 	query := `
-		SELECT userid, username, password, email, role, defaultadmin
+		SELECT userid, username, password, email, role, defaultadmin, version
 		FROM userT
 		WHERE username = $1
 		`
+	args := []interface{}{username}
+	query += db.scope(&args, userScope, "userid")
+
 	var user models.User
-	err := db.QueryRow(query, username).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&user.UserID,
 		&user.Username,
 		&user.Password,
 		&user.Email,
 		&user.Role,
 		&user.DefaultAdmin,
+		&user.Version,
 	)
 
 	if err != nil {
@@ -138,19 +226,22 @@ func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 // Get user by ID function
 func (db *DB) GetUserByID(userid int) (*models.User, error) {
 	query := `
-		SELECT userid, username, password, email, role, defaultadmin
+		SELECT userid, username, password, email, role, defaultadmin, version
 		FROM userT
 		WHERE userid = $1
 		`
+	args := []interface{}{userid}
+	query += db.scope(&args, userScope, "userid")
 
 	var user models.User
-	err := db.QueryRow(query, userid).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&user.UserID,
 		&user.Username,
 		&user.Password,
 		&user.Email,
 		&user.Role,
 		&user.DefaultAdmin,
+		&user.Version,
 	)
 
 	if err != nil {
@@ -160,9 +251,15 @@ func (db *DB) GetUserByID(userid int) (*models.User, error) {
 	return &user, nil
 }
 
-// Delete user function
-func (db *DB) DeleteUser(userid int) error {
-	query := `DELETE FROM userT WHERE userid = $1`
+// Delete user function. A scoped store removes the user from its organisation,
+// and only deletes them once they belong to no other organisation.
+func (db *DB) DeleteUser(userid int, version int) error {
+	if db.organisationID != 0 {
+		return db.leaveOrganisation(userid, version)
+	}
+
+	args := []interface{}{userid}
+	query := `DELETE FROM userT WHERE userid = $1` + versionCheck(&args, "version", version)
 	deleteStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -170,22 +267,72 @@ func (db *DB) DeleteUser(userid int) error {
 
 	defer deleteStmt.Close()
 
-	_, err = deleteStmt.Exec(userid)
+	result, err := deleteStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return checkVersion(db, result, version, `SELECT 1 FROM userT WHERE userid = $1`, userid)
+}
+
+// leaveOrganisation removes a user from the store's organisation, deleting the user when it was their last
+func (db *DB) leaveOrganisation(userID int, version int) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	args := []interface{}{userID, db.organisationID}
+	query := `DELETE FROM UserOrganisationT WHERE UserID = $1 AND OrganisationID = $2` +
+		versionCheck(&args, "(SELECT version FROM userT WHERE userid = $1)", version)
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	exists := `SELECT 1 FROM UserOrganisationT WHERE UserID = $1 AND OrganisationID = $2`
+	if err := checkVersion(tx, result, version, exists, userID, db.organisationID); err != nil {
+		return err
+	}
+
+	// Users of other organisations are left alone, like users that do not exist
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM userT
+		WHERE userid = $1 AND NOT EXISTS (SELECT 1 FROM UserOrganisationT WHERE UserID = $1)
+		`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Update password function
 func (db *DB) UpdatePassword(userid int, password string) error {
+	if err := db.ownsUser(userid); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE userT
 		SET password = $1
 		WHERE userid = $2
 		`
+	args := []interface{}{password, userid}
+	query += db.scope(&args, userScope, "userid")
+
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -193,7 +340,7 @@ func (db *DB) UpdatePassword(userid int, password string) error {
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(password, userid)
+	_, err = updateStmt.Exec(args...)
 
 	if err != nil {
 		return err
@@ -209,8 +356,11 @@ func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 		FROM userT
 		WHERE email = $1
 		`
+	args := []interface{}{email}
+	query += db.scope(&args, userScope, "userid")
+
 	var user models.User
-	err := db.QueryRow(query, email).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&user.UserID,
 		&user.Username,
 		&user.Password,
@@ -225,21 +375,34 @@ func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 	return &user, nil
 }
 
-func (db *DB) GetAllDevices(siteId string, buildingCode string) ([]models.EmergencyDevice, error) {
+// GetAllDevices returns the devices in service, decommissioned devices are left out.
+// The site, building code and floor filters are ignored when empty.
+func (db *DB) GetAllDevices(siteId string, buildingCode string, floorId string) ([]models.EmergencyDevice, error) {
+	return db.getDevices(siteId, buildingCode, floorId, false)
+}
+
+// GetDecommissionedDevices returns the devices that have been taken out of service
+func (db *DB) GetDecommissionedDevices(siteId string, buildingCode string, floorId string) ([]models.EmergencyDevice, error) {
+	return db.getDevices(siteId, buildingCode, floorId, true)
+}
+
+func (db *DB) getDevices(siteId string, buildingCode string, floorId string, decommissioned bool) ([]models.EmergencyDevice, error) {
 	var query string
 	var args []interface{}
 
 	// Check if the building exists
 	var buildingExists bool
 	var siteExists bool
+	var floorExists bool
 
 	// Check if the building exists (if building code is provided)
 	if buildingCode != "" {
+		existsArgs := []interface{}{buildingCode}
 		buildingExistsQuery := `
 		SELECT EXISTS (
-			SELECT 1 FROM buildingT b WHERE b.buildingcode = $1
+			SELECT 1 FROM buildingT b WHERE b.buildingcode = $1` + db.scope(&existsArgs, siteScope, "b.siteid") + `
 		)`
-		err := db.QueryRow(buildingExistsQuery, buildingCode).Scan(&buildingExists)
+		err := db.QueryRow(buildingExistsQuery, existsArgs...).Scan(&buildingExists)
 		if err != nil {
 			return nil, err
 		}
@@ -247,55 +410,53 @@ func (db *DB) GetAllDevices(siteId string, buildingCode string) ([]models.Emerge
 
 	// Check if the site exists (if site ID is provided)
 	if siteId != "" {
+		existsArgs := []interface{}{siteId}
 		siteExistsQuery := `
 		SELECT EXISTS (
-			SELECT 1 FROM siteT s WHERE s.siteid = $1
+			SELECT 1 FROM siteT s WHERE s.siteid = $1` + db.scope(&existsArgs, organisationScope, "s.organisationid") + `
+		)`
+		err := db.QueryRow(siteExistsQuery, existsArgs...).Scan(&siteExists)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Check if the floor exists (if floor ID is provided)
+	if floorId != "" {
+		existsArgs := []interface{}{floorId}
+		floorExistsQuery := `
+		SELECT EXISTS (
+			SELECT 1 FROM floorT f WHERE f.floorid = $1` + db.scope(&existsArgs, buildingScope, "f.buildingid") + `
 		)`
-		err := db.QueryRow(siteExistsQuery, siteId).Scan(&siteExists)
+		err := db.QueryRow(floorExistsQuery, existsArgs...).Scan(&floorExists)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	// Define the base query
-	query = `
-	SELECT 
-		ed.emergencydeviceid, 
-		edt.emergencydevicetypename,
-		et.extinguishertypename AS ExtinguisherTypeName,
-		r.roomcode,
-		b.buildingcode,
-		ed.serialnumber,
-		ed.manufacturedate,
-		ed.LastInspectionDateTime AT TIME ZONE 'Pacific/Auckland' AS lastinspectiondatetime_nzdt,
-		ed.description,
-		ed.size,
-		ed.status 
-	FROM emergency_deviceT ed
-	JOIN roomT r ON ed.roomid = r.roomid
-	JOIN buildingT b ON r.buildingid = b.buildingid
-	LEFT JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
-	LEFT JOIN Extinguisher_TypeT et ON ed.extinguishertypeid = et.extinguishertypeid
-	`
+	query = `SELECT ` + listedDeviceColumns + listedDeviceJoins
 
-	// Add filtering by site name and building code if provided
-	if siteId != "" && buildingCode != "" {
-		query += `
-		JOIN siteT s ON b.siteid = s.siteid
-		WHERE s.siteid = $1 AND b.buildingcode = $2
-		`
-		args = append(args, siteId, buildingCode)
-	} else if siteId != "" {
-		query += `
-		JOIN siteT s ON b.siteid = s.siteid
-		WHERE s.siteid = $1
-		`
+	// Only return devices in the requested lifecycle state, active devices in an archived location are not listed
+	if decommissioned {
+		query += ` WHERE ed.decommissionedat IS NOT NULL`
+	} else {
+		query += ` WHERE ed.decommissionedat IS NULL AND r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL`
+	}
+	query += db.scope(&args, organisationScope, "s.organisationid")
+
+	// Add filtering by site and building code if provided
+	if siteId != "" {
 		args = append(args, siteId)
-	} else if buildingCode != "" {
-		query += `
-		WHERE b.buildingcode = $1
-		`
+		query += fmt.Sprintf(` AND s.siteid = $%d`, len(args))
+	}
+	if buildingCode != "" {
 		args = append(args, buildingCode)
+		query += fmt.Sprintf(` AND b.buildingcode = $%d`, len(args))
+	}
+	if floorId != "" {
+		args = append(args, floorId)
+		query += fmt.Sprintf(` AND r.floorid = $%d`, len(args))
 	}
 
 	// Prepare and execute the query
@@ -311,105 +472,132 @@ func (db *DB) GetAllDevices(siteId string, buildingCode string) ([]models.Emerge
 	// Scan the results
 	for rows.Next() {
 		var device models.EmergencyDevice
-		err := rows.Scan(
-			&device.EmergencyDeviceID,
-			&device.EmergencyDeviceTypeName,
-			&device.ExtinguisherTypeName,
-			&device.RoomCode,
-			&device.BuildingCode,
-			&device.SerialNumber,
-			&device.ManufactureDate,
-			&device.LastInspectionDateTime,
-			&device.Description,
-			&device.Size,
-			&device.Status,
-		)
-		if err != nil {
+		if err := scanListedDevice(rows, &device); err != nil {
 			return nil, err
 		}
 
-		// Handle null fields (same as before)
-		if !device.ExtinguisherTypeName.Valid {
-			device.ExtinguisherTypeName.String = "N/A"
-			device.ExtinguisherTypeName.Valid = false
-		}
-		if !device.SerialNumber.Valid {
-			device.SerialNumber.String = "N/A"
-			device.SerialNumber.Valid = false
-		}
-		if !device.Description.Valid {
-			device.Description.String = "N/A"
-			device.Description.Valid = false
-		}
-		if !device.Size.Valid {
-			device.Size.String = "N/A"
-			device.Size.Valid = false
-		}
-		if !device.Status.Valid {
-			device.Status.String = "N/A"
-			device.Status.Valid = false
-		}
-
-		// Handle dates (same as before)
-		if device.ManufactureDate.Valid {
-			expiryDate := device.ManufactureDate.Time.AddDate(5, 0, 0) // Hardcoded 5 years + manuafcture date
-			device.ExpireDate = sql.NullTime{
-				Time:  expiryDate,
-				Valid: true,
-			}
-			// if device type is not "Fire Extinguisher", set expire date to null
-			if device.EmergencyDeviceTypeName != "Fire Extinguisher" {
-				device.ExpireDate = sql.NullTime{
-					Time:  time.Time{},
-					Valid: false,
-				}
-			}
-		} else {
-			device.ManufactureDate = sql.NullTime{
-				Time:  time.Time{},
-				Valid: false,
-			}
-			device.ExpireDate = sql.NullTime{
-				Time:  time.Time{},
-				Valid: false,
-			}
-		}
-
-		// check if manufacture date is null or zero value, if true, set expire date to null
-		if device.ManufactureDate.Time.IsZero() {
-			device.ExpireDate = sql.NullTime{
-				Time:  time.Time{},
-				Valid: false,
-			}
-		}
-
-		if device.LastInspectionDateTime.Valid {
-			nextInspectionDate := device.LastInspectionDateTime.Time.AddDate(0, 3, 0)
-			device.NextInspectionDate = sql.NullTime{
-				Time:  nextInspectionDate,
-				Valid: true,
-			}
-		} else {
-			device.NextInspectionDate = sql.NullTime{
-				Time:  time.Time{},
-				Valid: false,
-			}
-		}
-
 		emergencyDevices = append(emergencyDevices, device)
 	}
 
 	// Return empty slice if:
 	// 1. Building exists but no devices found
 	// 2. Site exists but no devices found
+	// 3. Floor exists but no devices found
 	if (buildingExists && len(emergencyDevices) == 0) ||
-		(siteExists && len(emergencyDevices) == 0) {
+		(siteExists && len(emergencyDevices) == 0) ||
+		(floorExists && len(emergencyDevices) == 0) {
 		return []models.EmergencyDevice{}, nil
 	}
 
 	return emergencyDevices, nil
 }
 
+// The columns and joins of a device list, read with scanListedDevice
+const (
+	listedDeviceColumns = `
+		ed.emergencydeviceid, 
+		edt.emergencydevicetypename,
+		et.extinguishertypename AS ExtinguisherTypeName,
+		r.roomcode,
+		r.floorid,
+		f.floorname,
+		b.buildingcode,
+		s.siteid,
+		s.sitename,
+		s.timezone,
+		ed.serialnumber,
+		ed.manufacturedate,
+		ed.lastinspectiondatetime,
+		ed.description,
+		ed.size,
+		ed.status,
+		ed.decommissionedat,
+		ed.decommissionreason,
+		edt.inspectionintervalmonths,
+		edt.serviceintervalmonths,
+		mr.servicedate,
+		mr.nextservicedate,
+		ed.version`
+	listedDeviceJoins = `
+	FROM emergency_deviceT ed
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN floorT f ON r.floorid = f.floorid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	LEFT JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
+	LEFT JOIN Extinguisher_TypeT et ON ed.extinguishertypeid = et.extinguishertypeid
+	LEFT JOIN LATERAL (
+		SELECT m.servicedate, m.nextservicedate
+		FROM MaintenanceRecordT m
+		WHERE m.emergencydeviceid = ed.emergencydeviceid
+		ORDER BY m.servicedate DESC, m.maintenancerecordid DESC
+		LIMIT 1
+	) mr ON true
+	`
+)
+
+// scanListedDevice scans a row of listedDeviceColumns followed by the extra columns and fills in the calculated fields
+func scanListedDevice(rows *sql.Rows, device *models.EmergencyDevice, extra ...interface{}) error {
+	var schedule deviceSchedule
+	dest := []interface{}{
+		&device.EmergencyDeviceID,
+		&device.EmergencyDeviceTypeName,
+		&device.ExtinguisherTypeName,
+		&device.RoomCode,
+		&device.FloorID,
+		&device.FloorName,
+		&device.BuildingCode,
+		&device.SiteID,
+		&device.SiteName,
+		&device.SiteTimeZone,
+		&device.SerialNumber,
+		&device.ManufactureDate,
+		&device.LastInspectionDateTime,
+		&device.Description,
+		&device.Size,
+		&device.Status,
+		&device.DecommissionedAt,
+		&device.DecommissionReason,
+		&schedule.InspectionIntervalMonths,
+		&schedule.ServiceIntervalMonths,
+		&device.LastServiceDate,
+		&schedule.RecordedNextServiceDate,
+		&device.Version,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+
+	// Timestamps are shown in the site's local time
+	localiseDevice(device)
+
+	// Missing details are shown as N/A on the dashboard
+	setNotApplicable(device)
+
+	// Extinguishers expire a fixed number of years after manufacture
+	applyExpireDate(device)
+
+	// Next inspection, service and due dates from the device type's schedules
+	applyDueDates(device, schedule)
+
+	return nil
+}
+
+// setNotApplicable fills the missing text fields of a listed device with N/A, they stay invalid
+func setNotApplicable(device *models.EmergencyDevice) {
+	for _, field := range []*sql.NullString{
+		&device.ExtinguisherTypeName,
+		&device.SerialNumber,
+		&device.Description,
+		&device.Size,
+		&device.Status,
+	} {
+		if !field.Valid {
+			*field = sql.NullString{String: "N/A", Valid: false}
+		}
+	}
+}
+
 // GetDeviceByID function
 func (db *DB) GetDeviceByID(deviceID int) (*models.EmergencyDevice, error) {
 	query := `
@@ -425,22 +613,32 @@ func (db *DB) GetDeviceByID(deviceID int) (*models.EmergencyDevice, error) {
 		b.buildingcode,
 		s.siteid,
 		s.sitename,
+		s.timezone,
 		ed.serialnumber,
 		ed.manufacturedate,
-		ed.LastInspectionDateTime AT TIME ZONE 'Pacific/Auckland' AS lastinspectiondatetime_nzdt,
+		ed.lastinspectiondatetime,
 		ed.description,
 		ed.size,
-		ed.status
+		ed.status,
+		ed.decommissionedat,
+		ed.decommissionreason,
+		ed.replacesdeviceid,
+		successor.emergencydeviceid,
+		ed.version
 	FROM emergency_deviceT ed
 	JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
 	LEFT JOIN Extinguisher_TypeT et ON ed.extinguishertypeid = et.extinguishertypeid
 	JOIN roomT r ON ed.roomid = r.roomid
 	JOIN buildingT b ON r.buildingid = b.buildingid
 	JOIN siteT s ON b.siteid = s.siteid
+	LEFT JOIN emergency_deviceT successor ON successor.replacesdeviceid = ed.emergencydeviceid
 	WHERE ed.emergencydeviceid = $1
 	`
+	args := []interface{}{deviceID}
+	query += db.scope(&args, organisationScope, "s.organisationid")
+
 	var device models.EmergencyDevice
-	err := db.QueryRow(query, deviceID).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&device.EmergencyDeviceID,
 		&device.EmergencyDeviceTypeID,
 		&device.EmergencyDeviceTypeName,
@@ -452,18 +650,26 @@ func (db *DB) GetDeviceByID(deviceID int) (*models.EmergencyDevice, error) {
 		&device.BuildingCode,
 		&device.SiteID,
 		&device.SiteName,
+		&device.SiteTimeZone,
 		&device.SerialNumber,
 		&device.ManufactureDate,
 		&device.LastInspectionDateTime,
 		&device.Description,
 		&device.Size,
 		&device.Status,
+		&device.DecommissionedAt,
+		&device.DecommissionReason,
+		&device.PredecessorDeviceID,
+		&device.SuccessorDeviceID,
+		&device.Version,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	localiseDevice(&device)
+
 	return &device, nil
 }
 
@@ -481,9 +687,10 @@ func (db *DB) GetDevicesByRoomID(roomID int) ([]models.EmergencyDevice, error) {
 		b.buildingcode,
 		s.siteid,
 		s.sitename,
+		s.timezone,
 		ed.serialnumber,
 		ed.manufacturedate,
-		ed.LastInspectionDateTime AT TIME ZONE 'Pacific/Auckland' AS lastinspectiondatetime_nzdt,
+		ed.lastinspectiondatetime,
 		ed.description,
 		ed.size,
 		ed.status
@@ -493,9 +700,12 @@ func (db *DB) GetDevicesByRoomID(roomID int) ([]models.EmergencyDevice, error) {
 	JOIN roomT r ON ed.roomid = r.roomid
 	JOIN buildingT b ON r.buildingid = b.buildingid
 	JOIN siteT s ON b.siteid = s.siteid
-	WHERE ed.roomid = $1
+	WHERE ed.roomid = $1 AND ed.decommissionedat IS NULL
 	`
-	rows, err := db.Query(query, roomID)
+	args := []interface{}{roomID}
+	query += db.scope(&args, organisationScope, "s.organisationid")
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -519,6 +729,7 @@ func (db *DB) GetDevicesByRoomID(roomID int) ([]models.EmergencyDevice, error) {
 			&device.BuildingCode,
 			&device.SiteID,
 			&device.SiteName,
+			&device.SiteTimeZone,
 			&device.SerialNumber,
 			&device.ManufactureDate,
 			&device.LastInspectionDateTime,
@@ -529,6 +740,7 @@ func (db *DB) GetDevicesByRoomID(roomID int) ([]models.EmergencyDevice, error) {
 		if err != nil {
 			return nil, err
 		}
+		localiseDevice(&device)
 
 		emergencyDevices = append(emergencyDevices, device)
 	}
@@ -537,13 +749,15 @@ func (db *DB) GetDevicesByRoomID(roomID int) ([]models.EmergencyDevice, error) {
 }
 
 func (db *DB) GetAllDeviceTypes() ([]models.EmergencyDeviceType, error) {
+	var args []interface{}
 	query := `
-	SELECT emergencydevicetypeid, emergencydevicetypename
+	SELECT emergencydevicetypeid, emergencydevicetypename, inspectionintervalmonths, serviceintervalmonths, organisationid, version
 	FROM emergency_device_typeT
+	WHERE true` + db.scope(&args, sharedScope, "organisationid") + `
 	ORDER BY emergencydevicetypename
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -557,6 +771,10 @@ func (db *DB) GetAllDeviceTypes() ([]models.EmergencyDeviceType, error) {
 		err := rows.Scan(
 			&deviceType.EmergencyDeviceTypeID,
 			&deviceType.EmergencyDeviceTypeName,
+			&deviceType.InspectionIntervalMonths,
+			&deviceType.ServiceIntervalMonths,
+			&deviceType.OrganisationID,
+			&deviceType.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -569,13 +787,15 @@ func (db *DB) GetAllDeviceTypes() ([]models.EmergencyDeviceType, error) {
 }
 
 func (db *DB) GetAllExtinguisherTypes() ([]models.ExtinguisherType, error) {
+	var args []interface{}
 	query := `
-	SELECT extinguishertypeid, extinguishertypename
+	SELECT extinguishertypeid, extinguishertypename, organisationid
 	FROM Extinguisher_TypeT
+	WHERE true` + db.scope(&args, sharedScope, "organisationid") + `
 	ORDER BY extinguishertypename
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -589,6 +809,7 @@ func (db *DB) GetAllExtinguisherTypes() ([]models.ExtinguisherType, error) {
 		err := rows.Scan(
 			&extinguisherType.ExtinguisherTypeID,
 			&extinguisherType.ExtinguisherTypeName,
+			&extinguisherType.OrganisationID,
 		)
 		if err != nil {
 			return nil, err
@@ -600,21 +821,31 @@ func (db *DB) GetAllExtinguisherTypes() ([]models.ExtinguisherType, error) {
 	return extinguisherTypes, nil
 }
 
-func (db *DB) GetAllRooms(buildingId string) ([]models.Room, error) {
+// GetAllRooms returns the active rooms, optionally of one building or one floor, lowest floor first
+func (db *DB) GetAllRooms(buildingId string, floorId string) ([]models.Room, error) {
 	var query string
 	var args []interface{}
 
 	// Define the base query
-	query = ` SELECT r.roomid, r.buildingid, r.roomcode, b.buildingcode, s.sitename
+	query = ` SELECT r.roomid, r.buildingid, r.roomcode, b.buildingcode, s.sitename, r.floorid, f.floorname, f.floorlevel, r.version
               FROM roomT r
+              JOIN floorT f ON r.floorid = f.floorid
               JOIN buildingT b ON r.buildingid = b.buildingid
-              JOIN siteT s ON b.siteid = s.siteid`
+              JOIN siteT s ON b.siteid = s.siteid
+              WHERE r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL`
 
 	// Add filtering by building code if provided
 	if buildingId != "" {
-		query += ` WHERE b.buildingId = $1`
 		args = append(args, buildingId)
+		query += fmt.Sprintf(` AND b.buildingId = $%d`, len(args))
+	}
+	// Add filtering by floor if provided
+	if floorId != "" {
+		args = append(args, floorId)
+		query += fmt.Sprintf(` AND r.floorid = $%d`, len(args))
 	}
+	query += db.scope(&args, organisationScope, "s.organisationid")
+	query += ` ORDER BY b.buildingcode, f.floorlevel, r.roomcode`
 
 	// Prepare and execute the query
 	rows, err := db.Query(query, args...)
@@ -635,6 +866,10 @@ func (db *DB) GetAllRooms(buildingId string) ([]models.Room, error) {
 			&room.RoomCode,
 			&room.BuildingCode, // Assuming you have added this field to the Room model
 			&room.SiteName,     // Assuming you have added this field to the Room model
+			&room.FloorID,
+			&room.FloorName,
+			&room.FloorLevel,
+			&room.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -649,16 +884,18 @@ func (db *DB) GetAllRooms(buildingId string) ([]models.Room, error) {
 func (db *DB) GetAllBuildings(siteId string) ([]models.Building, error) {
 	var args []interface{}
 	query := `
-    SELECT b.buildingid, b.buildingcode, b.siteid, s.sitename
+    SELECT b.buildingid, b.buildingcode, b.siteid, s.sitename, b.mapx, b.mapy, b.mappolygon, b.version
     FROM buildingT b
     JOIN siteT s ON b.siteid = s.siteid
+    WHERE b.archivedat IS NULL AND s.archivedat IS NULL
     `
 
 	// Add filtering by site name if provided
 	if siteId != "" {
-		query += ` WHERE s.siteid = $1`
+		query += ` AND s.siteid = $1`
 		args = append(args, siteId)
 	}
+	query += db.scope(&args, organisationScope, "s.organisationid")
 
 	query += ` ORDER BY b.buildingcode`
 
@@ -682,6 +919,10 @@ func (db *DB) GetAllBuildings(siteId string) ([]models.Building, error) {
 			&building.BuildingCode,
 			&building.SiteID,
 			&building.SiteName, // Assuming you have added this field to the Building model
+			&building.MapX,
+			&building.MapY,
+			&building.MapPolygon,
+			&building.Version,
 		)
 		if err != nil {
 			return nil, err
@@ -695,15 +936,22 @@ func (db *DB) GetAllBuildings(siteId string) ([]models.Building, error) {
 
 func (db *DB) GetBuildingById(buildingID int) (*models.Building, error) {
 	query := `
-	SELECT buildingid, siteid, buildingcode
+	SELECT buildingid, siteid, buildingcode, mapx, mapy, mappolygon, version
 	FROM buildingT
 	WHERE buildingid = $1
 	`
+	args := []interface{}{buildingID}
+	query += db.scope(&args, siteScope, "siteid")
+
 	var building models.Building
-	err := db.QueryRow(query, buildingID).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&building.BuildingID,
 		&building.SiteID,
 		&building.BuildingCode,
+		&building.MapX,
+		&building.MapY,
+		&building.MapPolygon,
+		&building.Version,
 	)
 
 	if err != nil {
@@ -719,9 +967,11 @@ func (db *DB) GetBuildingByCodeandSite(buildingCode string, siteId int) (*models
 	FROM buildingT
 	WHERE buildingcode = $1 AND siteid = $2
 	`
+	args := []interface{}{buildingCode, siteId}
+	query += db.scope(&args, siteScope, "siteid")
 
 	var building models.Building
-	err := db.QueryRow(query, buildingCode, siteId).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&building.BuildingID,
 		&building.SiteID,
 		&building.BuildingCode,
@@ -735,7 +985,11 @@ func (db *DB) GetBuildingByCodeandSite(buildingCode string, siteId int) (*models
 }
 
 func (db *DB) AddBuilding(building *models.Building) error {
-	query := "INSERT INTO buildingT (siteId, buildingCode) VALUES ($1, $2)"
+	if err := db.inOrganisation(siteScope, building.SiteID); err != nil {
+		return err
+	}
+
+	query := "INSERT INTO buildingT (siteId, buildingCode, mapX, mapY, mapPolygon) VALUES ($1, $2, $3, $4, $5)"
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -743,7 +997,7 @@ func (db *DB) AddBuilding(building *models.Building) error {
 
 	defer insertStmt.Close()
 
-	_, err = insertStmt.Exec(building.SiteID, building.BuildingCode)
+	_, err = insertStmt.Exec(building.SiteID, building.BuildingCode, building.MapX, building.MapY, building.MapPolygon)
 
 	if err != nil {
 		return err
@@ -753,7 +1007,15 @@ func (db *DB) AddBuilding(building *models.Building) error {
 }
 
 func (db *DB) UpdateBuilding(building *models.Building) error {
-	query := "UPDATE BuildingT SET siteId = $1, buildingCode = $2 WHERE buildingID = $3"
+	if err := db.inOrganisation(siteScope, building.SiteID); err != nil {
+		return err
+	}
+
+	query := "UPDATE BuildingT SET siteId = $1, buildingCode = $2, mapX = $3, mapY = $4, mapPolygon = $5 WHERE buildingID = $6"
+	args := []interface{}{building.SiteID, building.BuildingCode, building.MapX, building.MapY, building.MapPolygon, building.BuildingID}
+	query += db.scope(&args, siteScope, "siteId")
+	query += versionCheck(&args, "version", building.Version)
+
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -761,95 +1023,266 @@ func (db *DB) UpdateBuilding(building *models.Building) error {
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(building.SiteID, building.BuildingCode, building.BuildingID)
+	result, err := updateStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{building.BuildingID}
+	exists := `SELECT 1 FROM BuildingT WHERE buildingID = $1` + db.scope(&existsArgs, siteScope, "siteId")
+	return checkVersion(db, result, building.Version, exists, existsArgs...)
 }
 
-func (db *DB) DeleteBuilding(buildingID string) error {
-	query := "DELETE FROM BuildingT WHERE buildingID = $1"
-	deleteStmt, err := db.Prepare(query)
+// ArchiveBuilding hides a building from the active listings, the row is kept for history
+func (db *DB) ArchiveBuilding(buildingID string, reason string, version int) error {
+	query := "UPDATE BuildingT SET archivedAt = NOW(), archiveReason = $1 WHERE buildingID = $2 AND archivedAt IS NULL"
+	args := []interface{}{sql.NullString{String: reason, Valid: reason != ""}, buildingID}
+	query += db.scope(&args, siteScope, "siteID")
+	query += versionCheck(&args, "version", version)
+
+	archiveStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
 
-	defer deleteStmt.Close()
+	defer archiveStmt.Close()
 
-	_, err = deleteStmt.Exec(buildingID)
+	result, err := archiveStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{buildingID}
+	exists := `SELECT 1 FROM BuildingT WHERE buildingID = $1 AND archivedAt IS NULL` + db.scope(&existsArgs, siteScope, "siteID")
+	return checkVersion(db, result, version, exists, existsArgs...)
 }
 
-func (db *DB) GetRoomsByBuildingID(buildingID string) ([]models.Room, error) {
+// GetFloorsByBuildingID returns the floors of a building, lowest floor first, with their plan image if they have one
+func (db *DB) GetFloorsByBuildingID(buildingID int) ([]models.Floor, error) {
+	args := []interface{}{buildingID}
 	query := `
-	SELECT r.roomid
-	FROM roomT r
-	WHERE r.buildingid = $1
-	ORDER BY r.roomcode
+	SELECT f.floorid, f.buildingid, b.buildingcode, b.siteid, f.floorname, f.floorlevel, fp.floorplanid, fp.imagepath, f.version
+	FROM floorT f
+	JOIN buildingT b ON f.buildingid = b.buildingid
+	LEFT JOIN FloorPlanT fp ON fp.floorid = f.floorid
+	WHERE f.buildingid = $1` + db.scope(&args, siteScope, "b.siteid") + `
+	ORDER BY f.floorlevel
 	`
 
-	rows, err := db.Query(query, buildingID)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
-
 	defer rows.Close()
 
-	var rooms []models.Room
-
-	// Scan the results
+	floors := []models.Floor{}
 	for rows.Next() {
-		var room models.Room
+		var floor models.Floor
 		err := rows.Scan(
-			&room.RoomID,
+			&floor.FloorID,
+			&floor.BuildingID,
+			&floor.BuildingCode,
+			&floor.SiteID,
+			&floor.FloorName,
+			&floor.FloorLevel,
+			&floor.FloorPlanID,
+			&floor.ImagePath,
+			&floor.Version,
 		)
 		if err != nil {
 			return nil, err
 		}
+		floors = append(floors, floor)
+	}
 
-		rooms = append(rooms, room)
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return rooms, nil
+	return floors, nil
 }
 
-func (db *DB) GetAllSites() ([]models.Site, error) {
+func (db *DB) GetFloorByID(floorID int) (*models.Floor, error) {
+	args := []interface{}{floorID}
 	query := `
-	SELECT siteid, sitename, siteaddress
-	FROM siteT
-	ORDER BY sitename
-	`
-
-	rows, err := db.Query(query)
+	SELECT f.floorid, f.buildingid, b.buildingcode, b.siteid, f.floorname, f.floorlevel, fp.floorplanid, fp.imagepath, f.version
+	FROM floorT f
+	JOIN buildingT b ON f.buildingid = b.buildingid
+	LEFT JOIN FloorPlanT fp ON fp.floorid = f.floorid
+	WHERE f.floorid = $1` + db.scope(&args, siteScope, "b.siteid")
+
+	var floor models.Floor
+	err := db.QueryRow(query, args...).Scan(
+		&floor.FloorID,
+		&floor.BuildingID,
+		&floor.BuildingCode,
+		&floor.SiteID,
+		&floor.FloorName,
+		&floor.FloorLevel,
+		&floor.FloorPlanID,
+		&floor.ImagePath,
+		&floor.Version,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	defer rows.Close()
-
-	var sites []models.Site
-
-	// Scan the results
-	for rows.Next() {
-		var site models.Site
-		err := rows.Scan(
-			&site.SiteID,
-			&site.SiteName,
-			&site.SiteAddress,
-		)
-		if err != nil {
-			return nil, err
-		}
+	return &floor, nil
+}
 
-		sites = append(sites, site)
+// AddFloor adds a floor to a building and returns the new floor ID
+func (db *DB) AddFloor(floor *models.Floor) (int, error) {
+	if err := db.inOrganisation(buildingScope, floor.BuildingID); err != nil {
+		return 0, err
+	}
+
+	query := `
+	INSERT INTO FloorT (BuildingID, FloorName, FloorLevel)
+	VALUES ($1, $2, $3)
+	RETURNING FloorID
+	`
+
+	var floorID int
+	err := db.QueryRow(query, floor.BuildingID, floor.FloorName, floor.FloorLevel).Scan(&floorID)
+	if err != nil {
+		return 0, err
+	}
+
+	return floorID, nil
+}
+
+// UpdateFloor renames or renumbers a floor, floors cannot move to another building
+func (db *DB) UpdateFloor(floor *models.Floor) error {
+	args := []interface{}{floor.FloorName, floor.FloorLevel, floor.FloorID}
+	query := "UPDATE FloorT SET FloorName = $1, FloorLevel = $2 WHERE FloorID = $3" + db.scope(&args, buildingScope, "BuildingID")
+	query += versionCheck(&args, "Version", floor.Version)
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	existsArgs := []interface{}{floor.FloorID}
+	exists := `SELECT 1 FROM FloorT WHERE FloorID = $1` + db.scope(&existsArgs, buildingScope, "BuildingID")
+	if err := checkVersion(db, result, floor.Version, exists, existsArgs...); err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteFloor deletes a floor together with its plan, it fails while rooms, archived or not, are on the floor
+func (db *DB) DeleteFloor(floorID int, version int) error {
+	args := []interface{}{floorID}
+	query := "DELETE FROM FloorT WHERE FloorID = $1" + db.scope(&args, buildingScope, "BuildingID")
+	query += versionCheck(&args, "Version", version)
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	existsArgs := []interface{}{floorID}
+	exists := `SELECT 1 FROM FloorT WHERE FloorID = $1` + db.scope(&existsArgs, buildingScope, "BuildingID")
+	if err := checkVersion(db, result, version, exists, existsArgs...); err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (db *DB) GetRoomsByBuildingID(buildingID string) ([]models.Room, error) {
+	args := []interface{}{buildingID}
+	query := `
+	SELECT r.roomid, r.floorid, f.floorname, f.floorlevel
+	FROM roomT r
+	JOIN floorT f ON r.floorid = f.floorid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	WHERE r.buildingid = $1 AND r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL` + db.scope(&args, buildingScope, "r.buildingid") + `
+	ORDER BY f.floorlevel, r.roomcode
+	`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var rooms []models.Room
+
+	// Scan the results
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.RoomID,
+			&room.FloorID,
+			&room.FloorName,
+			&room.FloorLevel,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	return rooms, nil
+}
+
+func (db *DB) GetAllSites() ([]models.Site, error) {
+	var args []interface{}
+	query := `
+	SELECT siteid, sitename, siteaddress, timezone, organisationid, version
+	FROM siteT
+	WHERE archivedat IS NULL` + db.scope(&args, organisationScope, "organisationid") + `
+	ORDER BY sitename
+	`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var sites []models.Site
+
+	// Scan the results
+	for rows.Next() {
+		var site models.Site
+		err := rows.Scan(
+			&site.SiteID,
+			&site.SiteName,
+			&site.SiteAddress,
+			&site.TimeZone,
+			&site.OrganisationID,
+			&site.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sites = append(sites, site)
 	}
 
 	return sites, nil
@@ -858,16 +1291,22 @@ func (db *DB) GetAllSites() ([]models.Site, error) {
 
 func (db *DB) GetSiteByID(siteID string) (*models.Site, error) {
 	query := `
-	SELECT siteid, sitename, siteaddress, sitemapimagepath
+	SELECT siteid, sitename, siteaddress, sitemapimagepath, timezone, organisationid, version
 	FROM siteT
 	WHERE siteid = $1
 	`
+	args := []interface{}{siteID}
+	query += db.scope(&args, organisationScope, "organisationid")
+
 	var site models.Site
-	err := db.QueryRow(query, siteID).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&site.SiteID,
 		&site.SiteName,
 		&site.SiteAddress,
 		&site.SiteMapImagePath,
+		&site.TimeZone,
+		&site.OrganisationID,
+		&site.Version,
 	)
 
 	if err != nil {
@@ -880,17 +1319,22 @@ func (db *DB) GetSiteByID(siteID string) (*models.Site, error) {
 // Get site by name function
 func (db *DB) GetSiteByName(siteName string) (*models.Site, error) {
 	query := `
-	SELECT siteid, sitename, siteaddress, sitemapimagepath
+	SELECT siteid, sitename, siteaddress, sitemapimagepath, timezone, organisationid, version
 	FROM siteT
 	WHERE sitename = $1
 	`
+	args := []interface{}{siteName}
+	query += db.scope(&args, organisationScope, "organisationid")
 
 	var site models.Site
-	err := db.QueryRow(query, siteName).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&site.SiteID,
 		&site.SiteName,
 		&site.SiteAddress,
 		&site.SiteMapImagePath,
+		&site.TimeZone,
+		&site.OrganisationID,
+		&site.Version,
 	)
 
 	if err != nil {
@@ -900,8 +1344,9 @@ func (db *DB) GetSiteByName(siteName string) (*models.Site, error) {
 	return &site, nil
 }
 
+// AddSite adds a site to the store's organisation, unscoped stores add it to the default organisation
 func (db *DB) AddSite(site *models.Site) error {
-	query := "INSERT INTO SiteT (siteName, siteAddress, siteMapImagePath) VALUES ($1, $2, $3)"
+	query := "INSERT INTO SiteT (siteName, siteAddress, siteMapImagePath, timeZone, organisationID) VALUES ($1, $2, $3, $4, $5)"
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -909,7 +1354,7 @@ func (db *DB) AddSite(site *models.Site) error {
 
 	defer insertStmt.Close()
 
-	_, err = insertStmt.Exec(site.SiteName, site.SiteAddress, site.SiteMapImagePath)
+	_, err = insertStmt.Exec(site.SiteName, site.SiteAddress, site.SiteMapImagePath, siteTimeZone(site), db.writeOrganisationID())
 
 	if err != nil {
 		return err
@@ -919,7 +1364,11 @@ func (db *DB) AddSite(site *models.Site) error {
 }
 
 func (db *DB) UpdateSite(site *models.Site) error {
-	query := "UPDATE SiteT SET siteName = $1, siteAddress = $2, siteMapImagePath = $3 WHERE siteID = $4"
+	query := "UPDATE SiteT SET siteName = $1, siteAddress = $2, siteMapImagePath = $3, timeZone = $4 WHERE siteID = $5"
+	args := []interface{}{site.SiteName, site.SiteAddress, site.SiteMapImagePath, siteTimeZone(site), site.SiteID}
+	query += db.scope(&args, organisationScope, "organisationID")
+	query += versionCheck(&args, "version", site.Version)
+
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -927,44 +1376,54 @@ func (db *DB) UpdateSite(site *models.Site) error {
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(site.SiteName, site.SiteAddress, site.SiteMapImagePath, site.SiteID)
+	result, err := updateStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{site.SiteID}
+	exists := `SELECT 1 FROM SiteT WHERE siteID = $1` + db.scope(&existsArgs, organisationScope, "organisationID")
+	return checkVersion(db, result, site.Version, exists, existsArgs...)
 }
 
-func (db *DB) DeleteSite(siteID string) error {
-	query := "DELETE FROM SiteT WHERE siteID = $1"
-	deleteStmt, err := db.Prepare(query)
+// ArchiveSite hides a site from the active listings, the row is kept for history
+func (db *DB) ArchiveSite(siteID string, reason string, version int) error {
+	query := "UPDATE SiteT SET archivedAt = NOW(), archiveReason = $1 WHERE siteID = $2 AND archivedAt IS NULL"
+	args := []interface{}{sql.NullString{String: reason, Valid: reason != ""}, siteID}
+	query += db.scope(&args, organisationScope, "organisationID")
+	query += versionCheck(&args, "version", version)
+
+	archiveStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
 
-	defer deleteStmt.Close()
+	defer archiveStmt.Close()
 
-	_, err = deleteStmt.Exec(siteID)
+	result, err := archiveStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{siteID}
+	exists := `SELECT 1 FROM SiteT WHERE siteID = $1 AND archivedAt IS NULL` + db.scope(&existsArgs, organisationScope, "organisationID")
+	return checkVersion(db, result, version, exists, existsArgs...)
 }
 
 func (db *DB) GetRoomsBySiteID(siteID string) ([]models.Room, error) {
+	args := []interface{}{siteID}
 	query := `
 	SELECT r.roomid, r.roomcode, b.buildingcode, s.sitename
 	FROM roomT r
 	JOIN buildingT b ON r.buildingid = b.buildingid
 	JOIN siteT s ON b.siteid = s.siteid
-	WHERE s.siteid = $1
+	WHERE s.siteid = $1 AND r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL` + db.scope(&args, organisationScope, "s.organisationid") + `
 	ORDER BY r.roomcode
 	`
 
-	rows, err := db.Query(query, siteID)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -994,21 +1453,28 @@ func (db *DB) GetRoomsBySiteID(siteID string) ([]models.Room, error) {
 
 func (db *DB) GetRoomByID(roomID int) (*models.Room, error) {
 	query := `
-	SELECT r.roomid, r.roomcode, b.buildingid,b.buildingcode, s.sitename, s.siteid
+	SELECT r.roomid, r.roomcode, b.buildingid,b.buildingcode, s.sitename, s.siteid, r.floorid, f.floorname, f.floorlevel, r.version
 	FROM roomT r
+	JOIN floorT f ON r.floorid = f.floorid
 	JOIN buildingT b ON r.buildingid = b.buildingid
 	JOIN siteT s ON b.siteid = s.siteid
 	WHERE r.roomid = $1
 	`
+	args := []interface{}{roomID}
+	query += db.scope(&args, organisationScope, "s.organisationid")
 
 	var room models.Room
-	err := db.QueryRow(query, roomID).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&room.RoomID,
 		&room.RoomCode,
 		&room.BuildingID,
 		&room.BuildingCode,
 		&room.SiteName,
 		&room.SiteID,
+		&room.FloorID,
+		&room.FloorName,
+		&room.FloorLevel,
+		&room.Version,
 	)
 
 	if err != nil {
@@ -1026,9 +1492,11 @@ func (db *DB) GetRoomByCodeAndSite(roomCode string, siteId int) (*models.Room, e
     JOIN BuildingT b ON r.BuildingID = b.BuildingID
     WHERE r.RoomCode = $1 AND b.SiteID = $2
     `
+	args := []interface{}{roomCode, siteId}
+	query += db.scope(&args, siteScope, "b.SiteID")
 
 	var room models.Room
-	err := db.QueryRow(query, roomCode, siteId).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&room.RoomID,
 		&room.BuildingID,
 		&room.RoomCode,
@@ -1048,9 +1516,11 @@ func (db *DB) GetRoomByCodeAndBuilding(roomCode string, buildingId int) (*models
     FROM RoomT
     WHERE RoomCode = $1 AND BuildingID = $2
     `
+	args := []interface{}{roomCode, buildingId}
+	query += db.scope(&args, buildingScope, "BuildingID")
 
 	var room models.Room
-	err := db.QueryRow(query, roomCode, buildingId).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&room.RoomID,
 		&room.BuildingID,
 		&room.RoomCode,
@@ -1068,7 +1538,12 @@ func (db *DB) GetRoomByCodeAndBuilding(roomCode string, buildingId int) (*models
 }
 
 func (db *DB) AddRoom(room *models.Room) error {
-	query := "INSERT INTO RoomT (buildingId, roomCode) VALUES ($1, $2)"
+	if err := db.inOrganisation(buildingScope, room.BuildingID); err != nil {
+		return err
+	}
+
+	// Without a floor the room goes on the building's default floor, see set_room_floor
+	query := "INSERT INTO RoomT (buildingId, roomCode, floorId) VALUES ($1, $2, NULLIF($3, 0))"
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -1076,7 +1551,7 @@ func (db *DB) AddRoom(room *models.Room) error {
 
 	defer insertStmt.Close()
 
-	_, err = insertStmt.Exec(room.BuildingID, room.RoomCode)
+	_, err = insertStmt.Exec(room.BuildingID, room.RoomCode, room.FloorID)
 
 	if err != nil {
 		return err
@@ -1086,7 +1561,16 @@ func (db *DB) AddRoom(room *models.Room) error {
 }
 
 func (db *DB) UpdateRoom(room *models.Room) error {
-	query := "UPDATE RoomT SET buildingId = $1, roomCode = $2 WHERE roomID = $3"
+	if err := db.inOrganisation(buildingScope, room.BuildingID); err != nil {
+		return err
+	}
+
+	// Without a floor the room keeps its floor, or goes on the default floor of the building it moves to
+	query := "UPDATE RoomT SET buildingId = $1, roomCode = $2, floorId = COALESCE(NULLIF($3, 0), floorId) WHERE roomID = $4"
+	args := []interface{}{room.BuildingID, room.RoomCode, room.FloorID, room.RoomID}
+	query += db.scope(&args, buildingScope, "buildingId")
+	query += versionCheck(&args, "version", room.Version)
+
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -1094,44 +1578,59 @@ func (db *DB) UpdateRoom(room *models.Room) error {
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(room.BuildingID, room.RoomCode, room.RoomID)
+	result, err := updateStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{room.RoomID}
+	exists := `SELECT 1 FROM RoomT WHERE roomID = $1` + db.scope(&existsArgs, buildingScope, "buildingId")
+	return checkVersion(db, result, room.Version, exists, existsArgs...)
 }
 
-func (db *DB) DeleteRoom(roomID int) error {
-	query := "DELETE FROM RoomT WHERE roomID = $1"
-	deleteStmt, err := db.Prepare(query)
+// ArchiveRoom hides a room from the active listings, the row is kept for history
+func (db *DB) ArchiveRoom(roomID int, reason string, version int) error {
+	query := "UPDATE RoomT SET archivedAt = NOW(), archiveReason = $1 WHERE roomID = $2 AND archivedAt IS NULL"
+	args := []interface{}{sql.NullString{String: reason, Valid: reason != ""}, roomID}
+	query += db.scope(&args, buildingScope, "buildingID")
+	query += versionCheck(&args, "version", version)
+
+	archiveStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
 
-	defer deleteStmt.Close()
+	defer archiveStmt.Close()
 
-	_, err = deleteStmt.Exec(roomID)
+	result, err := archiveStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{roomID}
+	exists := `SELECT 1 FROM RoomT WHERE roomID = $1 AND archivedAt IS NULL` + db.scope(&existsArgs, buildingScope, "buildingID")
+	return checkVersion(db, result, version, exists, existsArgs...)
 }
 
 func (db *DB) GetEmergencyDeviceTypeByID(emergencyDeviceTypeID int) (*models.EmergencyDeviceType, error) {
 	query := `
-	SELECT emergencydevicetypeid, emergencydevicetypename
+	SELECT emergencydevicetypeid, emergencydevicetypename, inspectionintervalmonths, serviceintervalmonths, organisationid, version
 	FROM emergency_device_typeT
 	WHERE emergencydevicetypeid = $1
 	`
+	args := []interface{}{emergencyDeviceTypeID}
+	query += db.scope(&args, sharedScope, "organisationid")
 
 	var deviceType models.EmergencyDeviceType
-	err := db.QueryRow(query, emergencyDeviceTypeID).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&deviceType.EmergencyDeviceTypeID,
 		&deviceType.EmergencyDeviceTypeName,
+		&deviceType.InspectionIntervalMonths,
+		&deviceType.ServiceIntervalMonths,
+		&deviceType.OrganisationID,
+		&deviceType.Version,
 	)
 
 	if err != nil {
@@ -1143,15 +1642,21 @@ func (db *DB) GetEmergencyDeviceTypeByID(emergencyDeviceTypeID int) (*models.Eme
 
 func (db *DB) GetDeviceTypeByName(emergencyDeviceTypeName string) (*models.EmergencyDeviceType, error) {
 	query := `
-	SELECT emergencydevicetypeid, emergencydevicetypename
+	SELECT emergencydevicetypeid, emergencydevicetypename, inspectionintervalmonths, serviceintervalmonths, organisationid, version
 	FROM emergency_device_typeT
 	WHERE emergencydevicetypename = $1
 	`
+	args := []interface{}{emergencyDeviceTypeName}
+	query += db.scope(&args, sharedScope, "organisationid")
 
 	var deviceType models.EmergencyDeviceType
-	err := db.QueryRow(query, emergencyDeviceTypeName).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&deviceType.EmergencyDeviceTypeID,
 		&deviceType.EmergencyDeviceTypeName,
+		&deviceType.InspectionIntervalMonths,
+		&deviceType.ServiceIntervalMonths,
+		&deviceType.OrganisationID,
+		&deviceType.Version,
 	)
 
 	if err != nil {
@@ -1161,10 +1666,11 @@ func (db *DB) GetDeviceTypeByName(emergencyDeviceTypeName string) (*models.Emerg
 	return &deviceType, nil
 }
 
-func (db *DB) AddEmergencyDeviceType(emergencyDeviceTypeName string) error {
+// AddEmergencyDeviceType adds a device type for the store's organisation, unscoped stores add global types
+func (db *DB) AddEmergencyDeviceType(emergencyDeviceType *models.EmergencyDeviceType) error {
 	query := `
-	INSERT INTO emergency_device_typeT (emergencydevicetypename)
-	VALUES ($1)
+	INSERT INTO emergency_device_typeT (emergencydevicetypename, inspectionintervalmonths, serviceintervalmonths, organisationid)
+	VALUES ($1, $2, $3, $4)
 	`
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
@@ -1174,7 +1680,10 @@ func (db *DB) AddEmergencyDeviceType(emergencyDeviceTypeName string) error {
 	defer insertStmt.Close()
 
 	_, err = insertStmt.Exec(
-		emergencyDeviceTypeName,
+		emergencyDeviceType.EmergencyDeviceTypeName,
+		emergencyDeviceType.InspectionIntervalMonths,
+		emergencyDeviceType.ServiceIntervalMonths,
+		sql.NullInt64{Int64: int64(db.organisationID), Valid: db.organisationID != 0},
 	)
 
 	if err != nil {
@@ -1184,12 +1693,21 @@ func (db *DB) AddEmergencyDeviceType(emergencyDeviceTypeName string) error {
 	return nil
 }
 
+// UpdateEmergencyDeviceType changes a device type, a scoped store can only change its organisation's own types
 func (db *DB) UpdateEmergencyDeviceType(emergencyDeviceType *models.EmergencyDeviceType) error {
 	query := `
 	UPDATE emergency_device_typeT
-	SET emergencydevicetypename = $1
-	WHERE emergencydevicetypeid = $2
+	SET emergencydevicetypename = $1, inspectionintervalmonths = $2, serviceintervalmonths = $3
+	WHERE emergencydevicetypeid = $4
 	`
+	args := []interface{}{
+		emergencyDeviceType.EmergencyDeviceTypeName,
+		emergencyDeviceType.InspectionIntervalMonths,
+		emergencyDeviceType.ServiceIntervalMonths,
+		emergencyDeviceType.EmergencyDeviceTypeID,
+	}
+	query += db.scope(&args, organisationScope, "organisationid")
+	query += versionCheck(&args, "version", emergencyDeviceType.Version)
 
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
@@ -1198,20 +1716,24 @@ func (db *DB) UpdateEmergencyDeviceType(emergencyDeviceType *models.EmergencyDev
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(
-		emergencyDeviceType.EmergencyDeviceTypeName,
-		emergencyDeviceType.EmergencyDeviceTypeID,
-	)
+	result, err := updateStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{emergencyDeviceType.EmergencyDeviceTypeID}
+	exists := `SELECT 1 FROM emergency_device_typeT WHERE emergencydevicetypeid = $1` + db.scope(&existsArgs, organisationScope, "organisationid")
+	return checkVersion(db, result, emergencyDeviceType.Version, exists, existsArgs...)
 }
 
-func (db *DB) DeleteEmergencyDeviceType(emergencyDeviceTypeID int) error {
+// DeleteEmergencyDeviceType deletes a device type, a scoped store can only delete its organisation's own types
+func (db *DB) DeleteEmergencyDeviceType(emergencyDeviceTypeID int, version int) error {
 	query := "DELETE FROM Emergency_Device_TypeT WHERE EmergencyDeviceTypeID = $1"
+	args := []interface{}{emergencyDeviceTypeID}
+	query += db.scope(&args, organisationScope, "OrganisationID")
+	query += versionCheck(&args, "Version", version)
+
 	deleteStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -1219,13 +1741,15 @@ func (db *DB) DeleteEmergencyDeviceType(emergencyDeviceTypeID int) error {
 
 	defer deleteStmt.Close()
 
-	_, err = deleteStmt.Exec(emergencyDeviceTypeID)
+	result, err := deleteStmt.Exec(args...)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	existsArgs := []interface{}{emergencyDeviceTypeID}
+	exists := `SELECT 1 FROM Emergency_Device_TypeT WHERE EmergencyDeviceTypeID = $1` + db.scope(&existsArgs, organisationScope, "OrganisationID")
+	return checkVersion(db, result, version, exists, existsArgs...)
 }
 
 func (db *DB) GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyDevice, error) {
@@ -1242,9 +1766,10 @@ func (db *DB) GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyD
         b.buildingcode,
         s.siteid,
         s.sitename,
+        s.timezone,
         ed.serialnumber,
         ed.manufacturedate,
-        ed.LastInspectionDateTime AT TIME ZONE 'Pacific/Auckland' AS lastinspectiondatetime_nzdt,
+        ed.lastinspectiondatetime,
         ed.description,
         ed.size,
         ed.status
@@ -1256,7 +1781,10 @@ func (db *DB) GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyD
     JOIN siteT s ON b.siteid = s.siteid
     WHERE ed.emergencydevicetypeid = $1
     `
-	rows, err := db.Query(query, emergencyDeviceTypeID)
+	args := []interface{}{emergencyDeviceTypeID}
+	query += db.scope(&args, organisationScope, "s.organisationid")
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1277,6 +1805,7 @@ func (db *DB) GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyD
 			&device.BuildingCode,
 			&device.SiteID,
 			&device.SiteName,
+			&device.SiteTimeZone,
 			&device.SerialNumber,
 			&device.ManufactureDate,
 			&device.LastInspectionDateTime,
@@ -1287,6 +1816,7 @@ func (db *DB) GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyD
 		if err != nil {
 			return nil, err
 		}
+		localiseDevice(&device)
 		devices = append(devices, device)
 	}
 	if err = rows.Err(); err != nil {
@@ -1298,15 +1828,18 @@ func (db *DB) GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyD
 
 func (db *DB) GetExtinguisherTypeByID(extinguisherTypeID int) (*models.ExtinguisherType, error) {
 	query := `
-	SELECT extinguishertypeid, extinguishertypename
+	SELECT extinguishertypeid, extinguishertypename, organisationid
 	FROM extinguisher_typeT
 	WHERE extinguishertypeid = $1
 	`
+	args := []interface{}{extinguisherTypeID}
+	query += db.scope(&args, sharedScope, "organisationid")
 
 	var extinguisherType models.ExtinguisherType
-	err := db.QueryRow(query, extinguisherTypeID).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&extinguisherType.ExtinguisherTypeID,
 		&extinguisherType.ExtinguisherTypeName,
+		&extinguisherType.OrganisationID,
 	)
 
 	if err != nil {
@@ -1316,10 +1849,30 @@ func (db *DB) GetExtinguisherTypeByID(extinguisherTypeID int) (*models.Extinguis
 	return &extinguisherType, nil
 }
 
+// checkDeviceReferences checks that the room and types of a device are ones the store's organisation can use
+func (db *DB) checkDeviceReferences(device *models.EmergencyDevice) error {
+	if err := db.inOrganisation(roomScope, device.RoomID); err != nil {
+		return err
+	}
+	if err := db.inOrganisation(deviceTypeScope, device.EmergencyDeviceTypeID); err != nil {
+		return err
+	}
+	if device.ExtinguisherTypeID.Valid {
+		return db.inOrganisation(extinguisherTypeScope, device.ExtinguisherTypeID.Int64)
+	}
+	return nil
+}
+
+// AddEmergencyDevice adds a device and sets its EmergencyDeviceID to the new row's
 func (db *DB) AddEmergencyDevice(device *models.EmergencyDevice) error {
+	if err := db.checkDeviceReferences(device); err != nil {
+		return err
+	}
+
 	query := `
 	INSERT INTO emergency_deviceT (emergencydevicetypeid, extinguishertypeid, roomid, serialnumber, manufacturedate, description, size, status)
 	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING emergencydeviceid
 	`
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
@@ -1328,7 +1881,7 @@ func (db *DB) AddEmergencyDevice(device *models.EmergencyDevice) error {
 
 	defer insertStmt.Close()
 
-	_, err = insertStmt.Exec(
+	err = insertStmt.QueryRow(
 		device.EmergencyDeviceTypeID,
 		device.ExtinguisherTypeID,
 		device.RoomID,
@@ -1337,7 +1890,7 @@ func (db *DB) AddEmergencyDevice(device *models.EmergencyDevice) error {
 		device.Description,
 		device.Size,
 		device.Status,
-	)
+	).Scan(&device.EmergencyDeviceID)
 
 	if err != nil {
 		return err
@@ -1347,19 +1900,16 @@ func (db *DB) AddEmergencyDevice(device *models.EmergencyDevice) error {
 }
 
 func (db *DB) UpdateEmergencyDevice(device *models.EmergencyDevice) error {
+	if err := db.checkDeviceReferences(device); err != nil {
+		return err
+	}
+
 	query := `
 	UPDATE emergency_deviceT
 	SET emergencydevicetypeid = $1, extinguishertypeid = $2, roomid = $3, serialnumber = $4, manufacturedate = $5, description = $6, size = $7, status = $8
 	WHERE emergencydeviceid = $9
 	`
-	updateStmt, err := db.Prepare(query)
-	if err != nil {
-		return err
-	}
-
-	defer updateStmt.Close()
-
-	_, err = updateStmt.Exec(
+	args := []interface{}{
 		device.EmergencyDeviceTypeID,
 		device.ExtinguisherTypeID,
 		device.RoomID,
@@ -1369,36 +1919,107 @@ func (db *DB) UpdateEmergencyDevice(device *models.EmergencyDevice) error {
 		device.Size,
 		device.Status,
 		device.EmergencyDeviceID,
-	)
+	}
+	query += db.scope(&args, roomScope, "roomid")
+	query += versionCheck(&args, "version", device.Version)
 
+	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	defer updateStmt.Close()
+
+	result, err := updateStmt.Exec(args...)
+
+	if err != nil {
+		return err
+	}
+
+	existsArgs := []interface{}{device.EmergencyDeviceID}
+	exists := `SELECT 1 FROM emergency_deviceT WHERE emergencydeviceid = $1` + db.scope(&existsArgs, roomScope, "roomid")
+	return checkVersion(db, result, device.Version, exists, existsArgs...)
 }
 
-func (db *DB) DeleteEmergencyDevice(deviceID int) error {
-	query := "DELETE FROM Emergency_DeviceT WHERE EmergencyDeviceID = $1"
-	deleteStmt, err := db.Prepare(query)
+// DecommissionEmergencyDevice takes a device out of service, its inspection history is kept
+func (db *DB) DecommissionEmergencyDevice(deviceID int, reason string, version int) error {
+	query := `
+	UPDATE emergency_deviceT
+	SET decommissionedat = NOW(), decommissionreason = $1, status = 'Decommissioned'
+	WHERE emergencydeviceid = $2 AND decommissionedat IS NULL
+	`
+	args := []interface{}{reason, deviceID}
+	query += db.scope(&args, roomScope, "roomid")
+	query += versionCheck(&args, "version", version)
+
+	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
 
-	defer deleteStmt.Close()
+	defer updateStmt.Close()
 
-	_, err = deleteStmt.Exec(deviceID)
+	result, err := updateStmt.Exec(args...)
+	if err != nil {
+		return err
+	}
+
+	existsArgs := []interface{}{deviceID}
+	exists := `SELECT 1 FROM emergency_deviceT WHERE emergencydeviceid = $1 AND decommissionedat IS NULL` + db.scope(&existsArgs, roomScope, "roomid")
+	if err := checkVersion(db, result, version, exists, existsArgs...); err != nil {
+		return err
+	}
 
+	// No rows means the device does not exist or is already decommissioned
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
+// PurgeEmergencyDevice permanently removes a device and its inspection history.
+// Callers must export the history first, this cannot be undone.
+func (db *DB) PurgeEmergencyDevice(deviceID int) error {
+	if err := db.inOrganisation(deviceScope, deviceID); err != nil {
+		return err
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM Emergency_Device_InspectionT WHERE EmergencyDeviceID = $1", deviceID)
+	if err != nil {
+		return err
+	}
+
+	// Attachments are removed with their maintenance records
+	_, err = tx.Exec("DELETE FROM MaintenanceRecordT WHERE EmergencyDeviceID = $1", deviceID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM Emergency_DeviceT WHERE EmergencyDeviceID = $1", deviceID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (db *DB) GetAllInspectionsByDeviceID(deviceID int) ([]models.Inspection, error) {
 	query := `
-	SELECT edi.emergencydeviceinspectionid, edi.emergencydeviceid, ed.serialnumber, edi.userid, u.username, edi.inspectiondatetime AT TIME ZONE 'Pacific/Auckland' AS lastinspectiondate_nzdt, edi.createdat AT TIME ZONE 'Pacific/Auckland' AS createdat_nzdt,
+	SELECT edi.emergencydeviceinspectionid, edi.emergencydeviceid, ed.serialnumber, edi.userid, u.username, edi.inspectiondatetime, edi.createdat, s.timezone,
 		   edi.IsConspicuous, edi.IsAccessible, edi.IsAssignedLocation, edi.IsSignVisible, edi.IsAntiTamperDeviceIntact,
 		   edi.IsSupportBracketSecure, edi.AreOperatingInstructionsClear, edi.IsMaintenanceTagAttached,
 		   edi.isNoExternalDamage, edi.IsChargeGaugeNormal, edi.IsReplaced, edi.AreMaintenanceRecordsComplete, edi.WorkOrderRequired,
@@ -1406,11 +2027,16 @@ func (db *DB) GetAllInspectionsByDeviceID(deviceID int) ([]models.Inspection, er
 	FROM emergency_device_inspectionT edi
 	JOIN userT u ON edi.userid = u.userid
 	JOIN emergency_deviceT ed ON edi.emergencydeviceid = ed.emergencydeviceid
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
 	WHERE edi.emergencydeviceid = $1
-	ORDER BY edi.inspectiondatetime DESC
 	`
+	args := []interface{}{deviceID}
+	query += db.scope(&args, organisationScope, "s.organisationid")
+	query += ` ORDER BY edi.inspectiondatetime DESC`
 
-	rows, err := db.Query(query, deviceID)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1429,6 +2055,7 @@ func (db *DB) GetAllInspectionsByDeviceID(deviceID int) ([]models.Inspection, er
 			&inspection.InspectorName, // Scans the `username` field into `InspectorName`
 			&inspection.InspectionDateTime,
 			&inspection.CreatedAt,
+			&inspection.SiteTimeZone,
 			&inspection.IsConspicuous,
 			&inspection.IsAccessible,
 			&inspection.IsAssignedLocation,
@@ -1448,6 +2075,7 @@ func (db *DB) GetAllInspectionsByDeviceID(deviceID int) ([]models.Inspection, er
 		if err != nil {
 			return nil, err
 		}
+		localiseInspection(&inspection)
 
 		inspections = append(inspections, inspection)
 	}
@@ -1456,20 +2084,35 @@ func (db *DB) GetAllInspectionsByDeviceID(deviceID int) ([]models.Inspection, er
 }
 
 func (db *DB) GetInspectionByID(inspectionID int) (*models.Inspection, error) {
+	return db.getInspection("edi.emergencydeviceinspectionid", inspectionID)
+}
+
+// GetInspectionByClientID returns the inspection an offline client recorded with the given UUID
+func (db *DB) GetInspectionByClientID(clientID string) (*models.Inspection, error) {
+	return db.getInspection("edi.clientid", clientID)
+}
+
+// getInspection returns the inspection whose column has the given value, column is never user input
+func (db *DB) getInspection(column string, value interface{}) (*models.Inspection, error) {
 	query := `
-	SELECT edi.emergencydeviceinspectionid, edi.emergencydeviceid, ed.serialnumber, edi.userid, u.username, edi.inspectiondatetime AT TIME ZONE 'Pacific/Auckland' AS lastinspectiondate_nzdt, edi.createdat AT TIME ZONE 'Pacific/Auckland' AS createdat_nzdt,
+	SELECT edi.emergencydeviceinspectionid, edi.emergencydeviceid, ed.serialnumber, edi.userid, u.username, edi.inspectiondatetime, edi.createdat, s.timezone,
 		   edi.IsConspicuous, edi.IsAccessible, edi.IsAssignedLocation, edi.IsSignVisible, edi.IsAntiTamperDeviceIntact,
 		   edi.IsSupportBracketSecure, edi.AreOperatingInstructionsClear, edi.IsMaintenanceTagAttached,
 		   edi.isNoExternalDamage, edi.IsChargeGaugeNormal, edi.IsReplaced, edi.AreMaintenanceRecordsComplete, edi.WorkOrderRequired,
-		   edi.InspectionStatus, edi.Notes
+		   edi.InspectionStatus, edi.Notes, edi.clientid
 	FROM emergency_device_inspectionT edi
 	JOIN userT u ON edi.userid = u.userid
 	JOIN emergency_deviceT ed ON edi.emergencydeviceid = ed.emergencydeviceid
-	WHERE edi.emergencydeviceinspectionid = $1
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	WHERE ` + column + ` = $1
 	`
+	args := []interface{}{value}
+	query += db.scope(&args, organisationScope, "s.organisationid")
 
 	var inspection models.Inspection
-	err := db.QueryRow(query, inspectionID).Scan(
+	err := db.QueryRow(query, args...).Scan(
 		&inspection.EmergencyDeviceInspectionID,
 		&inspection.EmergencyDeviceID,
 		&inspection.SerialNumber,
@@ -1477,6 +2120,7 @@ func (db *DB) GetInspectionByID(inspectionID int) (*models.Inspection, error) {
 		&inspection.InspectorName, // Scans the `username` field into `InspectorName`
 		&inspection.InspectionDateTime,
 		&inspection.CreatedAt,
+		&inspection.SiteTimeZone,
 		&inspection.IsConspicuous,
 		&inspection.IsAccessible,
 		&inspection.IsAssignedLocation,
@@ -1492,20 +2136,49 @@ func (db *DB) GetInspectionByID(inspectionID int) (*models.Inspection, error) {
 		&inspection.WorkOrderRequired,
 		&inspection.InspectionStatus,
 		&inspection.Notes,
+		&inspection.ClientID,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
+	localiseInspection(&inspection)
+
 	return &inspection, nil
 }
 
+// CountRecentInspections counts the inspections recorded within the given time, whatever time they were carried out
+func (db *DB) CountRecentInspections(within time.Duration) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM emergency_device_inspectionT
+	WHERE createdat >= CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'
+	`
+	args := []interface{}{within.Seconds()}
+	query += db.scope(&args, deviceScope, "emergencydeviceid")
+
+	var count int
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// AddInspection adds an inspection and sets its EmergencyDeviceInspectionID to the new row's
 func (db *DB) AddInspection(inspection *models.Inspection) error {
+	if err := db.inOrganisation(deviceScope, inspection.EmergencyDeviceID); err != nil {
+		return err
+	}
+	if err := db.inOrganisation(userScope, inspection.UserID); err != nil {
+		return err
+	}
 
 	query := `
-	INSERT INTO emergency_device_inspectionT (emergencydeviceid, userid, inspectiondatetime, IsConspicuous, IsAccessible, IsAssignedLocation, IsSignVisible, IsAntiTamperDeviceIntact, IsSupportBracketSecure, AreOperatingInstructionsClear, IsMaintenanceTagAttached, IsNoExternalDamage, IsChargeGaugeNormal, IsReplaced, AreMaintenanceRecordsComplete, WorkOrderRequired, InspectionStatus, Notes)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+	INSERT INTO emergency_device_inspectionT (emergencydeviceid, userid, inspectiondatetime, IsConspicuous, IsAccessible, IsAssignedLocation, IsSignVisible, IsAntiTamperDeviceIntact, IsSupportBracketSecure, AreOperatingInstructionsClear, IsMaintenanceTagAttached, IsNoExternalDamage, IsChargeGaugeNormal, IsReplaced, AreMaintenanceRecordsComplete, WorkOrderRequired, InspectionStatus, Notes, ClientID)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+	RETURNING emergencydeviceinspectionid
 	`
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
@@ -1514,7 +2187,7 @@ func (db *DB) AddInspection(inspection *models.Inspection) error {
 
 	defer insertStmt.Close()
 
-	_, err = insertStmt.Exec(
+	err = insertStmt.QueryRow(
 		inspection.EmergencyDeviceID,
 		inspection.UserID,
 		inspection.InspectionDateTime,
@@ -1533,7 +2206,8 @@ func (db *DB) AddInspection(inspection *models.Inspection) error {
 		inspection.WorkOrderRequired.Bool,
 		inspection.InspectionStatus,
 		inspection.Notes.String,
-	)
+		inspection.ClientID,
+	).Scan(&inspection.EmergencyDeviceInspectionID)
 
 	if err != nil {
 		return err
@@ -1547,7 +2221,628 @@ func (db *DB) UpdateDeviceStatus(deviceID int, status string) error {
         UPDATE emergency_devicet
         SET status = $1
         WHERE emergencydeviceid = $2`
+	args := []interface{}{status, deviceID}
+	query += db.scope(&args, roomScope, "roomid")
 
-	_, err := db.Exec(query, status, deviceID)
+	_, err := db.Exec(query, args...)
 	return err
 }
+
+// ReplaceEmergencyDevice decommissions a device and creates its replacement in the same room with the
+// same type and description. Fields left empty on the replacement are carried over from the old device.
+// The old device must still be at the version, unless it is 0. It returns the ID of the new device.
+func (db *DB) ReplaceEmergencyDevice(oldDeviceID int, replacement *models.EmergencyDevice, reason string, version int) (int, error) {
+	if replacement.ExtinguisherTypeID.Valid {
+		if err := db.inOrganisation(extinguisherTypeScope, replacement.ExtinguisherTypeID.Int64); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// Lock the old device so it cannot be replaced twice at the same time
+	var old models.EmergencyDevice
+	args := []interface{}{oldDeviceID}
+	err = tx.QueryRow(`
+	SELECT emergencydevicetypeid, roomid, extinguishertypeid, description, size, decommissionedat, version
+	FROM emergency_deviceT
+	WHERE emergencydeviceid = $1`+db.scope(&args, roomScope, "roomid")+`
+	FOR UPDATE
+	`, args...).Scan(
+		&old.EmergencyDeviceTypeID,
+		&old.RoomID,
+		&old.ExtinguisherTypeID,
+		&old.Description,
+		&old.Size,
+		&old.DecommissionedAt,
+		&old.Version,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	if old.DecommissionedAt.Valid {
+		return 0, ErrDeviceDecommissioned
+	}
+
+	// Only the version the replacement was made from is replaced
+	if err := matchVersion(old.Version, version); err != nil {
+		return 0, err
+	}
+
+	// Carry over the location and type, and the details the replacement does not override
+	replacement.RoomID = old.RoomID
+	replacement.EmergencyDeviceTypeID = old.EmergencyDeviceTypeID
+	replacement.Description = old.Description
+	if !replacement.ExtinguisherTypeID.Valid {
+		replacement.ExtinguisherTypeID = old.ExtinguisherTypeID
+	}
+	if !replacement.Size.Valid {
+		replacement.Size = old.Size
+	}
+	if !replacement.Status.Valid {
+		replacement.Status = sql.NullString{String: "Active", Valid: true}
+	}
+
+	var newDeviceID int
+	err = tx.QueryRow(`
+	INSERT INTO emergency_deviceT (emergencydevicetypeid, extinguishertypeid, roomid, serialnumber, manufacturedate, description, size, status, replacesdeviceid)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING emergencydeviceid
+	`,
+		replacement.EmergencyDeviceTypeID,
+		replacement.ExtinguisherTypeID,
+		replacement.RoomID,
+		replacement.SerialNumber,
+		replacement.ManufactureDate,
+		replacement.Description,
+		replacement.Size,
+		replacement.Status,
+		oldDeviceID,
+	).Scan(&newDeviceID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+	UPDATE emergency_deviceT
+	SET decommissionedat = NOW(), decommissionreason = $1, status = 'Decommissioned'
+	WHERE emergencydeviceid = $2
+	`, fmt.Sprintf("Replaced by device %d: %s", newDeviceID, reason), oldDeviceID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	replacement.EmergencyDeviceID = newDeviceID
+	replacement.PredecessorDeviceID = sql.NullInt64{Int64: int64(oldDeviceID), Valid: true}
+
+	return newDeviceID, nil
+}
+
+// GetDeviceReplacementChain returns every device in the replacement chain of a device, oldest first
+func (db *DB) GetDeviceReplacementChain(deviceID int) ([]models.EmergencyDevice, error) {
+	// Replacements stay in the room of the device they replace, so only the starting device needs scoping
+	args := []interface{}{deviceID}
+	condition := db.scope(&args, roomScope, "roomid")
+
+	query := `
+	WITH RECURSIVE predecessors AS (
+		SELECT emergencydeviceid, replacesdeviceid
+		FROM emergency_deviceT
+		WHERE emergencydeviceid = $1` + condition + `
+		UNION ALL
+		SELECT ed.emergencydeviceid, ed.replacesdeviceid
+		FROM emergency_deviceT ed
+		JOIN predecessors p ON ed.emergencydeviceid = p.replacesdeviceid
+	), successors AS (
+		SELECT emergencydeviceid
+		FROM emergency_deviceT
+		WHERE emergencydeviceid = $1` + condition + `
+		UNION ALL
+		SELECT ed.emergencydeviceid
+		FROM emergency_deviceT ed
+		JOIN successors s ON ed.replacesdeviceid = s.emergencydeviceid
+	)
+	SELECT emergencydeviceid FROM predecessors
+	UNION
+	SELECT emergencydeviceid FROM successors
+	ORDER BY 1
+	`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deviceIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		deviceIDs = append(deviceIDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(deviceIDs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	// Replacements are always created after the device they replace, so ID order is chain order
+	var chain []models.EmergencyDevice
+	for _, id := range deviceIDs {
+		device, err := db.GetDeviceByID(id)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, *device)
+	}
+
+	return chain, nil
+}
+
+// GetMaintenanceRecordsByDeviceID returns the maintenance records of a device, latest service first
+func (db *DB) GetMaintenanceRecordsByDeviceID(deviceID int) ([]models.MaintenanceRecord, error) {
+	query := `
+	SELECT mr.maintenancerecordid, mr.emergencydeviceid, ed.serialnumber, mr.userid, u.username,
+		   mr.servicetype, mr.servicedate, mr.provider, mr.certificatenumber, mr.cost, mr.nextservicedate,
+		   mr.notes, mr.createdat
+	FROM MaintenanceRecordT mr
+	JOIN userT u ON mr.userid = u.userid
+	JOIN emergency_deviceT ed ON mr.emergencydeviceid = ed.emergencydeviceid
+	WHERE mr.emergencydeviceid = $1
+	`
+	args := []interface{}{deviceID}
+	query += db.scope(&args, roomScope, "ed.roomid")
+	query += ` ORDER BY mr.servicedate DESC, mr.maintenancerecordid DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []models.MaintenanceRecord{}
+	for rows.Next() {
+		var record models.MaintenanceRecord
+		err := rows.Scan(
+			&record.MaintenanceRecordID,
+			&record.EmergencyDeviceID,
+			&record.SerialNumber,
+			&record.UserID,
+			&record.RecordedBy,
+			&record.ServiceType,
+			&record.ServiceDate,
+			&record.Provider,
+			&record.CertificateNumber,
+			&record.Cost,
+			&record.NextServiceDate,
+			&record.Notes,
+			&record.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetMaintenanceRecordByID returns a maintenance record together with its attachments
+func (db *DB) GetMaintenanceRecordByID(maintenanceRecordID int) (*models.MaintenanceRecord, error) {
+	query := `
+	SELECT mr.maintenancerecordid, mr.emergencydeviceid, ed.serialnumber, mr.userid, u.username,
+		   mr.servicetype, mr.servicedate, mr.provider, mr.certificatenumber, mr.cost, mr.nextservicedate,
+		   mr.notes, mr.createdat
+	FROM MaintenanceRecordT mr
+	JOIN userT u ON mr.userid = u.userid
+	JOIN emergency_deviceT ed ON mr.emergencydeviceid = ed.emergencydeviceid
+	WHERE mr.maintenancerecordid = $1
+	`
+	args := []interface{}{maintenanceRecordID}
+	query += db.scope(&args, roomScope, "ed.roomid")
+
+	var record models.MaintenanceRecord
+	err := db.QueryRow(query, args...).Scan(
+		&record.MaintenanceRecordID,
+		&record.EmergencyDeviceID,
+		&record.SerialNumber,
+		&record.UserID,
+		&record.RecordedBy,
+		&record.ServiceType,
+		&record.ServiceDate,
+		&record.Provider,
+		&record.CertificateNumber,
+		&record.Cost,
+		&record.NextServiceDate,
+		&record.Notes,
+		&record.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+	SELECT maintenanceattachmentid, maintenancerecordid, filename, filepath, uploadedat
+	FROM MaintenanceAttachmentT
+	WHERE maintenancerecordid = $1
+	ORDER BY maintenanceattachmentid
+	`, maintenanceRecordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	record.Attachments = []models.MaintenanceAttachment{}
+	for rows.Next() {
+		var attachment models.MaintenanceAttachment
+		err := rows.Scan(
+			&attachment.MaintenanceAttachmentID,
+			&attachment.MaintenanceRecordID,
+			&attachment.FileName,
+			&attachment.FilePath,
+			&attachment.UploadedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		record.Attachments = append(record.Attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// AddMaintenanceRecord inserts a maintenance record with its attachments and returns the new record ID
+func (db *DB) AddMaintenanceRecord(record *models.MaintenanceRecord) (int, error) {
+	if err := db.inOrganisation(deviceScope, record.EmergencyDeviceID); err != nil {
+		return 0, err
+	}
+	if err := db.inOrganisation(userScope, record.UserID); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	var maintenanceRecordID int
+	err = tx.QueryRow(`
+	INSERT INTO MaintenanceRecordT (EmergencyDeviceID, UserID, ServiceType, ServiceDate, Provider, CertificateNumber, Cost, NextServiceDate, Notes)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING MaintenanceRecordID
+	`,
+		record.EmergencyDeviceID,
+		record.UserID,
+		record.ServiceType,
+		record.ServiceDate,
+		record.Provider,
+		record.CertificateNumber,
+		record.Cost,
+		record.NextServiceDate,
+		record.Notes,
+	).Scan(&maintenanceRecordID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, attachment := range record.Attachments {
+		_, err = tx.Exec(`
+		INSERT INTO MaintenanceAttachmentT (MaintenanceRecordID, FileName, FilePath)
+		VALUES ($1, $2, $3)
+		`, maintenanceRecordID, attachment.FileName, attachment.FilePath)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return maintenanceRecordID, nil
+}
+
+// GetFloorPlansByBuildingID returns the floor plans of a building, lowest floor first
+func (db *DB) GetFloorPlansByBuildingID(buildingID int) ([]models.FloorPlan, error) {
+	query := `
+	SELECT fp.floorplanid, fp.floorid, fp.buildingid, b.buildingcode, b.siteid, f.floorname, f.floorlevel, fp.imagepath, fp.createdat
+	FROM FloorPlanT fp
+	JOIN floorT f ON fp.floorid = f.floorid
+	JOIN buildingT b ON fp.buildingid = b.buildingid
+	WHERE fp.buildingid = $1
+	`
+	args := []interface{}{buildingID}
+	query += db.scope(&args, siteScope, "b.siteid")
+	query += ` ORDER BY f.floorlevel`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	floorPlans := []models.FloorPlan{}
+	for rows.Next() {
+		var floorPlan models.FloorPlan
+		err := rows.Scan(
+			&floorPlan.FloorPlanID,
+			&floorPlan.FloorID,
+			&floorPlan.BuildingID,
+			&floorPlan.BuildingCode,
+			&floorPlan.SiteID,
+			&floorPlan.FloorLabel,
+			&floorPlan.FloorLevel,
+			&floorPlan.ImagePath,
+			&floorPlan.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		floorPlans = append(floorPlans, floorPlan)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return floorPlans, nil
+}
+
+func (db *DB) GetFloorPlanByID(floorPlanID int) (*models.FloorPlan, error) {
+	query := `
+	SELECT fp.floorplanid, fp.floorid, fp.buildingid, b.buildingcode, b.siteid, f.floorname, f.floorlevel, fp.imagepath, fp.createdat
+	FROM FloorPlanT fp
+	JOIN floorT f ON fp.floorid = f.floorid
+	JOIN buildingT b ON fp.buildingid = b.buildingid
+	WHERE fp.floorplanid = $1
+	`
+	args := []interface{}{floorPlanID}
+	query += db.scope(&args, siteScope, "b.siteid")
+
+	var floorPlan models.FloorPlan
+	err := db.QueryRow(query, args...).Scan(
+		&floorPlan.FloorPlanID,
+		&floorPlan.FloorID,
+		&floorPlan.BuildingID,
+		&floorPlan.BuildingCode,
+		&floorPlan.SiteID,
+		&floorPlan.FloorLabel,
+		&floorPlan.FloorLevel,
+		&floorPlan.ImagePath,
+		&floorPlan.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &floorPlan, nil
+}
+
+// AddFloorPlan inserts the plan of a floor and returns the new floor plan ID, the building comes from the floor.
+// sql.ErrNoRows is returned if the floor does not exist.
+func (db *DB) AddFloorPlan(floorPlan *models.FloorPlan) (int, error) {
+	if err := db.inOrganisation(floorScope, floorPlan.FloorID); err != nil {
+		return 0, err
+	}
+
+	query := `
+	INSERT INTO FloorPlanT (BuildingID, FloorID, ImagePath)
+	SELECT BuildingID, FloorID, $2 FROM FloorT WHERE FloorID = $1
+	RETURNING FloorPlanID
+	`
+
+	var floorPlanID int
+	err := db.QueryRow(query,
+		floorPlan.FloorID,
+		floorPlan.ImagePath,
+	).Scan(&floorPlanID)
+	if err != nil {
+		return 0, err
+	}
+
+	return floorPlanID, nil
+}
+
+// DeleteFloorPlan deletes a floor plan together with its pins
+func (db *DB) DeleteFloorPlan(floorPlanID int) error {
+	args := []interface{}{floorPlanID}
+	query := "DELETE FROM FloorPlanT WHERE FloorPlanID = $1" + db.scope(&args, buildingScope, "BuildingID")
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetFloorPlanPins returns the room and device pins of a floor plan with the current status of what they mark.
+// Room pins take the most urgent status of the active devices in the room.
+// Decommissioned devices and archived rooms are left off the plan.
+func (db *DB) GetFloorPlanPins(floorPlanID int) ([]models.FloorPlanPin, error) {
+	query := `
+	SELECT p.floorplanpinid, p.floorplanid, p.roomid, COALESCE(r.roomcode, dr.roomcode), p.emergencydeviceid,
+		   edt.emergencydevicetypename, ed.serialnumber, p.x, p.y,
+		   CASE
+		       WHEN p.emergencydeviceid IS NOT NULL THEN ed.status
+		       ELSE (
+		           SELECT rd.status
+		           FROM emergency_deviceT rd
+		           WHERE rd.roomid = p.roomid AND rd.decommissionedat IS NULL
+		           ORDER BY CASE rd.status
+		               WHEN 'Inspection Failed' THEN 0
+		               WHEN 'Expired' THEN 1
+		               WHEN 'Inspection Due' THEN 2
+		               WHEN 'Active' THEN 4
+		               ELSE 3
+		           END
+		           LIMIT 1
+		       )
+		   END AS status
+	FROM FloorPlanPinT p
+	LEFT JOIN roomT r ON p.roomid = r.roomid
+	LEFT JOIN emergency_deviceT ed ON p.emergencydeviceid = ed.emergencydeviceid
+	LEFT JOIN roomT dr ON ed.roomid = dr.roomid
+	LEFT JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
+	WHERE p.floorplanid = $1
+		AND (p.roomid IS NULL OR r.archivedat IS NULL)
+		AND (p.emergencydeviceid IS NULL OR ed.decommissionedat IS NULL)
+	`
+	args := []interface{}{floorPlanID}
+	query += db.scope(&args, floorPlanScope, "p.floorplanid")
+	query += ` ORDER BY p.floorplanpinid`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []models.FloorPlanPin{}
+	for rows.Next() {
+		var pin models.FloorPlanPin
+		err := rows.Scan(
+			&pin.FloorPlanPinID,
+			&pin.FloorPlanID,
+			&pin.RoomID,
+			&pin.RoomCode,
+			&pin.EmergencyDeviceID,
+			&pin.EmergencyDeviceTypeName,
+			&pin.SerialNumber,
+			&pin.X,
+			&pin.Y,
+			&pin.Status,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pins, nil
+}
+
+// AddFloorPlanPin places a room or device on a floor plan and returns the new pin ID
+func (db *DB) AddFloorPlanPin(pin *models.FloorPlanPin) (int, error) {
+	if err := db.inOrganisation(floorPlanScope, pin.FloorPlanID); err != nil {
+		return 0, err
+	}
+	if pin.RoomID.Valid {
+		if err := db.inOrganisation(roomScope, pin.RoomID.Int64); err != nil {
+			return 0, err
+		}
+	}
+	if pin.EmergencyDeviceID.Valid {
+		if err := db.inOrganisation(deviceScope, pin.EmergencyDeviceID.Int64); err != nil {
+			return 0, err
+		}
+	}
+
+	query := `
+	INSERT INTO FloorPlanPinT (FloorPlanID, RoomID, EmergencyDeviceID, X, Y)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING FloorPlanPinID
+	`
+
+	var floorPlanPinID int
+	err := db.QueryRow(query,
+		pin.FloorPlanID,
+		pin.RoomID,
+		pin.EmergencyDeviceID,
+		pin.X,
+		pin.Y,
+	).Scan(&floorPlanPinID)
+	if err != nil {
+		return 0, err
+	}
+
+	return floorPlanPinID, nil
+}
+
+// MoveFloorPlanPin updates the position of a pin, sql.ErrNoRows is returned if the pin does not exist
+func (db *DB) MoveFloorPlanPin(floorPlanPinID int, x float64, y float64) error {
+	query := "UPDATE FloorPlanPinT SET X = $1, Y = $2 WHERE FloorPlanPinID = $3"
+	args := []interface{}{x, y, floorPlanPinID}
+	query += db.scope(&args, floorPlanScope, "FloorPlanID")
+
+	updateStmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	defer updateStmt.Close()
+
+	result, err := updateStmt.Exec(args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteFloorPlanPin removes a pin, sql.ErrNoRows is returned if the pin does not exist
+func (db *DB) DeleteFloorPlanPin(floorPlanPinID int) error {
+	args := []interface{}{floorPlanPinID}
+	query := "DELETE FROM FloorPlanPinT WHERE FloorPlanPinID = $1" + db.scope(&args, floorPlanScope, "FloorPlanID")
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package database_test
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"testing"
 	"time"
@@ -12,6 +13,12 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// expectFilterExistenceChecks mocks the building and site lookups GetAllDevices makes before listing devices
+func expectFilterExistenceChecks(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("^SELECT EXISTS (.+) FROM buildingT").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("^SELECT EXISTS (.+) FROM siteT").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+}
+
 func TestGetAllDevices(t *testing.T) {
 	testCases := []struct {
 		name            string
@@ -24,7 +31,7 @@ func TestGetAllDevices(t *testing.T) {
 			expectedDevices: []models.EmergencyDevice{
 				{
 					EmergencyDeviceID:       1,
-					EmergencyDeviceTypeName: "TypeA",
+					EmergencyDeviceTypeName: "Fire Extinguisher",
 					ExtinguisherTypeName:    sql.NullString{String: "ExtinguisherA", Valid: true},
 					RoomCode:                "Room101",
 					SerialNumber:            sql.NullString{String: "SN123", Valid: true},
@@ -81,7 +88,7 @@ func TestGetAllDevices(t *testing.T) {
 			expectedDevices: []models.EmergencyDevice{
 				{
 					EmergencyDeviceID:       4,
-					EmergencyDeviceTypeName: "TypeD",
+					EmergencyDeviceTypeName: "Fire Extinguisher",
 					ExtinguisherTypeName:    sql.NullString{String: "ExtinguisherE", Valid: true},
 					RoomCode:                "D104",
 					SerialNumber:            sql.NullString{String: "SN789", Valid: true},
@@ -99,6 +106,7 @@ func TestGetAllDevices(t *testing.T) {
 		{
 			name: "TestFetchAllDevices with query error",
 			mockSetup: func(mock sqlmock.Sqlmock) {
+				expectFilterExistenceChecks(mock)
 				mock.ExpectQuery("^SELECT (.+) FROM emergency_deviceT").WillReturnError(errors.New("database error"))
 			},
 			expectedError: errors.New("database error"),
@@ -111,24 +119,51 @@ func TestGetAllDevices(t *testing.T) {
 					"emergencydevicetypename",
 					"extinguishertypename",
 					"roomname",
+					"floorid",
+					"floorname",
+					"buildingcode",
+					"siteid",
+					"sitename",
+					"timezone",
 					"serialnumber",
 					"manufacturedate",
 					"lastinspectiondate",
 					"description",
 					"size",
 					"status",
+					"decommissionedat",
+					"decommissionreason",
+					"inspectionintervalmonths",
+					"serviceintervalmonths",
+					"servicedate",
+					"nextservicedate",
+					"version",
 				}).AddRow(
 					"invalid", // This will cause a scan error as it's not an int
 					"TypeA",
 					sql.NullString{String: "ExtinguisherA", Valid: true},
 					"Room101",
+					1,
+					"Ground",
+					"A",
+					1,
+					"Taradale",
+					"UTC",
 					sql.NullString{String: "SN123", Valid: true},
 					sql.NullTime{Time: time.Now(), Valid: true},
 					sql.NullTime{Time: time.Now(), Valid: true},
 					sql.NullString{String: "Description", Valid: true},
 					sql.NullString{String: "10kg", Valid: true},
 					sql.NullString{String: "Active", Valid: true},
+					nil,
+					nil,
+					3,
+					nil,
+					nil,
+					nil,
+					1,
 				)
+				expectFilterExistenceChecks(mock)
 				mock.ExpectQuery("^SELECT (.+) FROM emergency_deviceT").WillReturnRows(rows)
 			},
 			expectedError: errors.New("sql: Scan error on column index 0, name \"emergencydeviceid\": converting driver.Value type string (\"invalid\") to a int: invalid syntax"),
@@ -153,12 +188,25 @@ func TestGetAllDevices(t *testing.T) {
 					"emergencydevicetypename",
 					"extinguishertypename",
 					"roomname",
+					"floorid",
+					"floorname",
+					"buildingcode",
+					"siteid",
+					"sitename",
+					"timezone",
 					"serialnumber",
 					"manufacturedate",
 					"lastinspectiondate",
 					"description",
 					"size",
 					"status",
+					"decommissionedat",
+					"decommissionreason",
+					"inspectionintervalmonths",
+					"serviceintervalmonths",
+					"servicedate",
+					"nextservicedate",
+					"version",
 				})
 
 				for _, device := range tc.expectedDevices {
@@ -167,19 +215,33 @@ func TestGetAllDevices(t *testing.T) {
 						device.EmergencyDeviceTypeName,
 						device.ExtinguisherTypeName,
 						device.RoomCode,
+						device.FloorID,
+						device.FloorName,
+						device.BuildingCode,
+						device.SiteID,
+						device.SiteName,
+						"UTC",
 						device.SerialNumber,
 						device.ManufactureDate,
 						device.LastInspectionDateTime,
 						device.Description,
 						device.Size,
 						device.Status,
+						device.DecommissionedAt,
+						device.DecommissionReason,
+						3,
+						nil,
+						device.LastServiceDate,
+						nil,
+						1,
 					)
 				}
 
+				expectFilterExistenceChecks(mock)
 				mock.ExpectQuery("^SELECT (.+) FROM emergency_deviceT").WillReturnRows(rows)
 			}
 
-			actualDevices, err := dbInstance.GetAllDevices("your_building_code", "your_site_name")
+			actualDevices, err := dbInstance.GetAllDevices("your_building_code", "your_site_name", "")
 
 			if tc.expectedError != nil {
 				assert.Error(t, err)
@@ -210,3 +272,362 @@ func TestGetAllDevices(t *testing.T) {
 		})
 	}
 }
+
+func TestDecommissionEmergencyDevice(t *testing.T) {
+	testCases := []struct {
+		name          string
+		version       int
+		rowsAffected  int64
+		exists        bool
+		expectedError error
+	}{
+		{
+			name:         "TestDecommissionEmergencyDevice with active device",
+			rowsAffected: 1,
+		},
+		{
+			name:          "TestDecommissionEmergencyDevice with missing or decommissioned device",
+			rowsAffected:  0,
+			expectedError: sql.ErrNoRows,
+		},
+		{
+			name:         "TestDecommissionEmergencyDevice at the current version",
+			version:      2,
+			rowsAffected: 1,
+		},
+		{
+			name:          "TestDecommissionEmergencyDevice changed since it was read",
+			version:       2,
+			rowsAffected:  0,
+			exists:        true,
+			expectedError: database.ErrVersionConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create SQL mock: %v", err)
+			}
+			defer db.Close()
+
+			dbInstance := &database.DB{DB: db}
+
+			args := []driver.Value{"Replaced after failed inspection", 1}
+			if tc.version != 0 {
+				args = append(args, tc.version)
+			}
+			mock.ExpectPrepare("UPDATE emergency_deviceT").
+				ExpectExec().
+				WithArgs(args...).
+				WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+			if tc.version != 0 && tc.rowsAffected == 0 {
+				mock.ExpectQuery("SELECT EXISTS").
+					WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.exists))
+			}
+
+			err = dbInstance.DecommissionEmergencyDevice(1, "Replaced after failed inspection", tc.version)
+
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReplaceEmergencyDevice(t *testing.T) {
+	oldDeviceColumns := []string{"emergencydevicetypeid", "roomid", "extinguishertypeid", "description", "size", "decommissionedat", "version"}
+
+	t.Run("TestReplaceEmergencyDevice carries over room, type and description", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create SQL mock: %v", err)
+		}
+		defer db.Close()
+
+		dbInstance := &database.DB{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM emergency_deviceT (.+) FOR UPDATE").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(oldDeviceColumns).AddRow(2, 3, 4, "Corridor by lift", "5kg", nil, 1))
+		mock.ExpectQuery("INSERT INTO emergency_deviceT").
+			WithArgs(2, sql.NullInt64{Int64: 4, Valid: true}, 3, sql.NullString{String: "SN999", Valid: true}, sqlmock.AnyArg(),
+				sql.NullString{String: "Corridor by lift", Valid: true}, sql.NullString{String: "5kg", Valid: true},
+				sql.NullString{String: "Active", Valid: true}, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"emergencydeviceid"}).AddRow(10))
+		mock.ExpectExec("UPDATE emergency_deviceT").
+			WithArgs("Replaced by device 10: Failed inspection", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		replacement := &models.EmergencyDevice{SerialNumber: sql.NullString{String: "SN999", Valid: true}}
+		newDeviceID, err := dbInstance.ReplaceEmergencyDevice(1, replacement, "Failed inspection", 1)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 10, newDeviceID)
+		assert.Equal(t, 3, replacement.RoomID)
+		assert.Equal(t, sql.NullInt64{Int64: 1, Valid: true}, replacement.PredecessorDeviceID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("TestReplaceEmergencyDevice with decommissioned device", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create SQL mock: %v", err)
+		}
+		defer db.Close()
+
+		dbInstance := &database.DB{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM emergency_deviceT (.+) FOR UPDATE").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(oldDeviceColumns).AddRow(2, 3, nil, nil, nil, time.Now(), 1))
+		mock.ExpectRollback()
+
+		_, err = dbInstance.ReplaceEmergencyDevice(1, &models.EmergencyDevice{}, "Failed inspection", 0)
+
+		assert.ErrorIs(t, err, database.ErrDeviceDecommissioned)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetAllDevicesDueDates(t *testing.T) {
+	lastInspection := time.Date(2024, time.July, 15, 0, 0, 0, 0, time.UTC)
+	manufactureDate := time.Date(2024, time.January, 10, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name                    string
+		serviceInterval         interface{}
+		lastServiceDate         interface{}
+		recordedNextServiceDate interface{}
+		expectedNextService     sql.NullTime
+		expectedNextDue         sql.NullTime
+	}{
+		{
+			name:            "TestGetAllDevicesDueDates without a service schedule",
+			expectedNextDue: sql.NullTime{Time: time.Date(2024, time.October, 15, 0, 0, 0, 0, time.UTC), Valid: true},
+		},
+		{
+			name:                "TestGetAllDevicesDueDates never serviced is due one interval after manufacture",
+			serviceInterval:     6,
+			expectedNextService: sql.NullTime{Time: time.Date(2024, time.July, 10, 0, 0, 0, 0, time.UTC), Valid: true},
+			expectedNextDue:     sql.NullTime{Time: time.Date(2024, time.July, 10, 0, 0, 0, 0, time.UTC), Valid: true},
+		},
+		{
+			name:                "TestGetAllDevicesDueDates service after inspection",
+			serviceInterval:     12,
+			lastServiceDate:     time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			expectedNextService: sql.NullTime{Time: time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+			expectedNextDue:     sql.NullTime{Time: time.Date(2024, time.October, 15, 0, 0, 0, 0, time.UTC), Valid: true},
+		},
+		{
+			name:                    "TestGetAllDevicesDueDates recorded next service date wins",
+			serviceInterval:         12,
+			lastServiceDate:         time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+			recordedNextServiceDate: time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC),
+			expectedNextService:     sql.NullTime{Time: time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+			expectedNextDue:         sql.NullTime{Time: time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create SQL mock: %v", err)
+			}
+			defer db.Close()
+
+			dbInstance := &database.DB{DB: db}
+
+			rows := sqlmock.NewRows([]string{
+				"emergencydeviceid",
+				"emergencydevicetypename",
+				"extinguishertypename",
+				"roomname",
+				"floorid",
+				"floorname",
+				"buildingcode",
+				"siteid",
+				"sitename",
+				"timezone",
+				"serialnumber",
+				"manufacturedate",
+				"lastinspectiondate",
+				"description",
+				"size",
+				"status",
+				"decommissionedat",
+				"decommissionreason",
+				"inspectionintervalmonths",
+				"serviceintervalmonths",
+				"servicedate",
+				"nextservicedate",
+				"version",
+			}).AddRow(
+				1, "Fire Extinguisher", "CO2", "Room101", 1, "Ground", "A", 1, "Taradale", "UTC", "SN123",
+				manufactureDate, lastInspection, nil, nil, "Active", nil, nil,
+				3, tc.serviceInterval, tc.lastServiceDate, tc.recordedNextServiceDate, 1,
+			)
+
+			expectFilterExistenceChecks(mock)
+			mock.ExpectQuery("^SELECT (.+) FROM emergency_deviceT").WillReturnRows(rows)
+
+			devices, err := dbInstance.GetAllDevices("your_building_code", "your_site_name", "")
+			assert.NoError(t, err)
+			assert.Len(t, devices, 1)
+			assert.Equal(t, sql.NullTime{Time: time.Date(2024, time.October, 15, 0, 0, 0, 0, time.UTC), Valid: true}, devices[0].NextInspectionDate)
+			assert.Equal(t, tc.expectedNextService, devices[0].NextServiceDate)
+			assert.Equal(t, tc.expectedNextDue, devices[0].NextDueDate)
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMoveFloorPlanPin(t *testing.T) {
+	testCases := []struct {
+		name          string
+		rowsAffected  int64
+		expectedError error
+	}{
+		{
+			name:         "TestMoveFloorPlanPin with existing pin",
+			rowsAffected: 1,
+		},
+		{
+			name:          "TestMoveFloorPlanPin with missing pin",
+			rowsAffected:  0,
+			expectedError: sql.ErrNoRows,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create SQL mock: %v", err)
+			}
+			defer db.Close()
+
+			dbInstance := &database.DB{DB: db}
+
+			mock.ExpectPrepare("UPDATE FloorPlanPinT").
+				ExpectExec().
+				WithArgs(0.25, 0.75, 1).
+				WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+
+			err = dbInstance.MoveFloorPlanPin(1, 0.25, 0.75)
+
+			if tc.expectedError != nil {
+				assert.ErrorIs(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetAllBuildings(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	defer db.Close()
+
+	dbInstance := &database.DB{DB: db}
+
+	rows := sqlmock.NewRows([]string{"buildingid", "buildingcode", "siteid", "sitename", "mapx", "mapy", "mappolygon", "version"}).
+		AddRow(1, "A", 1, "EIT Taradale", 0.5793, 0.4143, nil, 1).
+		AddRow(2, "New", 1, "EIT Taradale", nil, nil, nil, 1)
+
+	mock.ExpectQuery("^SELECT (.+) FROM buildingT b").
+		WithArgs("1").
+		WillReturnRows(rows)
+
+	buildings, err := dbInstance.GetAllBuildings("1")
+	assert.NoError(t, err)
+	assert.Len(t, buildings, 2)
+	assert.Equal(t, sql.NullFloat64{Float64: 0.5793, Valid: true}, buildings[0].MapX)
+	assert.Equal(t, sql.NullFloat64{Float64: 0.4143, Valid: true}, buildings[0].MapY)
+	assert.False(t, buildings[1].MapX.Valid, "Buildings without a position have no map coordinates")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTx(t *testing.T) {
+	oldDeviceColumns := []string{"emergencydevicetypeid", "roomid", "extinguishertypeid", "description", "size", "decommissionedat", "version"}
+
+	t.Run("TestWithTx runs repository transactions as savepoints and commits once", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create SQL mock: %v", err)
+		}
+		defer db.Close()
+
+		dbInstance := &database.DB{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("^SAVEPOINT repository").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT (.+) FROM emergency_deviceT (.+) FOR UPDATE").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(oldDeviceColumns).AddRow(2, 3, nil, nil, nil, nil, 1))
+		mock.ExpectQuery("INSERT INTO emergency_deviceT").
+			WillReturnRows(sqlmock.NewRows([]string{"emergencydeviceid"}).AddRow(10))
+		mock.ExpectExec("UPDATE emergency_deviceT").
+			WithArgs("Replaced by device 10: Failed inspection", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("^RELEASE SAVEPOINT repository").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE emergency_devicet").
+			WithArgs("Inactive", 10).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err = dbInstance.WithTx(func(tx database.Store) error {
+			newDeviceID, err := tx.ReplaceEmergencyDevice(1, &models.EmergencyDevice{}, "Failed inspection", 0)
+			if err != nil {
+				return err
+			}
+			return tx.UpdateDeviceStatus(newDeviceID, "Inactive")
+		})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("TestWithTx rolls back when the function fails", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("Failed to create SQL mock: %v", err)
+		}
+		defer db.Close()
+
+		dbInstance := &database.DB{DB: db}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("^SAVEPOINT repository").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT (.+) FROM emergency_deviceT (.+) FOR UPDATE").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(oldDeviceColumns).AddRow(2, 3, nil, nil, nil, time.Now(), 1))
+		mock.ExpectExec("^ROLLBACK TO SAVEPOINT repository").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err = dbInstance.WithTx(func(tx database.Store) error {
+			_, err := tx.ReplaceEmergencyDevice(1, &models.EmergencyDevice{}, "Failed inspection", 0)
+			return err
+		})
+
+		assert.ErrorIs(t, err, database.ErrDeviceDecommissioned)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// UserRepository is the data access for system users
+type UserRepository interface {
+	GetAllUsers() ([]models.User, error)
+	CreateUser(user *models.User) error
+	RegisterUser(user *models.User) error
+	UpdateUserWithPassword(user *models.User) error
+	UpdateUser(user *models.User) error
+	GetUserByUsername(username string) (*models.User, error)
+	GetUserByID(userid int) (*models.User, error)
+	DeleteUser(userid int, version int) error
+	UpdatePassword(userid int, password string) error
+	GetUserByEmail(email string) (*models.User, error)
+}
+
+// DeviceRepository is the data access for emergency devices and their lifecycle
+type DeviceRepository interface {
+	GetAllDevices(siteId string, buildingCode string, floorId string) ([]models.EmergencyDevice, error)
+	GetDecommissionedDevices(siteId string, buildingCode string, floorId string) ([]models.EmergencyDevice, error)
+	GetDeviceByID(deviceID int) (*models.EmergencyDevice, error)
+	GetDevicesByRoomID(roomID int) ([]models.EmergencyDevice, error)
+	GetDevicesByTypeID(emergencyDeviceTypeID int) ([]models.EmergencyDevice, error)
+	AddEmergencyDevice(device *models.EmergencyDevice) error
+	UpdateEmergencyDevice(device *models.EmergencyDevice) error
+	UpdateDeviceStatus(deviceID int, status string) error
+	DecommissionEmergencyDevice(deviceID int, reason string, version int) error
+	PurgeEmergencyDevice(deviceID int) error
+	ReplaceEmergencyDevice(oldDeviceID int, replacement *models.EmergencyDevice, reason string, version int) (int, error)
+	GetDeviceReplacementChain(deviceID int) ([]models.EmergencyDevice, error)
+}
+
+// DeviceTypeRepository is the data access for emergency device types and extinguisher types
+type DeviceTypeRepository interface {
+	GetAllDeviceTypes() ([]models.EmergencyDeviceType, error)
+	GetEmergencyDeviceTypeByID(emergencyDeviceTypeID int) (*models.EmergencyDeviceType, error)
+	GetDeviceTypeByName(emergencyDeviceTypeName string) (*models.EmergencyDeviceType, error)
+	AddEmergencyDeviceType(emergencyDeviceType *models.EmergencyDeviceType) error
+	UpdateEmergencyDeviceType(emergencyDeviceType *models.EmergencyDeviceType) error
+	DeleteEmergencyDeviceType(emergencyDeviceTypeID int, version int) error
+	GetAllExtinguisherTypes() ([]models.ExtinguisherType, error)
+	GetExtinguisherTypeByID(extinguisherTypeID int) (*models.ExtinguisherType, error)
+}
+
+// LocationRepository is the data access for sites, buildings, floors and rooms
+type LocationRepository interface {
+	GetAllSites() ([]models.Site, error)
+	GetSiteByID(siteID string) (*models.Site, error)
+	GetSiteByName(siteName string) (*models.Site, error)
+	AddSite(site *models.Site) error
+	UpdateSite(site *models.Site) error
+	ArchiveSite(siteID string, reason string, version int) error
+
+	GetAllBuildings(siteId string) ([]models.Building, error)
+	GetBuildingById(buildingID int) (*models.Building, error)
+	GetBuildingByCodeandSite(buildingCode string, siteId int) (*models.Building, error)
+	AddBuilding(building *models.Building) error
+	UpdateBuilding(building *models.Building) error
+	ArchiveBuilding(buildingID string, reason string, version int) error
+
+	GetFloorsByBuildingID(buildingID int) ([]models.Floor, error)
+	GetFloorByID(floorID int) (*models.Floor, error)
+	AddFloor(floor *models.Floor) (int, error)
+	UpdateFloor(floor *models.Floor) error
+	DeleteFloor(floorID int, version int) error
+
+	GetAllRooms(buildingId string, floorId string) ([]models.Room, error)
+	GetRoomsByBuildingID(buildingID string) ([]models.Room, error)
+	GetRoomsBySiteID(siteID string) ([]models.Room, error)
+	GetRoomByID(roomID int) (*models.Room, error)
+	GetRoomByCodeAndSite(roomCode string, siteId int) (*models.Room, error)
+	GetRoomByCodeAndBuilding(roomCode string, buildingId int) (*models.Room, error)
+	AddRoom(room *models.Room) error
+	UpdateRoom(room *models.Room) error
+	ArchiveRoom(roomID int, reason string, version int) error
+}
+
+// InspectionRepository is the data access for device inspections
+type InspectionRepository interface {
+	GetAllInspectionsByDeviceID(deviceID int) ([]models.Inspection, error)
+	GetInspectionByID(inspectionID int) (*models.Inspection, error)
+	GetInspectionByClientID(clientID string) (*models.Inspection, error)
+	AddInspection(inspection *models.Inspection) error
+	CountRecentInspections(within time.Duration) (int, error)
+}
+
+// InspectionRoundRepository is the data access for the inspection rounds assigned to inspectors
+type InspectionRoundRepository interface {
+	GetInspectionRounds(userID int) ([]models.InspectionRound, error)
+	GetInspectionRoundByID(roundID int) (*models.InspectionRound, error)
+	AddInspectionRound(round *models.InspectionRound) (int, error)
+	AssignInspectionRound(roundID int, userID int) error
+	SkipInspectionRoundDevice(roundID int, deviceID int, reason string) error
+}
+
+// MaintenanceRepository is the data access for contractor maintenance records
+type MaintenanceRepository interface {
+	GetMaintenanceRecordsByDeviceID(deviceID int) ([]models.MaintenanceRecord, error)
+	GetMaintenanceRecordByID(maintenanceRecordID int) (*models.MaintenanceRecord, error)
+	AddMaintenanceRecord(record *models.MaintenanceRecord) (int, error)
+}
+
+// FloorPlanRepository is the data access for building floor plans and their pins
+type FloorPlanRepository interface {
+	GetFloorPlansByBuildingID(buildingID int) ([]models.FloorPlan, error)
+	GetFloorPlanByID(floorPlanID int) (*models.FloorPlan, error)
+	AddFloorPlan(floorPlan *models.FloorPlan) (int, error)
+	DeleteFloorPlan(floorPlanID int) error
+	GetFloorPlanPins(floorPlanID int) ([]models.FloorPlanPin, error)
+	AddFloorPlanPin(pin *models.FloorPlanPin) (int, error)
+	MoveFloorPlanPin(floorPlanPinID int, x float64, y float64) error
+	DeleteFloorPlanPin(floorPlanPinID int) error
+}
+
+// SyncRepository is the data access for the offline mobile clients
+type SyncRepository interface {
+	// GetSyncChanges returns what changed since the cursor of an earlier sync, everything for 0
+	GetSyncChanges(since int64) (*models.SyncChanges, error)
+}
+
+// CalendarRepository is the data access for the calendar subscription links of users
+type CalendarRepository interface {
+	SetCalendarFeed(userID int, organisationID int, tokenHash string) error
+	GetCalendarFeed(tokenHash string) (*models.CalendarFeed, error)
+	DeleteCalendarFeed(userID int, organisationID int) error
+}
+
+// WebhookRepository is the data access for outbound webhooks and their outbox of deliveries
+type WebhookRepository interface {
+	GetAllWebhooks() ([]models.Webhook, error)
+	GetWebhookByID(webhookID int) (*models.Webhook, error)
+	AddWebhook(webhook *models.Webhook) (int, error)
+	UpdateWebhook(webhook *models.Webhook) error
+	DeleteWebhook(webhookID int, version int) error
+	// AddWebhookEvent queues an event for the active webhooks of its device's organisation that subscribe to its type
+	// and returns how many deliveries were queued
+	AddWebhookEvent(event *models.WebhookEvent) (int, error)
+	// ClaimWebhookDeliveries returns up to limit pending deliveries due by now, oldest first, and holds them back
+	// until now plus lease so they are only sent once at a time
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error)
+	// RecordWebhookAttempt adds an attempt to the delivery log and moves its delivery on to status,
+	// to be tried again at nextAttemptAt while it is pending
+	RecordWebhookAttempt(attempt *models.WebhookAttempt, status string, nextAttemptAt sql.NullTime) error
+	// GetWebhookDeliveries returns the latest deliveries of a webhook with their attempts
+	GetWebhookDeliveries(webhookID int, limit int) ([]models.WebhookDelivery, error)
+	// RedeliverWebhookDelivery queues a finished delivery to be sent again with a fresh set of retries
+	RedeliverWebhookDelivery(deliveryID int) error
+}
+
+// StatsRepository is the data access for the dashboard statistics
+type StatsRepository interface {
+	// GetInspectionCounts counts the inspections carried out from the first to the last day given, at the local
+	// time of each device's site, optionally at one site. Inspections of decommissioned devices are counted.
+	GetInspectionCounts(siteId string, from time.Time, to time.Time) (*models.InspectionCounts, error)
+}
+
+// SearchRepository is the data access for searching devices, rooms and inspection notes
+type SearchRepository interface {
+	// Search returns a page of the devices, rooms and inspections that contain the search text or are
+	// similar to it, best match first, without highlights
+	Search(query string, limit int, offset int) (*models.SearchResults, error)
+}
+
+// SavedViewRepository is the data access for the device list views users save, share and open the dashboard with.
+// Views are read for a user, who sees the views they own and those shared with them, and only the owner can
+// change or delete a view.
+type SavedViewRepository interface {
+	GetSavedViews(userID int) ([]models.SavedView, error)
+	GetSavedViewByID(savedViewID int, userID int) (*models.SavedView, error)
+	// GetDefaultSavedView returns the view the user opens the dashboard with, sql.ErrNoRows when they have none
+	GetDefaultSavedView(userID int) (*models.SavedView, error)
+	// AddSavedView adds a view owned by its UserID to the store's organisation and returns its ID
+	AddSavedView(view *models.SavedView) (int, error)
+	// UpdateSavedView changes a view owned by its UserID, including who it is shared with.
+	// Users it is no longer shared with lose it as their default.
+	UpdateSavedView(view *models.SavedView) error
+	DeleteSavedView(savedViewID int, userID int, version int) error
+	SetDefaultSavedView(userID int, savedViewID int) error
+	ClearDefaultSavedView(userID int) error
+}
+
+// DependentsRepository is the data access for what stops sites, buildings and rooms from being archived,
+// and for moving it to another site, building or room first
+type DependentsRepository interface {
+	GetSiteDependents(siteID int) (*models.Dependents, error)
+	GetBuildingDependents(buildingID int) (*models.Dependents, error)
+	GetRoomDependents(roomID int) (*models.Dependents, error)
+	// MoveSiteBuildings moves the buildings of a site, with their rooms and devices, to another site
+	MoveSiteBuildings(fromSiteID int, toSiteID int) error
+	// MoveBuildingRooms moves the rooms of a building, with their devices, to the default floor of another building
+	MoveBuildingRooms(fromBuildingID int, toBuildingID int) error
+	// MoveRoomDevices moves the in service devices of a room to another room
+	MoveRoomDevices(fromRoomID int, toRoomID int) error
+}
+
+// OrganisationRepository is the data access for organisations and their members
+type OrganisationRepository interface {
+	// ForOrganisation returns a store limited to the data of one organisation
+	ForOrganisation(organisationID int) Store
+	GetAllOrganisations() ([]models.Organisation, error)
+	GetOrganisationByID(organisationID int) (*models.Organisation, error)
+	AddOrganisation(name string) (int, error)
+	GetUserOrganisations(userID int) ([]models.Organisation, error)
+	AddUserToOrganisation(userID int, organisationID int) error
+	RemoveUserFromOrganisation(userID int, organisationID int) error
+}
+
+// UnitOfWork runs operations that change several rows so they are saved together or not at all
+type UnitOfWork interface {
+	// WithTx runs fn in one transaction, committing it when fn returns nil and rolling it back otherwise.
+	// The store fn is given joins the transaction; WithTx on it runs in the same transaction.
+	WithTx(fn func(tx Store) error) error
+}
+
+// Store is everything the application needs from the database.
+// DB implements it on PostgreSQL and MemoryStore implements it in memory for tests.
+// Stores see every organisation, until ForOrganisation limits them to one.
+type Store interface {
+	OrganisationRepository
+	UserRepository
+	DeviceRepository
+	DeviceTypeRepository
+	LocationRepository
+	InspectionRepository
+	InspectionRoundRepository
+	MaintenanceRepository
+	FloorPlanRepository
+	SyncRepository
+	CalendarRepository
+	WebhookRepository
+	StatsRepository
+	SearchRepository
+	SavedViewRepository
+	DependentsRepository
+	UnitOfWork
+}
+
+// Both implementations must keep up with the interfaces
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+const inspectionRoundQuery = `
+	SELECT ir.inspectionroundid, ir.siteid, s.sitename, ir.buildingid, b.buildingcode, ir.userid, u.username,
+		ir.periodstart, ir.periodend, ir.createdat,
+		COUNT(rd.emergencydeviceid) FILTER (WHERE rd.status = 'Inspected'),
+		COUNT(rd.emergencydeviceid) FILTER (WHERE rd.status = 'Skipped'),
+		COUNT(rd.emergencydeviceid) FILTER (WHERE rd.status = 'Remaining')
+	FROM InspectionRoundT ir
+	JOIN siteT s ON ir.siteid = s.siteid
+	LEFT JOIN buildingT b ON ir.buildingid = b.buildingid
+	LEFT JOIN userT u ON ir.userid = u.userid
+	LEFT JOIN InspectionRoundDeviceT rd ON ir.inspectionroundid = rd.inspectionroundid
+	`
+
+func scanInspectionRound(scanner interface{ Scan(...interface{}) error }, round *models.InspectionRound) error {
+	return scanner.Scan(
+		&round.InspectionRoundID,
+		&round.SiteID,
+		&round.SiteName,
+		&round.BuildingID,
+		&round.BuildingCode,
+		&round.UserID,
+		&round.InspectorName,
+		&round.PeriodStart,
+		&round.PeriodEnd,
+		&round.CreatedAt,
+		&round.Inspected,
+		&round.Skipped,
+		&round.Remaining,
+	)
+}
+
+// GetInspectionRounds returns the rounds assigned to a user, every round for 0, the latest period first
+func (db *DB) GetInspectionRounds(userID int) ([]models.InspectionRound, error) {
+	var args []interface{}
+	query := inspectionRoundQuery + `WHERE true` + db.scope(&args, organisationScope, "s.organisationid")
+	if userID != 0 {
+		args = append(args, userID)
+		query += fmt.Sprintf(` AND ir.userid = $%d`, len(args))
+	}
+	query += `
+	GROUP BY ir.inspectionroundid, s.sitename, b.buildingcode, u.username
+	ORDER BY ir.periodstart DESC, s.sitename, b.buildingcode NULLS FIRST, ir.inspectionroundid`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rounds := []models.InspectionRound{}
+	for rows.Next() {
+		var round models.InspectionRound
+		if err := scanInspectionRound(rows, &round); err != nil {
+			return nil, err
+		}
+		rounds = append(rounds, round)
+	}
+
+	return rounds, rows.Err()
+}
+
+// GetInspectionRoundByID returns a round with its devices, ordered by room like the inspector walks them
+func (db *DB) GetInspectionRoundByID(roundID int) (*models.InspectionRound, error) {
+	args := []interface{}{roundID}
+	query := inspectionRoundQuery + `WHERE ir.inspectionroundid = $1` + db.scope(&args, organisationScope, "s.organisationid") + `
+	GROUP BY ir.inspectionroundid, s.sitename, b.buildingcode, u.username`
+
+	var round models.InspectionRound
+	if err := scanInspectionRound(db.QueryRow(query, args...), &round); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+	SELECT rd.emergencydeviceid, edt.emergencydevicetypename, ed.serialnumber, r.roomid, r.roomcode, f.floorname, b.buildingcode,
+		rd.status, rd.skipreason, rd.emergencydeviceinspectionid
+	FROM InspectionRoundDeviceT rd
+	JOIN emergency_deviceT ed ON rd.emergencydeviceid = ed.emergencydeviceid
+	JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN floorT f ON r.floorid = f.floorid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	WHERE rd.inspectionroundid = $1
+	ORDER BY b.buildingcode, f.floorlevel, r.roomcode, rd.emergencydeviceid
+	`, roundID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	round.Devices = []models.InspectionRoundDevice{}
+	for rows.Next() {
+		var device models.InspectionRoundDevice
+		err := rows.Scan(
+			&device.EmergencyDeviceID,
+			&device.EmergencyDeviceTypeName,
+			&device.SerialNumber,
+			&device.RoomID,
+			&device.RoomCode,
+			&device.FloorName,
+			&device.BuildingCode,
+			&device.Status,
+			&device.SkipReason,
+			&device.EmergencyDeviceInspectionID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		round.Devices = append(round.Devices, device)
+	}
+
+	return &round, rows.Err()
+}
+
+// AddInspectionRound adds a round of the given devices and returns its ID
+func (db *DB) AddInspectionRound(round *models.InspectionRound) (int, error) {
+	if err := db.inOrganisation(siteScope, round.SiteID); err != nil {
+		return 0, err
+	}
+	if round.BuildingID.Valid {
+		if err := db.inOrganisation(buildingScope, round.BuildingID.Int64); err != nil {
+			return 0, err
+		}
+	}
+	if round.UserID.Valid {
+		if err := db.inOrganisation(userScope, round.UserID.Int64); err != nil {
+			return 0, err
+		}
+	}
+	for _, deviceID := range round.DeviceIDs {
+		if err := db.inOrganisation(deviceScope, deviceID); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	var roundID int
+	err = tx.QueryRow(`
+	INSERT INTO InspectionRoundT (SiteID, BuildingID, UserID, PeriodStart, PeriodEnd)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING InspectionRoundID
+	`, round.SiteID, round.BuildingID, round.UserID, round.PeriodStart.Format("2006-01-02"), round.PeriodEnd.Format("2006-01-02")).Scan(&roundID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, deviceID := range round.DeviceIDs {
+		_, err = tx.Exec(`
+		INSERT INTO InspectionRoundDeviceT (InspectionRoundID, EmergencyDeviceID)
+		VALUES ($1, $2)
+		`, roundID, deviceID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return roundID, nil
+}
+
+// AssignInspectionRound assigns a round to a user, 0 leaves it unassigned
+func (db *DB) AssignInspectionRound(roundID int, userID int) error {
+	inspector := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+	if inspector.Valid {
+		if err := db.inOrganisation(userScope, userID); err != nil {
+			return err
+		}
+	}
+
+	args := []interface{}{inspector, roundID}
+	query := `UPDATE InspectionRoundT SET UserID = $1 WHERE InspectionRoundID = $2` +
+		db.scope(&args, siteScope, "SiteID")
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SkipInspectionRoundDevice records why a remaining device of a round was not inspected
+func (db *DB) SkipInspectionRoundDevice(roundID int, deviceID int, reason string) error {
+	args := []interface{}{reason, roundID, deviceID}
+	query := `
+	UPDATE InspectionRoundDeviceT
+	SET Status = 'Skipped', SkipReason = $1
+	WHERE InspectionRoundID = $2 AND EmergencyDeviceID = $3 AND Status = 'Remaining'` +
+		db.scope(&args, deviceScope, "EmergencyDeviceID")
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
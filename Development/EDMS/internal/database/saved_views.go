@@ -0,0 +1,242 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/lib/pq"
+)
+
+// savedViewQuery selects the views user $1 owns or that are shared with them
+const savedViewQuery = `
+	SELECT v.SavedViewID, v.UserID, u.Username, v.OrganisationID, v.Name, v.Filters, v.SortColumn, v.SortDescending,
+		v.Columns,
+		ARRAY(SELECT vs.UserID FROM SavedViewShareT vs WHERE vs.SavedViewID = v.SavedViewID ORDER BY vs.UserID),
+		EXISTS (SELECT 1 FROM DefaultSavedViewT d WHERE d.SavedViewID = v.SavedViewID AND d.UserID = $1),
+		v.CreatedAt, v.UpdatedAt, v.Version
+	FROM SavedViewT v
+	JOIN UserT u ON v.UserID = u.UserID
+	WHERE (v.UserID = $1 OR EXISTS (SELECT 1 FROM SavedViewShareT s WHERE s.SavedViewID = v.SavedViewID AND s.UserID = $1))`
+
+func scanSavedView(scanner interface{ Scan(...interface{}) error }, view *models.SavedView) error {
+	var filters []byte
+	var sharedWith []int64
+	err := scanner.Scan(
+		&view.SavedViewID,
+		&view.UserID,
+		&view.OwnerName,
+		&view.OrganisationID,
+		&view.Name,
+		&filters,
+		&view.SortColumn,
+		&view.SortDescending,
+		pq.Array(&view.Columns),
+		pq.Array(&sharedWith),
+		&view.IsDefault,
+		&view.CreatedAt,
+		&view.UpdatedAt,
+		&view.Version,
+	)
+	if err != nil {
+		return err
+	}
+
+	view.SharedWith = make([]int, 0, len(sharedWith))
+	for _, userID := range sharedWith {
+		view.SharedWith = append(view.SharedWith, int(userID))
+	}
+	return json.Unmarshal(filters, &view.Filters)
+}
+
+// shareSavedView replaces the users a view is shared with
+func shareSavedView(tx conn, savedViewID int, sharedWith []int) error {
+	if _, err := tx.Exec(`DELETE FROM SavedViewShareT WHERE SavedViewID = $1`, savedViewID); err != nil {
+		return err
+	}
+
+	userIDs := make([]int64, 0, len(sharedWith))
+	for _, userID := range sharedWith {
+		userIDs = append(userIDs, int64(userID))
+	}
+	_, err := tx.Exec(`
+	INSERT INTO SavedViewShareT (SavedViewID, UserID)
+	SELECT DISTINCT $1::INT, u FROM unnest($2::INT[]) AS u
+	`, savedViewID, pq.Array(userIDs))
+	return err
+}
+
+// GetSavedViews returns the views of the user by name, theirs and those shared with them
+func (db *DB) GetSavedViews(userID int) ([]models.SavedView, error) {
+	args := []interface{}{userID}
+	query := savedViewQuery + db.scope(&args, organisationScope, "v.OrganisationID") + ` ORDER BY v.Name, v.SavedViewID`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []models.SavedView{}
+	for rows.Next() {
+		var view models.SavedView
+		if err := scanSavedView(rows, &view); err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+
+	return views, rows.Err()
+}
+
+func (db *DB) GetSavedViewByID(savedViewID int, userID int) (*models.SavedView, error) {
+	args := []interface{}{userID, savedViewID}
+	query := savedViewQuery + ` AND v.SavedViewID = $2` + db.scope(&args, organisationScope, "v.OrganisationID")
+
+	var view models.SavedView
+	if err := scanSavedView(db.QueryRow(query, args...), &view); err != nil {
+		return nil, err
+	}
+
+	return &view, nil
+}
+
+// GetDefaultSavedView returns the default view of the user in the store's organisation.
+// A store that sees every organisation returns the one of the first organisation.
+func (db *DB) GetDefaultSavedView(userID int) (*models.SavedView, error) {
+	args := []interface{}{userID}
+	query := savedViewQuery + ` AND v.SavedViewID IN (SELECT SavedViewID FROM DefaultSavedViewT WHERE UserID = $1)` +
+		db.scope(&args, organisationScope, "v.OrganisationID") + ` ORDER BY v.OrganisationID LIMIT 1`
+
+	var view models.SavedView
+	if err := scanSavedView(db.QueryRow(query, args...), &view); err != nil {
+		return nil, err
+	}
+
+	return &view, nil
+}
+
+func (db *DB) AddSavedView(view *models.SavedView) (int, error) {
+	filters, err := json.Marshal(view.Filters)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	var savedViewID int
+	err = tx.QueryRow(`
+	INSERT INTO SavedViewT (UserID, OrganisationID, Name, Filters, SortColumn, SortDescending, Columns)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING SavedViewID
+	`, view.UserID, db.writeOrganisationID(), view.Name, string(filters), view.SortColumn, view.SortDescending,
+		pq.Array(view.Columns)).Scan(&savedViewID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := shareSavedView(tx, savedViewID, view.SharedWith); err != nil {
+		return 0, err
+	}
+
+	return savedViewID, tx.Commit()
+}
+
+func (db *DB) UpdateSavedView(view *models.SavedView) error {
+	filters, err := json.Marshal(view.Filters)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	args := []interface{}{view.Name, string(filters), view.SortColumn, view.SortDescending, pq.Array(view.Columns),
+		view.SavedViewID, view.UserID}
+	query := `
+	UPDATE SavedViewT
+	SET Name = $1, Filters = $2, SortColumn = $3, SortDescending = $4, Columns = $5, UpdatedAt = NOW()
+	WHERE SavedViewID = $6 AND UserID = $7` + db.scope(&args, organisationScope, "OrganisationID") +
+		versionCheck(&args, "Version", view.Version)
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	existsArgs := []interface{}{view.SavedViewID, view.UserID}
+	exists := `SELECT 1 FROM SavedViewT WHERE SavedViewID = $1 AND UserID = $2` +
+		db.scope(&existsArgs, organisationScope, "OrganisationID")
+	if err := checkVersion(tx, result, view.Version, exists, existsArgs...); err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := shareSavedView(tx, view.SavedViewID, view.SharedWith); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	DELETE FROM DefaultSavedViewT d
+	WHERE d.SavedViewID = $1 AND d.UserID <> $2
+		AND NOT EXISTS (SELECT 1 FROM SavedViewShareT s WHERE s.SavedViewID = d.SavedViewID AND s.UserID = d.UserID)
+	`, view.SavedViewID, view.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSavedView deletes a view of the user, it stops being the default of everyone it was shared with
+func (db *DB) DeleteSavedView(savedViewID int, userID int, version int) error {
+	args := []interface{}{savedViewID, userID}
+	query := `DELETE FROM SavedViewT WHERE SavedViewID = $1 AND UserID = $2` +
+		db.scope(&args, organisationScope, "OrganisationID")
+	query += versionCheck(&args, "Version", version)
+
+	existsArgs := []interface{}{savedViewID, userID}
+	exists := `SELECT 1 FROM SavedViewT WHERE SavedViewID = $1 AND UserID = $2` +
+		db.scope(&existsArgs, organisationScope, "OrganisationID")
+	return db.execOneVersion(version, exists, existsArgs, query, args...)
+}
+
+// SetDefaultSavedView makes a view the user can see their default in its organisation, replacing any other
+func (db *DB) SetDefaultSavedView(userID int, savedViewID int) error {
+	args := []interface{}{userID, savedViewID}
+	query := `
+	INSERT INTO DefaultSavedViewT (UserID, OrganisationID, SavedViewID)
+	SELECT $1, v.OrganisationID, v.SavedViewID
+	FROM SavedViewT v
+	WHERE v.SavedViewID = $2
+		AND (v.UserID = $1 OR EXISTS (SELECT 1 FROM SavedViewShareT s WHERE s.SavedViewID = v.SavedViewID AND s.UserID = $1))` +
+		db.scope(&args, organisationScope, "v.OrganisationID") + `
+	ON CONFLICT (UserID, OrganisationID) DO UPDATE SET SavedViewID = EXCLUDED.SavedViewID`
+
+	return db.execOne(query, args...)
+}
+
+// ClearDefaultSavedView leaves the user without a default view in the store's organisation
+func (db *DB) ClearDefaultSavedView(userID int) error {
+	args := []interface{}{userID}
+	query := `DELETE FROM DefaultSavedViewT WHERE UserID = $1` + db.scope(&args, organisationScope, "OrganisationID")
+
+	_, err := db.Exec(query, args...)
+	return err
+}
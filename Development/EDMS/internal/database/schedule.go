@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// DefaultInspectionIntervalMonths is used when a device type has no inspection interval configured
+const DefaultInspectionIntervalMonths = 3
+
+// ExtinguisherLifeYears is how long a fire extinguisher is in service after its manufacture date
+const ExtinguisherLifeYears = 5
+
+// deviceSchedule holds what is needed to calculate the due dates of a device
+type deviceSchedule struct {
+	InspectionIntervalMonths sql.NullInt64 // From emergency_device_typeT table
+	ServiceIntervalMonths    sql.NullInt64 // From emergency_device_typeT table
+	RecordedNextServiceDate  sql.NullTime  // NextServiceDate entered on the latest maintenance record
+}
+
+// siteLocation loads the time zone of a site, config.DefaultTimeZone when the site has none
+func siteLocation(timeZone string) *time.Location {
+	if timeZone == "" {
+		timeZone = config.DefaultTimeZone
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// siteTimeZone is the time zone a site is saved with, sites added without one use config.DefaultTimeZone
+func siteTimeZone(site *models.Site) string {
+	if site.TimeZone == "" {
+		return config.DefaultTimeZone
+	}
+	return site.TimeZone
+}
+
+// siteMidnight is the start of a calendar date in a site's time zone.
+// DATE columns are read as midnight UTC, only their year, month and day are used.
+func siteMidnight(date time.Time, location *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location)
+}
+
+// inLocation converts a stored timestamp to the local time of a site
+func inLocation(t sql.NullTime, location *time.Location) sql.NullTime {
+	if !t.Valid {
+		return t
+	}
+	return sql.NullTime{Time: t.Time.In(location), Valid: true}
+}
+
+// localiseDevice converts the timestamps of a device, stored in UTC, to the local time of its site
+func localiseDevice(device *models.EmergencyDevice) {
+	location := siteLocation(device.SiteTimeZone)
+	device.LastInspectionDateTime = inLocation(device.LastInspectionDateTime, location)
+	device.DecommissionedAt = inLocation(device.DecommissionedAt, location)
+}
+
+// localiseInspection converts the timestamps of an inspection to the local time of the device's site
+func localiseInspection(inspection *models.Inspection) {
+	location := siteLocation(inspection.SiteTimeZone)
+	inspection.InspectionDateTime = inLocation(inspection.InspectionDateTime, location)
+	inspection.CreatedAt = inLocation(inspection.CreatedAt, location)
+}
+
+// applyDueDates calculates the next inspection, next service and overall next due date of a device.
+// The next service date entered by the contractor wins over the device type's service interval.
+// Every due date is midnight at the start of the due day in the site's time zone.
+func applyDueDates(device *models.EmergencyDevice, schedule deviceSchedule) {
+	location := siteLocation(device.SiteTimeZone)
+
+	inspectionInterval := DefaultInspectionIntervalMonths
+	if schedule.InspectionIntervalMonths.Valid {
+		inspectionInterval = int(schedule.InspectionIntervalMonths.Int64)
+	}
+
+	device.NextInspectionDate = sql.NullTime{}
+	if device.LastInspectionDateTime.Valid {
+		// Counted from the day of the inspection at the site, whatever time of day it was carried out
+		inspectedOn := device.LastInspectionDateTime.Time.In(location)
+		device.NextInspectionDate = sql.NullTime{
+			Time:  siteMidnight(inspectedOn, location).AddDate(0, inspectionInterval, 0),
+			Valid: true,
+		}
+	}
+
+	device.NextServiceDate = sql.NullTime{}
+	if schedule.RecordedNextServiceDate.Valid {
+		device.NextServiceDate = sql.NullTime{Time: siteMidnight(schedule.RecordedNextServiceDate.Time, location), Valid: true}
+	} else if schedule.ServiceIntervalMonths.Valid {
+		// Devices that have never been serviced are due one interval after manufacture
+		serviceInterval := int(schedule.ServiceIntervalMonths.Int64)
+		if device.LastServiceDate.Valid {
+			device.NextServiceDate = sql.NullTime{Time: siteMidnight(device.LastServiceDate.Time.AddDate(0, serviceInterval, 0), location), Valid: true}
+		} else if device.ManufactureDate.Valid && !device.ManufactureDate.Time.IsZero() {
+			device.NextServiceDate = sql.NullTime{Time: siteMidnight(device.ManufactureDate.Time.AddDate(0, serviceInterval, 0), location), Valid: true}
+		}
+	}
+
+	// The device is next due for whichever schedule comes first
+	device.NextDueDate = device.NextInspectionDate
+	if device.NextServiceDate.Valid && (!device.NextDueDate.Valid || device.NextServiceDate.Time.Before(device.NextDueDate.Time)) {
+		device.NextDueDate = device.NextServiceDate
+	}
+}
+
+// applyExpireDate sets the expiry date of a device, only fire extinguishers with a manufacture date expire.
+// A device expires at midnight in its site's time zone.
+func applyExpireDate(device *models.EmergencyDevice) {
+	if !device.ManufactureDate.Valid {
+		device.ManufactureDate = sql.NullTime{}
+	}
+
+	device.ExpireDate = sql.NullTime{}
+	if device.EmergencyDeviceTypeName == "Fire Extinguisher" && device.ManufactureDate.Valid && !device.ManufactureDate.Time.IsZero() {
+		expires := siteMidnight(device.ManufactureDate.Time.AddDate(ExtinguisherLifeYears, 0, 0), siteLocation(device.SiteTimeZone))
+		device.ExpireDate = sql.NullTime{Time: expires, Valid: true}
+	}
+}
+
+// statusAfterInspection is the device status once an inspection is recorded, matching the
+// update_device_status_on_inspection trigger. A failed inspection wins over an expired device.
+func statusAfterInspection(current sql.NullString, manufactureDate sql.NullTime, inspectionStatus string, now time.Time, location *time.Location) sql.NullString {
+	switch {
+	case inspectionStatus == "Failed":
+		return sql.NullString{String: "Inspection Failed", Valid: true}
+	case manufactureDate.Valid && !siteMidnight(manufactureDate.Time.AddDate(ExtinguisherLifeYears, 0, 0), location).After(now):
+		return sql.NullString{String: "Expired", Valid: true}
+	case inspectionStatus == "Passed":
+		return sql.NullString{String: "Active", Valid: true}
+	default:
+		return current
+	}
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// WordSimilarityThreshold is how similar a value must be to the search text to match without containing it,
+// the default pg_trgm.word_similarity_threshold used by the <% operator
+const WordSimilarityThreshold = 0.6
+
+// likeEscaper escapes the search text for ILIKE ... ESCAPE '\', so % and _ are matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// trigrams returns the trigrams of a value in order the way pg_trgm makes them: from lower case runs of
+// letters and digits, each padded with two spaces in front and one behind
+func trigrams(value string) []string {
+	var list []string
+	words := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			list = append(list, string(padded[i:i+3]))
+		}
+	}
+	return list
+}
+
+// WordSimilarity is pg_trgm's word_similarity(): how similar the search text is to the most similar continuous
+// run of trigrams of the value, from 0 to 1. It follows iterate_word_similarity in pg_trgm, calculated as a real.
+func WordSimilarity(query string, value string) float64 {
+	found := map[string]bool{}
+	for _, trigram := range trigrams(query) {
+		found[trigram] = true
+	}
+	ulen1 := len(found)
+	valueTrigrams := trigrams(value)
+
+	similarity := func(count int, ulen2 int) float32 {
+		return float32(count) / float32(ulen1+ulen2-count)
+	}
+
+	lastPos := map[string]int{}
+	position := func(trigram string) int {
+		if i, ok := lastPos[trigram]; ok {
+			return i
+		}
+		return -1
+	}
+
+	var max float32
+	ulen2, count, lower := 0, 0, -1
+	for i, trigram := range valueTrigrams {
+		if lower >= 0 || found[trigram] {
+			if position(trigram) < 0 {
+				ulen2++
+				if found[trigram] {
+					count++
+				}
+			}
+			lastPos[trigram] = i
+		}
+		if !found[trigram] {
+			continue
+		}
+
+		upper := i
+		if lower == -1 {
+			lower = i
+			ulen2 = 1
+		}
+		current := similarity(count, ulen2)
+
+		// Try moving the lower bound up for a greater similarity
+		tmpCount, tmpUlen2, prevLower := count, ulen2, lower
+		for tmpLower := lower; tmpLower <= upper; tmpLower++ {
+			if tmp := similarity(tmpCount, tmpUlen2); tmp > current {
+				current, ulen2, lower, count = tmp, tmpUlen2, tmpLower, tmpCount
+			}
+			if tmpTrigram := valueTrigrams[tmpLower]; position(tmpTrigram) == tmpLower {
+				tmpUlen2--
+				if found[tmpTrigram] {
+					tmpCount--
+				}
+			}
+		}
+		if current > max {
+			max = current
+		}
+
+		for tmpLower := prevLower; tmpLower < lower; tmpLower++ {
+			if tmpTrigram := valueTrigrams[tmpLower]; position(tmpTrigram) == tmpLower {
+				delete(lastPos, tmpTrigram)
+			}
+		}
+	}
+
+	return float64(max)
+}
+
+// searchRank ranks how well a value matches the search text: a value that is the text scores 3, one that
+// starts with it 2 and one that contains it 1, plus their word similarity. Values that are neither similar
+// enough nor contain the text do not match.
+func searchRank(value string, query string) (float64, bool) {
+	lowerValue, lowerQuery := strings.ToLower(value), strings.ToLower(query)
+	similarity := WordSimilarity(query, value)
+
+	var rank float64
+	switch {
+	case lowerValue == lowerQuery:
+		rank = 3
+	case strings.HasPrefix(lowerValue, lowerQuery):
+		rank = 2
+	case strings.Contains(lowerValue, lowerQuery):
+		rank = 1
+	case similarity >= WordSimilarityThreshold:
+		rank = 0
+	default:
+		return 0, false
+	}
+
+	return rank + similarity, true
+}
+
+// searchMatch is the condition that one of the columns contains the search text, $2 escaped for ILIKE,
+// or has words similar to it, $1. Both can use the trigram indexes of the columns.
+func searchMatch(columns ...string) string {
+	conditions := make([]string, 0, len(columns))
+	for _, column := range columns {
+		conditions = append(conditions, column+` ILIKE '%' || $2::TEXT || '%' ESCAPE '\' OR $1::TEXT <% `+column)
+	}
+	return `(` + strings.Join(conditions, ` OR `) + `)`
+}
+
+// Search finds the in-service devices, rooms and their inspection notes that contain the search text or have
+// words similar to it. Each device, room or inspection is ranked by its best matching field, searchRank in SQL.
+func (db *DB) Search(query string, limit int, offset int) (*models.SearchResults, error) {
+	args := []interface{}{query, likeEscaper.Replace(query)}
+
+	devices := `
+	SELECT 'device' AS kind, ed.emergencydeviceid AS id, ed.emergencydeviceid AS deviceid, r.roomid,
+		TRIM(COALESCE(edt.emergencydevicetypename, '') || ' ' || COALESCE(ed.serialnumber, '')) AS title,
+		s.sitename || ', ' || b.buildingcode || ' ' || r.roomcode AS location, f.field, f.value
+	FROM emergency_deviceT ed
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	LEFT JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
+	LEFT JOIN Extinguisher_TypeT et ON ed.extinguishertypeid = et.extinguishertypeid
+	CROSS JOIN LATERAL (VALUES
+		('serial_number', ed.serialnumber),
+		('description', ed.description),
+		('emergency_device_type', edt.emergencydevicetypename),
+		('extinguisher_type', et.extinguishertypename),
+		('room_code', r.roomcode),
+		('building_code', b.buildingcode),
+		('site_name', s.sitename)
+	) AS f(field, value)
+	WHERE ed.decommissionedat IS NULL AND r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL AND ` +
+		searchMatch("ed.serialnumber", "ed.description", "edt.emergencydevicetypename", "et.extinguishertypename", "r.roomcode", "b.buildingcode", "s.sitename") +
+		db.scope(&args, organisationScope, "s.organisationid")
+
+	rooms := `
+	SELECT 'room', r.roomid, NULL::INT, r.roomid, b.buildingcode || ' ' || r.roomcode,
+		s.sitename || ', ' || b.buildingcode || ' ' || r.roomcode, f.field, f.value
+	FROM roomT r
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	CROSS JOIN LATERAL (VALUES
+		('room_code', r.roomcode),
+		('building_code', b.buildingcode),
+		('site_name', s.sitename)
+	) AS f(field, value)
+	WHERE r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL AND ` + searchMatch("r.roomcode", "b.buildingcode", "s.sitename") +
+		db.scope(&args, organisationScope, "s.organisationid")
+
+	inspections := `
+	SELECT 'inspection', edi.emergencydeviceinspectionid, ed.emergencydeviceid, r.roomid,
+		TRIM('Inspection of ' || COALESCE(edt.emergencydevicetypename, '') || ' ' || COALESCE(ed.serialnumber, '')),
+		s.sitename || ', ' || b.buildingcode || ' ' || r.roomcode, 'notes', edi.notes
+	FROM emergency_device_inspectionT edi
+	JOIN emergency_deviceT ed ON edi.emergencydeviceid = ed.emergencydeviceid
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	LEFT JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
+	WHERE ed.decommissionedat IS NULL AND r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL AND ` +
+		searchMatch("edi.notes") + db.scope(&args, organisationScope, "s.organisationid")
+
+	threshold := strconv.FormatFloat(WordSimilarityThreshold, 'f', -1, 64)
+	matched := `
+	WITH candidates AS (` + devices + `
+		UNION ALL` + rooms + `
+		UNION ALL` + inspections + `
+	), ranked AS (
+		SELECT *, CASE
+			WHEN lower(value) = lower($1::TEXT) THEN 3
+			WHEN value ILIKE $2::TEXT || '%' ESCAPE '\' THEN 2
+			WHEN value ILIKE '%' || $2::TEXT || '%' ESCAPE '\' THEN 1
+			ELSE 0
+		END + word_similarity($1::TEXT, value)::FLOAT8 AS rank
+		FROM candidates
+		WHERE value ILIKE '%' || $2::TEXT || '%' ESCAPE '\' OR word_similarity($1::TEXT, value) >= ` + threshold + `
+	), best AS (
+		SELECT DISTINCT ON (kind, id) * FROM ranked ORDER BY kind, id, rank DESC, field
+	)`
+
+	pageArgs := append(append([]interface{}(nil), args...), limit, offset)
+	rows, err := db.Query(matched+`
+	SELECT kind, id, deviceid, roomid, title, location, field, value, rank
+	FROM best
+	ORDER BY rank DESC, kind, id
+	LIMIT $`+strconv.Itoa(len(pageArgs)-1)+` OFFSET $`+strconv.Itoa(len(pageArgs)), pageArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := &models.SearchResults{Query: query, Limit: limit, Offset: offset, Results: []models.SearchResult{}}
+	for rows.Next() {
+		var result models.SearchResult
+		err := rows.Scan(
+			&result.Kind,
+			&result.ID,
+			&result.EmergencyDeviceID,
+			&result.RoomID,
+			&result.Title,
+			&result.Location,
+			&result.Field,
+			&result.Value,
+			&result.Rank,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results.Results = append(results.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.QueryRow(matched+` SELECT COUNT(*) FROM best`, args...).Scan(&results.Total); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -3,199 +3,130 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
+	"log/slog"
 	"time"
 
-	"github.com/joho/godotenv"
-
-	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/config"
 	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
 	_ "github.com/lib/pq" // Import the PostgreSQL driver
 	"golang.org/x/crypto/bcrypt"
 )
 
-// SeedStatus tracks the seeding state
-type SeedStatus struct {
-	TempFilePath string
-	EnvVarName   string
-}
+// Seed profiles, the profile loaded is recorded in SeedT
+const (
+	// SeedProfileDemo loads the EIT sites with example devices, inspections and users
+	SeedProfileDemo = "demo"
+	// SeedProfileMinimal loads only the default admin and the standard device and extinguisher types
+	SeedProfileMinimal = "minimal"
+)
 
-// NewSeedStatus creates a new SeedStatus instance
-func NewSeedStatus() *SeedStatus {
-	return &SeedStatus{
-		TempFilePath: filepath.Join("internal", "seed_complete"),
-		EnvVarName:   "DATA_SEEDED",
-	}
+// IsDataSeeded reports whether any seed profile has been loaded into the database
+func IsDataSeeded(db *sql.DB) (bool, error) {
+	var seeded bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM SeedT)`).Scan(&seeded)
+	return seeded, err
 }
 
-// IsDataSeeded checks if data has been seeded using both temp file and env var
-func (s *SeedStatus) IsDataSeeded() bool {
-
-	_, err := os.Stat(s.TempFilePath)
-	fileExists := !os.IsNotExist(err)
-
-	// Check environment variable
-	envVal := os.Getenv(s.EnvVarName)
-	envExists := envVal == "true"
-
-	// Load from .env file if environment variable is not set
-	if !envExists {
-		if err := godotenv.Load(); err == nil {
-			envVal = os.Getenv(s.EnvVarName)
-			envExists = envVal == "true"
-		}
+// SeedDatabase loads the seed profile unless the database records that it has already been seeded.
+// The data and the record are written in one transaction, so a failed seed is retried on the next start.
+// The default admin account is created with adminPassword.
+func SeedDatabase(db *sql.DB, profile string, adminPassword string) error {
+	var seed func(tx *sql.Tx, adminPassword string) error
+	switch profile {
+	case SeedProfileDemo:
+		seed = SeedData
+	case SeedProfileMinimal:
+		seed = SeedMinimalData
+	default:
+		return fmt.Errorf("unknown seed profile %q", profile)
+	}
+
+	seeded, err := IsDataSeeded(db)
+	if err != nil {
+		return fmt.Errorf("failed to check seed state: %v", err)
+	}
+	if seeded {
+		slog.Info("Database already seeded")
+		return nil
 	}
 
-	// Return true if either check passes
-	return fileExists || envExists
-}
+	slog.Info("Seeding database", "profile", profile)
 
-// MarkDataAsSeeded marks the data as seeded
-func (s *SeedStatus) MarkDataAsSeeded() error {
-	// Create temp file
-	err := os.MkdirAll(filepath.Dir(s.TempFilePath), 0755)
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to create directory: %v", err)
+		return err
 	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
 
-	tempFile, err := os.Create(s.TempFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %v", err)
+	if err := seed(tx, adminPassword); err != nil {
+		return fmt.Errorf("failed to seed database: %v", err)
 	}
-	tempFile.Close()
 
-	// Set environment variable
-	err = os.Setenv(s.EnvVarName, "true")
-	if err != nil {
-		return fmt.Errorf("failed to set environment variable: %v", err)
+	// Seeded users belong to the default organisation
+	if _, err := tx.Exec(`
+	INSERT INTO UserOrganisationT (UserID, OrganisationID)
+	SELECT UserID, $1 FROM UserT
+	ON CONFLICT DO NOTHING
+	`, models.DefaultOrganisationID); err != nil {
+		return fmt.Errorf("failed to add seeded users to the default organisation: %v", err)
 	}
 
-	return nil
-}
-
-func SeedDatabase(db *sql.DB) error {
-	seedStatus := NewSeedStatus()
+	if _, err := tx.Exec(`INSERT INTO SeedT (SeedName) VALUES ($1)`, profile); err != nil {
+		return fmt.Errorf("failed to mark data as seeded: %v", err)
+	}
 
-	if !seedStatus.IsDataSeeded() {
-		log.Println("Seeding database...")
-		SeedData(db) // Corrected here to remove error handling
-		if err := seedStatus.MarkDataAsSeeded(); err != nil {
-			return fmt.Errorf("failed to mark data as seeded: %v", err)
-		}
-		log.Println("Database seeding completed successfully")
-	} else {
-		log.Println("Database already seeded")
+	if err := tx.Commit(); err != nil {
+		return err
 	}
 
+	slog.Info("Database seeded", "profile", profile)
+
 	return nil
 }
 
-func createTriggerAndFunctionIfNotExists(db *sql.DB) error {
-	triggerName := "trg_update_device_status"                   // Trigger name
-	triggerFunctionName := "update_device_status_on_inspection" // Function name
+// SeedMinimalData loads the default admin account and the standard device and extinguisher types,
+// for a production install where sites and devices are entered or imported by the operator
+func SeedMinimalData(tx *sql.Tx, adminPassword string) error {
+	if adminPassword == "" {
+		return fmt.Errorf("ADMIN_PASSWORD is not set")
+	}
 
-	// Check if the trigger function exists
-	functionExistsQuery := `
-		SELECT EXISTS (
-			SELECT 1
-			FROM pg_proc
-			WHERE proname = $1
-		)
-	`
+	adminHash, err := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("error generating hash for admin password: %v", err)
+	}
 
-	var functionExists bool
-	err := db.QueryRow(functionExistsQuery, triggerFunctionName).Scan(&functionExists)
+	_, err = tx.Exec(`
+		INSERT INTO UserT (username, password, role, email, defaultadmin)
+		VALUES ('admin1', $1, 'Admin', 'admin@email.com', true)`, adminHash)
 	if err != nil {
 		return err
 	}
 
-	// Create the trigger function if it does not exist
-	if !functionExists {
-		createFunctionSQL := `
-		CREATE OR REPLACE FUNCTION update_device_status_on_inspection() 
-		RETURNS TRIGGER AS $$
-		DECLARE
-			current_last_inspection_timestamp TIMESTAMP;
-			calculated_expire_date TIMESTAMP;
-		BEGIN
-			-- Retrieve the current last inspection timestamp and manufacture date for the device
-			SELECT LastInspectionDateTime, ManufactureDate INTO current_last_inspection_timestamp, calculated_expire_date
-			FROM Emergency_DeviceT
-			WHERE EmergencyDeviceID = NEW.EmergencyDeviceID;
-			
-			-- Calculate the expiration date as ManufactureDate + 5 years
-			calculated_expire_date := calculated_expire_date + INTERVAL '5 years';
-
-			-- Check if the new inspection timestamp is more recent than the current last inspection timestamp
-			IF current_last_inspection_timestamp IS NULL OR NEW.InspectionDateTime > current_last_inspection_timestamp THEN
-				-- Determine the status based on inspection and expiration conditions
-				UPDATE Emergency_DeviceT
-				SET LastInspectionDateTime = NEW.InspectionDateTime,
-					Status = CASE 
-								WHEN NEW.InspectionStatus = 'Failed' THEN 'Inspection Failed'
-								WHEN calculated_expire_date <= NOW() THEN 'Expired'
-								WHEN NEW.InspectionStatus = 'Passed' THEN 'Active'
-								ELSE Status
-							END
-				WHERE EmergencyDeviceID = NEW.EmergencyDeviceID;
-			END IF;
-
-			RETURN NEW;
-		END;
-		$$ LANGUAGE plpgsql;
-		`
-		_, err = db.Exec(createFunctionSQL)
-		if err != nil {
-			return err
-		}
-		log.Println("Trigger function created:", triggerFunctionName)
-	} else {
-		log.Println("Trigger function already exists:", triggerFunctionName)
+	_, err = tx.Exec(`
+		INSERT INTO Extinguisher_TypeT (ExtinguisherTypeName)
+		VALUES ('CO2'), ('Water'), ('Dry')`)
+	if err != nil {
+		return err
 	}
 
-	// Check if the trigger exists
-	triggerExistsQuery := `
-		SELECT EXISTS (
-			SELECT 1
-			FROM pg_trigger
-			WHERE tgname = $1
-		)
-	`
-
-	var triggerExists bool
-	err = db.QueryRow(triggerExistsQuery, triggerName).Scan(&triggerExists)
+	_, err = tx.Exec(`
+		INSERT INTO Emergency_Device_TypeT (EmergencyDeviceTypeName, InspectionIntervalMonths, ServiceIntervalMonths)
+		VALUES ('Fire Extinguisher', 3, 12)`)
 	if err != nil {
 		return err
 	}
 
-	// Create the trigger if it does not exist
-	if !triggerExists {
-		createTriggerSQL := `
-			CREATE TRIGGER trg_update_device_status
-			AFTER INSERT ON Emergency_Device_InspectionT
-			FOR EACH ROW
-			EXECUTE FUNCTION update_device_status_on_inspection();
-		`
-		_, err = db.Exec(createTriggerSQL)
-		if err != nil {
-			return err
-		}
-		log.Println("Trigger created:", triggerName)
-	} else {
-		log.Println("Trigger already exists:", triggerName)
-	}
+	slog.Debug("Seeding complete")
 
 	return nil
 }
 
-func SeedData(db *sql.DB) {
-	// Get admin password from .env
-	adminPassword := config.LoadConfig().AdminPassword
-
+// SeedData loads the demo sites, buildings, rooms, devices, inspections and the two default users.
+// It runs inside the caller's transaction so a failure leaves no partial data behind.
+func SeedData(tx *sql.Tx, adminPassword string) error {
 	if adminPassword == "" {
-		log.Fatal("ADMIN_PASSWORD not set in .env file")
+		return fmt.Errorf("ADMIN_PASSWORD is not set")
 	}
 
 	userPassword := "Password1!"
@@ -208,154 +139,152 @@ func SeedData(db *sql.DB) {
 	// Generate hash for password
 	adminHash, err := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
 	if err != nil {
-		fmt.Println("Error generating hash for admin password")
-		log.Fatal(err)
+		return fmt.Errorf("error generating hash for admin password: %v", err)
 	}
 
 	userHash, err := bcrypt.GenerateFromPassword([]byte(userPassword), bcrypt.DefaultCost)
 
 	if err != nil {
-		fmt.Println("Error generating hash for user password")
-		log.Fatal(err)
+		return fmt.Errorf("error generating hash for user password: %v", err)
 	}
 
-	log.Println("Seeding data...")
+	slog.Debug("Seeding demo data")
 
 	// Insert Users
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO UserT (username, password, role, email, defaultadmin)
 		VALUES ('admin1', $1, 'Admin', 'admin@email.com', true)`, adminHash)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO UserT (username, password, role, email)
 		VALUES ('user12', $1, 'User', 'user@email.com')`, userHash)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Insert Sites
-	err = db.QueryRow(`
-		INSERT INTO SiteT (SiteName, SiteAddress)
-		VALUES ('EIT Taradale', '501 Gloucester Street, Taradale, Napier 4112') RETURNING SiteID`).Scan(&siteID)
+	err = tx.QueryRow(`
+		INSERT INTO SiteT (SiteName, SiteAddress, SiteMapImagePath)
+		VALUES ('EIT Taradale', '501 Gloucester Street, Taradale, Napier 4112', '/static/site_maps/EIT_Taradale.svg') RETURNING SiteID`).Scan(&siteID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO SiteT (SiteName, SiteAddress, SiteMapImagePath)
 			VALUES ('EIT Hastings', '416 Heretaunga Street West, Hastings 4122', '/static/site_maps/EIT_Hastings.png') RETURNING SiteID`).Scan(&hastingsSiteID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Insert Buildings - A, B, Main
-	err = db.QueryRow(`
-			INSERT INTO BuildingT (SiteID, BuildingCode)
-			VALUES ($1, 'A') RETURNING BuildingID`, siteID).Scan(&buildingIDA)
+	err = tx.QueryRow(`
+			INSERT INTO BuildingT (SiteID, BuildingCode, MapX, MapY)
+			VALUES ($1, 'A', 0.5793, 0.4143) RETURNING BuildingID`, siteID).Scan(&buildingIDA)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = db.QueryRow(`
-			INSERT INTO BuildingT (SiteID, BuildingCode)
-			VALUES ($1, 'B') RETURNING BuildingID`, siteID).Scan(&buildingIDB)
+	err = tx.QueryRow(`
+			INSERT INTO BuildingT (SiteID, BuildingCode, MapX, MapY)
+			VALUES ($1, 'B', 0.5185, 0.3604) RETURNING BuildingID`, siteID).Scan(&buildingIDB)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO BuildingT (SiteID, BuildingCode)
 			VALUES ($1, 'Main') RETURNING BuildingID`, hastingsSiteID).Scan(&hastingsBuildingID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Insert Rest of Taradale Buildings
-	_, err = db.Exec(`
-	INSERT INTO buildingT (siteID, buildingCode)
+	_, err = tx.Exec(`
+	INSERT INTO buildingT (siteID, buildingCode, mapX, mapY)
 	VALUES
-		(1, 'O'),
-		(1, 'T'),
-		(1, 'R'),
-		(1, 'E2'),
-		(1, 'E'),
-		(1, 'E1'),
-		(1, 'D'),
-		(1, 'P1'),
-		(1, 'P'),
-		(1, 'N1'),
-		(1, 'N2'),
-		(1, 'N'),
-		(1, 'M'),
-		(1, 'F1'),
-		(1, 'F'),
-		(1, 'C'),
-		(1, 'L'),
-		(1, 'L1'),
-		(1, 'K1'),
-		(1, 'K'),
-		(1, 'J'),
-		(1, 'G'),
-		(1, 'G1'),
-		(1, 'G2'),
-		(1, 'I'),
-		(1, 'I1'),
-		(1, 'H'),
-		(1, 'Q'),
-		(1, 'S'),
-		(1, 'J1');
+		(1, 'O', 0.1359, 0.0293),
+		(1, 'T', 0.2991, 0.0853),
+		(1, 'R', 0.5073, 0.1318),
+		(1, 'E2', 0.8075, 0.1195),
+		(1, 'E', 0.8097, 0.1685),
+		(1, 'E1', 0.8936, 0.2169),
+		(1, 'D', 0.5636, 0.2514),
+		(1, 'P1', 0.3227, 0.1821),
+		(1, 'P', 0.2381, 0.1799),
+		(1, 'N1', 0.2219, 0.2221),
+		(1, 'N2', 0.3209, 0.2351),
+		(1, 'N', 0.2500, 0.2840),
+		(1, 'M', 0.4325, 0.3074),
+		(1, 'F1', 0.7308, 0.2655),
+		(1, 'F', 0.8140, 0.3087),
+		(1, 'C', 0.6254, 0.3446),
+		(1, 'L', 0.3164, 0.3879),
+		(1, 'L1', 0.2126, 0.3757),
+		(1, 'K1', 0.2252, 0.4207),
+		(1, 'K', 0.3592, 0.4693),
+		(1, 'J', 0.5260, 0.5111),
+		(1, 'G', 0.7208, 0.4792),
+		(1, 'G1', 0.9183, 0.4709),
+		(1, 'G2', 0.7484, 0.3930),
+		(1, 'I', 0.7821, 0.6042),
+		(1, 'I1', 0.6740, 0.6262),
+		(1, 'H', 0.8905, 0.6743),
+		(1, 'Q', 0.7084, 0.8876),
+		(1, 'S', 0.4373, 0.8397),
+		(1, 'J1', 0.5388, 0.4697);
 	`)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Insert Rooms
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO RoomT (BuildingID, RoomCode)
 			VALUES ($1, 'A1') RETURNING RoomID`, buildingIDA).Scan(&roomA1ID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO RoomT (BuildingID, RoomCode)
 			VALUES ($1, 'B1') RETURNING RoomID`, buildingIDB).Scan(&roomB1ID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO RoomT (BuildingID, RoomCode)
 			VALUES ($1, 'Main Room') RETURNING RoomID`, hastingsBuildingID).Scan(&hastingsMainRoomID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Insert Extinguisher Types
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO Extinguisher_TypeT (ExtinguisherTypeName)
 			VALUES ('CO2') RETURNING ExtinguisherTypeID`).Scan(&co2TypeID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO Extinguisher_TypeT (ExtinguisherTypeName)
 			VALUES ('Water') RETURNING ExtinguisherTypeID`).Scan(&waterTypeID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 			INSERT INTO Extinguisher_TypeT (ExtinguisherTypeName)
 			VALUES ('Dry') RETURNING ExtinguisherTypeID`).Scan(&dryTypeID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Insert Emergency Device Type
-	err = db.QueryRow(`
-			INSERT INTO Emergency_Device_TypeT (EmergencyDeviceTypeName)
-			VALUES ('Fire Extinguisher') RETURNING EmergencyDeviceTypeID`).Scan(&emergencyDeviceTypeID)
+	err = tx.QueryRow(`
+			INSERT INTO Emergency_Device_TypeT (EmergencyDeviceTypeName, InspectionIntervalMonths, ServiceIntervalMonths)
+			VALUES ('Fire Extinguisher', 3, 12) RETURNING EmergencyDeviceTypeID`).Scan(&emergencyDeviceTypeID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// Create Emergency Devices using the models.EmergencyDevice struct
@@ -431,7 +360,7 @@ func SeedData(db *sql.DB) {
 			extinguisherTypeID = dryTypeID
 		}
 
-		_, err := db.Exec(`
+		_, err := tx.Exec(`
 				INSERT INTO Emergency_DeviceT
 					(EmergencyDeviceTypeID, RoomID, ExtinguisherTypeID, SerialNumber, ManufactureDate, LastInspectionDateTime, Description, Size, Status)
 				VALUES
@@ -440,12 +369,17 @@ func SeedData(db *sql.DB) {
 			device.SerialNumber, device.ManufactureDate, device.LastInspectionDateTime, device.Description, device.Size, device.Status,
 		)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
+	// The seeded devices already carry their demo status, so the inspections must not recalculate it
+	if _, err := tx.Exec(`ALTER TABLE Emergency_Device_InspectionT DISABLE TRIGGER trg_update_device_status`); err != nil {
+		return err
+	}
+
 	// Insert Inspections
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 	INSERT INTO Emergency_Device_InspectionT
 	(EmergencyDeviceID, UserID, InspectionDateTime, CreatedAt, IsConspicuous, IsAccessible, IsAssignedLocation, IsSignVisible, IsAntiTamperDeviceIntact, IsSupportBracketSecure, AreOperatingInstructionsClear, IsMaintenanceTagAttached, IsNoExternalDamage, IsChargeGaugeNormal, IsReplaced, AreMaintenanceRecordsComplete, WorkOrderRequired, InspectionStatus, Notes)
 	VALUES
@@ -454,10 +388,10 @@ func SeedData(db *sql.DB) {
 	 '2024-09-01 13:00:00+13'::timestamptz, 
 	 true, true, true, true, true, true, true, true, true, true ,NULL, true, NULL, 'Passed', 'Passed good as new')`)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 	INSERT INTO Emergency_Device_InspectionT
 	(EmergencyDeviceID, UserID, InspectionDateTime, CreatedAt, IsConspicuous, IsAccessible, IsAssignedLocation, IsSignVisible, IsAntiTamperDeviceIntact, IsSupportBracketSecure, AreOperatingInstructionsClear, IsMaintenanceTagAttached, IsNoExternalDamage, IsChargeGaugeNormal, IsReplaced, AreMaintenanceRecordsComplete, WorkOrderRequired, InspectionStatus, Notes)
 	VALUES
@@ -466,10 +400,10 @@ func SeedData(db *sql.DB) {
 	 '2024-10-01 14:30:00+13'::timestamptz, 
 	 true, true, true, true, true, true, NULL, true, NULL, true ,NULL, true, NULL, 'Failed', 'No notes')`)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	_, err = db.Exec(`
+	_, err = tx.Exec(`
 	INSERT INTO Emergency_Device_InspectionT
 	(EmergencyDeviceID, UserID, InspectionDateTime, CreatedAt, IsConspicuous, IsAccessible, IsAssignedLocation, IsSignVisible, IsAntiTamperDeviceIntact, IsSupportBracketSecure, AreOperatingInstructionsClear, IsMaintenanceTagAttached, IsNoExternalDamage, IsChargeGaugeNormal , IsReplaced, AreMaintenanceRecordsComplete, WorkOrderRequired, InspectionStatus, Notes)
 	VALUES
@@ -478,25 +412,14 @@ func SeedData(db *sql.DB) {
 	 '2024-07-01 15:45:00+13'::timestamptz, 
 	 true, true, true, true, true, true, true, true, true, true ,true, true, true, 'Passed', 'Passed and replaced')`)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// Create a temp file in .internal/ directory
-	tempFile, err := os.Create("internal/seed_complete")
-	if err != nil {
-		log.Fatal(err)
+	if _, err := tx.Exec(`ALTER TABLE Emergency_Device_InspectionT ENABLE TRIGGER trg_update_device_status`); err != nil {
+		return err
 	}
-	tempFile.Close()
 
-	// Set environment variable to indicate that data has been seeded
-	os.Setenv("DATA_SEEDED", "true")
+	slog.Debug("Seeding complete")
 
-	// Create trigger and function if they don't exist
-	err = createTriggerAndFunctionIfNotExists(db)
-	if err != nil {
-		log.Fatalf("Failed to create trigger or function: %v", err)
-		return
-	}
-
-	log.Println("Seeding complete.")
+	return nil
 }
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// inspectionCountJoins are the inspections of a period with their device, inspector and site, the period is $1 to $2
+const inspectionCountJoins = `
+	FROM emergency_device_inspectionT edi
+	JOIN userT u ON edi.userid = u.userid
+	JOIN emergency_deviceT ed ON edi.emergencydeviceid = ed.emergencydeviceid
+	LEFT JOIN emergency_device_typeT edt ON ed.emergencydevicetypeid = edt.emergencydevicetypeid
+	LEFT JOIN Extinguisher_TypeT et ON ed.extinguishertypeid = et.extinguishertypeid
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	WHERE (edi.inspectiondatetime AT TIME ZONE s.timezone)::DATE BETWEEN $1::DATE AND $2::DATE`
+
+func (db *DB) GetInspectionCounts(siteId string, from time.Time, to time.Time) (*models.InspectionCounts, error) {
+	args := []interface{}{from.Format("2006-01-02"), to.Format("2006-01-02")}
+	joins := inspectionCountJoins + db.scope(&args, organisationScope, "s.organisationid")
+	if siteId != "" {
+		args = append(args, siteId)
+		joins += fmt.Sprintf(` AND s.siteid = $%d`, len(args))
+	}
+
+	counts := &models.InspectionCounts{
+		ByType:      []models.InspectionTypeCount{},
+		ByInspector: []models.InspectorMonthCount{},
+	}
+
+	typeRows, err := db.Query(`
+	SELECT COALESCE(edt.emergencydevicetypename, ''), et.extinguishertypename,
+		COUNT(*), COUNT(*) FILTER (WHERE edi.inspectionstatus = 'Failed')`+joins+`
+	GROUP BY 1, 2
+	ORDER BY 1, 2 NULLS FIRST`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer typeRows.Close()
+
+	for typeRows.Next() {
+		var count models.InspectionTypeCount
+		err := typeRows.Scan(&count.EmergencyDeviceTypeName, &count.ExtinguisherTypeName, &count.Inspections, &count.Failed)
+		if err != nil {
+			return nil, err
+		}
+		counts.ByType = append(counts.ByType, count)
+	}
+	if err := typeRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The month of an inspection is the month at its site
+	inspectorRows, err := db.Query(`
+	SELECT u.userid, u.username, to_char(edi.inspectiondatetime AT TIME ZONE s.timezone, 'YYYY-MM'),
+		COUNT(*), COUNT(*) FILTER (WHERE edi.inspectionstatus = 'Failed')`+joins+`
+	GROUP BY 1, 2, 3
+	ORDER BY 3, 2, 1`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer inspectorRows.Close()
+
+	for inspectorRows.Next() {
+		var count models.InspectorMonthCount
+		err := inspectorRows.Scan(&count.UserID, &count.InspectorName, &count.Month, &count.Inspections, &count.Failed)
+		if err != nil {
+			return nil, err
+		}
+		counts.ByInspector = append(counts.ByInspector, count)
+	}
+
+	return counts, inspectorRows.Err()
+}
@@ -0,0 +1,68 @@
+package database_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoreContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return database.NewMemoryStore()
+	})
+}
+
+// TestPostgresStoreContract runs the same contract against a PostgreSQL database, migrating it first.
+// It is skipped unless EDMS_TEST_DATABASE_URL is set, every table in that database is emptied.
+func TestPostgresStoreContract(t *testing.T) {
+	connStr := os.Getenv("EDMS_TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("EDMS_TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.Ping())
+	require.NoError(t, database.MigrateUp(db))
+
+	storetest.Run(t, func(t *testing.T) database.Store {
+		_, err := db.Exec(`
+		TRUNCATE TABLE
+			DefaultSavedViewT,
+			SavedViewShareT,
+			SavedViewT,
+			WebhookAttemptT,
+			WebhookDeliveryT,
+			WebhookT,
+			CalendarFeedT,
+			InspectionRoundDeviceT,
+			InspectionRoundT,
+			FloorPlanPinT,
+			FloorPlanT,
+			MaintenanceAttachmentT,
+			MaintenanceRecordT,
+			Emergency_Device_InspectionT,
+			Emergency_DeviceT,
+			RoomT,
+			FloorT,
+			BuildingT,
+			SiteT,
+			UserOrganisationT,
+			UserT,
+			Emergency_Device_TypeT,
+			Extinguisher_TypeT,
+			SeedT,
+			OrganisationT
+		RESTART IDENTITY CASCADE
+		`)
+		require.NoError(t, err)
+		_, err = db.Exec(`INSERT INTO OrganisationT (Name) VALUES ('Default')`)
+		require.NoError(t, err)
+		return &database.DB{DB: db}
+	})
+}
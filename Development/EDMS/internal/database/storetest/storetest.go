@@ -0,0 +1,2274 @@
+// Package storetest is the contract test suite every database.Store implementation must pass,
+// so handlers tested against the in-memory store behave the same on PostgreSQL.
+package storetest
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/database"
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "time/tzdata" // Times are read in the time zone of each site
+)
+
+// Run runs the contract tests, newStore must return an empty store for every test
+func Run(t *testing.T, newStore func(t *testing.T) database.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store database.Store)
+	}{
+		{"Users", testUsers},
+		{"Locations", testLocations},
+		{"ArchivedLocations", testArchivedLocations},
+		{"DeviceTypes", testDeviceTypes},
+		{"Devices", testDevices},
+		{"DeviceFilters", testDeviceFilters},
+		{"DecommissionAndPurge", testDecommissionAndPurge},
+		{"ReplaceDevice", testReplaceDevice},
+		{"Inspections", testInspections},
+		{"InspectionOfExpiredDevice", testInspectionOfExpiredDevice},
+		{"InspectionRounds", testInspectionRounds},
+		{"SiteTimeZones", testSiteTimeZones},
+		{"MaintenanceRecords", testMaintenanceRecords},
+		{"FloorPlans", testFloorPlans},
+		{"Floors", testFloors},
+		{"Sync", testSync},
+		{"SyncLocations", testSyncLocations},
+		{"CalendarFeeds", testCalendarFeeds},
+		{"Webhooks", testWebhooks},
+		{"InspectionCounts", testInspectionCounts},
+		{"Search", testSearch},
+		{"SavedViews", testSavedViews},
+		{"RowVersions", testRowVersions},
+		{"VersionedArchivesAndDeletes", testVersionedArchivesAndDeletes},
+		{"UnitOfWork", testUnitOfWork},
+		{"Dependents", testDependents},
+		{"OrganisationIsolation", testOrganisationIsolation},
+		{"OrganisationMembers", testOrganisationMembers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// fixture is a site with one building and room, a fire extinguisher device type and an inspector
+type fixture struct {
+	SiteID       int
+	BuildingID   int
+	BuildingCode string
+	RoomID       int
+	DeviceTypeID int
+	UserID       int
+}
+
+func newFixture(t *testing.T, store database.Store) fixture {
+	t.Helper()
+
+	require.NoError(t, store.AddSite(&models.Site{SiteName: "Taradale", SiteAddress: "501 Gloucester Street"}))
+	site, err := store.GetSiteByName("Taradale")
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: site.SiteID, BuildingCode: "A"}))
+	building, err := store.GetBuildingByCodeandSite("A", site.SiteID)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: building.BuildingID, RoomCode: "A101"}))
+	room, err := store.GetRoomByCodeAndBuilding("A101", building.BuildingID)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddEmergencyDeviceType(&models.EmergencyDeviceType{
+		EmergencyDeviceTypeName:  "Fire Extinguisher",
+		InspectionIntervalMonths: 3,
+		ServiceIntervalMonths:    sql.NullInt64{Int64: 12, Valid: true},
+	}))
+	deviceType, err := store.GetDeviceTypeByName("Fire Extinguisher")
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateUser(&models.User{Username: "inspector", Password: "hash", Email: "inspector@example.com"}))
+	user, err := store.GetUserByUsername("inspector")
+	require.NoError(t, err)
+
+	return fixture{
+		SiteID:       site.SiteID,
+		BuildingID:   building.BuildingID,
+		BuildingCode: building.BuildingCode,
+		RoomID:       room.RoomID,
+		DeviceTypeID: deviceType.EmergencyDeviceTypeID,
+		UserID:       user.UserID,
+	}
+}
+
+// addDevice adds a device to the fixture room and returns it
+func addDevice(t *testing.T, store database.Store, f fixture, serialNumber string, manufactureDate time.Time) models.EmergencyDevice {
+	t.Helper()
+
+	require.NoError(t, store.AddEmergencyDevice(&models.EmergencyDevice{
+		EmergencyDeviceTypeID: f.DeviceTypeID,
+		RoomID:                f.RoomID,
+		SerialNumber:          sql.NullString{String: serialNumber, Valid: true},
+		ManufactureDate:       sql.NullTime{Time: manufactureDate, Valid: !manufactureDate.IsZero()},
+		Status:                sql.NullString{String: "Active", Valid: true},
+	}))
+
+	devices, err := store.GetDevicesByRoomID(f.RoomID)
+	require.NoError(t, err)
+	for _, device := range devices {
+		if device.SerialNumber.String == serialNumber {
+			return device
+		}
+	}
+
+	t.Fatalf("device %s was not added", serialNumber)
+	return models.EmergencyDevice{}
+}
+
+func findDevice(devices []models.EmergencyDevice, deviceID int) (models.EmergencyDevice, bool) {
+	for _, device := range devices {
+		if device.EmergencyDeviceID == deviceID {
+			return device, true
+		}
+	}
+	return models.EmergencyDevice{}, false
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func testUsers(t *testing.T, store database.Store) {
+	require.NoError(t, store.CreateUser(&models.User{Username: "alex", Password: "hash", Email: "alex@example.com"}))
+
+	user, err := store.GetUserByUsername("alex")
+	require.NoError(t, err)
+	assert.Equal(t, "alex@example.com", user.Email)
+	assert.Equal(t, "hash", user.Password)
+	assert.Equal(t, "User", user.Role, "new users get the User role")
+	assert.False(t, user.DefaultAdmin)
+
+	byEmail, err := store.GetUserByEmail("alex@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, user.UserID, byEmail.UserID)
+
+	assert.Error(t, store.CreateUser(&models.User{Username: "alex", Password: "hash", Email: "other@example.com"}), "usernames are unique")
+	assert.Error(t, store.CreateUser(&models.User{Username: "other", Password: "hash", Email: "alex@example.com"}), "emails are unique")
+
+	user.Role = "Admin"
+	user.Email = "alex@eit.ac.nz"
+	require.NoError(t, store.UpdateUser(user))
+	require.NoError(t, store.UpdatePassword(user.UserID, "new hash"))
+
+	updated, err := store.GetUserByID(user.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "Admin", updated.Role)
+	assert.Equal(t, "alex@eit.ac.nz", updated.Email)
+	assert.Equal(t, "new hash", updated.Password)
+
+	users, err := store.GetAllUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Empty(t, users[0].Password, "passwords are not listed")
+
+	require.NoError(t, store.DeleteUser(user.UserID, 0))
+	_, err = store.GetUserByID(user.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = store.GetUserByUsername("alex")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func testLocations(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+
+	assert.Error(t, store.AddSite(&models.Site{SiteName: "Taradale"}), "site names are unique")
+	assert.Error(t, store.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "A"}), "building codes are unique within a site")
+	assert.Error(t, store.AddRoom(&models.Room{BuildingID: f.BuildingID, RoomCode: "A101"}), "room codes are unique within a building")
+	assert.Error(t, store.AddRoom(&models.Room{BuildingID: f.BuildingID + 100, RoomCode: "X1"}), "rooms need an existing building")
+
+	require.NoError(t, store.AddBuilding(&models.Building{
+		SiteID:       f.SiteID,
+		BuildingCode: "B",
+		MapX:         sql.NullFloat64{Float64: 0.25, Valid: true},
+		MapY:         sql.NullFloat64{Float64: 0.75, Valid: true},
+	}))
+
+	buildings, err := store.GetAllBuildings(itoa(f.SiteID))
+	require.NoError(t, err)
+	require.Len(t, buildings, 2)
+	assert.Equal(t, "A", buildings[0].BuildingCode, "buildings are ordered by code")
+	assert.Equal(t, "B", buildings[1].BuildingCode)
+	assert.Equal(t, "Taradale", buildings[1].SiteName)
+	assert.InDelta(t, 0.25, buildings[1].MapX.Float64, 1e-9)
+	assert.InDelta(t, 0.75, buildings[1].MapY.Float64, 1e-9)
+
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: buildings[1].BuildingID, RoomCode: "B2"}))
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: buildings[1].BuildingID, RoomCode: "B1"}))
+
+	room, err := store.GetRoomByID(f.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, "A101", room.RoomCode)
+	assert.Equal(t, f.BuildingID, room.BuildingID)
+	assert.Equal(t, "A", room.BuildingCode)
+	assert.Equal(t, f.SiteID, room.SiteID)
+	assert.Equal(t, "Taradale", room.SiteName)
+
+	siteRooms, err := store.GetRoomsBySiteID(itoa(f.SiteID))
+	require.NoError(t, err)
+	require.Len(t, siteRooms, 3)
+	assert.Equal(t, []string{"A101", "B1", "B2"}, []string{siteRooms[0].RoomCode, siteRooms[1].RoomCode, siteRooms[2].RoomCode})
+
+	buildingRooms, err := store.GetAllRooms(itoa(buildings[1].BuildingID), "")
+	require.NoError(t, err)
+	assert.Len(t, buildingRooms, 2)
+
+	bySite, err := store.GetRoomByCodeAndSite("B1", f.SiteID)
+	require.NoError(t, err)
+	assert.Equal(t, buildings[1].BuildingID, bySite.BuildingID)
+
+	room.RoomCode = "A102"
+	require.NoError(t, store.UpdateRoom(room))
+	_, err = store.GetRoomByCodeAndBuilding("A101", f.BuildingID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = store.GetRoomByID(f.RoomID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = store.GetBuildingById(f.BuildingID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = store.GetSiteByName("Napier")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func testArchivedLocations(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+
+	require.NoError(t, store.ArchiveRoom(f.RoomID, "Demolished", 0))
+	require.NoError(t, store.ArchiveBuilding(itoa(f.BuildingID), "", 0))
+	require.NoError(t, store.ArchiveSite(itoa(f.SiteID), "Closed", 0))
+
+	rooms, err := store.GetAllRooms("", "")
+	require.NoError(t, err)
+	assert.Empty(t, rooms, "archived rooms are not listed")
+
+	buildings, err := store.GetAllBuildings("")
+	require.NoError(t, err)
+	assert.Empty(t, buildings, "archived buildings are not listed")
+
+	sites, err := store.GetAllSites()
+	require.NoError(t, err)
+	assert.Empty(t, sites, "archived sites are not listed")
+
+	// Archived rows are still there for the history
+	_, err = store.GetRoomByID(f.RoomID)
+	assert.NoError(t, err)
+	_, err = store.GetBuildingById(f.BuildingID)
+	assert.NoError(t, err)
+	_, err = store.GetSiteByID(itoa(f.SiteID))
+	assert.NoError(t, err)
+
+	// What is still in an archived site is not listed either
+	require.NoError(t, store.AddSite(&models.Site{SiteName: "Napier"}))
+	napier, err := store.GetSiteByName("Napier")
+	require.NoError(t, err)
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: napier.SiteID, BuildingCode: "N"}))
+	building, err := store.GetBuildingByCodeandSite("N", napier.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: building.BuildingID, RoomCode: "N1"}))
+	room, err := store.GetRoomByCodeAndBuilding("N1", building.BuildingID)
+	require.NoError(t, err)
+	addDevice(t, store, fixture{RoomID: room.RoomID, DeviceTypeID: f.DeviceTypeID}, "SN1", date(2020, time.January, 1))
+	require.NoError(t, store.ArchiveSite(itoa(napier.SiteID), "", 0))
+
+	buildings, err = store.GetAllBuildings("")
+	require.NoError(t, err)
+	assert.Empty(t, buildings)
+	rooms, err = store.GetAllRooms("", "")
+	require.NoError(t, err)
+	assert.Empty(t, rooms)
+	rooms, err = store.GetRoomsBySiteID(itoa(napier.SiteID))
+	require.NoError(t, err)
+	assert.Empty(t, rooms)
+	rooms, err = store.GetRoomsByBuildingID(itoa(building.BuildingID))
+	require.NoError(t, err)
+	assert.Empty(t, rooms)
+	devices, err := store.GetAllDevices("", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, devices, "devices in an archived site are not listed as active")
+}
+
+func testDeviceTypes(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+
+	require.NoError(t, store.AddEmergencyDeviceType(&models.EmergencyDeviceType{
+		EmergencyDeviceTypeName:  "Defibrillator",
+		InspectionIntervalMonths: 1,
+	}))
+	assert.Error(t, store.AddEmergencyDeviceType(&models.EmergencyDeviceType{
+		EmergencyDeviceTypeName:  "Defibrillator",
+		InspectionIntervalMonths: 1,
+	}), "device type names are unique")
+
+	deviceTypes, err := store.GetAllDeviceTypes()
+	require.NoError(t, err)
+	require.Len(t, deviceTypes, 2)
+	assert.Equal(t, "Defibrillator", deviceTypes[0].EmergencyDeviceTypeName, "device types are ordered by name")
+	assert.False(t, deviceTypes[0].ServiceIntervalMonths.Valid)
+
+	fireExtinguisher, err := store.GetEmergencyDeviceTypeByID(f.DeviceTypeID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, fireExtinguisher.InspectionIntervalMonths)
+	assert.Equal(t, int64(12), fireExtinguisher.ServiceIntervalMonths.Int64)
+
+	fireExtinguisher.InspectionIntervalMonths = 6
+	require.NoError(t, store.UpdateEmergencyDeviceType(fireExtinguisher))
+	updated, err := store.GetEmergencyDeviceTypeByID(f.DeviceTypeID)
+	require.NoError(t, err)
+	assert.Equal(t, 6, updated.InspectionIntervalMonths)
+
+	addDevice(t, store, f, "SN1", time.Time{})
+	assert.Error(t, store.DeleteEmergencyDeviceType(f.DeviceTypeID, 0), "device types in use cannot be deleted")
+
+	devices, err := store.GetDevicesByTypeID(f.DeviceTypeID)
+	require.NoError(t, err)
+	assert.Len(t, devices, 1)
+
+	require.NoError(t, store.DeleteEmergencyDeviceType(deviceTypes[0].EmergencyDeviceTypeID, 0))
+	_, err = store.GetDeviceTypeByName("Defibrillator")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	extinguisherTypes, err := store.GetAllExtinguisherTypes()
+	require.NoError(t, err)
+	assert.Empty(t, extinguisherTypes)
+	_, err = store.GetExtinguisherTypeByID(1)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func testDevices(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+
+	manufactured := date(2024, time.August, 1)
+	added := addDevice(t, store, f, "SN1", manufactured)
+	assert.Equal(t, f.DeviceTypeID, added.EmergencyDeviceTypeID)
+	assert.Equal(t, "Fire Extinguisher", added.EmergencyDeviceTypeName)
+	assert.Equal(t, "A101", added.RoomCode)
+	assert.Equal(t, f.BuildingID, added.BuildingID)
+	assert.Equal(t, "Taradale", added.SiteName)
+
+	assert.Error(t, store.AddEmergencyDevice(&models.EmergencyDevice{
+		EmergencyDeviceTypeID: f.DeviceTypeID,
+		RoomID:                f.RoomID + 100,
+	}), "devices need an existing room")
+
+	device, err := store.GetDeviceByID(added.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.True(t, device.ManufactureDate.Time.Equal(manufactured))
+	assert.False(t, device.DecommissionedAt.Valid)
+	assert.False(t, device.PredecessorDeviceID.Valid)
+	assert.False(t, device.SuccessorDeviceID.Valid)
+
+	devices, err := store.GetAllDevices("", "", "")
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	listed := devices[0]
+	assert.Equal(t, "A", listed.BuildingCode)
+	assert.Equal(t, "Taradale", listed.SiteName)
+	assert.Equal(t, "N/A", listed.Description.String, "missing details are listed as N/A")
+	assert.False(t, listed.Description.Valid)
+	assert.Equal(t, "N/A", listed.ExtinguisherTypeName.String)
+	assert.True(t, listed.ExpireDate.Time.Equal(siteDate(t, 2029, time.August, 1)), "fire extinguishers expire five years after manufacture")
+	assert.False(t, listed.NextInspectionDate.Valid, "never inspected devices have no next inspection")
+	assert.True(t, listed.NextServiceDate.Time.Equal(siteDate(t, 2025, time.August, 1)), "never serviced devices are due one interval after manufacture")
+	assert.True(t, listed.NextDueDate.Time.Equal(listed.NextServiceDate.Time))
+
+	device.Description = sql.NullString{String: "By the door", Valid: true}
+	device.Size = sql.NullString{String: "2kg", Valid: true}
+	require.NoError(t, store.UpdateEmergencyDevice(device))
+	require.NoError(t, store.UpdateDeviceStatus(device.EmergencyDeviceID, "Inspection Due"))
+
+	updated, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "By the door", updated.Description.String)
+	assert.Equal(t, "2kg", updated.Size.String)
+	assert.Equal(t, "Inspection Due", updated.Status.String)
+
+	_, err = store.GetDeviceByID(device.EmergencyDeviceID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func testDeviceFilters(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", time.Time{})
+
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "B"}))
+
+	devices, err := store.GetAllDevices(itoa(f.SiteID), "A", "")
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, device.EmergencyDeviceID, devices[0].EmergencyDeviceID)
+
+	devices, err = store.GetAllDevices("", "B", "")
+	require.NoError(t, err)
+	assert.NotNil(t, devices, "an existing building without devices lists none")
+	assert.Empty(t, devices)
+
+	devices, err = store.GetAllDevices(itoa(f.SiteID+100), "", "")
+	require.NoError(t, err)
+	assert.Empty(t, devices)
+}
+
+func testDecommissionAndPurge(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", time.Time{})
+
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Date(2024, time.July, 15, 10, 0, 0, 0, time.UTC), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+
+	require.NoError(t, store.DecommissionEmergencyDevice(device.EmergencyDeviceID, "Damaged", 0))
+	assert.ErrorIs(t, store.DecommissionEmergencyDevice(device.EmergencyDeviceID, "Damaged", 0), sql.ErrNoRows, "a device is decommissioned once")
+	assert.ErrorIs(t, store.DecommissionEmergencyDevice(device.EmergencyDeviceID+100, "Damaged", 0), sql.ErrNoRows)
+
+	active, err := store.GetAllDevices("", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, active, "decommissioned devices are not listed")
+
+	roomDevices, err := store.GetDevicesByRoomID(f.RoomID)
+	require.NoError(t, err)
+	assert.Empty(t, roomDevices)
+
+	decommissioned, err := store.GetDecommissionedDevices("", "", "")
+	require.NoError(t, err)
+	require.Len(t, decommissioned, 1)
+	assert.Equal(t, "Decommissioned", decommissioned[0].Status.String)
+	assert.Equal(t, "Damaged", decommissioned[0].DecommissionReason.String)
+	assert.True(t, decommissioned[0].DecommissionedAt.Valid)
+
+	// The history stays until the device is purged
+	inspections, err := store.GetAllInspectionsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Len(t, inspections, 1)
+	assert.Error(t, store.DeleteUser(f.UserID, 0), "users with inspections cannot be deleted")
+
+	require.NoError(t, store.PurgeEmergencyDevice(device.EmergencyDeviceID))
+	_, err = store.GetDeviceByID(device.EmergencyDeviceID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	inspections, err = store.GetAllInspectionsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Empty(t, inspections)
+}
+
+func testReplaceDevice(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	old := addDevice(t, store, f, "SN1", date(2020, time.January, 1))
+
+	old.Description = sql.NullString{String: "By the door", Valid: true}
+	old.Size = sql.NullString{String: "2kg", Valid: true}
+	require.NoError(t, store.UpdateEmergencyDevice(&old))
+
+	replacement := &models.EmergencyDevice{
+		SerialNumber:    sql.NullString{String: "SN2", Valid: true},
+		ManufactureDate: sql.NullTime{Time: date(2025, time.January, 1), Valid: true},
+	}
+	current, err := store.GetDeviceByID(old.EmergencyDeviceID)
+	require.NoError(t, err)
+	_, err = store.ReplaceEmergencyDevice(old.EmergencyDeviceID, &models.EmergencyDevice{}, "Stale", old.Version)
+	assert.ErrorIs(t, err, database.ErrVersionConflict, "only the version the replacement was made from is replaced")
+
+	newDeviceID, err := store.ReplaceEmergencyDevice(old.EmergencyDeviceID, replacement, "Expired", current.Version)
+	require.NoError(t, err)
+	assert.Equal(t, newDeviceID, replacement.EmergencyDeviceID)
+
+	created, err := store.GetDeviceByID(newDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, f.RoomID, created.RoomID, "the replacement goes in the same room")
+	assert.Equal(t, "By the door", created.Description.String)
+	assert.Equal(t, "2kg", created.Size.String, "details left empty are carried over")
+	assert.Equal(t, "Active", created.Status.String)
+	assert.Equal(t, int64(old.EmergencyDeviceID), created.PredecessorDeviceID.Int64)
+
+	replaced, err := store.GetDeviceByID(old.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.True(t, replaced.DecommissionedAt.Valid)
+	assert.Equal(t, int64(newDeviceID), replaced.SuccessorDeviceID.Int64)
+	assert.Contains(t, replaced.DecommissionReason.String, "Expired")
+
+	_, err = store.ReplaceEmergencyDevice(old.EmergencyDeviceID, &models.EmergencyDevice{}, "Again", 0)
+	assert.ErrorIs(t, err, database.ErrDeviceDecommissioned)
+	_, err = store.ReplaceEmergencyDevice(newDeviceID+100, &models.EmergencyDevice{}, "Missing", 0)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	for _, deviceID := range []int{old.EmergencyDeviceID, newDeviceID} {
+		chain, err := store.GetDeviceReplacementChain(deviceID)
+		require.NoError(t, err)
+		require.Len(t, chain, 2)
+		assert.Equal(t, old.EmergencyDeviceID, chain[0].EmergencyDeviceID, "the chain is ordered oldest first")
+		assert.Equal(t, newDeviceID, chain[1].EmergencyDeviceID)
+	}
+
+	_, err = store.GetDeviceReplacementChain(newDeviceID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func testInspections(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", time.Now().AddDate(-1, 0, 0))
+
+	inspect := func(inspectedAt time.Time, status string) {
+		t.Helper()
+		require.NoError(t, store.AddInspection(&models.Inspection{
+			EmergencyDeviceID:  device.EmergencyDeviceID,
+			UserID:             f.UserID,
+			InspectionDateTime: sql.NullTime{Time: inspectedAt, Valid: true},
+			IsConspicuous:      sql.NullBool{Bool: true, Valid: true},
+			InspectionStatus:   status,
+			Notes:              sql.NullString{String: status, Valid: true},
+		}))
+	}
+
+	first := time.Date(2024, time.July, 15, 10, 0, 0, 0, time.UTC)
+	inspect(first, "Failed")
+
+	failed, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Inspection Failed", failed.Status.String)
+
+	second := first.AddDate(0, 1, 0)
+	inspect(second, "Passed")
+	passed, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Active", passed.Status.String)
+	assert.True(t, passed.LastInspectionDateTime.Time.Equal(second), "inspection times are stored as instants")
+	assert.Equal(t, "Pacific/Auckland", passed.LastInspectionDateTime.Time.Location().String(), "and read back in the site's time zone")
+
+	// A late entry for an older inspection does not change the device
+	inspect(first.AddDate(0, 0, 1), "Failed")
+	unchanged, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Active", unchanged.Status.String)
+	assert.True(t, unchanged.LastInspectionDateTime.Time.Equal(passed.LastInspectionDateTime.Time))
+
+	inspections, err := store.GetAllInspectionsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	require.Len(t, inspections, 3)
+	assert.Equal(t, "Passed", inspections[0].InspectionStatus, "inspections are ordered latest first")
+	assert.Equal(t, "inspector", inspections[0].InspectorName)
+	assert.Equal(t, "SN1", inspections[0].SerialNumber)
+	assert.True(t, inspections[0].IsConspicuous.Bool)
+	assert.True(t, inspections[0].IsAccessible.Valid, "unanswered checklist items are stored as false")
+	assert.False(t, inspections[0].IsAccessible.Bool)
+
+	inspection, err := store.GetInspectionByID(inspections[1].EmergencyDeviceInspectionID)
+	require.NoError(t, err)
+	assert.Equal(t, inspections[1].Notes.String, inspection.Notes.String)
+	_, err = store.GetInspectionByID(inspections[0].EmergencyDeviceInspectionID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	recent, err := store.CountRecentInspections(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 3, recent, "inspections are counted by when they were recorded, not when they were carried out")
+
+	devices, err := store.GetAllDevices("", "", "")
+	require.NoError(t, err)
+	listed, ok := findDevice(devices, device.EmergencyDeviceID)
+	require.True(t, ok)
+	assert.True(t, listed.NextInspectionDate.Time.Equal(siteDate(t, 2024, time.November, 15)),
+		"the next inspection is one device type interval after the last")
+
+	// Decommissioned devices keep the status they were decommissioned with
+	require.NoError(t, store.DecommissionEmergencyDevice(device.EmergencyDeviceID, "Removed", 0))
+	inspect(second.AddDate(0, 1, 0), "Failed")
+	decommissioned, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Decommissioned", decommissioned.Status.String)
+}
+
+func testInspectionOfExpiredDevice(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", date(2015, time.March, 1))
+
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+
+	expired, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Expired", expired.Status.String, "passing an inspection does not make an expired device active")
+}
+
+func testInspectionRounds(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	first := addDevice(t, store, f, "SN1", time.Now().AddDate(-1, 0, 0))
+
+	// A second device downstairs of the first comes first in the round
+	basementID, err := store.AddFloor(&models.Floor{BuildingID: f.BuildingID, FloorName: "Basement", FloorLevel: -1})
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: f.BuildingID, FloorID: basementID, RoomCode: "B001"}))
+	basement, err := store.GetRoomByCodeAndBuilding("B001", f.BuildingID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddEmergencyDevice(&models.EmergencyDevice{
+		EmergencyDeviceTypeID: f.DeviceTypeID,
+		RoomID:                basement.RoomID,
+		SerialNumber:          sql.NullString{String: "SN2", Valid: true},
+	}))
+	downstairs, err := store.GetDevicesByRoomID(basement.RoomID)
+	require.NoError(t, err)
+	require.Len(t, downstairs, 1)
+	second := downstairs[0]
+
+	round := &models.InspectionRound{
+		SiteID:      f.SiteID,
+		BuildingID:  sql.NullInt64{Int64: int64(f.BuildingID), Valid: true},
+		PeriodStart: date(2024, time.November, 1),
+		PeriodEnd:   date(2024, time.November, 30),
+		DeviceIDs:   []int{first.EmergencyDeviceID, second.EmergencyDeviceID},
+	}
+	roundID, err := store.AddInspectionRound(round)
+	require.NoError(t, err)
+	_, err = store.AddInspectionRound(round)
+	assert.Error(t, err, "a building has one round per period")
+
+	stored, err := store.GetInspectionRoundByID(roundID)
+	require.NoError(t, err)
+	assert.Equal(t, "Taradale", stored.SiteName)
+	assert.Equal(t, "A", stored.BuildingCode.String)
+	assert.False(t, stored.UserID.Valid, "rounds start unassigned")
+	assert.True(t, stored.PeriodStart.Equal(date(2024, time.November, 1)))
+	assert.Equal(t, 2, stored.Remaining)
+	require.Len(t, stored.Devices, 2)
+	assert.Equal(t, second.EmergencyDeviceID, stored.Devices[0].EmergencyDeviceID, "devices are ordered by floor and room")
+	assert.Equal(t, "B001", stored.Devices[0].RoomCode)
+	assert.Equal(t, "Basement", stored.Devices[0].FloorName)
+	assert.Equal(t, models.RoundDeviceRemaining, stored.Devices[0].Status)
+
+	require.NoError(t, store.AssignInspectionRound(roundID, f.UserID))
+	assert.ErrorIs(t, store.AssignInspectionRound(roundID+100, f.UserID), sql.ErrNoRows)
+	mine, err := store.GetInspectionRounds(f.UserID)
+	require.NoError(t, err)
+	require.Len(t, mine, 1)
+	assert.Equal(t, "inspector", mine[0].InspectorName.String)
+	others, err := store.GetInspectionRounds(f.UserID + 100)
+	require.NoError(t, err)
+	assert.Empty(t, others)
+
+	// Inspections in the period complete their device, ones outside it do not
+	inspect := func(deviceID int, inspectedAt time.Time) {
+		t.Helper()
+		require.NoError(t, store.AddInspection(&models.Inspection{
+			EmergencyDeviceID:  deviceID,
+			UserID:             f.UserID,
+			InspectionDateTime: sql.NullTime{Time: inspectedAt, Valid: true},
+			InspectionStatus:   "Passed",
+		}))
+	}
+	inspect(first.EmergencyDeviceID, time.Date(2024, time.October, 31, 10, 0, 0, 0, time.UTC))
+	inspect(first.EmergencyDeviceID, time.Date(2024, time.November, 30, 12, 0, 0, 0, time.UTC))
+	stored, err = store.GetInspectionRoundByID(roundID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stored.Remaining, "the period is in the site's time zone, November 30 12:00 UTC is December 1 in Taradale")
+
+	inspect(first.EmergencyDeviceID, time.Date(2024, time.October, 31, 12, 0, 0, 0, time.UTC))
+	stored, err = store.GetInspectionRoundByID(roundID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.Inspected, "October 31 12:00 UTC is November 1 in Taradale")
+	assert.Equal(t, models.RoundDeviceInspected, stored.Devices[1].Status)
+	assert.True(t, stored.Devices[1].EmergencyDeviceInspectionID.Valid)
+
+	require.NoError(t, store.SkipInspectionRoundDevice(roundID, second.EmergencyDeviceID, "Room locked"))
+	assert.ErrorIs(t, store.SkipInspectionRoundDevice(roundID, first.EmergencyDeviceID, "Too late"), sql.ErrNoRows,
+		"inspected devices cannot be skipped")
+
+	rounds, err := store.GetInspectionRounds(0)
+	require.NoError(t, err)
+	require.Len(t, rounds, 1)
+	assert.Equal(t, 1, rounds[0].Inspected)
+	assert.Equal(t, 1, rounds[0].Skipped)
+	assert.Equal(t, 0, rounds[0].Remaining)
+
+	stored, err = store.GetInspectionRoundByID(roundID)
+	require.NoError(t, err)
+	assert.Equal(t, "Room locked", stored.Devices[0].SkipReason.String)
+}
+
+func testSiteTimeZones(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+
+	site, err := store.GetSiteByID(itoa(f.SiteID))
+	require.NoError(t, err)
+	assert.Equal(t, "Pacific/Auckland", site.TimeZone, "sites added without a time zone get the default")
+
+	site.TimeZone = "Europe/London"
+	require.NoError(t, store.UpdateSite(site))
+	sites, err := store.GetAllSites()
+	require.NoError(t, err)
+	require.Len(t, sites, 1)
+	assert.Equal(t, "Europe/London", sites[0].TimeZone)
+
+	london, err := time.LoadLocation("Europe/London")
+	require.NoError(t, err)
+
+	// 23:30 UTC on the 15th is already the 16th in London
+	device := addDevice(t, store, f, "SN1", date(2024, time.March, 1))
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Date(2024, time.August, 15, 23, 30, 0, 0, time.UTC), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+
+	devices, err := store.GetAllDevices("", "", "")
+	require.NoError(t, err)
+	listed, ok := findDevice(devices, device.EmergencyDeviceID)
+	require.True(t, ok)
+	assert.Equal(t, "Europe/London", listed.SiteTimeZone)
+	assert.Equal(t, 16, listed.LastInspectionDateTime.Time.Day(), "times are read in the site's time zone")
+	assert.True(t, listed.NextInspectionDate.Time.Equal(time.Date(2024, time.November, 16, 0, 0, 0, 0, london)),
+		"due dates are midnight at the site, counted from the local day of the inspection")
+	assert.True(t, listed.ExpireDate.Time.Equal(time.Date(2029, time.March, 1, 0, 0, 0, 0, london)))
+
+	inspections, err := store.GetAllInspectionsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	require.Len(t, inspections, 1)
+	assert.Equal(t, "Europe/London", inspections[0].SiteTimeZone)
+	assert.Equal(t, "Europe/London", inspections[0].InspectionDateTime.Time.Location().String())
+}
+
+func testMaintenanceRecords(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", date(2020, time.January, 1))
+
+	addRecord := func(serviceDate time.Time, nextServiceDate sql.NullTime, attachments ...models.MaintenanceAttachment) int {
+		t.Helper()
+		id, err := store.AddMaintenanceRecord(&models.MaintenanceRecord{
+			EmergencyDeviceID: device.EmergencyDeviceID,
+			UserID:            f.UserID,
+			ServiceType:       models.ServiceTypeService,
+			ServiceDate:       sql.NullTime{Time: serviceDate, Valid: true},
+			Provider:          "Fire Co",
+			Cost:              sql.NullFloat64{Float64: 120.5, Valid: true},
+			NextServiceDate:   nextServiceDate,
+			Attachments:       attachments,
+		})
+		require.NoError(t, err)
+		return id
+	}
+
+	firstID := addRecord(date(2024, time.January, 10), sql.NullTime{})
+	latestID := addRecord(date(2024, time.June, 10), sql.NullTime{Time: date(2025, time.March, 1), Valid: true},
+		models.MaintenanceAttachment{FileName: "certificate.pdf", FilePath: "/static/maintenance_attachments/certificate.pdf"})
+
+	_, err := store.AddMaintenanceRecord(&models.MaintenanceRecord{
+		EmergencyDeviceID: device.EmergencyDeviceID,
+		UserID:            f.UserID,
+		ServiceType:       "Polish",
+		ServiceDate:       sql.NullTime{Time: date(2024, time.June, 10), Valid: true},
+		Provider:          "Fire Co",
+	})
+	assert.Error(t, err, "service types are restricted")
+
+	records, err := store.GetMaintenanceRecordsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, latestID, records[0].MaintenanceRecordID, "records are ordered latest service first")
+	assert.Equal(t, firstID, records[1].MaintenanceRecordID)
+	assert.Equal(t, "inspector", records[0].RecordedBy)
+	assert.Equal(t, "SN1", records[0].SerialNumber.String)
+	assert.InDelta(t, 120.5, records[0].Cost.Float64, 1e-9)
+
+	record, err := store.GetMaintenanceRecordByID(latestID)
+	require.NoError(t, err)
+	require.Len(t, record.Attachments, 1)
+	assert.Equal(t, "certificate.pdf", record.Attachments[0].FileName)
+
+	withoutAttachments, err := store.GetMaintenanceRecordByID(firstID)
+	require.NoError(t, err)
+	assert.NotNil(t, withoutAttachments.Attachments)
+	assert.Empty(t, withoutAttachments.Attachments)
+
+	_, err = store.GetMaintenanceRecordByID(latestID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	devices, err := store.GetAllDevices("", "", "")
+	require.NoError(t, err)
+	listed, ok := findDevice(devices, device.EmergencyDeviceID)
+	require.True(t, ok)
+	assert.True(t, listed.LastServiceDate.Time.Equal(date(2024, time.June, 10)))
+	assert.True(t, listed.NextServiceDate.Time.Equal(siteDate(t, 2025, time.March, 1)), "the contractor's next service date wins")
+
+	emptyRecords, err := store.GetMaintenanceRecordsByDeviceID(device.EmergencyDeviceID + 100)
+	require.NoError(t, err)
+	assert.NotNil(t, emptyRecords)
+	assert.Empty(t, emptyRecords)
+}
+
+func testFloorPlans(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	failed := addDevice(t, store, f, "SN1", time.Time{})
+	active := addDevice(t, store, f, "SN2", time.Time{})
+	require.NoError(t, store.UpdateDeviceStatus(failed.EmergencyDeviceID, "Inspection Failed"))
+
+	floors, err := store.GetFloorsByBuildingID(f.BuildingID)
+	require.NoError(t, err)
+	require.Len(t, floors, 1)
+	groundFloorID := floors[0].FloorID
+	upperFloorID, err := store.AddFloor(&models.Floor{BuildingID: f.BuildingID, FloorName: "Level 1", FloorLevel: 1})
+	require.NoError(t, err)
+
+	upperID, err := store.AddFloorPlan(&models.FloorPlan{FloorID: upperFloorID, ImagePath: "/static/floor_plans/a1.svg"})
+	require.NoError(t, err)
+	groundID, err := store.AddFloorPlan(&models.FloorPlan{FloorID: groundFloorID, ImagePath: "/static/floor_plans/a0.svg"})
+	require.NoError(t, err)
+	_, err = store.AddFloorPlan(&models.FloorPlan{FloorID: groundFloorID, ImagePath: "/static/floor_plans/x.svg"})
+	assert.Error(t, err, "a floor has one plan")
+	_, err = store.AddFloorPlan(&models.FloorPlan{FloorID: upperFloorID + 100, ImagePath: "/static/floor_plans/x.svg"})
+	assert.ErrorIs(t, err, sql.ErrNoRows, "a plan is of an existing floor")
+
+	floorPlans, err := store.GetFloorPlansByBuildingID(f.BuildingID)
+	require.NoError(t, err)
+	require.Len(t, floorPlans, 2)
+	assert.Equal(t, groundID, floorPlans[0].FloorPlanID, "floor plans are ordered lowest floor first")
+	assert.Equal(t, groundFloorID, floorPlans[0].FloorID)
+	assert.Equal(t, f.BuildingID, floorPlans[0].BuildingID, "the building comes from the floor")
+	assert.Equal(t, "A", floorPlans[0].BuildingCode)
+	assert.Equal(t, f.SiteID, floorPlans[0].SiteID)
+	assert.Equal(t, "Ground", floorPlans[0].FloorLabel)
+
+	floors, err = store.GetFloorsByBuildingID(f.BuildingID)
+	require.NoError(t, err)
+	require.Len(t, floors, 2)
+	assert.Equal(t, int64(upperID), floors[1].FloorPlanID.Int64)
+	assert.Equal(t, "/static/floor_plans/a1.svg", floors[1].ImagePath.String)
+
+	roomPinID, err := store.AddFloorPlanPin(&models.FloorPlanPin{
+		FloorPlanID: groundID,
+		RoomID:      sql.NullInt64{Int64: int64(f.RoomID), Valid: true},
+		X:           0.1,
+		Y:           0.2,
+	})
+	require.NoError(t, err)
+	devicePinID, err := store.AddFloorPlanPin(&models.FloorPlanPin{
+		FloorPlanID:       groundID,
+		EmergencyDeviceID: sql.NullInt64{Int64: int64(active.EmergencyDeviceID), Valid: true},
+		X:                 0.3,
+		Y:                 0.4,
+	})
+	require.NoError(t, err)
+
+	_, err = store.AddFloorPlanPin(&models.FloorPlanPin{
+		FloorPlanID: groundID,
+		RoomID:      sql.NullInt64{Int64: int64(f.RoomID), Valid: true},
+	})
+	assert.Error(t, err, "a room is pinned once per floor plan")
+	_, err = store.AddFloorPlanPin(&models.FloorPlanPin{FloorPlanID: groundID, X: 0.5, Y: 0.5})
+	assert.Error(t, err, "a pin marks a room or a device")
+
+	pins, err := store.GetFloorPlanPins(groundID)
+	require.NoError(t, err)
+	require.Len(t, pins, 2)
+	assert.Equal(t, roomPinID, pins[0].FloorPlanPinID)
+	assert.Equal(t, "A101", pins[0].RoomCode.String)
+	assert.Equal(t, "Inspection Failed", pins[0].Status.String, "room pins take the most urgent device status")
+	assert.Equal(t, devicePinID, pins[1].FloorPlanPinID)
+	assert.Equal(t, "Active", pins[1].Status.String)
+	assert.Equal(t, "SN2", pins[1].SerialNumber.String)
+	assert.Equal(t, "Fire Extinguisher", pins[1].EmergencyDeviceTypeName.String)
+	assert.Equal(t, "A101", pins[1].RoomCode.String)
+
+	require.NoError(t, store.MoveFloorPlanPin(devicePinID, 0.9, 0.8))
+	assert.ErrorIs(t, store.MoveFloorPlanPin(devicePinID+100, 0.9, 0.8), sql.ErrNoRows)
+
+	// Decommissioned devices are left off the plan
+	require.NoError(t, store.DecommissionEmergencyDevice(active.EmergencyDeviceID, "Removed", 0))
+	pins, err = store.GetFloorPlanPins(groundID)
+	require.NoError(t, err)
+	require.Len(t, pins, 1)
+
+	require.NoError(t, store.DeleteFloorPlanPin(roomPinID))
+	assert.ErrorIs(t, store.DeleteFloorPlanPin(roomPinID), sql.ErrNoRows)
+
+	require.NoError(t, store.DeleteFloorPlan(groundID))
+	assert.ErrorIs(t, store.DeleteFloorPlan(groundID), sql.ErrNoRows)
+	_, err = store.GetFloorPlanByID(groundID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.ErrorIs(t, store.MoveFloorPlanPin(devicePinID, 0.5, 0.5), sql.ErrNoRows, "pins are deleted with their floor plan")
+
+	upper, err := store.GetFloorPlanByID(upperID)
+	require.NoError(t, err)
+	assert.Equal(t, "Level 1", upper.FloorLabel)
+	assert.Equal(t, 1, upper.FloorLevel)
+
+	// Renaming the floor renames its plan, deleting the floor deletes its plan
+	require.NoError(t, store.UpdateFloor(&models.Floor{FloorID: upperFloorID, FloorName: "First", FloorLevel: 1}))
+	upper, err = store.GetFloorPlanByID(upperID)
+	require.NoError(t, err)
+	assert.Equal(t, "First", upper.FloorLabel)
+	require.NoError(t, store.DeleteFloor(upperFloorID, 0))
+	_, err = store.GetFloorPlanByID(upperID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func testFloors(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+
+	// Buildings start with a ground floor, rooms added without a floor go on it
+	floors, err := store.GetFloorsByBuildingID(f.BuildingID)
+	require.NoError(t, err)
+	require.Len(t, floors, 1)
+	ground := floors[0]
+	assert.Equal(t, "Ground", ground.FloorName)
+	assert.Equal(t, 0, ground.FloorLevel)
+	assert.Equal(t, "A", ground.BuildingCode)
+	assert.False(t, ground.FloorPlanID.Valid)
+
+	room, err := store.GetRoomByID(f.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, ground.FloorID, room.FloorID)
+	assert.Equal(t, "Ground", room.FloorName)
+
+	upperID, err := store.AddFloor(&models.Floor{BuildingID: f.BuildingID, FloorName: "Level 1", FloorLevel: 1})
+	require.NoError(t, err)
+	basementID, err := store.AddFloor(&models.Floor{BuildingID: f.BuildingID, FloorName: "Basement", FloorLevel: -1})
+	require.NoError(t, err)
+	_, err = store.AddFloor(&models.Floor{BuildingID: f.BuildingID, FloorName: "Mezzanine", FloorLevel: 1})
+	assert.Error(t, err, "a building has one floor per level")
+	_, err = store.AddFloor(&models.Floor{BuildingID: f.BuildingID + 100, FloorName: "Nowhere", FloorLevel: 0})
+	assert.Error(t, err, "a floor belongs to an existing building")
+
+	floors, err = store.GetFloorsByBuildingID(f.BuildingID)
+	require.NoError(t, err)
+	require.Len(t, floors, 3)
+	assert.Equal(t, []int{basementID, ground.FloorID, upperID}, []int{floors[0].FloorID, floors[1].FloorID, floors[2].FloorID}, "floors are ordered lowest first")
+
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: f.BuildingID, FloorID: upperID, RoomCode: "A201"}))
+	upperRoom, err := store.GetRoomByCodeAndBuilding("A201", f.BuildingID)
+	require.NoError(t, err)
+
+	// A room's floor must be in the room's building
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "B"}))
+	buildingB, err := store.GetBuildingByCodeandSite("B", f.SiteID)
+	require.NoError(t, err)
+	assert.Error(t, store.AddRoom(&models.Room{BuildingID: buildingB.BuildingID, FloorID: upperID, RoomCode: "B101"}))
+
+	rooms, err := store.GetAllRooms(itoa(f.BuildingID), "")
+	require.NoError(t, err)
+	require.Len(t, rooms, 2)
+	assert.Equal(t, "A101", rooms[0].RoomCode, "rooms are ordered by floor")
+	assert.Equal(t, "A201", rooms[1].RoomCode)
+	assert.Equal(t, "Level 1", rooms[1].FloorName)
+	assert.Equal(t, 1, rooms[1].FloorLevel)
+
+	rooms, err = store.GetAllRooms("", itoa(upperID))
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	assert.Equal(t, upperRoom.RoomID, rooms[0].RoomID)
+
+	buildingRooms, err := store.GetRoomsByBuildingID(itoa(f.BuildingID))
+	require.NoError(t, err)
+	require.Len(t, buildingRooms, 2)
+	assert.Equal(t, upperID, buildingRooms[1].FloorID)
+
+	// Devices can be filtered by floor
+	addDevice(t, store, f, "SN1", time.Time{})
+	upperDevice := addDevice(t, store, fixture{RoomID: upperRoom.RoomID, DeviceTypeID: f.DeviceTypeID}, "SN2", time.Time{})
+	devices, err := store.GetAllDevices("", "", itoa(upperID))
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, upperDevice.EmergencyDeviceID, devices[0].EmergencyDeviceID)
+	assert.Equal(t, upperID, devices[0].FloorID)
+	assert.Equal(t, "Level 1", devices[0].FloorName)
+	devices, err = store.GetAllDevices("", "", itoa(basementID))
+	require.NoError(t, err)
+	assert.NotNil(t, devices, "an existing floor without devices gives an empty list")
+	assert.Empty(t, devices)
+
+	// Updating a room without a floor keeps its floor, moving it to another building puts it on that building's default floor
+	require.NoError(t, store.UpdateRoom(&models.Room{RoomID: upperRoom.RoomID, BuildingID: f.BuildingID, RoomCode: "A202"}))
+	moved, err := store.GetRoomByID(upperRoom.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, upperID, moved.FloorID)
+	require.NoError(t, store.UpdateRoom(&models.Room{RoomID: upperRoom.RoomID, BuildingID: f.BuildingID, FloorID: basementID, RoomCode: "A202"}))
+	moved, err = store.GetRoomByID(upperRoom.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, basementID, moved.FloorID)
+	require.NoError(t, store.UpdateRoom(&models.Room{RoomID: upperRoom.RoomID, BuildingID: buildingB.BuildingID, RoomCode: "B202"}))
+	moved, err = store.GetRoomByID(upperRoom.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, "Ground", moved.FloorName)
+	assert.Equal(t, buildingB.BuildingID, moved.BuildingID)
+
+	// The default floor is the one nearest the ground, above ground first
+	require.NoError(t, store.DeleteFloor(upperID, 0))
+	require.NoError(t, store.UpdateFloor(&models.Floor{FloorID: ground.FloorID, FloorName: "Level 2", FloorLevel: 2}))
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: f.BuildingID, RoomCode: "A301"}))
+	defaulted, err := store.GetRoomByCodeAndBuilding("A301", f.BuildingID)
+	require.NoError(t, err)
+	defaultedRoom, err := store.GetRoomByID(defaulted.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, basementID, defaultedRoom.FloorID)
+
+	assert.Error(t, store.UpdateFloor(&models.Floor{FloorID: basementID, FloorName: "Level 2", FloorLevel: 2}), "a building has one floor per level")
+	assert.ErrorIs(t, store.UpdateFloor(&models.Floor{FloorID: basementID + 100, FloorName: "Nowhere", FloorLevel: 5}), sql.ErrNoRows)
+
+	// Floors with rooms, archived or not, cannot be deleted
+	assert.Error(t, store.DeleteFloor(basementID, 0))
+	require.NoError(t, store.ArchiveRoom(defaulted.RoomID, "", 0))
+	assert.Error(t, store.DeleteFloor(basementID, 0))
+	assert.ErrorIs(t, store.DeleteFloor(basementID+100, 0), sql.ErrNoRows)
+	_, err = store.GetFloorByID(basementID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+// auckland is the zone inspection times are read back in
+func testSync(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", time.Now().AddDate(-1, 0, 0))
+
+	first, err := store.GetSyncChanges(0)
+	require.NoError(t, err)
+	require.Len(t, first.Devices, 1, "a first sync downloads everything")
+	assert.Equal(t, device.EmergencyDeviceID, first.Devices[0].EmergencyDeviceID)
+	assert.Equal(t, f.RoomID, first.Devices[0].RoomID)
+	assert.True(t, first.Devices[0].UpdatedAt.Valid)
+	assert.True(t, first.Devices[0].ExpireDate.Valid, "devices are listed with their calculated dates")
+	require.Len(t, first.Rooms, 1)
+	assert.Equal(t, f.RoomID, first.Rooms[0].RoomID)
+	assert.Equal(t, f.SiteID, first.Rooms[0].SiteID)
+	assert.Equal(t, "Ground", first.Rooms[0].FloorName)
+	assert.Empty(t, first.RemovedDeviceIDs)
+	assert.Empty(t, first.RemovedRoomIDs)
+
+	unchanged, err := store.GetSyncChanges(first.Cursor)
+	require.NoError(t, err)
+	assert.Empty(t, unchanged.Devices, "nothing changed since the last sync")
+	assert.Empty(t, unchanged.Rooms)
+	assert.GreaterOrEqual(t, unchanged.Cursor, first.Cursor)
+
+	// An inspection captured offline is recorded once, its status change is downloaded by the next sync
+	clientID := "0b7f2a8e-3c1d-4e5f-9a6b-7c8d9e0f1a2b"
+	inspection := &models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true},
+		InspectionStatus:   "Failed",
+		ClientID:           sql.NullString{String: clientID, Valid: true},
+	}
+	require.NoError(t, store.AddInspection(inspection))
+	assert.Error(t, store.AddInspection(inspection), "a client ID is recorded once")
+
+	recorded, err := store.GetInspectionByClientID(clientID)
+	require.NoError(t, err)
+	assert.Equal(t, clientID, recorded.ClientID.String)
+	assert.Equal(t, "Failed", recorded.InspectionStatus)
+	_, err = store.GetInspectionByClientID("1b7f2a8e-3c1d-4e5f-9a6b-7c8d9e0f1a2b")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	inspected, err := store.GetSyncChanges(unchanged.Cursor)
+	require.NoError(t, err)
+	require.Len(t, inspected.Devices, 1)
+	assert.Equal(t, "Inspection Failed", inspected.Devices[0].Status.String)
+	assert.Empty(t, inspected.Rooms)
+
+	// Devices taken out of service and archived rooms are listed as removed
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: f.BuildingID, RoomCode: "A102"}))
+	room, err := store.GetRoomByCodeAndBuilding("A102", f.BuildingID)
+	require.NoError(t, err)
+	require.NoError(t, store.ArchiveRoom(room.RoomID, "Demolished", 0))
+	require.NoError(t, store.DecommissionEmergencyDevice(device.EmergencyDeviceID, "Removed", 0))
+
+	removed, err := store.GetSyncChanges(inspected.Cursor)
+	require.NoError(t, err)
+	assert.Empty(t, removed.Devices)
+	assert.Empty(t, removed.Rooms)
+	assert.Equal(t, []int{device.EmergencyDeviceID}, removed.RemovedDeviceIDs)
+	assert.Equal(t, []int{room.RoomID}, removed.RemovedRoomIDs)
+
+	// A change committed after a sync read its cursor reaches that sync or the next one
+	other := addDevice(t, store, f, "SN2", time.Now().AddDate(-1, 0, 0))
+	before, err := store.GetSyncChanges(removed.Cursor)
+	require.NoError(t, err)
+	var during *models.SyncChanges
+	require.NoError(t, store.WithTx(func(tx database.Store) error {
+		if err := tx.UpdateDeviceStatus(other.EmergencyDeviceID, "Inactive"); err != nil {
+			return err
+		}
+		var err error
+		during, err = store.GetSyncChanges(before.Cursor)
+		return err
+	}))
+	after, err := store.GetSyncChanges(during.Cursor)
+	require.NoError(t, err)
+
+	var synced []string
+	for _, changes := range []*models.SyncChanges{during, after} {
+		for _, changed := range changes.Devices {
+			synced = append(synced, changed.Status.String)
+		}
+	}
+	assert.Contains(t, synced, "Inactive")
+}
+
+func testSyncLocations(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", time.Now().AddDate(-1, 0, 0))
+
+	// A second building and a second site, each with a room and a device
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "B"}))
+	building, err := store.GetBuildingByCodeandSite("B", f.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: building.BuildingID, RoomCode: "B101"}))
+	buildingRoom, err := store.GetRoomByCodeAndBuilding("B101", building.BuildingID)
+	require.NoError(t, err)
+	inBuilding := f
+	inBuilding.RoomID = buildingRoom.RoomID
+	buildingDevice := addDevice(t, store, inBuilding, "SN2", time.Now().AddDate(-1, 0, 0))
+
+	require.NoError(t, store.AddSite(&models.Site{SiteName: "Napier"}))
+	site, err := store.GetSiteByName("Napier")
+	require.NoError(t, err)
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: site.SiteID, BuildingCode: "N"}))
+	siteBuilding, err := store.GetBuildingByCodeandSite("N", site.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: siteBuilding.BuildingID, RoomCode: "N101"}))
+	siteRoom, err := store.GetRoomByCodeAndBuilding("N101", siteBuilding.BuildingID)
+	require.NoError(t, err)
+	inSite := f
+	inSite.RoomID = siteRoom.RoomID
+	siteDevice := addDevice(t, store, inSite, "SN3", time.Now().AddDate(-1, 0, 0))
+
+	first, err := store.GetSyncChanges(0)
+	require.NoError(t, err)
+	require.Len(t, first.Devices, 3)
+	require.Len(t, first.Rooms, 3)
+
+	// Renaming a building or site sends its rooms and devices again with the new names
+	fixtureBuilding, err := store.GetBuildingById(f.BuildingID)
+	require.NoError(t, err)
+	fixtureBuilding.BuildingCode = "A2"
+	require.NoError(t, store.UpdateBuilding(fixtureBuilding))
+	fixtureSite, err := store.GetSiteByID(strconv.Itoa(f.SiteID))
+	require.NoError(t, err)
+	fixtureSite.SiteName = "Taradale Campus"
+	require.NoError(t, store.UpdateSite(fixtureSite))
+
+	renamed, err := store.GetSyncChanges(first.Cursor)
+	require.NoError(t, err)
+	var renamedDevice *models.EmergencyDevice
+	for i := range renamed.Devices {
+		if renamed.Devices[i].EmergencyDeviceID == device.EmergencyDeviceID {
+			renamedDevice = &renamed.Devices[i]
+		}
+	}
+	require.NotNil(t, renamedDevice, "the device in the renamed building is sent again")
+	assert.Equal(t, "A2", renamedDevice.BuildingCode)
+	assert.Equal(t, "Taradale Campus", renamedDevice.SiteName)
+	var renamedRoom *models.Room
+	for i := range renamed.Rooms {
+		if renamed.Rooms[i].RoomID == f.RoomID {
+			renamedRoom = &renamed.Rooms[i]
+		}
+	}
+	require.NotNil(t, renamedRoom, "the room in the renamed building is sent again")
+	assert.Equal(t, "A2", renamedRoom.BuildingCode)
+	assert.Equal(t, "Taradale Campus", renamedRoom.SiteName)
+	for _, changed := range renamed.Devices {
+		assert.NotEqual(t, siteDevice.EmergencyDeviceID, changed.EmergencyDeviceID, "the other site did not change")
+	}
+
+	// Archiving a building or site removes the rooms and devices in it, which are no longer listed
+	unchanged, err := store.GetSyncChanges(renamed.Cursor)
+	require.NoError(t, err)
+	require.NoError(t, store.ArchiveBuilding(strconv.Itoa(building.BuildingID), "Demolished", 0))
+	require.NoError(t, store.ArchiveSite(strconv.Itoa(site.SiteID), "Sold", 0))
+
+	archived, err := store.GetSyncChanges(unchanged.Cursor)
+	require.NoError(t, err)
+	assert.Empty(t, archived.Devices)
+	assert.Empty(t, archived.Rooms)
+	assert.ElementsMatch(t, []int{buildingDevice.EmergencyDeviceID, siteDevice.EmergencyDeviceID}, archived.RemovedDeviceIDs)
+	assert.ElementsMatch(t, []int{buildingRoom.RoomID, siteRoom.RoomID}, archived.RemovedRoomIDs)
+
+	everything, err := store.GetSyncChanges(0)
+	require.NoError(t, err)
+	require.Len(t, everything.Devices, 1, "a first sync leaves out archived buildings and sites")
+	assert.Equal(t, device.EmergencyDeviceID, everything.Devices[0].EmergencyDeviceID)
+	require.Len(t, everything.Rooms, 1)
+	assert.Equal(t, f.RoomID, everything.Rooms[0].RoomID)
+}
+
+func testCalendarFeeds(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	otherID, err := store.AddOrganisation("Other")
+	require.NoError(t, err)
+
+	require.NoError(t, store.SetCalendarFeed(f.UserID, models.DefaultOrganisationID, strings.Repeat("a", 64)))
+	require.NoError(t, store.SetCalendarFeed(f.UserID, otherID, strings.Repeat("b", 64)))
+	assert.Error(t, store.SetCalendarFeed(f.UserID+100, models.DefaultOrganisationID, strings.Repeat("c", 64)), "the user must exist")
+
+	feed, err := store.GetCalendarFeed(strings.Repeat("a", 64))
+	require.NoError(t, err)
+	assert.Equal(t, f.UserID, feed.UserID)
+	assert.Equal(t, models.DefaultOrganisationID, feed.OrganisationID)
+
+	// A new link replaces the user's earlier one for the organisation
+	require.NoError(t, store.SetCalendarFeed(f.UserID, models.DefaultOrganisationID, strings.Repeat("c", 64)))
+	_, err = store.GetCalendarFeed(strings.Repeat("a", 64))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	replaced, err := store.GetCalendarFeed(strings.Repeat("c", 64))
+	require.NoError(t, err)
+	assert.Equal(t, feed.CalendarFeedID, replaced.CalendarFeedID)
+
+	require.NoError(t, store.DeleteCalendarFeed(f.UserID, models.DefaultOrganisationID))
+	_, err = store.GetCalendarFeed(strings.Repeat("c", 64))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	require.NoError(t, store.DeleteCalendarFeed(f.UserID, models.DefaultOrganisationID), "revoking twice is not an error")
+
+	require.NoError(t, store.DeleteUser(f.UserID, 0))
+	_, err = store.GetCalendarFeed(strings.Repeat("b", 64))
+	assert.ErrorIs(t, err, sql.ErrNoRows, "links are deleted with the user")
+}
+
+func testWebhooks(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", date(2020, time.January, 1))
+	assert.NotZero(t, device.EmergencyDeviceID)
+
+	webhookID, err := store.AddWebhook(&models.Webhook{
+		URL:        "http://localhost:9000/",
+		Secret:     "secret",
+		EventTypes: []string{models.EventInspectionFailed, models.EventDeviceStatusChanged},
+		IsActive:   true,
+	})
+	require.NoError(t, err)
+	inactiveID, err := store.AddWebhook(&models.Webhook{URL: "http://localhost:9001/", Secret: "other", EventTypes: []string{models.EventInspectionFailed}})
+	require.NoError(t, err)
+
+	webhook, err := store.GetWebhookByID(webhookID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DefaultOrganisationID, webhook.OrganisationID)
+	assert.Equal(t, []string{models.EventInspectionFailed, models.EventDeviceStatusChanged}, webhook.EventTypes)
+	assert.Equal(t, "secret", webhook.Secret)
+	webhooks, err := store.GetAllWebhooks()
+	require.NoError(t, err)
+	assert.Len(t, webhooks, 2)
+
+	event := func(eventID string, eventType string) *models.WebhookEvent {
+		return &models.WebhookEvent{
+			EventID:           eventID,
+			EventType:         eventType,
+			EmergencyDeviceID: device.EmergencyDeviceID,
+			Payload:           []byte(`{"event_type":"` + eventType + `"}`),
+		}
+	}
+	queued, err := store.AddWebhookEvent(event("8d1c0a52-55f5-4c87-9b1b-0d2f1e9b7a01", models.EventInspectionFailed))
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued, "inactive webhooks are not sent events")
+	queued, err = store.AddWebhookEvent(event("8d1c0a52-55f5-4c87-9b1b-0d2f1e9b7a02", models.EventDeviceCreated))
+	require.NoError(t, err)
+	assert.Equal(t, 0, queued, "webhooks only get the event types they subscribe to")
+	_, err = store.AddWebhookEvent(event("8d1c0a52-55f5-4c87-9b1b-0d2f1e9b7a01", models.EventInspectionFailed))
+	assert.Error(t, err, "an event is queued once per webhook")
+	queued, err = store.AddWebhookEvent(event("8d1c0a52-55f5-4c87-9b1b-0d2f1e9b7a03", models.EventDeviceStatusChanged))
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+
+	// Claimed deliveries are held back for the lease
+	claimed, err := store.ClaimWebhookDeliveries(time.Now().Add(time.Second), time.Minute, 1)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	first := claimed[0]
+	assert.Equal(t, models.EventInspectionFailed, first.EventType)
+	assert.Equal(t, "http://localhost:9000/", first.URL)
+	assert.Equal(t, "secret", first.Secret)
+	assert.JSONEq(t, `{"event_type":"inspection.failed"}`, string(first.Payload))
+	assert.Equal(t, models.DeliveryPending, first.Status)
+
+	claimed, err = store.ClaimWebhookDeliveries(time.Now().Add(time.Second), time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1, "the first delivery is claimed until the lease ends")
+	second := claimed[0]
+	claimed, err = store.ClaimWebhookDeliveries(time.Now().Add(2*time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 2, "unrecorded claims are sent again once their lease ends")
+
+	// A failed attempt is retried later, a successful one finishes the delivery
+	retryAt := time.Now().Add(time.Hour)
+	require.NoError(t, store.RecordWebhookAttempt(&models.WebhookAttempt{
+		WebhookDeliveryID: first.WebhookDeliveryID,
+		AttemptedAt:       time.Now(),
+		ResponseStatus:    sql.NullInt64{Int64: 500, Valid: true},
+		Error:             sql.NullString{String: "HTTP 500: down", Valid: true},
+		DurationMs:        12,
+	}, models.DeliveryPending, sql.NullTime{Time: retryAt, Valid: true}))
+	delivered := &models.WebhookAttempt{WebhookDeliveryID: second.WebhookDeliveryID, AttemptedAt: time.Now(), ResponseStatus: sql.NullInt64{Int64: 204, Valid: true}}
+	require.NoError(t, store.RecordWebhookAttempt(delivered, models.DeliveryDelivered, sql.NullTime{}))
+	assert.NotZero(t, delivered.WebhookAttemptID)
+	assert.ErrorIs(t, store.RecordWebhookAttempt(&models.WebhookAttempt{WebhookDeliveryID: second.WebhookDeliveryID + 100, AttemptedAt: time.Now()},
+		models.DeliveryDelivered, sql.NullTime{}), sql.ErrNoRows)
+
+	claimed, err = store.ClaimWebhookDeliveries(retryAt.Add(-time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed, "the failed delivery waits for its retry")
+	claimed, err = store.ClaimWebhookDeliveries(retryAt.Add(time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, first.WebhookDeliveryID, claimed[0].WebhookDeliveryID)
+	assert.Equal(t, 1, claimed[0].Attempts)
+
+	deliveries, err := store.GetWebhookDeliveries(webhookID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, second.WebhookDeliveryID, deliveries[0].WebhookDeliveryID, "latest first")
+	assert.Equal(t, models.DeliveryDelivered, deliveries[0].Status)
+	assert.True(t, deliveries[0].DeliveredAt.Valid)
+	assert.False(t, deliveries[0].NextAttemptAt.Valid)
+	require.Len(t, deliveries[0].Log, 1)
+	require.Len(t, deliveries[1].Log, 1)
+	assert.Equal(t, int64(500), deliveries[1].Log[0].ResponseStatus.Int64)
+	assert.Equal(t, "HTTP 500: down", deliveries[1].Log[0].Error.String)
+	assert.Equal(t, 12, deliveries[1].Log[0].DurationMs)
+
+	// Finished deliveries can be sent again, pending ones are already queued
+	assert.ErrorIs(t, store.RedeliverWebhookDelivery(first.WebhookDeliveryID), sql.ErrNoRows)
+	require.NoError(t, store.RedeliverWebhookDelivery(second.WebhookDeliveryID))
+	claimed, err = store.ClaimWebhookDeliveries(time.Now().Add(time.Second), time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, second.WebhookDeliveryID, claimed[0].WebhookDeliveryID)
+	assert.Equal(t, 0, claimed[0].Attempts, "a redelivery gets a fresh set of retries")
+
+	// Deliveries of a webhook that is switched off wait for it
+	webhook.IsActive = false
+	webhook.EventTypes = []string{models.EventDeviceCreated}
+	require.NoError(t, store.UpdateWebhook(webhook))
+	claimed, err = store.ClaimWebhookDeliveries(time.Now().Add(3*time.Hour), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+	updated, err := store.GetWebhookByID(webhookID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.EventDeviceCreated}, updated.EventTypes)
+	assert.Equal(t, "secret", updated.Secret, "updates keep the secret")
+
+	require.NoError(t, store.DeleteWebhook(webhookID, 0))
+	assert.ErrorIs(t, store.DeleteWebhook(webhookID, 0), sql.ErrNoRows)
+	deliveries, err = store.GetWebhookDeliveries(webhookID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+	_, err = store.GetWebhookByID(inactiveID)
+	assert.NoError(t, err)
+}
+
+func testInspectionCounts(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", date(2024, time.March, 1))
+
+	require.NoError(t, store.CreateUser(&models.User{Username: "apprentice", Password: "hash", Email: "apprentice@example.com"}))
+	apprentice, err := store.GetUserByUsername("apprentice")
+	require.NoError(t, err)
+
+	inspect := func(userID int, inspectedAt time.Time, status string) {
+		t.Helper()
+		require.NoError(t, store.AddInspection(&models.Inspection{
+			EmergencyDeviceID:  device.EmergencyDeviceID,
+			UserID:             userID,
+			InspectionDateTime: sql.NullTime{Time: inspectedAt, Valid: true},
+			InspectionStatus:   status,
+		}))
+	}
+	inspect(f.UserID, time.Date(2023, time.December, 1, 9, 0, 0, 0, time.UTC), "Passed")
+	inspect(f.UserID, time.Date(2024, time.July, 15, 10, 0, 0, 0, time.UTC), "Failed")
+	// 13:00 UTC on the 31st of July is already August in Auckland
+	inspect(f.UserID, time.Date(2024, time.July, 31, 13, 0, 0, 0, time.UTC), "Passed")
+	inspect(apprentice.UserID, time.Date(2024, time.August, 10, 2, 0, 0, 0, time.UTC), "Passed")
+
+	counts, err := store.GetInspectionCounts("", date(2024, time.July, 1), date(2024, time.August, 31))
+	require.NoError(t, err)
+	assert.Equal(t, []models.InspectionTypeCount{
+		{EmergencyDeviceTypeName: "Fire Extinguisher", Inspections: 3, Failed: 1},
+	}, counts.ByType, "inspections outside the period are not counted")
+	assert.Equal(t, []models.InspectorMonthCount{
+		{UserID: f.UserID, InspectorName: "inspector", Month: "2024-07", Inspections: 1, Failed: 1},
+		{UserID: apprentice.UserID, InspectorName: "apprentice", Month: "2024-08", Inspections: 1},
+		{UserID: f.UserID, InspectorName: "inspector", Month: "2024-08", Inspections: 1},
+	}, counts.ByInspector, "inspections are counted in the month at their site")
+
+	counts, err = store.GetInspectionCounts(itoa(f.SiteID), date(2024, time.July, 1), date(2024, time.July, 31))
+	require.NoError(t, err)
+	require.Len(t, counts.ByType, 1)
+	assert.Equal(t, 1, counts.ByType[0].Inspections, "the period ends on the last day at the site")
+
+	counts, err = store.GetInspectionCounts(itoa(f.SiteID+1), date(2024, time.July, 1), date(2024, time.August, 31))
+	require.NoError(t, err)
+	assert.Empty(t, counts.ByType)
+	assert.Empty(t, counts.ByInspector)
+}
+
+func testSearch(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	addDescribed := func(serialNumber string, description string) models.EmergencyDevice {
+		t.Helper()
+		device := addDevice(t, store, f, serialNumber, date(2024, time.March, 1))
+		device.Description = sql.NullString{String: description, Valid: true}
+		device.RoomID = f.RoomID
+		device.EmergencyDeviceTypeID = f.DeviceTypeID
+		require.NoError(t, store.UpdateEmergencyDevice(&device))
+		return device
+	}
+	kitchen := addDescribed("FX-1001", "Next to the kitchen door")
+	stairwell := addDescribed("FX-2002", "Stairwell")
+	removed := addDescribed("FX-1003", "Kitchen")
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  removed.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Date(2024, time.July, 1, 10, 0, 0, 0, time.UTC), Valid: true},
+		InspectionStatus:   "Passed",
+		Notes:              sql.NullString{String: "Kitchen bracket loose", Valid: true},
+	}))
+	require.NoError(t, store.DecommissionEmergencyDevice(removed.EmergencyDeviceID, "Removed", 0))
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  stairwell.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Date(2024, time.July, 15, 10, 0, 0, 0, time.UTC), Valid: true},
+		InspectionStatus:   "Failed",
+		Notes:              sql.NullString{String: "Kitchen hose cracked", Valid: true},
+	}))
+
+	type hit struct {
+		Kind  string
+		ID    int
+		Field string
+	}
+	search := func(query string, limit int, offset int) ([]hit, *models.SearchResults) {
+		t.Helper()
+		results, err := store.Search(query, limit, offset)
+		require.NoError(t, err)
+		hits := []hit{}
+		for _, result := range results.Results {
+			hits = append(hits, hit{result.Kind, result.ID, result.Field})
+		}
+		return hits, results
+	}
+
+	hits, results := search("fx-1001", 10, 0)
+	assert.Equal(t, []hit{{models.SearchDevice, kitchen.EmergencyDeviceID, "serial_number"}}, hits,
+		"matching ignores case and decommissioned devices are left out")
+	require.Len(t, results.Results, 1)
+	result := results.Results[0]
+	assert.Equal(t, "FX-1001", result.Value)
+	assert.Equal(t, "Fire Extinguisher FX-1001", result.Title)
+	assert.Equal(t, "Taradale, A A101", result.Location)
+	assert.Equal(t, int64(kitchen.EmergencyDeviceID), result.EmergencyDeviceID.Int64)
+	assert.Equal(t, f.RoomID, result.RoomID)
+	assert.InDelta(t, 4, result.Rank, 0.0001, "an exact match ranks highest")
+
+	inspections, err := store.GetAllInspectionsByDeviceID(stairwell.EmergencyDeviceID)
+	require.NoError(t, err)
+	require.Len(t, inspections, 1)
+	inspectionID := inspections[0].EmergencyDeviceInspectionID
+
+	hits, _ = search("kitchen", 10, 0)
+	assert.Equal(t, []hit{
+		{models.SearchInspection, inspectionID, "notes"},
+		{models.SearchDevice, kitchen.EmergencyDeviceID, "description"},
+	}, hits, "text at the start of a value ranks above text in the middle, inspections of decommissioned devices are left out")
+
+	hits, results = search("kitchin", 10, 0)
+	assert.Equal(t, []hit{
+		{models.SearchDevice, kitchen.EmergencyDeviceID, "description"},
+		{models.SearchInspection, inspectionID, "notes"},
+	}, hits, "misspelt words match similar words")
+	assert.InDelta(t, 0.625, results.Results[0].Rank, 0.0001)
+
+	hits, _ = search("1_01", 10, 0)
+	assert.Empty(t, hits, "wildcards in the search text are matched literally")
+
+	// Every device and the room are at the site, a page at a time
+	hits, results = search("Taradale", 2, 0)
+	assert.Equal(t, 3, results.Total)
+	assert.Equal(t, []hit{
+		{models.SearchDevice, kitchen.EmergencyDeviceID, "site_name"},
+		{models.SearchDevice, stairwell.EmergencyDeviceID, "site_name"},
+	}, hits)
+	hits, _ = search("Taradale", 2, 2)
+	assert.Equal(t, []hit{{models.SearchRoom, f.RoomID, "site_name"}}, hits)
+	hits, results = search("Taradale", 2, 10)
+	assert.Empty(t, hits)
+	assert.Equal(t, 3, results.Total)
+
+	// Rooms and devices in an archived building or site are left out
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "Z"}))
+	z, err := store.GetBuildingByCodeandSite("Z", f.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: z.BuildingID, RoomCode: "Boilerhouse"}))
+	require.NoError(t, store.AddSite(&models.Site{SiteName: "Napier"}))
+	site, err := store.GetSiteByName("Napier")
+	require.NoError(t, err)
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: site.SiteID, BuildingCode: "N"}))
+	building, err := store.GetBuildingByCodeandSite("N", site.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: building.BuildingID, RoomCode: "Gatehouse"}))
+	room, err := store.GetRoomByCodeAndBuilding("Gatehouse", building.BuildingID)
+	require.NoError(t, err)
+	gatehouse := addDevice(t, store, fixture{RoomID: room.RoomID, DeviceTypeID: f.DeviceTypeID}, "FX-9009", date(2024, time.March, 1))
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  gatehouse.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Date(2024, time.July, 15, 10, 0, 0, 0, time.UTC), Valid: true},
+		InspectionStatus:   "Passed",
+		Notes:              sql.NullString{String: "Gatehouse door propped open", Valid: true},
+	}))
+
+	hits, _ = search("Boilerhouse", 10, 0)
+	assert.Len(t, hits, 1)
+	require.NoError(t, store.ArchiveBuilding(itoa(z.BuildingID), "", 0))
+	require.NoError(t, store.ArchiveSite(itoa(site.SiteID), "", 0))
+	hits, _ = search("Boilerhouse", 10, 0)
+	assert.Empty(t, hits, "rooms of an archived building are left out")
+	hits, _ = search("Gatehouse", 10, 0)
+	assert.Empty(t, hits, "rooms, devices and inspections of an archived site are left out")
+	hits, _ = search("FX-9009", 10, 0)
+	assert.Empty(t, hits)
+}
+
+func testSavedViews(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	require.NoError(t, store.CreateUser(&models.User{Username: "manager", Password: "hash", Email: "manager@example.com"}))
+	manager, err := store.GetUserByUsername("manager")
+	require.NoError(t, err)
+
+	_, err = store.GetDefaultSavedView(f.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "nobody has a default view to start with")
+
+	overdueID, err := store.AddSavedView(&models.SavedView{
+		UserID:         f.UserID,
+		Name:           "Overdue at Taradale",
+		Filters:        models.ViewFilters{SiteID: strconv.Itoa(f.SiteID), Status: "Inspection Due"},
+		SortColumn:     "next_due_date",
+		SortDescending: true,
+		Columns:        []string{"serial_number", "next_due_date"},
+		SharedWith:     []int{manager.UserID, manager.UserID},
+	})
+	require.NoError(t, err)
+	_, err = store.AddSavedView(&models.SavedView{UserID: f.UserID, Name: "Overdue at Taradale", Columns: []string{"status"}})
+	assert.Error(t, err, "a user's view names are unique")
+	allID, err := store.AddSavedView(&models.SavedView{UserID: f.UserID, Name: "All devices", Columns: []string{"status"}})
+	require.NoError(t, err)
+	mineID, err := store.AddSavedView(&models.SavedView{UserID: manager.UserID, Name: "All devices", Columns: []string{"status"}})
+	require.NoError(t, err, "names only need to be unique for each user")
+
+	view, err := store.GetSavedViewByID(overdueID, f.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "inspector", view.OwnerName)
+	assert.Equal(t, models.ViewFilters{SiteID: strconv.Itoa(f.SiteID), Status: "Inspection Due"}, view.Filters)
+	assert.Equal(t, "next_due_date", view.SortColumn)
+	assert.True(t, view.SortDescending)
+	assert.Equal(t, []string{"serial_number", "next_due_date"}, view.Columns)
+	assert.Equal(t, []int{manager.UserID}, view.SharedWith)
+	assert.False(t, view.IsDefault)
+
+	names := func(userID int) []string {
+		views, err := store.GetSavedViews(userID)
+		require.NoError(t, err)
+		list := []string{}
+		for _, view := range views {
+			list = append(list, view.Name+" by "+view.OwnerName)
+		}
+		return list
+	}
+	assert.Equal(t, []string{"All devices by inspector", "Overdue at Taradale by inspector"}, names(f.UserID))
+	assert.Equal(t, []string{"All devices by manager", "Overdue at Taradale by inspector"}, names(manager.UserID), "shared views are listed with the user's own")
+
+	_, err = store.GetSavedViewByID(allID, manager.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "views are private until shared")
+	assert.ErrorIs(t, store.SetDefaultSavedView(manager.UserID, allID), sql.ErrNoRows)
+
+	// Only the owner can change or delete a view
+	view.Name = "Changed"
+	view.UserID = manager.UserID
+	assert.ErrorIs(t, store.UpdateSavedView(view), sql.ErrNoRows)
+	assert.ErrorIs(t, store.DeleteSavedView(overdueID, manager.UserID, 0), sql.ErrNoRows)
+
+	// Each user has one default, a view shared with them can be it
+	require.NoError(t, store.SetDefaultSavedView(f.UserID, allID))
+	require.NoError(t, store.SetDefaultSavedView(f.UserID, overdueID))
+	require.NoError(t, store.SetDefaultSavedView(manager.UserID, overdueID))
+	defaultView, err := store.GetDefaultSavedView(f.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, overdueID, defaultView.SavedViewID)
+	assert.True(t, defaultView.IsDefault)
+	defaultView, err = store.GetDefaultSavedView(manager.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, overdueID, defaultView.SavedViewID)
+	all, err := store.GetSavedViewByID(allID, f.UserID)
+	require.NoError(t, err)
+	assert.False(t, all.IsDefault)
+
+	// Unsharing a view takes it away as the default of the users it was shared with, not the owner's
+	require.NoError(t, store.UpdateSavedView(&models.SavedView{
+		SavedViewID: overdueID,
+		UserID:      f.UserID,
+		Name:        "Overdue",
+		Filters:     models.ViewFilters{Status: "Inspection Due"},
+		Columns:     []string{"status"},
+	}))
+	_, err = store.GetDefaultSavedView(manager.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	defaultView, err = store.GetDefaultSavedView(f.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "Overdue", defaultView.Name)
+	assert.Equal(t, models.ViewFilters{Status: "Inspection Due"}, defaultView.Filters)
+	assert.Empty(t, defaultView.SharedWith)
+	assert.Equal(t, []string{"All devices by manager"}, names(manager.UserID))
+	assert.Error(t, store.UpdateSavedView(&models.SavedView{SavedViewID: overdueID, UserID: f.UserID, Name: "All devices", Columns: []string{"status"}}),
+		"a view cannot be renamed to another of the user's views")
+
+	require.NoError(t, store.ClearDefaultSavedView(f.UserID))
+	_, err = store.GetDefaultSavedView(f.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Deleting a view stops it being anyone's default
+	require.NoError(t, store.SetDefaultSavedView(manager.UserID, mineID))
+	require.NoError(t, store.DeleteSavedView(mineID, manager.UserID, 0))
+	_, err = store.GetDefaultSavedView(manager.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.ErrorIs(t, store.DeleteSavedView(mineID, manager.UserID, 0), sql.ErrNoRows)
+
+	// Views go with their owner and shares with the user they were shared with
+	require.NoError(t, store.UpdateSavedView(&models.SavedView{
+		SavedViewID: allID, UserID: f.UserID, Name: "All devices", Columns: []string{"status"}, SharedWith: []int{manager.UserID},
+	}))
+	require.NoError(t, store.DeleteUser(manager.UserID, 0))
+	all, err = store.GetSavedViewByID(allID, f.UserID)
+	require.NoError(t, err)
+	assert.Empty(t, all.SharedWith)
+}
+
+func testRowVersions(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	added := addDevice(t, store, f, "SN1", date(2020, time.January, 1))
+
+	device, err := store.GetDeviceByID(added.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, device.Version, "rows start at version 1")
+
+	// An update made from the current version changes the row and moves it on
+	device.Size = sql.NullString{String: "5kg", Valid: true}
+	require.NoError(t, store.UpdateEmergencyDevice(device))
+	updated, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+
+	// One made from a stale copy is refused and changes nothing
+	device.Size = sql.NullString{String: "9kg", Valid: true}
+	assert.ErrorIs(t, store.UpdateEmergencyDevice(device), database.ErrVersionConflict)
+	unchanged, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "5kg", unchanged.Size.String)
+	assert.Equal(t, 2, unchanged.Version)
+
+	// Version 0 updates the row whatever its version
+	device.Version = 0
+	require.NoError(t, store.UpdateEmergencyDevice(device))
+	overwritten, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "9kg", overwritten.Size.String)
+	assert.Equal(t, 3, overwritten.Version)
+
+	// Changes made by the inspection trigger count too
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Now(), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+	inspected, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, 4, inspected.Version)
+	overwritten.Size = sql.NullString{String: "2kg", Valid: true}
+	assert.ErrorIs(t, store.UpdateEmergencyDevice(overwritten), database.ErrVersionConflict)
+
+	// A versioned update of a row that is not there is not a conflict
+	missing := *inspected
+	missing.EmergencyDeviceID += 100
+	assert.NoError(t, store.UpdateEmergencyDevice(&missing))
+
+	room, err := store.GetRoomByID(f.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, room.Version)
+	stale := *room
+	room.RoomCode = "A102"
+	require.NoError(t, store.UpdateRoom(room))
+	stale.RoomCode = "A103"
+	assert.ErrorIs(t, store.UpdateRoom(&stale), database.ErrVersionConflict)
+	room, err = store.GetRoomByID(f.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, "A102", room.RoomCode)
+	assert.Equal(t, 2, room.Version)
+
+	building, err := store.GetBuildingById(f.BuildingID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateBuilding(building))
+	assert.ErrorIs(t, store.UpdateBuilding(building), database.ErrVersionConflict)
+
+	site, err := store.GetSiteByID(itoa(f.SiteID))
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateSite(site))
+	assert.ErrorIs(t, store.UpdateSite(site), database.ErrVersionConflict)
+	site, err = store.GetSiteByID(itoa(f.SiteID))
+	require.NoError(t, err)
+	assert.Equal(t, 2, site.Version)
+
+	floors, err := store.GetFloorsByBuildingID(f.BuildingID)
+	require.NoError(t, err)
+	require.NotEmpty(t, floors)
+	floor := floors[0]
+	require.NoError(t, store.UpdateFloor(&floor))
+	assert.ErrorIs(t, store.UpdateFloor(&floor), database.ErrVersionConflict)
+
+	deviceType, err := store.GetEmergencyDeviceTypeByID(f.DeviceTypeID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateEmergencyDeviceType(deviceType))
+	assert.ErrorIs(t, store.UpdateEmergencyDeviceType(deviceType), database.ErrVersionConflict)
+
+	user, err := store.GetUserByID(f.UserID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateUser(user))
+	assert.ErrorIs(t, store.UpdateUserWithPassword(user), database.ErrVersionConflict)
+	require.NoError(t, store.UpdatePassword(f.UserID, "changed"))
+	user, err = store.GetUserByUsername("inspector")
+	require.NoError(t, err)
+	assert.Equal(t, 3, user.Version, "changing the password counts")
+
+	webhookID, err := store.AddWebhook(&models.Webhook{URL: "http://localhost:9000/", Secret: "secret", EventTypes: []string{models.EventInspectionFailed}})
+	require.NoError(t, err)
+	webhook, err := store.GetWebhookByID(webhookID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateWebhook(webhook))
+	assert.ErrorIs(t, store.UpdateWebhook(webhook), database.ErrVersionConflict)
+
+	viewID, err := store.AddSavedView(&models.SavedView{UserID: f.UserID, Name: "All devices", Columns: models.DeviceListColumns})
+	require.NoError(t, err)
+	view, err := store.GetSavedViewByID(viewID, f.UserID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateSavedView(view))
+	assert.ErrorIs(t, store.UpdateSavedView(view), database.ErrVersionConflict)
+	view.UserID = f.UserID + 100
+	assert.ErrorIs(t, store.UpdateSavedView(view), sql.ErrNoRows, "another user's update of a view is not a conflict")
+}
+
+func testVersionedArchivesAndDeletes(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	added := addDevice(t, store, f, "SN1", date(2020, time.January, 1))
+
+	// A device changed since it was read is not decommissioned
+	device, err := store.GetDeviceByID(added.EmergencyDeviceID)
+	require.NoError(t, err)
+	stale := device.Version
+	require.NoError(t, store.UpdateEmergencyDevice(device))
+	assert.ErrorIs(t, store.DecommissionEmergencyDevice(device.EmergencyDeviceID, "Damaged", stale), database.ErrVersionConflict)
+	device, err = store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.False(t, device.DecommissionedAt.Valid)
+	require.NoError(t, store.DecommissionEmergencyDevice(device.EmergencyDeviceID, "Damaged", device.Version))
+
+	// Nor is a room, building or site archived from a stale copy
+	room, err := store.GetRoomByID(f.RoomID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateRoom(room))
+	assert.ErrorIs(t, store.ArchiveRoom(f.RoomID, "Demolished", room.Version), database.ErrVersionConflict)
+	rooms, err := store.GetAllRooms(itoa(f.BuildingID), "")
+	require.NoError(t, err)
+	assert.Len(t, rooms, 1, "the room is still active")
+	require.NoError(t, store.ArchiveRoom(f.RoomID, "Demolished", room.Version+1))
+	assert.NoError(t, store.ArchiveRoom(f.RoomID, "Demolished", room.Version), "archiving an archived room is not a conflict")
+
+	building, err := store.GetBuildingById(f.BuildingID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateBuilding(building))
+	assert.ErrorIs(t, store.ArchiveBuilding(itoa(f.BuildingID), "", building.Version), database.ErrVersionConflict)
+	require.NoError(t, store.ArchiveBuilding(itoa(f.BuildingID), "", building.Version+1))
+
+	site, err := store.GetSiteByID(itoa(f.SiteID))
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateSite(site))
+	assert.ErrorIs(t, store.ArchiveSite(itoa(f.SiteID), "Closed", site.Version), database.ErrVersionConflict)
+	sites, err := store.GetAllSites()
+	require.NoError(t, err)
+	assert.Len(t, sites, 1, "the site is still active")
+	require.NoError(t, store.ArchiveSite(itoa(f.SiteID), "Closed", site.Version+1))
+
+	// Deletes made from a stale copy are refused and the row is kept
+	floorID, err := store.AddFloor(&models.Floor{BuildingID: f.BuildingID, FloorName: "Level 2", FloorLevel: 2})
+	require.NoError(t, err)
+	floor, err := store.GetFloorByID(floorID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateFloor(floor))
+	assert.ErrorIs(t, store.DeleteFloor(floorID, floor.Version), database.ErrVersionConflict)
+	_, err = store.GetFloorByID(floorID)
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteFloor(floorID, floor.Version+1))
+
+	require.NoError(t, store.AddEmergencyDeviceType(&models.EmergencyDeviceType{EmergencyDeviceTypeName: "Fire Blanket", InspectionIntervalMonths: 6}))
+	deviceType, err := store.GetDeviceTypeByName("Fire Blanket")
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateEmergencyDeviceType(deviceType))
+	assert.ErrorIs(t, store.DeleteEmergencyDeviceType(deviceType.EmergencyDeviceTypeID, deviceType.Version), database.ErrVersionConflict)
+	require.NoError(t, store.DeleteEmergencyDeviceType(deviceType.EmergencyDeviceTypeID, deviceType.Version+1))
+
+	require.NoError(t, store.CreateUser(&models.User{Username: "alex", Password: "hash", Email: "alex@example.com"}))
+	user, err := store.GetUserByUsername("alex")
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateUser(user))
+	assert.ErrorIs(t, store.DeleteUser(user.UserID, user.Version), database.ErrVersionConflict)
+	_, err = store.GetUserByID(user.UserID)
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteUser(user.UserID, user.Version+1))
+
+	webhookID, err := store.AddWebhook(&models.Webhook{URL: "http://localhost:9000/", Secret: "secret", EventTypes: []string{models.EventInspectionFailed}})
+	require.NoError(t, err)
+	webhook, err := store.GetWebhookByID(webhookID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateWebhook(webhook))
+	assert.ErrorIs(t, store.DeleteWebhook(webhookID, webhook.Version), database.ErrVersionConflict)
+	require.NoError(t, store.DeleteWebhook(webhookID, webhook.Version+1))
+
+	viewID, err := store.AddSavedView(&models.SavedView{UserID: f.UserID, Name: "All devices", Columns: models.DeviceListColumns})
+	require.NoError(t, err)
+	view, err := store.GetSavedViewByID(viewID, f.UserID)
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateSavedView(view))
+	assert.ErrorIs(t, store.DeleteSavedView(viewID, f.UserID, view.Version), database.ErrVersionConflict)
+	assert.ErrorIs(t, store.DeleteSavedView(viewID, f.UserID+100, view.Version), sql.ErrNoRows, "another user's view is not a conflict")
+	require.NoError(t, store.DeleteSavedView(viewID, f.UserID, view.Version+1))
+}
+
+func testUnitOfWork(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", date(2020, time.January, 1))
+	inspect := func(tx database.Store) error {
+		return tx.AddInspection(&models.Inspection{
+			EmergencyDeviceID:  device.EmergencyDeviceID,
+			UserID:             f.UserID,
+			InspectionDateTime: sql.NullTime{Time: time.Now(), Valid: true},
+			InspectionStatus:   "Failed",
+		})
+	}
+
+	// A failed unit of work leaves nothing behind, including what the trigger and repository transactions changed
+	stop := errors.New("stop")
+	err := store.WithTx(func(tx database.Store) error {
+		require.NoError(t, inspect(tx))
+		require.NoError(t, tx.CreateUser(&models.User{Username: "auditor", Password: "hash", Email: "auditor@example.com"}))
+
+		// The unit of work reads its own changes
+		inspected, err := tx.GetDeviceByID(device.EmergencyDeviceID)
+		require.NoError(t, err)
+		assert.Equal(t, "Inspection Failed", inspected.Status.String)
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+
+	inspections, err := store.GetAllInspectionsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Empty(t, inspections)
+	unchanged, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, device.Status, unchanged.Status)
+	assert.Equal(t, device.Version, unchanged.Version)
+	_, err = store.GetUserByUsername("auditor")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Nested units of work and organisation stores join the outer one and are saved with it
+	err = store.WithTx(func(tx database.Store) error {
+		if err := inspect(tx); err != nil {
+			return err
+		}
+		return tx.ForOrganisation(models.DefaultOrganisationID).WithTx(func(tx database.Store) error {
+			return tx.UpdateDeviceStatus(device.EmergencyDeviceID, "Inactive")
+		})
+	})
+	require.NoError(t, err)
+
+	inspections, err = store.GetAllInspectionsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Len(t, inspections, 1)
+	updated, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, "Inactive", updated.Status.String)
+}
+
+func testDependents(t *testing.T, store database.Store) {
+	f := newFixture(t, store)
+	device := addDevice(t, store, f, "SN1", date(2020, time.January, 1))
+	require.NoError(t, store.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Now(), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+	decommissioned := addDevice(t, store, f, "SN2", date(2020, time.January, 1))
+	require.NoError(t, store.DecommissionEmergencyDevice(decommissioned.EmergencyDeviceID, "Expired", 0))
+
+	dependents, err := store.GetSiteDependents(f.SiteID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Dependent{{ID: f.BuildingID, Name: "A"}}, dependents.Buildings)
+	assert.Equal(t, []models.Dependent{{ID: f.RoomID, Name: "A101"}}, dependents.Rooms)
+	assert.Equal(t, []models.Dependent{{ID: device.EmergencyDeviceID, Name: "SN1"}}, dependents.Devices, "decommissioned devices do not count")
+	assert.Equal(t, 1, dependents.Inspections)
+
+	dependents, err = store.GetBuildingDependents(f.BuildingID)
+	require.NoError(t, err)
+	assert.Empty(t, dependents.Buildings)
+	assert.Len(t, dependents.Rooms, 1)
+	assert.Len(t, dependents.Devices, 1)
+
+	dependents, err = store.GetRoomDependents(f.RoomID)
+	require.NoError(t, err)
+	assert.Empty(t, dependents.Rooms)
+	assert.Len(t, dependents.Devices, 1)
+	assert.False(t, dependents.Empty())
+
+	_, err = store.GetRoomDependents(f.RoomID + 100)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	// Devices move to another room, and the room is left with nothing under it
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: f.BuildingID, RoomCode: "A102"}))
+	other, err := store.GetRoomByCodeAndBuilding("A102", f.BuildingID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, store.MoveRoomDevices(f.RoomID, other.RoomID+100), sql.ErrNoRows)
+	inspected, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	require.NoError(t, store.MoveRoomDevices(f.RoomID, other.RoomID))
+
+	moved, err := store.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Equal(t, other.RoomID, moved.RoomID)
+	assert.Equal(t, inspected.Version+1, moved.Version)
+	dependents, err = store.GetRoomDependents(f.RoomID)
+	require.NoError(t, err)
+	assert.True(t, dependents.Empty())
+	require.NoError(t, store.ArchiveRoom(f.RoomID, "Demolished", 0))
+	_, err = store.GetRoomDependents(f.RoomID)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "archived rows have no dependents to report")
+
+	// Rooms only move to a building without their codes, archived rooms included
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "B"}))
+	b, err := store.GetBuildingByCodeandSite("B", f.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: b.BuildingID, RoomCode: "A101"}))
+	require.NoError(t, store.AddRoom(&models.Room{BuildingID: b.BuildingID, RoomCode: "A102"}))
+	taken, err := store.GetRoomByCodeAndBuilding("A102", b.BuildingID)
+	require.NoError(t, err)
+	assert.ErrorIs(t, store.MoveBuildingRooms(f.BuildingID, b.BuildingID), database.ErrCodeTaken)
+	require.NoError(t, store.ArchiveRoom(taken.RoomID, "", 0))
+	assert.ErrorIs(t, store.MoveBuildingRooms(f.BuildingID, b.BuildingID), database.ErrCodeTaken)
+
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "C"}))
+	c, err := store.GetBuildingByCodeandSite("C", f.SiteID)
+	require.NoError(t, err)
+	require.NoError(t, store.MoveBuildingRooms(f.BuildingID, c.BuildingID))
+
+	room, err := store.GetRoomByID(other.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, c.BuildingID, room.BuildingID)
+	floors, err := store.GetFloorsByBuildingID(c.BuildingID)
+	require.NoError(t, err)
+	require.NotEmpty(t, floors)
+	assert.Equal(t, floors[0].FloorID, room.FloorID, "moved rooms are on the default floor of their new building")
+	archived, err := store.GetRoomByID(f.RoomID)
+	require.NoError(t, err)
+	assert.Equal(t, f.BuildingID, archived.BuildingID, "archived rooms stay where they were")
+	dependents, err = store.GetBuildingDependents(c.BuildingID)
+	require.NoError(t, err)
+	assert.Len(t, dependents.Rooms, 1)
+	assert.Len(t, dependents.Devices, 1)
+
+	// Buildings move to another site without their map position
+	require.NoError(t, store.AddSite(&models.Site{SiteName: "Napier"}))
+	napier, err := store.GetSiteByName("Napier")
+	require.NoError(t, err)
+	require.NoError(t, store.AddBuilding(&models.Building{SiteID: napier.SiteID, BuildingCode: "C"}))
+	assert.ErrorIs(t, store.MoveSiteBuildings(f.SiteID, napier.SiteID), database.ErrCodeTaken)
+
+	require.NoError(t, store.AddSite(&models.Site{SiteName: "Hastings"}))
+	hastings, err := store.GetSiteByName("Hastings")
+	require.NoError(t, err)
+	c.MapX = sql.NullFloat64{Float64: 0.5, Valid: true}
+	c.MapY = sql.NullFloat64{Float64: 0.5, Valid: true}
+	require.NoError(t, store.UpdateBuilding(c))
+	require.NoError(t, store.MoveSiteBuildings(f.SiteID, hastings.SiteID))
+
+	building, err := store.GetBuildingById(c.BuildingID)
+	require.NoError(t, err)
+	assert.Equal(t, hastings.SiteID, building.SiteID)
+	assert.False(t, building.MapX.Valid)
+	dependents, err = store.GetSiteDependents(f.SiteID)
+	require.NoError(t, err)
+	assert.True(t, dependents.Empty())
+	dependents, err = store.GetSiteDependents(hastings.SiteID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A", "B", "C"}, []string{dependents.Buildings[0].Name, dependents.Buildings[1].Name, dependents.Buildings[2].Name})
+	assert.Len(t, dependents.Devices, 1)
+	assert.Equal(t, 1, dependents.Inspections)
+}
+
+func testOrganisationIsolation(t *testing.T, store database.Store) {
+	otherID, err := store.AddOrganisation("Other")
+	require.NoError(t, err)
+	_, err = store.AddOrganisation("Other")
+	assert.Error(t, err, "organisation names are unique")
+
+	own := store.ForOrganisation(models.DefaultOrganisationID)
+	other := store.ForOrganisation(otherID)
+
+	f := newFixture(t, own)
+	device := addDevice(t, own, f, "SN1", date(2020, time.January, 1))
+	require.NoError(t, own.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Now(), Valid: true},
+		InspectionStatus:   "Passed",
+	}))
+
+	organisations, err := other.GetAllOrganisations()
+	require.NoError(t, err)
+	require.Len(t, organisations, 1, "a scoped store only sees its own organisation")
+	assert.Equal(t, "Other", organisations[0].Name)
+	_, err = other.GetOrganisationByID(models.DefaultOrganisationID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	sites, err := other.GetAllSites()
+	require.NoError(t, err)
+	assert.Empty(t, sites)
+	_, err = other.GetSiteByName("Taradale")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = other.GetBuildingById(f.BuildingID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = other.GetRoomByID(f.RoomID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	floors, err := other.GetFloorsByBuildingID(f.BuildingID)
+	require.NoError(t, err)
+	assert.Empty(t, floors)
+	_, err = other.AddFloor(&models.Floor{BuildingID: f.BuildingID, FloorName: "Level 1", FloorLevel: 1})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	devices, err := other.GetAllDevices("", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, devices)
+	_, err = other.GetDeviceByID(device.EmergencyDeviceID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	inspections, err := other.GetAllInspectionsByDeviceID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.Empty(t, inspections)
+	recent, err := other.CountRecentInspections(time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, recent)
+	counts, err := other.GetInspectionCounts("", time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Empty(t, counts.ByType)
+	assert.Empty(t, counts.ByInspector)
+	found, err := other.Search("Taradale", 10, 0)
+	require.NoError(t, err)
+	assert.Zero(t, found.Total)
+	_, err = other.GetUserByID(f.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	roundID, err := own.AddInspectionRound(&models.InspectionRound{
+		SiteID:      f.SiteID,
+		PeriodStart: date(2024, time.November, 1),
+		PeriodEnd:   date(2024, time.November, 30),
+		DeviceIDs:   []int{device.EmergencyDeviceID},
+	})
+	require.NoError(t, err)
+	rounds, err := other.GetInspectionRounds(0)
+	require.NoError(t, err)
+	assert.Empty(t, rounds)
+	_, err = other.GetInspectionRoundByID(roundID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.ErrorIs(t, other.SkipInspectionRoundDevice(roundID, device.EmergencyDeviceID, "Locked"), sql.ErrNoRows)
+
+	webhookID, err := own.AddWebhook(&models.Webhook{URL: "http://localhost:9000/", Secret: "secret", EventTypes: []string{models.EventDeviceCreated}, IsActive: true})
+	require.NoError(t, err)
+	_, err = other.AddWebhook(&models.Webhook{URL: "http://localhost:9001/", Secret: "other", EventTypes: []string{models.EventDeviceCreated}, IsActive: true})
+	require.NoError(t, err)
+	webhooks, err := other.GetAllWebhooks()
+	require.NoError(t, err)
+	assert.Len(t, webhooks, 1)
+	_, err = other.GetWebhookByID(webhookID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.ErrorIs(t, other.DeleteWebhook(webhookID, 0), sql.ErrNoRows)
+	_, err = other.AddWebhookEvent(&models.WebhookEvent{
+		EventID: "8d1c0a52-55f5-4c87-9b1b-0d2f1e9b7a04", EventType: models.EventDeviceCreated, EmergencyDeviceID: device.EmergencyDeviceID, Payload: []byte(`{}`),
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows, "events are about the organisation's own devices")
+	queued, err := own.AddWebhookEvent(&models.WebhookEvent{
+		EventID: "8d1c0a52-55f5-4c87-9b1b-0d2f1e9b7a05", EventType: models.EventDeviceCreated, EmergencyDeviceID: device.EmergencyDeviceID, Payload: []byte(`{}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued, "only the device's organisation's webhooks get its events")
+	claimed, err := other.ClaimWebhookDeliveries(time.Now().Add(time.Second), time.Minute, 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	savedViewID, err := own.AddSavedView(&models.SavedView{UserID: f.UserID, Name: "Taradale", Columns: []string{"status"}})
+	require.NoError(t, err)
+	require.NoError(t, own.SetDefaultSavedView(f.UserID, savedViewID))
+	views, err := other.GetSavedViews(f.UserID)
+	require.NoError(t, err)
+	assert.Empty(t, views)
+	_, err = other.GetSavedViewByID(savedViewID, f.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = other.GetDefaultSavedView(f.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows, "defaults are kept for each organisation")
+	assert.ErrorIs(t, other.SetDefaultSavedView(f.UserID, savedViewID), sql.ErrNoRows)
+	assert.ErrorIs(t, other.DeleteSavedView(savedViewID, f.UserID, 0), sql.ErrNoRows)
+	require.NoError(t, other.ClearDefaultSavedView(f.UserID))
+	_, err = own.GetDefaultSavedView(f.UserID)
+	assert.NoError(t, err, "clearing the default in one organisation leaves the others")
+
+	_, err = store.GetDeviceByID(device.EmergencyDeviceID)
+	assert.NoError(t, err, "the unscoped store sees every organisation")
+
+	require.NoError(t, other.AddSite(&models.Site{SiteName: "Taradale", SiteAddress: "1 Other Street"}), "site names are unique per organisation")
+	otherSite, err := other.GetSiteByName("Taradale")
+	require.NoError(t, err)
+	assert.Equal(t, otherID, otherSite.OrganisationID)
+
+	assert.ErrorIs(t, other.AddBuilding(&models.Building{SiteID: f.SiteID, BuildingCode: "B"}), sql.ErrNoRows)
+	assert.ErrorIs(t, other.AddRoom(&models.Room{BuildingID: f.BuildingID, RoomCode: "A102"}), sql.ErrNoRows)
+	assert.ErrorIs(t, other.AddEmergencyDevice(&models.EmergencyDevice{
+		EmergencyDeviceTypeID: f.DeviceTypeID,
+		RoomID:                f.RoomID,
+		Status:                sql.NullString{String: "Active", Valid: true},
+	}), sql.ErrNoRows)
+	assert.ErrorIs(t, other.AddInspection(&models.Inspection{
+		EmergencyDeviceID:  device.EmergencyDeviceID,
+		UserID:             f.UserID,
+		InspectionDateTime: sql.NullTime{Time: time.Now(), Valid: true},
+		InspectionStatus:   "Failed",
+	}), sql.ErrNoRows)
+
+	assert.ErrorIs(t, other.DecommissionEmergencyDevice(device.EmergencyDeviceID, "Stolen", 0), sql.ErrNoRows)
+	require.NoError(t, other.ArchiveSite(itoa(f.SiteID), "Sold", 0))
+	unchanged, err := own.GetDeviceByID(device.EmergencyDeviceID)
+	require.NoError(t, err)
+	assert.False(t, unchanged.DecommissionedAt.Valid, "other organisations cannot change the device")
+	_, err = own.GetSiteByID(itoa(f.SiteID))
+	assert.NoError(t, err)
+
+	// The fixture's device type belongs to its organisation, types added unscoped are global
+	_, err = other.GetEmergencyDeviceTypeByID(f.DeviceTypeID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	require.NoError(t, other.AddEmergencyDeviceType(&models.EmergencyDeviceType{
+		EmergencyDeviceTypeName:  "Fire Extinguisher",
+		InspectionIntervalMonths: 6,
+	}), "type names are unique per organisation")
+
+	require.NoError(t, store.AddEmergencyDeviceType(&models.EmergencyDeviceType{EmergencyDeviceTypeName: "Smoke Alarm", InspectionIntervalMonths: 12}))
+	global, err := other.GetDeviceTypeByName("Smoke Alarm")
+	require.NoError(t, err, "global types are shared")
+	assert.False(t, global.OrganisationID.Valid)
+	global.InspectionIntervalMonths = 1
+	require.NoError(t, other.UpdateEmergencyDeviceType(global))
+	require.NoError(t, other.DeleteEmergencyDeviceType(global.EmergencyDeviceTypeID, 0))
+	unchangedType, err := own.GetEmergencyDeviceTypeByID(global.EmergencyDeviceTypeID)
+	require.NoError(t, err, "only the unscoped store changes global types")
+	assert.Equal(t, 12, unchangedType.InspectionIntervalMonths)
+}
+
+func testOrganisationMembers(t *testing.T, store database.Store) {
+	otherID, err := store.AddOrganisation("Other")
+	require.NoError(t, err)
+	own := store.ForOrganisation(models.DefaultOrganisationID)
+	other := store.ForOrganisation(otherID)
+
+	require.NoError(t, store.CreateUser(&models.User{Username: "alex", Password: "hash", Email: "alex@example.com"}))
+	user, err := store.GetUserByUsername("alex")
+	require.NoError(t, err)
+
+	organisations, err := store.GetUserOrganisations(user.UserID)
+	require.NoError(t, err)
+	require.Len(t, organisations, 1, "unscoped users join the default organisation")
+	assert.Equal(t, models.DefaultOrganisationID, organisations[0].OrganisationID)
+
+	require.NoError(t, store.RegisterUser(&models.User{Username: "newcomer", Password: "hash", Email: "newcomer@example.com"}))
+	newcomer, err := store.GetUserByUsername("newcomer")
+	require.NoError(t, err)
+	organisations, err = store.GetUserOrganisations(newcomer.UserID)
+	require.NoError(t, err)
+	assert.Empty(t, organisations, "registered users join no organisation")
+	_, err = own.GetUserByUsername("newcomer")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	assert.ErrorIs(t, own.AddUserToOrganisation(user.UserID, otherID), sql.ErrNoRows)
+	require.NoError(t, other.AddUserToOrganisation(user.UserID, otherID))
+	require.NoError(t, other.AddUserToOrganisation(user.UserID, otherID), "adding a member again does nothing")
+	assert.Error(t, store.AddUserToOrganisation(user.UserID+100, otherID))
+
+	organisations, err = store.GetUserOrganisations(user.UserID)
+	require.NoError(t, err)
+	require.Len(t, organisations, 2)
+	assert.Equal(t, "Other", organisations[1].Name)
+
+	// The user is the same in both organisations, so neither can change them alone
+	user.Role = "Admin"
+	assert.ErrorIs(t, other.UpdateUser(user), database.ErrUserInOtherOrganisations)
+	assert.ErrorIs(t, own.UpdateUserWithPassword(user), database.ErrUserInOtherOrganisations)
+	assert.ErrorIs(t, other.UpdatePassword(user.UserID, "taken over"), database.ErrUserInOtherOrganisations)
+	unchanged, err := store.GetUserByID(user.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "hash", unchanged.Password)
+	assert.NotEqual(t, "Admin", unchanged.Role)
+	require.NoError(t, store.UpdateUser(user), "unscoped stores change users of any organisation")
+
+	require.NoError(t, other.DeleteUser(user.UserID, 0), "deleting a user of several organisations only removes the membership")
+	_, err = other.GetUserByID(user.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	_, err = own.GetUserByID(user.UserID)
+	require.NoError(t, err)
+
+	require.NoError(t, other.CreateUser(&models.User{Username: "sam", Password: "hash", Email: "sam@example.com"}))
+	sam, err := other.GetUserByUsername("sam")
+	require.NoError(t, err)
+	_, err = own.GetUserByUsername("sam")
+	assert.ErrorIs(t, err, sql.ErrNoRows, "scoped users join the store's organisation")
+	users, err := own.GetAllUsers()
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	assert.ErrorIs(t, own.RemoveUserFromOrganisation(sam.UserID, otherID), sql.ErrNoRows)
+	require.NoError(t, other.RemoveUserFromOrganisation(sam.UserID, otherID))
+	assert.ErrorIs(t, other.RemoveUserFromOrganisation(sam.UserID, otherID), sql.ErrNoRows)
+
+	require.NoError(t, own.DeleteUser(user.UserID, 0), "the last membership deletes the user")
+	_, err = store.GetUserByID(user.UserID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func auckland(t *testing.T) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation("Pacific/Auckland")
+	require.NoError(t, err)
+	return location
+}
+
+// siteDate is midnight at the start of a day at the fixture site, which has the default time zone
+func siteDate(t *testing.T, year int, month time.Month, day int) time.Time {
+	t.Helper()
+	return time.Date(year, month, day, 0, 0, 0, 0, auckland(t))
+}
+
+func itoa(id int) string {
+	return strconv.Itoa(id)
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"strconv"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+)
+
+// GetSyncChanges returns the devices in service and active rooms added or changed since the cursor of an earlier sync,
+// with the IDs of those taken out of use since. The new cursor is read first, so a change made while the lists
+// are read is sent again by the next sync rather than missed.
+//
+// Devices and rooms are listed with the codes and names of their room, building and site, so a change to any of
+// those sends them again, and archiving a building or site removes everything in it.
+//
+// Every change records the ID of its transaction in ChangeXID, and the cursor is the oldest transaction ID still
+// running when it was read. A change committed after the lists are read has an ID at or above the cursor, so it is
+// sent by the next sync. Changes are sometimes sent twice, which clients expect.
+func (db *DB) GetSyncChanges(since int64) (*models.SyncChanges, error) {
+	changes := &models.SyncChanges{
+		Devices:          []models.EmergencyDevice{},
+		Rooms:            []models.Room{},
+		RemovedDeviceIDs: []int{},
+		RemovedRoomIDs:   []int{},
+	}
+
+	cursor := `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint`
+	if err := db.QueryRow(cursor).Scan(&changes.Cursor); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{strconv.FormatInt(since, 10)}
+	deviceQuery := `SELECT ` + listedDeviceColumns + `, ed.roomid, ed.updatedat` + listedDeviceJoins + `
+	WHERE ed.decommissionedat IS NULL AND r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL
+	AND ` + deviceChangedSince + db.scope(&args, organisationScope, "s.organisationid") + `
+	ORDER BY ed.emergencydeviceid`
+
+	rows, err := db.Query(deviceQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var device models.EmergencyDevice
+		if err := scanListedDevice(rows, &device, &device.RoomID, &device.UpdatedAt); err != nil {
+			return nil, err
+		}
+		changes.Devices = append(changes.Devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	args = []interface{}{strconv.FormatInt(since, 10)}
+	roomQuery := `
+	SELECT r.roomid, r.buildingid, r.roomcode, b.buildingcode, b.siteid, s.sitename, r.floorid, f.floorname, f.floorlevel, r.updatedat
+	FROM roomT r
+	JOIN floorT f ON r.floorid = f.floorid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	WHERE r.archivedat IS NULL AND b.archivedat IS NULL AND s.archivedat IS NULL
+	AND ` + roomChangedSince + db.scope(&args, organisationScope, "s.organisationid") + `
+	ORDER BY r.roomid`
+
+	roomRows, err := db.Query(roomQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer roomRows.Close()
+
+	for roomRows.Next() {
+		var room models.Room
+		err := roomRows.Scan(
+			&room.RoomID,
+			&room.BuildingID,
+			&room.RoomCode,
+			&room.BuildingCode,
+			&room.SiteID,
+			&room.SiteName,
+			&room.FloorID,
+			&room.FloorName,
+			&room.FloorLevel,
+			&room.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		changes.Rooms = append(changes.Rooms, room)
+	}
+	if err := roomRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A first sync has nothing to remove
+	if since == 0 {
+		return changes, nil
+	}
+
+	args = []interface{}{strconv.FormatInt(since, 10)}
+	removedDevices := `
+	SELECT ed.emergencydeviceid
+	FROM emergency_deviceT ed
+	JOIN roomT r ON ed.roomid = r.roomid
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	WHERE (ed.decommissionedat IS NOT NULL OR r.archivedat IS NOT NULL OR b.archivedat IS NOT NULL OR s.archivedat IS NOT NULL)
+	AND ` + deviceChangedSince + db.scope(&args, organisationScope, "s.organisationid") + `
+	ORDER BY ed.emergencydeviceid`
+	if changes.RemovedDeviceIDs, err = db.queryIDs(removedDevices, args...); err != nil {
+		return nil, err
+	}
+
+	args = []interface{}{strconv.FormatInt(since, 10)}
+	removedRooms := `
+	SELECT r.roomid
+	FROM roomT r
+	JOIN buildingT b ON r.buildingid = b.buildingid
+	JOIN siteT s ON b.siteid = s.siteid
+	WHERE (r.archivedat IS NOT NULL OR b.archivedat IS NOT NULL OR s.archivedat IS NOT NULL)
+	AND ` + roomChangedSince + db.scope(&args, organisationScope, "s.organisationid") + `
+	ORDER BY r.roomid`
+	if changes.RemovedRoomIDs, err = db.queryIDs(removedRooms, args...); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// The conditions of GetSyncChanges for a device or room that changed since the cursor in $1, itself or its location
+const (
+	roomChangedSince   = `(r.changexid >= $1::text::xid8 OR b.changexid >= $1::text::xid8 OR s.changexid >= $1::text::xid8)`
+	deviceChangedSince = `(ed.changexid >= $1::text::xid8 OR ` + roomChangedSince + `)`
+)
+
+// queryIDs returns the IDs selected by a query, never nil
+func (db *DB) queryIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A DB returned by WithTx runs every query in its transaction. Query, QueryRow, Exec and Prepare hide the
+// methods of the embedded *sql.DB so the repositories join it without knowing, and repositories that need a
+// transaction of their own start it with begin, which becomes a savepoint inside a unit of work.
+
+// conn is a transaction the repositories run their statements in, a *sql.Tx or a savepoint of one
+type conn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+	Commit() error
+	Rollback() error
+}
+
+// WithTx runs fn in one transaction, committing it when fn returns nil and rolling it back otherwise.
+// The store fn is given joins the transaction, and so do the stores it returns from ForOrganisation and WithTx.
+func (db *DB) WithTx(fn func(tx Store) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed, and also runs when fn panics
+	defer tx.Rollback()
+
+	if err := fn(&DB{DB: db.DB, tx: tx, organisationID: db.organisationID}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// begin starts the transaction of a repository method, a savepoint when the store is in a unit of work so a
+// failed method only undoes its own statements
+func (db *DB) begin() (conn, error) {
+	if db.tx == nil {
+		return db.DB.Begin()
+	}
+
+	if _, err := db.tx.Exec(`SAVEPOINT repository`); err != nil {
+		return nil, fmt.Errorf("failed to start savepoint: %w", err)
+	}
+	return &savepoint{Tx: db.tx}, nil
+}
+
+// Query runs a query in the store's transaction, if it has one
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if db.tx != nil {
+		return db.tx.Query(query, args...)
+	}
+	return db.DB.Query(query, args...)
+}
+
+// QueryRow runs a query returning one row in the store's transaction, if it has one
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	if db.tx != nil {
+		return db.tx.QueryRow(query, args...)
+	}
+	return db.DB.QueryRow(query, args...)
+}
+
+// Exec runs a statement in the store's transaction, if it has one
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	if db.tx != nil {
+		return db.tx.Exec(query, args...)
+	}
+	return db.DB.Exec(query, args...)
+}
+
+// Prepare prepares a statement in the store's transaction, if it has one
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	if db.tx != nil {
+		return db.tx.Prepare(query)
+	}
+	return db.DB.Prepare(query)
+}
+
+// savepoint is the transaction of a repository method inside a unit of work. Savepoints nest, so they can
+// share a name: releasing or rolling back to it applies to the latest one.
+type savepoint struct {
+	*sql.Tx
+	done bool
+}
+
+// Commit keeps the savepoint's changes in the enclosing transaction
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+
+	_, err := s.Tx.Exec(`RELEASE SAVEPOINT repository`)
+	return err
+}
+
+// Rollback undoes the savepoint's changes and leaves the enclosing transaction usable
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+
+	_, err := s.Tx.Exec(`ROLLBACK TO SAVEPOINT repository; RELEASE SAVEPOINT repository`)
+	return err
+}
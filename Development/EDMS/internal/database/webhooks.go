@@ -0,0 +1,346 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/models"
+	"github.com/lib/pq"
+)
+
+const webhookQuery = `SELECT WebhookID, OrganisationID, URL, Secret, EventTypes, IsActive, CreatedAt, Version FROM WebhookT`
+
+const webhookDeliveryColumns = `d.WebhookDeliveryID, d.WebhookID, d.EventID, d.EventType, d.Payload, d.Status, d.Attempts,
+	d.NextAttemptAt, d.DeliveredAt, d.CreatedAt`
+
+func scanWebhook(scanner interface{ Scan(...interface{}) error }, webhook *models.Webhook) error {
+	return scanner.Scan(
+		&webhook.WebhookID,
+		&webhook.OrganisationID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.EventTypes),
+		&webhook.IsActive,
+		&webhook.CreatedAt,
+		&webhook.Version,
+	)
+}
+
+// scanWebhookDelivery scans the webhookDeliveryColumns followed by extra columns
+func scanWebhookDelivery(scanner interface{ Scan(...interface{}) error }, delivery *models.WebhookDelivery, extra ...interface{}) error {
+	var payload []byte
+	err := scanner.Scan(append([]interface{}{
+		&delivery.WebhookDeliveryID,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.DeliveredAt,
+		&delivery.CreatedAt,
+	}, extra...)...)
+	delivery.Payload = payload
+	return err
+}
+
+// GetAllWebhooks returns the webhooks of every organisation the store sees, oldest first
+func (db *DB) GetAllWebhooks() ([]models.Webhook, error) {
+	var args []interface{}
+	query := webhookQuery + ` WHERE true` + db.scope(&args, organisationScope, "OrganisationID") + ` ORDER BY WebhookID`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		var webhook models.Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (db *DB) GetWebhookByID(webhookID int) (*models.Webhook, error) {
+	args := []interface{}{webhookID}
+	query := webhookQuery + ` WHERE WebhookID = $1` + db.scope(&args, organisationScope, "OrganisationID")
+
+	var webhook models.Webhook
+	if err := scanWebhook(db.QueryRow(query, args...), &webhook); err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// AddWebhook adds a webhook to the store's organisation and returns its ID
+func (db *DB) AddWebhook(webhook *models.Webhook) (int, error) {
+	var webhookID int
+	err := db.QueryRow(`
+	INSERT INTO WebhookT (OrganisationID, URL, Secret, EventTypes, IsActive)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING WebhookID
+	`, db.writeOrganisationID(), webhook.URL, webhook.Secret, pq.Array(webhook.EventTypes), webhook.IsActive).Scan(&webhookID)
+	if err != nil {
+		return 0, err
+	}
+
+	return webhookID, nil
+}
+
+// UpdateWebhook changes the URL, event types and whether a webhook is active, the secret stays the same
+func (db *DB) UpdateWebhook(webhook *models.Webhook) error {
+	args := []interface{}{webhook.URL, pq.Array(webhook.EventTypes), webhook.IsActive, webhook.WebhookID}
+	query := `UPDATE WebhookT SET URL = $1, EventTypes = $2, IsActive = $3 WHERE WebhookID = $4` +
+		db.scope(&args, organisationScope, "OrganisationID") + versionCheck(&args, "Version", webhook.Version)
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	existsArgs := []interface{}{webhook.WebhookID}
+	exists := `SELECT 1 FROM WebhookT WHERE WebhookID = $1` + db.scope(&existsArgs, organisationScope, "OrganisationID")
+	if err := checkVersion(db, result, webhook.Version, exists, existsArgs...); err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteWebhook deletes a webhook with its deliveries and their log
+func (db *DB) DeleteWebhook(webhookID int, version int) error {
+	args := []interface{}{webhookID}
+	query := `DELETE FROM WebhookT WHERE WebhookID = $1` + db.scope(&args, organisationScope, "OrganisationID")
+	query += versionCheck(&args, "Version", version)
+
+	existsArgs := []interface{}{webhookID}
+	exists := `SELECT 1 FROM WebhookT WHERE WebhookID = $1` + db.scope(&existsArgs, organisationScope, "OrganisationID")
+	return db.execOneVersion(version, exists, existsArgs, query, args...)
+}
+
+func (db *DB) AddWebhookEvent(event *models.WebhookEvent) (int, error) {
+	if err := db.inOrganisation(deviceScope, event.EmergencyDeviceID); err != nil {
+		return 0, err
+	}
+
+	result, err := db.Exec(`
+	INSERT INTO WebhookDeliveryT (WebhookID, EventID, EventType, Payload, NextAttemptAt)
+	SELECT w.WebhookID, $1::UUID, $2::TEXT, $3::TEXT, NOW()
+	FROM WebhookT w
+	WHERE w.IsActive AND $2::TEXT = ANY(w.EventTypes) AND w.OrganisationID = (
+		SELECT s.OrganisationID FROM Emergency_DeviceT ed
+		JOIN RoomT r ON ed.RoomID = r.RoomID
+		JOIN BuildingT b ON r.BuildingID = b.BuildingID
+		JOIN SiteT s ON b.SiteID = s.SiteID
+		WHERE ed.EmergencyDeviceID = $4)
+	`, event.EventID, event.EventType, string(event.Payload), event.EmergencyDeviceID)
+	if err != nil {
+		return 0, err
+	}
+
+	queued, err := result.RowsAffected()
+	return int(queued), err
+}
+
+// ClaimWebhookDeliveries skips deliveries another server has locked, so several servers can share the outbox.
+// Deliveries of inactive webhooks wait until the webhook is active again.
+func (db *DB) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error) {
+	args := []interface{}{now, now.Add(lease), limit}
+	query := `
+	UPDATE WebhookDeliveryT d
+	SET NextAttemptAt = $2
+	FROM WebhookT w
+	WHERE d.WebhookID = w.WebhookID AND d.WebhookDeliveryID IN (
+		SELECT WebhookDeliveryID FROM WebhookDeliveryT
+		WHERE Status = 'Pending' AND NextAttemptAt <= $1
+		AND WebhookID IN (SELECT WebhookID FROM WebhookT WHERE IsActive)` +
+		db.scope(&args, webhookScope, "WebhookID") + `
+		ORDER BY NextAttemptAt, WebhookDeliveryID
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED)
+	RETURNING ` + webhookDeliveryColumns + `, w.URL, w.Secret`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanWebhookDelivery(rows, &delivery, &delivery.URL, &delivery.Secret); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].WebhookDeliveryID < deliveries[j].WebhookDeliveryID })
+
+	return deliveries, nil
+}
+
+func (db *DB) RecordWebhookAttempt(attempt *models.WebhookAttempt, status string, nextAttemptAt sql.NullTime) error {
+	tx, err := db.begin()
+	if err != nil {
+		return err
+	}
+
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	args := []interface{}{status, nextAttemptAt, attempt.AttemptedAt, attempt.WebhookDeliveryID}
+	query := `
+	UPDATE WebhookDeliveryT
+	SET Status = $1, Attempts = Attempts + 1, NextAttemptAt = $2,
+		DeliveredAt = CASE WHEN $1 = 'Delivered' THEN $3 ELSE DeliveredAt END
+	WHERE WebhookDeliveryID = $4` + db.scope(&args, webhookScope, "WebhookID")
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO WebhookAttemptT (WebhookDeliveryID, AttemptedAt, ResponseStatus, Error, DurationMs)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING WebhookAttemptID
+	`, attempt.WebhookDeliveryID, attempt.AttemptedAt, attempt.ResponseStatus, attempt.Error, attempt.DurationMs).Scan(&attempt.WebhookAttemptID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetWebhookDeliveries returns the latest first, each with its attempts oldest first
+func (db *DB) GetWebhookDeliveries(webhookID int, limit int) ([]models.WebhookDelivery, error) {
+	args := []interface{}{webhookID, limit}
+	query := `SELECT ` + webhookDeliveryColumns + `
+	FROM WebhookDeliveryT d
+	WHERE d.WebhookID = $1` + db.scope(&args, webhookScope, "d.WebhookID") + `
+	ORDER BY d.CreatedAt DESC, d.WebhookDeliveryID DESC
+	LIMIT $2`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	byID := map[int]int{}
+	var deliveryIDs []int64
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		if err := scanWebhookDelivery(rows, &delivery); err != nil {
+			return nil, err
+		}
+		delivery.Log = []models.WebhookAttempt{}
+		byID[delivery.WebhookDeliveryID] = len(deliveries)
+		deliveryIDs = append(deliveryIDs, int64(delivery.WebhookDeliveryID))
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	attemptRows, err := db.Query(`
+	SELECT WebhookAttemptID, WebhookDeliveryID, AttemptedAt, ResponseStatus, Error, DurationMs
+	FROM WebhookAttemptT
+	WHERE WebhookDeliveryID = ANY($1)
+	ORDER BY AttemptedAt, WebhookAttemptID
+	`, pq.Array(deliveryIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer attemptRows.Close()
+
+	for attemptRows.Next() {
+		var attempt models.WebhookAttempt
+		err := attemptRows.Scan(
+			&attempt.WebhookAttemptID,
+			&attempt.WebhookDeliveryID,
+			&attempt.AttemptedAt,
+			&attempt.ResponseStatus,
+			&attempt.Error,
+			&attempt.DurationMs,
+		)
+		if err != nil {
+			return nil, err
+		}
+		delivery := &deliveries[byID[attempt.WebhookDeliveryID]]
+		delivery.Log = append(delivery.Log, attempt)
+	}
+
+	return deliveries, attemptRows.Err()
+}
+
+func (db *DB) RedeliverWebhookDelivery(deliveryID int) error {
+	args := []interface{}{deliveryID}
+	query := `
+	UPDATE WebhookDeliveryT
+	SET Status = 'Pending', Attempts = 0, NextAttemptAt = NOW()
+	WHERE WebhookDeliveryID = $1 AND Status <> 'Pending'` + db.scope(&args, webhookScope, "WebhookID")
+
+	return db.execOne(query, args...)
+}
+
+// execOne runs a statement that changes a row by its ID, sql.ErrNoRows when there was no such row
+func (db *DB) execOne(query string, args ...interface{}) error {
+	return db.execOneVersion(0, "", nil, query, args...)
+}
+
+// execOneVersion runs a statement made from a version of a row like execOne, returning ErrVersionConflict
+// when the row the exists query looks for is still there at another version
+func (db *DB) execOneVersion(version int, exists string, existsArgs []interface{}, query string, args ...interface{}) error {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersion(db, result, version, exists, existsArgs...); err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
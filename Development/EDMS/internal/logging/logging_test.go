@@ -0,0 +1,69 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/AlexGithub777/BAP---Project/Development/EDMS/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	level, err := logging.ParseLevel("debug")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelDebug, level)
+
+	level, err = logging.ParseLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, level)
+
+	_, err = logging.ParseLevel("loud")
+	assert.Error(t, err)
+}
+
+func TestNewRejectsUnknownFormat(t *testing.T) {
+	_, err := logging.New(&bytes.Buffer{}, "xml", slog.LevelInfo)
+	assert.Error(t, err)
+}
+
+func TestLoggerAddsRequestIDAndRedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "json", slog.LevelInfo)
+	require.NoError(t, err)
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	logger.InfoContext(ctx, "Password reset",
+		"user_id", 7,
+		"new_password", "Hunter2!",
+		slog.Group("headers", "Authorization", "Bearer abc", "Accept", "text/html"),
+	)
+	logger.DebugContext(ctx, "Below the level")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line), "one line is written")
+
+	assert.Equal(t, "Password reset", line["msg"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, float64(7), line["user_id"])
+	assert.Equal(t, logging.Redacted, line["new_password"])
+	headers := line["headers"].(map[string]interface{})
+	assert.Equal(t, logging.Redacted, headers["Authorization"])
+	assert.Equal(t, "text/html", headers["Accept"])
+	assert.NotContains(t, buf.String(), "Hunter2!")
+}
+
+func TestLoggerWithoutRequest(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "text", slog.LevelInfo)
+	require.NoError(t, err)
+
+	logger.With("component", "seed").Info("Seeding complete.")
+
+	assert.Contains(t, buf.String(), "component=seed")
+	assert.NotContains(t, buf.String(), "request_id")
+	assert.Equal(t, "", logging.RequestID(context.Background()))
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// CalendarFeed is a user's subscription link to the due dates of one organisation
+type CalendarFeed struct {
+	CalendarFeedID int       `json:"calendar_feed_id"`
+	UserID         int       `json:"user_id"`
+	OrganisationID int       `json:"organisation_id"`
+	TokenHash      string    `json:"-"` // SHA-256 of the token in the link, hex encoded
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// Dependent is a row under a site, building or room that is being archived
+type Dependent struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"` // Building or room code, or device serial number
+}
+
+// Dependents is what stops a site, building or room from being archived: the buildings, rooms and
+// in service devices under it. Inspections of the devices stay with them and are only counted.
+type Dependents struct {
+	Buildings   []Dependent `json:"buildings"`
+	Rooms       []Dependent `json:"rooms"`
+	Devices     []Dependent `json:"devices"`
+	Inspections int         `json:"inspections"`
+}
+
+// Empty reports whether nothing depends on the row any more
+func (d *Dependents) Empty() bool {
+	return len(d.Buildings) == 0 && len(d.Rooms) == 0 && len(d.Devices) == 0
+}
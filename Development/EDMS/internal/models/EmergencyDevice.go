@@ -12,18 +12,38 @@ type EmergencyDevice struct {
 	ExtinguisherTypeID      sql.NullInt64  `json:"extinguisher_type_id"`       // From Extinguisher_TypeT table
 	RoomID                  int            `json:"room_id"`                    // From emergency_deviceT table (FK)
 	RoomCode                string         `json:"room_code"`                  // From roomT table
+	FloorID                 int            `json:"floor_id"`                   // From roomT table
+	FloorName               string         `json:"floor_name"`                 // From floorT table
 	BuildingID              int            `json:"building_id"`                // From buildingT table
 	BuildingCode            string         `json:"building_code"`              // From buildingT table
 	SiteID                  int            `json:"site_id"`                    // From siteT table
 	SiteName                string         `json:"site_name"`                  // From siteT table
+	SiteTimeZone            string         `json:"site_time_zone"`             // From siteT table
 	SerialNumber            sql.NullString `json:"serial_number"`              // From emergency_deviceT table
 	ManufactureDate         sql.NullTime   `json:"manufacture_date"`           // From emergency_deviceT table
 	ExpireDate              sql.NullTime   `json:"expire_date"`                // Calculated
 	LastInspectionDateTime  sql.NullTime   `json:"last_inspection_datetime"`   // From emergency_deviceT table
 	NextInspectionDate      sql.NullTime   `json:"next_inspection_date"`       // Calculated
+	LastServiceDate         sql.NullTime   `json:"last_service_date"`          // From MaintenanceRecordT table
+	NextServiceDate         sql.NullTime   `json:"next_service_date"`          // Calculated
+	NextDueDate             sql.NullTime   `json:"next_due_date"`              // Calculated, earliest of inspection and service
 	Description             sql.NullString `json:"description"`                // From emergency_deviceT table
 	Size                    sql.NullString `json:"size"`                       // From emergency_deviceT table
 	Status                  sql.NullString `json:"status"`                     // From emergency_deviceT table
+	DecommissionedAt        sql.NullTime   `json:"decommissioned_at"`          // From emergency_deviceT table
+	DecommissionReason      sql.NullString `json:"decommission_reason"`        // From emergency_deviceT table
+	PredecessorDeviceID     sql.NullInt64  `json:"predecessor_device_id"`      // From emergency_deviceT table (FK)
+	SuccessorDeviceID       sql.NullInt64  `json:"successor_device_id"`        // From emergency_deviceT table (reverse FK)
+	UpdatedAt               sql.NullTime   `json:"updated_at"`                 // From emergency_deviceT table, only when syncing
+	Version                 int            `json:"version"`                    // From emergency_deviceT table, the ETag of its API representation
+}
+
+type EmergencyDeviceReplacementDto struct {
+	ExtinguisherTypeID string `json:"extinguisher_type"`
+	SerialNumber       string `json:"serial_number"`
+	ManufactureDate    string `json:"manufacture_date"`
+	Size               string `json:"size"`
+	Reason             string `json:"reason"`
 }
 
 type EmergencyDeviceDto struct {
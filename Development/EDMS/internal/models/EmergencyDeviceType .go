@@ -1,13 +1,21 @@
 package models
 
+import "database/sql"
+
 // Emergency_Device_TypeT represents the types of emergency devices
 type EmergencyDeviceType struct {
-	EmergencyDeviceTypeID   int    `json:"emergency_device_type_id"`
-	EmergencyDeviceTypeName string `json:"emergency_device_type_name"`
+	EmergencyDeviceTypeID    int           `json:"emergency_device_type_id"`
+	EmergencyDeviceTypeName  string        `json:"emergency_device_type_name"`
+	InspectionIntervalMonths int           `json:"inspection_interval_months"`
+	ServiceIntervalMonths    sql.NullInt64 `json:"service_interval_months"`
+	OrganisationID           sql.NullInt64 `json:"organisation_id"` // NULL for types shared by every organisation
+	Version                  int           `json:"version"`         // Counts the changes to the type, the ETag of its API representation
 }
 
 // Emergency_Device_TypeT represents the types of emergency devices
 type EmergencyDeviceTypeDto struct {
-	EmergencyDeviceTypeID   string `json:"emergency_device_type_id"`
-	EmergencyDeviceTypeName string `json:"emergency_device_type_name"`
+	EmergencyDeviceTypeID    string `json:"emergency_device_type_id"`
+	EmergencyDeviceTypeName  string `json:"emergency_device_type_name"`
+	InspectionIntervalMonths string `json:"inspection_interval_months"`
+	ServiceIntervalMonths    string `json:"service_interval_months"`
 }
@@ -1,7 +1,10 @@
 package models
 
+import "database/sql"
+
 // Extinguisher_TypeT represents the types of extinguishers devices
 type ExtinguisherType struct {
-	ExtinguisherTypeID   int    `json:"extinguisher_type_id"`
-	ExtinguisherTypeName string `json:"extinguisher_type_name"`
+	ExtinguisherTypeID   int           `json:"extinguisher_type_id"`
+	ExtinguisherTypeName string        `json:"extinguisher_type_name"`
+	OrganisationID       sql.NullInt64 `json:"organisation_id"` // NULL for types shared by every organisation
 }
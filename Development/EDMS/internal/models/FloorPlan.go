@@ -0,0 +1,38 @@
+package models
+
+import "database/sql"
+
+// FloorPlanT represents the plan image of a floor in a building
+type FloorPlan struct {
+	FloorPlanID  int          `json:"floor_plan_id"`
+	FloorID      int          `json:"floor_id"`
+	BuildingID   int          `json:"building_id"`
+	BuildingCode string       `json:"building_code"`
+	SiteID       int          `json:"site_id"`
+	FloorLabel   string       `json:"floor_label"` // The floor's name
+	FloorLevel   int          `json:"floor_level"`
+	ImagePath    string       `json:"image_path"`
+	CreatedAt    sql.NullTime `json:"created_at"`
+}
+
+// FloorPlanPinT represents a room or device positioned on a floor plan
+type FloorPlanPin struct {
+	FloorPlanPinID          int            `json:"floor_plan_pin_id"`
+	FloorPlanID             int            `json:"floor_plan_id"`
+	RoomID                  sql.NullInt64  `json:"room_id"`
+	RoomCode                sql.NullString `json:"room_code"`
+	EmergencyDeviceID       sql.NullInt64  `json:"emergency_device_id"`
+	EmergencyDeviceTypeName sql.NullString `json:"emergency_device_type_name"`
+	SerialNumber            sql.NullString `json:"serial_number"`
+	X                       float64        `json:"x"`
+	Y                       float64        `json:"y"`
+	Status                  sql.NullString `json:"status"`        // Device status, or the most urgent status of the devices in the room
+	StatusColour            string         `json:"status_colour"` // Calculated
+}
+
+type FloorPlanPinDto struct {
+	RoomID            string  `json:"room_id"`
+	EmergencyDeviceID string  `json:"emergency_device_id"`
+	X                 float64 `json:"x"`
+	Y                 float64 `json:"y"`
+}
@@ -11,6 +11,7 @@ type Inspection struct {
 	InspectorName                 string         `json:"inspector_name"`
 	InspectionDateTime            sql.NullTime   `json:"inspection_datetime"`
 	CreatedAt                     sql.NullTime   `json:"created_at"`
+	SiteTimeZone                  string         `json:"site_time_zone"` // Time zone of the device's site, the times are in
 	IsConspicuous                 sql.NullBool   `json:"is_conspicuous"`
 	IsAccessible                  sql.NullBool   `json:"is_accessible"`
 	IsAssignedLocation            sql.NullBool   `json:"is_assigned_location"`
@@ -26,4 +27,48 @@ type Inspection struct {
 	WorkOrderRequired             sql.NullBool   `json:"work_order_required"`
 	InspectionStatus              string         `json:"inspection_status"`
 	Notes                         sql.NullString `json:"notes"`
+	ClientID                      sql.NullString `json:"client_id"` // UUID from the offline client that captured it
+}
+
+// ChecklistItem is a question of the inspection checklist, Key is the JSON name of its answer
+type ChecklistItem struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+}
+
+// InspectionChecklist lists the checklist questions in the order of the inspection form
+var InspectionChecklist = []ChecklistItem{
+	{Key: "is_conspicuous", Label: "Is Conspicuous"},
+	{Key: "is_accessible", Label: "Is Accessible"},
+	{Key: "is_assigned_location", Label: "Is in Assigned Location"},
+	{Key: "is_sign_visible", Label: "Is Sign Visible"},
+	{Key: "is_anti_tamper_device_intact", Label: "Is Anti-Tamper Device Intact"},
+	{Key: "is_support_bracket_secure", Label: "Is Support Bracket Secure"},
+	{Key: "are_operating_instructions_clear", Label: "Are Operating Instructions Clear"},
+	{Key: "is_maintenance_tag_attached", Label: "Is Maintenance Tag Attached"},
+	{Key: "is_no_external_damage", Label: "Is No External Damage"},
+	{Key: "is_charge_gauge_normal", Label: "Is Charge Gauge Normal"},
+	{Key: "are_maintenance_records_complete", Label: "Are Maintenance Records Complete"},
+	{Key: "is_replaced", Label: "Is Replaced"},
+	{Key: "work_order_required", Label: "Work Order Required"},
+}
+
+// ChecklistAnswer returns the answer to a checklist question by its key, nil for an unknown key
+func (i *Inspection) ChecklistAnswer(key string) *sql.NullBool {
+	answers := map[string]*sql.NullBool{
+		"is_conspicuous":                   &i.IsConspicuous,
+		"is_accessible":                    &i.IsAccessible,
+		"is_assigned_location":             &i.IsAssignedLocation,
+		"is_sign_visible":                  &i.IsSignVisible,
+		"is_anti_tamper_device_intact":     &i.IsAntiTamperDeviceIntact,
+		"is_support_bracket_secure":        &i.IsSupportBracketSecure,
+		"are_operating_instructions_clear": &i.AreOperatingInstructionsClear,
+		"is_maintenance_tag_attached":      &i.IsMaintenanceTagAttached,
+		"is_no_external_damage":            &i.IsNoExternalDamage,
+		"is_charge_gauge_normal":           &i.IsChargeGaugeNormal,
+		"are_maintenance_records_complete": &i.AreMaintenanceRecordsComplete,
+		"is_replaced":                      &i.IsReplaced,
+		"work_order_required":              &i.WorkOrderRequired,
+	}
+	return answers[key]
 }
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Progress of a device in an inspection round
+const (
+	RoundDeviceRemaining = "Remaining"
+	RoundDeviceInspected = "Inspected"
+	RoundDeviceSkipped   = "Skipped"
+)
+
+// InspectionRound is the devices due in a building, or a whole site, for a period, assigned to an inspector
+type InspectionRound struct {
+	InspectionRoundID int                     `json:"inspection_round_id"`
+	SiteID            int                     `json:"site_id"`
+	SiteName          string                  `json:"site_name"`      // From siteT table
+	BuildingID        sql.NullInt64           `json:"building_id"`    // Null for a round of the whole site
+	BuildingCode      sql.NullString          `json:"building_code"`  // From buildingT table
+	UserID            sql.NullInt64           `json:"user_id"`        // Null until the round is assigned
+	InspectorName     sql.NullString          `json:"inspector_name"` // From userT table
+	PeriodStart       time.Time               `json:"period_start"`   // First day of the period
+	PeriodEnd         time.Time               `json:"period_end"`     // Last day of the period
+	CreatedAt         sql.NullTime            `json:"created_at"`
+	Inspected         int                     `json:"inspected"` // Calculated
+	Skipped           int                     `json:"skipped"`   // Calculated
+	Remaining         int                     `json:"remaining"` // Calculated
+	Devices           []InspectionRoundDevice `json:"devices"`   // Ordered by room, only when reading one round
+	DeviceIDs         []int                   `json:"-"`         // The devices of a new round
+}
+
+// InspectionRoundDevice is a device of an inspection round and its progress
+type InspectionRoundDevice struct {
+	EmergencyDeviceID           int            `json:"emergency_device_id"`
+	EmergencyDeviceTypeName     string         `json:"emergency_device_type_name"`
+	SerialNumber                sql.NullString `json:"serial_number"`
+	RoomID                      int            `json:"room_id"`
+	RoomCode                    string         `json:"room_code"`
+	FloorName                   string         `json:"floor_name"`
+	BuildingCode                string         `json:"building_code"`
+	Status                      string         `json:"status"`
+	SkipReason                  sql.NullString `json:"skip_reason"`
+	EmergencyDeviceInspectionID sql.NullInt64  `json:"emergency_device_inspection_id"`
+}
+
+// InspectionRoundDto is the request to plan a round, dates are YYYY-MM-DD
+type InspectionRoundDto struct {
+	SiteID      string `json:"site_id"`
+	BuildingID  string `json:"building_id"`
+	UserID      string `json:"user_id"`
+	PeriodStart string `json:"period_start"`
+	PeriodEnd   string `json:"period_end"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "database/sql"
+
+// Service types recorded in MaintenanceRecordT
+const (
+	ServiceTypeService       = "Service"
+	ServiceTypeRecharge      = "Recharge"
+	ServiceTypePressureTest  = "Pressure Test"
+	ServiceTypeRefurbishment = "Refurbishment"
+)
+
+// MaintenanceRecord represents contractor servicing of a device, separate from the routine inspections
+type MaintenanceRecord struct {
+	MaintenanceRecordID int                     `json:"maintenance_record_id"`
+	EmergencyDeviceID   int                     `json:"emergency_device_id"`
+	SerialNumber        sql.NullString          `json:"serial_number"`
+	UserID              int                     `json:"user_id"`
+	RecordedBy          string                  `json:"recorded_by"`
+	ServiceType         string                  `json:"service_type"`
+	ServiceDate         sql.NullTime            `json:"service_date"`
+	Provider            string                  `json:"provider"`
+	CertificateNumber   sql.NullString          `json:"certificate_number"`
+	Cost                sql.NullFloat64         `json:"cost"`
+	NextServiceDate     sql.NullTime            `json:"next_service_date"`
+	Notes               sql.NullString          `json:"notes"`
+	CreatedAt           sql.NullTime            `json:"created_at"`
+	Attachments         []MaintenanceAttachment `json:"attachments"`
+}
+
+// MaintenanceAttachment represents a certificate or report uploaded with a maintenance record
+type MaintenanceAttachment struct {
+	MaintenanceAttachmentID int          `json:"maintenance_attachment_id"`
+	MaintenanceRecordID     int          `json:"maintenance_record_id"`
+	FileName                string       `json:"file_name"`
+	FilePath                string       `json:"file_path"`
+	UploadedAt              sql.NullTime `json:"uploaded_at"`
+}
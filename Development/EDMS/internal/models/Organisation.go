@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DefaultOrganisationID is the organisation existing data was moved into, and that unscoped writes use
+const DefaultOrganisationID = 1
+
+// OrganisationT represents the organisations that own sites, users can belong to several
+type Organisation struct {
+	OrganisationID int       `json:"organisation_id"`
+	Name           string    `json:"name"`
+	CreatedAt      time.Time `json:"created_at"`
+}
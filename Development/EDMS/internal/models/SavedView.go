@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// DeviceListColumns are the columns of the device list a saved view can show and sort by, named like the
+// fields of EmergencyDevice, in the order the dashboard shows them
+var DeviceListColumns = []string{
+	"emergency_device_type_name",
+	"extinguisher_type_name",
+	"site_name",
+	"building_code",
+	"room_code",
+	"serial_number",
+	"manufacture_date",
+	"expire_date",
+	"last_inspection_datetime",
+	"next_inspection_date",
+	"next_due_date",
+	"size",
+	"status",
+}
+
+// ViewFilters are the device list filters of a saved view, empty filters match every device
+type ViewFilters struct {
+	SiteID                  string `json:"site_id,omitempty"`
+	BuildingCode            string `json:"building_code,omitempty"`
+	FloorID                 string `json:"floor_id,omitempty"`
+	RoomCode                string `json:"room_code,omitempty"`
+	EmergencyDeviceTypeName string `json:"emergency_device_type_name,omitempty"`
+	Status                  string `json:"status,omitempty"`
+}
+
+// SavedView is a named device list view of a user, its filters, sort and visible columns.
+// The owner can share it with other users of the organisation, who can use it but not change it.
+type SavedView struct {
+	SavedViewID    int         `json:"saved_view_id"`
+	UserID         int         `json:"user_id"`    // Owner
+	OwnerName      string      `json:"owner_name"` // From userT table
+	OrganisationID int         `json:"organisation_id"`
+	Name           string      `json:"name"`
+	Filters        ViewFilters `json:"filters"`
+	SortColumn     string      `json:"sort_column"` // One of DeviceListColumns, empty for the list's own order
+	SortDescending bool        `json:"sort_descending"`
+	Columns        []string    `json:"columns"`     // Visible columns in order, from DeviceListColumns
+	SharedWith     []int       `json:"shared_with"` // Users the view is shared with, besides the owner
+	IsDefault      bool        `json:"is_default"`  // Whether it is the default view of the user it was read for
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	Version        int         `json:"version"` // Counts the changes to the view, the ETag of its API representation
+}
+
+type SavedViewDto struct {
+	Name           string      `json:"name"`
+	Filters        ViewFilters `json:"filters"`
+	SortColumn     string      `json:"sort_column"`
+	SortDescending bool        `json:"sort_descending"`
+	Columns        []string    `json:"columns"` // Every column when not given
+	SharedWith     []int       `json:"shared_with"`
+	IsDefault      *bool       `json:"is_default"` // Make it the user's default view, or stop it being so; unchanged when not given
+}
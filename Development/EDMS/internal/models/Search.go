@@ -0,0 +1,33 @@
+package models
+
+import "database/sql"
+
+// Kinds of search result
+const (
+	SearchDevice     = "device"
+	SearchRoom       = "room"
+	SearchInspection = "inspection"
+)
+
+// SearchResult is a device, room or inspection that matched a search, by its best matching field
+type SearchResult struct {
+	Kind              string        `json:"kind"`
+	ID                int           `json:"id"`                  // Of the device, room or inspection
+	EmergencyDeviceID sql.NullInt64 `json:"emergency_device_id"` // The device, or the device inspected; null for rooms
+	RoomID            int           `json:"room_id"`
+	Title             string        `json:"title"`     // Device type and serial number, or building and room code
+	Location          string        `json:"location"`  // Site, building and room
+	Field             string        `json:"field"`     // Name of the field that matched, such as serial_number or notes
+	Value             string        `json:"value"`     // Value of the field that matched
+	Highlight         string        `json:"highlight"` // Value as HTML with the matched text in <mark> elements
+	Rank              float64       `json:"rank"`      // Higher is a better match
+}
+
+// SearchResults are one page of the results of a search, best match first
+type SearchResults struct {
+	Query   string         `json:"query"`
+	Total   int            `json:"total"` // Results on every page
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+	Results []SearchResult `json:"results"`
+}
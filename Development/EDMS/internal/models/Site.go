@@ -8,4 +8,7 @@ type Site struct {
 	SiteName         string         `json:"site_name"`
 	SiteAddress      string         `json:"site_address"`
 	SiteMapImagePath sql.NullString `json:"site_map_image_path"`
+	TimeZone         string         `json:"time_zone"` // IANA time zone, like Pacific/Auckland
+	OrganisationID   int            `json:"organisation_id"`
+	Version          int            `json:"version"` // Counts the changes to the site, the ETag of its API representation
 }
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// InspectionTypeCount is the number of inspections of one device type and extinguisher type, and how many failed
+type InspectionTypeCount struct {
+	EmergencyDeviceTypeName string         `json:"emergency_device_type_name"`
+	ExtinguisherTypeName    sql.NullString `json:"extinguisher_type_name"` // Null for devices that are not extinguishers
+	Inspections             int            `json:"inspections"`
+	Failed                  int            `json:"failed"`
+}
+
+// InspectorMonthCount is the number of inspections an inspector carried out in a month at the sites' time
+type InspectorMonthCount struct {
+	UserID        int    `json:"user_id"`
+	InspectorName string `json:"inspector_name"` // From userT table
+	Month         string `json:"month"`          // YYYY-MM
+	Inspections   int    `json:"inspections"`
+	Failed        int    `json:"failed"`
+}
+
+// InspectionCounts are the inspections of a period counted by type and by inspector
+type InspectionCounts struct {
+	ByType      []InspectionTypeCount `json:"by_type"`      // Ordered by device type, then extinguisher type
+	ByInspector []InspectorMonthCount `json:"by_inspector"` // Ordered by month, then inspector
+}
+
+// Stats are the compliance KPIs of the dashboard
+type Stats struct {
+	GeneratedAt            time.Time             `json:"generated_at"`
+	From                   string                `json:"from"` // First day of the inspection period, YYYY-MM-DD
+	To                     string                `json:"to"`   // Last day of the inspection period, YYYY-MM-DD
+	Compliance             ComplianceStats       `json:"compliance"`
+	Overdue                OverdueStats          `json:"overdue"`
+	FailureByDeviceType    []FailureRate         `json:"failure_by_device_type"`
+	FailureByExtinguisher  []FailureRate         `json:"failure_by_extinguisher_type"`
+	InspectionsByInspector []InspectorMonthCount `json:"inspections_by_inspector"`
+	ExpiringByQuarter      []QuarterCount        `json:"expiring_by_quarter"`
+}
+
+// ComplianceStats is how many in-service devices are compliant, in total and per site
+type ComplianceStats struct {
+	Devices    int              `json:"devices"`
+	Compliant  int              `json:"compliant"`
+	Percentage float64          `json:"percentage"` // Of the devices, 100 when there are none
+	Sites      []SiteCompliance `json:"sites"`      // Ordered by site name
+}
+
+// SiteCompliance is how many in-service devices of a site are compliant, in total and per building
+type SiteCompliance struct {
+	SiteName   string               `json:"site_name"`
+	Devices    int                  `json:"devices"`
+	Compliant  int                  `json:"compliant"`
+	Percentage float64              `json:"percentage"`
+	Buildings  []BuildingCompliance `json:"buildings"` // Ordered by building code
+}
+
+// BuildingCompliance is how many in-service devices of a building are compliant
+type BuildingCompliance struct {
+	BuildingCode string  `json:"building_code"`
+	Devices      int     `json:"devices"`
+	Compliant    int     `json:"compliant"`
+	Percentage   float64 `json:"percentage"`
+}
+
+// OverdueStats is how many in-service devices are past their due date, by how long
+type OverdueStats struct {
+	Devices        int             `json:"devices"`         // Overdue devices, not counting those never inspected
+	NeverInspected int             `json:"never_inspected"` // Devices without an inspection, which are due straight away
+	Buckets        []OverdueBucket `json:"buckets"`
+}
+
+// OverdueBucket is how many devices are overdue by a range of days, due today counts as 0 days
+type OverdueBucket struct {
+	Label   string `json:"label"`
+	MinDays int    `json:"min_days"`
+	MaxDays int    `json:"max_days"` // -1 for the open ended last bucket
+	Devices int    `json:"devices"`
+}
+
+// FailureRate is the share of the inspections of a device type or extinguisher type that failed
+type FailureRate struct {
+	Name        string  `json:"name"`
+	Inspections int     `json:"inspections"`
+	Failed      int     `json:"failed"`
+	Percentage  float64 `json:"percentage"`
+}
+
+// QuarterCount is how many in-service devices expire in a quarter
+type QuarterCount struct {
+	Quarter string `json:"quarter"` // YYYY-Qn
+	Devices int    `json:"devices"`
+}
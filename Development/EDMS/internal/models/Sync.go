@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Results of a synced inspection
+const (
+	SyncCreated   = "created"
+	SyncDuplicate = "duplicate" // Already recorded by an earlier sync
+	SyncRejected  = "rejected"
+)
+
+// SyncInspection is an inspection captured offline by a mobile inspector
+type SyncInspection struct {
+	ClientID          string          `json:"client_id"` // UUID generated by the client
+	EmergencyDeviceID int             `json:"emergency_device_id"`
+	CapturedAt        time.Time       `json:"captured_at"` // When the inspection was carried out, RFC 3339 with a time zone
+	InspectionStatus  string          `json:"inspection_status"`
+	Notes             string          `json:"notes"`
+	Checklist         map[string]bool `json:"checklist"` // Answers by checklist item key, unanswered items are recorded as false
+}
+
+// SyncResult is the outcome of one synced inspection
+type SyncResult struct {
+	ClientID     string `json:"client_id"`
+	Status       string `json:"status"`
+	InspectionID int    `json:"inspection_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// SyncChanges are the devices and rooms that changed since a sync cursor
+type SyncChanges struct {
+	Cursor           int64             `json:"cursor,string"`      // Where the changes were read up to, the cursor of the next sync
+	Devices          []EmergencyDevice `json:"devices"`            // Devices in service that were added or changed
+	Rooms            []Room            `json:"rooms"`              // Active rooms that were added or changed
+	RemovedDeviceIDs []int             `json:"removed_device_ids"` // Devices decommissioned since the cursor
+	RemovedRoomIDs   []int             `json:"removed_room_ids"`   // Rooms archived since the cursor
+}
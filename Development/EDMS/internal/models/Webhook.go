@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Event types webhooks can subscribe to
+const (
+	EventDeviceCreated       = "device.created"
+	EventDeviceStatusChanged = "device.status_changed"
+	EventInspectionCreated   = "inspection.created"
+	EventInspectionFailed    = "inspection.failed"
+	EventWorkOrderCreated    = "workorder.created" // An inspection that requires a work order
+)
+
+// WebhookEventTypes lists every event type in the order they are documented
+var WebhookEventTypes = []string{
+	EventDeviceCreated,
+	EventDeviceStatusChanged,
+	EventInspectionCreated,
+	EventInspectionFailed,
+	EventWorkOrderCreated,
+}
+
+// Status of a webhook delivery
+const (
+	DeliveryPending   = "Pending"
+	DeliveryDelivered = "Delivered"
+	DeliveryFailed    = "Failed" // Given up after the last retry
+)
+
+// Webhook is a URL an organisation's events are sent to
+type Webhook struct {
+	WebhookID      int       `json:"webhook_id"`
+	OrganisationID int       `json:"organisation_id"`
+	URL            string    `json:"url"`
+	Secret         string    `json:"-"` // Key of the HMAC-SHA256 signature, only shown when the webhook is added
+	EventTypes     []string  `json:"event_types"`
+	IsActive       bool      `json:"is_active"`
+	CreatedAt      time.Time `json:"created_at"`
+	Version        int       `json:"version"` // Counts the changes to the webhook, the ETag of its API representation
+}
+
+type WebhookDto struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	IsActive   *bool    `json:"is_active"` // Active when not given
+}
+
+// WebhookEvent is something that happened to a device, queued for the webhooks subscribed to it
+type WebhookEvent struct {
+	EventID           string // UUID, the same for every webhook the event is sent to
+	EventType         string
+	EmergencyDeviceID int    // The device the event is about, its organisation's webhooks receive it
+	Payload           []byte // JSON body sent to the webhooks
+}
+
+// WebhookDelivery is an event queued in the outbox for one webhook
+type WebhookDelivery struct {
+	WebhookDeliveryID int              `json:"webhook_delivery_id"`
+	WebhookID         int              `json:"webhook_id"`
+	EventID           string           `json:"event_id"`
+	EventType         string           `json:"event_type"`
+	Payload           json.RawMessage  `json:"payload"`
+	Status            string           `json:"status"`
+	Attempts          int              `json:"attempts"`
+	NextAttemptAt     sql.NullTime     `json:"next_attempt_at"` // Null once the delivery is finished
+	DeliveredAt       sql.NullTime     `json:"delivered_at"`
+	CreatedAt         time.Time        `json:"created_at"`
+	URL               string           `json:"-"`             // From WebhookT table, when claimed for sending
+	Secret            string           `json:"-"`             // From WebhookT table, when claimed for sending
+	Log               []WebhookAttempt `json:"log,omitempty"` // Oldest first, only when reading a webhook's deliveries
+}
+
+// WebhookAttempt is one attempt to send a delivery, the delivery log
+type WebhookAttempt struct {
+	WebhookAttemptID  int            `json:"webhook_attempt_id"`
+	WebhookDeliveryID int            `json:"webhook_delivery_id"`
+	AttemptedAt       time.Time      `json:"attempted_at"`
+	ResponseStatus    sql.NullInt64  `json:"response_status"` // Null when the receiver could not be reached
+	Error             sql.NullString `json:"error"`
+	DurationMs        int            `json:"duration_ms"`
+}
@@ -1,11 +1,17 @@
 package models
 
+import "database/sql"
+
 // BuildingT represents the buildings in each site
 type Building struct {
-	BuildingID   int    `json:"building_id"`
-	SiteID       int    `json:"site_id"`
-	BuildingCode string `json:"building_code"`
-	SiteName     string `json:"site_name"`
+	BuildingID   int             `json:"building_id"`
+	SiteID       int             `json:"site_id"`
+	BuildingCode string          `json:"building_code"`
+	SiteName     string          `json:"site_name"`
+	MapX         sql.NullFloat64 `json:"map_x"`       // Fraction of the site map image width
+	MapY         sql.NullFloat64 `json:"map_y"`       // Fraction of the site map image height
+	MapPolygon   sql.NullString  `json:"map_polygon"` // JSON array of [x, y] points outlining the building
+	Version      int             `json:"version"`     // Counts the changes to the building, the ETag of its API representation
 }
 
 type BuildingDto struct {
@@ -13,4 +19,7 @@ type BuildingDto struct {
 	SiteID       string `json:"site_id"`
 	BuildingCode string `json:"building_code"`
 	SiteName     string `json:"site_name"`
+	MapX         string `json:"map_x"`
+	MapY         string `json:"map_y"`
+	MapPolygon   string `json:"map_polygon"`
 }
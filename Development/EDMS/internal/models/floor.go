@@ -0,0 +1,22 @@
+package models
+
+import "database/sql"
+
+// FloorT represents the floors of a building, FloorLevel orders them with 0 the ground floor
+type Floor struct {
+	FloorID      int            `json:"floor_id"`
+	BuildingID   int            `json:"building_id"`
+	BuildingCode string         `json:"building_code"`
+	SiteID       int            `json:"site_id"`
+	FloorName    string         `json:"floor_name"`
+	FloorLevel   int            `json:"floor_level"`
+	FloorPlanID  sql.NullInt64  `json:"floor_plan_id"` // The floor's plan image, if it has one
+	ImagePath    sql.NullString `json:"image_path"`    // Calculated from the floor plan
+	Version      int            `json:"version"`       // Counts the changes to the floor, the ETag of its API representation
+}
+
+type FloorDto struct {
+	BuildingID string `json:"building_id"`
+	FloorName  string `json:"floor_name"`
+	FloorLevel string `json:"floor_level"`
+}
@@ -1,18 +1,26 @@
 package models
 
+import "database/sql"
+
 // RoomT represents rooms in each building
 type Room struct {
-	RoomID       int    `json:"room_id"`
-	BuildingID   int    `json:"building_id"`
-	RoomCode     string `json:"room_code"`
-	BuildingCode string `json:"building_code"`
-	SiteName     string `json:"site_name"`
-	SiteID       int    `json:"site_id"`
+	RoomID       int          `json:"room_id"`
+	BuildingID   int          `json:"building_id"`
+	FloorID      int          `json:"floor_id"` // 0 when adding puts the room on the building's default floor
+	FloorName    string       `json:"floor_name"`
+	FloorLevel   int          `json:"floor_level"`
+	RoomCode     string       `json:"room_code"`
+	BuildingCode string       `json:"building_code"`
+	SiteName     string       `json:"site_name"`
+	SiteID       int          `json:"site_id"`
+	UpdatedAt    sql.NullTime `json:"updated_at"` // Only when syncing
+	Version      int          `json:"version"`    // Counts the changes to the room, the ETag of its API representation
 }
 
 type RoomDto struct {
 	RoomID       string `json:"room_id"`
 	BuildingID   string `json:"building_id"`
+	FloorID      string `json:"floor_id"`
 	RoomCode     string `json:"room_code"`
 	BuildingCode string `json:"building_code"`
 	SiteName     string `json:"site_name"`
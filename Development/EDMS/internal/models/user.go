@@ -8,6 +8,7 @@ type User struct {
 	Role          string `json:"role"`
 	DefaultAdmin  bool   `json:"default_admin"`
 	CurrentUserID int    `json:"current_user_id"`
+	Version       int    `json:"version"` // Counts the changes to the user, the ETag of its API representation
 }
 
 type UserDto struct {
@@ -51,6 +51,11 @@ func (tr *TemplateRenderer) Render(w io.Writer, name string, data interface{}, c
 	return tr.templates.ExecuteTemplate(w, name, data)
 }
 
+// Count returns how many page templates are loaded
+func (tr *TemplateRenderer) Count() int {
+	return len(tr.templates.Templates())
+}
+
 func GetLocalIP() net.IP {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
@@ -165,7 +165,7 @@ fetch("/api/user")
                 hideDelete
                     ? ""
                     : `<button class="btn btn-danger p-2 delete-button" 
-                            onclick="showDeleteModal(${user.user_id}, 'user', '${user.username}', '${currentUserIdNumber}')" 
+                            onclick="showDeleteModal(${user.user_id}, 'user', '${user.username}', ${user.version}, '${currentUserIdNumber}')" 
                             data-id="${user.user_id}" 
                             title="Delete User">
                         <svg xmlns="http://www.w3.org/2000/svg" width="20" height="20" viewBox="0 0 24 24" fill="none" 
@@ -200,11 +200,10 @@ export async function editUser(userId) {
     const email = row.find("td[data-label=Email]").text();
     const role = row.find("td[data-label=Role]").text();
 
-    const default_admin = await fetch(`/api/user/${username}`)
-        .then((response) => response.json())
-        .then((user) => {
-            return user.default_admin.toString();
-        });
+    const user = await fetch(`/api/user/${username}`).then((response) =>
+        response.json()
+    );
+    const default_admin = user.default_admin.toString();
 
     // Fill in the form with the user data
     $("#editUserForm")[0].reset();
@@ -214,6 +213,8 @@ export async function editUser(userId) {
     $("#editUserForm input[name=email]").val(email);
     $("#editUserForm select[name=role]").val(role);
     $("#editUserForm input[name=default_admin]").val(default_admin);
+    // The edit only applies to the version of the user shown
+    $("#editUserForm").data("version", user.version);
 
     // Set the form action to the update endpoint for this user
     $("#editUserForm").attr("action", `/api/user/${id}`);
@@ -252,6 +253,7 @@ export async function editUser(userId) {
                 method: "PUT",
                 headers: {
                     "Content-Type": "application/json",
+                    "If-Match": `"${$("#editUserForm").data("version")}"`,
                 },
                 body: JSON.stringify(jsonData),
             })
@@ -285,6 +287,7 @@ fetch("/api/site")
                 <tr>
                     <td data-label="Site Name">${site.site_name}</td>
                     <td data-label="Site Address">${site.site_address}</td>
+                    <td data-label="Time Zone">${site.time_zone}</td>
                     <td data-label="Actions">
                         <div class="btn-group">
                             <button class="btn btn-warning p-2 edit-button" 
@@ -298,7 +301,7 @@ fetch("/api/site")
                                 </svg>
                             </button>
                             <button class="btn btn-danger p-2 delete-button" 
-                                    onclick="showDeleteModal(${site.site_id}, 'site', '${site.site_name}')" 
+                                    onclick="showDeleteModal(${site.site_id}, 'site', '${site.site_name}', ${site.version})" 
                                     title="Delete Site">
                                 <svg xmlns="http://www.w3.org/2000/svg" width="20" height="20" viewBox="0 0 24 24" fill="none" 
                                     stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
@@ -339,7 +342,7 @@ fetch("/api/building")
                         </svg>
                     </button>
                     <button class="btn btn-danger p-2 delete-button" 
-                            onclick="showDeleteModal(${building.building_id}, 'building', '${building.building_code}')" 
+                            onclick="showDeleteModal(${building.building_id}, 'building', '${building.building_code}', ${building.version})" 
                             title="Delete Building">
                         <svg xmlns="http://www.w3.org/2000/svg" width="20" height="20" viewBox="0 0 24 24" fill="none" 
                             stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
@@ -379,10 +382,23 @@ export function editBuilding(buildingId) {
             // Populate the form with the data
             document.getElementById("editBuildingID").value =
                 building.building_id;
+            $("#editBuildingForm").data("version", building.version);
             document.getElementById("editBuildingCode").value =
                 building.building_code;
             document.getElementById("editBuildingSite").value =
                 building.site_id;
+            document.getElementById("editBuildingMapX").value = building.map_x
+                .Valid
+                ? building.map_x.Float64
+                : "";
+            document.getElementById("editBuildingMapY").value = building.map_y
+                .Valid
+                ? building.map_y.Float64
+                : "";
+            document.getElementById("editBuildingMapPolygon").value = building
+                .map_polygon.Valid
+                ? building.map_polygon.String
+                : "";
         })
         .catch((error) => {
             console.error("Fetch error: ", error);
@@ -420,6 +436,9 @@ export function editBuilding(buildingId) {
                     method: "PUT",
                     headers: {
                         "Content-Type": "application/json",
+                        "If-Match": `"${$("#editBuildingForm").data(
+                            "version"
+                        )}"`,
                     },
                     body: JSON.stringify(jsonData),
                 }
@@ -453,6 +472,7 @@ fetch("/api/room")
             (room) => `
         <tr>
             <td data-label="Room Code">${room.room_code}</td>
+            <td data-label="Floor">${room.floor_name}</td>
             <td data-label="Building Code">${room.building_code}</td>
             <td data-label="Site Name">${room.site_name}</td>
             <td>
@@ -466,7 +486,7 @@ fetch("/api/room")
                         </svg>
                     </button>
                     <button class="btn btn-danger p-2 delete-button" 
-                            onclick="showDeleteModal(${room.room_id}, 'room', '${room.room_code}')" 
+                            onclick="showDeleteModal(${room.room_id}, 'room', '${room.room_code}', ${room.version})" 
                             title="Delete Room">
                         <svg xmlns="http://www.w3.org/2000/svg" width="20" height="20" viewBox="0 0 24 24" fill="none" 
                             stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
@@ -487,6 +507,33 @@ fetch("/api/room")
         $("#rooms-table tbody").html(roomRows.join(""));
     });
 
+// Populate a floor select with the floors of a building, lowest floor first.
+// Leaving it on the default option keeps the room on its floor, or puts a new room on the ground floor.
+function populateFloors(floorSelect, buildingId, selectedFloorId) {
+    floorSelect.html(`<option value="">Default floor</option>`);
+    if (!buildingId || buildingId === "0") {
+        return;
+    }
+
+    fetch(`/api/floor?buildingId=${buildingId}`)
+        .then((response) => response.json())
+        .then((floors) => {
+            const floorOptions = floors.map(
+                (floor) =>
+                    `<option value="${floor.floor_id}" ${
+                        floor.floor_id === selectedFloorId ? "selected" : ""
+                    }>${floor.floor_name}</option>`
+            );
+            floorSelect.html(
+                `<option value="">Default floor</option>` +
+                    floorOptions.join("")
+            );
+        })
+        .catch((error) => {
+            console.error("Error loading floors:", error);
+        });
+}
+
 export function editRoom(roomId) {
     const id = roomId;
 
@@ -551,8 +598,15 @@ export function editRoom(roomId) {
                         );
                     }
 
+                    populateFloors(
+                        $("#editRoomFloor"),
+                        room.building_id,
+                        room.floor_id
+                    );
+
                     // Set other form fields after dropdowns are populated
                     document.getElementById("editRoomID").value = room.room_id;
+                    $("#editRoomForm").data("version", room.version);
                     document.getElementById("editRoomCode").value =
                         room.room_code;
                 });
@@ -623,6 +677,9 @@ export function editRoom(roomId) {
             this.classList.remove("is-invalid");
             editRoomForm.classList.remove("was-validated");
         }
+
+        // The floors belong to the building
+        populateFloors($("#editRoomFloor"), this.value);
     });
 
     // Add change event listener to site select to reset building validation
@@ -679,6 +736,7 @@ export function editRoom(roomId) {
                 method: "PUT",
                 headers: {
                     "Content-Type": "application/json",
+                    "If-Match": `"${$("#editRoomForm").data("version")}"`,
                 },
                 body: JSON.stringify(jsonData),
             })
@@ -721,7 +779,7 @@ fetch("/api/emergency-device-type")
                         </svg>
                     </button>
                     <button class="btn btn-danger p-2 delete-button" 
-                            onclick="showDeleteModal(${deviceType.emergency_device_type_id}, 'emergency-device-type', '<br>${deviceType.emergency_device_type_name}')" 
+                            onclick="showDeleteModal(${deviceType.emergency_device_type_id}, 'emergency-device-type', '<br>${deviceType.emergency_device_type_name}', ${deviceType.version})" 
                             data-id="${deviceType.emergency_device_type_id}" 
                             title="Delete Device Type">
                         <svg xmlns="http://www.w3.org/2000/svg" width="20" height="20" viewBox="0 0 24 24" fill="none" 
@@ -754,6 +812,13 @@ export function editDeviceType(deviceTypeId) {
             //Populate the form with the data
             document.getElementById("editDeviceTypeName").value =
                 data.emergency_device_type_name;
+            $("#editDeviceTypeForm").data("version", data.version);
+            document.getElementById("editInspectionInterval").value =
+                data.inspection_interval_months;
+            document.getElementById("editServiceInterval").value = data
+                .service_interval_months.Valid
+                ? data.service_interval_months.Int64
+                : "";
         })
         .catch((error) => {
             console.error("Fetch error: ", error);
@@ -790,6 +855,9 @@ export function editDeviceType(deviceTypeId) {
                     method: "PUT",
                     headers: {
                         "Content-Type": "application/json",
+                        "If-Match": `"${$("#editDeviceTypeForm").data(
+                            "version"
+                        )}"`,
                     },
                     body: JSON.stringify(jsonData),
                 }
@@ -833,10 +901,14 @@ export function editSite(siteId) {
         .then((site) => {
             // Fill in the form with the site data
             $("#editSiteForm input[name=editSiteID]").val(site.site_id);
+            $("#editSiteForm input[name=editSiteVersion]").val(site.version);
             $("#editSiteForm input[name=editSiteName]").val(site.site_name);
             $("#editSiteForm input[name=editSiteAddress]").val(
                 site.site_address
             );
+            $("#editSiteForm input[name=editSiteTimeZone]").val(
+                site.time_zone
+            );
 
             // Set the form action to the update endpoint for this site
             $("#editSiteForm").attr("action", `/api/site/${site.site_id}`);
@@ -956,6 +1028,14 @@ export function AddRoom() {
     $(".buildingInput").html(
         `<option value="" disabled selected>Select a Building</option>`
     );
+    populateFloors($("#addRoomFloor"));
+
+    // The floors belong to the building
+    $("#addRoomBuildingCode")
+        .off("change.floors")
+        .on("change.floors", function () {
+            populateFloors($("#addRoomFloor"), this.value);
+        });
 
     // populate the site select dropdown
     populateDropdown(
@@ -18,6 +18,8 @@ initializeInspectionForm();
 // Leaflet map setup
 let map;
 
+let floorControl;
+
 function initializeMap(containerId, options = {}) {
     const defaultOptions = {
         crs: L.CRS.Simple,
@@ -26,46 +28,153 @@ function initializeMap(containerId, options = {}) {
     map = L.map(containerId, { ...defaultOptions, ...options });
 }
 
-function createEitTaradaleMap() {
-    const svgDimensions = { width: 561.568, height: 962.941 };
-    const minCoordinates = { x: 128.009, y: 82.331 };
-    const imageUrl = "/static/site_maps/EIT_Taradale.svg";
-    const bounds = [
-        [0, 0],
-        [svgDimensions.height, svgDimensions.width],
-    ];
+// Remove the site map, building markers, floor plan and pins from the map
+function clearMapLayers() {
+    map.eachLayer((layer) => {
+        if (
+            layer instanceof L.ImageOverlay ||
+            layer instanceof L.Rectangle ||
+            layer instanceof L.CircleMarker
+        ) {
+            map.removeLayer(layer);
+        }
+    });
 
-    L.imageOverlay(imageUrl, bounds).addTo(map);
+    if (floorControl) {
+        floorControl.remove();
+        floorControl = null;
+    }
+}
 
-    fetchBuildingsData()
-        .then((data) => renderBuildings(data, svgDimensions, minCoordinates))
-        .catch((error) =>
-            console.error("Error fetching building data:", error)
-        );
+// Show the floor plans of a building, or fall back to the site map if it has none
+function updateMapForBuilding(buildingId) {
+    const siteId = document.getElementById("siteFilter").value;
 
-    map.fitBounds(bounds);
+    if (!buildingId || buildingId === "All Buildings") {
+        updateMapForSite(siteId);
+        return;
+    }
+
+    fetch(`/api/floor-plan?buildingId=${buildingId}`)
+        .then((response) => response.json())
+        .then((floorPlans) => {
+            if (!Array.isArray(floorPlans) || floorPlans.length === 0) {
+                updateMapForSite(siteId);
+                return;
+            }
+            showMap();
+            renderFloorPlan(floorPlans, floorPlans[0].floor_plan_id);
+        })
+        .catch((error) => console.error("Error fetching floor plans:", error));
 }
 
-function fetchBuildingsData() {
-    return fetch("static/assets/buildings.json").then((response) =>
-        response.json()
+function renderFloorPlan(floorPlans, floorPlanId) {
+    const floorPlan = floorPlans.find(
+        (plan) => plan.floor_plan_id === floorPlanId
     );
+
+    const image = new Image();
+    image.src = floorPlan.image_path;
+    image.onload = function () {
+        const imgWidth = this.width;
+        const imgHeight = this.height;
+        const bounds = [
+            [0, 0],
+            [imgHeight, imgWidth],
+        ];
+
+        clearMapLayers();
+        L.imageOverlay(floorPlan.image_path, bounds).addTo(map);
+        map.fitBounds(bounds);
+
+        // Only show the floor selector if the building has more than one floor
+        if (floorPlans.length > 1) {
+            floorControl = createFloorControl(floorPlans, floorPlanId);
+            floorControl.addTo(map);
+        }
+
+        fetch(`/api/floor-plan/${floorPlanId}/pins`)
+            .then((response) => response.json())
+            .then((pins) =>
+                pins.forEach((pin) => renderPin(pin, imgWidth, imgHeight))
+            )
+            .catch((error) =>
+                console.error("Error fetching floor plan pins:", error)
+            );
+    };
 }
 
-function renderBuildings(data, svgDimensions, minCoordinates) {
-    data.buildings.forEach((building) => {
-        const x = building.coordinates.x - minCoordinates.x;
-        const y =
-            svgDimensions.height - (building.coordinates.y - minCoordinates.y);
+// Pins are stored as fractions of the image measured from the top left corner
+function renderPin(pin, imgWidth, imgHeight) {
+    const latLng = [imgHeight * (1 - pin.y), imgWidth * pin.x];
+    const label = pin.emergency_device_id.Valid
+        ? `${pin.emergency_device_type_name.String} ${
+              pin.serial_number.String || ""
+          } (${pin.room_code.String})`
+        : `Room ${pin.room_code.String}`;
+    const status = pin.status.Valid ? pin.status.String : "No devices";
+
+    L.circleMarker(latLng, {
+        radius: pin.emergency_device_id.Valid ? 6 : 10,
+        color: pin.status_colour,
+        fillColor: pin.status_colour,
+        fillOpacity: 0.8,
+    })
+        .bindTooltip(`${label}<br>${status}`)
+        .addTo(map);
+}
 
-        const rectangle = L.rectangle([
-            [y - 19, x],
-            [y, x + 19],
-        ]).addTo(map);
+function createFloorControl(floorPlans, selectedFloorPlanId) {
+    const control = L.control({ position: "topright" });
+    control.onAdd = () => {
+        const select = L.DomUtil.create("select", "form-select form-select-sm");
+        floorPlans.forEach((plan) => {
+            const option = document.createElement("option");
+            option.value = plan.floor_plan_id;
+            option.text = plan.floor_label;
+            option.selected = plan.floor_plan_id === selectedFloorPlanId;
+            select.add(option);
+        });
+        L.DomEvent.disableClickPropagation(select);
+        select.addEventListener("change", () =>
+            renderFloorPlan(floorPlans, parseInt(select.value))
+        );
+        return select;
+    };
+    return control;
+}
 
-        rectangle.on("click", () => {
-            filterByBuilding(building.name);
+// Building positions are stored as fractions of the site map measured from the top left corner
+function renderBuildings(buildings, imgWidth, imgHeight) {
+    // Marker size relative to the map so it scales with the image resolution
+    const halfSize = imgWidth * 0.017;
+
+    buildings.forEach((building) => {
+        let shape;
+        if (building.map_polygon.Valid) {
+            const points = JSON.parse(building.map_polygon.String).map(
+                ([x, y]) => [imgHeight * (1 - y), imgWidth * x]
+            );
+            shape = L.polygon(points);
+        } else if (building.map_x.Valid && building.map_y.Valid) {
+            const x = imgWidth * building.map_x.Float64;
+            const y = imgHeight * (1 - building.map_y.Float64);
+            shape = L.rectangle([
+                [y - halfSize, x - halfSize],
+                [y + halfSize, x + halfSize],
+            ]);
+        } else {
+            // Buildings without a position are not shown on the map
+            return;
+        }
+
+        shape.bindTooltip(building.building_code).addTo(map);
+        shape.on("click", () => {
+            filterByBuilding(building.building_code);
             filterByRoom();
+            updateMapForBuilding(
+                document.getElementById("buildingFilter").value
+            );
         });
     });
 }
@@ -154,6 +263,7 @@ function setupBuildingFilter() {
         filterByBuilding();
         clearRoomFilter();
         clearTableBody();
+        updateMapForBuilding(document.getElementById("buildingFilter").value);
     });
 }
 
@@ -202,23 +312,6 @@ function filterBySite() {
         return;
     }
 
-    if (siteId === "1") {
-        // Hard coded - EIT Taradale should always also be id = 1, as its the first site inserted into the database (see seed.go)
-        // Clear the map layers
-        map.eachLayer((layer) => {
-            if (
-                layer instanceof L.ImageOverlay ||
-                layer instanceof L.Rectangle
-            ) {
-                map.removeLayer(layer);
-            }
-        });
-        showMap();
-        createEitTaradaleMap();
-        loadDevicesAndUpdateTable("", siteId);
-        return;
-    }
-
     loadDevicesAndUpdateTable("", siteId);
     clearRoomFilter();
     updateMapForSite(siteId);
@@ -226,10 +319,9 @@ function filterBySite() {
 
 function filterByBuilding(buildingCode) {
     const buildingFilter = document.getElementById("buildingFilter");
-    var siteId;
+    const siteId = document.getElementById("siteFilter").value;
 
     if (buildingCode) {
-        siteId = "1";
         // Loop through `buildingFilter` options to select the one with matching text
         for (const option of buildingFilter.options) {
             if (option.text === buildingCode) {
@@ -240,7 +332,6 @@ function filterByBuilding(buildingCode) {
     } else {
         // If `buildingCode` is not provided, use the selected dropdown value
         buildingCode = buildingFilter.selectedOptions[0].text;
-        siteId = document.getElementById("siteFilter").value;
     }
 
     // Fetch devices based on `buildingCode` and `siteId`
@@ -309,17 +400,20 @@ function updateMapForSite(siteId) {
                     [imgHeight, imgWidth],
                 ];
 
-                map.eachLayer((layer) => {
-                    if (
-                        layer instanceof L.ImageOverlay ||
-                        layer instanceof L.Rectangle
-                    ) {
-                        map.removeLayer(layer);
-                    }
-                });
+                clearMapLayers();
 
                 L.imageOverlay(imageUrl, newBounds).addTo(map);
                 map.fitBounds(newBounds);
+
+                // Place the site's buildings on the map
+                fetch(`/api/building?siteId=${siteId}`)
+                    .then((response) => response.json())
+                    .then((buildings) =>
+                        renderBuildings(buildings || [], imgWidth, imgHeight)
+                    )
+                    .catch((error) =>
+                        console.error("Error fetching building data:", error)
+                    );
             };
         })
         .catch((error) => console.error("Error updating map:", error));
@@ -630,8 +724,37 @@ document.getElementById("rowsPerPage").addEventListener("change", (e) => {
     updateTable();
 });
 
-// Initial fetch without filtering
-loadDevicesAndUpdateTable();
+// Load the devices of a saved view, the server applies its filters and sort
+async function loadSavedView(view) {
+    try {
+        const response = await fetch(
+            `/api/emergency-device?view_id=${view.saved_view_id}`
+        );
+        if (!response.ok) {
+            throw new Error(`HTTP error! status: ${response.status}`);
+        }
+        const devices = await response.json();
+        allDevices = devices;
+        filteredDevices = devices;
+    } catch (err) {
+        console.error("Failed to load saved view:", err);
+        loadDevicesAndUpdateTable();
+        return;
+    }
+
+    if (view.filters.status) {
+        document.getElementById("statusFilter").value = view.filters.status;
+        activeFilters.status = view.filters.status;
+    }
+    updateTable();
+}
+
+// Initial fetch, on the user's default saved view if they have one
+if (window.defaultView) {
+    loadSavedView(window.defaultView);
+} else {
+    loadDevicesAndUpdateTable();
+}
 
 document.addEventListener("DOMContentLoaded", async function () {
     if (role === "Admin") {
@@ -790,7 +913,7 @@ export function getActionButtons(device) {
                 </svg>
             </button>
             <button class="btn btn-danger p-2 ml-2" 
-                    onclick="showDeleteModal(${device.emergency_device_id},'emergency-device', '<br>${device.emergency_device_type_name} - Serial Number: ${device.serial_number.String}')"
+                    onclick="showDeleteModal(${device.emergency_device_id},'emergency-device', '<br>${device.emergency_device_type_name} - Serial Number: ${device.serial_number.String}', ${device.version})"
                     title="Delete Device">
                 <svg xmlns="http://www.w3.org/2000/svg" width="20" height="20" viewBox="0 0 24 24" fill="none" 
                     stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round">
@@ -1101,6 +1224,8 @@ function editDevice(deviceId) {
                 .then((response) => response.json())
                 .then((data) => {
                     // Populate the form with the data
+                    document.getElementById("editDeviceForm").dataset.version =
+                        data.version;
                     document.getElementById(
                         "editEmergencyDeviceTypeInput"
                     ).value = data.emergency_device_type_id;
@@ -1627,6 +1752,7 @@ document.addEventListener("DOMContentLoaded", async function () {
                         method: "PUT",
                         headers: {
                             "Content-Type": "application/json",
+                            "If-Match": `"${editDeviceForm.dataset.version}"`,
                         },
                         body: JSON.stringify(jsonData),
                     }
@@ -99,11 +99,19 @@ export function populateDropdown(
         .catch((error) => console.error("Error:", error));
 }
 
-export function showDeleteModal(id, entityType, entityName, currentUserId) {
+export function showDeleteModal(
+    id,
+    entityType,
+    entityName,
+    version,
+    currentUserId
+) {
     const deleteModal = document.getElementById("deleteModal");
     const deleteForm = document.getElementById("deleteForm");
     const currentUserIdInput = document.getElementById("deleteCurrentUserID");
     const deleteIdInput = document.getElementById("deleteId");
+    const reasonGroup = document.getElementById("deleteReasonGroup");
+    const reasonInput = document.getElementById("deleteReason");
     const modalBody = deleteModal.querySelector(".modal-body p");
     const deleteButton = deleteModal.querySelector(".modal-footer .btn-danger");
 
@@ -118,9 +126,43 @@ export function showDeleteModal(id, entityType, entityName, currentUserId) {
         // Format and capitalize the entityType
         const formattedEntityType = formatEntityType(entityType);
 
+        // Devices are decommissioned and locations archived, both keep their history
+        const isDevice = entityType === "emergency-device";
+        const archivable = ["site", "building", "room"].includes(entityType);
+        const action = isDevice ? "Decommission" : archivable ? "Archive" : "Delete";
+
         // Update modal text
-        modalBody.innerHTML = `Are you sure you want to delete ${formattedEntityType}: ${entityName}?`;
-        deleteButton.textContent = `Delete ${formattedEntityType}`;
+        modalBody.innerHTML = `Are you sure you want to ${action.toLowerCase()} ${formattedEntityType}: ${entityName}?`;
+        deleteButton.textContent = `${action} ${formattedEntityType}`;
+        deleteButton.disabled = false;
+
+        // Locations can only be archived once nothing is left under them, show what is in the way
+        if (archivable) {
+            fetch(`/api/${entityType}/${id}/dependents`)
+                .then((response) => response.json())
+                .then((dependents) => {
+                    const blocking = [
+                        [dependents.buildings?.length, "building(s)"],
+                        [dependents.rooms?.length, "room(s)"],
+                        [dependents.devices?.length, "device(s)"],
+                    ].filter(([count]) => count > 0);
+                    if (blocking.length === 0) {
+                        return;
+                    }
+
+                    const list = blocking
+                        .map(([count, name]) => `${count} ${name}`)
+                        .join(", ");
+                    modalBody.textContent = `${formattedEntityType} ${entityName} still has ${list} with ${dependents.inspections} inspection(s). Move or archive them before archiving it.`;
+                    deleteButton.disabled = true;
+                })
+                .catch((error) => console.error("Error:", error));
+        }
+
+        // A reason is required to decommission a device and optional when archiving
+        reasonInput.value = "";
+        reasonInput.required = isDevice;
+        reasonGroup.classList.toggle("d-none", !isDevice && !archivable);
 
         // if the current user id is passed, add to delete form action
         if (currentUserId) {
@@ -132,6 +174,9 @@ export function showDeleteModal(id, entityType, entityName, currentUserId) {
         deleteIdInput.value = id;
         currentUserIdInput.value = currentUserId;
 
+        // Only the version of the row the admin saw is deleted
+        deleteForm.dataset.version = version;
+
         // Show the modal
         const modal = new bootstrap.Modal(deleteModal);
         modal.show();
@@ -148,11 +193,18 @@ document
     .addEventListener("submit", function (event) {
         event.preventDefault();
 
-        fetch(this.action, {
+        // Pass the decommission or archive reason as a query parameter
+        const url = new URL(this.action, window.location.origin);
+        const reason = document.getElementById("deleteReason").value.trim();
+        if (reason) {
+            url.searchParams.set("reason", reason);
+        }
+
+        fetch(url, {
             method: "DELETE",
             headers: {
                 "Content-Type": "application/json",
-                // Add any other necessary headers here
+                "If-Match": `"${this.dataset.version}"`,
             },
         })
             .then((response) => response.json())
@@ -213,6 +213,30 @@ export async function generateNotifications() {
                     );
                 }
             }
+
+            // Contractor servicing is scheduled separately from inspections
+            if (
+                !notificationMap.has(device.emergency_device_id) &&
+                device.next_service_date.Valid
+            ) {
+                const serviceDate = new Date(device.next_service_date.Time);
+                if (serviceDate <= currentDate) {
+                    updateDeviceNotification(
+                        device,
+                        "Service Due",
+                        calculateDaysOverdue(device.next_service_date.Time)
+                    );
+                } else if (serviceDate <= thirtyDaysFromNow) {
+                    const daysUntil = Math.ceil(
+                        (serviceDate - currentDate) / (1000 * 60 * 60 * 24)
+                    );
+                    updateDeviceNotification(
+                        device,
+                        "Service Due Soon",
+                        daysUntil
+                    );
+                }
+            }
         }
     });
 
@@ -226,6 +250,8 @@ export async function generateNotifications() {
         "Inspection Due": 2,
         "Expiring Soon": 3,
         "Inspection Due Soon": 4,
+        "Service Due": 5,
+        "Service Due Soon": 6,
     };
 
     notifications.sort((a, b) => {
@@ -332,6 +358,17 @@ export function generateNotificationHTML(notifications) {
                     '<i class="text-warning fa-solid fa-exclamation-triangle"></i>';
                 text = `Inspection Due (In ${days} days)`;
                 break;
+            case "Service Due":
+                badgeClass = "bg-danger text-light";
+                icon = '<i class="text-danger fa fa-exclamation-circle"></i>';
+                text = `Service Due (${days} days ago)`;
+                break;
+            case "Service Due Soon":
+                badgeClass = "bg-warning text-black";
+                icon =
+                    '<i class="text-warning fa-solid fa-exclamation-triangle"></i>';
+                text = `Service Due (In ${days} days)`;
+                break;
         }
 
         return { badgeClass, icon, text };